@@ -0,0 +1,61 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/observability"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// newAlertsCommand create the command group for the Prometheus alert rules of frp-provisioner-manager
+func newAlertsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alerts",
+		Short: "Manage the Prometheus alert rules for the metrics exported by frp-provisioner-manager",
+	}
+	cmd.AddCommand(newAlertsGenerateCommand())
+	return cmd
+}
+
+// newAlertsGenerateCommand create the command generating the SLO burn-rate PrometheusRule manifest
+func newAlertsGenerateCommand() *cobra.Command {
+	var output string
+	opts := &observability.SLOOptions{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate the PrometheusRule manifest with multi-window burn-rate alerts for the tunnel availability SLO",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			data, err := observability.MarshalSLORule(opts)
+			if err != nil {
+				return fmt.Errorf("unable generate alert rules, got: %w", err)
+			}
+			if output == "" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			return os.WriteFile(output, data, 0644)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Is the file to write the PrometheusRule manifest to, defaults to stdout.")
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/annotate"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newAnnotateCommand create the command applying and removing frp annotations across the services
+// matching a selector, it's used to roll out platform-wide policy changes.
+func newAnnotateCommand() *cobra.Command {
+	opts := &annotate.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "annotate",
+		Short: "Set or remove frp annotations on the services matching a selector and report the diff of every service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			restConfig, err := ctrl.GetConfig()
+			if err != nil {
+				return fmt.Errorf("unable get kubeconfig, got: %w", err)
+			}
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				return err
+			}
+			cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes client, got: %w", err)
+			}
+			report, err := annotate.Run(cmd.Context(), cli, opts, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			if failed := report.Failed(); failed != 0 {
+				return fmt.Errorf("unable annotate %d of %d services", failed, len(report.Changes))
+			}
+			return nil
+		},
+	}
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/conformance"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newConformanceCommand create the command running the conformance checks against the frps of a FrpServer,
+// it qualifies alternative frps implementations before services are scheduled on them.
+func newConformanceCommand() *cobra.Command {
+	var serverName string
+
+	cmd := &cobra.Command{
+		Use:   "conformance",
+		Short: "Run the login, proxy, data-plane and heartbeat conformance checks against the frps of a FrpServer",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if serverName == "" {
+				return errors.New("--server should not be empty")
+			}
+			restConfig, err := ctrl.GetConfig()
+			if err != nil {
+				return fmt.Errorf("unable get kubeconfig, got: %w", err)
+			}
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes client, got: %w", err)
+			}
+			server := &v1beta1.FrpServer{}
+			if err := cli.Get(cmd.Context(), client.ObjectKey{Name: serverName}, server); err != nil {
+				return fmt.Errorf("unable get frpserver '%s', got: %w", serverName, err)
+			}
+			creds, err := (&credentials.Resolver{}).Resolve(cmd.Context(), cli, server)
+			if err != nil {
+				return fmt.Errorf("unable resolve frp credentials of frpserver '%s', got: %w", serverName, err)
+			}
+			report := conformance.Run(cmd.Context(), server, creds)
+			if err := report.Write(cmd.OutOrStdout()); err != nil {
+				return err
+			}
+			if failed := report.Failed(); len(failed) != 0 {
+				return fmt.Errorf("frp server '%s' failed the conformance checks %v", serverName, failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&serverName, "server", serverName, "Is the name of the FrpServer whose frps is checked.")
+	return cmd
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/convert"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// newConvertCommand create the command group converting existing frp configurations to frp-provisioner resources
+func newConvertCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert",
+		Short: "Convert existing frp configurations to frp-provisioner resources",
+	}
+	cmd.AddCommand(newConvertFrpsCommand())
+	return cmd
+}
+
+// newConvertFrpsCommand create the command converting frps configuration files to FrpServer manifests
+func newConvertFrpsCommand() *cobra.Command {
+	var output string
+	opts := &convert.FrpsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "frps FILE...",
+		Short: "Convert frps configuration files to FrpServer manifests, fields which need manual attention are marked with TODO comments",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Name != "" && len(args) > 1 {
+				return fmt.Errorf("--name can only be used with a single configuration file")
+			}
+			results := make([]*convert.Result, 0, len(args))
+			for _, path := range args {
+				result, err := convert.LoadFrpsConfig(path, opts)
+				if err != nil {
+					return err
+				}
+				results = append(results, result)
+			}
+			data, err := convert.Marshal(results)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			return os.WriteFile(output, data, 0644)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Is the file to write the FrpServer manifests to, defaults to stdout.")
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
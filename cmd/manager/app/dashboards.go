@@ -0,0 +1,58 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/observability"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// newDashboardsCommand create the command group for the Grafana dashboards of frp-provisioner-manager
+func newDashboardsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dashboards",
+		Short: "Manage the Grafana dashboards for the metrics exported by frp-provisioner-manager",
+	}
+	cmd.AddCommand(newDashboardsGenerateCommand())
+	return cmd
+}
+
+// newDashboardsGenerateCommand create the command generating the Grafana dashboard JSON
+func newDashboardsGenerateCommand() *cobra.Command {
+	var output string
+	opts := &observability.DashboardOptions{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate the Grafana dashboard JSON wired to the metrics exported by this build",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := observability.MarshalDashboard(opts)
+			if err != nil {
+				return fmt.Errorf("unable generate dashboard, got: %w", err)
+			}
+			if output == "" {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), string(data))
+				return err
+			}
+			return os.WriteFile(output, append(data, '\n'), 0644)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Is the file to write the dashboard to, defaults to stdout.")
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
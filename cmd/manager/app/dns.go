@@ -0,0 +1,92 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/dns"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"os"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newDNSCommand create the command group for the in-cluster DNS helpers of frp-provisioner-manager
+func newDNSCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Manage the in-cluster DNS entries for the http proxies published by frp-provisioner-manager",
+	}
+	cmd.AddCommand(newDNSGenerateCommand())
+	return cmd
+}
+
+// newDNSGenerateCommand create the command generating the CoreDNS snippet mapping the published
+// subdomains back to the service ClusterIPs
+func newDNSGenerateCommand() *cobra.Command {
+	var output string
+	opts := &dns.HijackOptions{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate the CoreDNS snippet resolving the published http subdomains to the services inside the cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			restConfig, err := ctrl.GetConfig()
+			if err != nil {
+				return fmt.Errorf("unable get kubeconfig, got: %w", err)
+			}
+			scheme := runtime.NewScheme()
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				return err
+			}
+			cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes client, got: %w", err)
+			}
+			serviceList := &v1.ServiceList{}
+			if err := cli.List(cmd.Context(), serviceList, client.InNamespace(opts.Namespace)); err != nil {
+				return fmt.Errorf("unable get service list, got: %w", err)
+			}
+			serverList := &v1beta1.FrpServerList{}
+			if err := cli.List(cmd.Context(), serverList); err != nil {
+				return fmt.Errorf("unable get frpserver list, got: %w", err)
+			}
+			claimList := &v1beta1.FrpServerClaimList{}
+			if err := cli.List(cmd.Context(), claimList, client.InNamespace(opts.Namespace)); err != nil {
+				return fmt.Errorf("unable get frpserverclaim list, got: %w", err)
+			}
+			data := dns.Corefile(opts, dns.Entries(opts, serviceList.Items, serverList.Items, claimList.Items))
+			if output == "" {
+				_, err = fmt.Fprint(cmd.OutOrStdout(), data)
+				return err
+			}
+			return os.WriteFile(output, []byte(data), 0644)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", output, "Is the file to write the CoreDNS snippet to, defaults to stdout.")
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"github.com/frp-sigs/frp-provisioner/pkg/frpcinit"
+	"github.com/spf13/cobra"
+)
+
+// newFrpcInitCommand create the command run by the init container of the frp client pods, it writes the rendered
+// frpc config with the credentials the manager serves to the pod.
+func newFrpcInitCommand() *cobra.Command {
+	opts := &frpcinit.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:    "frpc-init",
+		Short:  "Write the rendered frpc config of a frp client pod with the credentials served by the manager",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			return frpcinit.Run(cmd.Context(), opts)
+		},
+	}
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/gc"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+// newGCCommand create the command removing the leftovers of crashed runs of frp-provisioner-manager, e.g. the
+// temp files holding the transport tls material of a login
+func newGCCommand() *cobra.Command {
+	var temp bool
+	opts := &gc.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove the leftovers of crashed frp-provisioner-manager runs, e.g. the cert, key and ca temp files of logins",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !temp {
+				return errors.New("nothing to collect, select the leftovers with --temp")
+			}
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			pruned, err := gc.PruneTempFiles(opts)
+			for _, path := range pruned {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s%s\n", path, lo.Ternary(opts.DryRun, " (dry run)", ""))
+			}
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&temp, "temp", temp, "Removes the cert, key and ca temp files older than --ttl.")
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/install"
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newInstallCommand create the command installing frp-provisioner-manager from the manifests embedded
+// into the binary, it's meant for proofs of concept without kustomize.
+func newInstallCommand() *cobra.Command {
+	opts := &install.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Apply the embedded CRDs, RBAC, webhook configurations and manager Deployment to the current cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			if opts.DryRun {
+				return install.Run(cmd.Context(), nil, opts, cmd.OutOrStdout())
+			}
+			restConfig, err := ctrl.GetConfig()
+			if err != nil {
+				return fmt.Errorf("unable get kubeconfig, got: %w", err)
+			}
+			cli, err := client.New(restConfig, client.Options{})
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes client, got: %w", err)
+			}
+			return install.Run(cmd.Context(), cli, opts, cmd.OutOrStdout())
+		},
+	}
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -33,6 +33,8 @@ const defaultConfigFile = "/etc/manager/config.yaml"
 type ManagerFlags struct {
 	ConfigFile  string
 	ShowVersion bool
+	// WatchConfig reloads the log level, the pod template and the scheduler when the config file changes
+	WatchConfig bool
 }
 
 // Validate Verify that the structure meets the requirements
@@ -56,9 +58,10 @@ func (f *ManagerFlags) SetDefaults() {
 func (f *ManagerFlags) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&f.ShowVersion, "version", f.ShowVersion, "Print version information and exit.")
 	fs.StringVar(&f.ConfigFile, "config", f.ConfigFile, "The Server will load its initial configuration from this file.")
+	fs.BoolVar(&f.WatchConfig, "watch-config", f.WatchConfig, "Reload the log level, the pod template and the scheduler when the config file changes.")
 }
 
 // NewManagerFlags A new NewManagerFlags structure will be created
 func NewManagerFlags() *ManagerFlags {
-	return &ManagerFlags{}
+	return &ManagerFlags{WatchConfig: true}
 }
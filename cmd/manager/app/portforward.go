@@ -0,0 +1,61 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/portforward"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newPortForwardCommand create the command opening a temporary stcp tunnel to a service port, the remote side
+// reaches the service through a frpc visitor without access to the cluster.
+func newPortForwardCommand() *cobra.Command {
+	opts := &portforward.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "port-forward",
+		Short: "Create an expiring stcp tunnel to a service port and print the frpc command of its visitor",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			restConfig, err := ctrl.GetConfig()
+			if err != nil {
+				return fmt.Errorf("unable get kubeconfig, got: %w", err)
+			}
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				return err
+			}
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes client, got: %w", err)
+			}
+			return portforward.Run(cmd.Context(), cli, opts, cmd.OutOrStdout())
+		},
+	}
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
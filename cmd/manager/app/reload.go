@@ -0,0 +1,107 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/cmd/manager/app/options"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"time"
+)
+
+// configReloadDelay coalesces the events of a config file update, a mounted ConfigMap is replaced by
+// swapping a symlink which fires several events
+const configReloadDelay = time.Second
+
+// watchConfigFile reloads the config file once it changes until ctx is done. The file is loaded like at
+// startup, the command line flags still take precedence, and the options which can change at runtime are
+// applied once the new config is valid. An invalid config is rejected and the current config is kept.
+// reloaded is called with the applied options, the services they change are reconciled again.
+func watchConfigFile(ctx context.Context, filename string, args []string, cfg *config.Configuration,
+	reloaded func(ctx context.Context, applied []string) error) {
+	logger := ctrl.LoggerFrom(ctx).WithName("config-watcher")
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error(err, "unable create config file watcher, the config file is not reloaded")
+		return
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+	// the directory is watched, the file of a mounted ConfigMap is replaced rather than written
+	if err := watcher.Add(filepath.Dir(filename)); err != nil {
+		logger.Error(err, "unable watch config file, the config file is not reloaded", "file", filename)
+		return
+	}
+	current, err := os.ReadFile(filename)
+	if err != nil {
+		logger.Error(err, "unable read config file", "file", filename)
+	}
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-watcher.Errors:
+			logger.Error(err, "config file watcher failed", "file", filename)
+		case <-watcher.Events:
+			reload = time.After(configReloadDelay)
+		case <-reload:
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				logger.Error(err, "unable read config file, keeping the current config", "file", filename)
+				continue
+			}
+			if bytes.Equal(data, current) {
+				continue
+			}
+			current = data
+			next, err := loadConfig(filename, args)
+			if err != nil {
+				logger.Error(err, "rejected invalid config file, keeping the current config", "file", filename)
+				continue
+			}
+			applied, restart := cfg.Reload(next)
+			logger.Info("reloaded config file", "file", filename, "applied", applied)
+			if err := reloaded(ctx, applied); err != nil {
+				logger.Error(err, "unable reconcile services with the reloaded options, they're applied on the next change of each service")
+			}
+			if len(restart) != 0 {
+				logger.Info("changed options take effect once the manager restarts", "options", restart)
+			}
+		}
+	}
+}
+
+// loadConfig loads and validates the config file, the options set by the command line flags override it
+func loadConfig(filename string, args []string) (*config.Configuration, error) {
+	cfg := config.NewConfiguration()
+	cfg.SetDefaults()
+	if err := options.LoadConfigFile(filename, cfg); err != nil {
+		return nil, fmt.Errorf("config file %s contains errors: %w", filename, err)
+	}
+	if err := options.FlagPrecedence(args, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config file is incorrect: %w", err)
+	}
+	return cfg, nil
+}
@@ -46,6 +46,7 @@ func NewManagerCommand(baseCtx context.Context) *cobra.Command {
 		Use:                component,
 		Short:              shortDescribe,
 		DisableFlagParsing: true,
+		Args:               cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx, cancel := context.WithCancel(baseCtx)
 			defer cancel()
@@ -91,6 +92,9 @@ func NewManagerCommand(baseCtx context.Context) *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("cannot create frp-provisioner server: %v", err)
 			}
+			if managerFlags.WatchConfig {
+				go watchConfigFile(ctx, managerFlags.ConfigFile, args, cfg, srv.ConfigReloaded)
+			}
 			return srv.Start(ctx)
 		},
 	}
@@ -100,5 +104,8 @@ func NewManagerCommand(baseCtx context.Context) *cobra.Command {
 	cfg.AddFlags(cleanFlagSet)
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 	cmd.Flags().AddFlagSet(cleanFlagSet) // In order to --help can display content
+	cmd.AddCommand(newDashboardsCommand(), newAlertsCommand(), newDNSCommand(), newConvertCommand(), newSoakCommand(),
+		newAnnotateCommand(), newInstallCommand(), newConformanceCommand(), newGCCommand(), newSupportBundleCommand(),
+		newPortForwardCommand(), newFrpcInitCommand())
 	return cmd
 }
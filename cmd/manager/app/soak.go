@@ -0,0 +1,42 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"github.com/frp-sigs/frp-provisioner/pkg/soak"
+	"github.com/spf13/cobra"
+)
+
+// newSoakCommand create the hidden command running the in-process frp client soak test, it is
+// used to catch leaks before releases and to size the resources of the manager.
+func newSoakCommand() *cobra.Command {
+	opts := &soak.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:    "soak",
+		Short:  "Cycle an in-process frp client with synthetic proxies against a test frps and report resource growth",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			_, err := soak.Run(cmd.Context(), opts, cmd.OutOrStdout())
+			return err
+		},
+	}
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package app
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/supportbundle"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"os"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newSupportBundleCommand create the command gathering the state of the installation into a tarball for bug
+// reports, the secrets are redacted before anything is written
+func newSupportBundleCommand() *cobra.Command {
+	opts := &supportbundle.Options{}
+	opts.SetDefaults()
+
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Gather the manager logs, the sanitized objects, the metrics, the frps connectivity and the version into a tarball",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			restConfig, err := ctrl.GetConfig()
+			if err != nil {
+				return fmt.Errorf("unable get kubeconfig, got: %w", err)
+			}
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				return err
+			}
+			if err := v1beta1.AddToScheme(scheme); err != nil {
+				return err
+			}
+			cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes client, got: %w", err)
+			}
+			clientset, err := kubernetes.NewForConfig(restConfig)
+			if err != nil {
+				return fmt.Errorf("unable create kubernetes clientset, got: %w", err)
+			}
+			f, err := os.OpenFile(opts.Output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return fmt.Errorf("unable create support bundle '%s', got: %w", opts.Output, err)
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			collector := &supportbundle.Collector{Client: cli, Pods: clientset.CoreV1(), Options: opts}
+			if err := collector.Collect(cmd.Context(), f); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "support bundle written to %s\n", opts.Output)
+			return f.Close()
+		},
+	}
+	opts.AddFlags(cmd.Flags())
+	return cmd
+}
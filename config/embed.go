@@ -0,0 +1,30 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package config embeds the generated manifests of frp-provisioner-manager into the binary
+package config
+
+import "embed"
+
+// CRDs are the CustomResourceDefinitions generated by `make manifests`
+//
+//go:embed crd/bases/*.yaml
+var CRDs embed.FS
+
+// Manifests are the RBAC, manager and webhook manifests of the default kustomization, they're rendered
+// without the kustomize transformations, i.e. in namespace "system" and without the name prefix
+//
+//go:embed rbac/service_account.yaml rbac/role.yaml rbac/role_binding.yaml
+//go:embed rbac/leader_election_role.yaml rbac/leader_election_role_binding.yaml
+//go:embed manager/manager.yaml manager/configmap.yaml webhook/manifests.yaml webhook/service.yaml
+var Manifests embed.FS
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package access
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	plugin "github.com/fatedier/frp/pkg/plugin/server"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"net"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+const (
+	// PathPrefix is the path of the server plugin handler, frps passes the FrpServer name and the plugin token
+	// after it, e.g. "/frpserveraccess/my-server/{token}" is configured as the path of the frps http plugin.
+	PathPrefix = "/frpserveraccess/"
+	// shutdownTimeout bounds the time given to in-flight requests once the manager stops
+	shutdownTimeout = 5 * time.Second
+)
+
+// pluginRequest is a plugin.Request whose content is decoded once its operation is known
+type pluginRequest struct {
+	Op      string          `json:"op"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Plugin serves the frps http server plugin enforcing the FrpServerAccess objects on the logins and
+// proxy registrations, frps is configured with an http plugin for the Login, NewProxy, CloseProxy and
+// NewUserConn ops. The NewUserConn op enforces the source ranges of the Services.
+type Plugin struct {
+	// BindAddress is the tcp address the server plugin listens on
+	BindAddress string
+	// Token authenticates frps, it's the last segment of the plugin path
+	Token string
+	// Store holds the synced FrpServerAccess objects
+	Store *Store
+	// Reader lists the FrpServers whose registered proxies are restored on start, they're counted from
+	// zero when nil
+	Reader client.Reader
+	// RestoreSourceRanges registers the source ranges of the exposed Services with Store on start, the source
+	// ranges are kept in memory only and the users would be accepted from any address until the Services are
+	// reconciled again
+	RestoreSourceRanges func(ctx context.Context) error
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the store is synced by the leader and the
+// proxies registered by the users are only counted once when a single replica serves the plugin.
+func (p *Plugin) NeedLeaderElection() bool {
+	return true
+}
+
+// Start serves the server plugin until ctx is done
+func (p *Plugin) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("access-plugin")
+	listener, err := net.Listen("tcp", p.BindAddress)
+	if err != nil {
+		return fmt.Errorf("unable listen on access plugin address '%s', got: %w", p.BindAddress, err)
+	}
+	if err := p.restore(ctx); err != nil {
+		logger.Error(err, "Unable restore the registered proxies, the proxy limits of their users count from zero")
+	}
+	if p.RestoreSourceRanges != nil {
+		if err := p.RestoreSourceRanges(ctx); err != nil {
+			logger.Error(err, "Unable restore the source ranges, they're enforced once their services are reconciled")
+		}
+	}
+	srv := &http.Server{Handler: p.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	logger.Info("Serving frps access plugin", "address", listener.Addr().String())
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("unable serve access plugin, got: %w", err)
+	}
+	return nil
+}
+
+// Handler returns the http handler of the server plugin
+func (p *Plugin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathPrefix, func(w http.ResponseWriter, r *http.Request) {
+		serverName, token, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, PathPrefix), "/")
+		if r.Method != http.MethodPost || serverName == "" {
+			http.Error(w, "expected a frps plugin request posted to "+PathPrefix+"{frpserver}/{token}", http.StatusBadRequest)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) != 1 {
+			http.Error(w, "invalid access plugin token", http.StatusUnauthorized)
+			return
+		}
+		req := &pluginRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid plugin request, got: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		resp := &plugin.Response{Unchange: true}
+		if err := p.handle(serverName, req); err != nil {
+			log.FromContext(r.Context()).Info("frps plugin request rejected", "server", serverName, "op", req.Op, "reason", err.Error())
+			resp = &plugin.Response{Reject: true, RejectReason: err.Error()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	return mux
+}
+
+// restore counts the proxies registered on the FrpServers before the plugin started from the frps dashboards of
+// spec.routeGC, the proxies of the FrpServers without a dashboard are counted from zero.
+func (p *Plugin) restore(ctx context.Context) error {
+	if p.Reader == nil {
+		return nil
+	}
+	servers := &v1beta1.FrpServerList{}
+	if err := p.Reader.List(ctx, servers); err != nil {
+		return fmt.Errorf("unable list frpservers, got: %w", err)
+	}
+	var errs error
+	for i := range servers.Items {
+		obj := &servers.Items[i]
+		if obj.Spec.RouteGC == nil {
+			continue
+		}
+		creds, err := frpclient.GetDashboardCredentials(ctx, p.Reader, obj)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		proxies, err := frpclient.ListProxies(ctx, obj, creds)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		p.Store.RestoreProxies(obj.Name, lo.FilterMap(proxies, func(proxy frpclient.DashboardProxy, _ int) (string, bool) {
+			return proxy.Name, proxy.Status == frpclient.DashboardProxyOnline
+		}))
+	}
+	return errs
+}
+
+// handle applies the store to the operation of a plugin request, the operations the plugin does not
+// enforce are allowed.
+func (p *Plugin) handle(serverName string, req *pluginRequest) error {
+	switch req.Op {
+	case plugin.OpLogin:
+		content := &plugin.LoginContent{}
+		if err := json.Unmarshal(req.Content, content); err != nil {
+			return fmt.Errorf("invalid login content, got: %w", err)
+		}
+		return p.Store.Login(serverName, content)
+	case plugin.OpNewProxy:
+		content := &plugin.NewProxyContent{}
+		if err := json.Unmarshal(req.Content, content); err != nil {
+			return fmt.Errorf("invalid new proxy content, got: %w", err)
+		}
+		return p.Store.NewProxy(serverName, content)
+	case plugin.OpCloseProxy:
+		content := &plugin.CloseProxyContent{}
+		if err := json.Unmarshal(req.Content, content); err != nil {
+			return fmt.Errorf("invalid close proxy content, got: %w", err)
+		}
+		p.Store.CloseProxy(serverName, content)
+	case plugin.OpNewUserConn:
+		content := &plugin.NewUserConnContent{}
+		if err := json.Unmarshal(req.Content, content); err != nil {
+			return fmt.Errorf("invalid new user conn content, got: %w", err)
+		}
+		return p.Store.NewUserConn(serverName, content)
+	}
+	return nil
+}
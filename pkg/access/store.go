@@ -0,0 +1,293 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package access
+
+import (
+	"errors"
+	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	plugin "github.com/fatedier/frp/pkg/plugin/server"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// portRange is an inclusive range of remote ports
+type portRange struct {
+	min, max int
+}
+
+// user is a v1beta1.FrpServerAccessUser with its parsed port ranges
+type user struct {
+	v1beta1.FrpServerAccessUser
+	ports []portRange
+}
+
+// server is the access of the users of a FrpServer merged from its FrpServerAccess objects
+type server struct {
+	denyUnknownUsers bool
+	users            map[string]*user
+}
+
+// userKey identifies a frp user of a FrpServer
+type userKey struct {
+	server string
+	user   string
+}
+
+// sourceRanges are the source ranges of the proxies of a Service on a FrpServer
+type sourceRanges struct {
+	server  string
+	proxies []string
+	ranges  frpclient.SourceRanges
+}
+
+// Store holds the synced FrpServerAccess objects and the proxies registered by their users, it's
+// consulted by the frps server plugin on every login, proxy registration and user connection.
+type Store struct {
+	mu       sync.RWMutex
+	accesses map[string]v1beta1.FrpServerAccessSpec
+	servers  map[string]*server
+	proxies  map[userKey]map[string]struct{}
+	sources  map[string]sourceRanges
+}
+
+// NewStore returns an empty Store, the FrpServers without any FrpServerAccess are not restricted
+func NewStore() *Store {
+	return &Store{
+		accesses: make(map[string]v1beta1.FrpServerAccessSpec),
+		servers:  make(map[string]*server),
+		proxies:  make(map[userKey]map[string]struct{}),
+		sources:  make(map[string]sourceRanges),
+	}
+}
+
+// Set syncs the FrpServerAccess named name, the spec should have been checked with Validate
+func (s *Store) Set(name string, spec v1beta1.FrpServerAccessSpec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accesses[name] = spec
+	s.rebuild()
+}
+
+// Delete removes the FrpServerAccess named name
+func (s *Store) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accesses, name)
+	s.rebuild()
+}
+
+// rebuild merges the accesses by FrpServer, a user listed by several accesses of a FrpServer is
+// defined by the access whose name sorts first.
+func (s *Store) rebuild() {
+	names := lo.Keys(s.accesses)
+	sort.Strings(names)
+	servers := make(map[string]*server)
+	for _, name := range names {
+		spec := s.accesses[name]
+		srv, ok := servers[spec.ServerName]
+		if !ok {
+			srv = &server{users: make(map[string]*user)}
+			servers[spec.ServerName] = srv
+		}
+		srv.denyUnknownUsers = srv.denyUnknownUsers || spec.DenyUnknownUsers
+		for _, u := range spec.Users {
+			if _, ok := srv.users[u.Name]; ok {
+				continue
+			}
+			ports, _ := parsePortRanges(u.AllowedPorts)
+			srv.users[u.Name] = &user{FrpServerAccessUser: u, ports: ports}
+		}
+	}
+	s.servers = servers
+}
+
+// Login checks a frp client may log in to the FrpServer named serverName
+func (s *Store) Login(serverName string, content *plugin.LoginContent) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, err := s.user(serverName, content.User)
+	if err != nil || u == nil {
+		return err
+	}
+	for key, value := range u.Metadatas {
+		if content.Metas[key] != value {
+			return fmt.Errorf("user '%s' should log in with metadata %s=%s", content.User, key, value)
+		}
+	}
+	return nil
+}
+
+// NewProxy checks a proxy may be registered on the FrpServer named serverName and counts it
+// against the proxy limit of its user.
+func (s *Store) NewProxy(serverName string, content *plugin.NewProxyContent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.user(serverName, content.User.User)
+	if err != nil || u == nil {
+		return err
+	}
+	if len(u.AllowedProxyTypes) != 0 && !lo.Contains(u.AllowedProxyTypes, content.ProxyType) {
+		return fmt.Errorf("user '%s' may not register %s proxies, allowed types are %v", u.Name, content.ProxyType, u.AllowedProxyTypes)
+	}
+	if len(u.ports) != 0 && (content.ProxyType == string(configv1.ProxyTypeTCP) || content.ProxyType == string(configv1.ProxyTypeUDP)) {
+		if !lo.SomeBy(u.ports, func(r portRange) bool { return content.RemotePort >= r.min && content.RemotePort <= r.max }) {
+			return fmt.Errorf("user '%s' may not bind remote port %d, allowed ports are %v", u.Name, content.RemotePort, u.AllowedPorts)
+		}
+	}
+	key := userKey{server: serverName, user: u.Name}
+	proxies, ok := s.proxies[key]
+	if !ok {
+		proxies = make(map[string]struct{})
+		s.proxies[key] = proxies
+	}
+	if _, ok := proxies[content.ProxyName]; ok {
+		return nil
+	}
+	if u.MaxProxies > 0 && len(proxies) >= u.MaxProxies {
+		return fmt.Errorf("user '%s' already registered the maximum of %d proxies", u.Name, u.MaxProxies)
+	}
+	proxies[content.ProxyName] = struct{}{}
+	return nil
+}
+
+// CloseProxy releases a proxy registered on the FrpServer named serverName
+func (s *Store) CloseProxy(serverName string, content *plugin.CloseProxyContent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := userKey{server: serverName, user: content.User.User}
+	delete(s.proxies[key], content.ProxyName)
+	if len(s.proxies[key]) == 0 {
+		delete(s.proxies, key)
+	}
+}
+
+// RestoreProxies counts the proxies registered on the FrpServer named serverName before the plugin started, so the
+// proxy limits survive restarts and leader changes. The names are prefixed with their frp user like frps reports
+// them, a name without a prefix belongs to the empty user.
+func (s *Store) RestoreProxies(serverName string, names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		userName, proxyName, ok := strings.Cut(name, ".")
+		if !ok {
+			userName, proxyName = "", name
+		}
+		key := userKey{server: serverName, user: userName}
+		if _, ok := s.proxies[key]; !ok {
+			s.proxies[key] = make(map[string]struct{})
+		}
+		s.proxies[key][proxyName] = struct{}{}
+	}
+}
+
+// SetSourceRanges restricts the users of the proxies of owner registered on the FrpServer named serverName to
+// the source ranges, the proxy names are prefixed with their frp user like frps reports them. Empty ranges
+// lift the restriction of owner.
+func (s *Store) SetSourceRanges(owner, serverName string, proxies []string, ranges frpclient.SourceRanges) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(ranges) == 0 {
+		delete(s.sources, owner)
+		return
+	}
+	s.sources[owner] = sourceRanges{server: serverName, proxies: proxies, ranges: ranges}
+}
+
+// NewUserConn checks the address of a user connecting to a proxy on the FrpServer named serverName
+// is in the source ranges of the proxy.
+func (s *Store) NewUserConn(serverName string, content *plugin.NewUserConnContent) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	host, _, err := net.SplitHostPort(content.RemoteAddr)
+	if err != nil {
+		host = content.RemoteAddr
+	}
+	for _, src := range s.sources {
+		if src.server != serverName || !lo.Contains(src.proxies, content.ProxyName) {
+			continue
+		}
+		if !src.ranges.Allows(host) {
+			return fmt.Errorf("address '%s' is not in the source ranges of proxy '%s'", content.RemoteAddr, content.ProxyName)
+		}
+	}
+	return nil
+}
+
+// user returns the access of a frp user, nil means the user is not restricted
+func (s *Store) user(serverName, name string) (*user, error) {
+	srv, ok := s.servers[serverName]
+	if !ok {
+		return nil, nil
+	}
+	u, ok := srv.users[name]
+	if !ok && srv.denyUnknownUsers {
+		return nil, fmt.Errorf("user '%s' is not allowed to log in to frpserver '%s'", name, serverName)
+	}
+	return u, nil
+}
+
+// Validate checks the users of a FrpServerAccess can be enforced
+func Validate(spec *v1beta1.FrpServerAccessSpec) (errs error) {
+	if spec.ServerName == "" {
+		errs = errors.Join(errs, fmt.Errorf("field spec.serverName should not be empty"))
+	}
+	seen := make(map[string]bool, len(spec.Users))
+	for i, u := range spec.Users {
+		if u.Name == "" {
+			errs = errors.Join(errs, fmt.Errorf("field spec.users[%d].name should not be empty", i))
+		} else if seen[u.Name] {
+			errs = errors.Join(errs, fmt.Errorf("invalid spec.users[%d].name, user '%s' is listed more than once", i, u.Name))
+		}
+		seen[u.Name] = true
+		for _, proxyType := range u.AllowedProxyTypes {
+			if configv1.NewProxyConfigurerByType(configv1.ProxyType(proxyType)) == nil {
+				errs = errors.Join(errs, fmt.Errorf("invalid spec.users[%d].allowedProxyTypes, unknown proxy type '%s'", i, proxyType))
+			}
+		}
+		if _, err := parsePortRanges(u.AllowedPorts); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid spec.users[%d].allowedPorts, got: %w", i, err))
+		}
+	}
+	return errs
+}
+
+// parsePortRanges parses single ports and ranges like "6000-6100"
+func parsePortRanges(values []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(values))
+	for _, value := range values {
+		low, high, isRange := strings.Cut(strings.TrimSpace(value), "-")
+		minPort, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", value)
+		}
+		maxPort := minPort
+		if isRange {
+			if maxPort, err = strconv.Atoi(high); err != nil {
+				return nil, fmt.Errorf("invalid port range '%s'", value)
+			}
+		}
+		if minPort <= 0 || maxPort > 65535 || minPort > maxPort {
+			return nil, fmt.Errorf("port range '%s' should be an ascending range within 1..65535", value)
+		}
+		ranges = append(ranges, portRange{min: minPort, max: maxPort})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package access
+
+import (
+	"github.com/fatedier/frp/pkg/msg"
+	plugin "github.com/fatedier/frp/pkg/plugin/server"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// proxyOp registers or closes a proxy of a user on the "edge" FrpServer
+type proxyOp struct {
+	close     bool
+	user      string
+	name      string
+	proxyType string
+	port      int
+	wantErr   bool
+}
+
+func newTestStore() *Store {
+	s := NewStore()
+	s.Set("edge-access", v1beta1.FrpServerAccessSpec{
+		ServerName:       "edge",
+		DenyUnknownUsers: true,
+		Users: []v1beta1.FrpServerAccessUser{{
+			Name:              "alice",
+			MaxProxies:        2,
+			AllowedProxyTypes: []string{"tcp", "http"},
+			AllowedPorts:      []string{"6000-6010"},
+		}},
+	})
+	return s
+}
+
+func TestStoreNewProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		restore map[string][]string
+		ops     []proxyOp
+	}{
+		{name: "within the limit", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "b", proxyType: "tcp", port: 6001},
+		}},
+		{name: "over the limit", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "b", proxyType: "tcp", port: 6001},
+			{user: "alice", name: "c", proxyType: "tcp", port: 6002, wantErr: true},
+		}},
+		{name: "re-registered proxy counted once", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "b", proxyType: "tcp", port: 6001},
+		}},
+		{name: "closed proxy released", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "b", proxyType: "tcp", port: 6001},
+			{close: true, user: "alice", name: "a"},
+			{user: "alice", name: "c", proxyType: "tcp", port: 6002},
+		}},
+		{name: "restored proxies counted", restore: map[string][]string{"edge": {"alice.a", "alice.b"}}, ops: []proxyOp{
+			{user: "alice", name: "c", proxyType: "tcp", port: 6002, wantErr: true},
+		}},
+		{name: "restored proxy re-registered", restore: map[string][]string{"edge": {"alice.a", "alice.b"}}, ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+		}},
+		{name: "restored proxies of other users not counted", restore: map[string][]string{"edge": {"bob.a", "bob.b", "c"}}, ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "b", proxyType: "tcp", port: 6001},
+		}},
+		{name: "restored proxies of other frpservers not counted", restore: map[string][]string{"core": {"alice.a", "alice.b"}}, ops: []proxyOp{
+			{user: "alice", name: "c", proxyType: "tcp", port: 6002},
+		}},
+		{name: "unknown user denied", ops: []proxyOp{
+			{user: "bob", name: "a", proxyType: "tcp", port: 6000, wantErr: true},
+		}},
+		{name: "proxy type not allowed", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "udp", port: 6000, wantErr: true},
+		}},
+		{name: "remote port outside the allowed ports", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 7000, wantErr: true},
+		}},
+		{name: "allowed ports not applied to http", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "http"},
+		}},
+		{name: "rejected proxy not counted", ops: []proxyOp{
+			{user: "alice", name: "a", proxyType: "tcp", port: 7000, wantErr: true},
+			{user: "alice", name: "b", proxyType: "tcp", port: 6000},
+			{user: "alice", name: "c", proxyType: "tcp", port: 6001},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore()
+			for serverName, names := range tt.restore {
+				s.RestoreProxies(serverName, names)
+			}
+			for i, op := range tt.ops {
+				user := plugin.UserInfo{User: op.user}
+				if op.close {
+					s.CloseProxy("edge", &plugin.CloseProxyContent{User: user, CloseProxy: msg.CloseProxy{ProxyName: op.name}})
+					continue
+				}
+				err := s.NewProxy("edge", &plugin.NewProxyContent{User: user, NewProxy: msg.NewProxy{
+					ProxyName: op.name, ProxyType: op.proxyType, RemotePort: op.port,
+				}})
+				if op.wantErr != (err != nil) {
+					t.Fatalf("op %d registering '%s': expected error %t, got: %v", i, op.name, op.wantErr, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreNewUserConn(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		serverName string
+		proxyName  string
+		remoteAddr string
+		wantErr    bool
+	}{
+		{name: "address in the source ranges", serverName: "edge", proxyName: "alice.web", remoteAddr: "10.1.2.3:40000"},
+		{name: "address outside the source ranges", serverName: "edge", proxyName: "alice.web", remoteAddr: "192.168.1.1:40000", wantErr: true},
+		{name: "address without a port", serverName: "edge", proxyName: "alice.web", remoteAddr: "192.168.1.1", wantErr: true},
+		{name: "other proxy not restricted", serverName: "edge", proxyName: "alice.api", remoteAddr: "192.168.1.1:40000"},
+		{name: "other frpserver not restricted", serverName: "core", proxyName: "alice.web", remoteAddr: "192.168.1.1:40000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore()
+			s.SetSourceRanges("default/web", "edge", []string{"alice.web"}, frpclient.SourceRanges{private})
+			err := s.NewUserConn(tt.serverName, &plugin.NewUserConnContent{ProxyName: tt.proxyName, RemoteAddr: tt.remoteAddr})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("expected error %t, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPluginToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "valid token", path: PathPrefix + "edge/secret", wantStatus: http.StatusOK},
+		{name: "invalid token", path: PathPrefix + "edge/guess", wantStatus: http.StatusUnauthorized},
+		{name: "missing token", path: PathPrefix + "edge", wantStatus: http.StatusUnauthorized},
+		{name: "missing frpserver", path: PathPrefix, wantStatus: http.StatusBadRequest},
+	}
+	p := &Plugin{Token: "secret", Store: newTestStore()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"version":"0.1.0","op":"Login","content":{"user":"alice"}}`
+			w := httptest.NewRecorder()
+			p.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
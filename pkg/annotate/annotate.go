@@ -0,0 +1,195 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package annotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+// annotationPrefixes are the prefixes of the frp annotations of services
+var annotationPrefixes = []string{"service.beta.kubernetes.io/frp-", "frp.gofrp.io/"}
+
+// managedAnnotations are written by the manager, they can't be changed with the command
+var managedAnnotations = []string{v1beta1.AnnotationPublishedEndpointsKey, v1beta1.AnnotationEffectiveSubdomainKey,
+	v1beta1.AnnotationInternalEndpointsKey, v1beta1.AnnotationReconciledByKey, v1beta1.AnnotationLastReconcileKey}
+
+// Options contains the configuration of a bulk annotation run
+type Options struct {
+	// Selector is the label selector of the services to annotate, empty matches all services
+	Selector string `json:"selector"`
+	// Namespace restricts the services to a namespace, empty matches all namespaces
+	Namespace string `json:"namespace"`
+	// Set are the frp annotations set on the matched services
+	Set map[string]string `json:"set"`
+	// Remove are the frp annotations removed from the matched services
+	Remove []string `json:"remove"`
+	// DryRun only reports the changes without applying them
+	DryRun bool `json:"dryRun"`
+}
+
+// SetDefaults set default values for annotate options
+func (o *Options) SetDefaults() {
+	if o.Set == nil {
+		o.Set = make(map[string]string)
+	}
+}
+
+// Validate validates the annotate options
+func (o *Options) Validate() (err error) {
+	if len(o.Set) == 0 && len(o.Remove) == 0 {
+		err = errors.Join(err, fmt.Errorf("at least one annotation to set or remove is required"))
+	}
+	if _, parseErr := labels.Parse(o.Selector); parseErr != nil {
+		err = errors.Join(err, fmt.Errorf("invalid selector '%s', got: %w", o.Selector, parseErr))
+	}
+	for _, key := range append(lo.Keys(o.Set), o.Remove...) {
+		if !isFrpAnnotation(key) {
+			err = errors.Join(err, fmt.Errorf("annotation '%s' is not a frp annotation, expected one of the prefixes %v", key, annotationPrefixes))
+		}
+		if lo.Contains(managedAnnotations, key) {
+			err = errors.Join(err, fmt.Errorf("annotation '%s' is managed by frp-provisioner-manager", key))
+		}
+	}
+	if both := lo.Intersect(lo.Keys(o.Set), o.Remove); len(both) != 0 {
+		err = errors.Join(err, fmt.Errorf("annotations %v are both set and removed", both))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.Selector, "selector", "l", o.Selector, "Is the label selector of the services to annotate, empty matches all services.")
+	fs.StringVarP(&o.Namespace, "namespace", "n", o.Namespace, "Restricts the services to a namespace, empty matches all namespaces.")
+	fs.StringToStringVar(&o.Set, "set", o.Set, "Are the frp annotations set on the matched services, e.g. frp.gofrp.io/user=team-a.")
+	fs.StringSliceVar(&o.Remove, "remove", o.Remove, "Are the frp annotations removed from the matched services.")
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Only reports the changes without applying them.")
+}
+
+func isFrpAnnotation(key string) bool {
+	return lo.SomeBy(annotationPrefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) && len(key) > len(prefix) })
+}
+
+// Change is the annotation change of a matched service
+type Change struct {
+	Namespace string
+	Service   string
+	// Diff are the changed annotations as "- key=value" and "+ key=value" lines, sorted by key
+	Diff []string
+	// Err is why the change could not be applied
+	Err error
+}
+
+// Report is the outcome of a bulk annotation run
+type Report struct {
+	// Matched is the number of services matched by the selector
+	Matched int
+	// Changes are the changes of the services whose annotations differ, sorted by namespace and name
+	Changes []Change
+}
+
+// Failed returns the number of changes which could not be applied
+func (r *Report) Failed() int {
+	return lo.CountBy(r.Changes, func(change Change) bool { return change.Err != nil })
+}
+
+// diff applies the options to the annotations, it returns the changed lines
+func diff(o *Options, annotations map[string]string) []string {
+	var lines []string
+	for _, key := range lo.Union(lo.Keys(o.Set), o.Remove) {
+		current, ok := annotations[key]
+		value, set := o.Set[key]
+		if (set && ok && current == value) || (!set && !ok) {
+			continue
+		}
+		if ok {
+			lines = append(lines, fmt.Sprintf("- %s=%s", key, current))
+		}
+		if set {
+			lines = append(lines, fmt.Sprintf("+ %s=%s", key, value))
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	return lines
+}
+
+// Run applies the annotation changes to the matched services. Every service is patched on its own, the
+// run continues after a failed patch and the outcome of every service is reported in one report, the
+// failed services can be retried with the same options. Nothing is changed when o.DryRun is set.
+func Run(ctx context.Context, cli client.Client, o *Options, w io.Writer) (*Report, error) {
+	selector, err := labels.Parse(o.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector '%s', got: %w", o.Selector, err)
+	}
+	serviceList := &v1.ServiceList{}
+	if err := cli.List(ctx, serviceList, client.InNamespace(o.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("unable get service list, got: %w", err)
+	}
+	sort.Slice(serviceList.Items, func(i, j int) bool {
+		return client.ObjectKeyFromObject(&serviceList.Items[i]).String() < client.ObjectKeyFromObject(&serviceList.Items[j]).String()
+	})
+	report := &Report{Matched: len(serviceList.Items)}
+	for i := range serviceList.Items {
+		svc := &serviceList.Items[i]
+		lines := diff(o, svc.Annotations)
+		if len(lines) == 0 {
+			continue
+		}
+		change := Change{Namespace: svc.Namespace, Service: svc.Name, Diff: lines}
+		if !o.DryRun {
+			patch := client.MergeFrom(svc.DeepCopy())
+			if svc.Annotations == nil {
+				svc.Annotations = make(map[string]string)
+			}
+			for _, key := range o.Remove {
+				delete(svc.Annotations, key)
+			}
+			for key, value := range o.Set {
+				svc.Annotations[key] = value
+			}
+			change.Err = cli.Patch(ctx, svc, patch)
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	return report, writeReport(w, o, report)
+}
+
+// writeReport writes the diff of every changed service followed by a summary
+func writeReport(w io.Writer, o *Options, report *Report) error {
+	var b strings.Builder
+	for _, change := range report.Changes {
+		status := lo.Ternary(o.DryRun, "would change", "changed")
+		if change.Err != nil {
+			status = fmt.Sprintf("failed: %v", change.Err)
+		}
+		fmt.Fprintf(&b, "service %s/%s %s\n", change.Namespace, change.Service, status)
+		for _, line := range change.Diff {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	fmt.Fprintf(&b, "%d services matched, %d %s, %d failed%s\n", report.Matched, len(report.Changes)-report.Failed(),
+		lo.Ternary(o.DryRun, "to change", "changed"), report.Failed(), lo.Ternary(o.DryRun, " (dry run)", ""))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
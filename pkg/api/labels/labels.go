@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package labels is the ownership schema of the objects the provisioner creates for a Service, the
+// controllers build their labels and selectors from it so queries stay consistent.
+package labels
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ServiceName is the name of the Service owning a frp client pod or secret
+	ServiceName = "gofrp.io/service-name"
+	// ControllerUID is the uid of the Service owning a frp client pod, it tells apart the pods of a
+	// Service recreated with the same name
+	ControllerUID = "gofrp.io/controller-uid"
+	// PodTemplateHash is the hash of the pod template a frp client pod was generated from
+	PodTemplateHash = "gofrp.io/pod-template-hash"
+	// UsageReport marks the usage report ConfigMaps written to the namespaces with tunnels
+	UsageReport = "gofrp.io/usage-report"
+	// Finalizer is the finalizer of the Services exposed by the provisioner
+	Finalizer = "finalizer.gofrp.io/tracking"
+	// FrpServerFinalizer holds a FrpServer until the tunnels of its Services are deleted or orphaned
+	FrpServerFinalizer = "finalizer.gofrp.io/frpserver-tunnels"
+)
+
+// ForService returns the ownership labels of the frp client pods of a Service
+func ForService(svc metav1.Object) map[string]string {
+	return map[string]string{
+		ServiceName:   svc.GetName(),
+		ControllerUID: string(svc.GetUID()),
+	}
+}
+
+// OwnedBy returns the selector of the frp client pods of a Service
+func OwnedBy(svc metav1.Object) labels.Selector {
+	return labels.SelectorFromSet(ForService(svc))
+}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package v1beta1
 
+import "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+
 var (
 	FrpServerAuthMethods = []FrpServerAuthMethod{
 		FrpServerAuthMethodToken,
@@ -25,6 +27,36 @@ var (
 		FrpServerAuthScopeHeartBeats,
 		FrpServerAuthScopeNewWorkConns,
 	}
+	FrpServerDeletionPolicies = []FrpServerDeletionPolicy{
+		FrpServerDeletionPolicyDelete,
+		FrpServerDeletionPolicyOrphan,
+	}
+	FrpServerTrustedCAModes = []FrpServerTrustedCAMode{
+		FrpServerTrustedCAModeReplace,
+		FrpServerTrustedCAModeMerge,
+	}
+	// ProxyTypes are the frp proxy types a service can select with AnnotationProxyTypeKey
+	ProxyTypes = []string{
+		ProxyTypeTCP,
+		ProxyTypeUDP,
+		ProxyTypeSTCP,
+		ProxyTypeXTCP,
+		ProxyTypeHTTP,
+		ProxyTypeHTTPS,
+		ProxyTypeSUDP,
+		ProxyTypeTCPMux,
+	}
+	// ClientPlugins are the frp client plugins terminating the TLS of the https proxies
+	ClientPlugins = []string{
+		PluginHTTPS2HTTP,
+		PluginHTTPS2HTTPS,
+	}
+	// ConflictStrategies are the strategies resolving the services publishing the same hostname on a FrpServer
+	ConflictStrategies = []string{
+		ConflictStrategyFail,
+		ConflictStrategySuffixHash,
+		ConflictStrategyPreemptLowerPriority,
+	}
 	FrpServerTransportProtocols = []FrpServerTransportProtocol{
 		FrpServerTransportProtocolTCP,
 		FrpServerTransportProtocolKCP,
@@ -35,13 +67,259 @@ var (
 )
 
 const (
-	FinalizerName              string = "finalizer.gofrp.io/tracking"
-	LabelServiceNameKey        string = "gofrp.io/service-name"
-	LabelControllerUidKey      string = "gofrp.io/controller-uid"
+	// Deprecated: use labels.Finalizer
+	FinalizerName string = labels.Finalizer
+	// Deprecated: use labels.ServiceName
+	LabelServiceNameKey string = labels.ServiceName
+	// Deprecated: use labels.ControllerUID
+	LabelControllerUidKey string = labels.ControllerUID
+	// Deprecated: use labels.PodTemplateHash
+	LabelPodTemplateHashKey string = labels.PodTemplateHash
+
 	AnnotationFrpServerNameKey string = "service.beta.kubernetes.io/frp-server-name"
+	// AnnotationFrpServerClaimNameKey assigns the service to the FrpServer bound by a FrpServerClaim of its namespace
+	AnnotationFrpServerClaimNameKey string = "service.beta.kubernetes.io/frp-server-claim-name"
+	// AnnotationSchedulerKey places the service on a FrpServer picked by the scheduler registered with the name,
+	// e.g. "default", the placement is recorded in AnnotationFrpServerNameKey
+	AnnotationSchedulerKey string = "service.beta.kubernetes.io/frp-scheduler"
+	// AnnotationServerSelectorKey is a label selector of the FrpServers a scheduler may place the service on, e.g.
+	// "region=eu,tier!=spot". The service is placed by the scheduler of the manager when it selects no scheduler.
+	AnnotationServerSelectorKey string = "frp.gofrp.io/server-selector"
+	// AnnotationServerGroupKey places the service on the healthy members of a FrpServerGroup, the member it's placed
+	// on is recorded in AnnotationFrpServerNameKey
+	AnnotationServerGroupKey string = "frp.gofrp.io/server-group"
+	// AnnotationReadinessGateKey opts a backend pod in to the tunnel readiness gate
+	AnnotationReadinessGateKey string = "frp.gofrp.io/readiness-gate"
+
+	// AnnotationProxyTypeKey selects the frp proxy type of the service, one of tcp, udp, stcp, xtcp, http,
+	// https, sudp or tcpmux
+	AnnotationProxyTypeKey string = "service.beta.kubernetes.io/frp-proxy-type"
+	// AnnotationSubdomainKey is the subdomain an http proxy is published on, relative to the FrpServer spec.subDomainHost
+	AnnotationSubdomainKey string = "service.beta.kubernetes.io/frp-subdomain"
+	// AnnotationDomainKey selects the FrpServer spec.domains entry an http proxy is published on
+	AnnotationDomainKey string = "service.beta.kubernetes.io/frp-domain"
+	// AnnotationMultiplexerKey selects the multiplexer of a tcpmux proxy, only httpconnect is supported by frp
+	AnnotationMultiplexerKey string = "service.beta.kubernetes.io/frp-multiplexer"
+	// AnnotationRouteByHTTPUserKey routes the connections of a http or tcpmux proxy by the user of their proxy
+	// authorization, so the proxies of several services can share a domain
+	AnnotationRouteByHTTPUserKey string = "service.beta.kubernetes.io/frp-route-by-http-user"
+	// AnnotationHTTPAuthSecretKey is the name of a kubernetes.io/basic-auth Secret of the namespace, the http and
+	// tcpmux proxies of the service require its username and password from the clients
+	AnnotationHTTPAuthSecretKey string = "service.beta.kubernetes.io/frp-http-auth-secret"
+	// AnnotationUseEncryptionKey overrides spec.proxyDefaults.useEncryption of the FrpServer for the service
+	AnnotationUseEncryptionKey string = "service.beta.kubernetes.io/frp-use-encryption"
+	// AnnotationUseCompressionKey overrides spec.proxyDefaults.useCompression of the FrpServer for the service
+	AnnotationUseCompressionKey string = "service.beta.kubernetes.io/frp-use-compression"
+	// AnnotationBandwidthLimitKey overrides spec.proxyDefaults.bandwidthLimit of the FrpServer for the service
+	AnnotationBandwidthLimitKey string = "service.beta.kubernetes.io/frp-bandwidth-limit"
+	// AnnotationBandwidthLimitModeKey overrides spec.proxyDefaults.bandwidthLimitMode of the FrpServer for the service
+	AnnotationBandwidthLimitModeKey string = "service.beta.kubernetes.io/frp-bandwidth-limit-mode"
+	// AnnotationHealthCheckTypeKey overrides spec.proxyDefaults.healthCheck.type of the FrpServer for the service,
+	// "none" disables the health check
+	AnnotationHealthCheckTypeKey string = "service.beta.kubernetes.io/frp-health-check-type"
+	// AnnotationHealthCheckPathKey overrides spec.proxyDefaults.healthCheck.path of the FrpServer for the service
+	AnnotationHealthCheckPathKey string = "service.beta.kubernetes.io/frp-health-check-path"
+	// AnnotationUserKey overrides spec.user of the FrpServer for the proxies of the service, so apps sharing
+	// a frp server get distinct identities on the server side
+	AnnotationUserKey string = "frp.gofrp.io/user"
+	// AnnotationProxyDependsOnKey is a json object mapping the proxies of the service to the proxies they
+	// depend on, e.g. {"web":["healthz"]}. The proxies are ordered in the rendered frpc config so frpc registers
+	// a proxy after its dependencies. Proxies are referred to by the name of their port, an unnamed port by its number,
+	// and registered as {namespace}.{service}.{port}.
+	AnnotationProxyDependsOnKey string = "frp.gofrp.io/proxy-depends-on"
+	// AnnotationIngressIPKey records the ingress IP allocated to the service by the IPAM of its FrpServer
+	AnnotationIngressIPKey string = "frp.gofrp.io/ingress-ip"
+	// AnnotationIngressIPPoolKey records the name of the FrpServer whose IPAM allocated AnnotationIngressIPKey
+	AnnotationIngressIPPoolKey string = "frp.gofrp.io/ingress-ip-pool"
+	// AnnotationExposureModeKey selects how the service is exposed, one of tunnel (the default) or hostPort
+	AnnotationExposureModeKey string = "frp.gofrp.io/exposure-mode"
+	// AnnotationSourceRangesKey is the comma separated list of CIDRs the users of the service may connect from,
+	// e.g. "10.0.0.0/8,192.0.2.1/32", like spec.loadBalancerSourceRanges. The frps access plugin rejects the
+	// other user connections, it requires the access plugin and frps configured with its NewUserConn op. On a
+	// FrpProxy the frp client of the manager closes the work connections frps starts for the other addresses.
+	AnnotationSourceRangesKey string = "frp.gofrp.io/source-ranges"
+	// AnnotationProxyBackendsKey is the json list of the backends the proxies of the service forward to, it's
+	// recorded on the frp client pods for the frp client images configuring their proxies from it.
+	AnnotationProxyBackendsKey string = "frp.gofrp.io/proxy-backends"
+	// AnnotationServerConfigHashKey records on the frp client pods the hash of the frp client common config and
+	// the proxy defaults of their FrpServer, the pods are restarted one at a time once the FrpServer spec changes it.
+	AnnotationServerConfigHashKey string = "frp.gofrp.io/server-config-hash"
+	// AnnotationScheduledServerKey records on the frp client pods the name of the FrpServer they were created for
+	AnnotationScheduledServerKey string = "frp.gofrp.io/scheduled-server"
+	// AnnotationReconciledByKey records on the reconciled Services and FrpServers the manager which reconciled them
+	// last, "{version}/{commit}@{hostname}", to tell apart the instances of a mixed-version rollout
+	AnnotationReconciledByKey string = "frp.gofrp.io/reconciled-by"
+	// AnnotationLastReconcileKey records the RFC 3339 time of the last reconcile stamped with AnnotationReconciledByKey
+	AnnotationLastReconcileKey string = "frp.gofrp.io/last-reconcile"
+	// AnnotationCanaryPodTemplateKey records on the canary service the last pod template its tunnel was live with
+	AnnotationCanaryPodTemplateKey string = "frp.gofrp.io/canary-pod-template"
+	// AnnotationKMSKeyIDKey records the id of the kms key which wrapped the data encryption key of a Secret
+	AnnotationKMSKeyIDKey string = "frp.gofrp.io/kms-key-id"
+	// AnnotationPublishedEndpointsKey mirrors the published endpoints of a service as a comma separated host:port list
+	AnnotationPublishedEndpointsKey string = "frp.gofrp.io/published-endpoints"
+	// AnnotationSplitHorizonKey set to "true" or "false" overrides the split-horizon publication of the manager
+	// for the service, the in-cluster endpoints are then published with AnnotationInternalEndpointsKey
+	AnnotationSplitHorizonKey string = "frp.gofrp.io/split-horizon"
+	// AnnotationInternalEndpointsKey publishes the in-cluster endpoints of a split-horizon service as a comma
+	// separated host:port list, so in-cluster consumers reach it without leaving the cluster
+	AnnotationInternalEndpointsKey string = "frp.gofrp.io/internal-endpoints"
+	// AnnotationPriorityKey is the integer priority of the service when its hostname conflicts with another
+	// service, the higher priority wins with the preempt-lower-priority strategy. Defaults to 0.
+	AnnotationPriorityKey string = "frp.gofrp.io/priority"
+	// AnnotationConflictStrategyKey on a namespace overrides the conflict strategy of the manager for its
+	// services, one of fail, suffix-hash or preempt-lower-priority
+	AnnotationConflictStrategyKey string = "frp.gofrp.io/conflict-strategy"
+	// AnnotationEffectiveSubdomainKey records the subdomain the http proxies of the service are registered with
+	// when a conflict renamed it, it's copied to the frp client pods.
+	AnnotationEffectiveSubdomainKey string = "frp.gofrp.io/effective-subdomain"
+	// AnnotationInlineServerKey is a json object of the frps a service is exposed through without a FrpServer, e.g.
+	// {"serverAddr":"frps.example.com","serverPort":7000,"tokenSecretRef":{"name":"frps","key":"token"}}. It's
+	// copied to the frp client pods.
+	AnnotationInlineServerKey string = "frp.gofrp.io/inline-server"
+	// AnnotationConformanceKey set to "true" on a FrpServer runs the conformance checks against its frps once
+	// per generation and stores the summary in status.conformance
+	AnnotationConformanceKey string = "frp.gofrp.io/conformance"
+	// AnnotationImageKey overrides the image of the frp client container of the pods of a service, e.g. to roll
+	// out a frpc version workload by workload. The image must be pulled from the manager's allowed registries.
+	AnnotationImageKey string = "frp.gofrp.io/image"
+	// AnnotationPortPrefix prefixes the annotations overriding the proxy of a single port of a service,
+	// "frp.gofrp.io/port.{port name}.type" selects its proxy type instead of AnnotationProxyTypeKey and
+	// "frp.gofrp.io/port.{port name}.remote-port" the port the FrpServer publishes a tcp or udp proxy on.
+	// They're copied to the frp client pods.
+	AnnotationPortPrefix string = "frp.gofrp.io/port."
+	// PortAnnotationType and PortAnnotationRemotePort are the fields of the port annotations
+	PortAnnotationType       = "type"
+	PortAnnotationRemotePort = "remote-port"
+	// AnnotationPluginKey selects the client plugin terminating the TLS of the https proxies of the service at the
+	// frp client, one of https2http or https2https. It's copied to the frp client pods.
+	AnnotationPluginKey string = "frp.gofrp.io/plugin"
+	// AnnotationPluginCertSecretKey is the name of the kubernetes.io/tls Secret holding the certificate the client
+	// plugin serves, it's mounted in the frp client containers at PluginTLSMountPath. frpc generates a self-signed
+	// certificate when it's not set.
+	AnnotationPluginCertSecretKey string = "frp.gofrp.io/plugin-cert-secret"
+	// AnnotationFrpcConfigHashKey records the hash of the rendered frpc config and its credentials on the frp client
+	// pods, the pods are restarted once it no longer matches the config of their service
+	AnnotationFrpcConfigHashKey string = "frp.gofrp.io/frpc-config-hash"
+	// AnnotationForceDeleteKey set to "true" on a FrpServer allows deleting it while Services or FrpProxies still
+	// use it, its deletion policy is then applied to their tunnels
+	AnnotationForceDeleteKey string = "frp.gofrp.io/force-delete"
+	// AnnotationExpiresAtKey is the RFC3339 time a FrpProxy is deleted at, the tunnels of the port-forward command
+	// are set to expire with it
+	AnnotationExpiresAtKey string = "frp.gofrp.io/expires-at"
+
+	// ProxyMetadataOwnerKey is the frp proxy metadata naming the object a proxy is registered for, as
+	// "Service/{namespace}/{name}" or "FrpProxy/{namespace}/{name}", the stale routes are found with it
+	ProxyMetadataOwnerKey string = "frp.gofrp.io/owner"
+
+	// PodConditionTunnelReady is the readiness gate condition set on backend pods once the tunnel is live, i.e. the
+	// proxies of the service are online in the frps dashboard of spec.routeGC or, without a dashboard, a frp
+	// client pod of the service is ready
+	PodConditionTunnelReady string = "frp.gofrp.io/tunnel-ready"
+	// ServiceConditionDegraded is set on services whose frp client pods exhausted their restart budget
+	ServiceConditionDegraded string = "frp.gofrp.io/Degraded"
+	// FrpServerConditionHealthy is true once the last login handshake with the frps succeeded, false when the
+	// server rejected it and unknown when the server couldn't be reached
+	FrpServerConditionHealthy string = "Healthy"
+	// FrpServerConditionClockSkewed is true while the clock of the frps host drifts from the manager beyond
+	// spec.clockSkew.thresholdSeconds
+	FrpServerConditionClockSkewed string = "ClockSkewed"
+	// ServiceConditionTunnelReady is set on exposed services, it's true while a frp client pod of the service is ready
+	ServiceConditionTunnelReady string = "frp.gofrp.io/TunnelReady"
+
+	ProxyTypeTCP    = "tcp"
+	ProxyTypeUDP    = "udp"
+	ProxyTypeSTCP   = "stcp"
+	ProxyTypeXTCP   = "xtcp"
+	ProxyTypeHTTP   = "http"
+	ProxyTypeHTTPS  = "https"
+	ProxyTypeSUDP   = "sudp"
+	ProxyTypeTCPMux = "tcpmux"
+
+	HealthCheckTypeTCP  = "tcp"
+	HealthCheckTypeHTTP = "http"
+	HealthCheckTypeNone = "none"
+
+	BandwidthLimitModeClient = "client"
+	BandwidthLimitModeServer = "server"
+
+	// PluginHTTPS2HTTP terminates the TLS of a https proxy at the frp client and forwards plain http to the backend
+	PluginHTTPS2HTTP = "https2http"
+	// PluginHTTPS2HTTPS terminates the TLS of a https proxy at the frp client and re-encrypts it to the backend
+	PluginHTTPS2HTTPS = "https2https"
+
+	// ExposureModeTunnel exposes the service through a frp tunnel to its FrpServer
+	ExposureModeTunnel = "tunnel"
+	// ExposureModeHostPort exposes the service on the host ports of its pod, which relays them to the service.
+	// The node addresses are published as the ingress points, for on-prem LANs where the nodes are reachable directly
+	ExposureModeHostPort = "hostPort"
+	// ConflictStrategyFail leaves the hostname to the service already publishing it, or else the oldest
+	// service, the other services are not published on it
+	ConflictStrategyFail = "fail"
+	// ConflictStrategySuffixHash publishes the other services on their subdomain suffixed with a hash of
+	// their namespace and name
+	ConflictStrategySuffixHash = "suffix-hash"
+	// ConflictStrategyPreemptLowerPriority gives the hostname to the service with the highest
+	// AnnotationPriorityKey, the services of lower priority are withdrawn from it
+	ConflictStrategyPreemptLowerPriority = "preempt-lower-priority"
+	// ClaimPolicyAllNamespaces in spec.claimPolicy.allowedNamespaces allows the claims of every namespace
+	ClaimPolicyAllNamespaces = "*"
 
 	DefaultCaFileName      = "tls.ca"
 	DefaultCertFileName    = "tls.crt"
 	DefaultKeyFileName     = "tls.key"
 	DefaultNatHoleSTUNAddr = "stun.easyvoip.com:3478"
+
+	// SecretKeyDataKey holds the plaintext secret key of a stcp/xtcp/sudp proxy when no kms is configured
+	SecretKeyDataKey = "secretKey"
+	// SecretKeyEncryptedDataKey holds the secret key of a stcp/xtcp/sudp proxy encrypted with a data encryption key
+	SecretKeyEncryptedDataKey = "secretKey.enc"
+	// SecretKeyEncryptedDEKDataKey holds the data encryption key wrapped by the kms
+	SecretKeyEncryptedDEKDataKey = "dek.enc"
+
+	// DefaultClientCertificateName is the name of the cert-manager Certificate issuing the client certificate
+	// of a namespace when the client certificate template has no name
+	DefaultClientCertificateName = "frp-client"
+	// ClientTLSVolumeName is the name of the volume of the namespace client certificate in the frp client pods
+	ClientTLSVolumeName = "frp-client-tls"
+	// ClientTLSMountPath is where the namespace client certificate is mounted in the frp client containers
+	ClientTLSMountPath = "/etc/frp/tls"
+	// PluginTLSVolumeName is the name of the volume of the client plugin certificate in the frp client pods
+	PluginTLSVolumeName = "frp-plugin-tls"
+	// PluginTLSMountPath is where the client plugin certificate is mounted in the frp client containers
+	PluginTLSMountPath = "/etc/frp/plugin-tls"
+	// FrpcConfigVolumeName is the name of the volume of the rendered frpc config in the frp client pods
+	FrpcConfigVolumeName = "frpc-config"
+	// FrpcConfigMountPath is where the rendered frpc config is mounted in the frp client containers
+	FrpcConfigMountPath = "/etc/frp/config"
+	// FrpcConfigFileName is the key of the rendered frpc config in its ConfigMap
+	FrpcConfigFileName = "frpc.yaml"
+	// InlineServerTokenEnv is the env var of the frp client containers holding the auth token of an inline server,
+	// the rendered frpc config reads it from the env var, the init container fills in the token of a FrpServer
+	InlineServerTokenEnv = "FRP_AUTH_TOKEN"
+	// OIDCClientSecretEnv names the env template of the oidc client secret of the FrpServer in the rendered frpc
+	// config, the init container of the frp client pods fills it in
+	OIDCClientSecretEnv = "FRP_OIDC_CLIENT_SECRET"
+	// SecretKeyEnv names the env template of the stcp/xtcp/sudp secret key in the rendered frpc config, the init
+	// container of the frp client pods fills in the decrypted key
+	SecretKeyEnv = "FRP_SECRET_KEY"
+	// HTTPUserEnv and HTTPPasswordEnv are the env vars of the frp client containers holding the credentials of
+	// the Secret selected by AnnotationHTTPAuthSecretKey
+	HTTPUserEnv     = "FRP_HTTP_USER"
+	HTTPPasswordEnv = "FRP_HTTP_PASSWORD"
+	// MultiplexerHTTPConnect is the tcpmux multiplexer tunneling the connections with http CONNECT
+	MultiplexerHTTPConnect = "httpconnect"
+
+	DefaultQUICKeepalivePeriod    = 10
+	DefaultQUICMaxIdleTimeout     = 30
+	DefaultQUICMaxIncomingStreams = 100000
+
+	DefaultTCPMuxKeepaliveInterval      = 60
+	DefaultTCPMuxMaxStreamWindowSize    = 6 * 1024 * 1024
+	DefaultTCPMuxAcceptBacklog          = 256
+	DefaultTCPMuxConnectionWriteTimeout = 10
+	MinTCPMuxMaxStreamWindowSize        = 256 * 1024
+	MaxTCPMuxMaxStreamWindowSize        = 64 * 1024 * 1024
+	MaxTCPMuxAcceptBacklog              = 65535
+	MaxTCPMuxKeepaliveInterval          = 3600
+	MaxTCPMuxConnectionWriteTimeout     = 300
 )
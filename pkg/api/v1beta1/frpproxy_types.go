@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FrpProxyPhase is the registration status of a FrpProxy
+// +enum
+type FrpProxyPhase string
+
+const (
+	// FrpProxyPhasePending means the proxy is waiting for its FrpServer or for frps to accept it
+	FrpProxyPhasePending FrpProxyPhase = "Pending"
+	// FrpProxyPhaseRunning means the proxy is registered on frps and forwards to its backend
+	FrpProxyPhaseRunning FrpProxyPhase = "Running"
+	// FrpProxyPhaseFailed means the proxy is invalid or was rejected by frps
+	FrpProxyPhaseFailed FrpProxyPhase = "Failed"
+)
+
+// FrpProxyBackend is the Service port in the namespace of the FrpProxy the proxy forwards to
+type FrpProxyBackend struct {
+	// ServiceName is the name of the Service
+	ServiceName string `json:"serviceName"`
+	// Port is the port of the Service
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+}
+
+// FrpProxyPlugin is the frp client plugin terminating the TLS of a https proxy before the backend
+type FrpProxyPlugin struct {
+	// Type is the plugin, https2http forwards plain http to the backend, https2https re-encrypts it
+	// +kubebuilder:validation:Enum=https2http;https2https
+	Type string `json:"type"`
+	// CertSecretRef is the kubernetes.io/tls Secret in the namespace of the FrpProxy holding the certificate the
+	// plugin serves, frp generates a self-signed certificate when it's not set
+	// +optional
+	CertSecretRef *v1.LocalObjectReference `json:"certSecretRef,omitempty"`
+	// HostHeaderRewrite is the host header the plugin sends to the backend
+	// +optional
+	HostHeaderRewrite string `json:"hostHeaderRewrite,omitempty"`
+	// RequestHeaders are set on the requests the plugin sends to the backend
+	// +optional
+	RequestHeaders map[string]string `json:"requestHeaders,omitempty"`
+}
+
+// FrpProxySpec defines the desired state of FrpProxy, the fields map to the frp proxy config of the same name,
+// the fields which don't apply to the proxy type are rejected. The encryption, compression, bandwidth limit and
+// health check annotations of the Services override spec.proxyDefaults of the FrpServer on a FrpProxy too.
+type FrpProxySpec struct {
+	// ServerName is the name of the FrpServer the proxy is registered on
+	ServerName string `json:"serverName"`
+	// Type is the frp proxy type
+	// +kubebuilder:validation:Enum=tcp;udp;http;https;stcp;xtcp;sudp
+	Type string `json:"type"`
+	// Backend is the Service port the proxy forwards to
+	Backend FrpProxyBackend `json:"backend"`
+	// RemotePort is the port the tcp and udp proxies listen on frps, 0 lets frps pick one
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	RemotePort int `json:"remotePort,omitempty"`
+	// CustomDomains are the domains the http and https proxies are routed by
+	// +optional
+	CustomDomains []string `json:"customDomains,omitempty"`
+	// Subdomain is the subdomain of the frps subdomain host the http and https proxies are routed by
+	// +optional
+	Subdomain string `json:"subdomain,omitempty"`
+	// Locations are the url path prefixes the http proxy is routed by
+	// +optional
+	Locations []string `json:"locations,omitempty"`
+	// HostHeaderRewrite is the host header the http proxy sends to the backend
+	// +optional
+	HostHeaderRewrite string `json:"hostHeaderRewrite,omitempty"`
+	// SecretKeyRef is the key of a Secret in the namespace of the FrpProxy holding the key the visitors of
+	// the stcp, xtcp and sudp proxies authenticate with
+	// +optional
+	SecretKeyRef *v1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// AllowUsers are the users of the visitors of the stcp, xtcp and sudp proxies besides the proxy's own,
+	// "*" allows every user
+	// +optional
+	AllowUsers []string `json:"allowUsers,omitempty"`
+	// UseEncryption overrides spec.proxyDefaults.useEncryption of the FrpServer
+	// +optional
+	UseEncryption *bool `json:"useEncryption,omitempty"`
+	// UseCompression overrides spec.proxyDefaults.useCompression of the FrpServer
+	// +optional
+	UseCompression *bool `json:"useCompression,omitempty"`
+	// Plugin terminates the TLS of the https proxy at the frp client, the backend receives the requests of the plugin
+	// +optional
+	Plugin *FrpProxyPlugin `json:"plugin,omitempty"`
+	// HTTPAuthSecretRef is the kubernetes.io/basic-auth Secret in the namespace of the FrpProxy whose user and
+	// password protect the http proxy
+	// +optional
+	HTTPAuthSecretRef *v1.LocalObjectReference `json:"httpAuthSecretRef,omitempty"`
+}
+
+// FrpProxyStatus defines the observed state of FrpProxy
+type FrpProxyStatus struct {
+	// Phase is the registration status of the proxy
+	Phase FrpProxyPhase `json:"phase,omitempty"`
+	// Reason A brief message indicating why the proxy is in this phase.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// RemoteAddr is the address frps serves the proxy on, the remote port of the tcp and udp proxies or the
+	// urls of the http and https proxies
+	// +optional
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// ObservedGeneration is the generation of the proxy which was last registered
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Server",type=string,JSONPath=`.spec.serverName`
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+//+kubebuilder:printcolumn:name="Remote-Addr",type=string,JSONPath=`.status.remoteAddr`
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// FrpProxy is the Schema for the frpproxies API, it declares a single frp proxy forwarding to a Service port.
+// Unlike the proxies derived from the Service annotations, each port and http route of a Service may be
+// published through its own proxy. The proxies are registered by the frp client the manager runs for each
+// FrpServer.
+type FrpProxy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FrpProxySpec   `json:"spec,omitempty"`
+	Status FrpProxyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FrpProxyList contains a list of FrpProxy
+type FrpProxyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FrpProxy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FrpProxy{}, &FrpProxyList{})
+}
@@ -59,9 +59,49 @@ const (
 )
 
 const (
-	ReasonInitialized          = "Initialized"
-	ReasonInitializeFailed     = "InitializeFailed"
-	ReasonGenerateConfigFailed = "GenerateConfigFailed"
+	ReasonInitialized            = "Initialized"
+	ReasonInitializeFailed       = "InitializeFailed"
+	ReasonGenerateConfigFailed   = "GenerateConfigFailed"
+	ReasonTunnelReady            = "TunnelReady"
+	ReasonTunnelNotReady         = "TunnelNotReady"
+	ReasonCredentialsFailed      = "CredentialsFailed"
+	ReasonValidationFailed       = "ValidationFailed"
+	ReasonClaimBound             = "ClaimBound"
+	ReasonClaimRejected          = "ClaimRejected"
+	ReasonRestartBudgetExhausted = "RestartBudgetExhausted"
+	ReasonWithinRestartBudget    = "WithinRestartBudget"
+	ReasonTunnelCrashLoop        = "TunnelCrashLoop"
+	ReasonAccessSynced           = "AccessSynced"
+	ReasonAccessFailed           = "AccessFailed"
+	ReasonIngressIPFailed        = "IngressIPFailed"
+	ReasonCanaryPassed           = "CanaryPassed"
+	ReasonCanaryFailed           = "CanaryFailed"
+	ReasonSTUNUnavailable        = "STUNUnavailable"
+	ReasonTunnelsDeleted         = "TunnelsDeleted"
+	ReasonTunnelsOrphaned        = "TunnelsOrphaned"
+	ReasonServerConfigChanged    = "ServerConfigChanged"
+	ReasonProvisioningFailed     = "ProvisioningFailed"
+	ReasonConformanceFailed      = "ConformanceFailed"
+	ReasonQueuedForOutage        = "QueuedForOutage"
+	ReasonNamingConflict         = "NamingConflict"
+	ReasonScheduled              = "Scheduled"
+	ReasonSchedulingFailed       = "SchedulingFailed"
+	ReasonClockSkewed            = "ClockSkewed"
+	ReasonClockInSync            = "ClockInSync"
+	ReasonClockSkewUnknown       = "ClockSkewUnknown"
+	ReasonProxyRunning           = "ProxyRunning"
+	ReasonProxyFailed            = "ProxyFailed"
+	ReasonImageChanged           = "ImageChanged"
+	ReasonProbeSucceeded         = "ProbeSucceeded"
+	ReasonProbeFailed            = "ProbeFailed"
+	ReasonServerUnreachable      = "ServerUnreachable"
+	ReasonFrpcConfigChanged      = "FrpcConfigChanged"
+	ReasonTunnelDraining         = "TunnelDraining"
+	ReasonProxyExpired           = "ProxyExpired"
+	ReasonGroupHealthy           = "GroupHealthy"
+	ReasonGroupDegraded          = "GroupDegraded"
+	ReasonStaleRoute             = "StaleRoute"
+	ReasonStaleRouteClosed       = "StaleRouteClosed"
 )
 
 // These are the valid statuses of pods.
@@ -82,6 +122,7 @@ type FrpServerAuth struct {
 	// authenticate frpc with frps. If "token" is specified - token will be
 	// read into login message. If "oidc" is specified - OIDC (Open ID Connect)
 	// token will be issued using OIDC settings. By default, this value is "token".
+	// +kubebuilder:default=token
 	Method FrpServerAuthMethod `json:"method,omitempty"`
 	// AdditionalScopes specify whether to include auth info in additional scope.
 	// Current supported scopes are: "HeartBeats", "NewWorkConns".
@@ -91,6 +132,14 @@ type FrpServerAuth struct {
 	// to the server. The server must have a matching token for authorization
 	// to succeed.  By default, this value is "".
 	Token string `json:"token,omitempty"`
+	// TokenSecretRef selects the authorization token in a Secret of SecretNamespace, it takes
+	// precedence over Token so the token doesn't have to be stored in plaintext in the FrpServer.
+	// +optional
+	TokenSecretRef *v1.SecretKeySelector `json:"tokenSecretRef,omitempty"`
+	// SecretNamespace is the namespace of the Secrets selected by tokenSecretRef and oidc.clientSecretRef,
+	// it's required when either is set since the FrpServer is cluster scoped.
+	// +optional
+	SecretNamespace string `json:"secretNamespace,omitempty"`
 	// +optional
 	OIDC *FrpServerAuthOIDC `json:"oidc,omitempty"`
 }
@@ -101,6 +150,10 @@ type FrpServerAuthOIDC struct {
 	// ClientSecret specifies the client secret to use to get a token in OIDC
 	// authentication.
 	ClientSecret string `json:"clientSecret,omitempty"`
+	// ClientSecretRef selects the client secret in a Secret of spec.auth.secretNamespace, it takes
+	// precedence over ClientSecret.
+	// +optional
+	ClientSecretRef *v1.SecretKeySelector `json:"clientSecretRef,omitempty"`
 	// Audience specifies the audience of the token in OIDC authentication.
 	Audience string `json:"audience,omitempty"`
 	// Scope specifies the scope of the token in OIDC authentication.
@@ -113,17 +166,31 @@ type FrpServerAuthOIDC struct {
 	// this field will be transfer to map[string][]string in OIDC token generator.
 	// +optional
 	AdditionalEndpointParams map[string]string `json:"additionalEndpointParams,omitempty"`
+	// ClockSkewTolerance specifies how many seconds before its reported expiry
+	// an OIDC token is considered expired, to tolerate clock skew between frpc,
+	// frps and the token endpoint. By default, this value is 30.
+	// +kubebuilder:default=30
+	// +optional
+	ClockSkewTolerance int64 `json:"clockSkewTolerance,omitempty"`
 }
 
 type FrpServerTransport struct {
 	// Protocol specifies the protocol to use when interacting with the server.
 	// Valid values are "tcp", "kcp", "quic", "websocket" and "wss". By default, this value
 	// is "tcp".
+	// +kubebuilder:default=tcp
 	Protocol FrpServerTransportProtocol `json:"protocol,omitempty"`
+	// FallbackProtocols are tried in order when connecting with Protocol fails, e.g. ["wss", "tcp"]
+	// lets a "quic" transport fall back to protocols passing restrictive networks. The protocol
+	// in use is recorded in status.activeProtocol.
+	// +optional
+	FallbackProtocols []FrpServerTransportProtocol `json:"fallbackProtocols,omitempty"`
 	// The maximum amount of time a dial to server will wait for a connect to complete.
+	// +kubebuilder:default=10
 	DialServerTimeout int64 `json:"dialServerTimeout,omitempty"`
 	// DialServerKeepAlive specifies the interval between keep-alive probes for an active network connection between frpc and frps.
 	// If negative, keep-alive probes are disabled.
+	// +kubebuilder:default=7200
 	DialServerKeepAlive int64 `json:"dialServerKeepalive,omitempty"`
 	// ConnectServerLocalIP specifies the address of the client bind when it connect to server.
 	// Note: This value only use in TCP/Websocket protocol. Not support in KCP protocol.
@@ -133,34 +200,62 @@ type FrpServerTransport struct {
 	// this value is read from the "http_proxy" environment variable.
 	ProxyURL string `json:"proxyURL,omitempty"`
 	// PoolCount specifies the number of connections the client will make to
-	// the server in advance.
+	// the server in advance. The validation login warms the whole pool and the
+	// FrpProxy objects are only reported running once their client dialed a
+	// pooled connection.
+	// +kubebuilder:default=1
 	PoolCount int `json:"poolCount,omitempty"`
 	// TCPMux toggles TCP stream multiplexing. This allows multiple requests
 	// from a client to share a single TCP connection. If this value is true,
 	// the server must have TCP multiplexing enabled as well. By default, this
 	// value is true.
+	// +kubebuilder:default=true
 	TCPMux *bool `json:"tcpMux,omitempty"`
 	// TCPMuxKeepaliveInterval specifies the keep alive interval for TCP stream multipler.
 	// If TCPMux is true, heartbeat of application layer is unnecessary because it can only rely on heartbeat in TCPMux.
+	// +kubebuilder:default=60
 	TCPMuxKeepaliveInterval int64 `json:"tcpMuxKeepaliveInterval,omitempty"`
-	// QUIC protocol options.
+	// TCPMuxMaxStreamWindowSize specifies the maximum receive window of a TCP multiplexed stream in bytes,
+	// raise it for high bandwidth-delay links. It must be between 262144 and 67108864. frpc has no such
+	// setting, so it only applies to the sessions of the manager, i.e. the FrpProxy objects and the
+	// validation login, not to the frp client pods of the Services.
+	// +kubebuilder:default=6291456
+	TCPMuxMaxStreamWindowSize int64 `json:"tcpMuxMaxStreamWindowSize,omitempty"`
+	// TCPMuxAcceptBacklog specifies the number of TCP multiplexed streams which may be waiting
+	// to be accepted. It must be between 1 and 65535. Like TCPMuxMaxStreamWindowSize it only applies
+	// to the sessions of the manager.
+	// +kubebuilder:default=256
+	TCPMuxAcceptBacklog int `json:"tcpMuxAcceptBacklog,omitempty"`
+	// TCPMuxConnectionWriteTimeout specifies how long a write to the TCP multiplexed connection
+	// may block before the session is closed, in seconds. It must be between 1 and 300. Like
+	// TCPMuxMaxStreamWindowSize it only applies to the sessions of the manager.
+	// +kubebuilder:default=10
+	TCPMuxConnectionWriteTimeout int64 `json:"tcpMuxConnectionWriteTimeout,omitempty"`
+	// QUIC protocol options. When the quic protocol is used and the options are absent, the defaults of
+	// the fields are used, they're only written to the object when the manager defaulting mode is full.
 	QUIC *FrpServerTransportQUIC `json:"quic,omitempty"`
 	// HeartBeatInterval specifies at what interval heartbeats are sent to the
 	// server, in seconds. It is not recommended to change this value. By
 	// default, this value is 30. Set negative value to disable it.
+	// +kubebuilder:default=30
 	HeartbeatInterval int64 `json:"heartbeatInterval,omitempty"`
 	// HeartBeatTimeout specifies the maximum allowed heartbeat response delay
 	// before the connection is terminated, in seconds. It is not recommended
 	// to change this value. By default, this value is 90. Set negative value to disable it.
+	// +kubebuilder:default=90
 	HeartbeatTimeout int64 `json:"heartbeatTimeout,omitempty"`
 	// TLS specifies TLS settings for the connection to the server.
+	// +kubebuilder:default={}
 	TLS FrpServerTransportTLS `json:"tls,omitempty"`
 }
 
 // FrpServerTransportQUIC the protocol options
 type FrpServerTransportQUIC struct {
-	KeepalivePeriod    int `json:"keepalivePeriod,omitempty"`
-	MaxIdleTimeout     int `json:"maxIdleTimeout,omitempty"`
+	// +kubebuilder:default=10
+	KeepalivePeriod int `json:"keepalivePeriod,omitempty"`
+	// +kubebuilder:default=30
+	MaxIdleTimeout int `json:"maxIdleTimeout,omitempty"`
+	// +kubebuilder:default=100000
 	MaxIncomingStreams int `json:"maxIncomingStreams,omitempty"`
 }
 
@@ -173,7 +268,90 @@ type FrpServerTransportTLS struct {
 	// If DisableCustomTLSFirstByte is set to false, frpc will establish a connection with frps using the
 	// first custom byte when tls is enabled.
 	// Since v0.50.0, the default value has been changed to true, and the first custom byte is disabled by default.
+	// +kubebuilder:default=true
 	DisableCustomTLSFirstByte *bool `json:"disableCustomTLSFirstByte,omitempty"`
+	// TrustedCAMode selects how the tls.ca of the tls secret verifies the server certificate. Replace trusts
+	// only its certs, Merge trusts them in addition to the system trust store. The tls.ca may hold several
+	// PEM certs.
+	// +kubebuilder:validation:Enum=Replace;Merge
+	// +kubebuilder:default=Replace
+	// +optional
+	TrustedCAMode FrpServerTrustedCAMode `json:"trustedCAMode,omitempty"`
+	// Policy restricts the TLS versions, cipher suites and curves the manager negotiates with the server, the
+	// login is refused when the server only offers settings outside the policy. In FIPS mode only FIPS-approved
+	// settings are allowed. frpc has no such settings, the frp client pods keep the defaults of their frpc.
+	// +optional
+	Policy *FrpServerTLSPolicy `json:"policy,omitempty"`
+}
+
+// FrpServerTLSPolicy restricts the TLS handshake with the server, the empty fields keep the defaults
+type FrpServerTLSPolicy struct {
+	// MinVersion is the minimum TLS version
+	// +kubebuilder:validation:Enum=VersionTLS10;VersionTLS11;VersionTLS12;VersionTLS13
+	// +optional
+	MinVersion string `json:"minVersion,omitempty"`
+	// CipherSuites are the IANA names of the enabled TLS 1.0-1.2 cipher suites, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. The TLS 1.3 cipher suites are not configurable.
+	// +optional
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+	// CurvePreferences are the elliptic curves of the ECDHE handshake in preference order
+	// +optional
+	CurvePreferences []string `json:"curvePreferences,omitempty"`
+}
+
+// FrpServerTrustedCAMode is how the trusted CA bundle of the transport tls verifies the server certificate
+// +enum
+type FrpServerTrustedCAMode string
+
+const (
+	// FrpServerTrustedCAModeReplace trusts only the certs of the CA bundle
+	FrpServerTrustedCAModeReplace FrpServerTrustedCAMode = "Replace"
+	// FrpServerTrustedCAModeMerge trusts the certs of the CA bundle and the system trust store
+	FrpServerTrustedCAModeMerge FrpServerTrustedCAMode = "Merge"
+)
+
+// FrpServerProxyDefaults are the proxy settings applied to every proxy scheduled on the FrpServer,
+// a Service overrides them with the frp-use-encryption, frp-use-compression, frp-bandwidth-limit,
+// frp-bandwidth-limit-mode and frp-health-check-* annotations.
+type FrpServerProxyDefaults struct {
+	// UseEncryption controls whether the communication of the proxies with the server is encrypted.
+	// +optional
+	UseEncryption *bool `json:"useEncryption,omitempty"`
+	// UseCompression controls whether the communication of the proxies with the server is compressed.
+	// +optional
+	UseCompression *bool `json:"useCompression,omitempty"`
+	// BandwidthLimit limits the bandwidth of each proxy, e.g. "1MB" or "512KB". Empty means no limit.
+	// +optional
+	BandwidthLimit string `json:"bandwidthLimit,omitempty"`
+	// BandwidthLimitMode specifies whether the bandwidth is limited on the client or server side.
+	// By default, this value is "client". The "server" mode needs frps v0.48.0 or later.
+	// +kubebuilder:validation:Enum=client;server
+	// +optional
+	BandwidthLimitMode string `json:"bandwidthLimitMode,omitempty"`
+	// HealthCheck is the health check of the proxy backends
+	// +optional
+	HealthCheck *FrpServerProxyHealthCheck `json:"healthCheck,omitempty"`
+}
+
+// FrpServerProxyHealthCheck is the health check frpc runs against the backend of a proxy
+type FrpServerProxyHealthCheck struct {
+	// Type specifies the protocol used for health checking, one of "tcp" or "http".
+	// +kubebuilder:validation:Enum=tcp;http
+	Type string `json:"type"`
+	// TimeoutSeconds specifies the number of seconds to wait for a health check attempt.
+	// By default, this value is 3.
+	// +optional
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// MaxFailed specifies the number of allowed failures before the proxy is stopped.
+	// By default, this value is 1.
+	// +optional
+	MaxFailed int `json:"maxFailed,omitempty"`
+	// IntervalSeconds specifies the time in seconds between health checks. By default, this value is 10.
+	// +optional
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+	// Path specifies the path the health check requests when the type is "http".
+	// +optional
+	Path string `json:"path,omitempty"`
 }
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -182,6 +360,7 @@ type FrpServerTransportTLS struct {
 // FrpServerSpec defines the desired state of FrpServer
 type FrpServerSpec struct {
 	// the auth config for current FrpServer
+	// +kubebuilder:default={}
 	Auth FrpServerAuth `json:"auth,omitempty"`
 	// User specifies a prefix for proxy names to distinguish them from other
 	// clients. If this value is not "", proxy names will automatically be
@@ -192,24 +371,179 @@ type FrpServerSpec struct {
 	ServerAddr string `json:"serverAddr,omitempty"`
 	// ServerPort specifies the port to connect to the server on. By default,
 	// this value is 7000.
+	// +kubebuilder:default=7000
 	ServerPort int `json:"serverPort,omitempty"`
 	// ExternalIPs is set for load-balancer ingress points that are DNS/IP based
 	ExternalIPs []string `json:"externalIPs,omitempty"`
+	// IPAM allocates a single stable ingress IP per Service from a pool of public IPs of the
+	// frp server, the Services publish every address of externalIPs when it's not set.
+	// +optional
+	IPAM *FrpServerIPAM `json:"ipam,omitempty"`
+	// SubDomainHost is the subdomain host configured on the frp server, http proxies
+	// are published as "{subdomain}.{subDomainHost}".
+	// +optional
+	SubDomainHost string `json:"subDomainHost,omitempty"`
+	// Domains are the ingress domains of the http and https proxies, a proxy is published on the domain
+	// selected by the frp-domain annotation of its Service, the Services without it are spread across
+	// the domains. The proxies are published on spec.subDomainHost when it's empty.
+	// +optional
+	Domains []FrpServerDomain `json:"domains,omitempty"`
 	// STUN server to help penetrate NAT hole.
+	// +kubebuilder:default="stun.easyvoip.com:3478"
 	NatHoleSTUNServer string `json:"natHoleStunServer,omitempty"`
+	// NatHoleSTUNFallbackServers are the STUN servers used for xtcp when natHoleStunServer is unavailable,
+	// the servers are probed periodically and the first available one in order is selected.
+	// +optional
+	NatHoleSTUNFallbackServers []string `json:"natHoleStunFallbackServers,omitempty"`
 	// DNSServer specifies a DNS server address for FRPC to use. If this value
 	// is "", the default DNS will be used.
 	DNSServer string `json:"dnsServer,omitempty"`
 	// LoginFailExit controls whether the client should exit after a
 	// failed login attempt. If false, the client will retry until a login
 	// attempt succeeds. By default, this value is true.
-	LoginFailExit *bool              `json:"loginFailExit,omitempty"`
-	Transport     FrpServerTransport `json:"transport,omitempty"`
+	// +kubebuilder:default=true
+	LoginFailExit *bool `json:"loginFailExit,omitempty"`
+	// +kubebuilder:default={}
+	Transport FrpServerTransport `json:"transport,omitempty"`
 	// UDPPacketSize specifies the udp packet size
 	// By default, this value is 1500
+	// +kubebuilder:default=1500
 	UDPPacketSize int64 `json:"udpPacketSize,omitempty"`
 	// Client metadata info
 	Metadatas map[string]string `json:"metadatas,omitempty"`
+	// ProxyDefaults are applied to every proxy scheduled on the FrpServer unless overridden by
+	// the annotations of the Service.
+	// +optional
+	ProxyDefaults *FrpServerProxyDefaults `json:"proxyDefaults,omitempty"`
+	// AllowedProxyTypes are the proxy types the services may select on the FrpServer, e.g. because the
+	// frps deployment disables the vhost or tcpmux features. Empty allows every type permitted by the manager.
+	// +optional
+	AllowedProxyTypes []string `json:"allowedProxyTypes,omitempty"`
+	// ClaimPolicy bounds the namespaced FrpServerClaims which may bind to the FrpServer,
+	// no claim may bind when it's not set.
+	// +optional
+	ClaimPolicy *FrpServerClaimPolicy `json:"claimPolicy,omitempty"`
+	// VaultRef references a HashiCorp Vault secret holding the frp credentials, when set
+	// the token, OIDC client secret and transport tls material are read from Vault instead
+	// of the inline spec and Secrets.
+	// +optional
+	VaultRef *FrpServerVaultRef `json:"vaultRef,omitempty"`
+	// DeletionPolicy selects what happens to the frp client pods of the Services scheduled on the FrpServer
+	// once it's deleted. Delete tears the tunnels down, Orphan keeps the pods running with their last config.
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy FrpServerDeletionPolicy `json:"deletionPolicy,omitempty"`
+	// ClockSkew enables the detection of the clock skew between the frps host and the manager, the auth
+	// timestamps of the frp messages are rejected by the servers enforcing a window when the clocks drift.
+	// +optional
+	ClockSkew *FrpServerClockSkew `json:"clockSkew,omitempty"`
+	// RouteGC enables the garbage collection of the http and https routes registered on the frps for Services
+	// and FrpProxies which no longer exist or moved to another server, the routes are read from the frps dashboard.
+	// +optional
+	RouteGC *FrpServerRouteGC `json:"routeGC,omitempty"`
+}
+
+// FrpServerRouteGC configures the access to the frps dashboard the stale routes of a FrpServer are read from, the
+// tunnel readiness gate of the backend pods checks the proxies of their services are online in it too
+type FrpServerRouteGC struct {
+	// DashboardURL is the address of the frps dashboard, e.g. "http://frps.example.com:7500"
+	DashboardURL string `json:"dashboardURL"`
+	// CredentialsSecretRef is the kubernetes.io/basic-auth Secret holding the user and password of the dashboard
+	// +optional
+	CredentialsSecretRef *v1.SecretReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// FrpServerDomain is an ingress domain of the http and https proxies of a FrpServer
+type FrpServerDomain struct {
+	// Name is the domain
+	Name string `json:"name"`
+	// Wildcard publishes the proxies on the subdomains of the domain as "{subdomain}.{name}", it needs a
+	// wildcard DNS record and certificate. A proxy is published on the domain itself otherwise.
+	// +optional
+	Wildcard bool `json:"wildcard,omitempty"`
+	// TLSSecretRef is the kubernetes.io/tls Secret holding the certificate of the domain, it's used by
+	// the https proxies published on the domain.
+	// +optional
+	TLSSecretRef *v1.SecretReference `json:"tlsSecretRef,omitempty"`
+}
+
+// FrpServerDeletionPolicy is what happens to the tunnels of a FrpServer once it's deleted
+// +enum
+type FrpServerDeletionPolicy string
+
+const (
+	// FrpServerDeletionPolicyDelete deletes the frp client pods of the Services scheduled on the FrpServer
+	FrpServerDeletionPolicyDelete FrpServerDeletionPolicy = "Delete"
+	// FrpServerDeletionPolicyOrphan keeps the frp client pods running with their last config
+	FrpServerDeletionPolicyOrphan FrpServerDeletionPolicy = "Orphan"
+)
+
+// FrpServerIPAMType is the kind of pool the ingress IPs of the Services are allocated from
+// +enum
+type FrpServerIPAMType string
+
+const (
+	// FrpServerIPAMTypeStatic allocates the ingress IPs from the addresses of the pool
+	FrpServerIPAMTypeStatic FrpServerIPAMType = "Static"
+	// FrpServerIPAMTypeCIDR allocates the ingress IPs from the host addresses of CIDR blocks
+	FrpServerIPAMTypeCIDR FrpServerIPAMType = "CIDR"
+	// FrpServerIPAMTypeWebhook delegates the allocation of the ingress IPs to an external IPAM
+	FrpServerIPAMTypeWebhook FrpServerIPAMType = "Webhook"
+)
+
+// FrpServerIPAM configures the allocation of the ingress IPs of the Services scheduled on a FrpServer
+type FrpServerIPAM struct {
+	// Type is the kind of pool the ingress IPs are allocated from
+	// +kubebuilder:validation:Enum=Static;CIDR;Webhook
+	Type FrpServerIPAMType `json:"type"`
+	// Addresses are the ingress IPs of the Static pool
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+	// CIDRs are the blocks of the CIDR pool, the network and broadcast addresses of IPv4 blocks are skipped
+	// +optional
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Webhook is the external IPAM of the Webhook pool
+	// +optional
+	Webhook *FrpServerIPAMWebhook `json:"webhook,omitempty"`
+}
+
+// FrpServerIPAMWebhook is an external IPAM, the manager posts a json object {"server", "namespace",
+// "service", "ip"} to {url}/allocate and {url}/release. The allocate response is a json object {"ip"}
+// and should return the same ip for the same Service until it's released.
+type FrpServerIPAMWebhook struct {
+	// URL is the base url of the external IPAM
+	URL string `json:"url"`
+	// TimeoutSeconds bounds the duration of a request to the external IPAM. Defaults to 10 seconds.
+	// +optional
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// FrpServerClockSkew configures the clock skew detection of a FrpServer. The frp messages carry no server
+// time, so the time of the frps host is read from the Date header of an http endpoint it serves.
+type FrpServerClockSkew struct {
+	// ReferenceURL is an http or https endpoint served by the frps host, e.g. its dashboard or vhost http port,
+	// any response carrying a Date header will do
+	ReferenceURL string `json:"referenceURL"`
+	// ThresholdSeconds is the skew above which the ClockSkewed condition is set
+	// +kubebuilder:default=30
+	// +optional
+	ThresholdSeconds int64 `json:"thresholdSeconds,omitempty"`
+}
+
+// FrpServerVaultRef references a secret stored in HashiCorp Vault
+type FrpServerVaultRef struct {
+	// Path is the full path of the secret to read, e.g. "secret/data/frp/my-server".
+	// KV version 1 and version 2 secret engines are both supported.
+	Path string `json:"path"`
+	// Role is the Vault role to login with when AuthMethod is kubernetes
+	// +optional
+	Role string `json:"role,omitempty"`
+	// AuthMethod is the method used to login to Vault, one of kubernetes or token.
+	// The token method reads the Vault token from the VAULT_TOKEN environment variable.
+	// +kubebuilder:validation:Enum=kubernetes;token
+	// +kubebuilder:default=kubernetes
+	AuthMethod string `json:"authMethod,omitempty"`
 }
 
 // ServiceReference represents a Service Reference. It has enough information to retrieve service
@@ -238,11 +572,130 @@ type FrpServerStatus struct {
 	// Reason A brief CamelCase message indicating details about why the pod is in this state.
 	// +optional
 	Reason string `json:"reason,omitempty"`
+	// LastProbeTime is the time of the last login handshake with the frps
+	// +optional
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	// ActiveProtocol is the transport protocol which last connected to the server successfully
+	// +optional
+	ActiveProtocol FrpServerTransportProtocol `json:"activeProtocol,omitempty"`
+	// DetectedUDPPacketSize is the udp packet size detected by the path MTU probe of the quic and kcp
+	// transports, the frp client configs use it instead of spec.udpPacketSize when it is smaller.
+	// +optional
+	DetectedUDPPacketSize int64 `json:"detectedUDPPacketSize,omitempty"`
+	// ServerVersion is the frps version reported by the server on the last successful login, the
+	// features which need a later frps version are rejected
+	// +optional
+	ServerVersion string `json:"serverVersion,omitempty"`
+	// NatHoleSTUNServer is the available STUN server selected for xtcp from spec.natHoleStunServer
+	// and spec.natHoleStunFallbackServers
+	// +optional
+	NatHoleSTUNServer string `json:"natHoleStunServer,omitempty"`
+	// NatHoleSTUNServers is the availability of the STUN servers observed by the last probe
+	// +optional
+	NatHoleSTUNServers []FrpServerSTUNServerStatus `json:"natHoleStunServers,omitempty"`
+	// Conformance is the summary of the last conformance run, it's only stored when the FrpServer has
+	// the annotation frp.gofrp.io/conformance set to "true"
+	// +optional
+	Conformance *FrpServerConformance `json:"conformance,omitempty"`
+	// Capabilities are the optional frps features detected by probing the server once per generation
+	// +optional
+	Capabilities *FrpServerCapabilities `json:"capabilities,omitempty"`
+	// QueuedChanges is the number of services whose proxy changes are held back while the FrpServer is
+	// Unhealthy, they're applied in order once it's healthy again
+	// +optional
+	QueuedChanges int32 `json:"queuedChanges,omitempty"`
+	// Proxies are the proxies of the FrpProxy objects the manager registered on the FrpServer, they're
+	// refreshed on every reconcile of the FrpServer, i.e. at least at the health probe interval
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Proxies []FrpServerProxyStatus `json:"proxies,omitempty"`
+	// History is the last 10 phase transitions of the FrpServer, the oldest first, so flapping is visible
+	// without a metrics stack
+	// +optional
+	// +listType=atomic
+	History []FrpServerPhaseTransition `json:"history,omitempty"`
 	// Services is a list of all services
 	// +optional
 	ServiceReferences []ServiceReference `json:"serviceReferences,omitempty"`
 }
 
+// FrpServerPhaseTransition is a transition of the FrpServer to another phase
+type FrpServerPhaseTransition struct {
+	// Phase is the phase the FrpServer transitioned to
+	Phase FrpServerPhase `json:"phase"`
+	// Reason is the reason of the FrpServer once it transitioned
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Time is when the FrpServer transitioned
+	Time metav1.Time `json:"time"`
+}
+
+// FrpServerProxyStatus is the state of a proxy the manager registered on the FrpServer
+type FrpServerProxyStatus struct {
+	// Name is the name of the proxy on the frps
+	Name string `json:"name"`
+	// FrpProxy is the "{namespace}/{name}" of the FrpProxy the proxy is registered for
+	FrpProxy string `json:"frpProxy"`
+	// Type is the type of the proxy
+	Type string `json:"type"`
+	// RemoteAddr is the address the frps exposes the proxy at
+	// +optional
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// State is the phase of the proxy in the frp client, one of new, wait start, start error, running,
+	// check failed or closed
+	State string `json:"state"`
+	// LastError is the last error the proxy failed to start or was rejected by the frps with
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+// FrpServerSTUNServerStatus is the availability of a STUN server of the FrpServer
+type FrpServerSTUNServerStatus struct {
+	// Address is the address of the STUN server
+	Address string `json:"address"`
+	// Available is whether the STUN server answered the last binding request
+	Available bool `json:"available"`
+	// Message describes why the STUN server is unavailable
+	// +optional
+	Message string `json:"message,omitempty"`
+	// LastProbeTime is the time the STUN server was last probed
+	// +optional
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty"`
+}
+
+// FrpServerConformance is the summary of a conformance run against the frps of the FrpServer
+type FrpServerConformance struct {
+	// ObservedGeneration is the generation of the FrpServer the checks ran against
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// Passed is the number of passed checks
+	Passed int32 `json:"passed"`
+	// Total is the number of checks
+	Total int32 `json:"total"`
+	// Failed is the names of the failed or skipped checks
+	// +optional
+	Failed []string `json:"failed,omitempty"`
+	// Message is the summary of the run
+	// +optional
+	Message string `json:"message,omitempty"`
+	// LastRunTime is the time the checks last ran
+	// +optional
+	LastRunTime metav1.Time `json:"lastRunTime,omitempty"`
+}
+
+// FrpServerCapabilities are the optional frps features detected by probing the frps of the FrpServer
+type FrpServerCapabilities struct {
+	// ObservedGeneration is the generation of the FrpServer the server was probed at
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// TCPMuxHTTPConnect is whether frps registers tcpmux proxies multiplexed with httpconnect, i.e. its
+	// tcpmuxHTTPConnectPort is set. It's unset when the probe was inconclusive.
+	// +optional
+	TCPMuxHTTPConnect *bool `json:"tcpmuxHTTPConnect,omitempty"`
+	// LastProbeTime is the time the server was last probed
+	// +optional
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty"`
+}
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster
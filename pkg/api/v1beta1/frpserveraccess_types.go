@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FrpServerAccessPhase is the sync status of a FrpServerAccess
+// +enum
+type FrpServerAccessPhase string
+
+const (
+	// FrpServerAccessPhasePending means the access has not been synced yet
+	FrpServerAccessPhasePending FrpServerAccessPhase = "Pending"
+	// FrpServerAccessPhaseSynced means the users of the access are enforced by the server plugin
+	FrpServerAccessPhaseSynced FrpServerAccessPhase = "Synced"
+	// FrpServerAccessPhaseFailed means the access is invalid and is not enforced
+	FrpServerAccessPhaseFailed FrpServerAccessPhase = "Failed"
+)
+
+// FrpServerAccessUser is a frp user allowed to log in to the FrpServer and the limits of its proxies
+type FrpServerAccessUser struct {
+	// Name is the frp user, the user field of the frp client config
+	Name string `json:"name"`
+	// Metadatas must all be sent with the same values by the frp clients logging in as the user
+	// +optional
+	Metadatas map[string]string `json:"metadatas,omitempty"`
+	// MaxProxies bounds the number of proxies the user may register at the same time, zero means unlimited
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxProxies int `json:"maxProxies,omitempty"`
+	// AllowedProxyTypes are the proxy types the user may register, empty allows every type
+	// +optional
+	AllowedProxyTypes []string `json:"allowedProxyTypes,omitempty"`
+	// AllowedPorts are the remote ports the tcp and udp proxies of the user may bind, single ports
+	// or ranges like "6000-6100", empty allows every port
+	// +optional
+	AllowedPorts []string `json:"allowedPorts,omitempty"`
+}
+
+// FrpServerAccessSpec defines the desired state of FrpServerAccess
+type FrpServerAccessSpec struct {
+	// ServerName is the name of the FrpServer whose server plugin enforces the access
+	ServerName string `json:"serverName"`
+	// DenyUnknownUsers rejects the logins of the users which are not listed in users
+	// +optional
+	DenyUnknownUsers bool `json:"denyUnknownUsers,omitempty"`
+	// Users are the frp users allowed to log in to the FrpServer
+	// +optional
+	Users []FrpServerAccessUser `json:"users,omitempty"`
+}
+
+// FrpServerAccessStatus defines the observed state of FrpServerAccess
+type FrpServerAccessStatus struct {
+	// Phase is the sync status of the access
+	Phase FrpServerAccessPhase `json:"phase,omitempty"`
+	// Reason A brief message indicating why the access is in this phase.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Users is the number of users enforced by the server plugin
+	// +optional
+	Users int `json:"users,omitempty"`
+	// ObservedGeneration is the generation of the access which was last synced
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Server",type=string,JSONPath=`.spec.serverName`
+//+kubebuilder:printcolumn:name="Users",type=integer,JSONPath=`.status.users`
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// FrpServerAccess is the Schema for the frpserveraccesses API, it declares the frp users allowed to log
+// in to a self-managed frps and the limits of their proxies. The users are enforced by the frps server
+// plugin served by the manager, so the server-side ACLs stay consistent with the cluster.
+type FrpServerAccess struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FrpServerAccessSpec   `json:"spec,omitempty"`
+	Status FrpServerAccessStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FrpServerAccessList contains a list of FrpServerAccess
+type FrpServerAccessList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FrpServerAccess `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FrpServerAccess{}, &FrpServerAccessList{})
+}
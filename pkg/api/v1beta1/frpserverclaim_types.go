@@ -0,0 +1,109 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FrpServerClaimPhase is the binding status of a FrpServerClaim
+// +enum
+type FrpServerClaimPhase string
+
+const (
+	// FrpServerClaimPhasePending means the claim has not been checked against the FrpServer yet
+	FrpServerClaimPhasePending FrpServerClaimPhase = "Pending"
+	// FrpServerClaimPhaseBound means the claim is bound to the FrpServer and may be used by services
+	FrpServerClaimPhaseBound FrpServerClaimPhase = "Bound"
+	// FrpServerClaimPhaseRejected means the FrpServer does not exist or its claim policy rejects the claim
+	FrpServerClaimPhaseRejected FrpServerClaimPhase = "Rejected"
+)
+
+// FrpServerClaimPolicy bounds the FrpServerClaims which may bind to a FrpServer
+type FrpServerClaimPolicy struct {
+	// AllowedNamespaces are the namespaces whose FrpServerClaims may bind to the FrpServer,
+	// "*" allows every namespace.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// AllowedMetadataKeys are the metadata keys a FrpServerClaim may set, the claim is rejected
+	// when it sets any other key.
+	// +optional
+	AllowedMetadataKeys []string `json:"allowedMetadataKeys,omitempty"`
+}
+
+// FrpServerClaimSpec defines the desired state of FrpServerClaim
+type FrpServerClaimSpec struct {
+	// ServerName is the name of the cluster-scoped FrpServer the claim binds to
+	ServerName string `json:"serverName"`
+	// SubdomainPrefix is prefixed to the subdomain of the http proxies of services using the claim,
+	// they are published as "{subdomainPrefix}-{subdomain}.{subDomainHost}". It must be unique
+	// among the claims bound to the FrpServer.
+	// +optional
+	SubdomainPrefix string `json:"subdomainPrefix,omitempty"`
+	// Metadatas are merged into the client metadata of the FrpServer for services using the claim
+	// +optional
+	Metadatas map[string]string `json:"metadatas,omitempty"`
+}
+
+// FrpServerClaimStatus defines the observed state of FrpServerClaim
+type FrpServerClaimStatus struct {
+	// Phase is the binding status of the claim
+	Phase FrpServerClaimPhase `json:"phase,omitempty"`
+	// Reason A brief message indicating why the claim is in this phase.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// ObservedGeneration is the generation of the claim which was last checked
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Server",type=string,JSONPath=`.spec.serverName`
+//+kubebuilder:printcolumn:name="Subdomain-Prefix",type=string,JSONPath=`.spec.subdomainPrefix`
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// FrpServerClaim is the Schema for the frpserverclaims API, it lets the services of a namespace
+// use a cluster-scoped FrpServer within the bounds of its claim policy.
+type FrpServerClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FrpServerClaimSpec   `json:"spec,omitempty"`
+	Status FrpServerClaimStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FrpServerClaimList contains a list of FrpServerClaim
+type FrpServerClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FrpServerClaim `json:"items"`
+}
+
+// Subdomain returns the subdomain an http proxy of a service using the claim is published on
+func (c *FrpServerClaim) Subdomain(subdomain string) string {
+	if c.Spec.SubdomainPrefix == "" || subdomain == "" {
+		return subdomain
+	}
+	return c.Spec.SubdomainPrefix + "-" + subdomain
+}
+
+func init() {
+	SchemeBuilder.Register(&FrpServerClaim{}, &FrpServerClaimList{})
+}
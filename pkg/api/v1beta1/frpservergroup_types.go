@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FrpServerGroupPolicy is how the services of a FrpServerGroup are placed on its members
+// +enum
+type FrpServerGroupPolicy string
+
+const (
+	// FrpServerGroupPolicyFailover places the service on one healthy member, it's moved to another healthy
+	// member once its member turns unhealthy
+	FrpServerGroupPolicyFailover FrpServerGroupPolicy = "Failover"
+	// FrpServerGroupPolicyFanOut places the service on one healthy member and registers its proxies on every
+	// other healthy member through FrpProxy objects
+	FrpServerGroupPolicyFanOut FrpServerGroupPolicy = "FanOut"
+)
+
+// FrpServerGroupPhase is the aggregated health of the members of a FrpServerGroup
+// +enum
+type FrpServerGroupPhase string
+
+const (
+	// FrpServerGroupPhaseHealthy means all members are healthy
+	FrpServerGroupPhaseHealthy FrpServerGroupPhase = "Healthy"
+	// FrpServerGroupPhaseDegraded means some members are unhealthy or don't exist
+	FrpServerGroupPhaseDegraded FrpServerGroupPhase = "Degraded"
+	// FrpServerGroupPhaseUnhealthy means no member is healthy
+	FrpServerGroupPhaseUnhealthy FrpServerGroupPhase = "Unhealthy"
+)
+
+// FrpServerGroupSpec defines the desired state of FrpServerGroup
+type FrpServerGroupSpec struct {
+	// Servers are the names of the member FrpServers, the services are placed on the first healthy member
+	// +kubebuilder:validation:MinItems=1
+	Servers []string `json:"servers"`
+	// Policy is how the services of the group are placed on its members
+	// +kubebuilder:validation:Enum=Failover;FanOut
+	// +kubebuilder:default=Failover
+	// +optional
+	Policy FrpServerGroupPolicy `json:"policy,omitempty"`
+}
+
+// FrpServerGroupMemberStatus is the health of a member of the FrpServerGroup
+type FrpServerGroupMemberStatus struct {
+	// Name is the name of the FrpServer
+	Name string `json:"name"`
+	// Phase is the phase of the FrpServer, empty when it doesn't exist
+	// +optional
+	Phase FrpServerPhase `json:"phase,omitempty"`
+}
+
+// FrpServerGroupStatus defines the observed state of FrpServerGroup
+type FrpServerGroupStatus struct {
+	// Phase is the aggregated health of the members
+	Phase FrpServerGroupPhase `json:"phase,omitempty"`
+	// Reason A brief message indicating why the group is in this phase.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// HealthyMembers is the number of healthy members
+	// +optional
+	HealthyMembers int `json:"healthyMembers,omitempty"`
+	// Members are the health of the members in the order of spec.servers
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Members []FrpServerGroupMemberStatus `json:"members,omitempty"`
+	// ObservedGeneration is the generation of the group which was last aggregated
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Policy",type=string,JSONPath=`.spec.policy`
+//+kubebuilder:printcolumn:name="Healthy",type=integer,JSONPath=`.status.healthyMembers`
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// FrpServerGroup is the Schema for the frpservergroups API, it groups FrpServer objects into a single
+// logical target of the services. The services reference it with the frp.gofrp.io/server-group annotation
+// and are placed on its healthy members according to spec.policy.
+type FrpServerGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FrpServerGroupSpec   `json:"spec,omitempty"`
+	Status FrpServerGroupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FrpServerGroupList contains a list of FrpServerGroup
+type FrpServerGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FrpServerGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FrpServerGroup{}, &FrpServerGroupList{})
+}
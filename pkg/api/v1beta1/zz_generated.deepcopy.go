@@ -26,6 +26,178 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpProxy) DeepCopyInto(out *FrpProxy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpProxy.
+func (in *FrpProxy) DeepCopy() *FrpProxy {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpProxy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpProxy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpProxyBackend) DeepCopyInto(out *FrpProxyBackend) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpProxyBackend.
+func (in *FrpProxyBackend) DeepCopy() *FrpProxyBackend {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpProxyBackend)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpProxyList) DeepCopyInto(out *FrpProxyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FrpProxy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpProxyList.
+func (in *FrpProxyList) DeepCopy() *FrpProxyList {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpProxyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpProxyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpProxyPlugin) DeepCopyInto(out *FrpProxyPlugin) {
+	*out = *in
+	if in.CertSecretRef != nil {
+		in, out := &in.CertSecretRef, &out.CertSecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.RequestHeaders != nil {
+		in, out := &in.RequestHeaders, &out.RequestHeaders
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpProxyPlugin.
+func (in *FrpProxyPlugin) DeepCopy() *FrpProxyPlugin {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpProxyPlugin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpProxySpec) DeepCopyInto(out *FrpProxySpec) {
+	*out = *in
+	out.Backend = in.Backend
+	if in.CustomDomains != nil {
+		in, out := &in.CustomDomains, &out.CustomDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Locations != nil {
+		in, out := &in.Locations, &out.Locations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowUsers != nil {
+		in, out := &in.AllowUsers, &out.AllowUsers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UseEncryption != nil {
+		in, out := &in.UseEncryption, &out.UseEncryption
+		*out = new(bool)
+		**out = **in
+	}
+	if in.UseCompression != nil {
+		in, out := &in.UseCompression, &out.UseCompression
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Plugin != nil {
+		in, out := &in.Plugin, &out.Plugin
+		*out = new(FrpProxyPlugin)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTPAuthSecretRef != nil {
+		in, out := &in.HTTPAuthSecretRef, &out.HTTPAuthSecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpProxySpec.
+func (in *FrpProxySpec) DeepCopy() *FrpProxySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpProxySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpProxyStatus) DeepCopyInto(out *FrpProxyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpProxyStatus.
+func (in *FrpProxyStatus) DeepCopy() *FrpProxyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpProxyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FrpServer) DeepCopyInto(out *FrpServer) {
 	*out = *in
@@ -35,18 +207,428 @@ func (in *FrpServer) DeepCopyInto(out *FrpServer) {
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServer.
-func (in *FrpServer) DeepCopy() *FrpServer {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServer.
+func (in *FrpServer) DeepCopy() *FrpServer {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpServer) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAccess) DeepCopyInto(out *FrpServerAccess) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAccess.
+func (in *FrpServerAccess) DeepCopy() *FrpServerAccess {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAccess)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpServerAccess) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAccessList) DeepCopyInto(out *FrpServerAccessList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FrpServerAccess, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAccessList.
+func (in *FrpServerAccessList) DeepCopy() *FrpServerAccessList {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAccessList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpServerAccessList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAccessSpec) DeepCopyInto(out *FrpServerAccessSpec) {
+	*out = *in
+	if in.Users != nil {
+		in, out := &in.Users, &out.Users
+		*out = make([]FrpServerAccessUser, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAccessSpec.
+func (in *FrpServerAccessSpec) DeepCopy() *FrpServerAccessSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAccessSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAccessStatus) DeepCopyInto(out *FrpServerAccessStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAccessStatus.
+func (in *FrpServerAccessStatus) DeepCopy() *FrpServerAccessStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAccessStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAccessUser) DeepCopyInto(out *FrpServerAccessUser) {
+	*out = *in
+	if in.Metadatas != nil {
+		in, out := &in.Metadatas, &out.Metadatas
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AllowedProxyTypes != nil {
+		in, out := &in.AllowedProxyTypes, &out.AllowedProxyTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedPorts != nil {
+		in, out := &in.AllowedPorts, &out.AllowedPorts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAccessUser.
+func (in *FrpServerAccessUser) DeepCopy() *FrpServerAccessUser {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAccessUser)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAuth) DeepCopyInto(out *FrpServerAuth) {
+	*out = *in
+	if in.AdditionalScopes != nil {
+		in, out := &in.AdditionalScopes, &out.AdditionalScopes
+		*out = make([]FrpServerAuthScope, len(*in))
+		copy(*out, *in)
+	}
+	if in.TokenSecretRef != nil {
+		in, out := &in.TokenSecretRef, &out.TokenSecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OIDC != nil {
+		in, out := &in.OIDC, &out.OIDC
+		*out = new(FrpServerAuthOIDC)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAuth.
+func (in *FrpServerAuth) DeepCopy() *FrpServerAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerAuthOIDC) DeepCopyInto(out *FrpServerAuthOIDC) {
+	*out = *in
+	if in.ClientSecretRef != nil {
+		in, out := &in.ClientSecretRef, &out.ClientSecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdditionalEndpointParams != nil {
+		in, out := &in.AdditionalEndpointParams, &out.AdditionalEndpointParams
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAuthOIDC.
+func (in *FrpServerAuthOIDC) DeepCopy() *FrpServerAuthOIDC {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerAuthOIDC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerCapabilities) DeepCopyInto(out *FrpServerCapabilities) {
+	*out = *in
+	if in.TCPMuxHTTPConnect != nil {
+		in, out := &in.TCPMuxHTTPConnect, &out.TCPMuxHTTPConnect
+		*out = new(bool)
+		**out = **in
+	}
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerCapabilities.
+func (in *FrpServerCapabilities) DeepCopy() *FrpServerCapabilities {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerCapabilities)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerClaim) DeepCopyInto(out *FrpServerClaim) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerClaim.
+func (in *FrpServerClaim) DeepCopy() *FrpServerClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpServerClaim) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerClaimList) DeepCopyInto(out *FrpServerClaimList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FrpServerClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerClaimList.
+func (in *FrpServerClaimList) DeepCopy() *FrpServerClaimList {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerClaimList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpServerClaimList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerClaimPolicy) DeepCopyInto(out *FrpServerClaimPolicy) {
+	*out = *in
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedMetadataKeys != nil {
+		in, out := &in.AllowedMetadataKeys, &out.AllowedMetadataKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerClaimPolicy.
+func (in *FrpServerClaimPolicy) DeepCopy() *FrpServerClaimPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerClaimPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerClaimSpec) DeepCopyInto(out *FrpServerClaimSpec) {
+	*out = *in
+	if in.Metadatas != nil {
+		in, out := &in.Metadatas, &out.Metadatas
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerClaimSpec.
+func (in *FrpServerClaimSpec) DeepCopy() *FrpServerClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerClaimStatus) DeepCopyInto(out *FrpServerClaimStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerClaimStatus.
+func (in *FrpServerClaimStatus) DeepCopy() *FrpServerClaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerClaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerClockSkew) DeepCopyInto(out *FrpServerClockSkew) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerClockSkew.
+func (in *FrpServerClockSkew) DeepCopy() *FrpServerClockSkew {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerClockSkew)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerConformance) DeepCopyInto(out *FrpServerConformance) {
+	*out = *in
+	if in.Failed != nil {
+		in, out := &in.Failed, &out.Failed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastRunTime.DeepCopyInto(&out.LastRunTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerConformance.
+func (in *FrpServerConformance) DeepCopy() *FrpServerConformance {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerConformance)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerDomain) DeepCopyInto(out *FrpServerDomain) {
+	*out = *in
+	if in.TLSSecretRef != nil {
+		in, out := &in.TLSSecretRef, &out.TLSSecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerDomain.
+func (in *FrpServerDomain) DeepCopy() *FrpServerDomain {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerDomain)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerGroup) DeepCopyInto(out *FrpServerGroup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerGroup.
+func (in *FrpServerGroup) DeepCopy() *FrpServerGroup {
 	if in == nil {
 		return nil
 	}
-	out := new(FrpServer)
+	out := new(FrpServerGroup)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *FrpServer) DeepCopyObject() runtime.Object {
+func (in *FrpServerGroup) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -54,48 +636,133 @@ func (in *FrpServer) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *FrpServerAuth) DeepCopyInto(out *FrpServerAuth) {
+func (in *FrpServerGroupList) DeepCopyInto(out *FrpServerGroupList) {
 	*out = *in
-	if in.AdditionalScopes != nil {
-		in, out := &in.AdditionalScopes, &out.AdditionalScopes
-		*out = make([]FrpServerAuthScope, len(*in))
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FrpServerGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerGroupList.
+func (in *FrpServerGroupList) DeepCopy() *FrpServerGroupList {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerGroupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FrpServerGroupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerGroupMemberStatus) DeepCopyInto(out *FrpServerGroupMemberStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerGroupMemberStatus.
+func (in *FrpServerGroupMemberStatus) DeepCopy() *FrpServerGroupMemberStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerGroupMemberStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerGroupSpec) DeepCopyInto(out *FrpServerGroupSpec) {
+	*out = *in
+	if in.Servers != nil {
+		in, out := &in.Servers, &out.Servers
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
-	if in.OIDC != nil {
-		in, out := &in.OIDC, &out.OIDC
-		*out = new(FrpServerAuthOIDC)
-		(*in).DeepCopyInto(*out)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerGroupSpec.
+func (in *FrpServerGroupSpec) DeepCopy() *FrpServerGroupSpec {
+	if in == nil {
+		return nil
 	}
+	out := new(FrpServerGroupSpec)
+	in.DeepCopyInto(out)
+	return out
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAuth.
-func (in *FrpServerAuth) DeepCopy() *FrpServerAuth {
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerGroupStatus) DeepCopyInto(out *FrpServerGroupStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]FrpServerGroupMemberStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerGroupStatus.
+func (in *FrpServerGroupStatus) DeepCopy() *FrpServerGroupStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(FrpServerAuth)
+	out := new(FrpServerGroupStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *FrpServerAuthOIDC) DeepCopyInto(out *FrpServerAuthOIDC) {
+func (in *FrpServerIPAM) DeepCopyInto(out *FrpServerIPAM) {
 	*out = *in
-	if in.AdditionalEndpointParams != nil {
-		in, out := &in.AdditionalEndpointParams, &out.AdditionalEndpointParams
-		*out = make(map[string]string, len(*in))
-		for key, val := range *in {
-			(*out)[key] = val
-		}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CIDRs != nil {
+		in, out := &in.CIDRs, &out.CIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Webhook != nil {
+		in, out := &in.Webhook, &out.Webhook
+		*out = new(FrpServerIPAMWebhook)
+		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerAuthOIDC.
-func (in *FrpServerAuthOIDC) DeepCopy() *FrpServerAuthOIDC {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerIPAM.
+func (in *FrpServerIPAM) DeepCopy() *FrpServerIPAM {
 	if in == nil {
 		return nil
 	}
-	out := new(FrpServerAuthOIDC)
+	out := new(FrpServerIPAM)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerIPAMWebhook) DeepCopyInto(out *FrpServerIPAMWebhook) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerIPAMWebhook.
+func (in *FrpServerIPAMWebhook) DeepCopy() *FrpServerIPAMWebhook {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerIPAMWebhook)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -132,6 +799,118 @@ func (in *FrpServerList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerPhaseTransition) DeepCopyInto(out *FrpServerPhaseTransition) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerPhaseTransition.
+func (in *FrpServerPhaseTransition) DeepCopy() *FrpServerPhaseTransition {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerPhaseTransition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerProxyDefaults) DeepCopyInto(out *FrpServerProxyDefaults) {
+	*out = *in
+	if in.UseEncryption != nil {
+		in, out := &in.UseEncryption, &out.UseEncryption
+		*out = new(bool)
+		**out = **in
+	}
+	if in.UseCompression != nil {
+		in, out := &in.UseCompression, &out.UseCompression
+		*out = new(bool)
+		**out = **in
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(FrpServerProxyHealthCheck)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerProxyDefaults.
+func (in *FrpServerProxyDefaults) DeepCopy() *FrpServerProxyDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerProxyDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerProxyHealthCheck) DeepCopyInto(out *FrpServerProxyHealthCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerProxyHealthCheck.
+func (in *FrpServerProxyHealthCheck) DeepCopy() *FrpServerProxyHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerProxyHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerProxyStatus) DeepCopyInto(out *FrpServerProxyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerProxyStatus.
+func (in *FrpServerProxyStatus) DeepCopy() *FrpServerProxyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerProxyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerRouteGC) DeepCopyInto(out *FrpServerRouteGC) {
+	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerRouteGC.
+func (in *FrpServerRouteGC) DeepCopy() *FrpServerRouteGC {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerRouteGC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerSTUNServerStatus) DeepCopyInto(out *FrpServerSTUNServerStatus) {
+	*out = *in
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerSTUNServerStatus.
+func (in *FrpServerSTUNServerStatus) DeepCopy() *FrpServerSTUNServerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerSTUNServerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FrpServerSpec) DeepCopyInto(out *FrpServerSpec) {
 	*out = *in
@@ -141,6 +920,23 @@ func (in *FrpServerSpec) DeepCopyInto(out *FrpServerSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.IPAM != nil {
+		in, out := &in.IPAM, &out.IPAM
+		*out = new(FrpServerIPAM)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Domains != nil {
+		in, out := &in.Domains, &out.Domains
+		*out = make([]FrpServerDomain, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NatHoleSTUNFallbackServers != nil {
+		in, out := &in.NatHoleSTUNFallbackServers, &out.NatHoleSTUNFallbackServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.LoginFailExit != nil {
 		in, out := &in.LoginFailExit, &out.LoginFailExit
 		*out = new(bool)
@@ -154,6 +950,36 @@ func (in *FrpServerSpec) DeepCopyInto(out *FrpServerSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ProxyDefaults != nil {
+		in, out := &in.ProxyDefaults, &out.ProxyDefaults
+		*out = new(FrpServerProxyDefaults)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedProxyTypes != nil {
+		in, out := &in.AllowedProxyTypes, &out.AllowedProxyTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClaimPolicy != nil {
+		in, out := &in.ClaimPolicy, &out.ClaimPolicy
+		*out = new(FrpServerClaimPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VaultRef != nil {
+		in, out := &in.VaultRef, &out.VaultRef
+		*out = new(FrpServerVaultRef)
+		**out = **in
+	}
+	if in.ClockSkew != nil {
+		in, out := &in.ClockSkew, &out.ClockSkew
+		*out = new(FrpServerClockSkew)
+		**out = **in
+	}
+	if in.RouteGC != nil {
+		in, out := &in.RouteGC, &out.RouteGC
+		*out = new(FrpServerRouteGC)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerSpec.
@@ -176,6 +1002,39 @@ func (in *FrpServerStatus) DeepCopyInto(out *FrpServerStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastProbeTime != nil {
+		in, out := &in.LastProbeTime, &out.LastProbeTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NatHoleSTUNServers != nil {
+		in, out := &in.NatHoleSTUNServers, &out.NatHoleSTUNServers
+		*out = make([]FrpServerSTUNServerStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conformance != nil {
+		in, out := &in.Conformance, &out.Conformance
+		*out = new(FrpServerConformance)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Capabilities != nil {
+		in, out := &in.Capabilities, &out.Capabilities
+		*out = new(FrpServerCapabilities)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Proxies != nil {
+		in, out := &in.Proxies, &out.Proxies
+		*out = make([]FrpServerProxyStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]FrpServerPhaseTransition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.ServiceReferences != nil {
 		in, out := &in.ServiceReferences, &out.ServiceReferences
 		*out = make([]ServiceReference, len(*in))
@@ -193,9 +1052,39 @@ func (in *FrpServerStatus) DeepCopy() *FrpServerStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerTLSPolicy) DeepCopyInto(out *FrpServerTLSPolicy) {
+	*out = *in
+	if in.CipherSuites != nil {
+		in, out := &in.CipherSuites, &out.CipherSuites
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CurvePreferences != nil {
+		in, out := &in.CurvePreferences, &out.CurvePreferences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerTLSPolicy.
+func (in *FrpServerTLSPolicy) DeepCopy() *FrpServerTLSPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerTLSPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FrpServerTransport) DeepCopyInto(out *FrpServerTransport) {
 	*out = *in
+	if in.FallbackProtocols != nil {
+		in, out := &in.FallbackProtocols, &out.FallbackProtocols
+		*out = make([]FrpServerTransportProtocol, len(*in))
+		copy(*out, *in)
+	}
 	if in.TCPMux != nil {
 		in, out := &in.TCPMux, &out.TCPMux
 		*out = new(bool)
@@ -247,6 +1136,11 @@ func (in *FrpServerTransportTLS) DeepCopyInto(out *FrpServerTransportTLS) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(FrpServerTLSPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerTransportTLS.
@@ -259,6 +1153,21 @@ func (in *FrpServerTransportTLS) DeepCopy() *FrpServerTransportTLS {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrpServerVaultRef) DeepCopyInto(out *FrpServerVaultRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrpServerVaultRef.
+func (in *FrpServerVaultRef) DeepCopy() *FrpServerVaultRef {
+	if in == nil {
+		return nil
+	}
+	out := new(FrpServerVaultRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceReference) DeepCopyInto(out *ServiceReference) {
 	*out = *in
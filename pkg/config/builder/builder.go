@@ -0,0 +1,268 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package builder builds frp proxy configs programmatically, the builders apply the frp client defaults and
+// validate the config the same way frpc does, so the embedders don't assemble the config structs by hand.
+//
+//	cfg, err := builder.NewTCPProxy("web").LocalPort(80).RemotePort(8080).Build()
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatedier/frp/pkg/config/types"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/config/v1/validation"
+)
+
+// ProxyBuilder builds the config of a frp proxy, the setters which don't apply to the proxy type are
+// reported by Build
+type ProxyBuilder struct {
+	cfg        configv1.ProxyConfigurer
+	namePrefix string
+	errs       error
+}
+
+// NewProxy returns the builder of a proxy of the type, one of the frp proxy types
+func NewProxy(proxyType configv1.ProxyType, name string) *ProxyBuilder {
+	b := &ProxyBuilder{cfg: configv1.NewProxyConfigurerByType(proxyType)}
+	if b.cfg == nil {
+		b.cfg = &configv1.TCPProxyConfig{}
+		b.errs = fmt.Errorf("unsupported proxy type '%s'", proxyType)
+	}
+	b.cfg.GetBaseConfig().Name = name
+	return b
+}
+
+// NewTCPProxy returns the builder of a tcp proxy
+func NewTCPProxy(name string) *ProxyBuilder {
+	return NewProxy(configv1.ProxyTypeTCP, name)
+}
+
+// NewUDPProxy returns the builder of an udp proxy
+func NewUDPProxy(name string) *ProxyBuilder {
+	return NewProxy(configv1.ProxyTypeUDP, name)
+}
+
+// NewHTTPProxy returns the builder of a http proxy
+func NewHTTPProxy(name string) *ProxyBuilder {
+	return NewProxy(configv1.ProxyTypeHTTP, name)
+}
+
+// NewHTTPSProxy returns the builder of a https proxy
+func NewHTTPSProxy(name string) *ProxyBuilder {
+	return NewProxy(configv1.ProxyTypeHTTPS, name)
+}
+
+// NewTCPMuxProxy returns the builder of a tcpmux proxy multiplexed with http connect
+func NewTCPMuxProxy(name string) *ProxyBuilder {
+	b := NewProxy(configv1.ProxyTypeTCPMUX, name)
+	b.cfg.(*configv1.TCPMuxProxyConfig).Multiplexer = string(configv1.TCPMultiplexerHTTPConnect)
+	return b
+}
+
+// NewSTCPProxy returns the builder of a stcp proxy
+func NewSTCPProxy(name string) *ProxyBuilder {
+	return NewProxy(configv1.ProxyTypeSTCP, name)
+}
+
+// unsupported records a setter which doesn't apply to the proxy type
+func (b *ProxyBuilder) unsupported(field string) *ProxyBuilder {
+	b.errs = errors.Join(b.errs, fmt.Errorf("%s is not supported by %s proxies", field, b.cfg.GetBaseConfig().Type))
+	return b
+}
+
+// NamePrefix prefixes the name of the proxy with "{prefix}.", frpc prefixes the proxies with its user
+func (b *ProxyBuilder) NamePrefix(prefix string) *ProxyBuilder {
+	b.namePrefix = prefix
+	return b
+}
+
+// LocalIP sets the ip or host name the proxy connects to, defaults to 127.0.0.1
+func (b *ProxyBuilder) LocalIP(ip string) *ProxyBuilder {
+	b.cfg.GetBaseConfig().LocalIP = ip
+	return b
+}
+
+// LocalPort sets the port the proxy connects to
+func (b *ProxyBuilder) LocalPort(port int) *ProxyBuilder {
+	b.cfg.GetBaseConfig().LocalPort = port
+	return b
+}
+
+// RemotePort sets the port the tcp and udp proxies listen on frps, 0 lets frps pick one
+func (b *ProxyBuilder) RemotePort(port int) *ProxyBuilder {
+	switch cfg := b.cfg.(type) {
+	case *configv1.TCPProxyConfig:
+		cfg.RemotePort = port
+	case *configv1.UDPProxyConfig:
+		cfg.RemotePort = port
+	default:
+		return b.unsupported("remotePort")
+	}
+	return b
+}
+
+// domainConfig returns the domains of the http, https and tcpmux proxies, nil for the other types
+func (b *ProxyBuilder) domainConfig() *configv1.DomainConfig {
+	switch cfg := b.cfg.(type) {
+	case *configv1.HTTPProxyConfig:
+		return &cfg.DomainConfig
+	case *configv1.HTTPSProxyConfig:
+		return &cfg.DomainConfig
+	case *configv1.TCPMuxProxyConfig:
+		return &cfg.DomainConfig
+	}
+	return nil
+}
+
+// CustomDomains adds the domains the http, https and tcpmux proxies are routed by
+func (b *ProxyBuilder) CustomDomains(domains ...string) *ProxyBuilder {
+	domainConfig := b.domainConfig()
+	if domainConfig == nil {
+		return b.unsupported("customDomains")
+	}
+	domainConfig.CustomDomains = append(domainConfig.CustomDomains, domains...)
+	return b
+}
+
+// SubDomain sets the subdomain of the frps subdomain host the http, https and tcpmux proxies are routed by
+func (b *ProxyBuilder) SubDomain(subdomain string) *ProxyBuilder {
+	domainConfig := b.domainConfig()
+	if domainConfig == nil {
+		return b.unsupported("subdomain")
+	}
+	domainConfig.SubDomain = subdomain
+	return b
+}
+
+// Locations adds the url path prefixes the http proxy is routed by
+func (b *ProxyBuilder) Locations(locations ...string) *ProxyBuilder {
+	cfg, ok := b.cfg.(*configv1.HTTPProxyConfig)
+	if !ok {
+		return b.unsupported("locations")
+	}
+	cfg.Locations = append(cfg.Locations, locations...)
+	return b
+}
+
+// HostHeaderRewrite sets the host header the http proxy sends to the local service
+func (b *ProxyBuilder) HostHeaderRewrite(host string) *ProxyBuilder {
+	cfg, ok := b.cfg.(*configv1.HTTPProxyConfig)
+	if !ok {
+		return b.unsupported("hostHeaderRewrite")
+	}
+	cfg.HostHeaderRewrite = host
+	return b
+}
+
+// HTTPBasicAuth protects the http and tcpmux proxies with the user and password
+func (b *ProxyBuilder) HTTPBasicAuth(user, password string) *ProxyBuilder {
+	switch cfg := b.cfg.(type) {
+	case *configv1.HTTPProxyConfig:
+		cfg.HTTPUser, cfg.HTTPPassword = user, password
+	case *configv1.TCPMuxProxyConfig:
+		cfg.HTTPUser, cfg.HTTPPassword = user, password
+	default:
+		return b.unsupported("httpUser")
+	}
+	return b
+}
+
+// SecretKey sets the key the visitors of the stcp, xtcp and sudp proxies authenticate with, allowUsers are
+// the users of the visitors besides the proxy's own, "*" allows every user
+func (b *ProxyBuilder) SecretKey(key string, allowUsers ...string) *ProxyBuilder {
+	switch cfg := b.cfg.(type) {
+	case *configv1.STCPProxyConfig:
+		cfg.Secretkey, cfg.AllowUsers = key, allowUsers
+	case *configv1.XTCPProxyConfig:
+		cfg.Secretkey, cfg.AllowUsers = key, allowUsers
+	case *configv1.SUDPProxyConfig:
+		cfg.Secretkey, cfg.AllowUsers = key, allowUsers
+	default:
+		return b.unsupported("secretKey")
+	}
+	return b
+}
+
+// Encryption encrypts the traffic between frpc and frps with the auth token
+func (b *ProxyBuilder) Encryption(enabled bool) *ProxyBuilder {
+	b.cfg.GetBaseConfig().Transport.UseEncryption = enabled
+	return b
+}
+
+// Compression compresses the traffic between frpc and frps
+func (b *ProxyBuilder) Compression(enabled bool) *ProxyBuilder {
+	b.cfg.GetBaseConfig().Transport.UseCompression = enabled
+	return b
+}
+
+// BandwidthLimit limits the bandwidth of the proxy, e.g. "1MB", on the client or the server side
+func (b *ProxyBuilder) BandwidthLimit(limit, mode string) *ProxyBuilder {
+	quantity, err := types.NewBandwidthQuantity(limit)
+	if err != nil {
+		b.errs = errors.Join(b.errs, fmt.Errorf("invalid bandwidth limit '%s', got: %w", limit, err))
+		return b
+	}
+	b.cfg.GetBaseConfig().Transport.BandwidthLimit = quantity
+	b.cfg.GetBaseConfig().Transport.BandwidthLimitMode = mode
+	return b
+}
+
+// ProxyProtocol sends the proxy protocol header of the version, v1 or v2, to the local service
+func (b *ProxyBuilder) ProxyProtocol(version string) *ProxyBuilder {
+	b.cfg.GetBaseConfig().Transport.ProxyProtocolVersion = version
+	return b
+}
+
+// LoadBalance adds the proxy to the group of proxies frps balances the connections across
+func (b *ProxyBuilder) LoadBalance(group, groupKey string) *ProxyBuilder {
+	b.cfg.GetBaseConfig().LoadBalancer = configv1.LoadBalancerConfig{Group: group, GroupKey: groupKey}
+	return b
+}
+
+// HealthCheck sets the health check which removes the proxy from frps while the local service fails it
+func (b *ProxyBuilder) HealthCheck(healthCheck configv1.HealthCheckConfig) *ProxyBuilder {
+	b.cfg.GetBaseConfig().HealthCheck = healthCheck
+	return b
+}
+
+// Metadata sets a metadata entry of the proxy, it's passed to the frps server plugins
+func (b *ProxyBuilder) Metadata(key, value string) *ProxyBuilder {
+	base := b.cfg.GetBaseConfig()
+	if base.Metadatas == nil {
+		base.Metadatas = make(map[string]string)
+	}
+	base.Metadatas[key] = value
+	return b
+}
+
+// Plugin sets the client plugin handling the connections of the proxy instead of the local service, e.g. the
+// https2http plugin terminating the TLS of a https proxy
+func (b *ProxyBuilder) Plugin(pluginType string, options configv1.ClientPluginOptions) *ProxyBuilder {
+	b.cfg.GetBaseConfig().Plugin = configv1.TypedClientPluginOptions{Type: pluginType, ClientPluginOptions: options}
+	return b
+}
+
+// Build applies the frp client defaults to the proxy config and validates it, the builder shouldn't be
+// used afterwards
+func (b *ProxyBuilder) Build() (configv1.ProxyConfigurer, error) {
+	if b.errs != nil {
+		return nil, fmt.Errorf("invalid proxy '%s', got: %w", b.cfg.GetBaseConfig().Name, b.errs)
+	}
+	b.cfg.Complete(b.namePrefix)
+	if err := validation.ValidateProxyConfigurerForClient(b.cfg); err != nil {
+		return nil, fmt.Errorf("invalid proxy '%s', got: %w", b.cfg.GetBaseConfig().Name, err)
+	}
+	return b.cfg, nil
+}
@@ -17,11 +17,20 @@ import (
 	"errors"
 	"fmt"
 	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/events"
+	"github.com/frp-sigs/frp-provisioner/pkg/frpcinit"
+	"github.com/frp-sigs/frp-provisioner/pkg/gc"
+	"github.com/frp-sigs/frp-provisioner/pkg/scheduler"
+	"github.com/frp-sigs/frp-provisioner/pkg/tlspolicy"
+	"github.com/samber/lo"
 	"github.com/spf13/pflag"
-	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"os"
 	"path/filepath"
 	"sigs.k8s.io/yaml"
+	"strings"
+	"text/template"
 	"time"
 )
 
@@ -35,6 +44,49 @@ const (
 	defaultWebhookBindAddress         = ":9443"
 	defaultWebhookCertName            = "tls.crt"
 	defaultWebhookKeyName             = "tls.key"
+	defaultVaultKubernetesMountPath   = "kubernetes"
+	defaultLeakDetectionInterval      = time.Minute
+	defaultLeakDetectionConnMaxAge    = time.Hour
+	defaultPodRestartBudget           = 5
+	defaultPodRestartBudgetWindow     = time.Hour
+	defaultPodLogTailLines            = 50
+	defaultWebhookRejectionSummary    = time.Hour
+	defaultCanaryTimeout              = 5 * time.Minute
+	defaultSTUNProbeInterval          = 5 * time.Minute
+	defaultHealthProbeInterval        = time.Minute
+	defaultReconcileAuditInterval     = 10 * time.Minute
+	defaultEventWebhookRateLimit      = 30
+	defaultEventDedupWindow           = 5 * time.Minute
+	defaultEventRateLimitPerObject    = 10
+	defaultServerRolloutInterval      = 5 * time.Second
+	defaultObjectMetricsInterval      = time.Minute
+	defaultHostPortForwarderImage     = "docker.io/alpine/socat:1.8.0.0"
+	defaultFrpcInitImage              = "frp-provisioner:latest"
+	defaultFrpcCredentialsURL         = "https://frp-provisioner-webhook-service.frp-provisioner-system.svc" + frpcinit.Path
+)
+
+const (
+	// DefaultingModeFull writes the FrpServer defaults which depend on other fields to the object
+	DefaultingModeFull = "full"
+	// DefaultingModePreserve never mutates a FrpServer, the defaults which depend on other fields are only
+	// applied when the frp client config is generated, so GitOps tools don't see fields they don't manage
+	DefaultingModePreserve = "preserve"
+)
+
+const (
+	// ModeReconcile reconciles the cluster objects and the frps state, it's the normal operation of the manager
+	ModeReconcile = "reconcile"
+	// ModeObserve watches and reports the objects the manager would change without mutating the cluster or the
+	// frps state, the writes are sent as dry runs and the events are only logged
+	ModeObserve = "observe"
+)
+
+const (
+	// WorkloadKindPod creates the frp client pods of the services directly, the manager restarts them
+	WorkloadKindPod = "Pod"
+	// WorkloadKindDeployment runs the frp client pod of each service in an owned Deployment, so the restarts and
+	// the rollouts of a changed pod spec are handled by the Deployment controller
+	WorkloadKindDeployment = "Deployment"
 )
 
 const defaultPodTemplate = `
@@ -125,6 +177,15 @@ type ManagerOptions struct {
 	// Note: If certificate or key doesn't exist a self-signed certificate will be used.
 	MetricsKeyName string `json:"metricsKeyName"`
 
+	// TLSPolicy restricts the TLS versions, cipher suites and curves the webhook and metrics servers negotiate,
+	// the empty fields keep the defaults of crypto/tls.
+	TLSPolicy tlspolicy.Policy `json:"tlsPolicy"`
+
+	// FIPS only allows the FIPS-approved TLS settings in TLSPolicy and in spec.transport.tls.policy of the
+	// FrpServers, the empty fields of TLSPolicy select the approved settings. It's always on when the manager
+	// is built with GOEXPERIMENT=boringcrypto.
+	FIPS bool `json:"fips"`
+
 	// WebhookBindAddress is the address that the server will listen on.
 	// Defaults to "" - all addresses.
 	WebhookBindAddress string `json:"webhookBindAddress"`
@@ -165,6 +226,247 @@ type ManagerOptions struct {
 
 	// PodTemplate The path to the pod template file for the FRP client, which will be used to generate pods
 	PodTemplate string `json:"PodTemplate"`
+
+	// ClientCertificateTemplate is a cert-manager.io/v1 Certificate issuing the client certificate of each
+	// namespace with exposed services, the common name of the certificate is the namespace so frps can authorize
+	// the tenants by their certificate identity. The certificate is mounted in the frp client pods at
+	// /etc/frp/tls, the client certificates are not issued when empty.
+	ClientCertificateTemplate string `json:"clientCertificateTemplate"`
+
+	// VaultAddress is the address of the HashiCorp Vault server used to resolve FrpServer
+	// credentials referenced by spec.vaultRef. Vault integration is disabled when empty.
+	VaultAddress string `json:"vaultAddress"`
+
+	// VaultKubernetesMountPath is the mount path of the Vault kubernetes auth method.
+	// Defaults to "kubernetes".
+	VaultKubernetesMountPath string `json:"vaultKubernetesMountPath"`
+
+	// KMSKeyFile is the path of the key file used to envelope encrypt the generated stcp/xtcp/sudp
+	// secret keys at rest. The secret keys are stored in plaintext when empty.
+	KMSKeyFile string `json:"kmsKeyFile"`
+
+	// EnableWebhooks determines whether to serve the admission webhooks, defaults to true.
+	// The static FrpServer defaults are declared in the CRD schema and applied either way. When
+	// disabled the defaults which depend on other fields and the validation are performed by the
+	// reconciler, invalid objects are reported with events, and the pod readiness gate is not injected.
+	EnableWebhooks *bool `json:"enableWebhooks"`
+
+	// PublishEndpointsAnnotation determines whether to mirror the published endpoints of a service
+	// into the frp.gofrp.io/published-endpoints annotation for consumers which can't read status.loadBalancer.
+	PublishEndpointsAnnotation bool `json:"publishEndpointsAnnotation"`
+
+	// SplitHorizon publishes the in-cluster endpoints of the exposed services, i.e. their cluster ip, into the
+	// frp.gofrp.io/internal-endpoints annotation while status.loadBalancer keeps the public frps endpoints. The
+	// frp.gofrp.io/split-horizon annotation of a service overrides it.
+	SplitHorizon bool `json:"splitHorizon"`
+
+	// LeakDetection enables the debug leak sentinel, which logs goroutine groups that keep growing and
+	// frp connections open longer than LeakDetectionConnMaxAge with stack traces.
+	LeakDetection bool `json:"leakDetection"`
+
+	// LeakDetectionInterval is the interval between goroutine snapshots of the leak sentinel.
+	// Defaults to 1 minute.
+	LeakDetectionInterval time.Duration `json:"leakDetectionInterval"`
+
+	// LeakDetectionConnMaxAge is the age after which an open frp connection is reported as a suspected leak.
+	// Defaults to 1 hour.
+	LeakDetectionConnMaxAge time.Duration `json:"leakDetectionConnMaxAge"`
+
+	// InventoryBindAddress is the TCP address that the read-only tunnel inventory API binds to,
+	// the OpenAPI document is served at /openapi.json.
+	// It can be set to "" or "0" to disable the inventory API.
+	InventoryBindAddress string `json:"inventoryBindAddress"`
+
+	// AccessPluginBindAddress is the TCP address that the frps http server plugin enforcing the
+	// FrpServerAccess objects binds to, frps is configured with the path /frpserveraccess/{frpserver}/{token}.
+	// It can be set to "" or "0" to disable the plugin.
+	AccessPluginBindAddress string `json:"accessPluginBindAddress"`
+
+	// AccessPluginToken authenticates the frps calling the access plugin, it's required when the plugin is enabled.
+	AccessPluginToken string `json:"accessPluginToken"`
+
+	// PodRestartBudget is the number of failed frp client pods of a service which are recreated within
+	// PodRestartBudgetWindow, the service is marked degraded and backs off once it's exhausted.
+	// Defaults to 5, set a negative value to disable the budget.
+	PodRestartBudget int `json:"podRestartBudget"`
+
+	// PodRestartBudgetWindow is the sliding window of PodRestartBudget. Defaults to 1 hour.
+	PodRestartBudgetWindow time.Duration `json:"podRestartBudgetWindow"`
+
+	// PodLogTailLines is the number of log lines read from a crash looping frp client container to
+	// summarize the root cause of the crash in a Service event. Defaults to 50.
+	PodLogTailLines int64 `json:"podLogTailLines"`
+
+	// WebhookRejectionSummaryInterval is the period the FrpServer fields most frequently rejected by the
+	// validating webhook are logged at. Defaults to 1 hour, set a negative value to disable the summary.
+	WebhookRejectionSummaryInterval time.Duration `json:"webhookRejectionSummaryInterval"`
+
+	// CanaryService is the "namespace/name" of the LoadBalancer service which validates a changed PodTemplate,
+	// the frp client pods of other services are only rolled to the new template once the tunnel of the canary
+	// service is live with it. The frp client pods keep their template when empty.
+	CanaryService string `json:"canaryService"`
+
+	// CanaryTimeout is the time given to the tunnel of the canary service to become live with a changed
+	// PodTemplate, the previous template is restored once it expires. Defaults to 5 minutes.
+	CanaryTimeout time.Duration `json:"canaryTimeout"`
+
+	// STUNProbeInterval is the period the STUN servers of the FrpServers are probed at, xtcp uses the first
+	// available one. Defaults to 5 minutes, set a negative value to disable the probe.
+	STUNProbeInterval time.Duration `json:"stunProbeInterval"`
+
+	// HealthProbeInterval is the period the FrpServers are logged in to again to keep their phase current.
+	// Defaults to 1 minute, set a negative value to only probe them when they change.
+	HealthProbeInterval time.Duration `json:"healthProbeInterval"`
+
+	// Tracing assigns a trace id to each reconcile, the trace id is added to the logs of the reconcile and
+	// attached as exemplar to the reconcile and login latency histograms served at /metrics/openmetrics.
+	Tracing bool `json:"tracing"`
+
+	// FrpsEmulator is the TCP address that an embedded minimal frps binds to, it accepts the logins of the
+	// frp clients and relays the traffic of their tcp proxies so the provisioning can be exercised without
+	// a real frps, e.g. in local development and CI. It can be set to "" or "0" to disable the emulator.
+	FrpsEmulator string `json:"frpsEmulator"`
+
+	// FrpsEmulatorToken is the auth token the frp clients log in to the frps emulator with.
+	FrpsEmulatorToken string `json:"frpsEmulatorToken"`
+
+	// EventWebhookURL is the webhook the Warning events of the provisioner, e.g. login failures and port
+	// conflicts, are forwarded to. The events are not forwarded when empty.
+	EventWebhookURL string `json:"eventWebhookURL"`
+
+	// EventWebhookFormat is the payload format of EventWebhookURL, one of generic, slack or teams.
+	// Defaults to generic, which posts the event with its rendered text as json.
+	EventWebhookFormat string `json:"eventWebhookFormat"`
+
+	// EventWebhookTemplate is the go template rendering the text of a forwarded event from its Component,
+	// Kind, Namespace, Name, Reason, Message and Time.
+	EventWebhookTemplate string `json:"eventWebhookTemplate"`
+
+	// EventWebhookRateLimit is the number of events forwarded per minute, the events beyond it are dropped.
+	// Defaults to 30.
+	EventWebhookRateLimit int `json:"eventWebhookRateLimit"`
+
+	// EventDedupWindow is the time a Kubernetes event suppresses its duplicates, i.e. the events of the same object
+	// with the same type, reason and message, the next event reports the suppressed repeats. A negative window
+	// disables the deduplication. Defaults to 5m.
+	EventDedupWindow time.Duration `json:"eventDedupWindow"`
+
+	// EventRateLimitPerObject is the number of Kubernetes events recorded per minute for an object, the events
+	// beyond it are dropped. A negative limit disables it. Defaults to 10.
+	EventRateLimitPerObject int `json:"eventRateLimitPerObject"`
+
+	// ExposeExternalNameServices exposes the ExternalName services with the frp server annotations, their
+	// proxies forward to the external host. Only LoadBalancer services are exposed when false.
+	ExposeExternalNameServices bool `json:"exposeExternalNameServices"`
+
+	// ExposeHeadlessServices exposes the headless services with the frp server annotations, a proxy named
+	// "{pod}-{port}" is created for each ready pod and port. Only LoadBalancer services are exposed when false.
+	ExposeHeadlessServices bool `json:"exposeHeadlessServices"`
+
+	// AllowInlineServers exposes the services with the frp.gofrp.io/inline-server annotation through the frps it
+	// describes, so one-off tunnels don't need a FrpServer. The annotation is rejected when false.
+	AllowInlineServers bool `json:"allowInlineServers"`
+
+	// Mode selects how the manager operates, one of reconcile or observe. The observe mode watches and reports
+	// through the logs and metrics but never mutates the cluster objects or the frps state, so the provisioner
+	// can be evaluated against a hand-managed frp setup before the cutover. Defaults to reconcile.
+	Mode string `json:"mode"`
+
+	// SkipUnchangedStatus skips the status writes of the FrpServer and Service controllers when the status is
+	// semantically unchanged, so idle reconciles don't generate audit noise and conflicts. Defaults to true.
+	SkipUnchangedStatus *bool `json:"skipUnchangedStatus"`
+
+	// DefaultingMode selects how the FrpServer defaults which depend on other fields are applied, one of full
+	// or preserve. The full mode writes them to the object on create and on the updates changing the spec,
+	// the preserve mode never mutates the object and applies them when the frp client config is generated.
+	// The static defaults are declared in the CRD schema in both modes. Defaults to full.
+	DefaultingMode string `json:"defaultingMode"`
+
+	// ServiceTunnelCondition writes the frp.gofrp.io/TunnelReady condition into the status of the exposed
+	// services, so automation can wait on the tunnel declaratively. Service status conditions need
+	// Kubernetes v1.20 or later, older API servers drop them.
+	ServiceTunnelCondition bool `json:"serviceTunnelCondition"`
+
+	// AllowedProxyTypes are the proxy types the services may select on any FrpServer, spec.allowedProxyTypes
+	// of a FrpServer restricts them further. Empty allows every proxy type.
+	AllowedProxyTypes []string `json:"allowedProxyTypes"`
+
+	// Scheduler is the name of the scheduler placing the services with a frp.gofrp.io/server-selector annotation
+	// which select no scheduler, one of default, least-loaded, round-robin or label-selector, or a scheduler
+	// compiled into the manager. Defaults to default.
+	Scheduler string `json:"scheduler"`
+
+	// ServerRolloutInterval is the minimum time between two restarts of frp client pods rolling out a changed
+	// FrpServer spec, so the tunnels of a FrpServer don't reconnect all at once. Defaults to 5 seconds.
+	ServerRolloutInterval time.Duration `json:"serverRolloutInterval"`
+
+	// RenderFrpcConfig renders the full frpc config of each exposed service, the common config of its FrpServer and
+	// a proxy per port, into a ConfigMap of the service mounted in the frp client pods. The credentials it reads are
+	// fetched from the manager webhook server by an init container, it requires the webhooks enabled. The pods are
+	// restarted one at a time once the rendered config or its credentials change. Off by default, the frp client
+	// image then configures frpc itself.
+	RenderFrpcConfig bool `json:"renderFrpcConfig"`
+
+	// FrpcInitImage is the image of the init container writing the rendered frpc config with the FrpServer
+	// credentials and the secret key of the service, it must run the manager binary. Defaults to frp-provisioner:latest.
+	FrpcInitImage string `json:"frpcInitImage"`
+
+	// FrpcCredentialsURL is the address the init container of the frp client pods fetches the credentials of the
+	// rendered frpc config from, the path of the manager webhook server behind the webhook Service.
+	FrpcCredentialsURL string `json:"frpcCredentialsURL"`
+
+	// ManagedWorkloadKind selects the workload running the frp client of each exposed service, one of Pod or
+	// Deployment. The Deployment rolls out the changed pod spec, e.g. a changed image or FrpServer common config,
+	// without surge so two frp clients never register the same proxies. Defaults to Pod.
+	ManagedWorkloadKind string `json:"managedWorkloadKind"`
+
+	// DrainTimeout is the grace period the frp client pods of a service which is deleted or no longer exposed
+	// are deleted with, frpc closes its proxies on SIGTERM and the tunnel is deleted once the pods are gone.
+	// Defaults to 0, the pods are deleted with the grace period of their template and not waited for.
+	DrainTimeout time.Duration `json:"drainTimeout"`
+
+	// ObjectMetricsInterval is the period the objects in the informer cache are counted at for the
+	// managed_objects and informer_cache_bytes metrics. Defaults to 1 minute, set a negative value to disable.
+	ObjectMetricsInterval time.Duration `json:"objectMetricsInterval"`
+
+	// ReconcileAuditInterval is the minimum period between two writes of the reconcile audit annotations of an
+	// object, they're written right away when another manager version or instance reconciled the object last.
+	// Defaults to 10 minutes, set a negative value to not write them.
+	ReconcileAuditInterval time.Duration `json:"reconcileAuditInterval"`
+
+	// UsageReportInterval is the period the usage summary of each namespace with tunnels is written to the
+	// frp-provisioner-usage ConfigMap of the namespace at. The reports are not written when it's zero.
+	UsageReportInterval time.Duration `json:"usageReportInterval"`
+
+	// CloudEventsSink is the URI the provisioning decisions, i.e. the tunnels created, deleted or switched to
+	// another FrpServer, are posted to as CloudEvents. The events are not emitted when empty.
+	CloudEventsSink string `json:"cloudEventsSink"`
+
+	// ManageCRDs applies the CRDs compiled into the manager on startup when the installed ones are missing
+	// or older. The drift is only logged and exported as the crd_schema_drift metric otherwise.
+	ManageCRDs bool `json:"manageCRDs"`
+
+	// ConflictStrategy resolves the services publishing the same hostname on a FrpServer, one of fail,
+	// suffix-hash or preempt-lower-priority. The frp.gofrp.io/conflict-strategy annotation of a namespace
+	// overrides it for its services. Defaults to fail.
+	ConflictStrategy string `json:"conflictStrategy"`
+
+	// TempFileTTL is the age after which the cert, key and ca temp files left by crashed validations are removed
+	// from the temp dir, they hold credentials. Defaults to 1 hour, negative disables the janitor.
+	TempFileTTL time.Duration `json:"tempFileTTL"`
+
+	// ConfigSnapshotPath is the file the effective options are persisted to on startup, the options changed
+	// since the previous run, e.g. by the new defaults of an upgrade, are logged and exported as the
+	// config_option_changed metric. It should be on a persistent volume, nothing is persisted when empty.
+	ConfigSnapshotPath string `json:"configSnapshotPath"`
+
+	// AllowedImageRegistries are the registries, e.g. "ghcr.io/fatedier", the frp.gofrp.io/image annotation of
+	// a service may pull the frp client image from. The annotation is rejected when empty.
+	AllowedImageRegistries []string `json:"allowedImageRegistries"`
+
+	// HostPortForwarderImage is the socat image of the pods of the services exposed in host port mode, the
+	// pods run one forwarder per port of the service which relays the host port to the service.
+	HostPortForwarderImage string `json:"hostPortForwarderImage"`
 }
 
 // SetDefaults set default values for manager options.
@@ -194,6 +496,68 @@ func (o *ManagerOptions) SetDefaults() {
 	o.PodTemplate = util.EmptyOr(o.PodTemplate, defaultPodTemplate)
 
 	o.MetricsCertDir = util.EmptyOr(o.MetricsCertDir, filepath.Join(os.TempDir(), "k8s-metrics-server", "serving-certs"))
+
+	o.VaultKubernetesMountPath = util.EmptyOr(o.VaultKubernetesMountPath, defaultVaultKubernetesMountPath)
+
+	if o.EnableWebhooks == nil {
+		o.EnableWebhooks = lo.ToPtr(true)
+	}
+
+	if o.SkipUnchangedStatus == nil {
+		o.SkipUnchangedStatus = lo.ToPtr(true)
+	}
+
+	o.LeakDetectionInterval = util.EmptyOr(o.LeakDetectionInterval, defaultLeakDetectionInterval)
+
+	o.LeakDetectionConnMaxAge = util.EmptyOr(o.LeakDetectionConnMaxAge, defaultLeakDetectionConnMaxAge)
+
+	o.PodRestartBudget = util.EmptyOr(o.PodRestartBudget, defaultPodRestartBudget)
+
+	o.PodRestartBudgetWindow = util.EmptyOr(o.PodRestartBudgetWindow, defaultPodRestartBudgetWindow)
+
+	o.PodLogTailLines = util.EmptyOr(o.PodLogTailLines, defaultPodLogTailLines)
+
+	o.WebhookRejectionSummaryInterval = util.EmptyOr(o.WebhookRejectionSummaryInterval, defaultWebhookRejectionSummary)
+
+	o.CanaryTimeout = util.EmptyOr(o.CanaryTimeout, defaultCanaryTimeout)
+
+	o.STUNProbeInterval = util.EmptyOr(o.STUNProbeInterval, defaultSTUNProbeInterval)
+	o.HealthProbeInterval = util.EmptyOr(o.HealthProbeInterval, defaultHealthProbeInterval)
+	o.ReconcileAuditInterval = util.EmptyOr(o.ReconcileAuditInterval, defaultReconcileAuditInterval)
+	o.ServerRolloutInterval = util.EmptyOr(o.ServerRolloutInterval, defaultServerRolloutInterval)
+	o.Scheduler = util.EmptyOr(o.Scheduler, scheduler.DefaultSchedulerName)
+	o.FIPS = o.FIPS || tlspolicy.BuildFIPS
+	o.ManagedWorkloadKind = util.EmptyOr(o.ManagedWorkloadKind, WorkloadKindPod)
+	o.ObjectMetricsInterval = util.EmptyOr(o.ObjectMetricsInterval, defaultObjectMetricsInterval)
+
+	o.EventWebhookFormat = util.EmptyOr(o.EventWebhookFormat, string(events.FormatGeneric))
+
+	o.EventWebhookTemplate = util.EmptyOr(o.EventWebhookTemplate, events.DefaultTemplate)
+
+	o.EventWebhookRateLimit = util.EmptyOr(o.EventWebhookRateLimit, defaultEventWebhookRateLimit)
+
+	o.EventDedupWindow = util.EmptyOr(o.EventDedupWindow, defaultEventDedupWindow)
+
+	o.EventRateLimitPerObject = util.EmptyOr(o.EventRateLimitPerObject, defaultEventRateLimitPerObject)
+
+	o.Mode = util.EmptyOr(o.Mode, ModeReconcile)
+
+	o.DefaultingMode = util.EmptyOr(o.DefaultingMode, DefaultingModeFull)
+
+	o.ConflictStrategy = util.EmptyOr(o.ConflictStrategy, v1beta1.ConflictStrategyFail)
+
+	o.TempFileTTL = util.EmptyOr(o.TempFileTTL, gc.DefaultTempFileTTL)
+
+	o.HostPortForwarderImage = util.EmptyOr(o.HostPortForwarderImage, defaultHostPortForwarderImage)
+
+	o.FrpcInitImage = util.EmptyOr(o.FrpcInitImage, defaultFrpcInitImage)
+
+	o.FrpcCredentialsURL = util.EmptyOr(o.FrpcCredentialsURL, defaultFrpcCredentialsURL)
+}
+
+// Observing reports whether the manager runs in the observe mode, nil options reconcile
+func (o *ManagerOptions) Observing() bool {
+	return o != nil && o.Mode == ModeObserve
 }
 
 // Validate validates the frpc service options.
@@ -226,15 +590,106 @@ func (o *ManagerOptions) Validate() (err error) {
 		err = errors.Join(err, fmt.Errorf("gracefulShutdownTimeout is required"))
 	}
 
+	if o.PodRestartBudgetWindow <= 0 {
+		err = errors.Join(err, fmt.Errorf("podRestartBudgetWindow must be positive"))
+	}
+
+	if o.PodLogTailLines <= 0 {
+		err = errors.Join(err, fmt.Errorf("podLogTailLines must be positive"))
+	}
+
+	if namespace, name, ok := strings.Cut(o.CanaryService, "/"); o.CanaryService != "" && (!ok || namespace == "" || name == "") {
+		err = errors.Join(err, fmt.Errorf("canaryService must be in the form namespace/name, got: %s", o.CanaryService))
+	}
+
+	if o.CanaryTimeout <= 0 {
+		err = errors.Join(err, fmt.Errorf("canaryTimeout must be positive"))
+	}
+
+	if o.ClientCertificateTemplate != "" {
+		cert := unstructured.Unstructured{}
+		if certErr := yaml.Unmarshal([]byte(o.ClientCertificateTemplate), &cert); certErr != nil {
+			err = errors.Join(err, fmt.Errorf("unable parse clientCertificateTemplate with yaml, got: %w", certErr))
+		} else if cert.GetAPIVersion() != "cert-manager.io/v1" || cert.GetKind() != "Certificate" {
+			err = errors.Join(err, fmt.Errorf("clientCertificateTemplate must be a cert-manager.io/v1 Certificate"))
+		} else if issuer, _, _ := unstructured.NestedString(cert.Object, "spec", "issuerRef", "name"); issuer == "" {
+			err = errors.Join(err, fmt.Errorf("clientCertificateTemplate does not specify spec.issuerRef.name"))
+		}
+	}
+
+	if !lo.Contains([]events.Format{events.FormatGeneric, events.FormatSlack, events.FormatTeams}, events.Format(o.EventWebhookFormat)) {
+		err = errors.Join(err, fmt.Errorf("eventWebhookFormat must be one of generic, slack or teams, got: %s", o.EventWebhookFormat))
+	}
+
+	if _, tmplErr := template.New("event").Parse(o.EventWebhookTemplate); tmplErr != nil {
+		err = errors.Join(err, fmt.Errorf("unable parse eventWebhookTemplate, got: %w", tmplErr))
+	}
+
+	if o.EventWebhookRateLimit <= 0 {
+		err = errors.Join(err, fmt.Errorf("eventWebhookRateLimit must be positive"))
+	}
+
+	if o.Mode != ModeReconcile && o.Mode != ModeObserve {
+		err = errors.Join(err, fmt.Errorf("mode must be one of %s or %s, got: %s", ModeReconcile, ModeObserve, o.Mode))
+	}
+
+	if o.DefaultingMode != DefaultingModeFull && o.DefaultingMode != DefaultingModePreserve {
+		err = errors.Join(err, fmt.Errorf("defaultingMode must be one of %s or %s, got: %s", DefaultingModeFull, DefaultingModePreserve, o.DefaultingMode))
+	}
+
+	if unknown := lo.Without(o.AllowedProxyTypes, v1beta1.ProxyTypes...); len(unknown) != 0 {
+		err = errors.Join(err, fmt.Errorf("allowedProxyTypes must be a subset of %v, got unknown types: %v", v1beta1.ProxyTypes, unknown))
+	}
+
+	if lo.Contains(o.AllowedImageRegistries, "") || lo.SomeBy(o.AllowedImageRegistries, func(registry string) bool { return strings.Contains(registry, "://") }) {
+		err = errors.Join(err, fmt.Errorf("allowedImageRegistries must be registry hosts or repository prefixes without a scheme, got: %v", o.AllowedImageRegistries))
+	}
+
+	if !lo.Contains(v1beta1.ConflictStrategies, o.ConflictStrategy) {
+		err = errors.Join(err, fmt.Errorf("conflictStrategy must be one of %v, got: %s", v1beta1.ConflictStrategies, o.ConflictStrategy))
+	}
+
+	if policyErr := o.TLSPolicy.Validate("tlsPolicy"); policyErr != nil {
+		err = errors.Join(err, policyErr)
+	} else if o.FIPS {
+		err = errors.Join(err, o.TLSPolicy.CheckFIPS("tlsPolicy"))
+	}
+
+	if o.ManagedWorkloadKind != WorkloadKindPod && o.ManagedWorkloadKind != WorkloadKindDeployment {
+		err = errors.Join(err, fmt.Errorf("managedWorkloadKind must be one of %s or %s, got: %s", WorkloadKindPod, WorkloadKindDeployment, o.ManagedWorkloadKind))
+	}
+
+	if _, schedulerErr := scheduler.Get(o.Scheduler); schedulerErr != nil {
+		err = errors.Join(err, fmt.Errorf("scheduler is not valid, got: %w", schedulerErr))
+	}
+
+	if o.ServerRolloutInterval < 0 {
+		err = errors.Join(err, fmt.Errorf("serverRolloutInterval must not be negative"))
+	}
+
+	if o.AccessPluginBindAddress != "" && o.AccessPluginBindAddress != "0" && o.AccessPluginToken == "" {
+		err = errors.Join(err, fmt.Errorf("accessPluginToken is required when the access plugin is enabled"))
+	}
+
+	// the frp client pods fetch the credentials of the rendered frpc config from the webhook server, a client
+	// certificate required by it can't be presented by them
+	if o.RenderFrpcConfig && (!lo.FromPtr(o.EnableWebhooks) || o.WebhookClientCAName != "") {
+		err = errors.Join(err, fmt.Errorf("renderFrpcConfig requires the webhooks enabled without webhookClientCAName"))
+	}
+
+	if o.DrainTimeout < 0 {
+		err = errors.Join(err, fmt.Errorf("drainTimeout must not be negative"))
+	}
+
+	if o.UsageReportInterval < 0 {
+		err = errors.Join(err, fmt.Errorf("usageReportInterval must not be negative"))
+	}
+
 	if o.PodTemplate == "" {
 		err = errors.Join(err, fmt.Errorf("PodTemplate is required"))
 	}
-	p := v1.Pod{}
-	err = yaml.Unmarshal([]byte(o.PodTemplate), &p)
-	if err != nil {
-		err = errors.Join(err, fmt.Errorf("unable parse podTemplate with yaml: %v", o.PodTemplate))
-	} else if len(p.Spec.Containers) == 0 {
-		err = errors.Join(err, fmt.Errorf("podTemplate does not specify any container"))
+	if _, parseErr := ParsePodTemplate(o.PodTemplate); parseErr != nil {
+		err = errors.Join(err, parseErr)
 	}
 	return err
 }
@@ -297,6 +752,186 @@ func (o *ManagerOptions) AddFlags(fs *pflag.FlagSet) {
 
 	fs.StringVar(&o.MetricsCertName, "manager.metrics-cert-name", o.MetricsCertName, "Is the metrics server tls certificate filename.")
 
+	fs.StringVar(&o.TLSPolicy.MinVersion, "manager.tls-min-version", o.TLSPolicy.MinVersion, "Is the minimum TLS version of the webhook"+
+		" and metrics servers, one of VersionTLS10, VersionTLS11, VersionTLS12 or VersionTLS13.")
+
+	fs.StringSliceVar(&o.TLSPolicy.CipherSuites, "manager.tls-cipher-suites", o.TLSPolicy.CipherSuites, "Is the list of IANA names of the"+
+		" TLS 1.0-1.2 cipher suites the webhook and metrics servers enable, empty keeps the defaults.")
+
+	fs.StringSliceVar(&o.TLSPolicy.CurvePreferences, "manager.tls-curve-preferences", o.TLSPolicy.CurvePreferences, "Is the list of"+
+		" elliptic curves of the webhook and metrics servers in preference order, one of X25519, P256, P384 or P521.")
+
+	fs.BoolVar(&o.FIPS, "manager.fips", o.FIPS, "Only allows the FIPS-approved TLS settings for the manager and the FrpServers,"+
+		" always on when built with GOEXPERIMENT=boringcrypto.")
+
 	fs.DurationVar(&o.GracefulShutdownTimeout, "manager.graceful-shutdown-timeout", o.GracefulShutdownTimeout, "is the duration given to runnable and to stop before the manager actually returns on stop."+
 		" To disable graceful shutdown, set to 0, To use graceful shutdown without timeout, set to a negative duration, eg: -1, The graceful shutdown is skipped for safety reasons in case the leader election lease is lost.")
+
+	fs.StringVar(&o.VaultAddress, "manager.vault-address", o.VaultAddress, "Is the address of the HashiCorp Vault server used to resolve"+
+		" FrpServer credentials, Vault integration is disabled when empty.")
+
+	fs.StringVar(&o.VaultKubernetesMountPath, "manager.vault-kubernetes-mount-path", o.VaultKubernetesMountPath, "Is the mount path of the Vault kubernetes auth method.")
+
+	if o.EnableWebhooks == nil {
+		o.EnableWebhooks = lo.ToPtr(true)
+	}
+	fs.BoolVar(o.EnableWebhooks, "manager.enable-webhooks", *o.EnableWebhooks, "Determines whether to serve the admission webhooks,"+
+		" when disabled the FrpServer defaults which depend on other fields and the validation are applied by the reconciler"+
+		" and the pod readiness gate is not injected. The static FrpServer defaults are declared in the CRD schema either way.")
+
+	fs.StringVar(&o.KMSKeyFile, "manager.kms-key-file", o.KMSKeyFile, "Is the path of the key file used to encrypt the generated"+
+		" stcp/xtcp/sudp secret keys at rest, the first key encrypts while all keys decrypt.")
+
+	fs.BoolVar(&o.SplitHorizon, "manager.split-horizon", o.SplitHorizon, "Publishes the in-cluster endpoints of the exposed"+
+		" services into the frp.gofrp.io/internal-endpoints annotation, status.loadBalancer keeps the public endpoints.")
+
+	fs.BoolVar(&o.PublishEndpointsAnnotation, "manager.publish-endpoints-annotation", o.PublishEndpointsAnnotation, "Determines whether to mirror"+
+		" the published endpoints of a service into the frp.gofrp.io/published-endpoints annotation.")
+
+	fs.BoolVar(&o.LeakDetection, "manager.leak-detection", o.LeakDetection, "Enables the debug leak sentinel, which logs goroutine"+
+		" groups that keep growing and long-lived frp connections with stack traces.")
+
+	fs.DurationVar(&o.LeakDetectionInterval, "manager.leak-detection-interval", o.LeakDetectionInterval, "Is the interval between"+
+		" goroutine snapshots of the leak sentinel.")
+
+	fs.DurationVar(&o.LeakDetectionConnMaxAge, "manager.leak-detection-conn-max-age", o.LeakDetectionConnMaxAge, "Is the age after"+
+		" which an open frp connection is reported as a suspected leak.")
+
+	fs.StringVar(&o.InventoryBindAddress, "manager.inventory-bind-address", o.InventoryBindAddress, "Is the tcp address that the read-only"+
+		" tunnel inventory API binds to. It can be set to \"\" or \"0\" to disable the inventory API.")
+
+	fs.StringVar(&o.AccessPluginBindAddress, "manager.access-plugin-bind-address", o.AccessPluginBindAddress, "Is the tcp address that the"+
+		" frps server plugin enforcing FrpServerAccess objects binds to. It can be set to \"\" or \"0\" to disable the plugin.")
+
+	fs.StringVar(&o.AccessPluginToken, "manager.access-plugin-token", o.AccessPluginToken, "Is the token authenticating the frps"+
+		" calling the access plugin, the last segment of the plugin path. It's required when the plugin is enabled.")
+
+	fs.IntVar(&o.PodRestartBudget, "manager.pod-restart-budget", o.PodRestartBudget, "Is the number of failed frp client pods of a service"+
+		" which are recreated within the budget window before the service backs off, a negative value disables the budget.")
+
+	fs.DurationVar(&o.PodRestartBudgetWindow, "manager.pod-restart-budget-window", o.PodRestartBudgetWindow, "Is the sliding window"+
+		" of the frp client pod restart budget.")
+
+	fs.Int64Var(&o.PodLogTailLines, "manager.pod-log-tail-lines", o.PodLogTailLines, "Is the number of log lines read from a crash"+
+		" looping frp client container to summarize the root cause of the crash in a Service event.")
+
+	fs.DurationVar(&o.WebhookRejectionSummaryInterval, "manager.webhook-rejection-summary-interval", o.WebhookRejectionSummaryInterval,
+		"Is the period the FrpServer fields most frequently rejected by the validating webhook are logged at, negative to disable.")
+
+	fs.StringVar(&o.CanaryService, "manager.canary-service", o.CanaryService, "Is the namespace/name of the LoadBalancer service which"+
+		" validates a changed pod template before the frp client pods of other services are rolled to it, empty to keep the pods.")
+
+	fs.DurationVar(&o.CanaryTimeout, "manager.canary-timeout", o.CanaryTimeout, "Is the time given to the tunnel of the canary"+
+		" service to become live with a changed pod template before the previous template is restored.")
+
+	fs.DurationVar(&o.STUNProbeInterval, "manager.stun-probe-interval", o.STUNProbeInterval, "Is the period the STUN servers of the"+
+		" FrpServers are probed at, xtcp uses the first available one, negative to disable.")
+
+	fs.DurationVar(&o.HealthProbeInterval, "manager.health-probe-interval", o.HealthProbeInterval, "Is the period the"+
+		" FrpServers are logged in to again to keep their phase current, negative to only probe them when they change.")
+
+	fs.BoolVar(&o.Tracing, "manager.tracing", o.Tracing, "Assigns a trace id to each reconcile, which is logged and attached"+
+		" as exemplar to the reconcile and login latency histograms served in the OpenMetrics format at /metrics/openmetrics.")
+
+	fs.StringVar(&o.FrpsEmulator, "manager.frps-emulator", o.FrpsEmulator, "Is the tcp address that an embedded minimal frps for"+
+		" local development binds to. It can be set to \"\" or \"0\" to disable the emulator.")
+
+	fs.StringVar(&o.FrpsEmulatorToken, "manager.frps-emulator-token", o.FrpsEmulatorToken, "Is the auth token the frp clients"+
+		" log in to the frps emulator with.")
+
+	fs.StringVar(&o.EventWebhookURL, "manager.event-webhook-url", o.EventWebhookURL, "Is the webhook the Warning events of"+
+		" the provisioner are forwarded to, empty to not forward them.")
+
+	fs.StringVar(&o.EventWebhookFormat, "manager.event-webhook-format", o.EventWebhookFormat, "Is the payload format of the"+
+		" event webhook, one of generic, slack or teams.")
+
+	fs.StringVar(&o.EventWebhookTemplate, "manager.event-webhook-template", o.EventWebhookTemplate, "Is the go template rendering"+
+		" the text of a forwarded event.")
+
+	fs.IntVar(&o.EventWebhookRateLimit, "manager.event-webhook-rate-limit", o.EventWebhookRateLimit, "Is the number of events"+
+		" forwarded to the event webhook per minute, the events beyond it are dropped.")
+
+	fs.DurationVar(&o.EventDedupWindow, "manager.event-dedup-window", o.EventDedupWindow, "Is the time a Kubernetes"+
+		" event suppresses its duplicates, the next event reports the repeats. A negative window disables it.")
+
+	fs.IntVar(&o.EventRateLimitPerObject, "manager.event-rate-limit-per-object", o.EventRateLimitPerObject, "Is the number"+
+		" of Kubernetes events recorded per minute for an object, the events beyond it are dropped. A negative limit disables it.")
+
+	fs.BoolVar(&o.ExposeExternalNameServices, "manager.expose-external-name-services", o.ExposeExternalNameServices, "Exposes the"+
+		" annotated ExternalName services, their proxies forward to the external host.")
+
+	fs.BoolVar(&o.ExposeHeadlessServices, "manager.expose-headless-services", o.ExposeHeadlessServices, "Exposes the annotated"+
+		" headless services with a proxy for each ready pod and port.")
+
+	fs.BoolVar(&o.AllowInlineServers, "manager.allow-inline-servers", o.AllowInlineServers, "Exposes the services with the"+
+		" frp.gofrp.io/inline-server annotation through the frps it describes, without a FrpServer.")
+
+	fs.StringVar(&o.Mode, "manager.mode", o.Mode, "Selects how the manager operates, reconcile mutates the cluster"+
+		" and the frps state, observe only watches and reports the changes it would make.")
+
+	if o.SkipUnchangedStatus == nil {
+		o.SkipUnchangedStatus = lo.ToPtr(true)
+	}
+	fs.BoolVar(o.SkipUnchangedStatus, "manager.skip-unchanged-status", *o.SkipUnchangedStatus, "Skips the status writes of the"+
+		" FrpServer and Service controllers when the status is unchanged.")
+
+	fs.StringVar(&o.DefaultingMode, "manager.defaulting-mode", o.DefaultingMode, "Selects how the FrpServer defaults which depend"+
+		" on other fields are applied, full writes them to the object, preserve never mutates the object.")
+
+	fs.BoolVar(&o.ServiceTunnelCondition, "manager.service-tunnel-condition", o.ServiceTunnelCondition, "Writes the"+
+		" frp.gofrp.io/TunnelReady condition into the status of the exposed services, needs Kubernetes v1.20 or later.")
+
+	fs.StringSliceVar(&o.AllowedProxyTypes, "manager.allowed-proxy-types", o.AllowedProxyTypes, "Is the list of proxy types"+
+		" the services may select on any FrpServer, empty allows every proxy type.")
+
+	fs.StringVar(&o.Scheduler, "manager.scheduler", o.Scheduler, "Is the name of the scheduler placing the services with"+
+		" a server selector which select no scheduler, one of default, least-loaded, round-robin or label-selector.")
+
+	fs.DurationVar(&o.ServerRolloutInterval, "manager.server-rollout-interval", o.ServerRolloutInterval, "Is the minimum time"+
+		" between two restarts of frp client pods rolling out a changed FrpServer spec.")
+
+	fs.BoolVar(&o.RenderFrpcConfig, "manager.render-frpc-config", o.RenderFrpcConfig, "Renders the full frpc config of"+
+		" each exposed service into a ConfigMap mounted in its frp client pods.")
+
+	fs.StringVar(&o.FrpcInitImage, "manager.frpc-init-image", o.FrpcInitImage, "Is the image of the init container writing"+
+		" the rendered frpc config with its credentials, it must run the manager binary.")
+
+	fs.StringVar(&o.FrpcCredentialsURL, "manager.frpc-credentials-url", o.FrpcCredentialsURL, "Is the address the init container"+
+		" of the frp client pods fetches the credentials of the rendered frpc config from.")
+
+	fs.StringVar(&o.ManagedWorkloadKind, "manager.managed-workload-kind", o.ManagedWorkloadKind, "Selects the workload running"+
+		" the frp client of each exposed service, Pod creates the pods directly, Deployment runs them in an owned Deployment.")
+
+	fs.DurationVar(&o.DrainTimeout, "manager.drain-timeout", o.DrainTimeout, "Is the grace period the frp client pods of a service"+
+		" which is deleted or no longer exposed are deleted with, the tunnel is deleted once they're gone. Zero to not wait for them.")
+
+	fs.DurationVar(&o.ObjectMetricsInterval, "manager.object-metrics-interval", o.ObjectMetricsInterval, "Is the period the objects"+
+		" in the informer cache are counted at for the manager self-metrics, negative to disable.")
+
+	fs.DurationVar(&o.ReconcileAuditInterval, "manager.reconcile-audit-interval", o.ReconcileAuditInterval, "Is the minimum"+
+		" period between two writes of the reconcile audit annotations of an object, negative to not write them.")
+
+	fs.DurationVar(&o.UsageReportInterval, "manager.usage-report-interval", o.UsageReportInterval, "Is the period the usage"+
+		" summary of each namespace with tunnels is written to a ConfigMap of the namespace at, zero to not write them.")
+
+	fs.StringVar(&o.CloudEventsSink, "manager.cloudevents-sink", o.CloudEventsSink, "Is the URI the provisioning decisions"+
+		" are posted to as CloudEvents, empty to not emit them.")
+
+	fs.BoolVar(&o.ManageCRDs, "manager.manage-crds", o.ManageCRDs, "Applies the CRDs compiled into the manager on"+
+		" startup when the installed ones are missing or older.")
+
+	fs.StringVar(&o.ConflictStrategy, "manager.conflict-strategy", o.ConflictStrategy, "Resolves the services publishing"+
+		" the same hostname on a FrpServer, one of fail, suffix-hash or preempt-lower-priority.")
+
+	fs.DurationVar(&o.TempFileTTL, "manager.temp-file-ttl", o.TempFileTTL, "Is the age after which the cert, key and ca"+
+		" temp files left by crashed validations are removed, negative to disable.")
+
+	fs.StringVar(&o.ConfigSnapshotPath, "manager.config-snapshot-path", o.ConfigSnapshotPath, "Is the file the effective"+
+		" options are persisted to, the options changed since the previous run are logged on startup. Empty to disable.")
+
+	fs.StringSliceVar(&o.AllowedImageRegistries, "manager.allowed-image-registries", o.AllowedImageRegistries, "Is the list of"+
+		" registries the frp.gofrp.io/image annotation of a service may pull the frp client image from, empty rejects the annotation.")
+
+	fs.StringVar(&o.HostPortForwarderImage, "manager.host-port-forwarder-image", o.HostPortForwarderImage, "Is the socat"+
+		" image relaying the host ports of the services exposed in host port mode to the services.")
 }
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+	"sync"
+)
+
+// maxPodTemplates bounds the parsed pod templates kept by PodTemplates, the current template and the
+// one restored after a failed canary validation are the only ones in use at a time
+const maxPodTemplates = 4
+
+// ParsePodTemplate parses and validates the yaml of the frp client pod template
+func ParsePodTemplate(template string) (*v1.Pod, error) {
+	pod := &v1.Pod{}
+	if err := yaml.Unmarshal([]byte(template), pod); err != nil {
+		return nil, fmt.Errorf("unable parse podTemplate with yaml, got: %w", err)
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("podTemplate does not specify any container")
+	}
+	return pod, nil
+}
+
+// PodTemplates caches the parsed frp client pod templates keyed by their yaml, so the reconciles generating
+// pods don't pay the yaml costs. A changed template is parsed once on its first use. The zero value is
+// ready to use and it's safe for concurrent use.
+type PodTemplates struct {
+	lock   sync.RWMutex
+	parsed map[string]*v1.Pod
+}
+
+// NewPod returns a new pod generated from the template, the cached pod is never handed out
+func (p *PodTemplates) NewPod(template string) (*v1.Pod, error) {
+	p.lock.RLock()
+	pod, ok := p.parsed[template]
+	p.lock.RUnlock()
+	if ok {
+		return pod.DeepCopy(), nil
+	}
+	pod, err := ParsePodTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.parsed == nil || len(p.parsed) >= maxPodTemplates {
+		p.parsed = make(map[string]*v1.Pod, maxPodTemplates)
+	}
+	p.parsed[template] = pod
+	return pod.DeepCopy(), nil
+}
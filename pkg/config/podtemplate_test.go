@@ -0,0 +1,45 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+)
+
+// BenchmarkParsePodTemplate is the cost generating a pod paid when the template was parsed on every reconcile
+func BenchmarkParsePodTemplate(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParsePodTemplate(defaultPodTemplate); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPodTemplatesNewPod is the cost generating a pod pays with the cached template
+func BenchmarkPodTemplatesNewPod(b *testing.B) {
+	templates := &PodTemplates{}
+	if _, err := templates.NewPod(defaultPodTemplate); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := templates.NewPod(defaultPodTemplate); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
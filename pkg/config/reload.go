@@ -0,0 +1,80 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"sync"
+)
+
+const (
+	// podTemplateOption is the name the PodTemplate option is reported with, it has no flag
+	podTemplateOption = "PodTemplate"
+	// logLevelOption and schedulerOption are the flag names of the options reloaded with the config file
+	logLevelOption  = "log.level"
+	schedulerOption = "manager.scheduler"
+)
+
+// reloadLock guards the manager options which are replaced at runtime when the config file is reloaded
+var reloadLock sync.RWMutex
+
+// CurrentPodTemplate returns PodTemplate, it's replaced at runtime when the config file is reloaded
+func (o *ManagerOptions) CurrentPodTemplate() string {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return o.PodTemplate
+}
+
+// CurrentScheduler returns Scheduler, it's replaced at runtime when the config file is reloaded
+func (o *ManagerOptions) CurrentScheduler() string {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return o.Scheduler
+}
+
+// ReconcilesServices reports whether the applied options change the frp client pods or the placement of the
+// services, the exposed services are reconciled again once such an option is reloaded
+func ReconcilesServices(applied []string) bool {
+	for _, option := range applied {
+		if option == podTemplateOption || option == schedulerOption {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload applies the options of next which can change at runtime, the log level, the pod template and the
+// scheduler. next must be validated. The names of the applied options are returned with the names of the
+// changed options which only take effect once the manager restarts, those keep their current value.
+func (c *Configuration) Reload(next *Configuration) (applied []string, restart []string) {
+	for _, change := range DiffSnapshots(c.Snapshot(), next.Snapshot()) {
+		if change.Option != logLevelOption && change.Option != schedulerOption {
+			restart = append(restart, change.Option)
+		}
+	}
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	if level := next.Log.Level.Level(); c.Log.Level.Level() != level {
+		c.Log.Level.SetLevel(level)
+		applied = append(applied, logLevelOption)
+	}
+	if c.Manager.PodTemplate != next.Manager.PodTemplate {
+		c.Manager.PodTemplate = next.Manager.PodTemplate
+		applied = append(applied, podTemplateOption)
+	}
+	if c.Manager.Scheduler != next.Manager.Scheduler {
+		c.Manager.Scheduler = next.Manager.Scheduler
+		applied = append(applied, schedulerOption)
+	}
+	return applied, restart
+}
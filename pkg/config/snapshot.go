@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// sensitiveOptions are the options holding credentials, their snapshot is a digest of the value so
+// the change is detected without persisting the credential
+var sensitiveOptions = []string{"manager.frps-emulator-token", "manager.event-webhook-url"}
+
+// OptionChange is an option whose effective value differs from the previous run, an option added by
+// the new release has no previous value and an option removed by it has no current value.
+type OptionChange struct {
+	Option   string  `json:"option"`
+	Previous *string `json:"previous,omitempty"`
+	Current  *string `json:"current,omitempty"`
+}
+
+// Snapshot returns the effective value of every option keyed by its flag name, the values are formatted
+// like on the command line, e.g. "15s"
+func (c *Configuration) Snapshot() map[string]string {
+	fs := pflag.NewFlagSet("snapshot", pflag.ContinueOnError)
+	c.AddFlags(fs)
+	snapshot := make(map[string]string)
+	fs.VisitAll(func(f *pflag.Flag) {
+		value := f.Value.String()
+		if lo.Contains(sensitiveOptions, f.Name) && value != "" {
+			digest := sha256.Sum256([]byte(value))
+			value = "sha256:" + hex.EncodeToString(digest[:])[:12]
+		}
+		snapshot[f.Name] = value
+	})
+	return snapshot
+}
+
+// DiffSnapshots returns the options changed between the snapshots ordered by name
+func DiffSnapshots(previous, current map[string]string) []OptionChange {
+	var changes []OptionChange
+	for _, option := range lo.Union(lo.Keys(previous), lo.Keys(current)) {
+		before, hadBefore := previous[option]
+		after, hasAfter := current[option]
+		if hadBefore == hasAfter && before == after {
+			continue
+		}
+		change := OptionChange{Option: option}
+		if hadBefore {
+			change.Previous = lo.ToPtr(before)
+		}
+		if hasAfter {
+			change.Current = lo.ToPtr(after)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Option < changes[j].Option })
+	return changes
+}
+
+// LoadSnapshot reads the snapshot persisted by the previous run, nil is returned when there is none
+func LoadSnapshot(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable read config snapshot '%s', got: %w", path, err)
+	}
+	snapshot := make(map[string]string)
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid config snapshot '%s', got: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// SaveSnapshot persists the snapshot, the file is replaced atomically so a crash never leaves a torn snapshot
+func SaveSnapshot(path string, snapshot map[string]string) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable create config snapshot, got: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable write config snapshot, got: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable write config snapshot, got: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("unable replace config snapshot '%s', got: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	frpclient "github.com/fatedier/frp/client"
+	"github.com/fatedier/frp/client/proxy"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config/builder"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	frputil "github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Names of the conformance checks, in the order they run
+const (
+	CheckLogin     = "login"
+	CheckProxy     = "proxy"
+	CheckDataPlane = "data-plane"
+	CheckHeartbeat = "heartbeat"
+)
+
+// Checks are the conformance checks, a check only runs once the previous ones passed
+var Checks = []string{CheckLogin, CheckProxy, CheckDataPlane, CheckHeartbeat}
+
+const (
+	// checkTimeout bounds the time each check waits for the server
+	checkTimeout = 15 * time.Second
+	// heartbeatInterval and heartbeatTimeout are the aggressive heartbeat settings of the test client, the
+	// client closes the control connection once the server doesn't answer its pings within the timeout
+	heartbeatInterval = 1
+	heartbeatTimeout  = 3
+	// heartbeatWindow is the time the control connection must survive on heartbeats alone
+	heartbeatWindow = 2 * heartbeatTimeout * time.Second
+	// pollInterval is the interval the proxy status is polled at
+	pollInterval = 200 * time.Millisecond
+)
+
+// Result is the outcome of a conformance check
+type Result struct {
+	Name     string
+	Passed   bool
+	Duration time.Duration
+	// Message describes why the check failed or was skipped
+	Message string
+}
+
+// Report is the outcome of a conformance run against a frps
+type Report struct {
+	// Server is the name of the FrpServer
+	Server  string
+	Results []Result
+}
+
+// Passed returns the number of passed checks
+func (r *Report) Passed() int {
+	return lo.CountBy(r.Results, func(result Result) bool { return result.Passed })
+}
+
+// Failed returns the names of the checks which failed or were skipped
+func (r *Report) Failed() []string {
+	return lo.FilterMap(r.Results, func(result Result, _ int) (string, bool) { return result.Name, !result.Passed })
+}
+
+// Summary describes the report in one line, e.g. "3/4 checks passed, failed: heartbeat"
+func (r *Report) Summary() string {
+	summary := fmt.Sprintf("%d/%d checks passed", r.Passed(), len(r.Results))
+	if failed := r.Failed(); len(failed) != 0 {
+		summary += ", failed: " + strings.Join(failed, ", ")
+	}
+	return summary
+}
+
+// Write writes the results as a table followed by the summary
+func (r *Report) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%-12s %-8s %-10s %s\n", "CHECK", "RESULT", "DURATION", "MESSAGE")
+	for _, result := range r.Results {
+		fmt.Fprintf(b, "%-12s %-8s %-10s %s\n", result.Name, lo.Ternary(result.Passed, "PASS", "FAIL"),
+			result.Duration.Round(time.Millisecond), result.Message)
+	}
+	fmt.Fprintf(b, "frp server %s: %s\n", r.Server, r.Summary())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Run runs the conformance checks against the frps of the FrpServer: it logs in, registers a tcp proxy,
+// relays data through the proxy to an in-process echo backend and keeps the control connection alive on
+// heartbeats alone. It's meant to qualify alternative frps implementations, the proxy is removed once the
+// run completes. creds may be nil, the transport tls files of spec.transport.tls.secretRef are not used.
+func Run(ctx context.Context, obj *v1beta1.FrpServer, creds *credentials.Credentials) *Report {
+	report := &Report{Server: obj.Name}
+	r := &runner{obj: obj, report: report}
+	defer r.close()
+	for _, check := range []func(context.Context) error{r.login, r.proxy, r.dataPlane, r.heartbeat} {
+		name := Checks[len(report.Results)]
+		if len(report.Failed()) != 0 {
+			report.Results = append(report.Results, Result{Name: name, Message: "skipped, a previous check failed"})
+			continue
+		}
+		if name == CheckLogin {
+			if err := r.start(ctx, creds, builder.NewTCPProxy("conformance-"+randomID())); err != nil {
+				report.Results = append(report.Results, Result{Name: name, Message: err.Error()})
+				continue
+			}
+		}
+		start := time.Now()
+		err := check(ctx)
+		result := Result{Name: name, Passed: err == nil, Duration: time.Since(start)}
+		if err != nil {
+			result.Message = err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// runner holds the in-process frp client session shared by the checks
+type runner struct {
+	obj      *v1beta1.FrpServer
+	report   *Report
+	echo     net.Listener
+	svc      *frpclient.Service
+	cancel   context.CancelFunc
+	done     chan error
+	proxyCfg configv1.ProxyConfigurer
+	remote   string
+}
+
+// start starts the echo backend and the frp client with the proxy forwarding to it
+func (r *runner) start(ctx context.Context, creds *credentials.Credentials, proxyBuilder *builder.ProxyBuilder) error {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("unable listen echo backend, got: %w", err)
+	}
+	r.echo = echo
+	go serveEcho(echo)
+
+	common := frputil.ClientCommonConfig(r.obj, creds)
+	common.Transport.Protocol = string(util.EmptyOr(r.obj.Status.ActiveProtocol, r.obj.Spec.Transport.Protocol))
+	common.Transport.HeartbeatInterval, common.Transport.HeartbeatTimeout = heartbeatInterval, heartbeatTimeout
+	common.LoginFailExit = lo.ToPtr(true)
+	common.Complete()
+
+	r.proxyCfg, err = proxyBuilder.
+		LocalIP("127.0.0.1").
+		LocalPort(echo.Addr().(*net.TCPAddr).Port).
+		NamePrefix(common.User).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	server := r.obj
+	r.svc, err = frpclient.NewService(frpclient.ServiceOptions{
+		Common:    &common,
+		ProxyCfgs: []configv1.ProxyConfigurer{r.proxyCfg},
+		ConnectorCreator: func(ctx context.Context, cfg *configv1.ClientCommonConfig) frpclient.Connector {
+			return frputil.NewConnector(ctx, server, cfg)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable create frp client, got: %w", err)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	r.cancel, r.done = cancel, make(chan error, 1)
+	go func() {
+		r.done <- r.svc.Run(runCtx)
+	}()
+	return nil
+}
+
+// status polls the proxy status until ready reports true, the client exiting fails the poll
+func (r *runner) status(ctx context.Context, ready func(*proxy.WorkingStatus) bool) (*proxy.WorkingStatus, error) {
+	deadline := time.Now().Add(checkTimeout)
+	for {
+		if status, err := r.svc.GetProxyStatus(r.proxyCfg.GetBaseConfig().Name); err == nil && ready(status) {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s", checkTimeout)
+		}
+		select {
+		case err := <-r.done:
+			r.done <- err
+			return nil, fmt.Errorf("frp client exited, got: %v", err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// login checks the server accepts the login, the proxy status is only known once the control is running
+func (r *runner) login(ctx context.Context) error {
+	_, err := r.status(ctx, func(*proxy.WorkingStatus) bool { return true })
+	return err
+}
+
+// proxy checks the server registers the tcp proxy on a remote port
+func (r *runner) proxy(ctx context.Context) error {
+	status, err := r.status(ctx, func(status *proxy.WorkingStatus) bool {
+		return status.Phase == proxy.ProxyPhaseRunning || status.Phase == proxy.ProxyPhaseStartErr
+	})
+	if err != nil {
+		return err
+	}
+	if status.Phase != proxy.ProxyPhaseRunning {
+		return fmt.Errorf("proxy %s was rejected: %s", r.proxyCfg.GetBaseConfig().Name, status.Err)
+	}
+	_, port, err := net.SplitHostPort(status.RemoteAddr)
+	if err != nil {
+		return fmt.Errorf("invalid remote address '%s' of proxy %s, got: %w", status.RemoteAddr, r.proxyCfg.GetBaseConfig().Name, err)
+	}
+	r.remote = net.JoinHostPort(r.obj.Spec.ServerAddr, port)
+	return nil
+}
+
+// dataPlane checks the data sent to the remote port of the proxy is relayed to the backend and back
+func (r *runner) dataPlane(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: checkTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.remote)
+	if err != nil {
+		return fmt.Errorf("unable dial proxy remote address %s, got: %w", r.remote, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(checkTimeout))
+	payload := make([]byte, 64)
+	_, _ = rand.Read(payload)
+	if _, err := conn.Write(payload); err != nil {
+		return fmt.Errorf("unable write to proxy remote address %s, got: %w", r.remote, err)
+	}
+	echoed := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		return fmt.Errorf("unable read the echo through proxy remote address %s, got: %w", r.remote, err)
+	}
+	if !bytes.Equal(payload, echoed) {
+		return errors.New("the data relayed through the proxy was corrupted")
+	}
+	return nil
+}
+
+// heartbeat checks the control connection survives on heartbeats, the client closes it once the server
+// stops answering the pings
+func (r *runner) heartbeat(ctx context.Context) error {
+	select {
+	case err := <-r.done:
+		r.done <- err
+		return fmt.Errorf("frp client exited, got: %v", err)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(heartbeatWindow):
+	}
+	status, err := r.svc.GetProxyStatus(r.proxyCfg.GetBaseConfig().Name)
+	if err != nil {
+		return fmt.Errorf("control connection was closed within %s, got: %w", heartbeatWindow, err)
+	}
+	if status.Phase != proxy.ProxyPhaseRunning {
+		return fmt.Errorf("proxy %s is %s after %s: %s", r.proxyCfg.GetBaseConfig().Name, status.Phase, heartbeatWindow, status.Err)
+	}
+	return nil
+}
+
+func (r *runner) close() {
+	if r.svc != nil {
+		r.svc.Close()
+		r.cancel()
+		<-r.done
+	}
+	if r.echo != nil {
+		_ = r.echo.Close()
+	}
+}
+
+// randomID returns a random suffix of the proxy names, so concurrent runs don't collide
+func randomID() string {
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// serveEcho echoes the data of the connections accepted by the listener until it's closed
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() {
+				_ = conn.Close()
+			}()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conformance
+
+import (
+	"context"
+	"github.com/fatedier/frp/client/proxy"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config/builder"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+)
+
+// ProbeTCPMuxHTTPConnect reports whether the frps of the FrpServer registers tcpmux proxies multiplexed with
+// httpconnect, frps rejects them unless its tcpmuxHTTPConnectPort is set. The probe proxy is routed by a
+// reserved .invalid domain and removed once the probe completes. creds may be nil.
+func ProbeTCPMuxHTTPConnect(ctx context.Context, obj *v1beta1.FrpServer, creds *credentials.Credentials) (bool, error) {
+	r := &runner{obj: obj}
+	defer r.close()
+	name := "probe-" + randomID()
+	if err := r.start(ctx, creds, builder.NewTCPMuxProxy(name).CustomDomains(name+".invalid")); err != nil {
+		return false, err
+	}
+	status, err := r.status(ctx, func(status *proxy.WorkingStatus) bool {
+		return status.Phase == proxy.ProxyPhaseRunning || status.Phase == proxy.ProxyPhaseStartErr
+	})
+	if err != nil {
+		return false, err
+	}
+	return status.Phase == proxy.ProxyPhaseRunning, nil
+}
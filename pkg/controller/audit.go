@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/version"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sync"
+	"time"
+)
+
+// reconcileStamp identifies the manager instance in AnnotationReconciledByKey
+var reconcileStamp = sync.OnceValue(func() string {
+	info := version.Get()
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%s@%s", info.GitVersion, info.GitCommit, hostname)
+})
+
+// stampReconcile records the manager instance and the time of the reconcile in the audit annotations of the
+// object. They're only patched when another version or instance reconciled the object last or the recorded time
+// is older than ReconcileAuditInterval, so the idle reconciles don't write the object each time.
+func stampReconcile(ctx context.Context, c client.Client, options *config.ManagerOptions, obj client.Object) error {
+	if options.ReconcileAuditInterval <= 0 {
+		return nil
+	}
+	annotations := obj.GetAnnotations()
+	if annotations[v1beta1.AnnotationReconciledByKey] == reconcileStamp() {
+		last, err := time.Parse(time.RFC3339, annotations[v1beta1.AnnotationLastReconcileKey])
+		if err == nil && time.Since(last) < options.ReconcileAuditInterval {
+			return nil
+		}
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[v1beta1.AnnotationReconciledByKey] = reconcileStamp()
+	annotations[v1beta1.AnnotationLastReconcileKey] = time.Now().UTC().Format(time.RFC3339)
+	obj.SetAnnotations(annotations)
+	return c.Patch(ctx, obj, patch)
+}
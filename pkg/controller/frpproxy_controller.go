@@ -0,0 +1,372 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/client/proxy"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/config/builder"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/service"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"net"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strconv"
+	"time"
+)
+
+const (
+	// frpProxyPendingInterval is the interval the registration of a pending proxy is checked at
+	frpProxyPendingInterval = 5 * time.Second
+	// frpProxyResyncInterval is the interval the status of a running proxy is refreshed at
+	frpProxyResyncInterval = time.Minute
+)
+
+// FrpProxyReconciler registers the proxies of the FrpProxy objects through the frp client the service.Sessions
+// runs for their FrpServer.
+type FrpProxyReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Options  *config.ManagerOptions
+	Recorder record.EventRecorder
+	Sessions *service.Sessions
+	// Credentials resolves the frp credentials the proxies are registered with
+	Credentials *credentials.Resolver
+}
+
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpproxies,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpproxies/status,verbs=get;update;patch
+
+// Reconcile registers the FrpProxy once it's valid and its FrpServer is healthy, and records the result in its status
+func (r *FrpProxyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	obj := &frpv1beta1.FrpProxy{}
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.remove(ctx, req.NamespacedName)
+		}
+		logger.Error(err, "unable get frpproxy by name", "request", req.String())
+		return ctrl.Result{}, err
+	}
+	expiresIn, expires := frpProxyExpiresIn(obj)
+	if expires && expiresIn <= 0 {
+		logger.Info("deleting expired frpproxy", "request", req.String())
+		r.Recorder.Event(obj, v1.EventTypeNormal, frpv1beta1.ReasonProxyExpired,
+			fmt.Sprintf("Expired at %s", obj.Annotations[frpv1beta1.AnnotationExpiresAtKey]))
+		return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, obj))
+	}
+	phase, reason, remoteAddr, err := r.register(ctx, obj)
+	if err != nil {
+		logger.Error(err, "unable register frpproxy", "request", req.String())
+		return ctrl.Result{}, err
+	}
+	result := ctrl.Result{RequeueAfter: lo.Ternary(phase == frpv1beta1.FrpProxyPhaseRunning, frpProxyResyncInterval, frpProxyPendingInterval)}
+	if phase == frpv1beta1.FrpProxyPhaseFailed {
+		// the proxy is only registered again once its spec or its FrpServer changes
+		result = ctrl.Result{}
+	}
+	if expires && (result.RequeueAfter == 0 || expiresIn < result.RequeueAfter) {
+		result.RequeueAfter = expiresIn
+	}
+	if obj.Status.Phase == phase && obj.Status.Reason == reason && obj.Status.RemoteAddr == remoteAddr &&
+		obj.Status.ObservedGeneration == obj.Generation {
+		return result, nil
+	}
+	if phase != obj.Status.Phase {
+		switch phase {
+		case frpv1beta1.FrpProxyPhaseRunning:
+			r.Recorder.Event(obj, v1.EventTypeNormal, frpv1beta1.ReasonProxyRunning, reason)
+		case frpv1beta1.FrpProxyPhaseFailed:
+			r.Recorder.Event(obj, v1.EventTypeWarning, frpv1beta1.ReasonProxyFailed, reason)
+		}
+	}
+	obj.Status.Phase, obj.Status.Reason, obj.Status.RemoteAddr, obj.Status.ObservedGeneration = phase, reason, remoteAddr, obj.Generation
+	return result, r.Status().Update(ctx, obj)
+}
+
+// register pushes the proxy of the FrpProxy to its FrpServer and returns the phase of the proxy, the error
+// is only returned when the registration should be retried with a backoff
+func (r *FrpProxyReconciler) register(ctx context.Context, obj *frpv1beta1.FrpProxy) (frpv1beta1.FrpProxyPhase, string, string, error) {
+	key := client.ObjectKeyFromObject(obj)
+	server := &frpv1beta1.FrpServer{}
+	if err := r.Get(ctx, client.ObjectKey{Name: obj.Spec.ServerName}, server); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", "", "", err
+		}
+		return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("frpserver '%s' does not exist", obj.Spec.ServerName), "", r.remove(ctx, key)
+	}
+	// A registered proxy is kept while its FrpServer is unhealthy, the frp client retries the login
+	if server.Status.Phase != frpv1beta1.FrpServerPhaseHealthy {
+		return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("frpserver '%s' is not healthy", server.Name), "", nil
+	}
+	backend := &v1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: obj.Spec.Backend.ServiceName}, backend); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", "", "", err
+		}
+		return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("service '%s' does not exist", obj.Spec.Backend.ServiceName), "", r.remove(ctx, key)
+	}
+	cfg, err := r.proxyConfig(ctx, obj, server)
+	if err != nil {
+		return frpv1beta1.FrpProxyPhaseFailed, err.Error(), "", r.remove(ctx, key)
+	}
+	ranges, err := frpclient.ParseSourceRanges(obj.Annotations)
+	if err != nil {
+		return frpv1beta1.FrpProxyPhaseFailed, err.Error(), "", r.remove(ctx, key)
+	}
+	if r.Options.Observing() {
+		return frpv1beta1.FrpProxyPhasePending, "proxies are not registered in the observe mode", "", nil
+	}
+	creds, err := r.Credentials.Resolve(ctx, r.Client, server)
+	if err != nil {
+		return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("unable resolve frp credentials of frpserver '%s', got: %v", server.Name, err), "", nil
+	}
+	if err := r.Sessions.Apply(ctx, server, creds, key.String(), cfg, ranges); err != nil {
+		metrics.ProxyCreateFailuresTotal.WithLabelValues("FrpProxy", server.Name).Inc()
+		return "", "", "", err
+	}
+	status, err := r.Sessions.Status(key.String())
+	if err != nil {
+		return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("waiting for the frp client to log in to frpserver '%s'", server.Name), "", nil
+	}
+	switch status.Phase {
+	case proxy.ProxyPhaseRunning:
+		if !r.Sessions.Warm(key.String()) {
+			return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("waiting for a pooled work connection to frpserver '%s'", server.Name), "", nil
+		}
+		return frpv1beta1.FrpProxyPhaseRunning, fmt.Sprintf("Registered on FrpServer %s", server.Name), remoteAddr(server, status.RemoteAddr), nil
+	case proxy.ProxyPhaseStartErr, proxy.ProxyPhaseCheckFailed:
+		metrics.ProxyCreateFailuresTotal.WithLabelValues("FrpProxy", server.Name).Inc()
+		return frpv1beta1.FrpProxyPhaseFailed, fmt.Sprintf("proxy was rejected by frpserver '%s': %s", server.Name, status.Err), "", nil
+	}
+	return frpv1beta1.FrpProxyPhasePending, fmt.Sprintf("proxy is %s", status.Phase), "", nil
+}
+
+// remove unregisters the proxy of the FrpProxy and removes the certificate files of its client plugin
+func (r *FrpProxyReconciler) remove(ctx context.Context, key client.ObjectKey) error {
+	if err := frpclient.RemovePluginCert(frpProxyName(key)); err != nil {
+		log.FromContext(ctx).Error(err, "unable remove plugin cert files of frpproxy", "request", key.String())
+	}
+	return r.Sessions.Remove(ctx, key.String())
+}
+
+// frpProxyExpiresIn returns the time left until the expires-at annotation of the FrpProxy, an annotation which is
+// not a RFC3339 time is ignored
+func frpProxyExpiresIn(obj *frpv1beta1.FrpProxy) (time.Duration, bool) {
+	value, ok := obj.Annotations[frpv1beta1.AnnotationExpiresAtKey]
+	if !ok {
+		return 0, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Until(expiresAt), true
+}
+
+// frpProxyName returns the name of the proxy of the FrpProxy before it's prefixed with the frp user
+func frpProxyName(key client.ObjectKey) string {
+	return key.Namespace + "." + key.Name
+}
+
+// backendAddr returns the in-cluster address of the backend Service port of the FrpProxy
+func backendAddr(obj *frpv1beta1.FrpProxy) (string, int) {
+	return fmt.Sprintf("%s.%s.svc", obj.Spec.Backend.ServiceName, obj.Namespace), int(obj.Spec.Backend.Port)
+}
+
+// proxyConfig builds the frp proxy config of the FrpProxy, spec.proxyDefaults of the FrpServer applies to the
+// fields the FrpProxy doesn't set. The proxy is named "{namespace}.{name}", prefixed with the frp user.
+func (r *FrpProxyReconciler) proxyConfig(ctx context.Context, obj *frpv1beta1.FrpProxy, server *frpv1beta1.FrpServer) (configv1.ProxyConfigurer, error) {
+	if err := checkAllowedProxyType(r.Options, server, obj.Spec.Type); err != nil {
+		return nil, err
+	}
+	defaults := &configv1.ProxyBaseConfig{}
+	if err := frpclient.ApplyProxyDefaults(defaults, server, obj.Annotations); err != nil {
+		return nil, fmt.Errorf("invalid spec.proxyDefaults of frpserver '%s', got: %w", server.Name, err)
+	}
+	host, port := backendAddr(obj)
+	b := builder.NewProxy(configv1.ProxyType(obj.Spec.Type), frpProxyName(client.ObjectKeyFromObject(obj))).
+		NamePrefix(server.Spec.User).
+		LocalIP(host).
+		LocalPort(port).
+		Encryption(lo.FromPtrOr(obj.Spec.UseEncryption, defaults.Transport.UseEncryption)).
+		Compression(lo.FromPtrOr(obj.Spec.UseCompression, defaults.Transport.UseCompression)).
+		HealthCheck(defaults.HealthCheck).
+		Metadata(frpv1beta1.ProxyMetadataOwnerKey, routeOwner(routeOwnerFrpProxy, client.ObjectKeyFromObject(obj)))
+	if limit := defaults.Transport.BandwidthLimit.String(); limit != "" {
+		b.BandwidthLimit(limit, defaults.Transport.BandwidthLimitMode)
+	}
+	if obj.Spec.RemotePort != 0 {
+		b.RemotePort(obj.Spec.RemotePort)
+	}
+	if len(obj.Spec.CustomDomains) != 0 {
+		b.CustomDomains(obj.Spec.CustomDomains...)
+	}
+	if obj.Spec.Subdomain != "" {
+		b.SubDomain(obj.Spec.Subdomain)
+	}
+	if len(obj.Spec.Locations) != 0 {
+		b.Locations(obj.Spec.Locations...)
+	}
+	if obj.Spec.HostHeaderRewrite != "" {
+		b.HostHeaderRewrite(obj.Spec.HostHeaderRewrite)
+	}
+	if obj.Spec.HTTPAuthSecretRef != nil {
+		user, password, err := r.httpAuth(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		b.HTTPBasicAuth(user, password)
+	}
+	if obj.Spec.Plugin != nil {
+		options, err := r.pluginOptions(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		b.Plugin(obj.Spec.Plugin.Type, options)
+	}
+	if obj.Spec.SecretKeyRef != nil || len(obj.Spec.AllowUsers) != 0 {
+		secretKey, err := r.secretKey(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		b.SecretKey(secretKey, obj.Spec.AllowUsers...)
+	}
+	cfg, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := frpclient.CheckProxyCompatibility(cfg, server.Status.ServerVersion); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// pluginOptions builds the options of the client plugin of the FrpProxy, the plugin forwards to the backend and
+// serves the certificate of spec.plugin.certSecretRef
+func (r *FrpProxyReconciler) pluginOptions(ctx context.Context, obj *frpv1beta1.FrpProxy) (configv1.ClientPluginOptions, error) {
+	plugin := obj.Spec.Plugin
+	if obj.Spec.Type != frpv1beta1.ProxyTypeHTTPS {
+		return nil, fmt.Errorf("spec.plugin only applies to proxy type %s", frpv1beta1.ProxyTypeHTTPS)
+	}
+	var certPath, keyPath string
+	if plugin.CertSecretRef != nil {
+		secret := &v1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: plugin.CertSecretRef.Name}, secret); err != nil {
+			return nil, fmt.Errorf("unable get secret '%s' of spec.plugin.certSecretRef, got: %w", plugin.CertSecretRef.Name, err)
+		}
+		for _, key := range []string{v1.TLSCertKey, v1.TLSPrivateKeyKey} {
+			if len(secret.Data[key]) == 0 {
+				return nil, fmt.Errorf("secret '%s' of spec.plugin.certSecretRef has no key '%s'", plugin.CertSecretRef.Name, key)
+			}
+		}
+		var err error
+		certPath, keyPath, err = frpclient.WritePluginCert(frpProxyName(client.ObjectKeyFromObject(obj)), secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, err
+		}
+	}
+	host, port := backendAddr(obj)
+	localAddr := net.JoinHostPort(host, strconv.Itoa(port))
+	headers := configv1.HeaderOperations{Set: plugin.RequestHeaders}
+	switch plugin.Type {
+	case frpv1beta1.PluginHTTPS2HTTP:
+		return &configv1.HTTPS2HTTPPluginOptions{Type: plugin.Type, LocalAddr: localAddr, HostHeaderRewrite: plugin.HostHeaderRewrite,
+			RequestHeaders: headers, CrtPath: certPath, KeyPath: keyPath}, nil
+	case frpv1beta1.PluginHTTPS2HTTPS:
+		return &configv1.HTTPS2HTTPSPluginOptions{Type: plugin.Type, LocalAddr: localAddr, HostHeaderRewrite: plugin.HostHeaderRewrite,
+			RequestHeaders: headers, CrtPath: certPath, KeyPath: keyPath}, nil
+	}
+	return nil, fmt.Errorf("invalid spec.plugin.type '%s', optional values are %v", plugin.Type, frpv1beta1.ClientPlugins)
+}
+
+// secretKey reads the key spec.secretKeyRef references, it's empty when spec.secretKeyRef is not set
+func (r *FrpProxyReconciler) secretKey(ctx context.Context, obj *frpv1beta1.FrpProxy) (string, error) {
+	ref := obj.Spec.SecretKeyRef
+	if ref == nil {
+		return "", nil
+	}
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: ref.Name}, secret); err != nil {
+		return "", fmt.Errorf("unable get secret '%s' of spec.secretKeyRef, got: %w", ref.Name, err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret '%s' of spec.secretKeyRef has no key '%s'", ref.Name, ref.Key)
+	}
+	return string(value), nil
+}
+
+// httpAuth reads the user and password of the http proxy from spec.httpAuthSecretRef
+func (r *FrpProxyReconciler) httpAuth(ctx context.Context, obj *frpv1beta1.FrpProxy) (string, string, error) {
+	name := obj.Spec.HTTPAuthSecretRef.Name
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: name}, secret); err != nil {
+		return "", "", fmt.Errorf("unable get secret '%s' of spec.httpAuthSecretRef, got: %w", name, err)
+	}
+	user, password := secret.Data[v1.BasicAuthUsernameKey], secret.Data[v1.BasicAuthPasswordKey]
+	if len(user) == 0 || len(password) == 0 {
+		return "", "", fmt.Errorf("secret '%s' of spec.httpAuthSecretRef should hold the keys '%s' and '%s'",
+			name, v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey)
+	}
+	return string(user), string(password), nil
+}
+
+// remoteAddr returns the address the proxy is served on, frps reports the remote port of the tcp and udp
+// proxies without the host
+func remoteAddr(server *frpv1beta1.FrpServer, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort(server.Spec.ServerAddr, port)
+}
+
+// mapFrpServerToProxies enqueue the FrpProxy objects referencing a FrpServer
+func (r *FrpProxyReconciler) mapFrpServerToProxies(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	proxyList := &frpv1beta1.FrpProxyList{}
+	if err := r.List(ctx, proxyList); err != nil {
+		logger.Error(err, "unable get frpproxy list")
+		return nil
+	}
+	return lo.FilterMap(proxyList.Items, func(item frpv1beta1.FrpProxy, _ int) (reconcile.Request, bool) {
+		return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)}, item.Spec.ServerName == obj.GetName()
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FrpProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&frpv1beta1.FrpProxy{}).
+		Watches(&frpv1beta1.FrpServer{}, handler.EnqueueRequestsFromMapFunc(r.mapFrpServerToProxies)).
+		Complete(metrics.InstrumentReconciler("frpproxy", r, false))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+func TestFrpProxyName(t *testing.T) {
+	tests := []struct {
+		name string
+		key  client.ObjectKey
+		want string
+	}{
+		{
+			name: "namespaced proxy",
+			key:  client.ObjectKey{Namespace: "default", Name: "web"},
+			want: "default.web",
+		},
+		{
+			name: "dotted name",
+			key:  client.ObjectKey{Namespace: "team-a", Name: "web.v2"},
+			want: "team-a.web.v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := frpProxyName(tt.key); got != tt.want {
+				t.Fatalf("frpProxyName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackendAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		obj      *frpv1beta1.FrpProxy
+		wantHost string
+		wantPort int
+	}{
+		{
+			name: "service port in the namespace of the proxy",
+			obj: &frpv1beta1.FrpProxy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Spec:       frpv1beta1.FrpProxySpec{Backend: frpv1beta1.FrpProxyBackend{ServiceName: "nginx", Port: 8080}},
+			},
+			wantHost: "nginx.default.svc",
+			wantPort: 8080,
+		},
+		{
+			name: "highest port",
+			obj: &frpv1beta1.FrpProxy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "dns"},
+				Spec:       frpv1beta1.FrpProxySpec{Backend: frpv1beta1.FrpProxyBackend{ServiceName: "coredns", Port: 65535}},
+			},
+			wantHost: "coredns.team-a.svc",
+			wantPort: 65535,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := backendAddr(tt.obj)
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Fatalf("backendAddr() = %v, %v, want %v, %v", host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	server := &frpv1beta1.FrpServer{Spec: frpv1beta1.FrpServerSpec{ServerAddr: "frps.example.com"}}
+	tests := []struct {
+		name string
+		addr string
+		want string
+	}{
+		{
+			name: "port without host",
+			addr: ":6000",
+			want: "frps.example.com:6000",
+		},
+		{
+			name: "host and port",
+			addr: "10.0.0.1:6000",
+			want: "10.0.0.1:6000",
+		},
+		{
+			name: "vhost address",
+			addr: "web.example.com",
+			want: "web.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remoteAddr(server, tt.addr); got != tt.want {
+				t.Fatalf("remoteAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/conformance"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strconv"
+)
+
+// capabilitiesDue reports whether the optional features of the frps of the FrpServer should be probed, they're
+// probed once per generation
+func capabilitiesDue(obj *frpv1beta1.FrpServer) bool {
+	return obj.Status.Capabilities == nil || obj.Status.Capabilities.ObservedGeneration != obj.Generation
+}
+
+// syncCapabilities probes the optional features of the frps of the FrpServer and stores them in its status, an
+// inconclusive probe leaves the feature unset so the services relying on it aren't rejected.
+func (r *FrpServerReconciler) syncCapabilities(ctx context.Context, obj *frpv1beta1.FrpServer, creds *credentials.Credentials) {
+	logger := log.FromContext(ctx)
+	capabilities := &frpv1beta1.FrpServerCapabilities{ObservedGeneration: obj.Generation, LastProbeTime: metav1.Now()}
+	if enabled, err := conformance.ProbeTCPMuxHTTPConnect(ctx, obj, creds); err != nil {
+		logger.Error(err, "unable probe tcpmux httpconnect of frp server")
+	} else {
+		capabilities.TCPMuxHTTPConnect = &enabled
+	}
+	obj.Status.Capabilities = capabilities
+}
+
+// capabilitiesChanged passes the FrpServer updates changing the probed capabilities
+var capabilitiesChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		previous, ok := e.ObjectOld.(*frpv1beta1.FrpServer)
+		current, ok2 := e.ObjectNew.(*frpv1beta1.FrpServer)
+		if !ok || !ok2 {
+			return false
+		}
+		return tcpMuxCapability(previous) != tcpMuxCapability(current)
+	},
+}
+
+// tcpMuxCapability returns whether the FrpServer supports tcpmux httpconnect, "" when it's not known
+func tcpMuxCapability(obj *frpv1beta1.FrpServer) string {
+	if obj.Status.Capabilities == nil || obj.Status.Capabilities.TCPMuxHTTPConnect == nil {
+		return ""
+	}
+	return strconv.FormatBool(*obj.Status.Capabilities.TCPMuxHTTPConnect)
+}
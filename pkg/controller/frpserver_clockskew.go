@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// syncClockSkew measures the clock skew of the frps host and sets the ClockSkewed condition, a warning is
+// emitted once the skew exceeds the threshold since the frps rejects the auth timestamps of a skewed client
+// without telling why. The condition and the metric are removed when spec.clockSkew is unset.
+func (r *FrpServerReconciler) syncClockSkew(ctx context.Context, obj *frpv1beta1.FrpServer) {
+	if obj.Spec.ClockSkew == nil {
+		meta.RemoveStatusCondition(&obj.Status.Conditions, frpv1beta1.FrpServerConditionClockSkewed)
+		metrics.ClockSkewSeconds.DeleteLabelValues(obj.Name)
+		return
+	}
+	logger := log.FromContext(ctx)
+	wasSkewed := meta.IsStatusConditionTrue(obj.Status.Conditions, frpv1beta1.FrpServerConditionClockSkewed)
+	skew, err := frpclient.MeasureClockSkew(ctx, obj)
+	if err != nil {
+		logger.Error(err, "Unable measure clock skew of resource object")
+		metrics.ClockSkewSeconds.DeleteLabelValues(obj.Name)
+		meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+			Type:               frpv1beta1.FrpServerConditionClockSkewed,
+			Status:             metav1.ConditionUnknown,
+			Reason:             frpv1beta1.ReasonClockSkewUnknown,
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Message:            fmt.Sprintf("Unable measure clock skew: %s", err.Error()),
+		})
+		return
+	}
+	metrics.ClockSkewSeconds.WithLabelValues(obj.Name).Set(skew.Seconds())
+	threshold := frpclient.ClockSkewThreshold(obj)
+	if skew.Abs() <= threshold {
+		meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+			Type:               frpv1beta1.FrpServerConditionClockSkewed,
+			Status:             metav1.ConditionFalse,
+			Reason:             frpv1beta1.ReasonClockInSync,
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Message:            fmt.Sprintf("Clock of frp server host is within %s of the manager", threshold),
+		})
+		return
+	}
+	message := fmt.Sprintf("Clock of frp server host is off by %s, more than the threshold %s, "+
+		"the token auth with timestamps is likely rejected", skew.Round(time.Second), threshold)
+	meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+		Type:               frpv1beta1.FrpServerConditionClockSkewed,
+		Status:             metav1.ConditionTrue,
+		Reason:             frpv1beta1.ReasonClockSkewed,
+		LastTransitionTime: metav1.NewTime(time.Now()),
+		Message:            message,
+	})
+	if !wasSkewed {
+		logger.Info("Clock of resource object is skewed", "skew", skew.String(), "threshold", threshold.String())
+		r.Recorder.Event(obj, v1.EventTypeWarning, frpv1beta1.ReasonClockSkewed, message)
+	}
+}
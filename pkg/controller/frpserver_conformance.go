@@ -0,0 +1,54 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/conformance"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strconv"
+)
+
+// conformanceDue reports whether the conformance checks should run against the frps of the FrpServer, they run
+// once per generation when the FrpServer opts in with the frp.gofrp.io/conformance annotation.
+func conformanceDue(obj *frpv1beta1.FrpServer) bool {
+	enabled, _ := strconv.ParseBool(obj.Annotations[frpv1beta1.AnnotationConformanceKey])
+	return enabled && (obj.Status.Conformance == nil || obj.Status.Conformance.ObservedGeneration != obj.Generation)
+}
+
+// syncConformance runs the conformance checks against the frps of the FrpServer and stores the summary in
+// its status, an event is emitted when a check failed.
+func (r *FrpServerReconciler) syncConformance(ctx context.Context, obj *frpv1beta1.FrpServer, creds *credentials.Credentials) {
+	logger := log.FromContext(ctx)
+	report := conformance.Run(ctx, obj, creds)
+	obj.Status.Conformance = &frpv1beta1.FrpServerConformance{
+		ObservedGeneration: obj.Generation,
+		Passed:             int32(report.Passed()),
+		Total:              int32(len(report.Results)),
+		Failed:             report.Failed(),
+		Message:            report.Summary(),
+		LastRunTime:        metav1.Now(),
+	}
+	if len(obj.Status.Conformance.Failed) != 0 {
+		logger.Info("Frp server of resource object failed conformance checks", "failed", obj.Status.Conformance.Failed)
+		r.Recorder.Event(obj, v1.EventTypeWarning, frpv1beta1.ReasonConformanceFailed, report.Summary())
+	}
+}
@@ -19,23 +19,57 @@ package controller
 import (
 	"context"
 	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
 	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/service"
 	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"time"
 )
 
+const (
+	// credentialsRetryInterval is the interval to retry resolving unavailable external credentials
+	credentialsRetryInterval = 30 * time.Second
+	// credentialsRenewFraction is the fraction of the credentials TTL after which they are resolved again
+	credentialsRenewFraction = 0.8
+	// clockSkewProbeInterval is the interval to measure the clock skew of the frps host again
+	clockSkewProbeInterval = 10 * time.Minute
+	// routeGCInterval is the interval to collect the stale routes of the frps again
+	routeGCInterval = 5 * time.Minute
+	// frpServerControllerName labels the metrics of the frpserver controller
+	frpServerControllerName = "frpserver"
+	// phaseHistoryLimit is the number of phase transitions kept in the status of a FrpServer
+	phaseHistoryLimit = 10
+)
+
 // FrpServerReconciler reconciles a FrpServer object
 type FrpServerReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Options  *config.ManagerOptions
+	Recorder record.EventRecorder
+	// Outages is shared with the ServiceReconciler, the queued services are released once the server is healthy
+	Outages *OutageQueue
+	// Sessions is shared with the FrpProxyReconciler, the proxies it registered are listed in the status of
+	// their FrpServer. The status lists no proxies when nil.
+	Sessions *service.Sessions
+	// AuthSetters caches the auth setter of each FrpServer across its logins, a new one is used by each login when nil
+	AuthSetters *frpclient.AuthSetters
+	// Credentials resolves the frp credentials the FrpServers are validated with
+	Credentials *credentials.Resolver
 }
 
 //+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpservers,verbs=get;list;watch;create;update;patch;delete
@@ -43,6 +77,11 @@ type FrpServerReconciler struct {
 //+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpservers/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=secrets/status,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpserverclaims,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -53,19 +92,92 @@ func (r *FrpServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	err := r.Get(ctx, req.NamespacedName, &obj)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			r.AuthSetters.Forget(req.Name)
 			return ctrl.Result{}, nil
 		}
 		logger.Error(err, "get resource object failed.", "request", req.String())
 		return ctrl.Result{}, nil
 	}
 
-	// Set phase to FrpServerPhasePending and wait next Reconcile
-	if obj.Status.Phase == frpv1beta1.FrpServerPhaseUnknown {
+	if obj.DeletionTimestamp != nil {
+		r.Outages.Release(obj.Name)
+		metrics.ClockSkewSeconds.DeleteLabelValues(obj.Name)
+		r.AuthSetters.Forget(obj.Name)
+		return ctrl.Result{}, r.finalizeFrpServer(ctx, &obj)
+	}
+	if !lo.Contains(obj.Finalizers, frplabels.FrpServerFinalizer) {
+		obj.Finalizers = append(obj.Finalizers, frplabels.FrpServerFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &obj)
+	}
+	// the audit annotations are best effort, they mustn't hold back the health probe
+	if err := stampReconcile(ctx, r.Client, r.Options, &obj); err != nil {
+		logger.Error(err, "Unable stamp reconcile audit annotations of resource object")
+	}
+
+	// original is compared with the status before it's written, an unchanged status is not written
+	original := obj.DeepCopy()
+	obj.Status.QueuedChanges = int32(r.Outages.Depth(obj.Name))
+	obj.Status.Proxies = r.proxyStatuses(&obj)
+
+	// Set phase to FrpServerPhasePending and wait next Reconcile, Unknown is left to the health probe
+	if obj.Status.Phase == "" {
 		obj.Status.Phase = frpv1beta1.FrpServerPhasePending
-		return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, &obj)})
+		return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.updateStatus(ctx, original, &obj)})
+	}
+
+	// The admission webhooks are disabled, default and validate the object here instead
+	if !lo.FromPtr(r.Options.EnableWebhooks) {
+		if r.Options.DefaultingMode != config.DefaultingModePreserve && defaultFrpServer(&obj) {
+			return ctrl.Result{}, r.Update(ctx, &obj)
+		}
+		if err := validateFrpServerSpec(&obj, r.Options); err != nil {
+			logger.Error(err, "Invalid resource object")
+			r.Recorder.Event(&obj, v1.EventTypeWarning, frpv1beta1.ReasonValidationFailed, err.Error())
+			meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+				Type:               "Initialized",
+				Status:             metav1.ConditionFalse,
+				Reason:             frpv1beta1.ReasonValidationFailed,
+				LastTransitionTime: metav1.NewTime(time.Now()),
+				Message:            fmt.Sprintf("Invalid FrpServer: %s", err.Error()),
+			})
+			obj.Status.Phase = frpv1beta1.FrpServerPhaseUnhealthy
+			r.Outages.Hold(obj.Name)
+			obj.Status.Reason = fmt.Sprintf("Invalid FrpServer: %s", err.Error())
+			// the object is only reconciled again once the spec is fixed
+			return ctrl.Result{}, r.updateStatus(ctx, original, &obj)
+		}
 	}
 
-	err = frpclient.ValidateFrpServerConfig(ctx, r.Client, &obj)
+	// Probe the STUN servers periodically so xtcp uses an available one
+	if r.Options.STUNProbeInterval > 0 && stunProbeDue(&obj, r.Options.STUNProbeInterval) {
+		r.syncSTUNServers(ctx, &obj)
+	}
+
+	creds, err := r.Credentials.Resolve(ctx, r.Client, &obj)
+	if err != nil {
+		logger.Error(err, "Unable resolve frp credentials for resource object")
+		meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
+			Type:               "Initialized",
+			Status:             metav1.ConditionFalse,
+			Reason:             frpv1beta1.ReasonCredentialsFailed,
+			LastTransitionTime: metav1.NewTime(time.Now()),
+			Message:            fmt.Sprintf("Unable resolve frp credentials: %s", err.Error()),
+		})
+		obj.Status.Phase = frpv1beta1.FrpServerPhaseUnhealthy
+		r.Outages.Hold(obj.Name)
+		obj.Status.Reason = fmt.Sprintf("Unable resolve frp credentials: %s", err.Error())
+		return ctrl.Result{RequeueAfter: credentialsRetryInterval}, r.updateStatus(ctx, original, &obj)
+	}
+
+	// Measure the clock skew before the login, the login of a skewed frps host is likely to fail
+	r.syncClockSkew(ctx, &obj)
+
+	loginResult, err := frpclient.ValidateFrpServerConfig(ctx, r.Client, &obj, creds, r.AuthSetters)
+	obj.Status.ActiveProtocol, obj.Status.DetectedUDPPacketSize = "", 0
+	if loginResult != nil {
+		obj.Status.ActiveProtocol, obj.Status.DetectedUDPPacketSize = loginResult.Protocol, loginResult.UDPPacketSize
+		obj.Status.ServerVersion = loginResult.ServerVersion
+	}
 	if err != nil {
 		logger.Error(err, "Invalid frp config from resource object")
 		meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
@@ -75,9 +187,14 @@ func (r *FrpServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			LastTransitionTime: metav1.NewTime(time.Now()),
 			Message:            fmt.Sprintf("Invalid frp config: %s", err.Error()),
 		})
-		obj.Status.Phase = frpv1beta1.FrpServerPhaseUnhealthy
+		r.syncHealth(ctx, &obj, err)
+		r.Outages.Hold(obj.Name)
 		obj.Status.Reason = fmt.Sprintf("Invalid frp config: %s", err.Error())
-		return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, &obj)})
+		// Keep probing at the regular interval instead of the backoff of the failed reconciles
+		if r.Options.HealthProbeInterval > 0 {
+			return ctrl.Result{RequeueAfter: r.Options.HealthProbeInterval}, r.updateStatus(ctx, original, &obj)
+		}
+		return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.updateStatus(ctx, original, &obj)})
 	}
 
 	meta.SetStatusCondition(&obj.Status.Conditions, metav1.Condition{
@@ -87,15 +204,84 @@ func (r *FrpServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		LastTransitionTime: metav1.NewTime(time.Now()),
 		Message:            "FrpServer is healthy",
 	})
-	obj.Status.Phase = frpv1beta1.FrpServerPhaseHealthy
+	r.syncHealth(ctx, &obj, nil)
 	obj.Status.Reason = "FrpServer is healthy"
+	obj.Status.QueuedChanges = 0
+
+	// Qualify the frps once per generation when the FrpServer opts in and probe its optional features, both
+	// register proxies on the frps, so they're skipped in the observe mode
+	if conformanceDue(&obj) && !r.Options.Observing() {
+		r.syncConformance(ctx, &obj, creds)
+	}
+	if capabilitiesDue(&obj) && !r.Options.Observing() {
+		r.syncCapabilities(ctx, &obj, creds)
+	}
+	// The stale routes are closed by deleting frp client pods and proxies, they're left alone in the observe mode
+	if !r.Options.Observing() {
+		r.collectStaleRoutes(ctx, &obj)
+	}
+
+	// Revalidate before the external credentials expire so rotated secrets are picked up
+	result := ctrl.Result{}
+	if creds != nil && creds.TTL > 0 {
+		result.RequeueAfter = time.Duration(float64(creds.TTL) * credentialsRenewFraction)
+	}
+	if r.Options.STUNProbeInterval > 0 && (result.RequeueAfter == 0 || r.Options.STUNProbeInterval < result.RequeueAfter) {
+		result.RequeueAfter = r.Options.STUNProbeInterval
+	}
+	if obj.Spec.ClockSkew != nil && (result.RequeueAfter == 0 || clockSkewProbeInterval < result.RequeueAfter) {
+		result.RequeueAfter = clockSkewProbeInterval
+	}
+	if obj.Spec.RouteGC != nil && (result.RequeueAfter == 0 || routeGCInterval < result.RequeueAfter) {
+		result.RequeueAfter = routeGCInterval
+	}
+	if r.Options.HealthProbeInterval > 0 && (result.RequeueAfter == 0 || r.Options.HealthProbeInterval < result.RequeueAfter) {
+		result.RequeueAfter = r.Options.HealthProbeInterval
+	}
+	if err := r.updateStatus(ctx, original, &obj); err != nil {
+		return result, err
+	}
+	// Apply the proxy changes queued during an outage once the healthy phase is stored
+	if released := r.Outages.Release(obj.Name); released != 0 {
+		logger.Info("Released proxy changes queued while resource object was unhealthy", "services", released)
+	}
+	return result, nil
+}
 
-	return ctrl.Result{}, utilerrors.NewAggregate([]error{err, r.Status().Update(ctx, &obj)})
+// proxyStatuses returns the status of the proxies the frp client sessions registered on the FrpServer
+func (r *FrpServerReconciler) proxyStatuses(server *frpv1beta1.FrpServer) []frpv1beta1.FrpServerProxyStatus {
+	if r.Sessions == nil {
+		return nil
+	}
+	return lo.Map(r.Sessions.Proxies(server.Name), func(status service.ProxyStatus, _ int) frpv1beta1.FrpServerProxyStatus {
+		return frpv1beta1.FrpServerProxyStatus{
+			Name:       status.Name,
+			FrpProxy:   status.Key,
+			Type:       status.Type,
+			RemoteAddr: lo.Ternary(status.RemoteAddr != "", remoteAddr(server, status.RemoteAddr), ""),
+			State:      status.Phase,
+			LastError:  status.Err,
+		}
+	})
+}
+
+// updateStatus writes the status of the FrpServer unless it's unchanged since the original was read, a changed
+// phase is recorded in the history of the status
+func (r *FrpServerReconciler) updateStatus(ctx context.Context, original, obj *frpv1beta1.FrpServer) error {
+	if obj.Status.Phase != original.Status.Phase {
+		obj.Status.History = append(obj.Status.History, frpv1beta1.FrpServerPhaseTransition{
+			Phase:  obj.Status.Phase,
+			Reason: obj.Status.Reason,
+			Time:   metav1.Now(),
+		})
+		obj.Status.History = obj.Status.History[max(len(obj.Status.History)-phaseHistoryLimit, 0):]
+	}
+	return updateStatus(ctx, r.Client, r.Options, frpServerControllerName, original, obj)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *FrpServerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&frpv1beta1.FrpServer{}).
-		Complete(r)
+		Complete(metrics.InstrumentReconciler(frpServerControllerName, r, r.Options.Tracing))
 }
@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/fieldindex"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// scheduledServices returns the exposed services scheduled on the FrpServer, directly or through a
+// FrpServerClaim bound to it. The reader must be the cached client, the services and claims are looked up
+// through their field indexes.
+func scheduledServices(ctx context.Context, r client.Reader, server *v1beta1.FrpServer) ([]*v1.Service, error) {
+	serviceList := &v1.ServiceList{}
+	if err := r.List(ctx, serviceList, client.MatchingFields{fieldindex.IndexNameForServiceServer: server.Name}); err != nil {
+		return nil, fmt.Errorf("unable list services, got: %w", err)
+	}
+	claimList := &v1beta1.FrpServerClaimList{}
+	if err := r.List(ctx, claimList, client.MatchingFields{fieldindex.IndexNameForClaimServer: server.Name}); err != nil {
+		return nil, fmt.Errorf("unable list frpserverclaims, got: %w", err)
+	}
+	for _, claim := range claimList.Items {
+		claimed := &v1.ServiceList{}
+		if err := r.List(ctx, claimed, client.InNamespace(claim.Namespace), client.MatchingFields{fieldindex.IndexNameForServiceClaim: claim.Name}); err != nil {
+			return nil, fmt.Errorf("unable list services, got: %w", err)
+		}
+		serviceList.Items = append(serviceList.Items, claimed.Items...)
+	}
+	services := make([]*v1.Service, 0, len(serviceList.Items))
+	for i := range serviceList.Items {
+		services = append(services, &serviceList.Items[i])
+	}
+	return lo.UniqBy(services, func(svc *v1.Service) types.UID { return svc.UID }), nil
+}
+
+// finalizeFrpServer applies the deletion policy of the deleted FrpServer to the tunnels of its services and
+// releases its finalizer. The Delete policy deletes the frp client pods and clears the load balancer status of
+// the services, they're not recreated while the FrpServer is gone. The Orphan policy keeps the pods running
+// with their last config.
+func (r *FrpServerReconciler) finalizeFrpServer(ctx context.Context, server *v1beta1.FrpServer) error {
+	logger := log.FromContext(ctx)
+	if !lo.Contains(server.Finalizers, frplabels.FrpServerFinalizer) {
+		return nil
+	}
+	services, err := scheduledServices(ctx, r.Client, server)
+	if err != nil {
+		return err
+	}
+	orphan := server.Spec.DeletionPolicy == v1beta1.FrpServerDeletionPolicyOrphan
+	errsList := make([]error, 0)
+	for _, svc := range services {
+		if orphan {
+			r.Recorder.Event(svc, v1.EventTypeWarning, v1beta1.ReasonTunnelsOrphaned,
+				fmt.Sprintf("frpserver '%s' was deleted, the frp client pods keep running with their last config", server.Name))
+			continue
+		}
+		if err := r.deleteClientPods(ctx, svc); err != nil {
+			logger.Error(err, "unable delete frp client pods of service", "service", client.ObjectKeyFromObject(svc).String())
+			errsList = append(errsList, err)
+			continue
+		}
+		if err := writeIngress(ctx, r.Client, r.Options, frpServerControllerName, svc.DeepCopy(), svc, nil); err != nil && !errors.IsNotFound(err) {
+			errsList = append(errsList, err)
+			continue
+		}
+		r.Recorder.Event(svc, v1.EventTypeWarning, v1beta1.ReasonTunnelsDeleted,
+			fmt.Sprintf("frpserver '%s' was deleted, the frp client pods were deleted", server.Name))
+	}
+	if len(errsList) != 0 {
+		return utilerrors.NewAggregate(errsList)
+	}
+	reason, action := v1beta1.ReasonTunnelsDeleted, "deleted"
+	if orphan {
+		reason, action = v1beta1.ReasonTunnelsOrphaned, "orphaned"
+	}
+	r.Recorder.Event(server, v1.EventTypeNormal, reason, fmt.Sprintf("%s the tunnels of %d services", action, len(services)))
+	logger.Info("finalized frpserver", "server", server.Name, "policy", server.Spec.DeletionPolicy, "services", len(services))
+	server.Finalizers = lo.Without(server.Finalizers, frplabels.FrpServerFinalizer)
+	return r.Update(ctx, server)
+}
+
+// deleteClientPods deletes the frp client pods of the service and the Deployment running them
+func (r *FrpServerReconciler) deleteClientPods(ctx context.Context, svc *v1.Service) error {
+	if r.Options.ManagedWorkloadKind == config.WorkloadKindDeployment {
+		if err := deleteClientDeployment(ctx, r.Client, svc); err != nil {
+			return err
+		}
+	}
+	podList := &v1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(svc.Namespace), client.MatchingFields{fieldindex.IndexNameForControllerUID: string(svc.UID)}); err != nil {
+		return err
+	}
+	for i := range podList.Items {
+		if err := r.Delete(ctx, &podList.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// syncHealth records the result of the login handshake with the frps in the phase, the Healthy condition and
+// the last probe time. A server which couldn't be reached is Unknown, one which rejected the login is Unhealthy.
+// An event is emitted when the phase changes between probes.
+func (r *FrpServerReconciler) syncHealth(ctx context.Context, obj *frpv1beta1.FrpServer, loginErr error) {
+	previous := obj.Status.Phase
+	now := metav1.NewTime(time.Now())
+	obj.Status.LastProbeTime = &now
+	condition := metav1.Condition{
+		Type:               frpv1beta1.FrpServerConditionHealthy,
+		Status:             metav1.ConditionTrue,
+		Reason:             frpv1beta1.ReasonProbeSucceeded,
+		LastTransitionTime: now,
+		Message:            "Logged in to frp server",
+	}
+	eventType := v1.EventTypeNormal
+	switch {
+	case loginErr == nil:
+		obj.Status.Phase = frpv1beta1.FrpServerPhaseHealthy
+	case frpclient.IsServerUnreachable(loginErr):
+		obj.Status.Phase = frpv1beta1.FrpServerPhaseUnknown
+		condition.Status, condition.Reason = metav1.ConditionUnknown, frpv1beta1.ReasonServerUnreachable
+		condition.Message = fmt.Sprintf("Unable reach frp server: %s", loginErr.Error())
+		eventType = v1.EventTypeWarning
+	default:
+		obj.Status.Phase = frpv1beta1.FrpServerPhaseUnhealthy
+		condition.Status, condition.Reason = metav1.ConditionFalse, frpv1beta1.ReasonProbeFailed
+		condition.Message = fmt.Sprintf("Unable login frp server: %s", loginErr.Error())
+		eventType = v1.EventTypeWarning
+	}
+	meta.SetStatusCondition(&obj.Status.Conditions, condition)
+	if previous == obj.Status.Phase || previous == "" || previous == frpv1beta1.FrpServerPhasePending {
+		return
+	}
+	log.FromContext(ctx).Info("Phase of resource object changed", "from", previous, "to", obj.Status.Phase)
+	r.Recorder.Eventf(obj, eventType, condition.Reason, "FrpServer is %s, was %s: %s", obj.Status.Phase, previous, condition.Message)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// These are the reasons a field of a FrpServer is rejected for.
+const (
+	RejectionRequired          = "Required"
+	RejectionInvalid           = "Invalid"
+	RejectionUnsupported       = "Unsupported"
+	RejectionOutOfRange        = "OutOfRange"
+	RejectionConfigInvalid     = "ConfigInvalid"
+	RejectionCredentialsFailed = "CredentialsFailed"
+	RejectionUnknown           = "Unknown"
+)
+
+// maxSummaryEntries bounds the number of field/reason pairs logged by RejectionSummary
+const maxSummaryEntries = 10
+
+// ValidationError is a field of a FrpServer rejected by the validation
+type ValidationError struct {
+	// Field is the path of the rejected field, e.g. spec.auth.method
+	Field string
+	// Reason is why the field is rejected, one of the Rejection constants
+	Reason string
+	// Err describes the rejection to the user
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns a ValidationError of field with the message of format
+func fieldError(field, reason, format string, args ...any) error {
+	return &ValidationError{Field: field, Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// validationErrors flattens the errors joined by the validation, errors without a ValidationError
+// are reported with the RejectionUnknown reason.
+func validationErrors(err error) []*ValidationError {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []*ValidationError
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, validationErrors(e)...)
+		}
+		return errs
+	}
+	validationErr := &ValidationError{}
+	if errors.As(err, &validationErr) {
+		return []*ValidationError{validationErr}
+	}
+	return []*ValidationError{{Field: "spec", Reason: RejectionUnknown, Err: err}}
+}
+
+// rejectionKey is a rejected field of FrpServers and why it was rejected
+type rejectionKey struct {
+	field  string
+	reason string
+}
+
+// RejectionSummary counts the FrpServer fields rejected by the validating webhook and periodically logs
+// the most frequent ones, so platform teams can see which misconfigurations users hit most.
+type RejectionSummary struct {
+	// Interval is the period of the summary log
+	Interval time.Duration
+
+	mu     sync.Mutex
+	counts map[rejectionKey]int
+}
+
+// Record counts the fields rejected by err in the metrics and the next summary, it's safe to call on nil
+func (s *RejectionSummary) Record(err error) {
+	for _, e := range validationErrors(err) {
+		metrics.WebhookRejectionsTotal.WithLabelValues(e.Field, e.Reason).Inc()
+		if s == nil {
+			continue
+		}
+		s.mu.Lock()
+		if s.counts == nil {
+			s.counts = make(map[rejectionKey]int)
+		}
+		s.counts[rejectionKey{field: e.Field, reason: e.Reason}]++
+		s.mu.Unlock()
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica serves admission requests
+func (s *RejectionSummary) NeedLeaderElection() bool {
+	return false
+}
+
+// Start logs the summary every Interval until ctx is done
+func (s *RejectionSummary) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("webhook-rejections")
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if total, top := s.summarize(); total != 0 {
+				logger.Info("FrpServer admission rejections", "interval", s.Interval, "total", total, "top", top)
+			}
+		}
+	}
+}
+
+// summarize resets the counts and returns their total and the most frequent field/reason pairs
+func (s *RejectionSummary) summarize() (int, []string) {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = nil
+	s.mu.Unlock()
+	keys := make([]rejectionKey, 0, len(counts))
+	total := 0
+	for key, n := range counts {
+		keys = append(keys, key)
+		total += n
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i].field+keys[i].reason < keys[j].field+keys[j].reason
+	})
+	top := make([]string, 0, min(len(keys), maxSummaryEntries))
+	for _, key := range keys[:min(len(keys), maxSummaryEntries)] {
+		top = append(top, fmt.Sprintf("%s/%s=%d", key.field, key.reason, counts[key]))
+	}
+	return total, top
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/service"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+const (
+	// routeOwnerService is the kind of the owner metadata of the proxies of a Service
+	routeOwnerService = "Service"
+	// routeOwnerFrpProxy is the kind of the owner metadata of the proxy of a FrpProxy
+	routeOwnerFrpProxy = "FrpProxy"
+)
+
+// routeOwner returns the owner metadata of the proxies registered for an object
+func routeOwner(kind string, key client.ObjectKey) string {
+	return kind + "/" + key.String()
+}
+
+// parseRouteOwner splits the owner metadata of a proxy into the kind and the key of the object
+func parseRouteOwner(owner string) (string, client.ObjectKey, bool) {
+	parts := strings.Split(owner, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return "", client.ObjectKey{}, false
+	}
+	return parts[0], client.ObjectKey{Namespace: parts[1], Name: parts[2]}, true
+}
+
+// collectStaleRoutes closes the online http and https routes of the frps registered for a Service or a FrpProxy
+// which is gone or no longer placed on the FrpServer, the subdomains of such routes keep pointing into the cluster.
+// The routes are identified by the owner metadata of their proxies. The frps dashboard can't close an online
+// proxy, so a route is closed at its client: the proxy of a FrpProxy is removed from the session of the manager
+// and the leftover frp client pods of a Service are deleted. The stale routes of the clients outside the cluster
+// are only reported. The metric is removed when spec.routeGC is unset.
+func (r *FrpServerReconciler) collectStaleRoutes(ctx context.Context, obj *frpv1beta1.FrpServer) {
+	if obj.Spec.RouteGC == nil {
+		metrics.StaleRoutes.DeleteLabelValues(obj.Name)
+		return
+	}
+	logger := log.FromContext(ctx)
+	creds, err := frpclient.GetDashboardCredentials(ctx, r.Client, obj)
+	if err != nil {
+		logger.Error(err, "Unable get frps dashboard credentials of resource object")
+		return
+	}
+	proxies, err := frpclient.ListVhostProxies(ctx, obj, creds)
+	if err != nil {
+		logger.Error(err, "Unable list vhost proxies of resource object")
+		return
+	}
+	services, err := scheduledServices(ctx, r.Client, obj)
+	if err != nil {
+		logger.Error(err, "Unable list services scheduled on resource object")
+		return
+	}
+	placed := lo.SliceToMap(services, func(svc *v1.Service) (string, bool) {
+		return routeOwner(routeOwnerService, client.ObjectKeyFromObject(svc)), true
+	})
+	unclosed := 0
+	for _, proxy := range proxies {
+		owner := proxy.Conf.Metadatas[frpv1beta1.ProxyMetadataOwnerKey]
+		if proxy.Status != frpclient.DashboardProxyOnline || owner == "" || placed[owner] {
+			continue
+		}
+		kind, key, ok := parseRouteOwner(owner)
+		if !ok {
+			continue
+		}
+		stale, err := r.routeStale(ctx, obj, kind, key)
+		if err != nil {
+			logger.Error(err, "Unable check owner of route of resource object", "proxy", proxy.Name, "owner", owner)
+			continue
+		}
+		if !stale {
+			continue
+		}
+		routes := strings.Join(proxy.Routes(), ",")
+		closed, err := r.closeRoute(ctx, obj, kind, key)
+		if err != nil {
+			logger.Error(err, "Unable close stale route of resource object", "proxy", proxy.Name, "owner", owner)
+		}
+		if !closed {
+			unclosed++
+			r.Recorder.Eventf(obj, v1.EventTypeWarning, frpv1beta1.ReasonStaleRoute,
+				"Route %s of %s proxy %s is left by %s, it can't be closed from the cluster", routes, proxy.Type, proxy.Name, owner)
+			continue
+		}
+		logger.Info("Closed stale route of resource object", "proxy", proxy.Name, "owner", owner, "routes", routes)
+		r.Recorder.Eventf(obj, v1.EventTypeNormal, frpv1beta1.ReasonStaleRouteClosed,
+			"Closed route %s of %s proxy %s left by %s", routes, proxy.Type, proxy.Name, owner)
+	}
+	metrics.StaleRoutes.WithLabelValues(obj.Name).Set(float64(unclosed))
+}
+
+// routeStale reports whether the owner of a route registered on the FrpServer is gone or placed elsewhere, the
+// services scheduled on the FrpServer are already excluded by the caller
+func (r *FrpServerReconciler) routeStale(ctx context.Context, obj *frpv1beta1.FrpServer, kind string, key client.ObjectKey) (bool, error) {
+	switch kind {
+	case routeOwnerService:
+		return true, nil
+	case routeOwnerFrpProxy:
+		proxy := &frpv1beta1.FrpProxy{}
+		if err := r.Get(ctx, key, proxy); err != nil {
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return !proxy.DeletionTimestamp.IsZero() || proxy.Spec.ServerName != obj.Name, nil
+	}
+	return false, nil
+}
+
+// closeRoute closes the stale route of an owner at its client, it reports false when the client is not run by
+// the manager
+func (r *FrpServerReconciler) closeRoute(ctx context.Context, obj *frpv1beta1.FrpServer, kind string, key client.ObjectKey) (bool, error) {
+	switch kind {
+	case routeOwnerFrpProxy:
+		if r.Sessions == nil || !lo.ContainsBy(r.Sessions.Proxies(obj.Name), func(status service.ProxyStatus) bool {
+			return status.Key == key.String()
+		}) {
+			return false, nil
+		}
+		return true, r.Sessions.Remove(ctx, key.String())
+	case routeOwnerService:
+		podList := &v1.PodList{}
+		if err := r.List(ctx, podList, client.InNamespace(key.Namespace), client.MatchingLabels{frplabels.ServiceName: key.Name}); err != nil {
+			return false, fmt.Errorf("unable list frp client pods, got: %w", err)
+		}
+		closed := false
+		for i := range podList.Items {
+			pod := &podList.Items[i]
+			if pod.Annotations[frpv1beta1.AnnotationScheduledServerKey] != obj.Name {
+				continue
+			}
+			if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+				return closed, fmt.Errorf("unable delete frp client pod '%s', got: %w", pod.Name, err)
+			}
+			closed = true
+		}
+		return closed, nil
+	}
+	return false, nil
+}
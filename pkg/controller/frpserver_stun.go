@@ -0,0 +1,61 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"slices"
+	"time"
+)
+
+// stunProbeDue reports whether the STUN servers of the FrpServer should be probed, they are probed once
+// the servers change or the last probe is older than interval.
+func stunProbeDue(obj *frpv1beta1.FrpServer, interval time.Duration) bool {
+	probed := lo.Map(obj.Status.NatHoleSTUNServers, func(s frpv1beta1.FrpServerSTUNServerStatus, _ int) string { return s.Address })
+	if !slices.Equal(probed, frpclient.STUNServers(obj)) {
+		return true
+	}
+	return lo.SomeBy(obj.Status.NatHoleSTUNServers, func(s frpv1beta1.FrpServerSTUNServerStatus) bool {
+		return time.Since(s.LastProbeTime.Time) >= interval
+	})
+}
+
+// syncSTUNServers probes the STUN servers of the FrpServer and selects the first available one for xtcp,
+// an event is emitted when none of them is available, xtcp falls back to spec.natHoleStunServer then.
+func (r *FrpServerReconciler) syncSTUNServers(ctx context.Context, obj *frpv1beta1.FrpServer) {
+	logger := log.FromContext(ctx)
+	wasAvailable := len(obj.Status.NatHoleSTUNServers) == 0 ||
+		lo.SomeBy(obj.Status.NatHoleSTUNServers, func(s frpv1beta1.FrpServerSTUNServerStatus) bool { return s.Available })
+	previous := obj.Status.NatHoleSTUNServer
+	obj.Status.NatHoleSTUNServers = frpclient.ProbeSTUNServers(obj)
+	obj.Status.NatHoleSTUNServer = frpclient.SelectSTUNServer(obj, obj.Status.NatHoleSTUNServers)
+	available := lo.SomeBy(obj.Status.NatHoleSTUNServers, func(s frpv1beta1.FrpServerSTUNServerStatus) bool { return s.Available })
+	if !available && wasAvailable {
+		logger.Info("No STUN server of resource object is available", "servers", frpclient.STUNServers(obj))
+		r.Recorder.Event(obj, v1.EventTypeWarning, frpv1beta1.ReasonSTUNUnavailable,
+			fmt.Sprintf("None of the STUN servers %v is available, xtcp proxies can't penetrate NAT", frpclient.STUNServers(obj)))
+	}
+	if previous != "" && previous != obj.Status.NatHoleSTUNServer {
+		logger.Info("Switched STUN server of resource object", "from", previous, "to", obj.Status.NatHoleSTUNServer)
+	}
+}
@@ -2,159 +2,383 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/fatedier/frp/pkg/util/util"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/frp-sigs/frp-provisioner/pkg/ipam"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/tlspolicy"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/fieldindex"
 	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
 	"github.com/samber/lo"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net"
+	"net/url"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sort"
+	"strings"
+)
+
+const (
+	frpServerMutatePath   = "/mutate-frp-gofrp-io-v1beta1-frpserver"
+	frpServerValidatePath = "/validate-frp-gofrp-io-v1beta1-frpserver"
 )
 
 type FrpServerValidator struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme  *runtime.Scheme
+	Options *config.ManagerOptions
+	// Rejections counts the rejected fields, the rejections are only exported as metrics when nil
+	Rejections *RejectionSummary
+	// Credentials resolves the frp credentials the FrpServers are validated with
+	Credentials *credentials.Resolver
 }
 
+// SetupWebhookWithManager registers the webhooks on the paths ctrl.NewWebhookManagedBy would generate, the
+// handlers are registered by hand so their panics are recovered.
 func (f *FrpServerValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewWebhookManagedBy(mgr).
-		For(&v1beta1.FrpServer{}).
-		WithDefaulter(f).
-		WithValidator(f).
-		Complete()
+	defaulter := admission.WithCustomDefaulter(mgr.GetScheme(), &v1beta1.FrpServer{}, f)
+	mgr.GetWebhookServer().Register(frpServerMutatePath, &webhook.Admission{
+		Handler: metrics.InstrumentHandler(frpServerMutatePath, defaulter.Handler),
+	})
+	validator := admission.WithCustomValidator(mgr.GetScheme(), &v1beta1.FrpServer{}, f)
+	mgr.GetWebhookServer().Register(frpServerValidatePath, &webhook.Admission{
+		Handler: metrics.InstrumentHandler(frpServerValidatePath, validator.Handler),
+	})
+	return nil
 }
 
 // +kubebuilder:webhook:path=/mutate-frp-gofrp-io-v1beta1-frpserver,mutating=true,failurePolicy=fail,sideEffects=None,groups=frp.gofrp.io,resources=frpservers,verbs=create;update,versions=v1beta1,name=mfrpserver.kb.io,admissionReviewVersions=v1
 var _ admission.CustomDefaulter = &FrpServerValidator{}
 
-// Default implements admission.CustomDefaulter so a webhook will be registered for the type
+// Default implements admission.CustomDefaulter so a webhook will be registered for the type.
+// Static defaults are declared in the CRD schema, only defaults which depend on other fields are set here.
 func (f *FrpServerValidator) Default(ctx context.Context, obj runtime.Object) error {
-	r := obj.(*v1beta1.FrpServer)
-	r.Spec.Auth.Method = util.EmptyOr(r.Spec.Auth.Method, v1beta1.FrpServerAuthMethodToken)
-	r.Spec.ServerPort = util.EmptyOr(r.Spec.ServerPort, 7000)
-	r.Spec.LoginFailExit = util.EmptyOr(r.Spec.LoginFailExit, lo.ToPtr(true))
-	r.Spec.NatHoleSTUNServer = util.EmptyOr(r.Spec.NatHoleSTUNServer, v1beta1.DefaultNatHoleSTUNAddr)
-	r.Spec.UDPPacketSize = util.EmptyOr(r.Spec.UDPPacketSize, 1500)
-	// set Transport defaults
-	r.Spec.Transport.Protocol = util.EmptyOr(r.Spec.Transport.Protocol, v1beta1.FrpServerTransportProtocolTCP)
-	r.Spec.Transport.DialServerTimeout = util.EmptyOr(r.Spec.Transport.DialServerTimeout, 10)
-	r.Spec.Transport.DialServerKeepAlive = util.EmptyOr(r.Spec.Transport.DialServerKeepAlive, 7200)
-	r.Spec.Transport.PoolCount = util.EmptyOr(r.Spec.Transport.PoolCount, 1)
-	r.Spec.Transport.TCPMux = util.EmptyOr(r.Spec.Transport.TCPMux, lo.ToPtr(true))
-	r.Spec.Transport.TCPMuxKeepaliveInterval = util.EmptyOr(r.Spec.Transport.TCPMuxKeepaliveInterval, 60)
-	r.Spec.Transport.HeartbeatInterval = util.EmptyOr(r.Spec.Transport.HeartbeatInterval, 30)
-	r.Spec.Transport.HeartbeatTimeout = util.EmptyOr(r.Spec.Transport.HeartbeatTimeout, 90)
-	r.Spec.Transport.TLS.DisableCustomTLSFirstByte = util.EmptyOr(r.Spec.Transport.TLS.DisableCustomTLSFirstByte, lo.ToPtr(true))
-	if r.Spec.Transport.Protocol == v1beta1.FrpServerTransportProtocolQUIC {
-		r.Spec.Transport.QUIC.KeepalivePeriod = util.EmptyOr(r.Spec.Transport.QUIC.KeepalivePeriod, 10)
-		r.Spec.Transport.QUIC.MaxIdleTimeout = util.EmptyOr(r.Spec.Transport.QUIC.MaxIdleTimeout, 30)
-		r.Spec.Transport.QUIC.MaxIncomingStreams = util.EmptyOr(r.Spec.Transport.QUIC.MaxIncomingStreams, 100000)
+	if server := obj.(*v1beta1.FrpServer); f.shouldDefault(ctx, server) {
+		defaultFrpServer(server)
 	}
 	return ctx.Err()
 }
 
-// +kubebuilder:webhook:path=/validate-frp-gofrp-io-v1beta1-frpserver,mutating=false,failurePolicy=fail,sideEffects=None,groups=frp.gofrp.io,resources=frpservers,verbs=create;update;delete,versions=v1beta1,name=vfrpserver.kb.io,admissionReviewVersions=v1
+// shouldDefault reports whether the defaults which depend on other fields are written to obj. They're
+// never written in the preserve mode, and in the full mode only on create and on the updates changing the
+// spec, i.e. starting a new generation, so the writes of other field managers leave the spec untouched.
+func (f *FrpServerValidator) shouldDefault(ctx context.Context, obj *v1beta1.FrpServer) bool {
+	if f.Options != nil && f.Options.DefaultingMode == config.DefaultingModePreserve {
+		return false
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || req.Operation != admissionv1.Update || len(req.OldObject.Raw) == 0 {
+		return true
+	}
+	old := &v1beta1.FrpServer{}
+	if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+		return true
+	}
+	return !equality.Semantic.DeepEqual(old.Spec, obj.Spec)
+}
+
+// defaultFrpServer sets the defaults which depend on other fields and reports whether obj was changed
+func defaultFrpServer(r *v1beta1.FrpServer) bool {
+	if lo.Contains(frpclient.TransportProtocols(r), v1beta1.FrpServerTransportProtocolQUIC) && r.Spec.Transport.QUIC == nil {
+		r.Spec.Transport.QUIC = &v1beta1.FrpServerTransportQUIC{
+			KeepalivePeriod:    v1beta1.DefaultQUICKeepalivePeriod,
+			MaxIdleTimeout:     v1beta1.DefaultQUICMaxIdleTimeout,
+			MaxIncomingStreams: v1beta1.DefaultQUICMaxIncomingStreams,
+		}
+		return true
+	}
+	return false
+}
+
+// +kubebuilder:webhook:path=/validate-frp-gofrp-io-v1beta1-frpserver,mutating=false,failurePolicy=fail,sideEffects=NoneOnDryRun,groups=frp.gofrp.io,resources=frpservers,verbs=create;update;delete,versions=v1beta1,name=vfrpserver.kb.io,admissionReviewVersions=v1
 var _ admission.CustomValidator = &FrpServerValidator{}
 
 func (f *FrpServerValidator) ValidateCreate(ctx context.Context, object runtime.Object) (warnings admission.Warnings, errs error) {
 	obj := object.(*v1beta1.FrpServer)
-	if !lo.Contains(v1beta1.FrpServerAuthMethods, obj.Spec.Auth.Method) {
-		errs = errors.Join(errs, fmt.Errorf("invalid spec.auth.method, optional values are %+v", v1beta1.FrpServerAuthMethods))
+	errs = validateFrpServerSpec(obj, f.Options)
+	warnings = f.proxyTypeWarnings(obj)
+	if errs == nil {
+		errs = f.validateFrpServerConfig(ctx, obj)
 	}
-	if !lo.Every(v1beta1.FrpServerAuthScopes, obj.Spec.Auth.AdditionalScopes) {
-		errs = errors.Join(errs, fmt.Errorf("invalid spec.auth.authScopes, optional values are %v", v1beta1.FrpServerAuthScopes))
+	f.Rejections.Record(errs)
+	return warnings, errs
+}
+
+// ValidateUpdate implements admission.CustomValidator so a webhook will be registered for the type
+func (f *FrpServerValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (warnings admission.Warnings, errs error) {
+	obj := newObj.(*v1beta1.FrpServer)
+	errs = validateFrpServerSpec(obj, f.Options)
+	warnings = f.proxyTypeWarnings(obj)
+	// The metadata only updates, e.g. the finalizers and the audit annotations, don't log in to the server again
+	if errs == nil && !equality.Semantic.DeepEqual(oldObj.(*v1beta1.FrpServer).Spec, obj.Spec) {
+		errs = f.validateFrpServerConfig(ctx, obj)
 	}
-	if obj.Spec.Auth.Method == v1beta1.FrpServerAuthMethodToken && obj.Spec.Auth.Token == "" {
-		errs = errors.Join(errs, fmt.Errorf("field spec.auth.token should not be empty"))
+	f.Rejections.Record(errs)
+	return warnings, errs
+}
+
+// checkFIPS rejects the tls policies selecting settings which are not FIPS-approved when the manager runs in FIPS mode
+func checkFIPS(obj *v1beta1.FrpServer, opts *config.ManagerOptions) error {
+	policy := obj.Spec.Transport.TLS.Policy
+	if opts == nil || !opts.FIPS || policy == nil {
+		return nil
 	}
-	if obj.Spec.ServerAddr == "" {
-		errs = errors.Join(errs, fmt.Errorf("field spec.serverAddr should not be empty"))
+	if err := (*tlspolicy.Policy)(policy).CheckFIPS("spec.transport.tls.policy"); err != nil {
+		return fieldError("spec.transport.tls.policy", RejectionUnsupported, "%w", err)
 	}
-	if err := frpclient.ValidatePort(obj.Spec.ServerPort); err != nil {
-		errs = errors.Join(errs, fmt.Errorf("invalid field spec.serverPort, got: %w", err))
+	return nil
+}
+
+// proxyTypeWarnings warns about spec.allowedProxyTypes the manager doesn't allow, they're never permitted
+func (f *FrpServerValidator) proxyTypeWarnings(obj *v1beta1.FrpServer) admission.Warnings {
+	if f.Options == nil || len(f.Options.AllowedProxyTypes) == 0 {
+		return nil
 	}
-	if len(obj.Spec.ExternalIPs) == 0 {
-		errs = errors.Join(errs, fmt.Errorf("field spec.externalIPs should not be empty"))
+	if denied := lo.Without(obj.Spec.AllowedProxyTypes, f.Options.AllowedProxyTypes...); len(denied) != 0 {
+		return admission.Warnings{fmt.Sprintf("spec.allowedProxyTypes %v are not allowed by the provisioner, allowed types are %v", denied, f.Options.AllowedProxyTypes)}
 	}
-	if obj.Spec.Transport.HeartbeatTimeout > 0 && obj.Spec.Transport.HeartbeatInterval > 0 {
-		if obj.Spec.Transport.HeartbeatTimeout < obj.Spec.Transport.HeartbeatInterval {
-			errs = errors.Join(errs, fmt.Errorf("invalid spec.transport.heartbeatTimeout,"+
-				" spec.transport.heartbeatTimeout should not less than spec.transport.heartbeatInterval"))
+	return nil
+}
+
+// validateFrpServerConfig logs in to the frp server with the config of obj. Dry-run requests must not have
+// side effects, so their config is only checked offline without resolving credentials or contacting the server.
+func (f *FrpServerValidator) validateFrpServerConfig(ctx context.Context, obj *v1beta1.FrpServer) error {
+	if req, err := admission.RequestFromContext(ctx); err == nil && lo.FromPtr(req.DryRun) {
+		if err := frpclient.CheckFrpServerConfig(obj); err != nil {
+			return fieldError("spec", RejectionConfigInvalid, "failed to validate frp config, got: %w", err)
 		}
+		return nil
 	}
-	if obj.Spec.Transport.TLS.SecretRef != nil {
-		if obj.Spec.Transport.TLS.SecretRef.Name != "" && obj.Spec.Transport.TLS.SecretRef.Namespace == "" {
-			errs = errors.Join(errs, fmt.Errorf("field spec.transport.tls.secretRef.namespace"+
-				" should not be empty when spec.transport.tls.secretRef.name is not empty"))
+	creds, err := f.Credentials.Resolve(ctx, f.Client, obj)
+	if err != nil {
+		return fieldError(lo.Ternary(obj.Spec.VaultRef != nil, "spec.vaultRef", "spec.auth"), RejectionCredentialsFailed, "failed to resolve frp credentials, got: %w", err)
+	}
+	if _, err := frpclient.ValidateFrpServerConfig(ctx, f.Client, obj, creds, nil); err != nil {
+		return fieldError("spec", RejectionConfigInvalid, "failed to validate frp config, got: %w", err)
+	}
+	return nil
+}
+
+// validateAuthSecretRefs checks the Secret references of the token and the OIDC client secret, they need the
+// namespace of the Secrets and can't be combined with spec.vaultRef which replaces them
+func validateAuthSecretRefs(obj *v1beta1.FrpServer) (errs error) {
+	fields := []string{"spec.auth.tokenSecretRef", "spec.auth.oidc.clientSecretRef"}
+	refs := []*v1.SecretKeySelector{obj.Spec.Auth.TokenSecretRef, nil}
+	if obj.Spec.Auth.OIDC != nil {
+		refs[1] = obj.Spec.Auth.OIDC.ClientSecretRef
+	}
+	for i, ref := range refs {
+		if ref == nil {
+			continue
 		}
-		if obj.Spec.Transport.TLS.SecretRef.Name == "" && obj.Spec.Transport.TLS.SecretRef.Namespace != "" {
-			errs = errors.Join(errs, fmt.Errorf("field spec.transport.tls.secretRef.name"+
-				" should not be empty when spec.transport.tls.secretRef.namespace is not empty"))
+		field := fields[i]
+		if ref.Name == "" || ref.Key == "" {
+			errs = errors.Join(errs, fieldError(field, RejectionRequired, "field %s.name and %s.key should not be empty", field, field))
 		}
-		if !lo.Contains(v1beta1.FrpServerTransportProtocols, obj.Spec.Transport.Protocol) {
-			errs = errors.Join(errs, fmt.Errorf("invalid spec.transport.protocol, optional values are %+v", v1beta1.FrpServerTransportProtocols))
+		if obj.Spec.Auth.SecretNamespace == "" {
+			errs = errors.Join(errs, fieldError("spec.auth.secretNamespace", RejectionRequired, "field spec.auth.secretNamespace should not be empty when %s is set", field))
 		}
-	}
-	if errs == nil {
-		if err := frpclient.ValidateFrpServerConfig(ctx, f.Client, obj); err != nil {
-			errs = errors.Join(errs, fmt.Errorf("failed to validate frp config, got: %w", err))
+		if obj.Spec.VaultRef != nil {
+			errs = errors.Join(errs, fieldError(field, RejectionInvalid, "field %s can't be combined with spec.vaultRef", field))
 		}
 	}
-	return warnings, errs
+	return errs
 }
 
-// ValidateUpdate implements admission.CustomValidator so a webhook will be registered for the type
-func (f *FrpServerValidator) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) (warnings admission.Warnings, errs error) {
-	obj := newObj.(*v1beta1.FrpServer)
+// ValidateDelete implements admission.CustomValidator so a webhook will be registered for the type, it denies
+// deleting a FrpServer still used by Services or FrpProxies unless it carries the force delete annotation.
+func (f *FrpServerValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (warnings admission.Warnings, err error) {
+	server, ok := obj.(*v1beta1.FrpServer)
+	if !ok {
+		return warnings, fmt.Errorf("expected a FrpServer but got a %T", obj)
+	}
+	blockers, err := f.deletionBlockers(ctx, server)
+	if err != nil {
+		return warnings, err
+	}
+	if len(blockers) == 0 {
+		return warnings, nil
+	}
+	if server.Annotations[v1beta1.AnnotationForceDeleteKey] == "true" {
+		warnings = append(warnings, fmt.Sprintf("frp server '%s' is force deleted while in use by %s", server.Name, strings.Join(blockers, ", ")))
+		return warnings, nil
+	}
+	return warnings, fmt.Errorf("frp server '%s' is in use by %s, annotate it with %s=true to delete it anyway",
+		server.Name, strings.Join(blockers, ", "), v1beta1.AnnotationForceDeleteKey)
+}
+
+// deletionBlockers returns the sorted Services and FrpProxies using the server
+func (f *FrpServerValidator) deletionBlockers(ctx context.Context, server *v1beta1.FrpServer) ([]string, error) {
+	services, err := scheduledServices(ctx, f.Client, server)
+	if err != nil {
+		return nil, err
+	}
+	blockers := lo.Map(services, func(svc *v1.Service, _ int) string { return fmt.Sprintf("service %s/%s", svc.Namespace, svc.Name) })
+	proxies := &v1beta1.FrpProxyList{}
+	if err := f.List(ctx, proxies, client.MatchingFields{fieldindex.IndexNameForProxyServer: server.Name}); err != nil {
+		return nil, fmt.Errorf("unable list frpproxies, got: %w", err)
+	}
+	for _, proxy := range proxies.Items {
+		blockers = append(blockers, fmt.Sprintf("frpproxy %s/%s", proxy.Namespace, proxy.Name))
+	}
+	sort.Strings(blockers)
+	return blockers, nil
+}
+
+// validateFrpServerSpec runs the static checks shared by create and update, it's also used by
+// FrpServerReconciler when the admission webhooks are disabled.
+func validateFrpServerSpec(obj *v1beta1.FrpServer, opts *config.ManagerOptions) (errs error) {
+	if err := checkFIPS(obj, opts); err != nil {
+		errs = errors.Join(errs, err)
+	}
 	if !lo.Contains(v1beta1.FrpServerAuthMethods, obj.Spec.Auth.Method) {
-		errs = errors.Join(errs, fmt.Errorf("invalid spec.auth.method, optional values are %+v", v1beta1.FrpServerAuthMethods))
+		errs = errors.Join(errs, fieldError("spec.auth.method", RejectionUnsupported, "invalid spec.auth.method, optional values are %+v", v1beta1.FrpServerAuthMethods))
 	}
 	if !lo.Every(v1beta1.FrpServerAuthScopes, obj.Spec.Auth.AdditionalScopes) {
-		errs = errors.Join(errs, fmt.Errorf("invalid spec.auth.authScopes, optional values are %v", v1beta1.FrpServerAuthScopes))
+		errs = errors.Join(errs, fieldError("spec.auth.additionalScopes", RejectionUnsupported, "invalid spec.auth.authScopes, optional values are %v", v1beta1.FrpServerAuthScopes))
+	}
+	if obj.Spec.Auth.Method == v1beta1.FrpServerAuthMethodToken && obj.Spec.Auth.Token == "" && obj.Spec.Auth.TokenSecretRef == nil && obj.Spec.VaultRef == nil {
+		errs = errors.Join(errs, fieldError("spec.auth.token", RejectionRequired, "field spec.auth.token should not be empty"))
+	}
+	if err := validateAuthSecretRefs(obj); err != nil {
+		errs = errors.Join(errs, err)
 	}
-	if obj.Spec.Auth.Method == v1beta1.FrpServerAuthMethodToken && obj.Spec.Auth.Token == "" {
-		errs = errors.Join(errs, fmt.Errorf("field spec.auth.token should not be empty"))
+	if obj.Spec.VaultRef != nil && obj.Spec.VaultRef.Path == "" {
+		errs = errors.Join(errs, fieldError("spec.vaultRef.path", RejectionRequired, "field spec.vaultRef.path should not be empty"))
+	}
+	if obj.Spec.Auth.OIDC != nil && obj.Spec.Auth.OIDC.ClockSkewTolerance < 0 {
+		errs = errors.Join(errs, fieldError("spec.auth.oidc.clockSkewTolerance", RejectionInvalid, "field spec.auth.oidc.clockSkewTolerance should not be negative"))
 	}
 	if obj.Spec.ServerAddr == "" {
-		errs = errors.Join(errs, fmt.Errorf("field spec.serverAddr should not be empty"))
+		errs = errors.Join(errs, fieldError("spec.serverAddr", RejectionRequired, "field spec.serverAddr should not be empty"))
 	}
-	if obj.Spec.ServerPort <= 0 {
-		errs = errors.Join(errs, fmt.Errorf("field spec.serverPort should not be empty"))
+	if obj.Spec.ServerPort == 0 {
+		errs = errors.Join(errs, fieldError("spec.serverPort", RejectionRequired, "field spec.serverPort should not be empty"))
+	} else if err := frpclient.ValidatePort(obj.Spec.ServerPort); err != nil {
+		errs = errors.Join(errs, fieldError("spec.serverPort", RejectionInvalid, "invalid field spec.serverPort, got: %w", err))
 	}
 	if len(obj.Spec.ExternalIPs) == 0 {
-		errs = errors.Join(errs, fmt.Errorf("field spec.externalIPs should not be empty"))
+		errs = errors.Join(errs, fieldError("spec.externalIPs", RejectionRequired, "field spec.externalIPs should not be empty"))
+	}
+	if !lo.Every(v1beta1.FrpServerTransportProtocols, obj.Spec.Transport.FallbackProtocols) {
+		errs = errors.Join(errs, fieldError("spec.transport.fallbackProtocols", RejectionUnsupported, "invalid spec.transport.fallbackProtocols, optional values are %+v", v1beta1.FrpServerTransportProtocols))
 	}
 	if obj.Spec.Transport.HeartbeatTimeout > 0 && obj.Spec.Transport.HeartbeatInterval > 0 {
 		if obj.Spec.Transport.HeartbeatTimeout < obj.Spec.Transport.HeartbeatInterval {
-			errs = errors.Join(errs, fmt.Errorf("invalid spec.transport.heartbeatTimeout,"+
+			errs = errors.Join(errs, fieldError("spec.transport.heartbeatTimeout", RejectionInvalid, "invalid spec.transport.heartbeatTimeout,"+
 				" spec.transport.heartbeatTimeout should not less than spec.transport.heartbeatInterval"))
 		}
 	}
+	if err := validateTCPMux(&obj.Spec.Transport); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	if err := frpclient.ApplyProxyDefaults(&configv1.ProxyBaseConfig{}, obj, nil); err != nil {
+		errs = errors.Join(errs, fieldError("spec.proxyDefaults", RejectionInvalid, "invalid spec.proxyDefaults, got: %w", err))
+	}
+	if obj.Spec.IPAM != nil {
+		if _, err := ipam.New(obj.Spec.IPAM); err != nil {
+			errs = errors.Join(errs, fieldError("spec.ipam", RejectionInvalid, "invalid spec.ipam, got: %w", err))
+		}
+	}
+	if unknown := lo.Without(obj.Spec.AllowedProxyTypes, v1beta1.ProxyTypes...); len(unknown) != 0 {
+		errs = errors.Join(errs, fieldError("spec.allowedProxyTypes", RejectionUnsupported, "invalid spec.allowedProxyTypes %v, optional values are %v", unknown, v1beta1.ProxyTypes))
+	}
+	if mode := obj.Spec.Transport.TLS.TrustedCAMode; mode != "" && !lo.Contains(v1beta1.FrpServerTrustedCAModes, mode) {
+		errs = errors.Join(errs, fieldError("spec.transport.tls.trustedCAMode", RejectionUnsupported, "invalid spec.transport.tls.trustedCAMode, optional values are %+v", v1beta1.FrpServerTrustedCAModes))
+	}
+	if policy := obj.Spec.Transport.TLS.Policy; policy != nil {
+		if err := (*tlspolicy.Policy)(policy).Validate("spec.transport.tls.policy"); err != nil {
+			errs = errors.Join(errs, fieldError("spec.transport.tls.policy", RejectionUnsupported, "%w", err))
+		}
+	}
+	if obj.Spec.DeletionPolicy != "" && !lo.Contains(v1beta1.FrpServerDeletionPolicies, obj.Spec.DeletionPolicy) {
+		errs = errors.Join(errs, fieldError("spec.deletionPolicy", RejectionUnsupported, "invalid spec.deletionPolicy, optional values are %+v", v1beta1.FrpServerDeletionPolicies))
+	}
+	if obj.Spec.ClockSkew != nil {
+		if u, err := url.Parse(obj.Spec.ClockSkew.ReferenceURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = errors.Join(errs, fieldError("spec.clockSkew.referenceURL", RejectionInvalid, "invalid spec.clockSkew.referenceURL '%s', an http or https url is expected", obj.Spec.ClockSkew.ReferenceURL))
+		}
+		if obj.Spec.ClockSkew.ThresholdSeconds < 0 {
+			errs = errors.Join(errs, fieldError("spec.clockSkew.thresholdSeconds", RejectionInvalid, "field spec.clockSkew.thresholdSeconds should not be negative"))
+		}
+	}
+	if obj.Spec.RouteGC != nil {
+		if u, err := url.Parse(obj.Spec.RouteGC.DashboardURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = errors.Join(errs, fieldError("spec.routeGC.dashboardURL", RejectionInvalid, "invalid spec.routeGC.dashboardURL '%s', an http or https url is expected", obj.Spec.RouteGC.DashboardURL))
+		}
+		if ref := obj.Spec.RouteGC.CredentialsSecretRef; ref != nil && (ref.Name == "" || ref.Namespace == "") {
+			errs = errors.Join(errs, fieldError("spec.routeGC.credentialsSecretRef", RejectionRequired, "field spec.routeGC.credentialsSecretRef should have a name and a namespace"))
+		}
+	}
+	if err := validateDomains(obj.Spec.Domains); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	for _, addr := range obj.Spec.NatHoleSTUNFallbackServers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = errors.Join(errs, fieldError("spec.natHoleStunFallbackServers", RejectionInvalid, "invalid spec.natHoleStunFallbackServers '%s', got: %w", addr, err))
+		}
+	}
 	if obj.Spec.Transport.TLS.SecretRef != nil {
 		if obj.Spec.Transport.TLS.SecretRef.Name != "" && obj.Spec.Transport.TLS.SecretRef.Namespace == "" {
-			errs = errors.Join(errs, fmt.Errorf("field spec.transport.tls.secretRef.namespace"+
+			errs = errors.Join(errs, fieldError("spec.transport.tls.secretRef.namespace", RejectionRequired, "field spec.transport.tls.secretRef.namespace"+
 				" should not be empty when spec.transport.tls.secretRef.name is not empty"))
 		}
 		if obj.Spec.Transport.TLS.SecretRef.Name == "" && obj.Spec.Transport.TLS.SecretRef.Namespace != "" {
-			errs = errors.Join(errs, fmt.Errorf("field spec.transport.tls.secretRef.name"+
+			errs = errors.Join(errs, fieldError("spec.transport.tls.secretRef.name", RejectionRequired, "field spec.transport.tls.secretRef.name"+
 				" should not be empty when spec.transport.tls.secretRef.namespace is not empty"))
 		}
 		if !lo.Contains(v1beta1.FrpServerTransportProtocols, obj.Spec.Transport.Protocol) {
-			errs = errors.Join(errs, fmt.Errorf("invalid spec.transport.protocol, optional values are %+v", v1beta1.FrpServerTransportProtocols))
+			errs = errors.Join(errs, fieldError("spec.transport.protocol", RejectionUnsupported, "invalid spec.transport.protocol, optional values are %+v", v1beta1.FrpServerTransportProtocols))
 		}
 	}
-	if errs == nil {
-		if err := frpclient.ValidateFrpServerConfig(ctx, f.Client, obj); err != nil {
-			errs = errors.Join(errs, fmt.Errorf("failed to validate frp config, got: %w", err))
+	return errs
+}
+
+// validateDomains checks the ingress domains are unique DNS names with a complete tls secret reference
+func validateDomains(domains []v1beta1.FrpServerDomain) (errs error) {
+	for i, domain := range domains {
+		field := fmt.Sprintf("spec.domains[%d]", i)
+		if msgs := validation.IsDNS1123Subdomain(domain.Name); len(msgs) != 0 {
+			errs = errors.Join(errs, fieldError(field+".name", RejectionInvalid, "invalid %s.name '%s', %s", field, domain.Name, strings.Join(msgs, ", ")))
+		}
+		if lo.ContainsBy(domains[:i], func(other v1beta1.FrpServerDomain) bool { return other.Name == domain.Name }) {
+			errs = errors.Join(errs, fieldError(field+".name", RejectionInvalid, "duplicate %s.name '%s'", field, domain.Name))
+		}
+		if ref := domain.TLSSecretRef; ref != nil && (ref.Name == "" || ref.Namespace == "") {
+			errs = errors.Join(errs, fieldError(field+".tlsSecretRef", RejectionRequired, "field %s.tlsSecretRef should have a name and namespace", field))
 		}
 	}
-	return warnings, errs
+	return errs
 }
 
-// ValidateDelete implements admission.CustomValidator so a webhook will be registered for the type
-func (f *FrpServerValidator) ValidateDelete(_ context.Context, _ runtime.Object) (warnings admission.Warnings, err error) {
-	return warnings, err
+// validateTCPMux checks the tcp multiplexing tunables are within sane bounds, zero values are defaulted
+func validateTCPMux(transport *v1beta1.FrpServerTransport) (errs error) {
+	if transport.TCPMuxMaxStreamWindowSize != 0 && (transport.TCPMuxMaxStreamWindowSize < v1beta1.MinTCPMuxMaxStreamWindowSize ||
+		transport.TCPMuxMaxStreamWindowSize > v1beta1.MaxTCPMuxMaxStreamWindowSize) {
+		errs = errors.Join(errs, fieldError("spec.transport.tcpMuxMaxStreamWindowSize", RejectionOutOfRange, "field spec.transport.tcpMuxMaxStreamWindowSize should be in the range %d..%d",
+			v1beta1.MinTCPMuxMaxStreamWindowSize, v1beta1.MaxTCPMuxMaxStreamWindowSize))
+	}
+	if transport.TCPMuxAcceptBacklog < 0 || transport.TCPMuxAcceptBacklog > v1beta1.MaxTCPMuxAcceptBacklog {
+		errs = errors.Join(errs, fieldError("spec.transport.tcpMuxAcceptBacklog", RejectionOutOfRange, "field spec.transport.tcpMuxAcceptBacklog should be in the range 1..%d", v1beta1.MaxTCPMuxAcceptBacklog))
+	}
+	if transport.TCPMuxKeepaliveInterval < 0 || transport.TCPMuxKeepaliveInterval > v1beta1.MaxTCPMuxKeepaliveInterval {
+		errs = errors.Join(errs, fieldError("spec.transport.tcpMuxKeepaliveInterval", RejectionOutOfRange, "field spec.transport.tcpMuxKeepaliveInterval should be in the range 1..%d", v1beta1.MaxTCPMuxKeepaliveInterval))
+	}
+	if transport.TCPMuxConnectionWriteTimeout < 0 || transport.TCPMuxConnectionWriteTimeout > v1beta1.MaxTCPMuxConnectionWriteTimeout {
+		errs = errors.Join(errs, fieldError("spec.transport.tcpMuxConnectionWriteTimeout", RejectionOutOfRange, "field spec.transport.tcpMuxConnectionWriteTimeout should be in the range 1..%d",
+			v1beta1.MaxTCPMuxConnectionWriteTimeout))
+	}
+	return errs
 }
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/access"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// FrpServerAccessReconciler syncs the FrpServerAccess objects to the access.Store enforced by the frps
+// server plugin.
+type FrpServerAccessReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Store    *access.Store
+}
+
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpserveraccesses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpserveraccesses/status,verbs=get;update;patch
+
+// Reconcile syncs the FrpServerAccess to the store once it's valid and its FrpServer exists, and records the result in its status
+func (r *FrpServerAccessReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	obj := &frpv1beta1.FrpServerAccess{}
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		if errors.IsNotFound(err) {
+			r.Store.Delete(req.Name)
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable get frpserveraccess by name", "request", req.String())
+		return ctrl.Result{}, err
+	}
+	server := &frpv1beta1.FrpServer{}
+	serverErr := r.Get(ctx, client.ObjectKey{Name: obj.Spec.ServerName}, server)
+	if serverErr != nil && !errors.IsNotFound(serverErr) {
+		logger.Error(serverErr, "unable get frpserver by name", "server", obj.Spec.ServerName)
+		return ctrl.Result{}, serverErr
+	}
+
+	phase, reason, users := frpv1beta1.FrpServerAccessPhaseSynced, fmt.Sprintf("Synced to FrpServer %s", obj.Spec.ServerName), len(obj.Spec.Users)
+	if err := access.Validate(&obj.Spec); err != nil {
+		phase, reason, users = frpv1beta1.FrpServerAccessPhaseFailed, err.Error(), 0
+		r.Store.Delete(obj.Name)
+	} else if serverErr != nil {
+		phase, reason, users = frpv1beta1.FrpServerAccessPhasePending, fmt.Sprintf("frpserver '%s' does not exist", obj.Spec.ServerName), 0
+		r.Store.Delete(obj.Name)
+	} else {
+		r.Store.Set(obj.Name, obj.Spec)
+	}
+	if obj.Status.Phase == phase && obj.Status.Reason == reason && obj.Status.ObservedGeneration == obj.Generation {
+		return ctrl.Result{}, nil
+	}
+	if phase == frpv1beta1.FrpServerAccessPhaseSynced {
+		r.Recorder.Event(obj, v1.EventTypeNormal, frpv1beta1.ReasonAccessSynced, reason)
+	} else {
+		r.Recorder.Event(obj, v1.EventTypeWarning, frpv1beta1.ReasonAccessFailed, reason)
+	}
+	obj.Status.Phase, obj.Status.Reason, obj.Status.Users, obj.Status.ObservedGeneration = phase, reason, users, obj.Generation
+	return ctrl.Result{}, r.Status().Update(ctx, obj)
+}
+
+// mapFrpServerToAccesses enqueue the FrpServerAccess objects referencing a FrpServer
+func (r *FrpServerAccessReconciler) mapFrpServerToAccesses(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	accessList := &frpv1beta1.FrpServerAccessList{}
+	if err := r.List(ctx, accessList); err != nil {
+		logger.Error(err, "unable get frpserveraccess list")
+		return nil
+	}
+	return lo.FilterMap(accessList.Items, func(item frpv1beta1.FrpServerAccess, _ int) (reconcile.Request, bool) {
+		return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)}, item.Spec.ServerName == obj.GetName()
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FrpServerAccessReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&frpv1beta1.FrpServerAccess{}).
+		Watches(&frpv1beta1.FrpServer{}, handler.EnqueueRequestsFromMapFunc(r.mapFrpServerToAccesses)).
+		Complete(metrics.InstrumentReconciler("frpserveraccess", r, false))
+}
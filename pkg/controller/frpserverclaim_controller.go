@@ -0,0 +1,163 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/fieldindex"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
+)
+
+// FrpServerClaimReconciler binds FrpServerClaim objects to the FrpServer they reference
+// when the claim policy of the FrpServer allows them.
+type FrpServerClaimReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpserverclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpserverclaims/status,verbs=get;update;patch
+
+// Reconcile checks the FrpServerClaim against the claim policy of its FrpServer and records the result in its status
+func (r *FrpServerClaimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	claim := &frpv1beta1.FrpServerClaim{}
+	if err := r.Get(ctx, req.NamespacedName, claim); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable get frpserverclaim by name", "request", req.String())
+		return ctrl.Result{}, err
+	}
+	server := &frpv1beta1.FrpServer{}
+	if err := r.Get(ctx, client.ObjectKey{Name: claim.Spec.ServerName}, server); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "unable get frpserver by name", "server", claim.Spec.ServerName)
+		return ctrl.Result{}, err
+	} else if err != nil {
+		server = nil
+	}
+	claimList := &frpv1beta1.FrpServerClaimList{}
+	if err := r.List(ctx, claimList, client.MatchingFields{fieldindex.IndexNameForClaimServer: claim.Spec.ServerName}); err != nil {
+		logger.Error(err, "unable get frpserverclaim list", "server", claim.Spec.ServerName)
+		return ctrl.Result{}, err
+	}
+
+	phase, reason := frpv1beta1.FrpServerClaimPhaseBound, fmt.Sprintf("Bound to FrpServer %s", claim.Spec.ServerName)
+	if err := checkClaim(claim, server, claimList.Items); err != nil {
+		phase, reason = frpv1beta1.FrpServerClaimPhaseRejected, err.Error()
+	}
+	if claim.Status.Phase == phase && claim.Status.Reason == reason && claim.Status.ObservedGeneration == claim.Generation {
+		return ctrl.Result{}, nil
+	}
+	if phase == frpv1beta1.FrpServerClaimPhaseRejected {
+		r.Recorder.Event(claim, v1.EventTypeWarning, frpv1beta1.ReasonClaimRejected, reason)
+	} else {
+		r.Recorder.Event(claim, v1.EventTypeNormal, frpv1beta1.ReasonClaimBound, reason)
+	}
+	claim.Status.Phase, claim.Status.Reason, claim.Status.ObservedGeneration = phase, reason, claim.Generation
+	return ctrl.Result{}, r.Status().Update(ctx, claim)
+}
+
+// checkClaim returns why the claim policy of server rejects claim, nil means the claim may bind. A
+// subdomain prefix belongs to the oldest claim using it among the claims of the FrpServer.
+func checkClaim(claim *frpv1beta1.FrpServerClaim, server *frpv1beta1.FrpServer, claims []frpv1beta1.FrpServerClaim) error {
+	if server == nil {
+		return fmt.Errorf("frpserver '%s' does not exist", claim.Spec.ServerName)
+	}
+	policy := server.Spec.ClaimPolicy
+	if policy == nil {
+		return fmt.Errorf("frpserver '%s' does not accept claims", server.Name)
+	}
+	if !lo.Contains(policy.AllowedNamespaces, claim.Namespace) && !lo.Contains(policy.AllowedNamespaces, frpv1beta1.ClaimPolicyAllNamespaces) {
+		return fmt.Errorf("frpserver '%s' does not accept claims from namespace '%s'", server.Name, claim.Namespace)
+	}
+	if keys, _ := lo.Difference(lo.Keys(claim.Spec.Metadatas), policy.AllowedMetadataKeys); len(keys) != 0 {
+		return fmt.Errorf("frpserver '%s' does not allow metadata keys %v, allowed keys are %v", server.Name, keys, policy.AllowedMetadataKeys)
+	}
+	if claim.Spec.SubdomainPrefix == "" {
+		return nil
+	}
+	if server.Spec.SubDomainHost == "" && !lo.ContainsBy(server.Spec.Domains, func(domain frpv1beta1.FrpServerDomain) bool { return domain.Wildcard }) {
+		return fmt.Errorf("frpserver '%s' has no spec.subDomainHost or wildcard domain, spec.subdomainPrefix can't be used", server.Name)
+	}
+	if errs := validation.IsDNS1123Label(claim.Spec.SubdomainPrefix); len(errs) != 0 {
+		return fmt.Errorf("invalid spec.subdomainPrefix '%s', %s", claim.Spec.SubdomainPrefix, strings.Join(errs, ", "))
+	}
+	for i := range claims {
+		other := &claims[i]
+		if other.UID == claim.UID || other.Spec.SubdomainPrefix != claim.Spec.SubdomainPrefix {
+			continue
+		}
+		if other.CreationTimestamp.Before(&claim.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&claim.CreationTimestamp) && other.Namespace+"/"+other.Name < claim.Namespace+"/"+claim.Name) {
+			return fmt.Errorf("spec.subdomainPrefix '%s' is already claimed by '%s/%s'", claim.Spec.SubdomainPrefix, other.Namespace, other.Name)
+		}
+	}
+	return nil
+}
+
+// mapFrpServerToClaims enqueue the FrpServerClaims referencing a FrpServer
+func (r *FrpServerClaimReconciler) mapFrpServerToClaims(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.claimRequests(ctx, obj.GetName())
+}
+
+// mapClaimToSiblings enqueue the FrpServerClaims referencing the same FrpServer as a claim, a
+// subdomain prefix released by a claim may be bound by another one.
+func (r *FrpServerClaimReconciler) mapClaimToSiblings(ctx context.Context, obj client.Object) []reconcile.Request {
+	claim, ok := obj.(*frpv1beta1.FrpServerClaim)
+	if !ok || claim.Spec.SubdomainPrefix == "" {
+		return nil
+	}
+	return r.claimRequests(ctx, claim.Spec.ServerName)
+}
+
+// claimRequests returns the requests of the FrpServerClaims referencing the FrpServer named serverName
+func (r *FrpServerClaimReconciler) claimRequests(ctx context.Context, serverName string) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	claimList := &frpv1beta1.FrpServerClaimList{}
+	if err := r.List(ctx, claimList, client.MatchingFields{fieldindex.IndexNameForClaimServer: serverName}); err != nil {
+		logger.Error(err, "unable get frpserverclaim list", "server", serverName)
+		return nil
+	}
+	return lo.Map(claimList.Items, func(claim frpv1beta1.FrpServerClaim, _ int) reconcile.Request {
+		return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&claim)}
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FrpServerClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&frpv1beta1.FrpServerClaim{}).
+		Watches(&frpv1beta1.FrpServer{}, handler.EnqueueRequestsFromMapFunc(r.mapFrpServerToClaims)).
+		Watches(&frpv1beta1.FrpServerClaim{}, handler.EnqueueRequestsFromMapFunc(r.mapClaimToSiblings)).
+		Complete(metrics.InstrumentReconciler("frpserverclaim", r, false))
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frpv1beta1 "github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// FrpServerGroupReconciler aggregates the health of the members of the FrpServerGroup objects in their status,
+// the services of a group are placed on its members by the ServiceReconciler.
+type FrpServerGroupReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpservergroups,verbs=get;list;watch
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpservergroups/status,verbs=get;update;patch
+
+// Reconcile records the phase of every member of the FrpServerGroup and the aggregated health in its status
+func (r *FrpServerGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	obj := &frpv1beta1.FrpServerGroup{}
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable get frpservergroup by name", "request", req.String())
+		return ctrl.Result{}, err
+	}
+	members, err := groupMembers(ctx, r.Client, obj)
+	if err != nil {
+		logger.Error(err, "unable get members of frpservergroup", "request", req.String())
+		return ctrl.Result{}, err
+	}
+	status := frpv1beta1.FrpServerGroupStatus{ObservedGeneration: obj.Generation}
+	for _, name := range lo.Uniq(obj.Spec.Servers) {
+		member := frpv1beta1.FrpServerGroupMemberStatus{Name: name}
+		if server, ok := members[name]; ok {
+			member.Phase = server.Status.Phase
+			if controllerutils.IsFrpServerActive(server) {
+				status.HealthyMembers++
+			}
+		}
+		status.Members = append(status.Members, member)
+	}
+	switch status.HealthyMembers {
+	case len(status.Members):
+		status.Phase, status.Reason = frpv1beta1.FrpServerGroupPhaseHealthy, fmt.Sprintf("All %d members are healthy", len(status.Members))
+	case 0:
+		status.Phase, status.Reason = frpv1beta1.FrpServerGroupPhaseUnhealthy, "No member is healthy"
+	default:
+		status.Phase, status.Reason = frpv1beta1.FrpServerGroupPhaseDegraded,
+			fmt.Sprintf("%d of %d members are healthy", status.HealthyMembers, len(status.Members))
+	}
+	if equality.Semantic.DeepEqual(obj.Status, status) {
+		return ctrl.Result{}, nil
+	}
+	if status.Phase != obj.Status.Phase {
+		if status.Phase == frpv1beta1.FrpServerGroupPhaseHealthy {
+			r.Recorder.Event(obj, v1.EventTypeNormal, frpv1beta1.ReasonGroupHealthy, status.Reason)
+		} else {
+			r.Recorder.Event(obj, v1.EventTypeWarning, frpv1beta1.ReasonGroupDegraded, status.Reason)
+		}
+	}
+	obj.Status = status
+	return ctrl.Result{}, r.Status().Update(ctx, obj)
+}
+
+// groupMembers returns the existing members of the FrpServerGroup by name
+func groupMembers(ctx context.Context, cli client.Client, obj *frpv1beta1.FrpServerGroup) (map[string]*frpv1beta1.FrpServer, error) {
+	members := make(map[string]*frpv1beta1.FrpServer, len(obj.Spec.Servers))
+	for _, name := range obj.Spec.Servers {
+		server := &frpv1beta1.FrpServer{}
+		if err := cli.Get(ctx, client.ObjectKey{Name: name}, server); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		members[name] = server
+	}
+	return members, nil
+}
+
+// mapFrpServerToGroups enqueue the FrpServerGroup objects the FrpServer is a member of
+func (r *FrpServerGroupReconciler) mapFrpServerToGroups(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	groupList := &frpv1beta1.FrpServerGroupList{}
+	if err := r.List(ctx, groupList); err != nil {
+		logger.Error(err, "unable get frpservergroup list")
+		return nil
+	}
+	return lo.FilterMap(groupList.Items, func(item frpv1beta1.FrpServerGroup, _ int) (reconcile.Request, bool) {
+		return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)}, lo.Contains(item.Spec.Servers, obj.GetName())
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FrpServerGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&frpv1beta1.FrpServerGroup{}).
+		Watches(&frpv1beta1.FrpServer{}, handler.EnqueueRequestsFromMapFunc(r.mapFrpServerToGroups)).
+		Complete(metrics.InstrumentReconciler("frpservergroup", r, false))
+}
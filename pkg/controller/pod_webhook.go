@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	v1 "k8s.io/api/core/v1"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const podReadinessGateWebhookPath = "/mutate-v1-pod"
+
+// PodReadinessGateInjector injects the tunnel readiness gate into backend pods
+// which opt in via the v1beta1.AnnotationReadinessGateKey annotation.
+type PodReadinessGateInjector struct {
+	Decoder *admission.Decoder
+}
+
+func (p *PodReadinessGateInjector) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if p.Decoder == nil {
+		p.Decoder = admission.NewDecoder(mgr.GetScheme())
+	}
+	mgr.GetWebhookServer().Register(podReadinessGateWebhookPath, &webhook.Admission{Handler: metrics.InstrumentHandler(podReadinessGateWebhookPath, p)})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mpod.frp.gofrp.io,admissionReviewVersions=v1
+var _ admission.Handler = &PodReadinessGateInjector{}
+
+// Handle implements admission.Handler, readiness gates can only be set at pod creation time
+func (p *PodReadinessGateInjector) Handle(_ context.Context, req admission.Request) admission.Response {
+	pod := &v1.Pod{}
+	if err := p.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !controllerutils.IsReadinessGateRequested(pod) {
+		return admission.Allowed("readiness gate not requested")
+	}
+	if controllerutils.HasTunnelReadinessGate(pod) {
+		return admission.Allowed("readiness gate already present")
+	}
+	pod.Spec.ReadinessGates = append(pod.Spec.ReadinessGates, v1.PodReadinessGate{
+		ConditionType: v1.PodConditionType(v1beta1.PodConditionTunnelReady),
+	})
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// isExposable reports whether the type of the service can be exposed, LoadBalancer services always are,
+// ExternalName and headless services only when enabled in the options.
+func (r *ServiceReconciler) isExposable(instance *v1.Service) bool {
+	switch {
+	case instance.Spec.Type == v1.ServiceTypeLoadBalancer:
+		return true
+	case instance.Spec.Type == v1.ServiceTypeExternalName:
+		return r.Options.ExposeExternalNameServices
+	case frpclient.IsHeadless(instance):
+		return r.Options.ExposeHeadlessServices
+	}
+	return false
+}
+
+// syncProxyBackends records the backends of the proxies of the service on its frp client pods, the
+// backends of a headless service follow its ready pods. The annotations of a pod are updated in place
+// so the proxies are added and removed without restarting the pod.
+func (r *ServiceReconciler) syncProxyBackends(ctx context.Context, instance *v1.Service, claimedPods []*v1.Pod) error {
+	logger := log.FromContext(ctx)
+	var selected []*v1.Pod
+	if frpclient.IsHeadless(instance) && len(instance.Spec.Selector) != 0 {
+		podList := &v1.PodList{}
+		if err := r.List(ctx, podList, client.InNamespace(instance.Namespace), client.MatchingLabels(instance.Spec.Selector)); err != nil {
+			return fmt.Errorf("unable list pods of headless service, got: %w", err)
+		}
+		for i := range podList.Items {
+			selected = append(selected, &podList.Items[i])
+		}
+	}
+	backends, err := frpclient.ProxyBackends(instance, selected)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(backends)
+	if err != nil {
+		return err
+	}
+	value := string(data)
+	errsList := make([]error, 0)
+	for _, pod := range claimedPods {
+		if pod.Annotations[v1beta1.AnnotationProxyBackendsKey] == value {
+			continue
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[v1beta1.AnnotationProxyBackendsKey] = value
+		if err := r.Update(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable update proxy backends annotation of pod", "podName", pod.GetName())
+			errsList = append(errsList, err)
+		}
+	}
+	return utilerrors.NewAggregate(errsList)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+// restartBudget is the failure history of the frp client pods of a service
+type restartBudget struct {
+	// failures are the times the failed frp client pods were removed or their containers restarted, oldest first
+	failures []time.Time
+	// cause describes why the last frp client pod failed
+	cause string
+	// restarts are the restart counts of the containers of the claimed pods, keyed by pod UID and container name
+	restarts map[string]int32
+}
+
+// recordPodFailure records a failed or completed frp client pod of the service once it's removed,
+// pods removed for other reasons don't consume the restart budget.
+func (r *ServiceReconciler) recordPodFailure(instance *v1.Service, pod *v1.Pod) {
+	if pod.Status.Phase != v1.PodFailed && pod.Status.Phase != v1.PodSucceeded {
+		return
+	}
+	key := client.ObjectKeyFromObject(instance)
+	previous, _ := r.budgets.Load(key)
+	budget, _ := previous.(restartBudget)
+	budget.failures = append(r.recentFailures(budget.failures), time.Now())
+	budget.cause = podFailureCause(pod)
+	r.budgets.Store(key, budget)
+}
+
+// recordContainerRestarts records the container restarts of the claimed frp client pods of the service since
+// the last reconcile, a crash looping container consumes the restart budget without its pod failing.
+func (r *ServiceReconciler) recordContainerRestarts(instance *v1.Service, claimedPods []*v1.Pod) {
+	key := client.ObjectKeyFromObject(instance)
+	previous, ok := r.budgets.Load(key)
+	budget, _ := previous.(restartBudget)
+	restarts := make(map[string]int32)
+	failures := r.recentFailures(budget.failures)
+	for _, pod := range claimedPods {
+		for _, status := range pod.Status.ContainerStatuses {
+			containerKey := string(pod.UID) + "/" + status.Name
+			restarts[containerKey] = status.RestartCount
+			delta := int(status.RestartCount - budget.restarts[containerKey])
+			if delta <= 0 {
+				continue
+			}
+			// the failures beyond the budget don't change the back off
+			for i := 0; i < min(delta, max(r.Options.PodRestartBudget, 1)); i++ {
+				failures = append(failures, time.Now())
+			}
+			budget.cause = podFailureCause(pod)
+		}
+	}
+	if !ok && len(restarts) == 0 {
+		return
+	}
+	budget.failures, budget.restarts = failures, restarts
+	r.budgets.Store(key, budget)
+}
+
+// stopCrashLoopingPods deletes the claimed frp client pods which are not ready once the restart budget of the
+// service is exhausted, they're recreated when the budget allows it. The remaining pods are returned, the pods
+// of a Deployment are left to its controller.
+func (r *ServiceReconciler) stopCrashLoopingPods(ctx context.Context, instance *v1.Service, claimedPods []*v1.Pod) ([]*v1.Pod, error) {
+	if exhausted, _, _ := r.checkRestartBudget(instance); !exhausted || r.managesDeployment() {
+		return claimedPods, nil
+	}
+	remaining := make([]*v1.Pod, 0, len(claimedPods))
+	for _, pod := range claimedPods {
+		if controllerutils.IsPodReady(pod) {
+			remaining = append(remaining, pod)
+			continue
+		}
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("unable delete crash looping pod '%s', got: %w", pod.Name, err)
+		}
+	}
+	return remaining, nil
+}
+
+// recentFailures returns the failures within the restart budget window
+func (r *ServiceReconciler) recentFailures(failures []time.Time) []time.Time {
+	since := time.Now().Add(-r.Options.PodRestartBudgetWindow)
+	return lo.Filter(failures, func(t time.Time, _ int) bool { return t.After(since) })
+}
+
+// checkRestartBudget reports whether the frp client pods of the service failed more often than the
+// restart budget allows within its window, and how long to back off until the oldest failure expires.
+func (r *ServiceReconciler) checkRestartBudget(instance *v1.Service) (bool, time.Duration, string) {
+	previous, ok := r.budgets.Load(client.ObjectKeyFromObject(instance))
+	if !ok || r.Options.PodRestartBudget <= 0 {
+		return false, 0, ""
+	}
+	budget := previous.(restartBudget)
+	failures := r.recentFailures(budget.failures)
+	if len(failures) < r.Options.PodRestartBudget {
+		return false, 0, ""
+	}
+	retryAfter := time.Until(failures[len(failures)-r.Options.PodRestartBudget].Add(r.Options.PodRestartBudgetWindow))
+	return true, max(retryAfter, time.Second), budget.cause
+}
+
+// forgetRestartBudget removes the failure history of a service which is no longer exposed
+func (r *ServiceReconciler) forgetRestartBudget(instance *v1.Service) {
+	r.budgets.Delete(client.ObjectKeyFromObject(instance))
+}
+
+// syncDegraded sets the v1beta1.ServiceConditionDegraded condition of the service, an event pointing
+// at the root cause is emitted when the service becomes degraded.
+func (r *ServiceReconciler) syncDegraded(ctx context.Context, instance *v1.Service, degraded bool, retryAfter time.Duration, cause string) error {
+	logger := log.FromContext(ctx)
+	condition := metav1.Condition{
+		Type:    v1beta1.ServiceConditionDegraded,
+		Status:  metav1.ConditionFalse,
+		Reason:  v1beta1.ReasonWithinRestartBudget,
+		Message: "frp client pod is running within its restart budget",
+	}
+	if degraded {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = v1beta1.ReasonRestartBudgetExhausted
+		condition.Message = fmt.Sprintf("frp client pod failed %d times within %s, retrying in %s, last failure: %s",
+			r.Options.PodRestartBudget, r.Options.PodRestartBudgetWindow, retryAfter.Round(time.Second), cause)
+	}
+	current := meta.FindStatusCondition(instance.Status.Conditions, condition.Type)
+	if current == nil && !degraded {
+		return nil
+	}
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason {
+		return nil
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, instance); err != nil {
+		logger.Error(err, "unable update degraded condition for service")
+		return err
+	}
+	if degraded {
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonRestartBudgetExhausted, condition.Message)
+	}
+	return nil
+}
+
+// rollbackPublishedEndpoints withdraws the ingress points published for the service once its frp client pods
+// exhausted their restart budget, the endpoints would receive no traffic. A terminal event is emitted when
+// endpoints were withdrawn, they're published again once a frp client pod is ready.
+func (r *ServiceReconciler) rollbackPublishedEndpoints(ctx context.Context, instance *v1.Service, cause string) error {
+	_, annotated := instance.Annotations[v1beta1.AnnotationPublishedEndpointsKey]
+	if len(instance.Status.LoadBalancer.Ingress) == 0 && !annotated {
+		return nil
+	}
+	if err := r.syncPublishedEndpoints(ctx, instance, nil); err != nil {
+		return err
+	}
+	if err := r.syncTunnelCondition(ctx, instance, nil); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(instance, v1.EventTypeWarning, v1beta1.ReasonProvisioningFailed,
+		"Withdrew the published endpoints, frp client pod failed %d times within %s, last failure: %s",
+		r.Options.PodRestartBudget, r.Options.PodRestartBudgetWindow, cause)
+	return nil
+}
+
+// podFailureCause describes why a frp client pod failed from the termination state of its containers
+func podFailureCause(pod *v1.Pod) string {
+	causes := make([]string, 0, len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil {
+			terminated = status.LastTerminationState.Terminated
+		}
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		cause := fmt.Sprintf("container %s exited with code %d (%s)", status.Name, terminated.ExitCode, terminated.Reason)
+		if message := strings.TrimSpace(terminated.Message); message != "" {
+			cause += ": " + message
+		}
+		causes = append(causes, cause)
+	}
+	if len(causes) != 0 {
+		return strings.Join(causes, "; ")
+	}
+	return fmt.Sprintf("pod %s is %s: %s %s", pod.Name, pod.Status.Phase, pod.Status.Reason, pod.Status.Message)
+}
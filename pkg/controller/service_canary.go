@@ -0,0 +1,192 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/samber/lo"
+	"hash/fnv"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sync"
+	"time"
+)
+
+// canaryRequeueInterval is the period services holding stale frp client pods are requeued at while
+// the canary validation of the pod template is pending
+const canaryRequeueInterval = 30 * time.Second
+
+// canaryPhase is the state of the canary validation of the pod template
+type canaryPhase string
+
+const (
+	canaryPending canaryPhase = "Pending"
+	canaryPassed  canaryPhase = "Passed"
+	canaryFailed  canaryPhase = "Failed"
+)
+
+// canaryState is the canary validation of the pod template of the manager, the validation restarts once
+// the template is replaced by a reload of the config file.
+type canaryState struct {
+	sync.Mutex
+	// template is the pod template the validation is for
+	template string
+	phase    canaryPhase
+	// started is when the canary service was first reconciled with the pod template
+	started time.Time
+	// restore is the last pod template the tunnel of the canary service was live with
+	restore string
+}
+
+// track restarts the validation once the pod template changed, the lock must be held
+func (c *canaryState) track(template string) {
+	if c.template == template {
+		return
+	}
+	c.template, c.phase, c.started = template, "", time.Time{}
+}
+
+// templateHash returns the value of the frplabels.PodTemplateHash label of the pods generated from template
+func templateHash(template string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(template))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// isCanary reports whether the service validates the pod template for the other services
+func (r *ServiceReconciler) isCanary(instance *v1.Service) bool {
+	return r.Options.CanaryService != "" && r.Options.CanaryService == instance.Namespace+"/"+instance.Name
+}
+
+// podTemplate returns the pod template the frp client pods are generated from, the previous template
+// is restored once the current one failed its canary validation.
+func (r *ServiceReconciler) podTemplate() string {
+	template := r.Options.CurrentPodTemplate()
+	if r.Options.CanaryService == "" {
+		return template
+	}
+	r.canary.Lock()
+	defer r.canary.Unlock()
+	r.canary.track(template)
+	if r.canary.phase == canaryFailed && r.canary.restore != "" {
+		return r.canary.restore
+	}
+	return template
+}
+
+// syncCanary validates the pod template with the canary service, the template passes once the frp client
+// pod generated from it is ready, i.e. its tunnel is live. It fails when the pod is not ready within
+// CanaryTimeout or exhausts the restart budget of the service, the duration to requeue the canary service
+// after is returned while the validation is pending.
+func (r *ServiceReconciler) syncCanary(ctx context.Context, instance *v1.Service, claimedPods []*v1.Pod) (time.Duration, error) {
+	logger := log.FromContext(ctx)
+	template := r.Options.CurrentPodTemplate()
+	r.canary.Lock()
+	defer r.canary.Unlock()
+	r.canary.track(template)
+	r.canary.restore = instance.Annotations[v1beta1.AnnotationCanaryPodTemplateKey]
+	if r.canary.restore == template {
+		r.canary.phase = canaryPassed
+		metrics.CanaryFailed.Set(0)
+		return 0, nil
+	}
+	if r.canary.phase == canaryFailed {
+		return 0, nil
+	}
+	hash := templateHash(template)
+	live := lo.SomeBy(claimedPods, func(pod *v1.Pod) bool {
+		return pod.Labels[frplabels.PodTemplateHash] == hash && controllerutils.IsPodReady(pod)
+	})
+	if live {
+		instance.Annotations[v1beta1.AnnotationCanaryPodTemplateKey] = template
+		if err := r.Update(ctx, instance); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable record canary pod template for service")
+			return 0, err
+		}
+		r.canary.phase, r.canary.restore = canaryPassed, template
+		metrics.CanaryFailed.Set(0)
+		r.Recorder.Event(instance, v1.EventTypeNormal, v1beta1.ReasonCanaryPassed,
+			fmt.Sprintf("frp tunnel is live with pod template %s, rolling out the frp client pods of the other services", hash))
+		return 0, nil
+	}
+	if r.canary.started.IsZero() {
+		r.canary.started = time.Now()
+	}
+	exhausted, _, cause := r.checkRestartBudget(instance)
+	if remaining := time.Until(r.canary.started.Add(r.Options.CanaryTimeout)); remaining > 0 && !exhausted {
+		r.canary.phase = canaryPending
+		return min(remaining, canaryRequeueInterval), nil
+	}
+	r.canary.phase = canaryFailed
+	metrics.CanaryFailed.Set(1)
+	message := fmt.Sprintf("frp tunnel is not live with pod template %s after %s", hash, r.Options.CanaryTimeout)
+	if exhausted {
+		message = fmt.Sprintf("frp client pod generated from pod template %s exhausted its restart budget: %s", hash, cause)
+	}
+	if r.canary.restore == "" {
+		message += ", no previous pod template to restore"
+	} else {
+		message += fmt.Sprintf(", restored pod template %s", templateHash(r.canary.restore))
+	}
+	logger.Info("pod template failed the canary validation", "service", r.Options.CanaryService, "template", hash)
+	r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonCanaryFailed, message)
+	return 0, nil
+}
+
+// canaryHolds reports whether the service keeps its stale frp client pods until the canary validation completes
+func (r *ServiceReconciler) canaryHolds(instance *v1.Service) bool {
+	template := r.Options.CurrentPodTemplate()
+	r.canary.Lock()
+	r.canary.track(template)
+	pending := r.canary.phase == "" || r.canary.phase == canaryPending
+	r.canary.Unlock()
+	return pending && !r.isCanary(instance)
+}
+
+// rolloutStalePods deletes the frp client pods of the service generated from another pod template, they are
+// recreated from the current one. The pods of the canary service are rolled first, the pods of the other services
+// are kept until the canary validation completes. The remaining pods are returned with the duration to requeue
+// the service after when stale pods are kept.
+func (r *ServiceReconciler) rolloutStalePods(ctx context.Context, instance *v1.Service, claimedPods []*v1.Pod) ([]*v1.Pod, time.Duration, error) {
+	logger := log.FromContext(ctx)
+	// the Deployment rolls out the pod template itself
+	if r.Options.CanaryService == "" || r.managesDeployment() {
+		return claimedPods, 0, nil
+	}
+	hash := templateHash(r.podTemplate())
+	stale := lo.Filter(claimedPods, func(pod *v1.Pod, _ int) bool { return pod.Labels[frplabels.PodTemplateHash] != hash })
+	if len(stale) == 0 {
+		return claimedPods, 0, nil
+	}
+	if r.canaryHolds(instance) {
+		return claimedPods, canaryRequeueInterval, nil
+	}
+	for _, pod := range stale {
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable delete stale frp client pod", "podName", pod.GetName())
+			return nil, 0, err
+		}
+		logger.Info("rolled out stale frp client pod", "podName", pod.GetName(), "template", hash)
+	}
+	return lo.Without(claimedPods, stale...), 0, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+// clientCertificate returns the cert-manager Certificate issuing the client certificate of the namespace
+// from the client certificate template, the common name of the certificate is the namespace.
+func (r *ServiceReconciler) clientCertificate(namespace string) (*unstructured.Unstructured, error) {
+	cert := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(r.Options.ClientCertificateTemplate), cert); err != nil {
+		return nil, fmt.Errorf("unable parse yaml from client certificate template, got: %w", err)
+	}
+	cert.SetNamespace(namespace)
+	cert.SetName(util.EmptyOr(cert.GetName(), v1beta1.DefaultClientCertificateName))
+	secretName, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+	fields := map[string]any{
+		"secretName": util.EmptyOr(secretName, cert.GetName()+"-tls"),
+		"commonName": namespace,
+	}
+	if usages, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "usages"); len(usages) == 0 {
+		fields["usages"] = []any{"client auth", "digital signature", "key encipherment"}
+	}
+	for field, value := range fields {
+		if err := unstructured.SetNestedField(cert.Object, value, "spec", field); err != nil {
+			return nil, fmt.Errorf("unable set spec.%s of client certificate, got: %w", field, err)
+		}
+	}
+	return cert, nil
+}
+
+// syncClientCertificate ensures the cert-manager Certificate issuing the client certificate of the namespace
+// of the service exists and matches the client certificate template. The Certificate is shared by the services
+// of the namespace, so it's not owned by the service and outlives it.
+func (r *ServiceReconciler) syncClientCertificate(ctx context.Context, instance *v1.Service) error {
+	logger := log.FromContext(ctx)
+	if r.Options.ClientCertificateTemplate == "" {
+		return nil
+	}
+	desired, err := r.clientCertificate(instance.Namespace)
+	if err != nil {
+		return err
+	}
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(desired.GroupVersionKind())
+	err = r.Get(ctx, client.ObjectKeyFromObject(desired), current)
+	if errors.IsNotFound(err) {
+		logger.Info("create client certificate for namespace", "namespace", instance.Namespace, "certificate", desired.GetName())
+		return r.Create(ctx, desired)
+	}
+	if err != nil {
+		logger.Error(err, "unable get client certificate", "namespace", instance.Namespace, "certificate", desired.GetName())
+		return err
+	}
+	if equality.Semantic.DeepEqual(current.Object["spec"], desired.Object["spec"]) {
+		return nil
+	}
+	current.Object["spec"] = desired.Object["spec"]
+	if err := r.Update(ctx, current); err != nil {
+		logger.Error(err, "unable update client certificate", "namespace", instance.Namespace, "certificate", desired.GetName())
+		return err
+	}
+	return nil
+}
+
+// applyClientCertificate mounts the client certificate of the namespace in the containers of the frp client pod,
+// the ca.crt issued by cert-manager is mounted as v1beta1.DefaultCaFileName.
+func (r *ServiceReconciler) applyClientCertificate(pod *v1.Pod) error {
+	cert, err := r.clientCertificate(pod.Namespace)
+	if err != nil {
+		return err
+	}
+	secretName, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: v1beta1.ClientTLSVolumeName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: secretName,
+				Items: []v1.KeyToPath{
+					{Key: v1.TLSCertKey, Path: v1beta1.DefaultCertFileName},
+					{Key: v1.TLSPrivateKeyKey, Path: v1beta1.DefaultKeyFileName},
+					{Key: "ca.crt", Path: v1beta1.DefaultCaFileName},
+				},
+			},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+			Name:      v1beta1.ClientTLSVolumeName,
+			MountPath: v1beta1.ClientTLSMountPath,
+			ReadOnly:  true,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"hash/fnv"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strconv"
+)
+
+// resolveHostnameConflict returns the hostname the service is published on once the conflicts with the other
+// services of the FrpServer publishing their proxies of the same type on the same hostname are resolved. The
+// hostname is empty when the service lost it and the strategy doesn't rename the subdomain.
+func (r *ServiceReconciler) resolveHostnameConflict(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer,
+	domain *v1beta1.FrpServerDomain, subdomain string) (string, error) {
+	hostname := frpclient.Hostname(domain, subdomain)
+	if _, err := servicePriority(instance); err != nil {
+		return "", err
+	}
+	rivals, err := r.hostnameRivals(ctx, instance, server, hostname)
+	if err != nil {
+		return "", err
+	}
+	if len(rivals) == 0 {
+		return hostname, r.syncEffectiveSubdomain(ctx, instance, "")
+	}
+	strategy, err := r.conflictStrategy(ctx, instance.Namespace)
+	if err != nil {
+		return "", err
+	}
+	winner, lost := lo.Find(rivals, func(rival *v1.Service) bool { return !outranks(instance, rival, hostname, strategy) })
+	if !lost {
+		// the services of lower rank still publishing the hostname are preempted
+		for _, rival := range rivals {
+			if err := r.withdrawHostname(ctx, rival, instance, hostname); err != nil {
+				return "", err
+			}
+		}
+		return hostname, r.syncEffectiveSubdomain(ctx, instance, "")
+	}
+	if strategy == v1beta1.ConflictStrategySuffixHash && subdomain != "" {
+		effective := subdomain + "-" + nameHash(instance)
+		return frpclient.Hostname(domain, effective), r.syncEffectiveSubdomain(ctx, instance, effective)
+	}
+	r.Recorder.Eventf(instance, v1.EventTypeWarning, v1beta1.ReasonNamingConflict,
+		"Hostname %s is taken by service %s/%s, not publishing it with the %s conflict strategy", hostname, winner.Namespace, winner.Name, strategy)
+	return "", r.syncEffectiveSubdomain(ctx, instance, "")
+}
+
+// hostnameRivals returns the other services of the FrpServer publishing their proxies of the same type on the
+// hostname, the services whose hostname can't be resolved report it themselves and are skipped.
+func (r *ServiceReconciler) hostnameRivals(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer, hostname string) ([]*v1.Service, error) {
+	services, err := scheduledServices(ctx, r.Client, server)
+	if err != nil {
+		return nil, err
+	}
+	var rivals []*v1.Service
+	for _, svc := range services {
+		if svc.UID == instance.UID || svc.DeletionTimestamp != nil || isHostPortMode(svc) || serviceProxyType(svc) != serviceProxyType(instance) {
+			continue
+		}
+		domain, subdomain, err := r.serviceSubdomain(ctx, svc, server)
+		if err == nil && domain != nil && frpclient.Hostname(domain, subdomain) == hostname {
+			rivals = append(rivals, svc)
+		}
+	}
+	return rivals, nil
+}
+
+// conflictStrategy returns the conflict strategy of the namespace, the annotation of the namespace overrides
+// the strategy of the manager options
+func (r *ServiceReconciler) conflictStrategy(ctx context.Context, namespace string) (string, error) {
+	ns := &v1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return "", fmt.Errorf("unable get namespace '%s', got: %w", namespace, err)
+	}
+	strategy := util.EmptyOr(ns.Annotations[v1beta1.AnnotationConflictStrategyKey], r.Options.ConflictStrategy)
+	if !lo.Contains(v1beta1.ConflictStrategies, strategy) {
+		return "", fmt.Errorf("invalid annotations.%s '%s' of namespace '%s', optional values are %v",
+			v1beta1.AnnotationConflictStrategyKey, strategy, namespace, v1beta1.ConflictStrategies)
+	}
+	return strategy, nil
+}
+
+// servicePriority returns the priority of the service in the naming conflicts, 0 when it's not set
+func servicePriority(instance *v1.Service) (int, error) {
+	value, ok := instance.Annotations[v1beta1.AnnotationPriorityKey]
+	if !ok {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid annotations.%s '%s', got: %w", v1beta1.AnnotationPriorityKey, value, err)
+	}
+	return priority, nil
+}
+
+// outranks reports whether service a keeps the hostname over service b. The higher priority wins with the
+// preempt-lower-priority strategy, then the service already publishing the hostname, then the older service.
+func outranks(a, b *v1.Service, hostname, strategy string) bool {
+	if strategy == v1beta1.ConflictStrategyPreemptLowerPriority {
+		pa, _ := servicePriority(a)
+		pb, _ := servicePriority(b)
+		if pa != pb {
+			return pa > pb
+		}
+	}
+	if pa, pb := publishesHostname(a, hostname), publishesHostname(b, hostname); pa != pb {
+		return pa
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+}
+
+// publishesHostname reports whether the hostname is a published ingress point of the service
+func publishesHostname(instance *v1.Service, hostname string) bool {
+	return lo.ContainsBy(instance.Status.LoadBalancer.Ingress, func(ingress v1.LoadBalancerIngress) bool { return ingress.Hostname == hostname })
+}
+
+// withdrawHostname removes the hostname from the published ingress points of the service which lost it to
+// the winner, the service publishes its endpoints again on its next reconcile.
+func (r *ServiceReconciler) withdrawHostname(ctx context.Context, instance, winner *v1.Service, hostname string) error {
+	if !publishesHostname(instance, hostname) {
+		return nil
+	}
+	ingress := lo.Reject(instance.Status.LoadBalancer.Ingress, func(ingress v1.LoadBalancerIngress, _ int) bool {
+		return ingress.Hostname == hostname
+	})
+	if err := writeIngress(ctx, r.Client, r.Options, serviceControllerName, instance.DeepCopy(), instance, ingress); err != nil && !errors.IsNotFound(err) {
+		log.FromContext(ctx).Error(err, "unable withdraw preempted hostname of service", "service", client.ObjectKeyFromObject(instance).String())
+		return err
+	}
+	r.Recorder.Eventf(instance, v1.EventTypeWarning, v1beta1.ReasonNamingConflict,
+		"Hostname %s was preempted by service %s/%s", hostname, winner.Namespace, winner.Name)
+	return nil
+}
+
+// syncEffectiveSubdomain records the subdomain renamed by a naming conflict on the service and its frp client
+// pods, it's removed when effective is empty.
+func (r *ServiceReconciler) syncEffectiveSubdomain(ctx context.Context, instance *v1.Service, effective string) error {
+	if current, ok := instance.Annotations[v1beta1.AnnotationEffectiveSubdomainKey]; current == effective && ok == (effective != "") {
+		return nil
+	}
+	activePods, _, err := r.getOwnedPods(ctx, instance)
+	if err != nil {
+		return err
+	}
+	for _, obj := range append([]client.Object{instance}, lo.Map(activePods, func(pod *v1.Pod, _ int) client.Object { return pod })...) {
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		annotations := obj.GetAnnotations()
+		if effective == "" {
+			delete(annotations, v1beta1.AnnotationEffectiveSubdomainKey)
+		} else {
+			annotations = lo.Assign(annotations, map[string]string{v1beta1.AnnotationEffectiveSubdomainKey: effective})
+		}
+		obj.SetAnnotations(annotations)
+		if err := r.Patch(ctx, obj, patch); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable record effective subdomain on %s, got: %w", obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// nameHash returns a short hash of the namespace and name of the service
+func nameHash(instance *v1.Service) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(instance.Namespace + "/" + instance.Name))
+	return fmt.Sprintf("%08x", h.Sum32())[:6]
+}
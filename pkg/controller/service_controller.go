@@ -19,44 +19,112 @@ package controller
 import (
 	"context"
 	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/access"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
 	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
 	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/frp-sigs/frp-provisioner/pkg/events"
+	"github.com/frp-sigs/frp-provisioner/pkg/frpcinit"
+	"github.com/frp-sigs/frp-provisioner/pkg/kms"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
 	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/fieldindex"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/naming"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/samber/lo"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
-	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/apiserver/pkg/storage/names"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
+	"sync"
+	"time"
 )
 
-const defaultBaseName = "frp-client"
+const (
+	defaultBaseName = "frp-client"
+	// serviceControllerName labels the metrics of the service controller
+	serviceControllerName = "service"
+)
 
 // ServiceReconciler reconciles a FrpServer object
 type ServiceReconciler struct {
 	client.Client
 	Scheme  *runtime.Scheme
 	Options *config.ManagerOptions
+	// KMS encrypts the generated stcp/xtcp/sudp secret keys at rest, they are stored in plaintext when nil
+	KMS kms.Service
+	// Access enforces the source ranges of the services in the frps access plugin, the source ranges
+	// annotation is rejected when nil
+	Access *access.Store
+	// Credentials resolves the frp credentials of the FrpServers served to the frpc-init containers
+	Credentials *credentials.Resolver
+
+	// Recorder emits the events of the services
+	Recorder record.EventRecorder
+	// Pods reads the logs of crash looping frp client pods, the logs are not attached to events when nil
+	Pods corev1client.PodsGetter
+	// CloudEvents emits the provisioning decisions as CloudEvents, they're not emitted when nil
+	CloudEvents *events.CloudEventSink
+	// Outages buffers the proxy changes while the FrpServer of a service is Unhealthy, they're retried on
+	// every reconcile when nil
+	Outages *OutageQueue
+	// Reloads enqueues the exposed services once the reloaded config changed their pods or their placement,
+	// they pick up the reloaded options on their next change when nil
+	Reloads *ConfigReloads
+
+	// tunnels tracks the last observed tunnelState of each service to count reconnects
+	tunnels sync.Map
+	// budgets tracks the restartBudget of each service
+	budgets sync.Map
+	// crashes tracks the last crashReport of each frp client container, keyed by crashKey
+	crashes sync.Map
+	// ingressIPs reserves the ingress IPs allocated by this manager, keyed by "{server}/{ip}"
+	ingressIPs sync.Map
+	// canary is the canary validation of the pod template
+	canary canaryState
+	// rollout paces the restarts rolling out changed FrpServer specs
+	rollout serverRollout
+	// templates caches the parsed pod templates the frp client pods are generated from
+	templates config.PodTemplates
+}
+
+// tunnelState is the last observed tunnel readiness of a service
+type tunnelState struct {
+	ready     bool
+	everReady bool
+	// server is the FrpServer the tunnel was counted on by the active tunnels metric
+	server string
 }
 
 func (r *ServiceReconciler) getOwnedPods(ctx context.Context, instance *v1.Service) ([]*v1.Pod, []*v1.Pod, error) {
 	logger := log.FromContext(ctx)
+	defer metrics.StartPhase(ctx, serviceControllerName, metrics.PhaseListPods)()
 	podList := &v1.PodList{}
-	opts := &client.ListOptions{
-		Namespace: instance.Namespace,
-		LabelSelector: labels.SelectorFromSet(labels.Set{
-			v1beta1.LabelServiceNameKey:   instance.Name,
-			v1beta1.LabelControllerUidKey: string(instance.UID),
-		}),
+	opts := []client.ListOption{
+		client.InNamespace(instance.Namespace),
+		client.MatchingFields{fieldindex.IndexNameForControllerUID: string(instance.UID)},
 	}
-	if err := r.List(ctx, podList, opts); err != nil {
+	if err := r.List(ctx, podList, opts...); err != nil {
 		logger.WithValues("namespace", instance.Namespace).Error(err, "unable get pod list")
 		return nil, nil, err
 	}
@@ -74,9 +142,11 @@ func (r *ServiceReconciler) getOwnedPods(ctx context.Context, instance *v1.Servi
 
 func (r *ServiceReconciler) generatePod(ctx context.Context, owner *v1.Service) (*v1.Pod, error) {
 	logger := log.FromContext(ctx)
-	pod := &v1.Pod{}
-	if err := yaml.Unmarshal([]byte(r.Options.PodTemplate), pod); err != nil {
-		logger.Error(err, "unable parse yaml from pod template", "template", r.Options.PodTemplate)
+	defer metrics.StartPhase(ctx, serviceControllerName, metrics.PhaseGenerate)()
+	template := r.podTemplate()
+	pod, err := r.templates.NewPod(template)
+	if err != nil {
+		logger.Error(err, "unable parse yaml from pod template", "template", template)
 		return nil, fmt.Errorf("unable parse yaml from pod template, err: %w", err)
 	}
 	if pod.GetLabels() == nil {
@@ -87,13 +157,46 @@ func (r *ServiceReconciler) generatePod(ctx context.Context, owner *v1.Service)
 		baseName = pod.GetName()
 	}
 	pod.SetNamespace(owner.Namespace)
-	pod.SetName(names.SimpleNameGenerator.GenerateName(baseName + "-" + owner.Name))
+	pod.SetName(names.SimpleNameGenerator.GenerateName(naming.Shorten(baseName+"-"+owner.Name, naming.MaxPrefixLength)))
 	if err := controllerutil.SetControllerReference(owner, pod, r.Scheme); err != nil {
 		logger.Error(err, "can't set Pod owner reference", "namespace", pod.GetNamespace(), "name", pod.GetName())
 		return nil, fmt.Errorf("can't set Pod '%v/%v' owner reference: %w", pod.GetNamespace(), pod.GetName(), err)
 	}
-	pod.Labels[v1beta1.LabelServiceNameKey] = owner.Name
-	pod.Labels[v1beta1.LabelControllerUidKey] = string(owner.UID)
+	for key, value := range frplabels.ForService(owner) {
+		pod.Labels[key] = value
+	}
+	pod.Labels[frplabels.PodTemplateHash] = templateHash(template)
+	for _, key := range []string{v1beta1.AnnotationEffectiveSubdomainKey, v1beta1.AnnotationMultiplexerKey,
+		v1beta1.AnnotationRouteByHTTPUserKey, v1beta1.AnnotationPluginKey, v1beta1.AnnotationPluginCertSecretKey} {
+		if value, ok := owner.Annotations[key]; ok {
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[key] = value
+		}
+	}
+	for key, value := range owner.Annotations {
+		if strings.HasPrefix(key, v1beta1.AnnotationPortPrefix) {
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[key] = value
+		}
+	}
+	if err := applyInlineServerToken(pod, owner); err != nil {
+		return nil, err
+	}
+	applyHTTPAuth(pod, owner)
+	applyPluginCert(pod, owner)
+	if isHostPortMode(owner) {
+		if err := applyHostPorts(pod, owner, r.Options.HostPortForwarderImage); err != nil {
+			return nil, err
+		}
+	} else if r.Options.ClientCertificateTemplate != "" {
+		if err := r.applyClientCertificate(pod); err != nil {
+			return nil, err
+		}
+	}
 	return pod, nil
 }
 
@@ -101,6 +204,17 @@ func (r *ServiceReconciler) generatePod(ctx context.Context, owner *v1.Service)
 //+kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=services/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpproxies,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=frp.gofrp.io,resources=frpservergroups,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;create;update
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -112,6 +226,7 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		if errors.IsNotFound(err) {
 			// skip deleted object
 			logger.Info("service has been deleted", "request", req.String())
+			r.forgetSourceRanges(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		logger.Error(err, "unable get service by name", "request", req.String())
@@ -122,58 +237,557 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		logger.Error(err, "unable get owner pods for service", "request", req.String())
 		return ctrl.Result{}, err
 	}
+	endClaim := metrics.StartPhase(ctx, serviceControllerName, metrics.PhaseClaim)
 	claimedPods, err := r.claimPods(instance, activePods)
+	endClaim()
 	if err != nil {
 		logger.Error(err, "unable get claimed pods for service", "request", req.String())
 		return ctrl.Result{}, err
 	}
 	errsList := make([]error, 0)
-	// kill all inactive pods
+	if r.managesDeployment() {
+		// the frp client pods created before the Deployment mode are replaced by the pods of the Deployment
+		for _, pod := range claimedPods {
+			if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+				logger.Error(err, "unable delete frp client pod replaced by deployment", "podName", pod.GetName())
+				return ctrl.Result{}, err
+			}
+		}
+		claimedPods = lo.Filter(activePods, func(pod *v1.Pod, _ int) bool { return isReplicaSetPod(pod) })
+	} else if lo.SomeBy(activePods, isReplicaSetPod) {
+		// the Deployment created before the Pod mode is replaced by the frp client pods of the service
+		if err := deleteClientDeployment(ctx, r.Client, instance); err != nil {
+			logger.Error(err, "unable delete frp client deployment replaced by pods", "service", req.String())
+			return ctrl.Result{}, err
+		}
+	}
+	// kill all inactive pods, the terminating pods are left to their grace period
 	for _, pod := range inactivePods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
 		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
 			logger.Error(err, "unable delete pod", "podName", pod.GetName())
 			errsList = append(errsList, err)
+		} else if err == nil {
+			r.recordPodFailure(instance, pod)
 		}
 	}
+	r.forgetCrashLoops(inactivePods)
 	if len(errsList) != 0 {
 		return ctrl.Result{}, utilerrors.NewAggregate(errsList)
 	}
-	// clean for delete service or service type is not LoadBalancer
-	if instance.Spec.Type != v1.ServiceTypeLoadBalancer || len(instance.Annotations) == 0 ||
-		instance.Annotations[v1beta1.AnnotationFrpServerNameKey] == "" || instance.DeletionTimestamp != nil {
+	// clean for delete service or service type is not exposable
+	if !r.isExposable(instance) || !isExposed(instance) || instance.DeletionTimestamp != nil {
+		if drainAfter, err := r.drainPods(ctx, instance, claimedPods, inactivePods); err != nil || drainAfter != 0 {
+			return ctrl.Result{RequeueAfter: drainAfter}, err
+		}
 		for _, pod := range claimedPods {
 			if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
 				logger.Error(err, "unable delete pod for service", "podName", pod.GetName(), "service", req)
 				errsList = append(errsList, fmt.Errorf("unable delete pod '%s', err: %w", req.String(), err))
 			}
 		}
-		instance.Finalizers = lo.Without(instance.Finalizers, v1beta1.FinalizerName)
+		if r.managesDeployment() {
+			if err := deleteClientDeployment(ctx, r.Client, instance); err != nil {
+				logger.Error(err, "unable delete frp client deployment for service", "service", req)
+				errsList = append(errsList, err)
+			}
+		}
+		if err := r.deleteGroupProxies(ctx, instance, nil); err != nil {
+			logger.Error(err, "unable delete frp server group proxies for service", "service", req)
+			errsList = append(errsList, err)
+		}
+		r.forgetTunnel(instance)
+		r.forgetSourceRanges(client.ObjectKeyFromObject(instance))
+		r.forgetRestartBudget(instance)
+		r.forgetCrashLoops(claimedPods)
+		original := instance.DeepCopy()
+		meta.RemoveStatusCondition(&instance.Status.Conditions, v1beta1.ServiceConditionTunnelReady)
+		if err := writeIngress(ctx, r.Client, r.Options, serviceControllerName, original, instance, nil); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable clear load balancer status for service", "service", req.String())
+			errsList = append(errsList, fmt.Errorf("unable clear load balancer status for service '%s', err: %w", req.String(), err))
+		}
+		if err := r.releaseIngressIP(ctx, instance); err != nil {
+			errsList = append(errsList, err)
+		}
+		delete(instance.Annotations, v1beta1.AnnotationPublishedEndpointsKey)
+		delete(instance.Annotations, v1beta1.AnnotationInternalEndpointsKey)
+		provisioned := lo.Contains(instance.Finalizers, frplabels.Finalizer)
+		instance.Finalizers = lo.Without(instance.Finalizers, frplabels.Finalizer)
 		if err := r.Update(ctx, instance); err != nil {
 			logger.Error(err, "unable remove finalizers for service", "service", req.String())
 			errsList = append(errsList, fmt.Errorf("unable remove finalizers for service '%s', err: %w", req.String(), err))
+		} else if provisioned {
+			r.CloudEvents.Emit(events.TypeTunnelDeleted, tunnelSubject(instance), &events.TunnelEvent{
+				Namespace: instance.Namespace, Service: instance.Name, Server: instance.Annotations[v1beta1.AnnotationFrpServerNameKey],
+			})
 		}
 		return ctrl.Result{}, utilerrors.NewAggregate(errsList)
 	}
 	// add finalizer for current service
-	if !lo.Contains(instance.Finalizers, v1beta1.FinalizerName) {
-		instance.Finalizers = append(instance.Finalizers, v1beta1.FinalizerName)
+	if !lo.Contains(instance.Finalizers, frplabels.Finalizer) {
+		instance.Finalizers = append(instance.Finalizers, frplabels.Finalizer)
 		if err := r.Update(ctx, instance); err != nil {
 			logger.Error(err, "unable add finalizers for service", "service", req.String())
 			return ctrl.Result{}, fmt.Errorf("unable add finalizers for service '%s', err: %w", req.String(), err)
 		}
+		r.CloudEvents.Emit(events.TypeTunnelCreated, tunnelSubject(instance), &events.TunnelEvent{
+			Namespace: instance.Namespace, Service: instance.Name, Server: instance.Annotations[v1beta1.AnnotationFrpServerNameKey],
+		})
+	}
+	// the audit annotations are best effort, e.g. the webhook denies them on an invalid service
+	if err := stampReconcile(ctx, r.Client, r.Options, instance); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "unable stamp reconcile audit annotations of service", "service", req.String())
+	}
+	var requeueAfter time.Duration
+	if r.isCanary(instance) {
+		if requeueAfter, err = r.syncCanary(ctx, instance, claimedPods); err != nil {
+			logger.Error(err, "unable sync canary validation for service", "service", req.String())
+			return ctrl.Result{}, err
+		}
+	}
+	claimedPods, rolloutAfter, err := r.rolloutStalePods(ctx, instance, claimedPods)
+	if err != nil {
+		logger.Error(err, "unable roll out stale pods for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	image, err := serviceImage(r.Options, instance)
+	if err != nil {
+		// the service is requeued once its annotations are fixed, the running pods are kept
+		logger.Error(err, "invalid frp client image for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if claimedPods, err = r.rolloutImage(ctx, instance, image, claimedPods); err != nil {
+		logger.Error(err, "unable roll out image for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	if rolloutAfter != 0 && (requeueAfter == 0 || rolloutAfter < requeueAfter) {
+		requeueAfter = rolloutAfter
+	}
+	// the secret key is generated before the frpc config reading it is rendered
+	if err := r.syncSecretKey(ctx, instance); err != nil {
+		logger.Error(err, "unable sync secret key for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	var server *v1beta1.FrpServer
+	var frpcConfigHash string
+	if !isHostPortMode(instance) {
+		if err := r.syncClientCertificate(ctx, instance); err != nil {
+			logger.Error(err, "unable sync client certificate for service", "service", req.String())
+			return ctrl.Result{}, err
+		}
+		if server, err = r.scheduleServer(ctx, instance); err != nil {
+			logger.Error(err, "unable get frp server for service", "service", req.String())
+			return ctrl.Result{}, err
+		}
+		if r.Outages.Holds(server.Name) && server.DeletionTimestamp == nil {
+			return ctrl.Result{RequeueAfter: requeueAfter}, r.queueOutage(ctx, instance, server)
+		}
+		var resyncAfter time.Duration
+		if claimedPods, resyncAfter, err = r.syncServerConfig(ctx, instance, server, claimedPods); err != nil {
+			logger.Error(err, "unable roll out frp server config for service", "service", req.String())
+			return ctrl.Result{}, err
+		}
+		if resyncAfter != 0 && (requeueAfter == 0 || resyncAfter < requeueAfter) {
+			requeueAfter = resyncAfter
+		}
+		if r.Options.RenderFrpcConfig {
+			creds, err := r.frpcCredentials(ctx, instance, server)
+			if err != nil {
+				logger.Error(err, "unable resolve frpc credentials for service", "service", req.String())
+				return ctrl.Result{}, err
+			}
+			data, err := r.renderFrpcConfig(instance, server, creds)
+			if err != nil {
+				// the service is requeued once its annotations are fixed, the running pods are kept
+				logger.Error(err, "unable render frpc config for service", "service", req.String())
+				r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+				return ctrl.Result{}, nil
+			}
+			if frpcConfigHash, err = r.syncFrpcConfig(ctx, instance, server, data, creds); err != nil {
+				logger.Error(err, "unable sync frpc config for service", "service", req.String())
+				return ctrl.Result{}, err
+			}
+			if claimedPods, resyncAfter, err = r.rolloutFrpcConfig(ctx, instance, frpcConfigHash, claimedPods); err != nil {
+				logger.Error(err, "unable roll out frpc config for service", "service", req.String())
+				return ctrl.Result{}, err
+			}
+			if resyncAfter != 0 && (requeueAfter == 0 || resyncAfter < requeueAfter) {
+				requeueAfter = resyncAfter
+			}
+		}
+	}
+	r.recordContainerRestarts(instance, claimedPods)
+	if claimedPods, err = r.stopCrashLoopingPods(ctx, instance, claimedPods); err != nil {
+		logger.Error(err, "unable stop crash looping pods for service", "service", req.String())
+		return ctrl.Result{}, err
 	}
 	if len(claimedPods) == 0 {
+		// back off once the frp client pods keep failing, e.g. crash looping on a bad token
+		if exhausted, retryAfter, cause := r.checkRestartBudget(instance); exhausted {
+			logger.Info("frp client pod restart budget exhausted, backing off", "service", req.String(), "retryAfter", retryAfter)
+			if err := r.rollbackPublishedEndpoints(ctx, instance, cause); err != nil {
+				logger.Error(err, "unable withdraw published endpoints for service", "service", req.String())
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: retryAfter}, r.syncDegraded(ctx, instance, true, retryAfter, cause)
+		}
+		// the tunnels are not recreated while the FrpServer is being deleted
+		if server != nil && server.DeletionTimestamp != nil {
+			logger.Info("frp server is being deleted, not creating frp client pod", "service", req.String(), "server", server.Name)
+			return ctrl.Result{}, nil
+		}
+	}
+	// the Deployment is applied on every reconcile so it rolls out the changes of the generated pod
+	if len(claimedPods) == 0 || r.managesDeployment() {
 		pod, err := r.generatePod(ctx, instance)
 		if err != nil {
 			logger.Error(err, "unable generate pod from podTemplate")
 			return ctrl.Result{}, fmt.Errorf("unable generate pod from podTemplate, err: %w", err)
 		}
-		if err := r.Create(ctx, pod); err != nil {
+		if err := applyImage(pod, image); err != nil {
+			logger.Error(err, "unable apply frp client image to pod")
+			return ctrl.Result{}, err
+		}
+		if server != nil {
+			applyServerConfig(pod, server)
+		}
+		if frpcConfigHash != "" {
+			r.applyFrpcConfig(pod, instance, server, frpcConfigHash)
+		}
+		endCreate := metrics.StartPhase(ctx, serviceControllerName, metrics.PhaseCreate)
+		if r.managesDeployment() {
+			var heldAfter time.Duration
+			heldAfter, err = r.syncDeployment(ctx, instance, pod)
+			if heldAfter != 0 && (requeueAfter == 0 || heldAfter < requeueAfter) {
+				requeueAfter = heldAfter
+			}
+		} else {
+			err = r.Create(ctx, pod)
+		}
+		endCreate()
+		if err != nil {
+			metrics.ProxyCreateFailuresTotal.WithLabelValues("Service", instance.Annotations[v1beta1.AnnotationFrpServerNameKey]).Inc()
 			logger.Error(err, "unable create frp pod by template", "pod", fmt.Sprintf("%+v", pod))
 			return ctrl.Result{}, fmt.Errorf("unable create frp pod '%+v',err: %w", pod, err)
 		}
 	}
-	return ctrl.Result{}, nil
+	ready := lo.SomeBy(claimedPods, controllerutils.IsPodReady)
+	r.recordTunnel(instance, ready)
+	if err := r.syncTunnelCondition(ctx, instance, claimedPods); err != nil {
+		logger.Error(err, "unable sync tunnel ready condition for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	r.reportCrashLoops(ctx, instance, claimedPods)
+	if ready {
+		if err := r.syncDegraded(ctx, instance, false, 0, ""); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.syncTunnelReadiness(ctx, instance, server, claimedPods); err != nil {
+		logger.Error(err, "unable sync tunnel readiness for backend pods", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	if isHostPortMode(instance) {
+		ingress, err := r.hostPortIngress(ctx, claimedPods)
+		if err != nil {
+			logger.Error(err, "unable get host port ingress for service", "service", req.String())
+			return ctrl.Result{}, err
+		}
+		if err := r.syncPublishedEndpoints(ctx, instance, ingress); err != nil {
+			logger.Error(err, "unable sync published endpoints for service", "service", req.String())
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+	if server.Spec.User, err = frpclient.ProxyUser(server, instance.Annotations); err != nil {
+		// the service is requeued once its annotations are fixed
+		logger.Error(err, "invalid frp user for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := checkProxyType(r.Options, server, instance); err != nil {
+		logger.Error(err, "proxy type not allowed for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := validateProxyDependencies(instance); err != nil {
+		logger.Error(err, "invalid proxy dependencies for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := r.syncSourceRanges(instance, server); err != nil {
+		logger.Error(err, "invalid source ranges for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := validateProxyConfig(instance, server); err != nil {
+		logger.Error(err, "invalid proxy config for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if _, err := splitHorizon(r.Options, instance); err != nil {
+		logger.Error(err, "invalid split-horizon publication for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := validateTCPMuxProxy(instance, server); err != nil {
+		logger.Error(err, "invalid tcpmux config for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := r.checkHTTPAuthSecret(ctx, instance); err != nil {
+		logger.Error(err, "invalid http auth secret for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+	if err := validatePlugin(instance); err != nil {
+		logger.Error(err, "invalid client plugin for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err := r.checkPluginCertSecret(ctx, instance); err != nil {
+		logger.Error(err, "invalid plugin cert secret for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+	if err := r.syncProxyBackends(ctx, instance, claimedPods); err != nil {
+		logger.Error(err, "unable sync proxy backends for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+	if err := r.syncGroupProxies(ctx, instance, server); err != nil {
+		logger.Error(err, "unable sync frp server group proxies for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	ip, err := r.syncIngressIP(ctx, instance, server)
+	if err != nil {
+		logger.Error(err, "unable sync ingress ip for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonIngressIPFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+	hostname, err := r.publishedHostname(ctx, instance, server)
+	if err != nil {
+		logger.Error(err, "unable get published hostname for service", "service", req.String())
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonGenerateConfigFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+	if err := r.syncPublishedEndpoints(ctx, instance, publishedIngress(instance, server, ip, hostname, ready)); err != nil {
+		logger.Error(err, "unable sync published endpoints for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	if err := r.syncInternalEndpoints(ctx, instance); err != nil {
+		logger.Error(err, "unable sync internal endpoints for service", "service", req.String())
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// validateProxyDependencies checks the start order of the proxies of the service can be resolved, the
+// dependencies refer to the ports like renderFrpcConfig resolves them.
+func validateProxyDependencies(instance *v1.Service) error {
+	dependsOn, err := frpclient.ProxyDependencies(instance.Annotations)
+	if err != nil || len(dependsOn) == 0 {
+		return err
+	}
+	proxies := lo.Map(instance.Spec.Ports, func(port v1.ServicePort, _ int) string { return portName(port) })
+	_, err = frpclient.StartOrder(proxies, dependsOn)
+	return err
+}
+
+// validateProxyConfig checks the proxy settings of the service annotations and the FrpServer defaults,
+// the features unsupported by the frps version the server reported on its last login are rejected.
+func validateProxyConfig(instance *v1.Service, server *v1beta1.FrpServer) error {
+	base := &configv1.ProxyBaseConfig{}
+	if err := frpclient.ApplyProxyDefaults(base, server, instance.Annotations); err != nil {
+		return err
+	}
+	for _, port := range instance.Spec.Ports {
+		proxyType := portProxyType(instance, port)
+		cfg := configv1.NewProxyConfigurerByType(configv1.ProxyType(proxyType))
+		if cfg == nil {
+			continue
+		}
+		*cfg.GetBaseConfig() = *base
+		cfg.GetBaseConfig().Type = proxyType
+		switch cfg := cfg.(type) {
+		case *configv1.HTTPProxyConfig:
+			cfg.RouteByHTTPUser = instance.Annotations[v1beta1.AnnotationRouteByHTTPUserKey]
+		case *configv1.TCPMuxProxyConfig:
+			cfg.RouteByHTTPUser = instance.Annotations[v1beta1.AnnotationRouteByHTTPUserKey]
+		}
+		if err := frpclient.CheckProxyCompatibility(cfg, server.Status.ServerVersion); err != nil {
+			return fmt.Errorf("invalid proxy of port '%s', got: %w", portName(port), err)
+		}
+	}
+	return nil
+}
+
+// recordTunnel updates the tunnel metrics of the service, a reconnect is counted when
+// the tunnel becomes ready again after it has been ready before.
+func (r *ServiceReconciler) recordTunnel(instance *v1.Service, ready bool) {
+	key := client.ObjectKeyFromObject(instance)
+	serverName := instance.Annotations[v1beta1.AnnotationFrpServerNameKey]
+	previous, _ := r.tunnels.Load(key)
+	state, _ := previous.(tunnelState)
+	if ready && !state.ready && state.everReady {
+		metrics.TunnelReconnectsTotal.WithLabelValues(instance.Namespace, instance.Name, serverName).Inc()
+	}
+	if state.ready {
+		metrics.ActiveTunnels.WithLabelValues(state.server).Dec()
+	}
+	if ready {
+		metrics.ActiveTunnels.WithLabelValues(serverName).Inc()
+	}
+	r.tunnels.Store(key, tunnelState{ready: ready, everReady: state.everReady || ready, server: serverName})
+	metrics.TunnelReady.WithLabelValues(instance.Namespace, instance.Name, serverName).Set(lo.Ternary[float64](ready, 1, 0))
+}
+
+// forgetTunnel removes the tunnel metrics of a service which is no longer exposed
+func (r *ServiceReconciler) forgetTunnel(instance *v1.Service) {
+	if previous, ok := r.tunnels.LoadAndDelete(client.ObjectKeyFromObject(instance)); ok && previous.(tunnelState).ready {
+		metrics.ActiveTunnels.WithLabelValues(previous.(tunnelState).server).Dec()
+	}
+	matchLabels := prometheus.Labels{metrics.LabelNamespace: instance.Namespace, metrics.LabelService: instance.Name}
+	metrics.TunnelReady.DeletePartialMatch(matchLabels)
+	metrics.TunnelReconnectsTotal.DeletePartialMatch(matchLabels)
+}
+
+// syncTunnelReadiness sets the tunnel readiness gate condition on the backend pods
+// selected by the service, the condition is only true once tunnelLive confirms the proxies run.
+func (r *ServiceReconciler) syncTunnelReadiness(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer, claimedPods []*v1.Pod) error {
+	logger := log.FromContext(ctx)
+	if len(instance.Spec.Selector) == 0 {
+		return nil
+	}
+	podList := &v1.PodList{}
+	opts := &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labels.SelectorFromSet(instance.Spec.Selector),
+	}
+	if err := r.List(ctx, podList, opts); err != nil {
+		logger.WithValues("namespace", instance.Namespace).Error(err, "unable get backend pod list")
+		return err
+	}
+	gated := lo.Filter(podList.Items, func(pod v1.Pod, _ int) bool { return controllerutils.HasTunnelReadinessGate(&pod) })
+	if len(gated) == 0 {
+		return nil
+	}
+	condition := v1.PodCondition{
+		Type:    v1.PodConditionType(v1beta1.PodConditionTunnelReady),
+		Status:  v1.ConditionFalse,
+		Reason:  v1beta1.ReasonTunnelNotReady,
+		Message: r.tunnelLive(ctx, instance, server, claimedPods),
+	}
+	if condition.Message == "" {
+		condition.Status = v1.ConditionTrue
+		condition.Reason = v1beta1.ReasonTunnelReady
+		condition.Message = "frp tunnel is live"
+	}
+	errsList := make([]error, 0)
+	for i := range gated {
+		pod := &gated[i]
+		current, _, found := lo.FindIndexOf(pod.Status.Conditions, func(c v1.PodCondition) bool {
+			return c.Type == condition.Type
+		})
+		if found && current.Status == condition.Status {
+			continue
+		}
+		condition.LastTransitionTime = metav1.Now()
+		pod.Status.Conditions = append(lo.Filter(pod.Status.Conditions, func(c v1.PodCondition, _ int) bool {
+			return c.Type != condition.Type
+		}), condition)
+		if err := r.Status().Update(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable update tunnel readiness condition", "podName", pod.GetName())
+			errsList = append(errsList, err)
+		}
+	}
+	return utilerrors.NewAggregate(errsList)
+}
+
+// tunnelLive checks the proxies of the service run on its FrpServer, it returns why the tunnel is not live or
+// an empty string. The proxies are looked up in the frps dashboard of spec.routeGC, the readiness of the frp
+// client pods is all there is to go by for the FrpServers without a dashboard, the inline servers and the
+// host port mode.
+func (r *ServiceReconciler) tunnelLive(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer, claimedPods []*v1.Pod) string {
+	if !lo.SomeBy(claimedPods, controllerutils.IsPodReady) {
+		return "frp client pod is not ready"
+	}
+	if server == nil || isInlineServer(server) || server.Spec.RouteGC.DashboardURL == "" {
+		return ""
+	}
+	names, err := registeredProxyNames(instance, server)
+	if err != nil {
+		return err.Error()
+	}
+	creds, err := frpclient.GetDashboardCredentials(ctx, r.Client, server)
+	if err != nil {
+		return err.Error()
+	}
+	proxyTypes := lo.Uniq(lo.Map(instance.Spec.Ports, func(port v1.ServicePort, _ int) string { return portProxyType(instance, port) }))
+	proxies, err := frpclient.ListProxiesOfTypes(ctx, server, creds, proxyTypes...)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable list proxies of frp server", "server", server.Name)
+		return fmt.Sprintf("unable list proxies of frpserver '%s'", server.Name)
+	}
+	online := lo.SliceToMap(lo.Filter(proxies, func(proxy frpclient.DashboardProxy, _ int) bool {
+		return proxy.Status == frpclient.DashboardProxyOnline
+	}), func(proxy frpclient.DashboardProxy) (string, bool) { return proxy.Name, true })
+	for _, name := range names {
+		if !online[name] {
+			return fmt.Sprintf("proxy '%s' is not running on frpserver '%s'", name, server.Name)
+		}
+	}
+	return ""
+}
+
+// registeredProxyNames returns the names the proxies of the service are registered with on the FrpServer, they're
+// prefixed with their frp user like frps reports them
+func registeredProxyNames(instance *v1.Service, server *v1beta1.FrpServer) ([]string, error) {
+	user, err := frpclient.ProxyUser(server, instance.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(instance.Spec.Ports))
+	for _, port := range instance.Spec.Ports {
+		name := proxyName(instance, port)
+		if user != "" {
+			name = user + "." + name
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// mapBackendPodToServices enqueue the LoadBalancer services selecting a backend pod with the tunnel readiness gate
+// and the exposed headless services selecting a backend pod, their proxies follow the pods
+func (r *ServiceReconciler) mapBackendPodToServices(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	pod, ok := obj.(*v1.Pod)
+	gated := ok && controllerutils.HasTunnelReadinessGate(pod)
+	if !gated && (!ok || !r.Options.ExposeHeadlessServices) {
+		return nil
+	}
+	serviceList := &v1.ServiceList{}
+	if err := r.List(ctx, serviceList, client.InNamespace(pod.Namespace)); err != nil {
+		logger.WithValues("namespace", pod.Namespace).Error(err, "unable get service list")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, svc := range serviceList.Items {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		if !(gated && svc.Spec.Type == v1.ServiceTypeLoadBalancer) && !(frpclient.IsHeadless(&svc) && r.isExposable(&svc) && isExposed(&svc)) {
+			continue
+		}
+		if labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&svc)})
+		}
+	}
+	return requests
 }
 
 func (r *ServiceReconciler) scheduleServer(ctx context.Context, instance *v1.Service) (*v1beta1.FrpServer, error) {
@@ -181,7 +795,23 @@ func (r *ServiceReconciler) scheduleServer(ctx context.Context, instance *v1.Ser
 	if len(instance.Annotations) == 0 {
 		return nil, fmt.Errorf("please set annotations.%s to assign frp server", v1beta1.AnnotationFrpServerNameKey)
 	}
+	if instance.Annotations[v1beta1.AnnotationInlineServerKey] != "" {
+		return r.scheduleInlineServer(ctx, instance)
+	}
+	if claimName := instance.Annotations[v1beta1.AnnotationFrpServerClaimNameKey]; claimName != "" {
+		return r.scheduleClaimedServer(ctx, instance, claimName)
+	}
+	if groupName := instance.Annotations[v1beta1.AnnotationServerGroupKey]; groupName != "" {
+		return r.scheduleGroupServer(ctx, instance, groupName)
+	}
 	serverName, ok := instance.Annotations[v1beta1.AnnotationFrpServerNameKey]
+	schedulerName := instance.Annotations[v1beta1.AnnotationSchedulerKey]
+	if _, selected := instance.Annotations[v1beta1.AnnotationServerSelectorKey]; selected {
+		schedulerName = util.EmptyOr(schedulerName, r.Options.CurrentScheduler())
+	}
+	if serverName == "" && schedulerName != "" {
+		return r.placeService(ctx, instance, schedulerName)
+	}
 	if !ok || serverName == "" {
 		return nil, fmt.Errorf("please set annotations.%s to assign frp server", v1beta1.AnnotationFrpServerNameKey)
 	}
@@ -194,6 +824,28 @@ func (r *ServiceReconciler) scheduleServer(ctx context.Context, instance *v1.Ser
 	return server, nil
 }
 
+// scheduleClaimedServer returns the FrpServer bound by the FrpServerClaim of the service namespace, the
+// metadata of the claim is merged into the client metadata of the returned FrpServer.
+func (r *ServiceReconciler) scheduleClaimedServer(ctx context.Context, instance *v1.Service, claimName string) (*v1beta1.FrpServer, error) {
+	logger := log.FromContext(ctx)
+	claimKey := client.ObjectKey{Namespace: instance.Namespace, Name: claimName}
+	claim := &v1beta1.FrpServerClaim{}
+	if err := r.Get(ctx, claimKey, claim); err != nil {
+		logger.WithValues("request", claimKey.String()).Error(err, "unable get v1beta1.FrpServerClaim by name")
+		return nil, err
+	}
+	if claim.Status.Phase != v1beta1.FrpServerClaimPhaseBound {
+		return nil, fmt.Errorf("frpserverclaim '%s' is not bound, got: %s", claimKey.String(), claim.Status.Reason)
+	}
+	server := &v1beta1.FrpServer{}
+	if err := r.Get(ctx, client.ObjectKey{Name: claim.Spec.ServerName}, server); err != nil {
+		logger.WithValues("request", claim.Spec.ServerName).Error(err, "unable get v1beta1.FrpServer by name")
+		return nil, err
+	}
+	server.Spec.Metadatas = lo.Assign(server.Spec.Metadatas, claim.Spec.Metadatas)
+	return server, nil
+}
+
 func (r *ServiceReconciler) getFrpServers(ctx context.Context, instance v1.Service) ([]*v1beta1.FrpServer, []*v1beta1.FrpServer, error) {
 	logger := log.FromContext(ctx)
 	serverList := &v1beta1.FrpServerList{}
@@ -214,11 +866,7 @@ func (r *ServiceReconciler) getFrpServers(ctx context.Context, instance v1.Servi
 }
 
 func (r *ServiceReconciler) claimPods(instance *v1.Service, pods []*v1.Pod) ([]*v1.Pod, error) {
-	selector := labels.SelectorFromSet(labels.Set{
-		v1beta1.LabelServiceNameKey:   instance.Name,
-		v1beta1.LabelControllerUidKey: string(instance.UID),
-	})
-	mgr, err := controllerutils.NewRefManager(r.Client, selector, instance, r.Scheme)
+	mgr, err := controllerutils.NewRefManager(r.Client, frplabels.OwnedBy(instance), instance, r.Scheme)
 	if err != nil {
 		return nil, err
 	}
@@ -239,8 +887,36 @@ func (r *ServiceReconciler) claimPods(instance *v1.Service, pods []*v1.Pod) ([]*
 
 // SetupWithManager set up the controller with the Manager.
 func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	// Parse the pod template once up front, the reconciles only deep copy it
+	if _, err := r.templates.NewPod(r.Options.CurrentPodTemplate()); err != nil {
+		return err
+	}
+	blder := ctrl.NewControllerManagedBy(mgr).
 		For(&v1.Service{}).
 		Owns(&v1.Pod{}).
-		Complete(r)
+		Owns(&v1.Secret{}).
+		Owns(&v1.ConfigMap{}).
+		Owns(&v1beta1.FrpProxy{}).
+		Watches(&v1beta1.FrpServerGroup{}, handler.EnqueueRequestsFromMapFunc(r.mapFrpServerGroupToServices)).
+		Watches(&v1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.mapBackendPodToServices)).
+		Watches(&v1beta1.FrpServer{}, handler.EnqueueRequestsFromMapFunc(r.mapFrpServerToServices),
+			builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, capabilitiesChanged)))
+	if r.managesDeployment() {
+		blder = blder.Owns(&appsv1.Deployment{}).
+			Watches(&v1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.mapClientPodToService),
+				builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+					pod, ok := obj.(*v1.Pod)
+					return ok && isReplicaSetPod(pod)
+				})))
+	}
+	if r.Outages != nil {
+		blder = blder.WatchesRawSource(r.Outages.Source(), &handler.EnqueueRequestForObject{})
+	}
+	if r.Reloads != nil {
+		blder = blder.WatchesRawSource(r.Reloads.Source(), &handler.EnqueueRequestForObject{})
+	}
+	if r.Options.RenderFrpcConfig {
+		mgr.GetWebhookServer().Register(frpcinit.Path, &frpcCredentialsHandler{r: r})
+	}
+	return blder.Complete(metrics.InstrumentReconciler(serviceControllerName, r, r.Options.Tracing))
 }
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/naming"
+	"github.com/samber/lo"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
+)
+
+// managesDeployment reports whether the frp client pods of the services run in owned Deployments
+func (r *ServiceReconciler) managesDeployment() bool {
+	return r.Options.ManagedWorkloadKind == config.WorkloadKindDeployment
+}
+
+// clientDeploymentName returns the name of the Deployment running the frp client pod of the service
+func clientDeploymentName(instance *v1.Service) string {
+	return naming.Join(defaultBaseName, instance.Name)
+}
+
+// isReplicaSetPod reports whether the pod is controlled by a ReplicaSet, i.e. it's run by a Deployment
+func isReplicaSetPod(pod *v1.Pod) bool {
+	ref := metav1.GetControllerOf(pod)
+	return ref != nil && ref.Kind == "ReplicaSet"
+}
+
+// syncDeployment applies the Deployment running the frp client pod generated for the service. The Deployment rolls
+// out a changed pod spec by replacing the pod without surge, so two frp clients never register the same proxies.
+// The pod template of the other services is kept until the canary validation completes, the duration to requeue
+// the service after is returned while it's held.
+func (r *ServiceReconciler) syncDeployment(ctx context.Context, instance *v1.Service, pod *v1.Pod) (time.Duration, error) {
+	pod.Spec.RestartPolicy = v1.RestartPolicyAlways
+	maxSurge, maxUnavailable := intstr.FromInt32(0), intstr.FromInt32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: instance.Namespace,
+			Name:      clientDeploymentName(instance),
+			Labels:    frplabels.ForService(instance),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: lo.ToPtr[int32](1),
+			Selector: &metav1.LabelSelector{MatchLabels: frplabels.ForService(instance)},
+			Strategy: appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable},
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels, Annotations: pod.Annotations},
+				Spec:       pod.Spec,
+			},
+		},
+	}
+	var held bool
+	err := r.applyOwned(ctx, instance, deployment, func(existing client.Object) bool {
+		current := existing.(*appsv1.Deployment)
+		if equality.Semantic.DeepDerivative(deployment.Spec, current.Spec) {
+			return false
+		}
+		hash := current.Spec.Template.Labels[frplabels.PodTemplateHash]
+		if r.Options.CanaryService != "" && hash != pod.Labels[frplabels.PodTemplateHash] && r.canaryHolds(instance) {
+			held = true
+			return false
+		}
+		current.Spec = deployment.Spec
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable apply frp client deployment '%s/%s', got: %w", deployment.Namespace, deployment.Name, err)
+	}
+	return lo.Ternary(held, canaryRequeueInterval, 0), nil
+}
+
+// deleteClientDeployment deletes the Deployment running the frp client pod of the service
+func deleteClientDeployment(ctx context.Context, c client.Client, instance *v1.Service) error {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: instance.Namespace, Name: clientDeploymentName(instance)}}
+	if err := c.Delete(ctx, deployment); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable delete frp client deployment '%s/%s', got: %w", deployment.Namespace, deployment.Name, err)
+	}
+	return nil
+}
+
+// mapClientPodToService enqueue the service of a frp client pod run by a Deployment, the pod isn't owned by the
+// service so its restarts and readiness changes are not enqueued otherwise
+func (r *ServiceReconciler) mapClientPodToService(_ context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[frplabels.ServiceName]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: obj.GetNamespace(), Name: name}}}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"math"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// drainPollInterval is the interval to check the terminating frp client pods of a drained service again, the
+// deletion of a pod requeues the service before it elapses
+const drainPollInterval = 5 * time.Second
+
+// drainPods deletes the frp client pods of the service with the drain timeout as their grace period, the kubelet sends
+// frpc SIGTERM, which closes its proxies so frps sends the pods no new connections, and kills the pods once the grace
+// period elapsed. The duration to requeue the service after is returned until no pod of the service is terminating.
+func (r *ServiceReconciler) drainPods(ctx context.Context, instance *v1.Service, claimedPods, inactivePods []*v1.Pod) (time.Duration, error) {
+	logger := log.FromContext(ctx)
+	if r.Options.DrainTimeout <= 0 {
+		return 0, nil
+	}
+	gracePeriod := int64(math.Ceil(r.Options.DrainTimeout.Seconds()))
+	for _, pod := range claimedPods {
+		if err := r.Delete(ctx, pod, client.GracePeriodSeconds(gracePeriod)); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable delete draining frp client pod", "podName", pod.GetName())
+			return 0, err
+		}
+	}
+	if len(claimedPods) != 0 {
+		r.Recorder.Event(instance, v1.EventTypeNormal, v1beta1.ReasonTunnelDraining,
+			fmt.Sprintf("Draining %d frp client pods for up to %s before the tunnel is deleted", len(claimedPods), r.Options.DrainTimeout))
+	}
+	if len(claimedPods) == 0 && !lo.SomeBy(inactivePods, isTerminating) {
+		return 0, nil
+	}
+	return drainPollInterval, nil
+}
+
+// isTerminating reports whether the pod is being deleted
+func isTerminating(pod *v1.Pod) bool {
+	return pod.DeletionTimestamp != nil
+}
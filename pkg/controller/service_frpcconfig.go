@@ -0,0 +1,352 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config/builder"
+	"github.com/frp-sigs/frp-provisioner/pkg/frpcinit"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/naming"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// frpcConfig is the frpc config file rendered for a service, the common config of its FrpServer and a proxy per port
+type frpcConfig struct {
+	configv1.ClientCommonConfig
+	Proxies []configv1.ProxyConfigurer `json:"proxies,omitempty"`
+}
+
+// frpcConfigName returns the name of the ConfigMap holding the rendered frpc config of the service
+func frpcConfigName(instance *v1.Service) string {
+	return naming.Join(instance.Name, "frpc-config")
+}
+
+// frpcCredentialsName returns the name of the Secret which held the plaintext credentials of the rendered frpc
+// config of the service before they were served to the init container, it's deleted once the config is synced
+func frpcCredentialsName(instance *v1.Service) string {
+	return naming.Join(instance.Name, "frpc-credentials")
+}
+
+// isInlineServer reports whether the FrpServer is the inline server of a service rather than a FrpServer object
+func isInlineServer(server *v1beta1.FrpServer) bool {
+	_, ok := server.Annotations[v1beta1.AnnotationInlineServerKey]
+	return ok
+}
+
+// renderFrpcConfig renders the frpc config of the service scheduled on the FrpServer, the auth token, the oidc
+// client secret and the stcp/xtcp/sudp secret key are env templates filled in by the init container, the token of
+// an inline server and the http credentials are read from the env vars of the frp client containers. The transport
+// tls of the FrpServer is enabled with the files the init container writes next to the config unless the namespace
+// client certificate is used. The proxies are named by proxyName and connect to the cluster DNS name of the service.
+func (r *ServiceReconciler) renderFrpcConfig(instance *v1.Service, server *v1beta1.FrpServer, creds *frpcinit.Credentials) ([]byte, error) {
+	user, err := frpclient.ProxyUser(server, instance.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &frpcConfig{ClientCommonConfig: frpclient.ClientCommonConfig(server, nil)}
+	cfg.User = user
+	cfg.Transport.Protocol = string(util.EmptyOr(server.Status.ActiveProtocol, server.Spec.Transport.Protocol))
+	if server.Spec.Auth.Method == v1beta1.FrpServerAuthMethodOIDC {
+		cfg.Auth.OIDC.ClientSecret = frpcinit.EnvTemplate(v1beta1.OIDCClientSecretEnv)
+	} else {
+		cfg.Auth.Token = frpcinit.EnvTemplate(v1beta1.InlineServerTokenEnv)
+	}
+	if r.Options.ClientCertificateTemplate != "" {
+		cfg.Transport.TLS.Enable = lo.ToPtr(true)
+		cfg.Transport.TLS.CertFile = path.Join(v1beta1.ClientTLSMountPath, v1beta1.DefaultCertFileName)
+		cfg.Transport.TLS.KeyFile = path.Join(v1beta1.ClientTLSMountPath, v1beta1.DefaultKeyFileName)
+		cfg.Transport.TLS.TrustedCaFile = path.Join(v1beta1.ClientTLSMountPath, v1beta1.DefaultCaFileName)
+	} else if creds.TLS != nil {
+		cfg.Transport.TLS.Enable = lo.ToPtr(true)
+		cfg.Transport.TLS.CertFile = path.Join(v1beta1.FrpcConfigMountPath, v1beta1.DefaultCertFileName)
+		cfg.Transport.TLS.KeyFile = path.Join(v1beta1.FrpcConfigMountPath, v1beta1.DefaultKeyFileName)
+		if _, ok := creds.TLS[v1beta1.DefaultCaFileName]; ok {
+			cfg.Transport.TLS.TrustedCaFile = path.Join(v1beta1.FrpcConfigMountPath, v1beta1.DefaultCaFileName)
+		}
+	}
+	localIP := fmt.Sprintf("%s.%s.svc", instance.Name, instance.Namespace)
+	subdomain := instance.Annotations[v1beta1.AnnotationEffectiveSubdomainKey]
+	_, httpAuth := instance.Annotations[v1beta1.AnnotationHTTPAuthSecretKey]
+	dependsOn, err := frpclient.ProxyDependencies(instance.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	waves, err := frpclient.StartOrder(lo.Map(instance.Spec.Ports, func(port v1.ServicePort, _ int) string { return portName(port) }), dependsOn)
+	if err != nil {
+		return nil, err
+	}
+	// frpc registers the proxies in the order of its config, a proxy is registered after its dependencies. The
+	// dependencies refer to the ports of the service by name.
+	wave := make(map[string]int)
+	for i, names := range waves {
+		for _, name := range names {
+			wave[name] = i
+		}
+	}
+	ports := append([]v1.ServicePort{}, instance.Spec.Ports...)
+	sort.SliceStable(ports, func(i, j int) bool { return wave[portName(ports[i])] < wave[portName(ports[j])] })
+	for _, port := range ports {
+		name := proxyName(instance, port)
+		proxyType := portProxyType(instance, port)
+		var b *builder.ProxyBuilder
+		if proxyType == v1beta1.ProxyTypeTCPMux {
+			b = builder.NewTCPMuxProxy(name)
+		} else {
+			b = builder.NewProxy(configv1.ProxyType(proxyType), name)
+		}
+		b.LocalIP(localIP).LocalPort(int(port.Port)).
+			Metadata(v1beta1.ProxyMetadataOwnerKey, routeOwner(routeOwnerService, client.ObjectKeyFromObject(instance)))
+		switch proxyType {
+		case v1beta1.ProxyTypeTCP, v1beta1.ProxyTypeUDP:
+			remotePort, err := portRemotePort(instance, port)
+			if err != nil {
+				return nil, err
+			}
+			b.RemotePort(remotePort)
+		case v1beta1.ProxyTypeHTTP, v1beta1.ProxyTypeHTTPS, v1beta1.ProxyTypeTCPMux:
+			if subdomain != "" {
+				b.SubDomain(subdomain)
+			}
+			if httpAuth && proxyType != v1beta1.ProxyTypeHTTPS {
+				b.HTTPBasicAuth(frpcinit.EnvTemplate(v1beta1.HTTPUserEnv), frpcinit.EnvTemplate(v1beta1.HTTPPasswordEnv))
+			}
+		case v1beta1.ProxyTypeSTCP, v1beta1.ProxyTypeXTCP, v1beta1.ProxyTypeSUDP:
+			b.SecretKey(frpcinit.EnvTemplate(v1beta1.SecretKeyEnv))
+		}
+		proxy, err := b.Build()
+		if err != nil {
+			return nil, err
+		}
+		if err := frpclient.ApplyProxyDefaults(proxy.GetBaseConfig(), server, instance.Annotations); err != nil {
+			return nil, err
+		}
+		cfg.Proxies = append(cfg.Proxies, proxy)
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable marshal frpc config, got: %w", err)
+	}
+	return data, nil
+}
+
+// portName returns the name of the service port, the unnamed port of a single port service is named after its number
+func portName(port v1.ServicePort) string {
+	return util.EmptyOr(port.Name, strconv.Itoa(int(port.Port)))
+}
+
+// proxyName returns the name of the proxy of the service port before it's prefixed with the frp user, it's
+// qualified with the namespace and the name of the service like frpProxyName so the proxies of the services
+// sharing a FrpServer and a user don't collide.
+func proxyName(instance *v1.Service, port v1.ServicePort) string {
+	return instance.Namespace + "." + instance.Name + "." + portName(port)
+}
+
+// portRemotePort returns the remote port of the tcp or udp proxy of the service port, the port itself unless
+// it's overridden by the remote port annotation of the port
+func portRemotePort(instance *v1.Service, port v1.ServicePort) (int, error) {
+	value, ok := instance.Annotations[v1beta1.AnnotationPortPrefix+port.Name+"."+v1beta1.PortAnnotationRemotePort]
+	if !ok {
+		return int(port.Port), nil
+	}
+	remotePort, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid remote port '%s' of port '%s', got: %w", value, portName(port), err)
+	}
+	return remotePort, nil
+}
+
+// syncFrpcConfig stores the rendered frpc config of the service in its ConfigMap with the CA bundle verifying the
+// manager webhook server the init container fetches the credentials from. The FrpServer the config was rendered
+// for is recorded on the ConfigMap unless it's an inline server whose token is passed by applyInlineServerToken. It
+// returns the hash of the config and its credentials the frp client pods are expected to record, so they're
+// restarted once the credentials change.
+func (r *ServiceReconciler) syncFrpcConfig(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer, data []byte, creds *frpcinit.Credentials) (string, error) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        frpcConfigName(instance),
+			Namespace:   instance.Namespace,
+			Labels:      map[string]string{frplabels.ServiceName: instance.Name},
+			Annotations: map[string]string{},
+		},
+		Data: map[string]string{v1beta1.FrpcConfigFileName: string(data)},
+	}
+	if !isInlineServer(server) {
+		configMap.Annotations[v1beta1.AnnotationFrpServerNameKey] = server.Name
+	}
+	credsData, err := json.Marshal(creds)
+	if err != nil {
+		return "", fmt.Errorf("unable marshal frpc credentials, got: %w", err)
+	}
+	if needsFrpcInit(instance, server) {
+		ca, err := r.webhookCA()
+		if err != nil {
+			return "", err
+		}
+		configMap.Data[frpcinit.CAFileName] = string(ca)
+	}
+	if err := r.applyOwned(ctx, instance, configMap, func(existing client.Object) bool {
+		current := existing.(*v1.ConfigMap)
+		if equality.Semantic.DeepEqual(current.Data, configMap.Data) &&
+			current.Annotations[v1beta1.AnnotationFrpServerNameKey] == configMap.Annotations[v1beta1.AnnotationFrpServerNameKey] {
+			return false
+		}
+		current.Data = configMap.Data
+		if serverName, ok := configMap.Annotations[v1beta1.AnnotationFrpServerNameKey]; ok {
+			metav1.SetMetaDataAnnotation(&current.ObjectMeta, v1beta1.AnnotationFrpServerNameKey, serverName)
+		} else {
+			delete(current.Annotations, v1beta1.AnnotationFrpServerNameKey)
+		}
+		return true
+	}); err != nil {
+		return "", err
+	}
+	// the credentials were stored in plaintext before the init container fetched them
+	legacy := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: frpcCredentialsName(instance), Namespace: instance.Namespace}}
+	if err := r.Delete(ctx, legacy); err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("unable delete frpc credentials secret, got: %w", err)
+	}
+	return templateHash(string(data) + string(credsData)), nil
+}
+
+// webhookCA returns the CA bundle verifying the manager webhook server, the ca.crt of its cert dir, e.g. written
+// by cert-manager, else its serving certificate
+func (r *ServiceReconciler) webhookCA() ([]byte, error) {
+	for _, name := range []string{frpcinit.CAFileName, r.Options.WebhookCertName} {
+		data, err := os.ReadFile(filepath.Join(r.Options.WebhookCertDir, name))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unable read webhook ca bundle, got: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("no webhook ca bundle found in '%s'", r.Options.WebhookCertDir)
+}
+
+// applyOwned creates the object owned by the service, or updates the existing one when update reports a change
+func (r *ServiceReconciler) applyOwned(ctx context.Context, instance *v1.Service, obj client.Object, update func(existing client.Object) bool) error {
+	key := client.ObjectKeyFromObject(obj)
+	existing := obj.DeepCopyObject().(client.Object)
+	err := r.Get(ctx, key, existing)
+	if errors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(instance, obj, r.Scheme); err != nil {
+			return fmt.Errorf("can't set '%s' owner reference: %w", key.String(), err)
+		}
+		return r.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+	if !update(existing) {
+		return nil
+	}
+	return r.Update(ctx, existing)
+}
+
+// applyFrpcConfig mounts the rendered frpc config of the service in the frp client containers of the pod and records
+// its hash on the pod. When it reads credentials the init container writes the config holding them into a memory
+// volume, authenticated to the manager by a service account token bound to the pod.
+func (r *ServiceReconciler) applyFrpcConfig(pod *v1.Pod, owner *v1.Service, server *v1beta1.FrpServer, hash string) {
+	configMap := v1.VolumeSource{
+		ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: frpcConfigName(owner)}},
+	}
+	if !needsFrpcInit(owner, server) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{Name: v1beta1.FrpcConfigVolumeName, VolumeSource: configMap})
+	} else {
+		pod.Spec.Volumes = append(pod.Spec.Volumes,
+			v1.Volume{Name: frpcinit.TemplateVolumeName, VolumeSource: configMap},
+			v1.Volume{Name: v1beta1.FrpcConfigVolumeName, VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory},
+			}},
+			v1.Volume{Name: frpcinit.TokenVolumeName, VolumeSource: v1.VolumeSource{
+				Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{{
+					ServiceAccountToken: &v1.ServiceAccountTokenProjection{
+						Audience:          frpcinit.Audience,
+						ExpirationSeconds: lo.ToPtr(int64(frpcInitTokenExpiration)),
+						Path:              frpcinit.TokenFileName,
+					},
+				}}},
+			}},
+		)
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, v1.Container{
+			Name:  frpcinit.ContainerName,
+			Image: r.Options.FrpcInitImage,
+			Args:  []string{"frpc-init", "--url=" + r.Options.FrpcCredentialsURL},
+			VolumeMounts: []v1.VolumeMount{
+				{Name: frpcinit.TemplateVolumeName, MountPath: frpcinit.TemplateMountPath, ReadOnly: true},
+				{Name: frpcinit.TokenVolumeName, MountPath: frpcinit.TokenMountPath, ReadOnly: true},
+				{Name: v1beta1.FrpcConfigVolumeName, MountPath: v1beta1.FrpcConfigMountPath},
+			},
+		})
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+			Name:      v1beta1.FrpcConfigVolumeName,
+			MountPath: v1beta1.FrpcConfigMountPath,
+			ReadOnly:  true,
+		})
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[v1beta1.AnnotationFrpcConfigHashKey] = hash
+}
+
+// rolloutFrpcConfig restarts the claimed frp client pods of the service whose recorded frpc config hash doesn't
+// match the rendered config, one at a time across all services like syncServerConfig. The remaining pods are
+// returned with the duration to requeue the service after when a restart is pending.
+func (r *ServiceReconciler) rolloutFrpcConfig(ctx context.Context, instance *v1.Service, hash string, claimedPods []*v1.Pod) ([]*v1.Pod, time.Duration, error) {
+	logger := log.FromContext(ctx)
+	stale := lo.Filter(claimedPods, func(pod *v1.Pod, _ int) bool { return pod.Annotations[v1beta1.AnnotationFrpcConfigHashKey] != hash })
+	// the Deployment rolls out the hash recorded in its pod template itself
+	if len(stale) == 0 || r.managesDeployment() {
+		return claimedPods, 0, nil
+	}
+	if wait := r.rollout.next(r.Options.ServerRolloutInterval); wait > 0 {
+		return claimedPods, wait, nil
+	}
+	pod := stale[0]
+	if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "unable delete frp client pod with stale frpc config", "podName", pod.GetName())
+		return nil, 0, err
+	}
+	logger.Info("restarted frp client pod to apply frpc config", "podName", pod.GetName())
+	r.Recorder.Eventf(instance, v1.EventTypeNormal, v1beta1.ReasonFrpcConfigChanged,
+		"Restarted frp client pod %s to apply the changed frpc config", pod.Name)
+	return lo.Without(claimedPods, pod), lo.Ternary(len(stale) > 1, r.Options.ServerRolloutInterval, 0), nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/frpcinit"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+const (
+	// serviceAccountUsernamePrefix prefixes the username of the service account tokens
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+	// podNameExtraKey and podUIDExtraKey are the extra user info the pod bound service account tokens carry
+	podNameExtraKey = "authentication.kubernetes.io/pod-name"
+	podUIDExtraKey  = "authentication.kubernetes.io/pod-uid"
+	// frpcInitTokenExpiration is the lifetime of the projected token of the init container, the minimum allowed
+	frpcInitTokenExpiration = 600
+)
+
+// frpcCredentialsHandler serves the credentials of the rendered frpc config to the init container of the frp
+// client pods, so neither the FrpServer credentials nor the decrypted secret key are stored in a Secret. The pod
+// authenticates with a projected service account token bound to it.
+type frpcCredentialsHandler struct {
+	r *ServiceReconciler
+}
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+
+func (h *frpcCredentialsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	logger := log.Log.WithName("frpc-credentials")
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	pod, err := h.r.reviewFrpcInitToken(ctx, token)
+	if err != nil {
+		logger.Info("rejected frpc credentials request", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	creds, err := h.r.podFrpcCredentials(ctx, pod)
+	if err != nil {
+		logger.Error(err, "unable resolve frpc credentials", "namespace", pod.Namespace, "podName", pod.Name)
+		http.Error(w, "unable resolve frpc credentials", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(creds); err != nil {
+		logger.Error(err, "unable write frpc credentials", "namespace", pod.Namespace, "podName", pod.Name)
+	}
+}
+
+// reviewFrpcInitToken returns the frp client pod the service account token is bound to, the token must be issued
+// for the frpc-init audience and the pod must be controlled by its service or by a ReplicaSet of its Deployment.
+func (r *ServiceReconciler) reviewFrpcInitToken(ctx context.Context, token string) (*v1.Pod, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: []string{frpcinit.Audience}},
+	}
+	if err := r.Create(ctx, review); err != nil {
+		return nil, fmt.Errorf("unable review token, got: %w", err)
+	}
+	if !review.Status.Authenticated || !lo.Contains(review.Status.Audiences, frpcinit.Audience) {
+		return nil, fmt.Errorf("token is not authenticated, got: %s", review.Status.Error)
+	}
+	namespace, _, ok := strings.Cut(strings.TrimPrefix(review.Status.User.Username, serviceAccountUsernamePrefix), ":")
+	podName, podUID := review.Status.User.Extra[podNameExtraKey], review.Status.User.Extra[podUIDExtraKey]
+	if !ok || !strings.HasPrefix(review.Status.User.Username, serviceAccountUsernamePrefix) || len(podName) != 1 || len(podUID) != 1 {
+		return nil, fmt.Errorf("token is not bound to a pod")
+	}
+	pod := &v1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: podName[0]}, pod); err != nil {
+		return nil, fmt.Errorf("unable get pod '%s/%s', got: %w", namespace, podName[0], err)
+	}
+	if string(pod.UID) != podUID[0] {
+		return nil, fmt.Errorf("pod '%s/%s' was replaced", namespace, podName[0])
+	}
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || (string(ref.UID) != pod.Labels[frplabels.ControllerUID] && !isReplicaSetPod(pod)) {
+		return nil, fmt.Errorf("pod '%s/%s' is not a frp client pod", namespace, podName[0])
+	}
+	return pod, nil
+}
+
+// podFrpcCredentials returns the credentials of the rendered frpc config of the service running the pod, the
+// FrpServer it was rendered for is recorded on the frpc config ConfigMap.
+func (r *ServiceReconciler) podFrpcCredentials(ctx context.Context, pod *v1.Pod) (*frpcinit.Credentials, error) {
+	instance := &v1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: pod.Labels[frplabels.ServiceName]}, instance); err != nil {
+		return nil, fmt.Errorf("unable get service of pod, got: %w", err)
+	}
+	if string(instance.UID) != pod.Labels[frplabels.ControllerUID] {
+		return nil, fmt.Errorf("service '%s/%s' was replaced", instance.Namespace, instance.Name)
+	}
+	configMap := &v1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: frpcConfigName(instance)}, configMap); err != nil {
+		return nil, fmt.Errorf("unable get frpc config of service, got: %w", err)
+	}
+	var server *v1beta1.FrpServer
+	if serverName := configMap.Annotations[v1beta1.AnnotationFrpServerNameKey]; serverName != "" {
+		server = &v1beta1.FrpServer{}
+		if err := r.Get(ctx, client.ObjectKey{Name: serverName}, server); err != nil {
+			return nil, fmt.Errorf("unable get frp server '%s', got: %w", serverName, err)
+		}
+	}
+	return r.frpcCredentials(ctx, instance, server)
+}
+
+// frpcCredentials returns the credentials the rendered frpc config of the service reads, the auth token, the oidc
+// client secret and the transport tls material of the FrpServer resolved from its Secrets or Vault and the
+// decrypted secret key of a stcp/xtcp/sudp service. The FrpServer is nil or an inline server when the service
+// is exposed through an inline server, its token is passed by applyInlineServerToken.
+func (r *ServiceReconciler) frpcCredentials(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer) (*frpcinit.Credentials, error) {
+	creds := &frpcinit.Credentials{}
+	if server != nil && !isInlineServer(server) {
+		resolved, err := r.Credentials.Resolve(ctx, r.Client, server)
+		if err != nil {
+			return nil, fmt.Errorf("unable resolve credentials of frp server '%s', got: %w", server.Name, err)
+		}
+		common := frpclient.ClientCommonConfig(server, resolved)
+		creds.Token, creds.OIDCClientSecret = common.Auth.Token, common.Auth.OIDC.ClientSecret
+		tlsData, err := frpclient.TransportTLSData(ctx, r.Client, server, resolved)
+		if err != nil {
+			return nil, err
+		}
+		if tlsData != nil {
+			creds.TLS = make(map[string][]byte)
+			for _, key := range []string{v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName} {
+				if _, ok := tlsData[key]; !ok {
+					return nil, fmt.Errorf("file '%s' not found on transport tls data of frp server '%s'", key, server.Name)
+				}
+				creds.TLS[key] = tlsData[key]
+			}
+			if caData, ok := tlsData[v1beta1.DefaultCaFileName]; ok {
+				// frpc trusts only the certs of its trusted ca file, the system trust store of the manager is merged in
+				if creds.TLS[v1beta1.DefaultCaFileName], err = frpclient.TrustedCABundle(server, caData); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if secretKeyed(instance) {
+		secretKey, err := r.serviceSecretKey(ctx, instance)
+		if err != nil {
+			return nil, err
+		}
+		creds.SecretKey = string(secretKey)
+	}
+	return creds, nil
+}
+
+// needsFrpcInit reports whether the rendered frpc config of the service reads credentials served to the init
+// container, the token of an inline server is passed by applyInlineServerToken.
+func needsFrpcInit(instance *v1.Service, server *v1beta1.FrpServer) bool {
+	return !isInlineServer(server) || secretKeyed(instance)
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/fieldindex"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/naming"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strconv"
+	"strings"
+)
+
+// groupProxyTypes are the proxy types fanned out to the members of a FrpServerGroup, the other proxies are only
+// registered on the member the service is placed on
+var groupProxyTypes = []string{v1beta1.ProxyTypeTCP, v1beta1.ProxyTypeUDP, v1beta1.ProxyTypeHTTP, v1beta1.ProxyTypeHTTPS}
+
+// scheduleGroupServer places the service on a healthy member of the FrpServerGroup. The member the service is
+// placed on is kept while it's healthy, otherwise the service moves to the first healthy member in the order of
+// spec.servers. The placement is recorded in annotations.frp-server-name like the placement of a scheduler.
+func (r *ServiceReconciler) scheduleGroupServer(ctx context.Context, instance *v1.Service, groupName string) (*v1beta1.FrpServer, error) {
+	logger := log.FromContext(ctx)
+	group := &v1beta1.FrpServerGroup{}
+	if err := r.Get(ctx, client.ObjectKey{Name: groupName}, group); err != nil {
+		logger.WithValues("request", groupName).Error(err, "unable get v1beta1.FrpServerGroup by name")
+		return nil, err
+	}
+	members, err := groupMembers(ctx, r.Client, group)
+	if err != nil {
+		return nil, err
+	}
+	current, placed := members[instance.Annotations[v1beta1.AnnotationFrpServerNameKey]]
+	if placed && controllerutils.IsFrpServerActive(current) {
+		return current, nil
+	}
+	name, found := lo.Find(group.Spec.Servers, func(name string) bool {
+		server, ok := members[name]
+		return ok && controllerutils.IsFrpServerActive(server)
+	})
+	if !found {
+		if placed {
+			// the frp client keeps retrying the login to the member until another member turns healthy
+			return current, nil
+		}
+		err := fmt.Errorf("frpservergroup '%s' has no healthy member", groupName)
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonSchedulingFailed, err.Error())
+		return nil, err
+	}
+	server := members[name]
+	patch := client.MergeFrom(instance.DeepCopy())
+	instance.Annotations[v1beta1.AnnotationFrpServerNameKey] = server.Name
+	if err := r.Patch(ctx, instance, patch); err != nil {
+		return nil, fmt.Errorf("unable record frp server '%s' of frpservergroup '%s', got: %w", server.Name, groupName, err)
+	}
+	logger.Info("placed service on frp server group member", "server", server.Name, "group", groupName)
+	r.Recorder.Eventf(instance, v1.EventTypeNormal, v1beta1.ReasonScheduled, "Placed on frp server %s of frpservergroup %s", server.Name, groupName)
+	return server, nil
+}
+
+// groupProxyName returns the name of the FrpProxy registering the proxy of the service port on a group member
+func groupProxyName(instance *v1.Service, serverName string, port v1.ServicePort) string {
+	return naming.Join(instance.Name, serverName, strings.ToLower(util.EmptyOr(port.Name, strconv.Itoa(int(port.Port)))))
+}
+
+// syncGroupProxies fans the proxies of the service out to the healthy members of its FrpServerGroup besides the
+// member it's placed on, when the group policy is FanOut. Every proxy is registered by a FrpProxy owned by the
+// service, the FrpProxy objects of the members which are no longer healthy are deleted.
+func (r *ServiceReconciler) syncGroupProxies(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer) error {
+	desired := make([]*v1beta1.FrpProxy, 0)
+	if groupName := instance.Annotations[v1beta1.AnnotationServerGroupKey]; groupName != "" {
+		group := &v1beta1.FrpServerGroup{}
+		if err := r.Get(ctx, client.ObjectKey{Name: groupName}, group); err != nil && !errors.IsNotFound(err) {
+			return err
+		} else if err == nil && group.Spec.Policy == v1beta1.FrpServerGroupPolicyFanOut {
+			members, err := groupMembers(ctx, r.Client, group)
+			if err != nil {
+				return err
+			}
+			for _, name := range lo.Uniq(group.Spec.Servers) {
+				member, ok := members[name]
+				if !ok || name == server.Name || !controllerutils.IsFrpServerActive(member) {
+					continue
+				}
+				proxies, err := groupProxies(instance, name)
+				if err != nil {
+					return err
+				}
+				desired = append(desired, proxies...)
+			}
+		}
+	}
+	for _, obj := range desired {
+		spec, annotations := obj.Spec, obj.Annotations
+		if err := r.applyOwned(ctx, instance, obj, func(existing client.Object) bool {
+			current := existing.(*v1beta1.FrpProxy)
+			if equality.Semantic.DeepEqual(current.Spec, spec) && equality.Semantic.DeepEqual(current.Annotations, annotations) {
+				return false
+			}
+			current.Spec, current.Annotations = spec, annotations
+			return true
+		}); err != nil {
+			return fmt.Errorf("unable apply frpproxy '%s', got: %w", obj.Name, err)
+		}
+	}
+	return r.deleteGroupProxies(ctx, instance, lo.Map(desired, func(obj *v1beta1.FrpProxy, _ int) string { return obj.Name }))
+}
+
+// groupProxies returns the FrpProxy objects registering the proxies of the service on the group member, they carry
+// the proxy defaults annotations and the http auth Secret of the service like the proxies of its frp client pods,
+// and its source ranges which are enforced by the frp client of the manager.
+func groupProxies(instance *v1.Service, serverName string) ([]*v1beta1.FrpProxy, error) {
+	proxies := make([]*v1beta1.FrpProxy, 0, len(instance.Spec.Ports))
+	annotations := lo.PickByKeys(instance.Annotations, append([]string{v1beta1.AnnotationSourceRangesKey}, frpclient.ProxyDefaultsAnnotations...))
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	for _, port := range instance.Spec.Ports {
+		proxyType := portProxyType(instance, port)
+		if !lo.Contains(groupProxyTypes, proxyType) {
+			continue
+		}
+		obj := &v1beta1.FrpProxy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        groupProxyName(instance, serverName, port),
+				Namespace:   instance.Namespace,
+				Labels:      map[string]string{frplabels.ServiceName: instance.Name},
+				Annotations: annotations,
+			},
+			Spec: v1beta1.FrpProxySpec{
+				ServerName: serverName,
+				Type:       proxyType,
+				Backend:    v1beta1.FrpProxyBackend{ServiceName: instance.Name, Port: port.Port},
+			},
+		}
+		switch proxyType {
+		case v1beta1.ProxyTypeTCP, v1beta1.ProxyTypeUDP:
+			remotePort, err := portRemotePort(instance, port)
+			if err != nil {
+				return nil, err
+			}
+			obj.Spec.RemotePort = remotePort
+		default:
+			obj.Spec.Subdomain = instance.Annotations[v1beta1.AnnotationEffectiveSubdomainKey]
+		}
+		if name, ok := instance.Annotations[v1beta1.AnnotationHTTPAuthSecretKey]; ok && proxyType == v1beta1.ProxyTypeHTTP {
+			obj.Spec.HTTPAuthSecretRef = &v1.LocalObjectReference{Name: name}
+		}
+		proxies = append(proxies, obj)
+	}
+	return proxies, nil
+}
+
+// deleteGroupProxies deletes the FrpProxy objects of the service registering its proxies on the group members,
+// except the ones named in keep
+func (r *ServiceReconciler) deleteGroupProxies(ctx context.Context, instance *v1.Service, keep []string) error {
+	proxyList := &v1beta1.FrpProxyList{}
+	if err := r.List(ctx, proxyList, client.InNamespace(instance.Namespace), client.MatchingLabels{frplabels.ServiceName: instance.Name}); err != nil {
+		return fmt.Errorf("unable get frpproxy list of service, got: %w", err)
+	}
+	errsList := make([]error, 0)
+	for i := range proxyList.Items {
+		obj := &proxyList.Items[i]
+		if lo.Contains(keep, obj.Name) || !metav1.IsControlledBy(obj, instance) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			errsList = append(errsList, fmt.Errorf("unable delete frpproxy '%s', got: %w", obj.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errsList)
+}
+
+// mapFrpServerGroupToServices enqueue the services placed on the members of a FrpServerGroup, they're placed
+// again once the health of the members changes
+func (r *ServiceReconciler) mapFrpServerGroupToServices(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	serviceList := &v1.ServiceList{}
+	if err := r.List(ctx, serviceList, client.MatchingFields{fieldindex.IndexNameForServiceGroup: obj.GetName()}); err != nil {
+		logger.Error(err, "unable get service list of frpservergroup", "group", obj.GetName())
+		return nil
+	}
+	return lo.Map(serviceList.Items, func(item v1.Service, _ int) reconcile.Request {
+		return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)}
+	})
+}
@@ -0,0 +1,378 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newGroupReconciler returns a ServiceReconciler backed by a fake client holding the objects
+func newGroupReconciler(objs ...client.Object) *ServiceReconciler {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1beta1.AddToScheme(scheme))
+	return &ServiceReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(16),
+	}
+}
+
+func groupMember(name string, phase v1beta1.FrpServerPhase) *v1beta1.FrpServer {
+	return &v1beta1.FrpServer{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1beta1.FrpServerStatus{Phase: phase},
+	}
+}
+
+func serverGroup(policy v1beta1.FrpServerGroupPolicy, servers ...string) *v1beta1.FrpServerGroup {
+	return &v1beta1.FrpServerGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group"},
+		Spec:       v1beta1.FrpServerGroupSpec{Servers: servers, Policy: policy},
+	}
+}
+
+// groupService returns the service of the FrpServerGroup named "group" placed on the member named placed
+func groupService(placed string, ports ...v1.ServicePort) *v1.Service {
+	instance := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "web-uid",
+			Annotations: map[string]string{v1beta1.AnnotationServerGroupKey: "group"},
+		},
+		Spec: v1.ServiceSpec{Ports: ports},
+	}
+	if placed != "" {
+		instance.Annotations[v1beta1.AnnotationFrpServerNameKey] = placed
+	}
+	return instance
+}
+
+func TestScheduleGroupServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		placed  string
+		members []*v1beta1.FrpServer
+		servers []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "first healthy member in the order of spec.servers",
+			members: []*v1beta1.FrpServer{groupMember("a", v1beta1.FrpServerPhaseUnhealthy), groupMember("b", v1beta1.FrpServerPhaseHealthy), groupMember("c", v1beta1.FrpServerPhaseHealthy)},
+			servers: []string{"a", "c", "b"},
+			want:    "c",
+		},
+		{
+			name:    "healthy member the service is placed on is kept",
+			placed:  "b",
+			members: []*v1beta1.FrpServer{groupMember("a", v1beta1.FrpServerPhaseHealthy), groupMember("b", v1beta1.FrpServerPhaseHealthy)},
+			servers: []string{"a", "b"},
+			want:    "b",
+		},
+		{
+			name:    "service moves off an unhealthy member",
+			placed:  "a",
+			members: []*v1beta1.FrpServer{groupMember("a", v1beta1.FrpServerPhaseUnhealthy), groupMember("b", v1beta1.FrpServerPhaseHealthy)},
+			servers: []string{"a", "b"},
+			want:    "b",
+		},
+		{
+			name:    "missing members are skipped",
+			members: []*v1beta1.FrpServer{groupMember("b", v1beta1.FrpServerPhaseHealthy)},
+			servers: []string{"a", "b"},
+			want:    "b",
+		},
+		{
+			name:    "service stays on its member without a healthy member",
+			placed:  "a",
+			members: []*v1beta1.FrpServer{groupMember("a", v1beta1.FrpServerPhaseUnhealthy), groupMember("b", v1beta1.FrpServerPhasePending)},
+			servers: []string{"a", "b"},
+			want:    "a",
+		},
+		{
+			name:    "unplaced service without a healthy member",
+			members: []*v1beta1.FrpServer{groupMember("a", v1beta1.FrpServerPhaseUnhealthy)},
+			servers: []string{"a", "b"},
+			wantErr: "frpservergroup 'group' has no healthy member",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []client.Object{serverGroup(v1beta1.FrpServerGroupPolicyFailover, tt.servers...), groupService(tt.placed)}
+			for _, member := range tt.members {
+				objs = append(objs, member)
+			}
+			r := newGroupReconciler(objs...)
+			instance := &v1.Service{}
+			if err := r.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "web"}, instance); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			got, err := r.scheduleGroupServer(context.Background(), instance, "group")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("scheduleGroupServer() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("scheduleGroupServer() error = %v", err)
+			}
+			if got.Name != tt.want {
+				t.Fatalf("scheduleGroupServer() = %v, want %v", got.Name, tt.want)
+			}
+			stored := &v1.Service{}
+			if err := r.Get(context.Background(), client.ObjectKeyFromObject(instance), stored); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if placed := stored.Annotations[v1beta1.AnnotationFrpServerNameKey]; placed != tt.want {
+				t.Fatalf("scheduleGroupServer() recorded %v, want %v", placed, tt.want)
+			}
+		})
+	}
+}
+
+// groupMemberProxy returns a FrpProxy of the service registering its http port on the member
+func groupMemberProxy(instance *v1.Service, serverName string, controlled bool) *v1beta1.FrpProxy {
+	obj := &v1beta1.FrpProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      groupProxyName(instance, serverName, v1.ServicePort{Name: "http", Port: 80}),
+			Namespace: instance.Namespace,
+			Labels:    map[string]string{frplabels.ServiceName: instance.Name},
+		},
+		Spec: v1beta1.FrpProxySpec{ServerName: serverName, Type: v1beta1.ProxyTypeTCP, Backend: v1beta1.FrpProxyBackend{ServiceName: instance.Name, Port: 8080}},
+	}
+	if controlled {
+		obj.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "v1", Kind: "Service", Name: instance.Name, UID: instance.UID, Controller: lo.ToPtr(true),
+		}}
+	}
+	return obj
+}
+
+func TestSyncGroupProxies(t *testing.T) {
+	port := v1.ServicePort{Name: "http", Port: 80}
+	instance := groupService("a", port)
+	members := []client.Object{
+		groupMember("a", v1beta1.FrpServerPhaseHealthy),
+		groupMember("b", v1beta1.FrpServerPhaseHealthy),
+		groupMember("c", v1beta1.FrpServerPhaseUnhealthy),
+		groupMember("d", v1beta1.FrpServerPhaseHealthy),
+	}
+	tests := []struct {
+		name     string
+		group    *v1beta1.FrpServerGroup
+		existing []*v1beta1.FrpProxy
+		// want are the members the service has a FrpProxy on after the sync
+		want []string
+	}{
+		{
+			name:  "fan out to the healthy members besides the placed one",
+			group: serverGroup(v1beta1.FrpServerGroupPolicyFanOut, "a", "b", "c", "d", "b", "missing"),
+			want:  []string{"b", "d"},
+		},
+		{
+			name:     "stale proxies of unhealthy members are pruned and outdated ones updated",
+			group:    serverGroup(v1beta1.FrpServerGroupPolicyFanOut, "a", "b", "c"),
+			existing: []*v1beta1.FrpProxy{groupMemberProxy(instance, "b", true), groupMemberProxy(instance, "c", true), groupMemberProxy(instance, "d", true)},
+			want:     []string{"b"},
+		},
+		{
+			name:     "proxies not controlled by the service are kept",
+			group:    serverGroup(v1beta1.FrpServerGroupPolicyFanOut, "a", "b"),
+			existing: []*v1beta1.FrpProxy{groupMemberProxy(instance, "c", false)},
+			want:     []string{"b", "c"},
+		},
+		{
+			name:     "failover group prunes every member proxy",
+			group:    serverGroup(v1beta1.FrpServerGroupPolicyFailover, "a", "b", "d"),
+			existing: []*v1beta1.FrpProxy{groupMemberProxy(instance, "b", true), groupMemberProxy(instance, "d", true)},
+		},
+		{
+			name:     "missing group prunes every member proxy",
+			existing: []*v1beta1.FrpProxy{groupMemberProxy(instance, "b", true)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := append([]client.Object{instance.DeepCopy()}, members...)
+			if tt.group != nil {
+				objs = append(objs, tt.group)
+			}
+			for _, obj := range tt.existing {
+				objs = append(objs, obj)
+			}
+			r := newGroupReconciler(objs...)
+			server := &v1beta1.FrpServer{ObjectMeta: metav1.ObjectMeta{Name: "a"}}
+			if err := r.syncGroupProxies(context.Background(), instance.DeepCopy(), server); err != nil {
+				t.Fatalf("syncGroupProxies() error = %v", err)
+			}
+			proxyList := &v1beta1.FrpProxyList{}
+			if err := r.List(context.Background(), proxyList, client.InNamespace(instance.Namespace)); err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			var got []string
+			for i := range proxyList.Items {
+				obj := &proxyList.Items[i]
+				got = append(got, obj.Spec.ServerName)
+				if !metav1.IsControlledBy(obj, instance) {
+					continue
+				}
+				want, err := groupProxies(instance, obj.Spec.ServerName)
+				if err != nil {
+					t.Fatalf("groupProxies() error = %v", err)
+				}
+				if !reflect.DeepEqual(obj.Spec, want[0].Spec) {
+					t.Fatalf("frpproxy '%s' spec = %+v, want %+v", obj.Name, obj.Spec, want[0].Spec)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("syncGroupProxies() left proxies on %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupProxies(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		ports       []v1.ServicePort
+		want        []v1beta1.FrpProxySpec
+		wantNames   []string
+		wantAnnots  map[string]string
+		wantErr     string
+	}{
+		{
+			name:      "tcp ports keep their port as the remote port",
+			ports:     []v1.ServicePort{{Name: "SSH", Port: 22}, {Port: 2222}},
+			wantNames: []string{"web-b-ssh", "web-b-2222"},
+			want: []v1beta1.FrpProxySpec{
+				{ServerName: "b", Type: v1beta1.ProxyTypeTCP, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 22}, RemotePort: 22},
+				{ServerName: "b", Type: v1beta1.ProxyTypeTCP, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 2222}, RemotePort: 2222},
+			},
+		},
+		{
+			name: "remote port annotation",
+			annotations: map[string]string{
+				v1beta1.AnnotationPortPrefix + "dns." + v1beta1.PortAnnotationType:       v1beta1.ProxyTypeUDP,
+				v1beta1.AnnotationPortPrefix + "dns." + v1beta1.PortAnnotationRemotePort: "5353",
+			},
+			ports:     []v1.ServicePort{{Name: "dns", Port: 53}},
+			wantNames: []string{"web-b-dns"},
+			want: []v1beta1.FrpProxySpec{
+				{ServerName: "b", Type: v1beta1.ProxyTypeUDP, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 53}, RemotePort: 5353},
+			},
+		},
+		{
+			name: "http ports carry the subdomain and the http auth secret",
+			annotations: map[string]string{
+				v1beta1.AnnotationProxyTypeKey:                                     v1beta1.ProxyTypeHTTP,
+				v1beta1.AnnotationEffectiveSubdomainKey:                            "web",
+				v1beta1.AnnotationHTTPAuthSecretKey:                                "web-auth",
+				v1beta1.AnnotationPortPrefix + "tls." + v1beta1.PortAnnotationType: v1beta1.ProxyTypeHTTPS,
+			},
+			ports:     []v1.ServicePort{{Name: "http", Port: 80}, {Name: "tls", Port: 443}},
+			wantNames: []string{"web-b-http", "web-b-tls"},
+			want: []v1beta1.FrpProxySpec{
+				{ServerName: "b", Type: v1beta1.ProxyTypeHTTP, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 80}, Subdomain: "web",
+					HTTPAuthSecretRef: &v1.LocalObjectReference{Name: "web-auth"}},
+				{ServerName: "b", Type: v1beta1.ProxyTypeHTTPS, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 443}, Subdomain: "web"},
+			},
+		},
+		{
+			name:        "ports of the proxy types registered on the placed member only are skipped",
+			annotations: map[string]string{v1beta1.AnnotationPortPrefix + "secret." + v1beta1.PortAnnotationType: v1beta1.ProxyTypeSTCP},
+			ports:       []v1.ServicePort{{Name: "secret", Port: 22}, {Name: "ssh", Port: 2222}},
+			wantNames:   []string{"web-b-ssh"},
+			want: []v1beta1.FrpProxySpec{
+				{ServerName: "b", Type: v1beta1.ProxyTypeTCP, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 2222}, RemotePort: 2222},
+			},
+		},
+		{
+			name: "source ranges and proxy defaults annotations are carried",
+			annotations: map[string]string{
+				v1beta1.AnnotationSourceRangesKey:   "10.0.0.0/8",
+				v1beta1.AnnotationUseEncryptionKey:  "true",
+				v1beta1.AnnotationHTTPAuthSecretKey: "web-auth",
+			},
+			ports:     []v1.ServicePort{{Name: "ssh", Port: 22}},
+			wantNames: []string{"web-b-ssh"},
+			want: []v1beta1.FrpProxySpec{
+				{ServerName: "b", Type: v1beta1.ProxyTypeTCP, Backend: v1beta1.FrpProxyBackend{ServiceName: "web", Port: 22}, RemotePort: 22},
+			},
+			wantAnnots: map[string]string{v1beta1.AnnotationSourceRangesKey: "10.0.0.0/8", v1beta1.AnnotationUseEncryptionKey: "true"},
+		},
+		{
+			name:        "invalid remote port",
+			annotations: map[string]string{v1beta1.AnnotationPortPrefix + "ssh." + v1beta1.PortAnnotationRemotePort: "ssh"},
+			ports:       []v1.ServicePort{{Name: "ssh", Port: 22}},
+			wantErr:     "invalid remote port 'ssh' of port 'ssh'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations},
+				Spec:       v1.ServiceSpec{Ports: tt.ports},
+			}
+			got, err := groupProxies(instance, "b")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("groupProxies() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("groupProxies() error = %v", err)
+			}
+			names := lo.Map(got, func(obj *v1beta1.FrpProxy, _ int) string { return obj.Name })
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Fatalf("groupProxies() names = %v, want %v", names, tt.wantNames)
+			}
+			specs := lo.Map(got, func(obj *v1beta1.FrpProxy, _ int) v1beta1.FrpProxySpec { return obj.Spec })
+			if !reflect.DeepEqual(specs, tt.want) {
+				t.Fatalf("groupProxies() specs = %+v, want %+v", specs, tt.want)
+			}
+			for _, obj := range got {
+				if !reflect.DeepEqual(obj.Annotations, tt.wantAnnots) {
+					t.Fatalf("groupProxies() annotations = %v, want %v", obj.Annotations, tt.wantAnnots)
+				}
+				if obj.Namespace != "default" || obj.Labels[frplabels.ServiceName] != "web" {
+					t.Fatalf("groupProxies() namespace = %v, labels = %v, want the namespace and label of the service", obj.Namespace, obj.Labels)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isHostPortMode reports whether the service is exposed through the host ports of its pod
+// instead of a frp tunnel
+func isHostPortMode(instance *v1.Service) bool {
+	return instance.Annotations[v1beta1.AnnotationExposureModeKey] == v1beta1.ExposureModeHostPort
+}
+
+// isExposed reports whether the service is exposed by the provisioner, through a FrpServer, a
+// FrpServerClaim, a scheduler, an inline server or the host ports of its pod
+func isExposed(instance *v1.Service) bool {
+	return instance.Annotations[v1beta1.AnnotationFrpServerNameKey] != "" ||
+		instance.Annotations[v1beta1.AnnotationFrpServerClaimNameKey] != "" ||
+		instance.Annotations[v1beta1.AnnotationSchedulerKey] != "" ||
+		instance.Annotations[v1beta1.AnnotationServerSelectorKey] != "" ||
+		instance.Annotations[v1beta1.AnnotationInlineServerKey] != "" || isHostPortMode(instance)
+}
+
+// applyHostPorts replaces the containers of the pod with a socat forwarder per port of the service, each
+// forwarder binds the port on the node of the pod and relays it to the service, which routes it to the
+// targetPort of its backends.
+func applyHostPorts(pod *v1.Pod, instance *v1.Service, image string) error {
+	host := fmt.Sprintf("%s.%s.svc", instance.Name, instance.Namespace)
+	containers := make([]v1.Container, 0, len(instance.Spec.Ports))
+	for _, port := range instance.Spec.Ports {
+		protocol := util.EmptyOr(port.Protocol, v1.ProtocolTCP)
+		var listen, connect string
+		switch protocol {
+		case v1.ProtocolTCP:
+			listen, connect = "TCP-LISTEN", "TCP"
+		case v1.ProtocolUDP:
+			listen, connect = "UDP-LISTEN", "UDP"
+		default:
+			return fmt.Errorf("protocol %s of port '%s' is not supported in host port mode", protocol, port.Name)
+		}
+		containers = append(containers, v1.Container{
+			Name:    "forward-" + strings.ToLower(util.EmptyOr(port.Name, strconv.Itoa(int(port.Port)))),
+			Image:   image,
+			Command: []string{"socat"},
+			Args: []string{
+				fmt.Sprintf("%s:%d,fork,reuseaddr", listen, port.Port),
+				fmt.Sprintf("%s:%s:%d", connect, host, port.Port),
+			},
+			Ports: []v1.ContainerPort{{
+				ContainerPort: port.Port,
+				HostPort:      port.Port,
+				Protocol:      protocol,
+			}},
+		})
+	}
+	pod.Spec.InitContainers = nil
+	pod.Spec.Containers = containers
+	return nil
+}
+
+// hostPortIngress returns the load balancer ingress points of a service in host port mode, they are
+// the addresses of the nodes running its ready pods. The external IP of a node is preferred over its
+// internal IP.
+func (r *ServiceReconciler) hostPortIngress(ctx context.Context, claimedPods []*v1.Pod) ([]v1.LoadBalancerIngress, error) {
+	logger := log.FromContext(ctx)
+	addrs := make(map[string]bool)
+	for _, pod := range claimedPods {
+		if !controllerutils.IsPodReady(pod) || pod.Spec.NodeName == "" {
+			continue
+		}
+		node := &v1.Node{}
+		if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable get node by name", "node", pod.Spec.NodeName)
+			return nil, err
+		}
+		addr := pod.Status.HostIP
+		for _, t := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+			for _, nodeAddr := range node.Status.Addresses {
+				if nodeAddr.Type == t && nodeAddr.Address != "" {
+					addr = nodeAddr.Address
+				}
+			}
+		}
+		if addr != "" {
+			addrs[addr] = true
+		}
+	}
+	ingress := make([]v1.LoadBalancerIngress, 0, len(addrs))
+	for addr := range addrs {
+		ingress = append(ingress, v1.LoadBalancerIngress{IP: addr})
+	}
+	sort.Slice(ingress, func(i, j int) bool { return ingress[i].IP < ingress[j].IP })
+	return ingress, nil
+}
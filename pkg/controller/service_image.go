@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+// imagePattern is the charset of an image reference "{repository}[:{tag}][@{digest}]"
+var imagePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)+(:[\w][\w.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+
+// serviceImage returns the frp client image selected by the annotations of the service, empty when it's not set.
+// The image must be pulled from one of the allowed registries of the manager.
+func serviceImage(options *config.ManagerOptions, instance *v1.Service) (string, error) {
+	image, ok := instance.Annotations[v1beta1.AnnotationImageKey]
+	if !ok {
+		return "", nil
+	}
+	if !imagePattern.MatchString(image) {
+		return "", fmt.Errorf("invalid annotations.%s '%s', it should be an image reference like 'ghcr.io/fatedier/frpc:v0.53.2'", v1beta1.AnnotationImageKey, image)
+	}
+	if options == nil || len(options.AllowedImageRegistries) == 0 {
+		return "", fmt.Errorf("annotations.%s is not allowed, the provisioner has no allowed image registries", v1beta1.AnnotationImageKey)
+	}
+	allowed := lo.SomeBy(options.AllowedImageRegistries, func(registry string) bool {
+		return strings.HasPrefix(image, strings.TrimSuffix(registry, "/")+"/")
+	})
+	if !allowed {
+		return "", fmt.Errorf("image '%s' of annotations.%s is not pulled from an allowed registry, allowed registries are %v",
+			image, v1beta1.AnnotationImageKey, options.AllowedImageRegistries)
+	}
+	return image, nil
+}
+
+// applyImage sets the image of the frp client container, the first container of the pod template, and records it
+// on the pod so the pods are replaced once the annotation changes
+func applyImage(pod *v1.Pod, image string) error {
+	if image == "" {
+		return nil
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return fmt.Errorf("pod template has no container to run the image of annotations.%s", v1beta1.AnnotationImageKey)
+	}
+	pod.Spec.Containers[0].Image = image
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[v1beta1.AnnotationImageKey] = image
+	return nil
+}
+
+// rolloutImage deletes the frp client pods of the service running another image than its annotation selects, they
+// are recreated with the selected image. The remaining pods are returned.
+func (r *ServiceReconciler) rolloutImage(ctx context.Context, instance *v1.Service, image string, claimedPods []*v1.Pod) ([]*v1.Pod, error) {
+	logger := log.FromContext(ctx)
+	// the Deployment rolls out the image itself
+	if r.managesDeployment() {
+		return claimedPods, nil
+	}
+	stale := lo.Filter(claimedPods, func(pod *v1.Pod, _ int) bool { return pod.Annotations[v1beta1.AnnotationImageKey] != image })
+	for _, pod := range stale {
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable delete frp client pod with stale image", "podName", pod.GetName())
+			return nil, err
+		}
+		logger.Info("restarted frp client pod to apply image", "podName", pod.GetName(), "image", image)
+		r.Recorder.Eventf(instance, v1.EventTypeNormal, v1beta1.ReasonImageChanged,
+			"Restarted frp client pod %s to apply the image %s", pod.Name, lo.Ternary(image != "", image, "of the pod template"))
+	}
+	return lo.Without(claimedPods, stale...), nil
+}
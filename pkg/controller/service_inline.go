@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// validateInlineServer checks the inline server of the service is allowed by the manager options and is not
+// combined with a FrpServer or FrpServerClaim, it returns the parsed inline server.
+func validateInlineServer(options *config.ManagerOptions, instance *v1.Service) (*frpclient.InlineServer, error) {
+	inline, err := frpclient.ParseInlineServer(instance.Annotations)
+	if err != nil || inline == nil {
+		return nil, err
+	}
+	if options != nil && !options.AllowInlineServers {
+		return nil, fmt.Errorf("annotations.%s is not allowed by the provisioner", v1beta1.AnnotationInlineServerKey)
+	}
+	for _, key := range []string{v1beta1.AnnotationFrpServerNameKey, v1beta1.AnnotationFrpServerClaimNameKey} {
+		if instance.Annotations[key] != "" {
+			return nil, fmt.Errorf("annotations.%s can't be combined with annotations.%s", v1beta1.AnnotationInlineServerKey, key)
+		}
+	}
+	return inline, nil
+}
+
+// scheduleInlineServer returns the FrpServer of the inline server of the service, the token is read from the
+// Secret of the namespace selected by the inline server
+func (r *ServiceReconciler) scheduleInlineServer(ctx context.Context, instance *v1.Service) (*v1beta1.FrpServer, error) {
+	inline, err := validateInlineServer(r.Options, instance)
+	if err != nil {
+		return nil, err
+	}
+	var token string
+	if ref := inline.TokenSecretRef; ref != nil {
+		secret := &v1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: ref.Name}, secret); err != nil {
+			return nil, fmt.Errorf("unable get token secret '%s/%s' of inline server, got: %w", instance.Namespace, ref.Name, err)
+		}
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("key '%s' not found in token secret '%s/%s' of inline server", ref.Key, instance.Namespace, ref.Name)
+		}
+		token = string(value)
+	}
+	return inline.FrpServer(instance, token), nil
+}
+
+// applyInlineServerToken passes the token of the inline server of the service to the frp client containers of the
+// pod with the InlineServerTokenEnv env var, the token is never written to the annotations
+func applyInlineServerToken(pod *v1.Pod, owner *v1.Service) error {
+	inline, err := frpclient.ParseInlineServer(owner.Annotations)
+	if err != nil || inline == nil || inline.TokenSecretRef == nil {
+		return err
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, v1.EnvVar{
+			Name:      v1beta1.InlineServerTokenEnv,
+			ValueFrom: &v1.EnvVarSource{SecretKeyRef: inline.TokenSecretRef.DeepCopy()},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/ipam"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+// syncIngressIP allocates the ingress IP of the service from the IPAM of its FrpServer and records it
+// in the annotations of the service, "" is returned when the FrpServer has no IPAM.
+func (r *ServiceReconciler) syncIngressIP(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer) (string, error) {
+	logger := log.FromContext(ctx)
+	pool := instance.Annotations[v1beta1.AnnotationIngressIPPoolKey]
+	if r.Options.Observing() {
+		// the external IPAM is never asked to allocate or release in the observe mode
+		if pool != server.Name {
+			return "", nil
+		}
+		return instance.Annotations[v1beta1.AnnotationIngressIPKey], nil
+	}
+	if pool != "" && (pool != server.Name || server.Spec.IPAM == nil) {
+		// the service moved to another FrpServer or its FrpServer dropped the IPAM
+		if err := r.releaseIngressIP(ctx, instance); err != nil {
+			return "", err
+		}
+		if err := r.Update(ctx, instance); err != nil {
+			logger.Error(err, "unable remove ingress ip annotations for service")
+			return "", err
+		}
+	}
+	if server.Spec.IPAM == nil {
+		return "", nil
+	}
+	allocator, err := ipam.New(server.Spec.IPAM)
+	if err != nil {
+		return "", fmt.Errorf("invalid spec.ipam of frpserver '%s', got: %w", server.Name, err)
+	}
+	inUse, err := r.ingressIPsInUse(ctx, instance, server.Name)
+	if err != nil {
+		return "", err
+	}
+	req := &ipam.Request{Server: server.Name, Namespace: instance.Namespace, Service: instance.Name}
+	if instance.Annotations[v1beta1.AnnotationIngressIPPoolKey] == server.Name {
+		req.IP = instance.Annotations[v1beta1.AnnotationIngressIPKey]
+	}
+	ip, err := allocator.Allocate(ctx, req, inUse)
+	if err != nil {
+		return "", fmt.Errorf("unable allocate ingress ip from frpserver '%s', got: %w", server.Name, err)
+	}
+	r.ingressIPs.Store(server.Name+"/"+ip, client.ObjectKeyFromObject(instance))
+	if req.IP == ip {
+		return ip, nil
+	}
+	if req.IP != "" {
+		r.ingressIPs.Delete(server.Name + "/" + req.IP)
+	}
+	instance.Annotations[v1beta1.AnnotationIngressIPKey] = ip
+	instance.Annotations[v1beta1.AnnotationIngressIPPoolKey] = server.Name
+	if err := r.Update(ctx, instance); err != nil {
+		logger.Error(err, "unable record ingress ip for service", "ip", ip)
+		return "", err
+	}
+	return ip, nil
+}
+
+// ingressIPsInUse returns the ingress IPs of the FrpServer allocated to services other than instance. The
+// allocations reserved by this manager are included, the cache may not observe the recorded annotations yet.
+func (r *ServiceReconciler) ingressIPsInUse(ctx context.Context, instance *v1.Service, serverName string) (sets.Set[string], error) {
+	serviceList := &v1.ServiceList{}
+	if err := r.List(ctx, serviceList); err != nil {
+		return nil, fmt.Errorf("unable list services, got: %w", err)
+	}
+	inUse := sets.New[string]()
+	for i := range serviceList.Items {
+		svc := &serviceList.Items[i]
+		if svc.UID == instance.UID || svc.Annotations[v1beta1.AnnotationIngressIPPoolKey] != serverName {
+			continue
+		}
+		inUse.Insert(svc.Annotations[v1beta1.AnnotationIngressIPKey])
+	}
+	key := client.ObjectKeyFromObject(instance)
+	r.ingressIPs.Range(func(k, v any) bool {
+		if ip, ok := strings.CutPrefix(k.(string), serverName+"/"); ok && v.(types.NamespacedName) != key {
+			inUse.Insert(ip)
+		}
+		return true
+	})
+	return inUse, nil
+}
+
+// releaseIngressIP returns the ingress IP of the service to the IPAM which allocated it and removes the
+// annotations recording it, the caller updates the service.
+func (r *ServiceReconciler) releaseIngressIP(ctx context.Context, instance *v1.Service) error {
+	logger := log.FromContext(ctx)
+	pool, ip := instance.Annotations[v1beta1.AnnotationIngressIPPoolKey], instance.Annotations[v1beta1.AnnotationIngressIPKey]
+	if pool == "" {
+		return nil
+	}
+	server := &v1beta1.FrpServer{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pool}, server); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "unable get frpserver by name", "server", pool)
+		return err
+	} else if err == nil && server.Spec.IPAM != nil {
+		allocator, err := ipam.New(server.Spec.IPAM)
+		if err == nil {
+			err = allocator.Release(ctx, &ipam.Request{Server: pool, Namespace: instance.Namespace, Service: instance.Name, IP: ip})
+		}
+		if err != nil {
+			logger.Error(err, "unable release ingress ip of service", "server", pool, "ip", ip)
+			return fmt.Errorf("unable release ingress ip '%s' to frpserver '%s', got: %w", ip, pool, err)
+		}
+	}
+	r.ingressIPs.Delete(pool + "/" + ip)
+	delete(instance.Annotations, v1beta1.AnnotationIngressIPKey)
+	delete(instance.Annotations, v1beta1.AnnotationIngressIPPoolKey)
+	return nil
+}
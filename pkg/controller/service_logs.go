@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// reasonCrashLoopBackOff is the waiting reason of a container restarted with back off
+	reasonCrashLoopBackOff = "CrashLoopBackOff"
+	// maxLogSummaryLength bounds the length of the log summary attached to an event
+	maxLogSummaryLength = 512
+)
+
+// frpcLogPatterns classify the frpc log lines pointing at the root cause of a crash, the first
+// category matching a line wins, so the most specific categories come first.
+var frpcLogPatterns = []struct {
+	category string
+	patterns []string
+}{
+	{category: "login failed", patterns: []string{"login to the server failed", "login to server failed", "authorization failed", "token in login doesn't match"}},
+	{category: "port rejected", patterns: []string{"port already used", "port not allowed", "port unavailable", "port is not allowed"}},
+	{category: "proxy rejected", patterns: []string{"start error", "proxy name", "already exists"}},
+	{category: "frp server unreachable", patterns: []string{"connect to server error", "connection refused", "i/o timeout", "no such host"}},
+	{category: "tls handshake failed", patterns: []string{"tls:", "x509:"}},
+}
+
+// crashKey identifies a frp client container
+type crashKey struct {
+	pod       types.UID
+	container string
+}
+
+// crashReport identifies a reported crash of a frp client container, a container is only reported
+// again once it restarted since.
+type crashReport struct {
+	crashKey
+	restartCount int32
+}
+
+// reportCrashLoops emits a warning event on the service for every frp client container in
+// CrashLoopBackOff, the event carries a summary of the logs of the crashed container so users
+// don't need access to the pod to debug the tunnel.
+func (r *ServiceReconciler) reportCrashLoops(ctx context.Context, instance *v1.Service, claimedPods []*v1.Pod) {
+	logger := log.FromContext(ctx)
+	if r.Pods == nil {
+		return
+	}
+	for _, pod := range claimedPods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting == nil || status.State.Waiting.Reason != reasonCrashLoopBackOff {
+				continue
+			}
+			report := crashReport{crashKey: crashKey{pod: pod.UID, container: status.Name}, restartCount: status.RestartCount}
+			if previous, ok := r.crashes.Load(report.crashKey); ok && previous.(crashReport) == report {
+				continue
+			}
+			r.crashes.Store(report.crashKey, report)
+			logs, err := r.Pods.Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+				Container: status.Name,
+				Previous:  true,
+				TailLines: &r.Options.PodLogTailLines,
+			}).DoRaw(ctx)
+			summary := summarizeFrpcLogs(string(logs))
+			if err != nil {
+				logger.Error(err, "unable get logs of crashed frp client container", "podName", pod.Name, "container", status.Name)
+				summary = fmt.Sprintf("unable get logs: %s", err.Error())
+			}
+			r.Recorder.Eventf(instance, v1.EventTypeWarning, v1beta1.ReasonTunnelCrashLoop,
+				"frp client pod %s container %s is crash looping after %d restarts: %s", pod.Name, status.Name, status.RestartCount, summary)
+		}
+	}
+}
+
+// forgetCrashLoops removes the reported crashes of the frp client pods which are gone
+func (r *ServiceReconciler) forgetCrashLoops(pods []*v1.Pod) {
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			r.crashes.Delete(crashKey{pod: pod.UID, container: container.Name})
+		}
+	}
+}
+
+// summarizeFrpcLogs returns the classified frpc log line pointing at the root cause of a crash, the
+// last line is returned when no line is recognized.
+func summarizeFrpcLogs(logs string) string {
+	lines := strings.Split(strings.TrimSpace(logs), "\n")
+	summary := ""
+	for _, class := range frpcLogPatterns {
+		for i := len(lines) - 1; i >= 0 && summary == ""; i-- {
+			line := strings.ToLower(lines[i])
+			for _, pattern := range class.patterns {
+				if strings.Contains(line, pattern) {
+					summary = class.category + ": " + strings.TrimSpace(lines[i])
+					break
+				}
+			}
+		}
+		if summary != "" {
+			break
+		}
+	}
+	if summary == "" {
+		summary = strings.TrimSpace(lines[len(lines)-1])
+	}
+	if summary == "" {
+		return "no logs"
+	}
+	if len(summary) > maxLogSummaryLength {
+		// cut on a rune boundary so the event message stays valid UTF-8
+		cut := maxLogSummaryLength
+		for cut > 0 && !utf8.RuneStart(summary[cut]) {
+			cut--
+		}
+		summary = summary[:cut] + "..."
+	}
+	return summary
+}
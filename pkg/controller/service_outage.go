@@ -0,0 +1,151 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sync"
+)
+
+// outageReleaseBuffer is the number of released services buffered for the service controller
+const outageReleaseBuffer = 1024
+
+// OutageQueue buffers the proxy changes of the services scheduled on an Unhealthy FrpServer. The services
+// are queued per server in the order their changes were held back, and are released in that order to the
+// service controller once the server is healthy again, instead of error looping on every reconcile.
+type OutageQueue struct {
+	mu sync.Mutex
+	// held is the servers observed Unhealthy by the FrpServer controller
+	held map[string]bool
+	// pending is the queued services of each server
+	pending map[string][]types.NamespacedName
+	// released feeds the released services to the service controller
+	released chan event.GenericEvent
+}
+
+// NewOutageQueue returns an empty OutageQueue
+func NewOutageQueue() *OutageQueue {
+	return &OutageQueue{
+		held:     make(map[string]bool),
+		pending:  make(map[string][]types.NamespacedName),
+		released: make(chan event.GenericEvent, outageReleaseBuffer),
+	}
+}
+
+// Hold holds back the proxy changes of the services scheduled on the server until it's released
+func (q *OutageQueue) Hold(server string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.held[server] = true
+}
+
+// Holds reports whether the proxy changes of the services scheduled on the server are held back, the
+// FrpServer controller tracks it so a stale cached phase doesn't hold back the released services.
+func (q *OutageQueue) Holds(server string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held[server]
+}
+
+// Add queues the service on the server, a service already queued keeps its position. It reports the queue
+// depth and whether the service was added.
+func (q *OutageQueue) Add(server string, key types.NamespacedName) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, queued := range q.pending[server] {
+		if queued == key {
+			return len(q.pending[server]), false
+		}
+	}
+	q.pending[server] = append(q.pending[server], key)
+	metrics.OutageQueueDepth.WithLabelValues(server).Set(float64(len(q.pending[server])))
+	return len(q.pending[server]), true
+}
+
+// Depth returns the number of services queued on the server, a nil queue is always empty
+func (q *OutageQueue) Depth(server string) int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending[server])
+}
+
+// Release stops holding back the server and hands its queued services to the service controller in the
+// order they were queued, it returns the number of released services.
+func (q *OutageQueue) Release(server string) int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	keys := q.pending[server]
+	delete(q.held, server)
+	delete(q.pending, server)
+	q.mu.Unlock()
+	if len(keys) == 0 {
+		return 0
+	}
+	metrics.OutageQueueDepth.DeleteLabelValues(server)
+	// don't block the FrpServer reconcile on a full buffer, the services keep their order
+	go func() {
+		for _, key := range keys {
+			q.released <- event.GenericEvent{Object: &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}}
+		}
+	}()
+	return len(keys)
+}
+
+// Source returns the source of the released services for the service controller
+func (q *OutageQueue) Source() source.Source {
+	return &source.Channel{Source: q.released}
+}
+
+// queueOutage holds back the proxy changes of the service until its Unhealthy FrpServer is healthy again,
+// the queue depth is mirrored to status.queuedChanges of the FrpServer.
+func (r *ServiceReconciler) queueOutage(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer) error {
+	logger := log.FromContext(ctx)
+	depth, added := r.Outages.Add(server.Name, client.ObjectKeyFromObject(instance))
+	if !added {
+		return nil
+	}
+	logger.Info("frp server is unhealthy, queued proxy changes of service", "server", server.Name, "queueDepth", depth)
+	r.Recorder.Eventf(instance, v1.EventTypeNormal, v1beta1.ReasonQueuedForOutage,
+		"Frp server %s is unhealthy, the proxy changes are applied once it's healthy again", server.Name)
+	patch := client.MergeFrom(server.DeepCopy())
+	server.Status.QueuedChanges = int32(depth)
+	if err := r.Status().Patch(ctx, server, patch); err != nil {
+		logger.Error(err, "unable update queued changes of frp server", "server", server.Name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// validatePlugin checks the client plugin annotations of the service, the plugins only terminate the TLS of
+// https proxies and the certificate annotation needs a plugin to serve it
+func validatePlugin(instance *v1.Service) error {
+	plugin, ok := instance.Annotations[v1beta1.AnnotationPluginKey]
+	if !ok {
+		if _, ok := instance.Annotations[v1beta1.AnnotationPluginCertSecretKey]; ok {
+			return fmt.Errorf("annotations.%s needs annotations.%s", v1beta1.AnnotationPluginCertSecretKey, v1beta1.AnnotationPluginKey)
+		}
+		return nil
+	}
+	if !lo.Contains(v1beta1.ClientPlugins, plugin) {
+		return fmt.Errorf("invalid annotations.%s '%s', optional values are %v", v1beta1.AnnotationPluginKey, plugin, v1beta1.ClientPlugins)
+	}
+	if proxyType := serviceProxyType(instance); proxyType != v1beta1.ProxyTypeHTTPS {
+		return fmt.Errorf("annotations.%s only applies to proxy type %s", v1beta1.AnnotationPluginKey, v1beta1.ProxyTypeHTTPS)
+	}
+	return nil
+}
+
+// checkPluginCertSecret checks the Secret selected by the client plugin certificate annotation of the service
+// holds a tls.crt and a tls.key
+func (r *ServiceReconciler) checkPluginCertSecret(ctx context.Context, instance *v1.Service) error {
+	name, ok := instance.Annotations[v1beta1.AnnotationPluginCertSecretKey]
+	if !ok {
+		return nil
+	}
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+		return fmt.Errorf("unable get plugin cert secret '%s/%s', got: %w", instance.Namespace, name, err)
+	}
+	for _, key := range []string{v1.TLSCertKey, v1.TLSPrivateKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("key '%s' not found in plugin cert secret '%s/%s'", key, instance.Namespace, name)
+		}
+	}
+	return nil
+}
+
+// applyPluginCert mounts the client plugin certificate Secret of the service in the containers of the frp client
+// pod, as v1beta1.DefaultCertFileName and v1beta1.DefaultKeyFileName under PluginTLSMountPath
+func applyPluginCert(pod *v1.Pod, owner *v1.Service) {
+	name, ok := owner.Annotations[v1beta1.AnnotationPluginCertSecretKey]
+	if !ok {
+		return
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: v1beta1.PluginTLSVolumeName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: name,
+				Items: []v1.KeyToPath{
+					{Key: v1.TLSCertKey, Path: v1beta1.DefaultCertFileName},
+					{Key: v1.TLSPrivateKeyKey, Path: v1beta1.DefaultKeyFileName},
+				},
+			},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+			Name:      v1beta1.PluginTLSVolumeName,
+			MountPath: v1beta1.PluginTLSMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
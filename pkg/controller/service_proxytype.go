@@ -0,0 +1,208 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/scheduler"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const serviceProxyTypeWebhookPath = "/validate-v1-service"
+
+// serviceProxyType returns the frp proxy type selected by the service, tcp when it's not set
+func serviceProxyType(instance *v1.Service) string {
+	return util.EmptyOr(instance.Annotations[v1beta1.AnnotationProxyTypeKey], v1beta1.ProxyTypeTCP)
+}
+
+// portProxyType returns the frp proxy type of the port, the proxy type of the service when the port
+// annotations don't override it
+func portProxyType(instance *v1.Service, port v1.ServicePort) string {
+	return util.EmptyOr(instance.Annotations[v1beta1.AnnotationPortPrefix+port.Name+"."+v1beta1.PortAnnotationType], serviceProxyType(instance))
+}
+
+// checkProxyType checks the proxy types of the service and of its ports are permitted by the manager options
+// and, when the service is scheduled, by spec.allowedProxyTypes of its FrpServer. server may be nil.
+func checkProxyType(options *config.ManagerOptions, server *v1beta1.FrpServer, instance *v1.Service) error {
+	if err := checkAllowedProxyType(options, server, serviceProxyType(instance)); err != nil {
+		return err
+	}
+	return checkPortProxies(options, server, instance)
+}
+
+// checkPortProxies checks the port annotations of the service, they must name a port of the service and a known
+// field. The proxy type of a port must be permitted and match the protocol of the port, a remote port only
+// applies to the tcp and udp proxies.
+func checkPortProxies(options *config.ManagerOptions, server *v1beta1.FrpServer, instance *v1.Service) error {
+	ports := lo.SliceToMap(instance.Spec.Ports, func(port v1.ServicePort) (string, v1.ServicePort) {
+		return port.Name, port
+	})
+	keys := lo.Keys(instance.Annotations)
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := instance.Annotations[key]
+		suffix, ok := strings.CutPrefix(key, v1beta1.AnnotationPortPrefix)
+		if !ok {
+			continue
+		}
+		dot := strings.LastIndex(suffix, ".")
+		if dot < 0 {
+			return fmt.Errorf("invalid annotations.%s, the key should be %s{port name}.{field}", key, v1beta1.AnnotationPortPrefix)
+		}
+		port, ok := ports[suffix[:dot]]
+		if !ok {
+			return fmt.Errorf("invalid annotations.%s, the service has no port named '%s'", key, suffix[:dot])
+		}
+		proxyType := portProxyType(instance, port)
+		switch field := suffix[dot+1:]; field {
+		case v1beta1.PortAnnotationType:
+			if err := checkAllowedProxyType(options, server, proxyType); err != nil {
+				return fmt.Errorf("invalid annotations.%s, got: %w", key, err)
+			}
+			udp := proxyType == v1beta1.ProxyTypeUDP || proxyType == v1beta1.ProxyTypeSUDP
+			if protocol := util.EmptyOr(port.Protocol, v1.ProtocolTCP); udp != (protocol == v1.ProtocolUDP) {
+				return fmt.Errorf("invalid annotations.%s, proxy type '%s' doesn't match protocol %s of port '%s'", key, proxyType, protocol, port.Name)
+			}
+		case v1beta1.PortAnnotationRemotePort:
+			if proxyType != v1beta1.ProxyTypeTCP && proxyType != v1beta1.ProxyTypeUDP {
+				return fmt.Errorf("annotations.%s only applies to proxy types [%s %s]", key, v1beta1.ProxyTypeTCP, v1beta1.ProxyTypeUDP)
+			}
+			if remotePort, err := strconv.Atoi(value); err != nil || remotePort < 1 || remotePort > 65535 {
+				return fmt.Errorf("invalid annotations.%s '%s', a port number in the range 1..65535 is expected", key, value)
+			}
+		default:
+			return fmt.Errorf("invalid annotations.%s, optional fields are [%s %s]", key, v1beta1.PortAnnotationType, v1beta1.PortAnnotationRemotePort)
+		}
+	}
+	return nil
+}
+
+// checkAllowedProxyType checks the proxy type is known and permitted by the manager options and by
+// spec.allowedProxyTypes of the FrpServer. server may be nil.
+func checkAllowedProxyType(options *config.ManagerOptions, server *v1beta1.FrpServer, proxyType string) error {
+	if !lo.Contains(v1beta1.ProxyTypes, proxyType) {
+		return fmt.Errorf("invalid annotations.%s '%s', optional values are %v", v1beta1.AnnotationProxyTypeKey, proxyType, v1beta1.ProxyTypes)
+	}
+	if options != nil && len(options.AllowedProxyTypes) != 0 && !lo.Contains(options.AllowedProxyTypes, proxyType) {
+		return fmt.Errorf("proxy type '%s' is not allowed by the provisioner, allowed types are %v", proxyType, options.AllowedProxyTypes)
+	}
+	if server != nil && len(server.Spec.AllowedProxyTypes) != 0 && !lo.Contains(server.Spec.AllowedProxyTypes, proxyType) {
+		return fmt.Errorf("proxy type '%s' is not allowed by frp server '%s', allowed types are %v", proxyType, server.Name, server.Spec.AllowedProxyTypes)
+	}
+	return nil
+}
+
+// ServiceProxyTypeValidator rejects the services selecting a proxy type which is not permitted globally
+// or by the FrpServer they're assigned to, and the invalid inline servers and frp client images, so the mistake
+// surfaces on apply instead of as an event.
+type ServiceProxyTypeValidator struct {
+	client.Client
+	Options *config.ManagerOptions
+	Decoder *admission.Decoder
+}
+
+func (s *ServiceProxyTypeValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if s.Decoder == nil {
+		s.Decoder = admission.NewDecoder(mgr.GetScheme())
+	}
+	mgr.GetWebhookServer().Register(serviceProxyTypeWebhookPath, &webhook.Admission{Handler: metrics.InstrumentHandler(serviceProxyTypeWebhookPath, s)})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-v1-service,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=services,verbs=create;update,versions=v1,name=vservice.frp.gofrp.io,admissionReviewVersions=v1
+var _ admission.Handler = &ServiceProxyTypeValidator{}
+
+// Handle implements admission.Handler, only the services exposed through a frp tunnel are checked. The
+// FrpServer of a claim is only known once the claim is bound, until then the reconciler enforces it.
+func (s *ServiceProxyTypeValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	instance := &v1.Service{}
+	if err := s.Decoder.Decode(req, instance); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !isExposed(instance) || isHostPortMode(instance) {
+		return admission.Allowed("service is not exposed through a frp tunnel")
+	}
+	if _, err := validateInlineServer(s.Options, instance); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if _, err := serviceImage(s.Options, instance); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if name := instance.Annotations[v1beta1.AnnotationSchedulerKey]; name != "" {
+		if _, err := scheduler.Get(name); err != nil {
+			return admission.Denied(fmt.Sprintf("invalid annotations.%s, got: %v", v1beta1.AnnotationSchedulerKey, err))
+		}
+	}
+	if _, err := scheduler.ServerSelector(instance); err != nil {
+		return admission.Denied(err.Error())
+	}
+	server, err := s.assignedServer(ctx, instance)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if err := checkProxyType(s.Options, server, instance); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if err := validateTCPMuxProxy(instance, server); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if err := validatePlugin(instance); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("proxy type is allowed")
+}
+
+// assignedServer returns the FrpServer the service is assigned to, nil when it's not known yet or the
+// service uses an inline server
+func (s *ServiceProxyTypeValidator) assignedServer(ctx context.Context, instance *v1.Service) (*v1beta1.FrpServer, error) {
+	if instance.Annotations[v1beta1.AnnotationInlineServerKey] != "" {
+		return nil, nil
+	}
+	serverName := instance.Annotations[v1beta1.AnnotationFrpServerNameKey]
+	if claimName := instance.Annotations[v1beta1.AnnotationFrpServerClaimNameKey]; claimName != "" {
+		claim := &v1beta1.FrpServerClaim{}
+		err := s.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: claimName}, claim)
+		if errors.IsNotFound(err) || (err == nil && claim.Status.Phase != v1beta1.FrpServerClaimPhaseBound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		serverName = claim.Spec.ServerName
+	}
+	server := &v1beta1.FrpServer{}
+	err := s.Get(ctx, client.ObjectKey{Name: serverName}, server)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	return server, err
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// reloadBuffer is the number of reloaded services buffered for the service controller
+const reloadBuffer = 1024
+
+// ConfigReloads hands the exposed services to the service controller once the reloaded config file changed
+// the pod template or the scheduler, the services pick up the applied options without waiting for their
+// next change.
+type ConfigReloads struct {
+	reader client.Reader
+	// reloaded feeds the services to reconcile to the service controller
+	reloaded chan event.GenericEvent
+}
+
+// NewConfigReloads returns the ConfigReloads listing the services with reader
+func NewConfigReloads(reader client.Reader) *ConfigReloads {
+	return &ConfigReloads{reader: reader, reloaded: make(chan event.GenericEvent, reloadBuffer)}
+}
+
+// Reloaded enqueues the exposed services when the applied options of the reloaded config change them
+func (c *ConfigReloads) Reloaded(ctx context.Context, applied []string) error {
+	if c == nil || !config.ReconcilesServices(applied) {
+		return nil
+	}
+	services := &v1.ServiceList{}
+	if err := c.reader.List(ctx, services); err != nil {
+		return fmt.Errorf("unable list services, got: %w", err)
+	}
+	var keys []client.ObjectKey
+	for i := range services.Items {
+		if isExposed(&services.Items[i]) {
+			keys = append(keys, client.ObjectKeyFromObject(&services.Items[i]))
+		}
+	}
+	log.FromContext(ctx).Info("reconciling exposed services with reloaded options", "options", applied, "count", len(keys))
+	// don't block the config watcher on a full buffer
+	go func() {
+		for _, key := range keys {
+			select {
+			case c.reloaded <- event.GenericEvent{Object: &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Source returns the source of the reloaded services for the service controller
+func (c *ConfigReloads) Source() source.Source {
+	return &source.Channel{Source: c.reloaded}
+}
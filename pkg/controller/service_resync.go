@@ -0,0 +1,133 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/events"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sync"
+	"time"
+)
+
+// serverRollout paces the restarts of the frp client pods rolling out changed FrpServer specs, the
+// restarts of all services are at least ServerRolloutInterval apart
+type serverRollout struct {
+	sync.Mutex
+	// last is when a frp client pod was last restarted
+	last time.Time
+}
+
+// next reports how long to wait before the next restart, the restart is claimed when it's zero
+func (s *serverRollout) next(interval time.Duration) time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if wait := interval - time.Since(s.last); wait > 0 {
+		return wait
+	}
+	s.last = time.Now()
+	return 0
+}
+
+// applyServerConfig records the FrpServer and its config hash on a new frp client pod, the inline server
+// of the service is recorded too
+func applyServerConfig(pod *v1.Pod, server *v1beta1.FrpServer) {
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[v1beta1.AnnotationScheduledServerKey] = server.Name
+	pod.Annotations[v1beta1.AnnotationServerConfigHashKey] = frpclient.ServerConfigHash(server)
+	if value, ok := server.Annotations[v1beta1.AnnotationInlineServerKey]; ok {
+		pod.Annotations[v1beta1.AnnotationInlineServerKey] = value
+	}
+}
+
+// syncServerConfig rolls out the spec of the FrpServer to the claimed frp client pods of the service, frpc only
+// applies it on start so the pods are restarted one at a time across all services. The rendered frpc config covers
+// the spec too, it's rolled out by rolloutFrpcConfig alone when the config is rendered. The pods created before the
+// hash was recorded are adopted as they are. The remaining pods are returned with the duration to requeue the
+// service after when a restart is pending.
+func (r *ServiceReconciler) syncServerConfig(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer, claimedPods []*v1.Pod) ([]*v1.Pod, time.Duration, error) {
+	logger := log.FromContext(ctx)
+	hash := frpclient.ServerConfigHash(server)
+	var stale []*v1.Pod
+	for _, pod := range claimedPods {
+		recorded, ok := pod.Annotations[v1beta1.AnnotationServerConfigHashKey]
+		if ok {
+			if recorded != hash {
+				stale = append(stale, pod)
+			}
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		applyServerConfig(pod, server)
+		if err := r.Patch(ctx, pod, patch); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable update frp server config hash of frp client pod", "podName", pod.GetName())
+			return nil, 0, err
+		}
+	}
+	// the Deployment rolls out the config hash recorded in its pod template itself
+	if len(stale) == 0 || r.managesDeployment() || r.Options.RenderFrpcConfig {
+		return claimedPods, 0, nil
+	}
+	if wait := r.rollout.next(r.Options.ServerRolloutInterval); wait > 0 {
+		return claimedPods, wait, nil
+	}
+	pod := stale[0]
+	if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "unable delete frp client pod with stale frp server config", "podName", pod.GetName())
+		return nil, 0, err
+	}
+	logger.Info("restarted frp client pod to apply frp server config", "podName", pod.GetName(), "server", server.Name)
+	r.Recorder.Eventf(instance, v1.EventTypeNormal, v1beta1.ReasonServerConfigChanged,
+		"Restarted frp client pod %s to apply the changed spec of frp server %s", pod.Name, server.Name)
+	if previous := pod.Annotations[v1beta1.AnnotationScheduledServerKey]; previous != "" && previous != server.Name {
+		r.CloudEvents.Emit(events.TypeTunnelServerSwitched, tunnelSubject(instance), &events.TunnelEvent{
+			Namespace: instance.Namespace, Service: instance.Name, Server: server.Name, PreviousServer: previous,
+		})
+	}
+	return lo.Without(claimedPods, pod), lo.Ternary(len(stale) > 1, r.Options.ServerRolloutInterval, 0), nil
+}
+
+// mapFrpServerToServices enqueue the services scheduled on a FrpServer whose spec changed
+func (r *ServiceReconciler) mapFrpServerToServices(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	server, ok := obj.(*v1beta1.FrpServer)
+	if !ok {
+		return nil
+	}
+	services, err := scheduledServices(ctx, r.Client, server)
+	if err != nil {
+		logger.Error(err, "unable get services scheduled on frp server", "server", server.Name)
+		return nil
+	}
+	return lo.Map(services, func(svc *v1.Service, _ int) reconcile.Request {
+		return reconcile.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+	})
+}
+
+// tunnelSubject returns the subject of the CloudEvents about the tunnel of the service
+func tunnelSubject(instance *v1.Service) string {
+	return instance.Namespace + "/" + instance.Name
+}
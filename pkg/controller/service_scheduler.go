@@ -0,0 +1,58 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/scheduler"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// placeService schedules the service without an assigned FrpServer with the scheduler registered with
+// the name. The placement is recorded in annotations.frp-server-name, so it's kept by the next reconciles
+// and the service is found by the FrpServer it's scheduled on.
+func (r *ServiceReconciler) placeService(ctx context.Context, instance *v1.Service, schedulerName string) (*v1beta1.FrpServer, error) {
+	logger := log.FromContext(ctx)
+	s, err := scheduler.Get(schedulerName)
+	if err != nil {
+		r.Recorder.Event(instance, v1.EventTypeWarning, v1beta1.ReasonSchedulingFailed, err.Error())
+		return nil, err
+	}
+	serverList := &v1beta1.FrpServerList{}
+	if err := r.List(ctx, serverList); err != nil {
+		logger.Error(err, "unable get frpserver list")
+		return nil, err
+	}
+	server, err := scheduler.Schedule(ctx, s, instance, lo.ToSlicePtr(serverList.Items))
+	if err != nil {
+		r.Recorder.Eventf(instance, v1.EventTypeWarning, v1beta1.ReasonSchedulingFailed, "Unable schedule service with scheduler %s, got: %v", schedulerName, err)
+		return nil, err
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	instance.Annotations[v1beta1.AnnotationFrpServerNameKey] = server.Name
+	if err := r.Patch(ctx, instance, patch); err != nil {
+		return nil, fmt.Errorf("unable record frp server '%s' scheduled by '%s', got: %w", server.Name, schedulerName, err)
+	}
+	logger.Info("scheduled service on frp server", "server", server.Name, "scheduler", schedulerName)
+	r.Recorder.Eventf(instance, v1.EventTypeNormal, v1beta1.ReasonScheduled, "Scheduled on frp server %s by scheduler %s", server.Name, schedulerName)
+	return server, nil
+}
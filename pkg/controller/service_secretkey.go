@@ -0,0 +1,163 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/kms"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/naming"
+	"github.com/samber/lo"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// secretKeySize is the number of random bytes of a generated stcp/xtcp/sudp secret key
+const secretKeySize = 32
+
+// secretKeyName returns the name of the Secret holding the stcp/xtcp/sudp secret key of the service
+func secretKeyName(instance *v1.Service) string {
+	return naming.Join(instance.Name, "frp-secret-key")
+}
+
+// secretKeyed reports whether a port of the service resolves to a stcp, xtcp or sudp proxy, the proxy type of a
+// port is looked up like renderFrpcConfig does
+func secretKeyed(instance *v1.Service) bool {
+	return lo.SomeBy(instance.Spec.Ports, func(port v1.ServicePort) bool {
+		return lo.Contains([]string{v1beta1.ProxyTypeSTCP, v1beta1.ProxyTypeXTCP, v1beta1.ProxyTypeSUDP}, portProxyType(instance, port))
+	})
+}
+
+// syncSecretKey ensures the Secret holding the secret key of a stcp/xtcp/sudp service exists. When a kms
+// is configured the secret key is envelope encrypted with a data encryption key wrapped for the
+// namespace of the service, and it's rewrapped once the kms key used for the namespace is rotated.
+func (r *ServiceReconciler) syncSecretKey(ctx context.Context, instance *v1.Service) error {
+	logger := log.FromContext(ctx)
+	if !secretKeyed(instance) {
+		return nil
+	}
+	secret := &v1.Secret{}
+	secretObjKey := client.ObjectKey{Namespace: instance.Namespace, Name: secretKeyName(instance)}
+	err := r.Get(ctx, secretObjKey, secret)
+	if errors.IsNotFound(err) {
+		secretKey := make([]byte, secretKeySize)
+		if _, err := io.ReadFull(rand.Reader, secretKey); err != nil {
+			return fmt.Errorf("unable generate secret key, got: %w", err)
+		}
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretObjKey.Name,
+				Namespace: secretObjKey.Namespace,
+				Labels:    map[string]string{frplabels.ServiceName: instance.Name},
+			},
+			Type: v1.SecretTypeOpaque,
+		}
+		if err := controllerutil.SetControllerReference(instance, secret, r.Scheme); err != nil {
+			return fmt.Errorf("can't set Secret '%s' owner reference: %w", secretObjKey.String(), err)
+		}
+		if err := r.storeSecretKey(ctx, instance, secret, []byte(hex.EncodeToString(secretKey))); err != nil {
+			return err
+		}
+		return r.Create(ctx, secret)
+	}
+	if err != nil {
+		logger.Error(err, "unable get secret key", "secret", secretObjKey.String())
+		return err
+	}
+	if r.KMS == nil {
+		return nil
+	}
+	_, plaintext := secret.Data[v1beta1.SecretKeyDataKey]
+	if !plaintext {
+		rotate, err := kms.NeedsRotation(ctx, r.KMS, instance.Namespace, secretKeyEnvelope(secret))
+		if err != nil || !rotate {
+			return err
+		}
+	}
+	secretKey, err := r.readSecretKey(ctx, instance, secret)
+	if err != nil {
+		return err
+	}
+	if err := r.storeSecretKey(ctx, instance, secret, secretKey); err != nil {
+		return err
+	}
+	logger.Info("rewrapped secret key with current kms key", "secret", secretObjKey.String())
+	return r.Update(ctx, secret)
+}
+
+// storeSecretKey stores the secret key into the Secret, encrypting it when a kms is configured
+func (r *ServiceReconciler) storeSecretKey(ctx context.Context, instance *v1.Service, secret *v1.Secret, secretKey []byte) error {
+	if r.KMS == nil {
+		secret.Data = map[string][]byte{v1beta1.SecretKeyDataKey: secretKey}
+		return nil
+	}
+	env, err := kms.Seal(ctx, r.KMS, instance.Namespace, secretKey)
+	if err != nil {
+		return fmt.Errorf("unable encrypt secret key, got: %w", err)
+	}
+	secret.Data = map[string][]byte{
+		v1beta1.SecretKeyEncryptedDataKey:    env.Ciphertext,
+		v1beta1.SecretKeyEncryptedDEKDataKey: env.EncryptedDEK,
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[v1beta1.AnnotationKMSKeyIDKey] = env.KeyID
+	return nil
+}
+
+// serviceSecretKey returns the plaintext secret key of the service, decrypted when a kms is configured
+func (r *ServiceReconciler) serviceSecretKey(ctx context.Context, instance *v1.Service) ([]byte, error) {
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: secretKeyName(instance)}, secret); err != nil {
+		return nil, fmt.Errorf("unable get secret key of service, got: %w", err)
+	}
+	return r.readSecretKey(ctx, instance, secret)
+}
+
+// readSecretKey returns the plaintext secret key stored in the Secret
+func (r *ServiceReconciler) readSecretKey(ctx context.Context, instance *v1.Service, secret *v1.Secret) ([]byte, error) {
+	if secretKey, ok := secret.Data[v1beta1.SecretKeyDataKey]; ok {
+		return secretKey, nil
+	}
+	if r.KMS == nil {
+		return nil, fmt.Errorf("secret '%s/%s' is encrypted but no kms is configured", secret.Namespace, secret.Name)
+	}
+	secretKey, err := kms.Open(ctx, r.KMS, instance.Namespace, secretKeyEnvelope(secret))
+	if err != nil {
+		return nil, fmt.Errorf("unable decrypt secret key, got: %w", err)
+	}
+	return secretKey, nil
+}
+
+// secretKeyEnvelope returns the kms.Envelope stored in the Secret
+func secretKeyEnvelope(secret *v1.Secret) *kms.Envelope {
+	return &kms.Envelope{
+		Ciphertext:   secret.Data[v1beta1.SecretKeyEncryptedDataKey],
+		EncryptedDEK: secret.Data[v1beta1.SecretKeyEncryptedDEKDataKey],
+		KeyID:        secret.Annotations[v1beta1.AnnotationKMSKeyIDKey],
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// syncSourceRanges registers the source ranges of the service with the frps access plugin, which rejects the
+// user connections of its proxies from the other addresses. The proxies fanned out to the other members of a
+// FanOut FrpServerGroup are FrpProxy objects carrying the source ranges, the frp client of the manager closes
+// their work connections started for the other addresses.
+func (r *ServiceReconciler) syncSourceRanges(instance *v1.Service, server *v1beta1.FrpServer) error {
+	ranges, err := frpclient.ParseSourceRanges(instance.Annotations)
+	if err != nil {
+		return err
+	}
+	if len(ranges) != 0 && r.Access == nil {
+		return fmt.Errorf("annotations.%s is enforced by the frps access plugin, which is disabled", v1beta1.AnnotationSourceRangesKey)
+	}
+	if r.Access == nil {
+		return nil
+	}
+	proxies, err := registeredProxyNames(instance, server)
+	if err != nil {
+		return err
+	}
+	r.Access.SetSourceRanges(client.ObjectKeyFromObject(instance).String(), server.Name, proxies, ranges)
+	return nil
+}
+
+// RestoreSourceRanges registers the source ranges of the exposed services with the frps access plugin, the
+// plugin calls it on start before serving frps so the source ranges don't fail open until the services are
+// reconciled again.
+func (r *ServiceReconciler) RestoreSourceRanges(ctx context.Context) error {
+	services := &v1.ServiceList{}
+	if err := r.List(ctx, services); err != nil {
+		return fmt.Errorf("unable list services, got: %w", err)
+	}
+	var errs []error
+	for i := range services.Items {
+		instance := &services.Items[i]
+		if instance.Annotations[v1beta1.AnnotationSourceRangesKey] == "" || instance.Annotations[v1beta1.AnnotationInlineServerKey] != "" ||
+			!r.isExposable(instance) || !isExposed(instance) || instance.DeletionTimestamp != nil {
+			continue
+		}
+		server, err := r.placedServer(ctx, instance)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable get frpserver of service '%s', got: %w", client.ObjectKeyFromObject(instance), err))
+			continue
+		} else if server == nil {
+			continue
+		}
+		if err := r.syncSourceRanges(instance, server); err != nil {
+			errs = append(errs, fmt.Errorf("unable restore source ranges of service '%s', got: %w", client.ObjectKeyFromObject(instance), err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// placedServer returns the FrpServer the service is placed on without scheduling it, nil is returned for a
+// service which is not placed yet
+func (r *ServiceReconciler) placedServer(ctx context.Context, instance *v1.Service) (*v1beta1.FrpServer, error) {
+	if claimName := instance.Annotations[v1beta1.AnnotationFrpServerClaimNameKey]; claimName != "" {
+		return r.scheduleClaimedServer(ctx, instance, claimName)
+	}
+	serverName := instance.Annotations[v1beta1.AnnotationFrpServerNameKey]
+	if serverName == "" {
+		return nil, nil
+	}
+	server := &v1beta1.FrpServer{}
+	if err := r.Get(ctx, client.ObjectKey{Name: serverName}, server); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return server, nil
+}
+
+// forgetSourceRanges lifts the source ranges of a service which is no longer exposed
+func (r *ServiceReconciler) forgetSourceRanges(key types.NamespacedName) {
+	if r.Access != nil {
+		r.Access.SetSourceRanges(key.String(), "", nil, nil)
+	}
+}
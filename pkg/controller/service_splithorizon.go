@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitHorizon reports whether the in-cluster endpoints of the service are published, the annotation of the
+// service overrides the manager options
+func splitHorizon(options *config.ManagerOptions, instance *v1.Service) (bool, error) {
+	value, ok := instance.Annotations[v1beta1.AnnotationSplitHorizonKey]
+	if !ok {
+		return options != nil && options.SplitHorizon, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid annotations.%s '%s', it should be true or false", v1beta1.AnnotationSplitHorizonKey, value)
+	}
+	return enabled, nil
+}
+
+// internalEndpoints formats the in-cluster endpoints of the service as a sorted, comma separated list of host:port
+// pairs, the format of the v1beta1.AnnotationInternalEndpointsKey annotation. The cluster ips are the hosts, the
+// services without one are addressed by their cluster DNS name.
+func internalEndpoints(instance *v1.Service) string {
+	clusterIPs := instance.Spec.ClusterIPs
+	if len(clusterIPs) == 0 {
+		clusterIPs = []string{instance.Spec.ClusterIP}
+	}
+	hosts := make([]string, 0, len(clusterIPs))
+	for _, ip := range clusterIPs {
+		if ip != "" && ip != v1.ClusterIPNone {
+			hosts = append(hosts, ip)
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = append(hosts, instance.Name+"."+instance.Namespace+".svc")
+	}
+	endpoints := make([]string, 0, len(hosts)*len(instance.Spec.Ports))
+	for _, host := range hosts {
+		for _, port := range instance.Spec.Ports {
+			endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(port.Port))))
+		}
+	}
+	sort.Strings(endpoints)
+	return strings.Join(endpoints, ",")
+}
+
+// syncInternalEndpoints publishes the in-cluster endpoints of a split-horizon service into the
+// v1beta1.AnnotationInternalEndpointsKey annotation, so in-cluster consumers never route through the frps
+// published in status.loadBalancer. The annotation is removed once split-horizon is disabled.
+func (r *ServiceReconciler) syncInternalEndpoints(ctx context.Context, instance *v1.Service) error {
+	enabled, err := splitHorizon(r.Options, instance)
+	if err != nil {
+		return err
+	}
+	current, annotated := instance.Annotations[v1beta1.AnnotationInternalEndpointsKey]
+	if !enabled {
+		if !annotated {
+			return nil
+		}
+		delete(instance.Annotations, v1beta1.AnnotationInternalEndpointsKey)
+		return r.Update(ctx, instance)
+	}
+	endpoints := internalEndpoints(instance)
+	if annotated && current == endpoints {
+		return nil
+	}
+	instance.Annotations[v1beta1.AnnotationInternalEndpointsKey] = endpoints
+	if err := r.Update(ctx, instance); err != nil {
+		log.FromContext(ctx).Error(err, "unable update internal endpoints annotation for service")
+		return err
+	}
+	return nil
+}
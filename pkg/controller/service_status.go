@@ -0,0 +1,215 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// publishedIngress returns the load balancer ingress points of the service, they are only
+// published once the tunnel is ready. The hostname of an http proxy on the domains of the FrpServer
+// is published first. The ingress IP allocated by the IPAM of the FrpServer is published instead of
+// its external IPs when set, the IPs are published with the remote ports of the proxies.
+func publishedIngress(instance *v1.Service, server *v1beta1.FrpServer, ip, hostname string, ready bool) []v1.LoadBalancerIngress {
+	if server == nil || !ready {
+		return nil
+	}
+	ingress := make([]v1.LoadBalancerIngress, 0, len(server.Spec.ExternalIPs)+1)
+	if hostname != "" {
+		ingress = append(ingress, v1.LoadBalancerIngress{Hostname: hostname})
+	}
+	ports := ingressPorts(instance)
+	if ip != "" {
+		return append(ingress, v1.LoadBalancerIngress{IP: ip, Ports: ports})
+	}
+	for _, addr := range server.Spec.ExternalIPs {
+		if net.ParseIP(addr) != nil {
+			ingress = append(ingress, v1.LoadBalancerIngress{IP: addr, Ports: ports})
+		} else {
+			ingress = append(ingress, v1.LoadBalancerIngress{Hostname: addr, Ports: ports})
+		}
+	}
+	return ingress
+}
+
+// ingressPorts returns the ports the FrpServer publishes the ports of the service on, a tcp or udp proxy is
+// published on the remote port of its port annotations and the other proxies on the service port
+func ingressPorts(instance *v1.Service) []v1.PortStatus {
+	return lo.Map(instance.Spec.Ports, func(port v1.ServicePort, _ int) v1.PortStatus {
+		status := v1.PortStatus{Port: port.Port, Protocol: lo.Ternary(port.Protocol != "", port.Protocol, v1.ProtocolTCP)}
+		if proxyType := portProxyType(instance, port); proxyType != v1beta1.ProxyTypeTCP && proxyType != v1beta1.ProxyTypeUDP {
+			return status
+		}
+		value := instance.Annotations[v1beta1.AnnotationPortPrefix+port.Name+"."+v1beta1.PortAnnotationRemotePort]
+		if remotePort, err := strconv.ParseInt(value, 10, 32); err == nil {
+			status.Port = int32(remotePort)
+		}
+		return status
+	})
+}
+
+// publishedHostname returns the hostname the http or https proxies of the service are published on, it's
+// empty for the other proxy types and when the FrpServer has no domains. The conflicts with the other
+// services publishing the same hostname are resolved with the conflict strategy of the namespace.
+func (r *ServiceReconciler) publishedHostname(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer) (string, error) {
+	domain, subdomain, err := r.serviceSubdomain(ctx, instance, server)
+	if err != nil || domain == nil {
+		return "", err
+	}
+	return r.resolveHostnameConflict(ctx, instance, server, domain, subdomain)
+}
+
+// serviceSubdomain returns the domain and subdomain the http or https proxies of the service are published
+// on, the domain is nil for the other proxy types and when the FrpServer has no domains. The subdomain of a
+// service using a FrpServerClaim is prefixed with the claim subdomain prefix.
+func (r *ServiceReconciler) serviceSubdomain(ctx context.Context, instance *v1.Service, server *v1beta1.FrpServer) (*v1beta1.FrpServerDomain, string, error) {
+	if proxyType := serviceProxyType(instance); proxyType != v1beta1.ProxyTypeHTTP && proxyType != v1beta1.ProxyTypeHTTPS {
+		return nil, "", nil
+	}
+	domain, err := frpclient.ServiceDomain(server, instance)
+	if err != nil || domain == nil {
+		return nil, "", err
+	}
+	subdomain := instance.Annotations[v1beta1.AnnotationSubdomainKey]
+	if claimName := instance.Annotations[v1beta1.AnnotationFrpServerClaimNameKey]; claimName != "" {
+		claim := &v1beta1.FrpServerClaim{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: claimName}, claim); err != nil {
+			return nil, "", err
+		}
+		subdomain = claim.Subdomain(subdomain)
+	}
+	return domain, subdomain, nil
+}
+
+// publishedEndpoints formats the ingress points and their published ports, the service ports when the ingress
+// point has none, as a sorted, comma separated list of host:port pairs, the format of the
+// v1beta1.AnnotationPublishedEndpointsKey annotation.
+func publishedEndpoints(instance *v1.Service, ingress []v1.LoadBalancerIngress) string {
+	endpoints := make([]string, 0, len(ingress)*len(instance.Spec.Ports))
+	for _, point := range ingress {
+		host := lo.Ternary(point.IP != "", point.IP, point.Hostname)
+		ports := lo.Map(instance.Spec.Ports, func(port v1.ServicePort, _ int) int32 { return port.Port })
+		if len(point.Ports) != 0 {
+			ports = lo.Map(point.Ports, func(port v1.PortStatus, _ int) int32 { return port.Port })
+		}
+		for _, port := range ports {
+			endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(port))))
+		}
+	}
+	sort.Strings(endpoints)
+	return strings.Join(endpoints, ",")
+}
+
+// writeIngress is the only writer of status.loadBalancer.ingress, it sets the ingress points of instance and
+// writes its status unless it's unchanged from original. Only LoadBalancer services have a load balancer
+// status, the ingress points of the other services are always cleared.
+func writeIngress(ctx context.Context, c client.Client, options *config.ManagerOptions, controller string, original, instance *v1.Service, ingress []v1.LoadBalancerIngress) error {
+	if instance.Spec.Type != v1.ServiceTypeLoadBalancer {
+		ingress = nil
+	}
+	instance.Status.LoadBalancer.Ingress = ingress
+	return updateStatus(ctx, c, options, controller, original, instance)
+}
+
+// syncPublishedEndpoints publishes the ingress points of the service into status.loadBalancer and, when
+// enabled, mirrors them into the v1beta1.AnnotationPublishedEndpointsKey annotation for consumers which
+// can't read the status. The status is written first so the annotation never advertises endpoints
+// which are not published, a failed annotation write is retried by the next reconcile.
+func (r *ServiceReconciler) syncPublishedEndpoints(ctx context.Context, instance *v1.Service, ingress []v1.LoadBalancerIngress) error {
+	logger := log.FromContext(ctx)
+	endStatusUpdate := metrics.StartPhase(ctx, serviceControllerName, metrics.PhaseStatusUpdate)
+	err := writeIngress(ctx, r.Client, r.Options, serviceControllerName, instance.DeepCopy(), instance, ingress)
+	endStatusUpdate()
+	if err != nil {
+		logger.Error(err, "unable update load balancer status for service")
+		return err
+	}
+
+	current, annotated := instance.Annotations[v1beta1.AnnotationPublishedEndpointsKey]
+	if !r.Options.PublishEndpointsAnnotation || len(ingress) == 0 {
+		if !annotated {
+			return nil
+		}
+		delete(instance.Annotations, v1beta1.AnnotationPublishedEndpointsKey)
+		return r.Update(ctx, instance)
+	}
+	endpoints := publishedEndpoints(instance, ingress)
+	if annotated && current == endpoints {
+		return nil
+	}
+	instance.Annotations[v1beta1.AnnotationPublishedEndpointsKey] = endpoints
+	if err := r.Update(ctx, instance); err != nil {
+		logger.Error(err, "unable update published endpoints annotation for service")
+		return err
+	}
+	return nil
+}
+
+// syncTunnelCondition writes the v1beta1.ServiceConditionTunnelReady condition into the status of the
+// service when enabled, the condition is true while one of the claimed frp client pods is ready.
+func (r *ServiceReconciler) syncTunnelCondition(ctx context.Context, instance *v1.Service, claimedPods []*v1.Pod) error {
+	logger := log.FromContext(ctx)
+	if !r.Options.ServiceTunnelCondition {
+		return nil
+	}
+	condition := metav1.Condition{
+		Type:               v1beta1.ServiceConditionTunnelReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             v1beta1.ReasonTunnelNotReady,
+		Message:            "no frp client pod is ready",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(claimedPods) == 0 {
+		condition.Message = "frp client pod is not created"
+	}
+	if pod, ok := lo.Find(claimedPods, controllerutils.IsPodReady); ok {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = v1beta1.ReasonTunnelReady
+		condition.Message = fmt.Sprintf("frp tunnel is live through frp client pod %s", pod.Name)
+	}
+	original := instance.DeepCopy()
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+	endStatusUpdate := metrics.StartPhase(ctx, serviceControllerName, metrics.PhaseStatusUpdate)
+	err := r.updateStatus(ctx, original, instance)
+	endStatusUpdate()
+	if err != nil {
+		logger.Error(err, "unable update tunnel ready condition for service")
+		return err
+	}
+	return nil
+}
+
+// updateStatus writes the status of the service unless it's unchanged since the original was read
+func (r *ServiceReconciler) updateStatus(ctx context.Context, original, instance *v1.Service) error {
+	return updateStatus(ctx, r.Client, r.Options, serviceControllerName, original, instance)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// httpProxyTypes are the proxy types routed by http, they support the http credentials and the routing by user
+var httpProxyTypes = []string{v1beta1.ProxyTypeHTTP, v1beta1.ProxyTypeTCPMux}
+
+// validateTCPMuxProxy checks the multiplexer, the http routing and the http credentials annotations of the service
+// apply to its proxy type, a tcpmux service is rejected once the probe of its FrpServer found tcpmux httpconnect
+// disabled. server may be nil.
+func validateTCPMuxProxy(instance *v1.Service, server *v1beta1.FrpServer) error {
+	proxyType := serviceProxyType(instance)
+	for _, key := range []string{v1beta1.AnnotationRouteByHTTPUserKey, v1beta1.AnnotationHTTPAuthSecretKey} {
+		if _, ok := instance.Annotations[key]; ok && !lo.Contains(httpProxyTypes, proxyType) {
+			return fmt.Errorf("annotations.%s only applies to proxy types %v", key, httpProxyTypes)
+		}
+	}
+	multiplexer, ok := instance.Annotations[v1beta1.AnnotationMultiplexerKey]
+	if ok && proxyType != v1beta1.ProxyTypeTCPMux {
+		return fmt.Errorf("annotations.%s only applies to proxy type %s", v1beta1.AnnotationMultiplexerKey, v1beta1.ProxyTypeTCPMux)
+	}
+	if ok && multiplexer != v1beta1.MultiplexerHTTPConnect {
+		return fmt.Errorf("invalid annotations.%s '%s', optional values are [%s]", v1beta1.AnnotationMultiplexerKey,
+			multiplexer, v1beta1.MultiplexerHTTPConnect)
+	}
+	if server == nil || proxyType != v1beta1.ProxyTypeTCPMux {
+		return nil
+	}
+	if capabilities := server.Status.Capabilities; capabilities != nil && capabilities.TCPMuxHTTPConnect != nil && !*capabilities.TCPMuxHTTPConnect {
+		return fmt.Errorf("frp server '%s' doesn't register tcpmux proxies, tcpmuxHTTPConnectPort should be set in its frps config", server.Name)
+	}
+	return nil
+}
+
+// checkHTTPAuthSecret checks the Secret selected by the http credentials annotation of the service holds a
+// username and a password
+func (r *ServiceReconciler) checkHTTPAuthSecret(ctx context.Context, instance *v1.Service) error {
+	name, ok := instance.Annotations[v1beta1.AnnotationHTTPAuthSecretKey]
+	if !ok {
+		return nil
+	}
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+		return fmt.Errorf("unable get http auth secret '%s/%s', got: %w", instance.Namespace, name, err)
+	}
+	for _, key := range []string{v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey} {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("key '%s' not found in http auth secret '%s/%s'", key, instance.Namespace, name)
+		}
+	}
+	return nil
+}
+
+// applyHTTPAuth passes the credentials of the http auth Secret of the service to the frp client containers of the
+// pod with the HTTPUserEnv and HTTPPasswordEnv env vars, the credentials are never written to the annotations
+func applyHTTPAuth(pod *v1.Pod, owner *v1.Service) {
+	name, ok := owner.Annotations[v1beta1.AnnotationHTTPAuthSecretKey]
+	if !ok {
+		return
+	}
+	env := []v1.EnvVar{
+		{Name: v1beta1.HTTPUserEnv, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: name}, Key: v1.BasicAuthUsernameKey,
+		}}},
+		{Name: v1beta1.HTTPPasswordEnv, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: name}, Key: v1.BasicAuthPasswordKey,
+		}}},
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, env...)
+	}
+}
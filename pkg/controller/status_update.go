@@ -0,0 +1,54 @@
+/*
+Copyright 2023 Aapeli <aapeli.nian@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateStatus writes the status of obj unless it's semantically equal to the status of original, the copy of
+// the object taken before the reconcile changed it, so the reconciles which changed nothing don't generate audit
+// noise and conflicts. The skipped writes are counted per controller, nothing is skipped when the options
+// disable it.
+func updateStatus(ctx context.Context, c client.Client, options *config.ManagerOptions, controller string, original, obj client.Object) error {
+	if (options == nil || lo.FromPtr(options.SkipUnchangedStatus)) && equality.Semantic.DeepEqual(statusOf(original), statusOf(obj)) {
+		metrics.SkippedStatusUpdatesTotal.WithLabelValues(controller).Inc()
+		return nil
+	}
+	return c.Status().Update(ctx, obj)
+}
+
+// statusOf returns the status of the objects whose status the controllers write, the other objects are
+// compared whole
+func statusOf(obj client.Object) any {
+	switch o := obj.(type) {
+	case *v1beta1.FrpServer:
+		return o.Status
+	case *v1.Service:
+		return o.Status
+	case *v1.Pod:
+		return o.Status
+	}
+	return obj
+}
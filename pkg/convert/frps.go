@@ -0,0 +1,179 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	frpconfig "github.com/fatedier/frp/pkg/config"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// FrpsOptions contains the configuration of the frps configuration converter
+type FrpsOptions struct {
+	// Name is the name of the generated FrpServer, defaults to the configuration file name
+	Name string `json:"name"`
+	// ServerAddr is the address frp clients reach the server on, the frps bindAddr is rarely reachable
+	ServerAddr string `json:"serverAddr"`
+	// ExternalIPs are the load-balancer ingress points published for services exposed by the server
+	ExternalIPs []string `json:"externalIPs"`
+}
+
+// AddFlags add related command line parameters
+func (o *FrpsOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Name, "name", o.Name, "Is the name of the generated FrpServer, defaults to the configuration file name.")
+	fs.StringVar(&o.ServerAddr, "server-addr", o.ServerAddr, "Is the address frp clients reach the server on.")
+	fs.StringSliceVar(&o.ExternalIPs, "external-ip", o.ExternalIPs, "Are the load-balancer ingress points published for services exposed by the server.")
+}
+
+// Result is a FrpServer converted from a frps configuration, Notes lists the fields which need manual attention
+type Result struct {
+	Source string
+	Server *v1beta1.FrpServer
+	Notes  []string
+}
+
+// LoadFrpsConfig loads a frps configuration file in TOML, YAML, JSON or the legacy INI format and converts it
+func LoadFrpsConfig(path string, o *FrpsOptions) (*Result, error) {
+	cfg, _, err := frpconfig.LoadServerConfig(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable load frps config '%s', got: %w", path, err)
+	}
+	name := o.Name
+	if name == "" {
+		name = strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+	result := FromServerConfig(name, cfg, o)
+	result.Source = path
+	return result, nil
+}
+
+// FromServerConfig converts a completed frps configuration to the FrpServer its clients should use
+func FromServerConfig(name string, cfg *configv1.ServerConfig, o *FrpsOptions) *Result {
+	result := &Result{}
+	note := func(format string, args ...any) {
+		result.Notes = append(result.Notes, fmt.Sprintf(format, args...))
+	}
+	server := &v1beta1.FrpServer{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "FrpServer"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+		note("metadata.name: %q is not a valid name, %s", name, strings.Join(errs, ", "))
+	}
+
+	server.Spec.ServerAddr = o.ServerAddr
+	if server.Spec.ServerAddr == "" {
+		server.Spec.ServerAddr = cfg.BindAddr
+		if cfg.BindAddr == "" || cfg.BindAddr == "0.0.0.0" || cfg.BindAddr == "::" {
+			note("spec.serverAddr: frps binds %q, set the address frp clients reach the server on", cfg.BindAddr)
+		}
+	}
+	server.Spec.ServerPort = cfg.BindPort
+	server.Spec.ExternalIPs = o.ExternalIPs
+	if len(server.Spec.ExternalIPs) == 0 {
+		note("spec.externalIPs: set the load-balancer ingress points published for exposed services")
+	}
+	server.Spec.SubDomainHost = cfg.SubDomainHost
+	server.Spec.UDPPacketSize = cfg.UDPPacketSize
+
+	server.Spec.Auth.Method = v1beta1.FrpServerAuthMethod(cfg.Auth.Method)
+	for _, scope := range cfg.Auth.AdditionalScopes {
+		server.Spec.Auth.AdditionalScopes = append(server.Spec.Auth.AdditionalScopes, v1beta1.FrpServerAuthScope(scope))
+	}
+	switch server.Spec.Auth.Method {
+	case v1beta1.FrpServerAuthMethodToken:
+		server.Spec.Auth.Token = cfg.Auth.Token
+		if cfg.Auth.Token != "" {
+			note("spec.auth.token: the token is inlined, consider moving it to Vault with spec.vaultRef")
+		}
+	case v1beta1.FrpServerAuthMethodOIDC:
+		server.Spec.Auth.OIDC = &v1beta1.FrpServerAuthOIDC{Audience: cfg.Auth.OIDC.Audience}
+		note("spec.auth.oidc: set clientID, clientSecret and tokenEndpointURL of the issuer %q", cfg.Auth.OIDC.Issuer)
+	}
+
+	server.Spec.Transport.TCPMux = cfg.Transport.TCPMux
+	server.Spec.Transport.TCPMuxKeepaliveInterval = cfg.Transport.TCPMuxKeepaliveInterval
+	server.Spec.Transport.HeartbeatTimeout = cfg.Transport.HeartbeatTimeout
+	// the fallback protocols are dialed on spec.serverPort, a protocol served on another port is only noted
+	if cfg.QUICBindPort > 0 && cfg.QUICBindPort == cfg.BindPort {
+		server.Spec.Transport.FallbackProtocols = append(server.Spec.Transport.FallbackProtocols, v1beta1.FrpServerTransportProtocolQUIC)
+	} else if cfg.QUICBindPort > 0 {
+		note("spec.transport.fallbackProtocols: quic is served on port %d while clients dial spec.serverPort %d,"+
+			" create a separate FrpServer for quic", cfg.QUICBindPort, cfg.BindPort)
+	}
+	if cfg.KCPBindPort > 0 && cfg.KCPBindPort == cfg.BindPort {
+		server.Spec.Transport.FallbackProtocols = append(server.Spec.Transport.FallbackProtocols, v1beta1.FrpServerTransportProtocolKCP)
+	} else if cfg.KCPBindPort > 0 {
+		note("spec.transport.fallbackProtocols: kcp is served on port %d while clients dial spec.serverPort %d,"+
+			" create a separate FrpServer for kcp", cfg.KCPBindPort, cfg.BindPort)
+	}
+	if cfg.Transport.TLS.Force {
+		note("spec.transport.tls.secretRef: frps only accepts tls connections, frpc only enables tls when a secret is referenced")
+	}
+	if cfg.Transport.TLS.TrustedCaFile != "" {
+		note("spec.transport.tls.secretRef: frps verifies client certificates against %q, create a Secret with"+
+			" %s, %s and %s and reference it", cfg.Transport.TLS.TrustedCaFile,
+			v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName, v1beta1.DefaultCaFileName)
+	}
+	if len(cfg.AllowPorts) > 0 {
+		note("spec: frps restricts the proxy ports with allowPorts, make sure exposed services use allowed ports")
+	}
+	if len(cfg.HTTPPlugins) > 0 {
+		note("spec: frps calls the http plugins %v, they may reject the proxies created by frp-provisioner",
+			lo.Map(cfg.HTTPPlugins, func(p configv1.HTTPPluginOptions, _ int) string { return p.Name }))
+	}
+	result.Server = server
+	return result
+}
+
+// Marshal renders the converted FrpServers as a multi-document YAML manifest, the notes of each
+// FrpServer are written as comments above it.
+func Marshal(results []*Result) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for i, result := range results {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		if result.Source != "" {
+			fmt.Fprintf(buf, "# Converted from %s\n", result.Source)
+		}
+		for _, note := range result.Notes {
+			fmt.Fprintf(buf, "# TODO: %s\n", note)
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(result.Server)
+		if err != nil {
+			return nil, fmt.Errorf("unable convert frpserver '%s', got: %w", result.Server.Name, err)
+		}
+		// the status and creation timestamp are owned by the api server
+		delete(obj, "status")
+		unstructured.RemoveNestedField(obj, "metadata", "creationTimestamp")
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("unable marshal frpserver '%s', got: %w", result.Server.Name, err)
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crds
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/samber/lo"
+	"io/fs"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+	"sort"
+)
+
+// FieldOwner is the field manager the embedded CRDs are applied with
+const FieldOwner = "frp-provisioner-manager"
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch;create;patch
+
+// Embedded returns the CRDs compiled into the manager
+func Embedded() ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	files, err := fs.Glob(config.CRDs, "crd/bases/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	crds := make([]*apiextensionsv1.CustomResourceDefinition, 0, len(files))
+	for _, file := range files {
+		data, err := config.CRDs.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.UnmarshalStrict(data, crd); err != nil {
+			return nil, fmt.Errorf("unable decode embedded crd '%s', got: %w", file, err)
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+// Drift returns why the installed CRD is older than the embedded one, i.e. the versions and schema fields
+// of the embedded CRD it lacks. installed is nil when the CRD is not installed.
+func Drift(installed, embedded *apiextensionsv1.CustomResourceDefinition) []string {
+	if installed == nil {
+		return []string{"crd is not installed"}
+	}
+	var drift []string
+	for _, version := range embedded.Spec.Versions {
+		current, ok := lo.Find(installed.Spec.Versions, func(v apiextensionsv1.CustomResourceDefinitionVersion) bool { return v.Name == version.Name })
+		if !ok {
+			drift = append(drift, fmt.Sprintf("version %s is not served", version.Name))
+			continue
+		}
+		if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+			continue
+		}
+		var have []string
+		if current.Schema != nil && current.Schema.OpenAPIV3Schema != nil {
+			have = schemaFields("", current.Schema.OpenAPIV3Schema)
+		}
+		missing := lo.Without(schemaFields("", version.Schema.OpenAPIV3Schema), have...)
+		sort.Strings(missing)
+		for _, field := range missing {
+			drift = append(drift, fmt.Sprintf("version %s is missing field %s", version.Name, field))
+		}
+	}
+	return drift
+}
+
+// schemaFields returns the paths of the properties of the schema, the items of arrays are suffixed with "[]"
+func schemaFields(prefix string, schema *apiextensionsv1.JSONSchemaProps) []string {
+	var fields []string
+	for name, property := range schema.Properties {
+		property := property
+		path := lo.Ternary(prefix == "", name, prefix+"."+name)
+		fields = append(fields, path)
+		fields = append(fields, schemaFields(path, &property)...)
+	}
+	if schema.Items != nil && schema.Items.Schema != nil {
+		fields = append(fields, schemaFields(prefix+"[]", schema.Items.Schema)...)
+	}
+	if schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil {
+		fields = append(fields, schemaFields(prefix+"{}", schema.AdditionalProperties.Schema)...)
+	}
+	return fields
+}
+
+// Check compares the installed CRDs with the ones compiled into the manager on startup, the drift is
+// logged and exported as the crd_schema_drift metric. The drifted CRDs are applied when apply is set,
+// so the controllers don't start against a schema which prunes the fields they write.
+func Check(ctx context.Context, restConfig *rest.Config, apply bool) error {
+	logger := log.FromContext(ctx).WithName("crd-drift")
+	scheme := runtime.NewScheme()
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	cli, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("unable create kubernetes client, got: %w", err)
+	}
+	embedded, err := Embedded()
+	if err != nil {
+		return err
+	}
+	for _, crd := range embedded {
+		installed := &apiextensionsv1.CustomResourceDefinition{}
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(crd), installed); err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("unable get crd '%s', got: %w", crd.Name, err)
+			}
+			installed = nil
+		}
+		drift := Drift(installed, crd)
+		if len(drift) == 0 {
+			metrics.CRDSchemaDrift.WithLabelValues(crd.Name).Set(0)
+			continue
+		}
+		if !apply {
+			logger.Info("WARNING: installed crd is older than the manager, upgrade the crds or run with --manager.manage-crds",
+				"crd", crd.Name, "drift", drift)
+			metrics.CRDSchemaDrift.WithLabelValues(crd.Name).Set(1)
+			continue
+		}
+		crd.ManagedFields = nil
+		if err := cli.Patch(ctx, crd, client.Apply, client.FieldOwner(FieldOwner), client.ForceOwnership); err != nil {
+			metrics.CRDSchemaDrift.WithLabelValues(crd.Name).Set(1)
+			return fmt.Errorf("unable apply crd '%s', got: %w", crd.Name, err)
+		}
+		logger.Info("applied embedded crd", "crd", crd.Name, "drift", drift)
+		metrics.CRDSchemaDrift.WithLabelValues(crd.Name).Set(0)
+	}
+	return nil
+}
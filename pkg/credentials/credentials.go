@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// ProviderVault is the name of the HashiCorp Vault credentials provider
+	ProviderVault = "vault"
+
+	// KeyToken is the key of the frp auth token in the resolved credentials
+	KeyToken = "token"
+	// KeyOIDCClientSecret is the key of the OIDC client secret in the resolved credentials
+	KeyOIDCClientSecret = "oidcClientSecret"
+)
+
+// Credentials holds the frp credentials resolved for a v1beta1.FrpServer
+type Credentials struct {
+	// Token overrides spec.auth.token when not empty
+	Token string
+	// OIDCClientSecret overrides spec.auth.oidc.clientSecret when not empty
+	OIDCClientSecret string
+	// TLSData holds the transport tls material keyed by
+	// v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName and v1beta1.DefaultCaFileName
+	TLSData map[string][]byte
+	// TTL is the duration the credentials are valid for, zero means they never expire
+	TTL time.Duration
+}
+
+// Provider resolves frp credentials from an external secret store
+type Provider interface {
+	// Resolve fetches the credentials referenced by the FrpServer
+	Resolve(ctx context.Context, obj *v1beta1.FrpServer) (*Credentials, error)
+}
+
+// Resolver resolves the frp credentials referenced by the FrpServers, it's shared by the reconcilers and the
+// validator of the FrpServers. A nil Resolver resolves the Secrets selected by spec.auth only.
+type Resolver struct {
+	// Vault resolves spec.vaultRef, the FrpServers referencing a Vault secret fail to resolve when nil
+	Vault Provider
+}
+
+// Resolve fetches the external credentials referenced by the FrpServer, either from its Vault secret or from
+// the Secrets selected by spec.auth, nil is returned when the FrpServer does not reference any.
+func (r *Resolver) Resolve(ctx context.Context, cli client.Reader, obj *v1beta1.FrpServer) (*Credentials, error) {
+	if obj.Spec.VaultRef == nil {
+		if !hasSecretRefs(obj) {
+			return nil, nil
+		}
+		return resolveSecretRefs(ctx, cli, obj)
+	}
+	if r == nil || r.Vault == nil {
+		return nil, fmt.Errorf("no credentials provider configured for '%s'", ProviderVault)
+	}
+	return r.Vault.Resolve(ctx, obj)
+}
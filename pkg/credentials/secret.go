@@ -0,0 +1,58 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// hasSecretRefs reports whether the FrpServer selects its token or OIDC client secret from a Secret
+func hasSecretRefs(obj *v1beta1.FrpServer) bool {
+	return obj.Spec.Auth.TokenSecretRef != nil || (obj.Spec.Auth.OIDC != nil && obj.Spec.Auth.OIDC.ClientSecretRef != nil)
+}
+
+// resolveSecretRefs reads the token and the OIDC client secret selected by spec.auth.tokenSecretRef and
+// spec.auth.oidc.clientSecretRef from the Secrets of spec.auth.secretNamespace
+func resolveSecretRefs(ctx context.Context, cli client.Reader, obj *v1beta1.FrpServer) (*Credentials, error) {
+	creds := &Credentials{}
+	var err error
+	if ref := obj.Spec.Auth.TokenSecretRef; ref != nil {
+		if creds.Token, err = secretKey(ctx, cli, obj.Spec.Auth.SecretNamespace, ref); err != nil {
+			return nil, fmt.Errorf("unable resolve spec.auth.tokenSecretRef, got: %w", err)
+		}
+	}
+	if obj.Spec.Auth.OIDC != nil && obj.Spec.Auth.OIDC.ClientSecretRef != nil {
+		if creds.OIDCClientSecret, err = secretKey(ctx, cli, obj.Spec.Auth.SecretNamespace, obj.Spec.Auth.OIDC.ClientSecretRef); err != nil {
+			return nil, fmt.Errorf("unable resolve spec.auth.oidc.clientSecretRef, got: %w", err)
+		}
+	}
+	return creds, nil
+}
+
+// secretKey reads the non-empty value of the key selected by ref from the Secret in namespace
+func secretKey(ctx context.Context, cli client.Reader, namespace string, ref *v1.SecretKeySelector) (string, error) {
+	secret := &v1.Secret{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, secret); err != nil {
+		return "", fmt.Errorf("unable get secret '%s/%s', got: %w", namespace, ref.Name, err)
+	}
+	value := secret.Data[ref.Key]
+	if len(value) == 0 {
+		return "", fmt.Errorf("key '%s' not found in secret '%s/%s'", ref.Key, namespace, ref.Name)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// VaultAuthMethodKubernetes logins to Vault with the service account token of the manager
+	VaultAuthMethodKubernetes = "kubernetes"
+	// VaultAuthMethodToken uses the Vault token from the VAULT_TOKEN environment variable
+	VaultAuthMethodToken = "token"
+
+	vaultTokenEnvKeyName    = "VAULT_TOKEN"
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	vaultRequestTimeout     = 10 * time.Second
+	// vaultTokenRenewSkew renews the cached Vault login token before it actually expires
+	vaultTokenRenewSkew = 30 * time.Second
+)
+
+var _ Provider = &VaultProvider{}
+
+// VaultProvider resolves frp credentials from HashiCorp Vault, KV version 1 and version 2 secret
+// engines are supported. The secret may contain the keys "token", "oidcClientSecret",
+// "tls.crt", "tls.key" and "tls.ca".
+type VaultProvider struct {
+	address             string
+	kubernetesMountPath string
+	httpClient          *http.Client
+	// tokenPath is the service account token of the manager logging in with the kubernetes auth method
+	tokenPath string
+
+	lock   sync.Mutex
+	tokens map[string]vaultToken
+}
+
+// vaultToken is a cached Vault login token
+type vaultToken struct {
+	token  string
+	expiry time.Time
+}
+
+// vaultResponse is the subset of the Vault API response used by VaultProvider
+type vaultResponse struct {
+	LeaseDuration int64                  `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// NewVaultProvider create a VaultProvider for the Vault server at address
+func NewVaultProvider(address, kubernetesMountPath string) *VaultProvider {
+	return &VaultProvider{
+		address:             strings.TrimSuffix(address, "/"),
+		kubernetesMountPath: strings.Trim(kubernetesMountPath, "/"),
+		httpClient:          &http.Client{Timeout: vaultRequestTimeout},
+		tokenPath:           serviceAccountTokenPath,
+		tokens:              make(map[string]vaultToken),
+	}
+}
+
+// Resolve implements Provider
+func (v *VaultProvider) Resolve(ctx context.Context, obj *v1beta1.FrpServer) (*Credentials, error) {
+	ref := obj.Spec.VaultRef
+	token, err := v.login(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("unable login to vault, got: %w", err)
+	}
+	resp, err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(ref.Path, "/"), token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable read vault secret '%s', got: %w", ref.Path, err)
+	}
+	data := resp.Data
+	// KV version 2 nests the secret under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	creds := &Credentials{
+		TTL:     time.Duration(resp.LeaseDuration) * time.Second,
+		TLSData: make(map[string][]byte),
+	}
+	for key, val := range data {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+		switch key {
+		case KeyToken:
+			creds.Token = str
+		case KeyOIDCClientSecret:
+			creds.OIDCClientSecret = str
+		case v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName, v1beta1.DefaultCaFileName:
+			creds.TLSData[key] = []byte(str)
+		}
+	}
+	return creds, nil
+}
+
+// login returns a Vault token for the reference, kubernetes login tokens are cached until they expire
+func (v *VaultProvider) login(ctx context.Context, ref *v1beta1.FrpServerVaultRef) (string, error) {
+	if ref.AuthMethod == VaultAuthMethodToken {
+		token := os.Getenv(vaultTokenEnvKeyName)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is not set", vaultTokenEnvKeyName)
+		}
+		return token, nil
+	}
+
+	v.lock.Lock()
+	cached, ok := v.tokens[ref.Role]
+	v.lock.Unlock()
+	if ok && time.Now().Add(vaultTokenRenewSkew).Before(cached.expiry) {
+		return cached.token, nil
+	}
+
+	jwt, err := os.ReadFile(v.tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("unable read service account token, got: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"role": ref.Role, "jwt": string(jwt)})
+	if err != nil {
+		return "", err
+	}
+	resp, err := v.do(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", v.kubernetesMountPath), "", body)
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login response does not contain a client token")
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.tokens[ref.Role] = vaultToken{
+		token:  resp.Auth.ClientToken,
+		expiry: time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second),
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// do sends a request to the Vault API and decodes the response
+func (v *VaultProvider) do(ctx context.Context, method, path, token string, body []byte) (*vaultResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, method, v.address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := &vaultResponse{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, result); err != nil {
+			return nil, fmt.Errorf("unable decode vault response, got: %w", err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault responded with status %d: %s", resp.StatusCode, strings.Join(result.Errors, ", "))
+	}
+	return result, nil
+}
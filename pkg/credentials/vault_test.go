@@ -0,0 +1,241 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testVaultToken = "s.vault-token"
+	testLoginToken = "s.login-token"
+	testJWT        = "service-account-jwt"
+)
+
+// vaultServer serves the secret at /v1/secret/frp and the kubernetes login at /v1/auth/kubernetes/login, the
+// secret is only served to the requests carrying the Vault token of the auth method. It counts the logins.
+func vaultServer(secret map[string]interface{}, logins *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/kubernetes/login":
+			body := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["role"] != "frp" || body["jwt"] != testJWT {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			logins.Add(1)
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"` + testLoginToken + `","lease_duration":3600}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/frp":
+			if token := r.Header.Get("X-Vault-Token"); token != testVaultToken && token != testLoginToken {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"lease_duration": 60, "data": secret})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestVaultProviderResolve(t *testing.T) {
+	fields := map[string]interface{}{
+		KeyToken:                    "frp-token",
+		KeyOIDCClientSecret:         "oidc-secret",
+		v1beta1.DefaultCertFileName: "cert",
+		"ignored":                   "value",
+		"number":                    1,
+	}
+	want := &Credentials{
+		Token:            "frp-token",
+		OIDCClientSecret: "oidc-secret",
+		TLSData:          map[string][]byte{v1beta1.DefaultCertFileName: []byte("cert")},
+		TTL:              time.Minute,
+	}
+	tests := []struct {
+		name       string
+		secret     map[string]interface{}
+		ref        v1beta1.FrpServerVaultRef
+		vaultToken string
+		want       *Credentials
+		wantErr    string
+		wantLogins int32
+	}{
+		{
+			name:       "kv v1 with the token auth method",
+			secret:     fields,
+			ref:        v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodToken},
+			vaultToken: testVaultToken,
+			want:       want,
+		},
+		{
+			name:       "kv v2 with the token auth method",
+			secret:     map[string]interface{}{"data": fields, "metadata": map[string]interface{}{"version": 1}},
+			ref:        v1beta1.FrpServerVaultRef{Path: "/secret/frp", AuthMethod: VaultAuthMethodToken},
+			vaultToken: testVaultToken,
+			want:       want,
+		},
+		{
+			name:   "kv v1 secret holding a data key",
+			secret: map[string]interface{}{"data": map[string]interface{}{KeyToken: "nested"}, KeyToken: "frp-token"},
+			ref:    v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodToken},
+			want: &Credentials{
+				Token:   "frp-token",
+				TLSData: map[string][]byte{},
+				TTL:     time.Minute,
+			},
+			vaultToken: testVaultToken,
+		},
+		{
+			name:    "token auth method without the token",
+			secret:  fields,
+			ref:     v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodToken},
+			wantErr: "VAULT_TOKEN is not set",
+		},
+		{
+			name:       "token auth method denied",
+			secret:     fields,
+			ref:        v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodToken},
+			vaultToken: "s.other",
+			wantErr:    "permission denied",
+		},
+		{
+			name:       "kubernetes auth method",
+			secret:     fields,
+			ref:        v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodKubernetes, Role: "frp"},
+			want:       want,
+			wantLogins: 1,
+		},
+		{
+			name:    "kubernetes auth method with an unknown role",
+			secret:  fields,
+			ref:     v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodKubernetes, Role: "other"},
+			wantErr: "unable login to vault",
+		},
+		{
+			name:       "missing secret",
+			secret:     fields,
+			ref:        v1beta1.FrpServerVaultRef{Path: "secret/other", AuthMethod: VaultAuthMethodToken},
+			vaultToken: testVaultToken,
+			wantErr:    "status 404",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(vaultTokenEnvKeyName, tt.vaultToken)
+			logins := &atomic.Int32{}
+			srv := vaultServer(tt.secret, logins)
+			defer srv.Close()
+			p := NewVaultProvider(srv.URL+"/", "/kubernetes/")
+			p.tokenPath = filepath.Join(t.TempDir(), "token")
+			if err := os.WriteFile(p.tokenPath, []byte(testJWT), 0o600); err != nil {
+				t.Fatalf("unable write service account token, got: %v", err)
+			}
+			obj := &v1beta1.FrpServer{Spec: v1beta1.FrpServerSpec{VaultRef: &tt.ref}}
+			// the second resolve reuses the cached login token of the kubernetes auth method
+			for i := 0; i < 2; i++ {
+				got, err := p.Resolve(context.Background(), obj)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("Resolve() error = %v, want error containing %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Resolve() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("Resolve() = %+v, want %+v", got, tt.want)
+				}
+			}
+			if got := logins.Load(); got != tt.wantLogins {
+				t.Fatalf("Resolve() logged in %d times, want %d", got, tt.wantLogins)
+			}
+		})
+	}
+}
+
+func TestResolverResolve(t *testing.T) {
+	vaultRef := &v1beta1.FrpServerVaultRef{Path: "secret/frp", AuthMethod: VaultAuthMethodToken}
+	tests := []struct {
+		name     string
+		resolver *Resolver
+		obj      *v1beta1.FrpServer
+		want     *Credentials
+		wantErr  string
+	}{
+		{
+			name:     "no references",
+			resolver: &Resolver{Vault: &staticProvider{}},
+			obj:      &v1beta1.FrpServer{},
+		},
+		{
+			name:     "vault reference with the vault provider",
+			resolver: &Resolver{Vault: &staticProvider{creds: &Credentials{Token: "frp-token"}}},
+			obj:      &v1beta1.FrpServer{Spec: v1beta1.FrpServerSpec{VaultRef: vaultRef}},
+			want:     &Credentials{Token: "frp-token"},
+		},
+		{
+			name:     "vault reference without the vault provider",
+			resolver: &Resolver{},
+			obj:      &v1beta1.FrpServer{Spec: v1beta1.FrpServerSpec{VaultRef: vaultRef}},
+			wantErr:  "no credentials provider configured for 'vault'",
+		},
+		{
+			name:    "vault reference with a nil resolver",
+			obj:     &v1beta1.FrpServer{Spec: v1beta1.FrpServerSpec{VaultRef: vaultRef}},
+			wantErr: "no credentials provider configured for 'vault'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolver.Resolve(context.Background(), nil, tt.obj)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// staticProvider is a Provider returning its credentials
+type staticProvider struct {
+	creds *Credentials
+}
+
+func (p *staticProvider) Resolve(_ context.Context, _ *v1beta1.FrpServer) (*Credentials, error) {
+	return p.creds, nil
+}
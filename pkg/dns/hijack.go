@@ -0,0 +1,161 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+const (
+	// ModeHosts maps the published subdomains to the service ClusterIPs with the CoreDNS hosts plugin
+	ModeHosts = "hosts"
+	// ModeRewrite rewrites the published subdomains to the service cluster DNS names with the CoreDNS rewrite plugin
+	ModeRewrite = "rewrite"
+
+	defaultMode          = ModeHosts
+	defaultClusterDomain = "cluster.local"
+)
+
+// Modes are the supported output modes of the generator
+var Modes = []string{ModeHosts, ModeRewrite}
+
+// HijackOptions contains the configuration of the generated CoreDNS snippet
+type HijackOptions struct {
+	// Mode is the CoreDNS plugin used to hijack the published subdomains, one of hosts or rewrite
+	Mode string `json:"mode"`
+	// ClusterDomain is the DNS domain of the cluster, used by the rewrite mode
+	ClusterDomain string `json:"clusterDomain"`
+	// Namespace restricts the services to a namespace, empty matches all namespaces
+	Namespace string `json:"namespace"`
+}
+
+// SetDefaults set default values for hijack options
+func (o *HijackOptions) SetDefaults() {
+	o.Mode = util.EmptyOr(o.Mode, defaultMode)
+	o.ClusterDomain = util.EmptyOr(o.ClusterDomain, defaultClusterDomain)
+}
+
+// Validate validates the hijack options
+func (o *HijackOptions) Validate() (err error) {
+	if !lo.Contains(Modes, o.Mode) {
+		err = errors.Join(err, fmt.Errorf("invalid mode, optional values are %+v", Modes))
+	}
+	if o.ClusterDomain == "" {
+		err = errors.Join(err, fmt.Errorf("cluster domain is required"))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *HijackOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Mode, "mode", o.Mode, "Is the CoreDNS plugin used to hijack the published subdomains, one of hosts or rewrite.")
+	fs.StringVar(&o.ClusterDomain, "cluster-domain", o.ClusterDomain, "Is the DNS domain of the cluster, used by the rewrite mode.")
+	fs.StringVar(&o.Namespace, "namespace", o.Namespace, "Restricts the services to a namespace, empty matches all namespaces.")
+}
+
+// Entry maps a published hostname back to the service exposing it
+type Entry struct {
+	Hostname  string
+	Namespace string
+	Service   string
+	ClusterIP string
+}
+
+// Entries returns the published hostnames of the http proxies exposed by services, sorted by hostname.
+// Services without a ClusterIP or a published hostname on the domains of their FrpServer are skipped, the
+// subdomain of services using a bound FrpServerClaim is prefixed with the claim subdomain prefix, the
+// subdomain renamed by a naming conflict is used when recorded.
+func Entries(o *HijackOptions, services []v1.Service, servers []v1beta1.FrpServer, claims []v1beta1.FrpServerClaim) []Entry {
+	serversByName := make(map[string]*v1beta1.FrpServer, len(servers))
+	for i := range servers {
+		serversByName[servers[i].Name] = &servers[i]
+	}
+	boundClaims := make(map[string]*v1beta1.FrpServerClaim, len(claims))
+	for i := range claims {
+		if claims[i].Status.Phase == v1beta1.FrpServerClaimPhaseBound {
+			boundClaims[claims[i].Namespace+"/"+claims[i].Name] = &claims[i]
+		}
+	}
+	entries := make([]Entry, 0)
+	for _, svc := range services {
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer || (o.Namespace != "" && svc.Namespace != o.Namespace) {
+			continue
+		}
+		if svc.Annotations[v1beta1.AnnotationProxyTypeKey] != v1beta1.ProxyTypeHTTP {
+			continue
+		}
+		subdomain := svc.Annotations[v1beta1.AnnotationSubdomainKey]
+		server := serversByName[svc.Annotations[v1beta1.AnnotationFrpServerNameKey]]
+		if claimName := svc.Annotations[v1beta1.AnnotationFrpServerClaimNameKey]; claimName != "" {
+			claim, ok := boundClaims[svc.Namespace+"/"+claimName]
+			if !ok {
+				continue
+			}
+			subdomain, server = claim.Subdomain(subdomain), serversByName[claim.Spec.ServerName]
+		}
+		// a naming conflict renamed the subdomain, the claim prefix is already applied
+		if effective, ok := svc.Annotations[v1beta1.AnnotationEffectiveSubdomainKey]; ok {
+			subdomain = effective
+		}
+		if server == nil || svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == v1.ClusterIPNone {
+			continue
+		}
+		domain, err := frpclient.ServiceDomain(server, &svc)
+		if err != nil {
+			continue
+		}
+		hostname := frpclient.Hostname(domain, subdomain)
+		if hostname == "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Hostname:  hostname,
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+			ClusterIP: svc.Spec.ClusterIP,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hostname < entries[j].Hostname
+	})
+	return entries
+}
+
+// Corefile generates the CoreDNS snippet resolving the published hostnames to the services inside the
+// cluster, so in-cluster clients don't hairpin their traffic through the frp server.
+func Corefile(o *HijackOptions, entries []Entry) string {
+	b := &strings.Builder{}
+	switch o.Mode {
+	case ModeRewrite:
+		for _, entry := range entries {
+			fmt.Fprintf(b, "rewrite name exact %s %s.%s.svc.%s\n", entry.Hostname, entry.Service, entry.Namespace, o.ClusterDomain)
+		}
+	default:
+		b.WriteString("hosts {\n")
+		for _, entry := range entries {
+			fmt.Fprintf(b, "    %s %s\n", entry.ClusterIP, entry.Hostname)
+		}
+		b.WriteString("    fallthrough\n}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,374 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package emulator implements a minimal frps for local development and CI, the frp client pods log in,
+// register their proxies and serve tcp traffic without any external frps.
+package emulator
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatedier/frp/pkg/auth"
+	"github.com/fatedier/frp/pkg/msg"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
+	"github.com/fatedier/frp/pkg/util/util"
+	libio "github.com/fatedier/golib/io"
+	fmux "github.com/hashicorp/yamux"
+	"io"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Version is the frps version reported to the frp clients
+	Version = "0.53.2"
+	// handshakeTimeout bounds the time waiting for the first message of a connection
+	handshakeTimeout = 10 * time.Second
+	// workConnTimeout bounds the time a user connection waits for a work connection of the frp client
+	workConnTimeout = 10 * time.Second
+	// maxPoolCount bounds the work connections pooled for a frp client
+	maxPoolCount = 100
+)
+
+// Server is an emulated frps, it accepts the logins of the frp clients, acknowledges their proxies and
+// relays the tcp connections to the remote port of a tcp proxy through the work connections of its client.
+// Only token authentication is supported, tls, kcp, quic and websocket transports are not.
+type Server struct {
+	// BindAddress is the tcp address the emulated frps listens on
+	BindAddress string
+	// Token is the auth token the frp clients log in with
+	Token string
+
+	verifier *auth.TokenAuthSetterVerifier
+	controls sync.Map
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica serves the frp clients
+// pointed at it.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the emulated frps until ctx is done
+func (s *Server) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("frps-emulator")
+	s.verifier = auth.NewTokenAuth(nil, s.Token)
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return fmt.Errorf("unable listen on frps emulator address '%s', got: %w", s.BindAddress, err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	logger.Info("Serving frps emulator", "address", listener.Addr().String())
+	ctx = log.IntoContext(ctx, logger)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("unable accept frps emulator connection, got: %w", err)
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// peekConn replays the byte peeked to tell a multiplexed connection apart
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implements net.Conn
+func (c *peekConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// serveConn serves a connection of a frp client, the frp messages start with their type byte while the
+// yamux frames of a multiplexed connection start with the version byte 0.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	first, err := r.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	conn = &peekConn{Conn: conn, r: r}
+	if first[0] != 0 {
+		s.handleConn(ctx, conn)
+		return
+	}
+	cfg := fmux.DefaultConfig()
+	cfg.LogOutput = io.Discard
+	cfg.MaxStreamWindowSize = 6 * 1024 * 1024
+	session, err := fmux.Server(conn, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go s.handleConn(ctx, stream)
+	}
+}
+
+// handleConn dispatches a connection of a frp client on its first message
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	logger := log.FromContext(ctx)
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	m, err := msg.ReadMsg(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	switch m := m.(type) {
+	case *msg.Login:
+		s.handleLogin(ctx, conn, m)
+	case *msg.NewWorkConn:
+		if err := s.handleWorkConn(conn, m); err != nil {
+			logger.Info("rejected work connection", "runID", m.RunID, "reason", err.Error())
+			_ = conn.Close()
+		}
+	default:
+		logger.Info("unsupported first message of connection", "remoteAddr", conn.RemoteAddr().String())
+		_ = conn.Close()
+	}
+}
+
+// control is the control connection of a logged in frp client
+type control struct {
+	server    *Server
+	runID     string
+	conn      net.Conn
+	rw        io.ReadWriter
+	writeLock sync.Mutex
+	workConns chan net.Conn
+
+	lock    sync.Mutex
+	proxies map[string]net.Listener
+}
+
+// handleLogin verifies the login and serves the control connection of the frp client until it's closed
+func (s *Server) handleLogin(ctx context.Context, conn net.Conn, login *msg.Login) {
+	logger := log.FromContext(ctx)
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err := s.verifier.VerifyLogin(login); err != nil {
+		logger.Info("rejected login", "user", login.User, "reason", err.Error())
+		_ = msg.WriteMsg(conn, &msg.LoginResp{Version: Version, Error: err.Error()})
+		return
+	}
+	runID := login.RunID
+	if runID == "" {
+		runID, _ = util.RandID()
+	}
+	if err := msg.WriteMsg(conn, &msg.LoginResp{Version: Version, RunID: runID}); err != nil {
+		return
+	}
+	rw, err := netpkg.NewCryptoReadWriter(conn, []byte(s.Token))
+	if err != nil {
+		logger.Error(err, "unable create crypto read writer for control conn")
+		return
+	}
+	ctl := &control{
+		server:    s,
+		runID:     runID,
+		conn:      conn,
+		rw:        rw,
+		workConns: make(chan net.Conn, maxPoolCount),
+		proxies:   make(map[string]net.Listener),
+	}
+	if old, loaded := s.controls.Swap(runID, ctl); loaded {
+		// the frp client reconnected with the same run id
+		_ = old.(*control).conn.Close()
+	}
+	defer ctl.close()
+	logger.Info("frp client logged in", "user", login.User, "runID", runID, "remoteAddr", conn.RemoteAddr().String())
+	for i := 0; i < max(min(login.PoolCount, maxPoolCount), 1); i++ {
+		if err := ctl.write(&msg.ReqWorkConn{}); err != nil {
+			return
+		}
+	}
+	for {
+		m, err := msg.ReadMsg(rw)
+		if err != nil {
+			logger.Info("frp client disconnected", "runID", runID)
+			return
+		}
+		switch m := m.(type) {
+		case *msg.NewProxy:
+			resp := &msg.NewProxyResp{ProxyName: m.ProxyName}
+			if resp.RemoteAddr, err = ctl.newProxy(ctx, m); err != nil {
+				resp.Error = err.Error()
+			}
+			logger.Info("registered proxy", "runID", runID, "proxy", m.ProxyName, "type", m.ProxyType, "remoteAddr", resp.RemoteAddr, "error", resp.Error)
+			err = ctl.write(resp)
+		case *msg.CloseProxy:
+			ctl.closeProxy(m.ProxyName)
+		case *msg.Ping:
+			pong := &msg.Pong{}
+			if err := s.verifier.VerifyPing(m); err != nil {
+				pong.Error = err.Error()
+			}
+			err = ctl.write(pong)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleWorkConn pools a work connection of a logged in frp client
+func (s *Server) handleWorkConn(conn net.Conn, m *msg.NewWorkConn) error {
+	v, ok := s.controls.Load(m.RunID)
+	if !ok {
+		return fmt.Errorf("no frp client logged in with run id '%s'", m.RunID)
+	}
+	if err := s.verifier.VerifyNewWorkConn(m); err != nil {
+		_ = msg.WriteMsg(conn, &msg.StartWorkConn{Error: err.Error()})
+		return err
+	}
+	select {
+	case v.(*control).workConns <- conn:
+		return nil
+	default:
+		return errors.New("work connection pool is full")
+	}
+}
+
+func (c *control) write(m msg.Message) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return msg.WriteMsg(c.rw, m)
+}
+
+// newProxy acknowledges a proxy, a tcp proxy is served on its remote port of the host of BindAddress,
+// a free port is picked when the proxy has no remote port. The other proxy types are acknowledged
+// without serving their traffic.
+func (c *control) newProxy(ctx context.Context, m *msg.NewProxy) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.proxies[m.ProxyName]; ok {
+		return "", fmt.Errorf("proxy '%s' is already registered", m.ProxyName)
+	}
+	if m.ProxyType != "tcp" {
+		c.proxies[m.ProxyName] = nil
+		return "", nil
+	}
+	host, _, err := net.SplitHostPort(c.server.BindAddress)
+	if err != nil {
+		return "", err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(m.RemotePort)))
+	if err != nil {
+		return "", fmt.Errorf("unable listen on remote port %d, got: %w", m.RemotePort, err)
+	}
+	c.proxies[m.ProxyName] = listener
+	go func() {
+		for {
+			userConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go c.relay(ctx, m, userConn)
+		}
+	}()
+	return fmt.Sprintf(":%d", listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+// relay joins a user connection of a tcp proxy with a work connection of its frp client
+func (c *control) relay(ctx context.Context, m *msg.NewProxy, userConn net.Conn) {
+	logger := log.FromContext(ctx)
+	defer func() {
+		_ = userConn.Close()
+	}()
+	var workConn net.Conn
+	select {
+	case workConn = <-c.workConns:
+	case <-time.After(workConnTimeout):
+		logger.Info("no work connection available for user connection", "runID", c.runID, "proxy", m.ProxyName)
+		return
+	}
+	// replenish the pool of the frp client
+	_ = c.write(&msg.ReqWorkConn{})
+	src, dst := userConn.RemoteAddr().(*net.TCPAddr), userConn.LocalAddr().(*net.TCPAddr)
+	err := msg.WriteMsg(workConn, &msg.StartWorkConn{
+		ProxyName: m.ProxyName,
+		SrcAddr:   src.IP.String(),
+		SrcPort:   uint16(src.Port),
+		DstAddr:   dst.IP.String(),
+		DstPort:   uint16(dst.Port),
+	})
+	if err != nil {
+		_ = workConn.Close()
+		return
+	}
+	var rwc io.ReadWriteCloser = workConn
+	if m.UseEncryption {
+		if rwc, err = libio.WithEncryption(rwc, []byte(c.server.Token)); err != nil {
+			_ = workConn.Close()
+			return
+		}
+	}
+	if m.UseCompression {
+		rwc = libio.WithCompression(rwc)
+	}
+	libio.Join(userConn, rwc)
+}
+
+func (c *control) closeProxy(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if listener := c.proxies[name]; listener != nil {
+		_ = listener.Close()
+	}
+	delete(c.proxies, name)
+}
+
+// close releases the proxies and the pooled work connections once the control connection is closed
+func (c *control) close() {
+	c.server.controls.CompareAndDelete(c.runID, c)
+	c.lock.Lock()
+	for name, listener := range c.proxies {
+		if listener != nil {
+			_ = listener.Close()
+		}
+		delete(c.proxies, name)
+	}
+	c.lock.Unlock()
+	for {
+		select {
+		case conn := <-c.workConns:
+			_ = conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"net/http"
+	"net/url"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// Types of the CloudEvents emitted for the provisioning decisions
+const (
+	// TypeTunnelCreated is emitted once a service is exposed through a frp tunnel
+	TypeTunnelCreated = "io.gofrp.frp.tunnel.created"
+	// TypeTunnelDeleted is emitted once the frp tunnel of a service is removed
+	TypeTunnelDeleted = "io.gofrp.frp.tunnel.deleted"
+	// TypeTunnelServerSwitched is emitted once the frp tunnel of a service moves to another FrpServer
+	TypeTunnelServerSwitched = "io.gofrp.frp.tunnel.server_switched"
+
+	// CloudEventSource is the source attribute of the emitted CloudEvents
+	CloudEventSource = "/frp-provisioner"
+	// cloudEventsSpecVersion is the version of the CloudEvents specification the events follow
+	cloudEventsSpecVersion = "1.0"
+)
+
+// TunnelEvent is the data of the tunnel CloudEvents
+type TunnelEvent struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// Server is the FrpServer the tunnel is scheduled on, empty when not known
+	Server string `json:"server,omitempty"`
+	// PreviousServer is the FrpServer the tunnel was scheduled on before a server switch
+	PreviousServer string `json:"previousServer,omitempty"`
+}
+
+// CloudEvent is a CloudEvent queued for the sink
+type CloudEvent struct {
+	ID      string
+	Type    string
+	Subject string
+	Time    time.Time
+	Data    any
+}
+
+// CloudEventSink posts the provisioning decisions as CloudEvents in the binary content mode of the http
+// protocol binding, so event driven platforms, e.g. a CMDB, don't have to watch the Kubernetes API.
+type CloudEventSink struct {
+	// URL is the sink the events are posted to
+	URL string
+
+	queue chan *CloudEvent
+}
+
+// NewCloudEventSink returns the CloudEventSink posting the events to sinkURL
+func NewCloudEventSink(sinkURL string) (*CloudEventSink, error) {
+	if _, err := url.ParseRequestURI(sinkURL); err != nil {
+		return nil, fmt.Errorf("invalid cloudevents sink '%s', got: %w", sinkURL, err)
+	}
+	return &CloudEventSink{URL: sinkURL, queue: make(chan *CloudEvent, queueSize)}, nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the events are emitted by the controllers
+// which only run on the leader
+func (s *CloudEventSink) NeedLeaderElection() bool {
+	return true
+}
+
+// Start posts the queued events until ctx is done
+func (s *CloudEventSink) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("cloudevent-sink")
+	httpClient := &http.Client{Timeout: postTimeout}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-s.queue:
+			if err := s.post(ctx, httpClient, event); err != nil {
+				metrics.CloudEventsTotal.WithLabelValues(event.Type, "failed").Inc()
+				logger.Error(err, "unable post cloudevent to sink", "type", event.Type, "subject", event.Subject)
+				continue
+			}
+			metrics.CloudEventsTotal.WithLabelValues(event.Type, "sent").Inc()
+		}
+	}
+}
+
+// Emit queues a CloudEvent, it never blocks the controller emitting the event. A nil sink drops the event,
+// so the controllers don't have to check whether the sink is configured.
+func (s *CloudEventSink) Emit(eventType, subject string, data any) {
+	if s == nil {
+		return
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	event := &CloudEvent{ID: hex.EncodeToString(id), Type: eventType, Subject: subject, Time: time.Now(), Data: data}
+	select {
+	case s.queue <- event:
+	default:
+		metrics.CloudEventsTotal.WithLabelValues(eventType, "dropped").Inc()
+	}
+}
+
+// post sends the event with its attributes as ce- headers and its data as the json body
+func (s *CloudEventSink) post(ctx context.Context, httpClient *http.Client, event *CloudEvent) error {
+	body, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", cloudEventsSpecVersion)
+	req.Header.Set("ce-id", event.ID)
+	req.Header.Set("ce-type", event.Type)
+	req.Header.Set("ce-source", CloudEventSource)
+	req.Header.Set("ce-subject", event.Subject)
+	req.Header.Set("ce-time", event.Time.UTC().Format(time.RFC3339Nano))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sink responded with status %s", resp.Status)
+	}
+	return nil
+}
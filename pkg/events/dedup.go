@@ -0,0 +1,180 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AnnotationIdempotencyKey is the event annotation holding the idempotency key of the event, the events of an
+	// object with the same type, reason and message share it
+	AnnotationIdempotencyKey = "frp.gofrp.io/idempotency-key"
+
+	// pruneInterval is the interval the expired entries of the deduplicator are dropped at
+	pruneInterval = time.Minute
+)
+
+// Deduplicator suppresses the events which repeat within the Window and rate limits the events of each object,
+// so high churn reconciles don't flood the API server. A suppressed event is aggregated into the next event
+// emitted with the same idempotency key once the Window passed, its message reports the repeats, the same way
+// the kubelet combines similar events.
+type Deduplicator struct {
+	// Window is the time an event suppresses its duplicates, zero disables the deduplication
+	Window time.Duration
+	// PerObjectRate is the number of events recorded per minute for an object, the burst is the same number.
+	// Zero disables the rate limit.
+	PerObjectRate int
+
+	mu        sync.Mutex
+	seen      map[string]*seenEvent
+	limiters  map[string]*objectLimiter
+	lastPrune time.Time
+}
+
+// seenEvent is the last emission of an idempotency key
+type seenEvent struct {
+	emitted    time.Time
+	suppressed int
+}
+
+// objectLimiter is the rate limit of the events of an object
+type objectLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+// IdempotencyKey returns the deterministic key of an event of the component, it's derived from the object, the
+// type, the reason and the message, so the key of a repeated event doesn't depend on when it's emitted
+func IdempotencyKey(component string, object runtime.Object, eventtype, reason, message string) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(strings.Join([]string{component, objectKey(object), eventtype, reason, message}, "\x00")))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// objectKey identifies the object of an event
+func objectKey(object runtime.Object) string {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return fmt.Sprintf("%T", object)
+	}
+	return object.GetObjectKind().GroupVersionKind().Kind + "/" + accessor.GetNamespace() + "/" + accessor.GetName() + "/" + string(accessor.GetUID())
+}
+
+// admit decides whether an event is recorded, it returns the message of the recorded event, which reports the
+// repeats of the event suppressed since its last emission
+func (d *Deduplicator) admit(key, object, message string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.prune(now)
+	if d.Window > 0 {
+		if seen, ok := d.seen[key]; ok && now.Sub(seen.emitted) < d.Window {
+			seen.suppressed++
+			metrics.SuppressedEventsTotal.WithLabelValues("duplicate").Inc()
+			return "", false
+		}
+	}
+	if d.PerObjectRate > 0 {
+		limiter, ok := d.limiters[object]
+		if !ok {
+			limiter = &objectLimiter{Limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(d.PerObjectRate)), d.PerObjectRate)}
+			d.limiters[object] = limiter
+		}
+		limiter.lastSeen = now
+		if !limiter.AllowN(now, 1) {
+			metrics.SuppressedEventsTotal.WithLabelValues("rate_limited").Inc()
+			return "", false
+		}
+	}
+	if d.Window > 0 {
+		if seen, ok := d.seen[key]; ok && seen.suppressed > 0 {
+			message = fmt.Sprintf("%s (repeated %d times in the last %s)", message, seen.suppressed+1, now.Sub(seen.emitted).Round(time.Second))
+		}
+		d.seen[key] = &seenEvent{emitted: now}
+	}
+	return message, true
+}
+
+// prune drops the entries which no longer suppress or limit an event
+func (d *Deduplicator) prune(now time.Time) {
+	if d.seen == nil {
+		d.seen, d.limiters, d.lastPrune = make(map[string]*seenEvent), make(map[string]*objectLimiter), now
+	}
+	if now.Sub(d.lastPrune) < pruneInterval {
+		return
+	}
+	d.lastPrune = now
+	for key, seen := range d.seen {
+		// the suppressed repeats are reported by the next emission, they're dropped with the entry
+		if now.Sub(seen.emitted) >= d.Window {
+			delete(d.seen, key)
+		}
+	}
+	for object, limiter := range d.limiters {
+		// an idle limiter refilled its burst, a new one is equivalent
+		if now.Sub(limiter.lastSeen) >= time.Minute {
+			delete(d.limiters, object)
+		}
+	}
+}
+
+// Recorder returns an EventRecorder which records the admitted events of the component with recorder, the
+// recorded events are annotated with their idempotency key
+func (d *Deduplicator) Recorder(component string, recorder record.EventRecorder) record.EventRecorder {
+	return &dedupRecorder{recorder: recorder, component: component, deduplicator: d}
+}
+
+// dedupRecorder is a record.EventRecorder recording the events admitted by the Deduplicator
+type dedupRecorder struct {
+	recorder     record.EventRecorder
+	component    string
+	deduplicator *Deduplicator
+}
+
+// Event implements record.EventRecorder
+func (r *dedupRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+// Eventf implements record.EventRecorder
+func (r *dedupRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf implements record.EventRecorder
+func (r *dedupRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	key := IdempotencyKey(r.component, object, eventtype, reason, message)
+	message, ok := r.deduplicator.admit(key, objectKey(object), message)
+	if !ok {
+		return
+	}
+	merged := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	merged[AnnotationIdempotencyKey] = key
+	r.recorder.AnnotatedEventf(object, merged, eventtype, reason, "%s", message)
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"text/template"
+	"time"
+)
+
+// Format is the payload format of the event webhook
+type Format string
+
+const (
+	// FormatGeneric posts the Event with the rendered text as json
+	FormatGeneric Format = "generic"
+	// FormatSlack posts a Slack incoming webhook message
+	FormatSlack Format = "slack"
+	// FormatTeams posts a Microsoft Teams incoming webhook message
+	FormatTeams Format = "teams"
+
+	// DefaultTemplate renders the text of a forwarded event
+	DefaultTemplate = "[{{.Reason}}] {{.Kind}} {{if .Namespace}}{{.Namespace}}/{{end}}{{.Name}}: {{.Message}}"
+
+	// queueSize bounds the events waiting to be posted, events are dropped once it's full
+	queueSize = 100
+	// postTimeout bounds the time posting an event to the webhook
+	postTimeout = 10 * time.Second
+)
+
+// Event is a Warning event of the provisioner forwarded to the webhook, the template renders its fields
+type Event struct {
+	Component string    `json:"component"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	// Text is the event rendered by the template
+	Text string `json:"text"`
+}
+
+// Forwarder relays the Warning events of the provisioner to a webhook, e.g. a Slack or Teams channel. The
+// events beyond the rate limit are dropped, a burst of failures must not flood the channel.
+type Forwarder struct {
+	// URL is the webhook the events are posted to
+	URL string
+	// Format is the payload format of the webhook
+	Format Format
+	// Template renders the text of an event, DefaultTemplate is used when empty
+	Template string
+	// RateLimit is the number of events forwarded per minute
+	RateLimit int
+	// Scheme resolves the kind of the objects of the events
+	Scheme *runtime.Scheme
+
+	queue chan *Event
+	tmpl  *template.Template
+}
+
+// NewForwarder returns the Forwarder posting the events to url, the template is parsed upfront
+func NewForwarder(url string, format Format, tmpl string, rateLimit int, scheme *runtime.Scheme) (*Forwarder, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	t, err := template.New("event").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("unable parse event webhook template, got: %w", err)
+	}
+	return &Forwarder{
+		URL:       url,
+		Format:    format,
+		Template:  tmpl,
+		RateLimit: rateLimit,
+		Scheme:    scheme,
+		queue:     make(chan *Event, queueSize),
+		tmpl:      t,
+	}, nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica forwards the events it emits
+func (f *Forwarder) NeedLeaderElection() bool {
+	return false
+}
+
+// Start posts the queued events until ctx is done
+func (f *Forwarder) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("event-forwarder")
+	limiter := rate.NewLimiter(rate.Every(time.Minute/time.Duration(max(f.RateLimit, 1))), max(f.RateLimit, 1))
+	httpClient := &http.Client{Timeout: postTimeout}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-f.queue:
+			if !limiter.Allow() {
+				metrics.ForwardedEventsTotal.WithLabelValues("dropped").Inc()
+				continue
+			}
+			if err := f.post(ctx, httpClient, event); err != nil {
+				metrics.ForwardedEventsTotal.WithLabelValues("failed").Inc()
+				logger.Error(err, "unable forward event to webhook", "reason", event.Reason, "name", event.Name)
+				continue
+			}
+			metrics.ForwardedEventsTotal.WithLabelValues("sent").Inc()
+		}
+	}
+}
+
+// post renders the event and posts it in the format of the webhook
+func (f *Forwarder) post(ctx context.Context, httpClient *http.Client, event *Event) error {
+	text := &bytes.Buffer{}
+	if err := f.tmpl.Execute(text, event); err != nil {
+		return fmt.Errorf("unable render event, got: %w", err)
+	}
+	event.Text = text.String()
+	var payload any = event
+	if f.Format == FormatSlack || f.Format == FormatTeams {
+		payload = map[string]string{"text": event.Text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// enqueue queues a Warning event of the object, it never blocks the controller emitting the event
+func (f *Forwarder) enqueue(component string, object runtime.Object, eventtype, reason, message string) {
+	if eventtype != v1.EventTypeWarning {
+		return
+	}
+	event := &Event{Component: component, Reason: reason, Message: message, Time: time.Now()}
+	if accessor, err := meta.Accessor(object); err == nil {
+		event.Namespace, event.Name = accessor.GetNamespace(), accessor.GetName()
+	}
+	event.Kind = object.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(object, f.Scheme); event.Kind == "" && err == nil {
+		event.Kind = gvk.Kind
+	}
+	select {
+	case f.queue <- event:
+	default:
+		metrics.ForwardedEventsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Recorder returns an EventRecorder which records the events with recorder and forwards the Warning ones
+func (f *Forwarder) Recorder(component string, recorder record.EventRecorder) record.EventRecorder {
+	return &forwardingRecorder{EventRecorder: recorder, component: component, forwarder: f}
+}
+
+// forwardingRecorder is a record.EventRecorder forwarding the Warning events it records
+type forwardingRecorder struct {
+	record.EventRecorder
+	component string
+	forwarder *Forwarder
+}
+
+// Event implements record.EventRecorder
+func (r *forwardingRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, message)
+	r.forwarder.enqueue(r.component, object, eventtype, reason, message)
+}
+
+// Eventf implements record.EventRecorder
+func (r *forwardingRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	r.forwarder.enqueue(r.component, object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf implements record.EventRecorder
+func (r *forwardingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	r.forwarder.enqueue(r.component, object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// LogRecorder is a record.EventRecorder writing the events to the log instead of creating Event objects, the
+// manager records its events with it in the observe mode, which never mutates the cluster.
+type LogRecorder struct {
+	// Component is the component recording the events
+	Component string
+}
+
+var _ record.EventRecorder = &LogRecorder{}
+
+// Event implements record.EventRecorder
+func (r *LogRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+// Eventf implements record.EventRecorder
+func (r *LogRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf implements record.EventRecorder
+func (r *LogRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	keysAndValues := []any{"type", eventtype, "reason", reason, "kind", fmt.Sprintf("%T", object)}
+	if accessor, err := meta.Accessor(object); err == nil {
+		keysAndValues = append(keysAndValues, "namespace", accessor.GetNamespace(), "name", accessor.GetName())
+	}
+	log.Log.WithName("events").WithValues("component", r.Component).Info(fmt.Sprintf(messageFmt, args...), keysAndValues...)
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package frpcinit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/spf13/pflag"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+const (
+	// Path is the path of the manager webhook server serving the credentials of the rendered frpc config
+	Path = "/frpc-credentials"
+	// Audience is the audience of the projected service account token authenticating the frp client pods
+	Audience = "frp-provisioner"
+	// CAFileName is the key of the CA bundle verifying the manager webhook server in the frpc config ConfigMap
+	CAFileName = "ca.crt"
+
+	// TemplateVolumeName is the name of the volume of the frpc config ConfigMap read by the init container
+	TemplateVolumeName = "frpc-template"
+	// TemplateMountPath is where the frpc config ConfigMap is mounted in the init container
+	TemplateMountPath = "/etc/frp/template"
+	// TokenVolumeName is the name of the projected service account token volume of the init container
+	TokenVolumeName = "frpc-init-token"
+	// TokenMountPath is where the projected service account token is mounted in the init container
+	TokenMountPath = "/var/run/secrets/frp-provisioner"
+	// TokenFileName is the file of the projected service account token
+	TokenFileName = "token"
+	// ContainerName is the name of the init container writing the frpc config of the frp client pods
+	ContainerName = "frpc-init"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// Credentials holds the credentials of the rendered frpc config of a service, an empty field leaves its
+// env template in place
+type Credentials struct {
+	Token            string `json:"token,omitempty"`
+	OIDCClientSecret string `json:"oidcClientSecret,omitempty"`
+	SecretKey        string `json:"secretKey,omitempty"`
+	// TLS holds the transport tls material keyed by v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName and
+	// v1beta1.DefaultCaFileName, it's written next to the frpc config
+	TLS map[string][]byte `json:"tls,omitempty"`
+}
+
+// EnvTemplate returns the frpc config template reading the env var, the credentials never land in the ConfigMap
+func EnvTemplate(name string) string {
+	return "{{ .Envs." + name + " }}"
+}
+
+// Options contains the configuration of the init container of the frp client pods
+type Options struct {
+	// URL is the address of the manager endpoint serving the credentials
+	URL string `json:"url"`
+	// CAFile is the CA bundle verifying the manager webhook server
+	CAFile string `json:"caFile"`
+	// TokenFile is the projected service account token authenticating the pod
+	TokenFile string `json:"tokenFile"`
+	// TemplateFile is the rendered frpc config reading the credentials from env templates
+	TemplateFile string `json:"templateFile"`
+	// Output is where the frpc config holding the credentials is written
+	Output string `json:"output"`
+	// Timeout is how long to wait for the manager
+	Timeout time.Duration `json:"timeout"`
+}
+
+// SetDefaults set default values for frpc-init options
+func (o *Options) SetDefaults() {
+	o.CAFile = util.EmptyOr(o.CAFile, path.Join(TemplateMountPath, CAFileName))
+	o.TokenFile = util.EmptyOr(o.TokenFile, path.Join(TokenMountPath, TokenFileName))
+	o.TemplateFile = util.EmptyOr(o.TemplateFile, path.Join(TemplateMountPath, v1beta1.FrpcConfigFileName))
+	o.Output = util.EmptyOr(o.Output, path.Join(v1beta1.FrpcConfigMountPath, v1beta1.FrpcConfigFileName))
+	o.Timeout = util.EmptyOr(o.Timeout, defaultTimeout)
+}
+
+// Validate validates the frpc-init options
+func (o *Options) Validate() (err error) {
+	if !strings.HasPrefix(o.URL, "https://") {
+		err = errors.Join(err, fmt.Errorf("invalid url '%s', expected an https url", o.URL))
+	}
+	if o.Timeout <= 0 {
+		err = errors.Join(err, fmt.Errorf("invalid timeout %s, expected a positive duration", o.Timeout))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.URL, "url", o.URL, "Is the address of the manager endpoint serving the frpc credentials.")
+	fs.StringVar(&o.CAFile, "ca-file", o.CAFile, "Is the CA bundle verifying the manager webhook server.")
+	fs.StringVar(&o.TokenFile, "token-file", o.TokenFile, "Is the projected service account token authenticating the pod.")
+	fs.StringVar(&o.TemplateFile, "template", o.TemplateFile, "Is the rendered frpc config reading the credentials from env templates.")
+	fs.StringVar(&o.Output, "output", o.Output, "Is where the frpc config holding the credentials is written.")
+	fs.DurationVar(&o.Timeout, "timeout", o.Timeout, "Is how long to wait for the manager.")
+}
+
+// Run fetches the credentials of the pod from the manager and writes the frpc config holding them and the transport
+// tls files it reads, the kubelet restarts the init container with a backoff until the manager serves them.
+func Run(ctx context.Context, o *Options) error {
+	data, err := os.ReadFile(o.TemplateFile)
+	if err != nil {
+		return fmt.Errorf("unable read frpc config template, got: %w", err)
+	}
+	creds, err := fetch(ctx, o)
+	if err != nil {
+		return err
+	}
+	if data, err = Inject(data, creds); err != nil {
+		return err
+	}
+	for _, name := range []string{v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName, v1beta1.DefaultCaFileName} {
+		if file, ok := creds.TLS[name]; ok {
+			if err := os.WriteFile(filepath.Join(filepath.Dir(o.Output), name), file, 0o600); err != nil {
+				return fmt.Errorf("unable write transport tls file '%s', got: %w", name, err)
+			}
+		}
+	}
+	if err := os.WriteFile(o.Output, data, 0o600); err != nil {
+		return fmt.Errorf("unable write frpc config, got: %w", err)
+	}
+	return nil
+}
+
+// fetch requests the credentials of the pod authenticated by its projected service account token
+func fetch(ctx context.Context, o *Options) (*Credentials, error) {
+	token, err := os.ReadFile(o.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable read service account token, got: %w", err)
+	}
+	ca, err := os.ReadFile(o.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable read ca bundle, got: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in ca bundle '%s'", o.CAFile)
+	}
+	cli := &http.Client{
+		Timeout:   o.Timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable request frpc credentials, got: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unable request frpc credentials, got: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	creds := &Credentials{}
+	if err := json.NewDecoder(resp.Body).Decode(creds); err != nil {
+		return nil, fmt.Errorf("unable decode frpc credentials, got: %w", err)
+	}
+	return creds, nil
+}
+
+// config is the frpc config marshalled like the manager renders it, the proxies carry their type
+type config struct {
+	configv1.ClientCommonConfig
+	Proxies []configv1.ProxyConfigurer `json:"proxies,omitempty"`
+}
+
+// Inject replaces the env templates of the auth token, the oidc client secret and the stcp/xtcp/sudp secret key
+// in the rendered frpc config with the credentials, the other env templates are left to frpc.
+func Inject(data []byte, creds *Credentials) ([]byte, error) {
+	in := &configv1.ClientConfig{}
+	if err := yaml.Unmarshal(data, in); err != nil {
+		return nil, fmt.Errorf("unable parse frpc config template, got: %w", err)
+	}
+	replace := func(value *string, env, secret string) {
+		if secret != "" && *value == EnvTemplate(env) {
+			*value = secret
+		}
+	}
+	replace(&in.Auth.Token, v1beta1.InlineServerTokenEnv, creds.Token)
+	replace(&in.Auth.OIDC.ClientSecret, v1beta1.OIDCClientSecretEnv, creds.OIDCClientSecret)
+	out := &config{ClientCommonConfig: in.ClientCommonConfig}
+	for _, proxy := range in.Proxies {
+		switch cfg := proxy.ProxyConfigurer.(type) {
+		case *configv1.STCPProxyConfig:
+			replace(&cfg.Secretkey, v1beta1.SecretKeyEnv, creds.SecretKey)
+		case *configv1.XTCPProxyConfig:
+			replace(&cfg.Secretkey, v1beta1.SecretKeyEnv, creds.SecretKey)
+		case *configv1.SUDPProxyConfig:
+			replace(&cfg.Secretkey, v1beta1.SecretKeyEnv, creds.SecretKey)
+		}
+		out.Proxies = append(out.Proxies, proxy.ProxyConfigurer)
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("unable marshal frpc config, got: %w", err)
+	}
+	return data, nil
+}
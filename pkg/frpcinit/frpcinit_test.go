@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package frpcinit
+
+import (
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"sigs.k8s.io/yaml"
+	"testing"
+)
+
+func TestInject(t *testing.T) {
+	template := `auth:
+  token: '{{ .Envs.FRP_AUTH_TOKEN }}'
+proxies:
+- name: web
+  type: http
+  localPort: 80
+  httpUser: '{{ .Envs.FRP_HTTP_USER }}'
+- name: ssh
+  type: stcp
+  localPort: 22
+  secretKey: '{{ .Envs.FRP_SECRET_KEY }}'
+`
+	tests := []struct {
+		name          string
+		creds         *Credentials
+		wantToken     string
+		wantSecretKey string
+	}{
+		{
+			name:          "credentials are filled in",
+			creds:         &Credentials{Token: "t0ken", SecretKey: "s3cret"},
+			wantToken:     "t0ken",
+			wantSecretKey: "s3cret",
+		},
+		{
+			name:          "empty credentials keep the env templates",
+			creds:         &Credentials{},
+			wantToken:     EnvTemplate(v1beta1.InlineServerTokenEnv),
+			wantSecretKey: EnvTemplate(v1beta1.SecretKeyEnv),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Inject([]byte(template), tt.creds)
+			if err != nil {
+				t.Fatalf("Inject() error = %v", err)
+			}
+			got := &configv1.ClientConfig{}
+			if err := yaml.Unmarshal(data, got); err != nil {
+				t.Fatalf("unable parse injected config, got: %v", err)
+			}
+			if got.Auth.Token != tt.wantToken {
+				t.Fatalf("token = %q, want %q", got.Auth.Token, tt.wantToken)
+			}
+			if len(got.Proxies) != 2 {
+				t.Fatalf("got %d proxies, want 2", len(got.Proxies))
+			}
+			http, ok := got.Proxies[0].ProxyConfigurer.(*configv1.HTTPProxyConfig)
+			if !ok || http.HTTPUser != EnvTemplate(v1beta1.HTTPUserEnv) {
+				t.Fatalf("http proxy = %+v, want the http user env template kept", got.Proxies[0].ProxyConfigurer)
+			}
+			stcp, ok := got.Proxies[1].ProxyConfigurer.(*configv1.STCPProxyConfig)
+			if !ok || stcp.Secretkey != tt.wantSecretKey {
+				t.Fatalf("stcp proxy = %+v, want secret key %q", got.Proxies[1].ProxyConfigurer, tt.wantSecretKey)
+			}
+		})
+	}
+}
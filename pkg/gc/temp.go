@@ -0,0 +1,176 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+// DefaultTempFileTTL is the age after which a temp file of a login is considered leaked
+const DefaultTempFileTTL = time.Hour
+
+// maxLegacyTempFileSize bounds the size of the legacy temp files read for their PEM block
+const maxLegacyTempFileSize = 64 << 10
+
+var (
+	// tempFilePattern matches the temp files holding the transport tls material of a login
+	tempFilePattern = regexp.MustCompile(`^` + regexp.QuoteMeta(frpclient.TempFilePrefix) + `(cert|key|ca)-\d+$`)
+	// legacyTempFilePattern matches the "{cert,key,ca}{random}" temp files written before they got the
+	// frpclient.TempFilePrefix, the name alone can't tell them apart from the files of other programs sharing
+	// the temp dir so they're only pruned once isLegacyTempFile checked their content.
+	legacyTempFilePattern = regexp.MustCompile(`^(cert|key|ca)\d+$`)
+)
+
+// Options contains the configuration of a temp file collection
+type Options struct {
+	// Dir is the directory scanned for leaked temp files, defaults to the temp dir of the process
+	Dir string `json:"dir"`
+	// TTL is the age after which a temp file is removed
+	TTL time.Duration `json:"ttl"`
+	// DryRun only reports the leaked temp files without removing them
+	DryRun bool `json:"dryRun"`
+}
+
+// SetDefaults set default values for gc options
+func (o *Options) SetDefaults() {
+	if o.Dir == "" {
+		o.Dir = os.TempDir()
+	}
+	if o.TTL == 0 {
+		o.TTL = DefaultTempFileTTL
+	}
+}
+
+// Validate validates the gc options
+func (o *Options) Validate() (err error) {
+	if o.Dir == "" {
+		err = errors.Join(err, fmt.Errorf("dir is required"))
+	}
+	if o.TTL <= 0 {
+		err = errors.Join(err, fmt.Errorf("ttl must be positive"))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Dir, "dir", o.Dir, "Is the directory scanned for leaked temp files, defaults to the temp dir of the process.")
+	fs.DurationVar(&o.TTL, "ttl", o.TTL, "Is the age after which a cert, key or ca temp file is removed.")
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Only reports the leaked temp files without removing them.")
+}
+
+// PruneTempFiles removes the cert, key and ca temp files of the logins older than the TTL from the directory,
+// the validation removes them once the login completes, so only the files of crashed validations are left. The
+// unprefixed files of the older versions are removed once their content shows they hold tls material. It
+// returns the paths of the removed files, or of the files which would be removed on a dry run.
+func PruneTempFiles(o *Options) ([]string, error) {
+	entries, err := os.ReadDir(o.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable read dir '%s', got: %w", o.Dir, err)
+	}
+	var pruned []string
+	var errs error
+	for _, entry := range entries {
+		legacy := legacyTempFilePattern.MatchString(entry.Name())
+		if !entry.Type().IsRegular() || !tempFilePattern.MatchString(entry.Name()) && !legacy {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < o.TTL {
+			continue
+		}
+		path := filepath.Join(o.Dir, entry.Name())
+		if legacy && !isLegacyTempFile(path, info) {
+			continue
+		}
+		if !o.DryRun {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = errors.Join(errs, fmt.Errorf("unable remove temp file '%s', got: %w", path, err))
+				continue
+			}
+		}
+		pruned = append(pruned, path)
+	}
+	return pruned, errs
+}
+
+// isLegacyTempFile reports whether the unprefixed temp file was written by a login, the login wrote only PEM
+// blocks into a file only readable by the process, certificates for the cert and ca files and a private key
+// for the key file.
+func isLegacyTempFile(path string, info os.FileInfo) bool {
+	if info.Mode().Perm() != 0o600 || info.Size() > maxLegacyTempFileSize {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	key := strings.HasPrefix(info.Name(), "key")
+	blocks := 0
+	for rest := bytes.TrimSpace(data); len(rest) != 0; rest = bytes.TrimSpace(rest) {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return false
+		}
+		if key && !strings.HasSuffix(block.Type, "PRIVATE KEY") || !key && block.Type != "CERTIFICATE" {
+			return false
+		}
+		blocks++
+	}
+	return blocks != 0 && (!key || blocks == 1)
+}
+
+// Janitor periodically prunes the temp files leaked by crashed validations, the files hold credentials
+type Janitor struct {
+	Options
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica writes its own temp files
+func (j *Janitor) NeedLeaderElection() bool {
+	return false
+}
+
+// Start prunes the temp files once every TTL until ctx is done
+func (j *Janitor) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("temp-file-janitor")
+	logger.Info("Starting temp file janitor", "dir", j.Dir, "ttl", j.TTL)
+	ticker := time.NewTicker(j.TTL)
+	defer ticker.Stop()
+	for {
+		pruned, err := PruneTempFiles(&j.Options)
+		if err != nil {
+			logger.Error(err, "unable prune leaked temp files")
+		}
+		if len(pruned) != 0 {
+			logger.Info("Removed leaked temp files", "files", pruned)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package install
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/crds"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"io"
+	"io/fs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultNamespace = "frp-provisioner-system"
+	defaultImage     = "frp-provisioner:latest"
+
+	// namePrefix is the name prefix of the default kustomization
+	namePrefix = "frp-provisioner-"
+	// manifestNamespace is the namespace of the manifests before they're rendered
+	manifestNamespace = "system"
+	// webhookCertSecret is the secret holding the serving certificate of the webhooks
+	webhookCertSecret = "webhook-server-cert"
+	// webhookServiceName is the service of the webhooks
+	webhookServiceName = "webhook-service"
+)
+
+// namespacedKinds are the kinds of the embedded manifests which are namespaced
+var namespacedKinds = []string{"ServiceAccount", "Role", "RoleBinding", "ConfigMap", "Service", "Secret", "Deployment"}
+
+// kindOrder is the order the kinds are applied in, the objects are created before the ones using them
+var kindOrder = []string{"Namespace", "CustomResourceDefinition", "ServiceAccount", "ClusterRole", "Role",
+	"ClusterRoleBinding", "RoleBinding", "ConfigMap", "Secret", "Service", "Deployment",
+	"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}
+
+func applyOrder(obj *unstructured.Unstructured) int {
+	if i := lo.IndexOf(kindOrder, obj.GetKind()); i >= 0 {
+		return i
+	}
+	return len(kindOrder)
+}
+
+// Options contains the configuration of an install run
+type Options struct {
+	// Namespace is the namespace the manager is installed to
+	Namespace string `json:"namespace"`
+	// Image is the image of the manager
+	Image string `json:"image"`
+	// Webhooks installs the admission webhooks with a self-signed serving certificate, defaults to true
+	Webhooks *bool `json:"webhooks"`
+	// DryRun only prints the manifests without applying them
+	DryRun bool `json:"dryRun"`
+}
+
+// SetDefaults set default values for install options
+func (o *Options) SetDefaults() {
+	o.Namespace = util.EmptyOr(o.Namespace, defaultNamespace)
+	o.Image = util.EmptyOr(o.Image, defaultImage)
+	if o.Webhooks == nil {
+		o.Webhooks = lo.ToPtr(true)
+	}
+}
+
+// Validate validates the install options
+func (o *Options) Validate() (err error) {
+	if msgs := validation.IsDNS1123Label(o.Namespace); len(msgs) != 0 {
+		err = errors.Join(err, fmt.Errorf("invalid namespace '%s', %s", o.Namespace, strings.Join(msgs, ", ")))
+	}
+	if o.Image == "" {
+		err = errors.Join(err, fmt.Errorf("image is required"))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.Namespace, "namespace", "n", o.Namespace, "Is the namespace the manager is installed to.")
+	fs.StringVar(&o.Image, "image", o.Image, "Is the image of the manager.")
+	if o.Webhooks == nil {
+		o.Webhooks = lo.ToPtr(true)
+	}
+	fs.BoolVar(o.Webhooks, "webhooks", *o.Webhooks, "Installs the admission webhooks with a self-signed serving certificate.")
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Only prints the manifests without applying them.")
+}
+
+// Render returns the embedded CRDs, RBAC, webhook configurations and manager Deployment transformed the
+// way the default kustomization does, so the manager can be installed without kustomize.
+func Render(o *Options) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	crdObjs, err := decodeFS(config.CRDs, "crd/bases/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, crdObjs...)
+	manifests, err := decodeFS(config.Manifests, "*/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var caBundle []byte
+	webhooks := lo.FromPtr(o.Webhooks)
+	if webhooks {
+		cert, key, err := selfSignedCert(fmt.Sprintf("%s%s.%s.svc", namePrefix, webhookServiceName, o.Namespace))
+		if err != nil {
+			return nil, fmt.Errorf("unable generate webhook serving certificate, got: %w", err)
+		}
+		caBundle = cert
+		manifests = append(manifests, webhookSecret(o.Namespace, cert, key))
+	}
+	for _, obj := range manifests {
+		if !webhooks && isWebhookObject(obj) {
+			continue
+		}
+		if err := transform(o, obj, caBundle); err != nil {
+			return nil, fmt.Errorf("unable render %s '%s', got: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		objs = append(objs, obj)
+	}
+	sort.SliceStable(objs, func(i, j int) bool { return applyOrder(objs[i]) < applyOrder(objs[j]) })
+	return objs, nil
+}
+
+// Run renders the manifests and applies them with server-side apply, or prints them when o.DryRun is set
+func Run(ctx context.Context, cli client.Client, o *Options, w io.Writer) error {
+	objs, err := Render(o)
+	if err != nil {
+		return err
+	}
+	if o.DryRun {
+		for _, obj := range objs {
+			data, err := yaml.Marshal(obj.Object)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, obj := range objs {
+		if err := cli.Patch(ctx, obj, client.Apply, client.FieldOwner(crds.FieldOwner), client.ForceOwnership); err != nil {
+			return fmt.Errorf("unable apply %s '%s', got: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		if _, err := fmt.Fprintf(w, "%s/%s applied\n", strings.ToLower(obj.GetKind()), obj.GetName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeFS decodes the yaml documents of the files matching the pattern
+func decodeFS(fsys fs.FS, pattern string) ([]*unstructured.Unstructured, error) {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	var objs []*unstructured.Unstructured
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range bytes.Split(data, []byte("\n---")) {
+			obj := map[string]any{}
+			if err := yaml.Unmarshal(doc, &obj); err != nil {
+				return nil, fmt.Errorf("unable decode manifest '%s', got: %w", file, err)
+			}
+			if len(obj) != 0 {
+				objs = append(objs, &unstructured.Unstructured{Object: obj})
+			}
+		}
+	}
+	return objs, nil
+}
+
+func isWebhookObject(obj *unstructured.Unstructured) bool {
+	return obj.GetKind() == "MutatingWebhookConfiguration" || obj.GetKind() == "ValidatingWebhookConfiguration" ||
+		(obj.GetKind() == "Service" && obj.GetName() == webhookServiceName)
+}
+
+// transform applies the namespace and name prefix of the default kustomization to the object, and points
+// the references between the objects to the transformed names
+func transform(o *Options, obj *unstructured.Unstructured, caBundle []byte) error {
+	if obj.GetKind() == "Namespace" {
+		obj.SetName(o.Namespace)
+		return nil
+	}
+	obj.SetName(namePrefix + obj.GetName())
+	if lo.Contains(namespacedKinds, obj.GetKind()) {
+		obj.SetNamespace(o.Namespace)
+	}
+	switch obj.GetKind() {
+	case "RoleBinding", "ClusterRoleBinding":
+		roleName, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+		if err := unstructured.SetNestedField(obj.Object, namePrefix+roleName, "roleRef", "name"); err != nil {
+			return err
+		}
+		return updateSlice(obj, func(subject map[string]any) error {
+			subject["name"] = namePrefix + fmt.Sprint(subject["name"])
+			subject["namespace"] = o.Namespace
+			return nil
+		}, "subjects")
+	case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+		return updateSlice(obj, func(webhook map[string]any) error {
+			if err := unstructured.SetNestedField(webhook, namePrefix+webhookServiceName, "clientConfig", "service", "name"); err != nil {
+				return err
+			}
+			if err := unstructured.SetNestedField(webhook, o.Namespace, "clientConfig", "service", "namespace"); err != nil {
+				return err
+			}
+			return unstructured.SetNestedField(webhook, encodeBase64(caBundle), "clientConfig", "caBundle")
+		}, "webhooks")
+	case "Deployment":
+		return transformDeployment(o, obj)
+	}
+	return nil
+}
+
+// transformDeployment sets the image, service account and volumes of the manager Deployment, the
+// webhook server is disabled when the webhooks are not installed
+func transformDeployment(o *Options, obj *unstructured.Unstructured) error {
+	spec := []string{"spec", "template", "spec"}
+	serviceAccount, _, _ := unstructured.NestedString(obj.Object, append(spec, "serviceAccountName")...)
+	if err := unstructured.SetNestedField(obj.Object, namePrefix+serviceAccount, append(spec, "serviceAccountName")...); err != nil {
+		return err
+	}
+	volumes, _, _ := unstructured.NestedSlice(obj.Object, append(spec, "volumes")...)
+	for _, volume := range volumes {
+		if configMap, ok := volume.(map[string]any)["configMap"].(map[string]any); ok {
+			configMap["name"] = namePrefix + fmt.Sprint(configMap["name"])
+		}
+	}
+	webhooks := lo.FromPtr(o.Webhooks)
+	if webhooks {
+		volumes = append(volumes, map[string]any{
+			"name":   "cert",
+			"secret": map[string]any{"secretName": namePrefix + webhookCertSecret, "defaultMode": int64(420)},
+		})
+	}
+	if err := unstructured.SetNestedSlice(obj.Object, volumes, append(spec, "volumes")...); err != nil {
+		return err
+	}
+	return updateSlice(obj, func(container map[string]any) error {
+		container["image"] = o.Image
+		// the image runs the manager as its entrypoint
+		delete(container, "command")
+		if !webhooks {
+			args, _ := container["args"].([]any)
+			container["args"] = append(args, "--manager.enable-webhooks=false")
+			return nil
+		}
+		container["ports"] = []any{map[string]any{"containerPort": int64(9443), "name": "webhook-server", "protocol": "TCP"}}
+		mounts, _ := container["volumeMounts"].([]any)
+		container["volumeMounts"] = append(mounts, map[string]any{
+			"mountPath": "/tmp/k8s-webhook-server/serving-certs",
+			"name":      "cert",
+			"readOnly":  true,
+		})
+		return nil
+	}, append(spec, "containers")...)
+}
+
+// updateSlice updates every object of the slice field of obj
+func updateSlice(obj *unstructured.Unstructured, update func(map[string]any) error, fields ...string) error {
+	items, _, err := unstructured.NestedSlice(obj.Object, fields...)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if err := update(m); err != nil {
+			return err
+		}
+	}
+	return unstructured.SetNestedSlice(obj.Object, items, fields...)
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package inventory
+
+import (
+	"context"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+)
+
+// FrpServer is the inventory entry of a v1beta1.FrpServer
+type FrpServer struct {
+	Name           string   `json:"name" description:"Name of the FrpServer"`
+	ServerAddr     string   `json:"serverAddr" description:"Address frp clients connect to"`
+	ServerPort     int      `json:"serverPort" description:"Port frp clients connect to"`
+	ExternalIPs    []string `json:"externalIPs" description:"Ingress points published for the exposed services"`
+	Phase          string   `json:"phase" description:"Health of the FrpServer, one of Pending, Healthy, Unhealthy or Unknown"`
+	ActiveProtocol string   `json:"activeProtocol,omitempty" description:"Transport protocol which last connected to the server"`
+	Tunnels        int      `json:"tunnels" description:"Number of tunnels scheduled on the FrpServer"`
+}
+
+// Tunnel is the inventory entry of a LoadBalancer service exposed through a FrpServer
+type Tunnel struct {
+	Namespace string   `json:"namespace" description:"Namespace of the service"`
+	Name      string   `json:"name" description:"Name of the service"`
+	Server    string   `json:"server" description:"Name of the FrpServer the tunnel is scheduled on"`
+	Claim     string   `json:"claim,omitempty" description:"Name of the FrpServerClaim the service uses"`
+	ProxyType string   `json:"proxyType" description:"frp proxy type, one of tcp, udp, stcp, xtcp or http"`
+	Ready     bool     `json:"ready" description:"Whether the tunnel is live and its endpoints are published"`
+	Endpoints []string `json:"endpoints" description:"Published host:port endpoints"`
+}
+
+// Allocation is a port of a FrpServer ingress point allocated to a service
+type Allocation struct {
+	Server    string `json:"server" description:"Name of the FrpServer"`
+	Address   string `json:"address" description:"Ingress point of the FrpServer"`
+	Port      int32  `json:"port" description:"Allocated port"`
+	Protocol  string `json:"protocol" description:"Protocol of the allocated port, TCP or UDP"`
+	Namespace string `json:"namespace" description:"Namespace of the service the port is allocated to"`
+	Service   string `json:"service" description:"Name of the service the port is allocated to"`
+}
+
+// FrpServerList is a page of FrpServer entries
+type FrpServerList struct {
+	Items    []FrpServer `json:"items" description:"FrpServer entries of the page"`
+	Continue string      `json:"continue,omitempty" description:"Token of the next page, empty on the last page"`
+}
+
+// TunnelList is a page of Tunnel entries
+type TunnelList struct {
+	Items    []Tunnel `json:"items" description:"Tunnel entries of the page"`
+	Continue string   `json:"continue,omitempty" description:"Token of the next page, empty on the last page"`
+}
+
+// AllocationList is a page of Allocation entries
+type AllocationList struct {
+	Items    []Allocation `json:"items" description:"Allocation entries of the page"`
+	Continue string       `json:"continue,omitempty" description:"Token of the next page, empty on the last page"`
+}
+
+// Filter selects the inventory entries, empty fields match everything
+type Filter struct {
+	// Namespace selects the tunnels and allocations of services in the namespace
+	Namespace string
+	// Server selects the entries of the FrpServer
+	Server string
+}
+
+// snapshot is a consistent view of the objects the inventory is built from
+type snapshot struct {
+	servers  []v1beta1.FrpServer
+	services []v1.Service
+	claims   map[string]v1beta1.FrpServerClaim
+}
+
+// load reads the FrpServers, the LoadBalancer services and the FrpServerClaims from c
+func load(ctx context.Context, c client.Reader, namespace string) (*snapshot, error) {
+	serverList := &v1beta1.FrpServerList{}
+	if err := c.List(ctx, serverList); err != nil {
+		return nil, err
+	}
+	serviceList := &v1.ServiceList{}
+	if err := c.List(ctx, serviceList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	claimList := &v1beta1.FrpServerClaimList{}
+	if err := c.List(ctx, claimList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	s := &snapshot{servers: serverList.Items, claims: make(map[string]v1beta1.FrpServerClaim, len(claimList.Items))}
+	for _, claim := range claimList.Items {
+		s.claims[claim.Namespace+"/"+claim.Name] = claim
+	}
+	s.services = lo.Filter(serviceList.Items, func(svc v1.Service, _ int) bool {
+		return svc.Spec.Type == v1.ServiceTypeLoadBalancer && s.serverOf(&svc) != ""
+	})
+	sort.Slice(s.servers, func(i, j int) bool { return s.servers[i].Name < s.servers[j].Name })
+	sort.Slice(s.services, func(i, j int) bool {
+		return s.services[i].Namespace+"/"+s.services[i].Name < s.services[j].Namespace+"/"+s.services[j].Name
+	})
+	return s, nil
+}
+
+// serverOf returns the name of the FrpServer the service is scheduled on, directly or through a bound claim
+func (s *snapshot) serverOf(svc *v1.Service) string {
+	if claimName := svc.Annotations[v1beta1.AnnotationFrpServerClaimNameKey]; claimName != "" {
+		claim, ok := s.claims[svc.Namespace+"/"+claimName]
+		if !ok || claim.Status.Phase != v1beta1.FrpServerClaimPhaseBound {
+			return ""
+		}
+		return claim.Spec.ServerName
+	}
+	return svc.Annotations[v1beta1.AnnotationFrpServerNameKey]
+}
+
+// FrpServers returns the FrpServer entries matching the filter
+func (s *snapshot) FrpServers(f Filter) []FrpServer {
+	tunnels := lo.CountValuesBy(s.services, func(svc v1.Service) string { return s.serverOf(&svc) })
+	items := make([]FrpServer, 0, len(s.servers))
+	for _, srv := range s.servers {
+		if f.Server != "" && srv.Name != f.Server {
+			continue
+		}
+		items = append(items, FrpServer{
+			Name:           srv.Name,
+			ServerAddr:     srv.Spec.ServerAddr,
+			ServerPort:     srv.Spec.ServerPort,
+			ExternalIPs:    srv.Spec.ExternalIPs,
+			Phase:          string(srv.Status.Phase),
+			ActiveProtocol: string(srv.Status.ActiveProtocol),
+			Tunnels:        tunnels[srv.Name],
+		})
+	}
+	return items
+}
+
+// Tunnels returns the Tunnel entries matching the filter
+func (s *snapshot) Tunnels(f Filter) []Tunnel {
+	items := make([]Tunnel, 0, len(s.services))
+	for i := range s.services {
+		svc := &s.services[i]
+		server := s.serverOf(svc)
+		if f.Server != "" && server != f.Server {
+			continue
+		}
+		endpoints := make([]string, 0, len(svc.Status.LoadBalancer.Ingress)*len(svc.Spec.Ports))
+		for _, point := range svc.Status.LoadBalancer.Ingress {
+			// the ingress points record the remote ports they publish the service ports on
+			ports := lo.Map(svc.Spec.Ports, func(port v1.ServicePort, _ int) int32 { return port.Port })
+			if len(point.Ports) != 0 {
+				ports = lo.Map(point.Ports, func(port v1.PortStatus, _ int) int32 { return port.Port })
+			}
+			for _, port := range ports {
+				endpoints = append(endpoints, net.JoinHostPort(lo.Ternary(point.IP != "", point.IP, point.Hostname), strconv.Itoa(int(port))))
+			}
+		}
+		items = append(items, Tunnel{
+			Namespace: svc.Namespace,
+			Name:      svc.Name,
+			Server:    server,
+			Claim:     svc.Annotations[v1beta1.AnnotationFrpServerClaimNameKey],
+			ProxyType: util.EmptyOr(svc.Annotations[v1beta1.AnnotationProxyTypeKey], v1beta1.ProxyTypeTCP),
+			Ready:     len(svc.Status.LoadBalancer.Ingress) != 0,
+			Endpoints: endpoints,
+		})
+	}
+	return items
+}
+
+// Allocations returns the Allocation entries matching the filter
+func (s *snapshot) Allocations(f Filter) []Allocation {
+	externalIPs := make(map[string][]string, len(s.servers))
+	for _, srv := range s.servers {
+		externalIPs[srv.Name] = srv.Spec.ExternalIPs
+	}
+	items := make([]Allocation, 0)
+	for i := range s.services {
+		svc := &s.services[i]
+		server := s.serverOf(svc)
+		if f.Server != "" && server != f.Server {
+			continue
+		}
+		addrs := externalIPs[server]
+		if ip := svc.Annotations[v1beta1.AnnotationIngressIPKey]; ip != "" && svc.Annotations[v1beta1.AnnotationIngressIPPoolKey] == server {
+			addrs = []string{ip}
+		}
+		for _, addr := range addrs {
+			for _, port := range svc.Spec.Ports {
+				items = append(items, Allocation{
+					Server:    server,
+					Address:   addr,
+					Port:      port.Port,
+					Protocol:  string(util.EmptyOr(port.Protocol, v1.ProtocolTCP)),
+					Namespace: svc.Namespace,
+					Service:   svc.Name,
+				})
+			}
+		}
+	}
+	return items
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package inventory
+
+import (
+	"reflect"
+	"strings"
+)
+
+// OpenAPI generates the OpenAPI 3 document of the inventory API, the schemas are derived from the
+// json and description tags of the response types so the document can't drift from the responses.
+func OpenAPI() map[string]any {
+	schemas := map[string]any{}
+	for _, v := range []any{FrpServer{}, Tunnel{}, Allocation{}, FrpServerList{}, TunnelList{}, AllocationList{}, Error{}} {
+		t := reflect.TypeOf(v)
+		schemas[t.Name()] = objectSchema(t)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "frp-provisioner tunnel inventory",
+			"description": "Read-only inventory of the tunnels, FrpServers and port allocations managed by frp-provisioner.",
+			"version":     "v1",
+		},
+		"paths": map[string]any{
+			PathFrpServers:  listOperation("List the FrpServers", "FrpServerList"),
+			PathTunnels:     listOperation("List the tunnels of LoadBalancer services", "TunnelList"),
+			PathAllocations: listOperation("List the ports allocated on the FrpServer ingress points", "AllocationList"),
+		},
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+// listOperation returns the path item of a paginated list endpoint
+func listOperation(summary, schema string) map[string]any {
+	parameter := func(name, description string, schema map[string]any) map[string]any {
+		return map[string]any{"name": name, "in": "query", "required": false, "description": description, "schema": schema}
+	}
+	response := func(description, schema string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/" + schema}},
+			},
+		}
+	}
+	return map[string]any{
+		"get": map[string]any{
+			"summary": summary,
+			"parameters": []any{
+				parameter("namespace", "Only return the entries of services in the namespace", map[string]any{"type": "string"}),
+				parameter("server", "Only return the entries of the FrpServer", map[string]any{"type": "string"}),
+				parameter("limit", "Maximum number of entries in the page", map[string]any{
+					"type": "integer", "minimum": 1, "maximum": maxLimit, "default": defaultLimit,
+				}),
+				parameter("continue", "Token of the page returned by the previous request", map[string]any{"type": "string"}),
+			},
+			"responses": map[string]any{
+				"200": response("A page of entries", schema),
+				"400": response("The filter or page of the request is invalid", "Error"),
+				"500": response("The inventory could not be loaded", "Error"),
+			},
+		},
+	}
+}
+
+// objectSchema returns the schema of a struct type from the json and description tags of its fields
+func objectSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		schema := typeSchema(field.Type)
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		properties[name] = schema
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// typeSchema returns the schema of a field type, named struct types are referenced by name
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
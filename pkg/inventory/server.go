@@ -0,0 +1,181 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package inventory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultLimit is the page size used when the request does not set limit
+	defaultLimit = 100
+	// maxLimit bounds the page size a request may ask for
+	maxLimit = 1000
+	// shutdownTimeout bounds the time given to in-flight requests once the manager stops
+	shutdownTimeout = 5 * time.Second
+)
+
+const (
+	PathFrpServers  = "/api/v1/frpservers"
+	PathTunnels     = "/api/v1/tunnels"
+	PathAllocations = "/api/v1/allocations"
+	PathOpenAPI     = "/openapi.json"
+)
+
+// Server serves the read-only tunnel inventory API, the entries are read from the manager cache
+// so the portals embedding the inventory don't need access to the cluster API.
+type Server struct {
+	// BindAddress is the tcp address the inventory API listens on
+	BindAddress string
+	// Reader reads the FrpServers, Services and FrpServerClaims
+	Reader client.Reader
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica serves the inventory from its cache
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the inventory API until ctx is done
+func (s *Server) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("inventory")
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return fmt.Errorf("unable listen on inventory address '%s', got: %w", s.BindAddress, err)
+	}
+	srv := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	logger.Info("Serving tunnel inventory API", "address", listener.Addr().String())
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("unable serve inventory API, got: %w", err)
+	}
+	return nil
+}
+
+// Handler returns the http handler of the inventory API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathFrpServers, s.list(func(snap *snapshot, f Filter, offset, limit int) any {
+		items, next := page(snap.FrpServers(f), offset, limit)
+		return &FrpServerList{Items: items, Continue: next}
+	}))
+	mux.HandleFunc(PathTunnels, s.list(func(snap *snapshot, f Filter, offset, limit int) any {
+		items, next := page(snap.Tunnels(f), offset, limit)
+		return &TunnelList{Items: items, Continue: next}
+	}))
+	mux.HandleFunc(PathAllocations, s.list(func(snap *snapshot, f Filter, offset, limit int) any {
+		items, next := page(snap.Allocations(f), offset, limit)
+		return &AllocationList{Items: items, Continue: next}
+	}))
+	mux.HandleFunc(PathOpenAPI, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, OpenAPI())
+	})
+	return mux
+}
+
+// list returns the handler of a list endpoint, it parses the filter and the page of the request
+func (s *Server) list(build func(snap *snapshot, f Filter, offset, limit int) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		query := r.URL.Query()
+		f := Filter{Namespace: query.Get("namespace"), Server: query.Get("server")}
+		limit, offset := defaultLimit, 0
+		if value := query.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > maxLimit {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("limit should be in the range 1..%d, got: %s", maxLimit, value))
+				return
+			}
+			limit = n
+		}
+		if value := query.Get("continue"); value != "" {
+			n, err := decodeContinue(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid continue token, got: %w", err))
+				return
+			}
+			offset = n
+		}
+		snap, err := load(r.Context(), s.Reader, f.Namespace)
+		if err != nil {
+			log.FromContext(r.Context()).Error(err, "unable load tunnel inventory")
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("unable load tunnel inventory"))
+			return
+		}
+		writeJSON(w, http.StatusOK, build(snap, f, offset, limit))
+	}
+}
+
+// page returns the items of the page starting at offset and the continue token of the next page
+func page[T any](items []T, offset, limit int) ([]T, string) {
+	if offset >= len(items) {
+		return []T{}, ""
+	}
+	end := offset + limit
+	if end >= len(items) {
+		return items[offset:], ""
+	}
+	return items[offset:end], encodeContinue(end)
+}
+
+// encodeContinue encodes the offset of the next page as an opaque continue token
+func encodeContinue(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// decodeContinue decodes the offset of the page from a continue token
+func decodeContinue(token string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(data))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("malformed offset %q", data)
+	}
+	return offset, nil
+}
+
+// Error is the body of a failed request
+type Error struct {
+	Message string `json:"message" description:"Reason the request failed"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &Error{Message: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
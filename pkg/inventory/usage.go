@@ -0,0 +1,194 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UsageConfigMapName is the name of the ConfigMap the usage report of a namespace is written to
+const UsageConfigMapName = "frp-provisioner-usage"
+
+// Usage is the usage summary of the tunnels of a namespace
+type Usage struct {
+	// Tunnels is the number of services of the namespace exposed through a FrpServer
+	Tunnels int
+	// ReadyTunnels is the number of tunnels whose endpoints are published
+	ReadyTunnels int
+	// Ports are the ports of the FrpServer ingress points held by the namespace, "{address}:{port}/{protocol}"
+	Ports []string
+	// Servers are the names of the FrpServers the tunnels are scheduled on
+	Servers []string
+	// BandwidthLimits are the bandwidth limits of the tunnels keyed by service name, the tunnels without
+	// a limit are left out
+	BandwidthLimits map[string]string
+}
+
+// Data returns the ConfigMap data of the usage report, one key per field so tenants can read it with kubectl
+func (u *Usage) Data() map[string]string {
+	data := map[string]string{
+		"tunnels":      strconv.Itoa(u.Tunnels),
+		"readyTunnels": strconv.Itoa(u.ReadyTunnels),
+		"ports":        strings.Join(u.Ports, "\n"),
+		"servers":      strings.Join(u.Servers, "\n"),
+	}
+	if len(u.BandwidthLimits) != 0 {
+		names := lo.Keys(u.BandwidthLimits)
+		sort.Strings(names)
+		data["bandwidthLimits"] = strings.Join(lo.Map(names, func(name string, _ int) string {
+			return name + ": " + u.BandwidthLimits[name]
+		}), "\n")
+	}
+	return data
+}
+
+// Usages returns the usage summaries of the namespaces with tunnels keyed by namespace
+func (s *snapshot) Usages() map[string]*Usage {
+	servers := lo.SliceToMap(s.servers, func(srv v1beta1.FrpServer) (string, *v1beta1.FrpServer) {
+		return srv.Name, &srv
+	})
+	usages := make(map[string]*Usage)
+	for _, tunnel := range s.Tunnels(Filter{}) {
+		usage, ok := usages[tunnel.Namespace]
+		if !ok {
+			usage = &Usage{BandwidthLimits: make(map[string]string)}
+			usages[tunnel.Namespace] = usage
+		}
+		usage.Tunnels++
+		usage.ReadyTunnels += lo.Ternary(tunnel.Ready, 1, 0)
+		usage.Servers = append(usage.Servers, tunnel.Server)
+	}
+	for _, allocation := range s.Allocations(Filter{}) {
+		usage := usages[allocation.Namespace]
+		usage.Ports = append(usage.Ports, net.JoinHostPort(allocation.Address, strconv.Itoa(int(allocation.Port)))+"/"+allocation.Protocol)
+	}
+	for i := range s.services {
+		svc := &s.services[i]
+		limit := svc.Annotations[v1beta1.AnnotationBandwidthLimitKey]
+		if srv, ok := servers[s.serverOf(svc)]; ok && srv.Spec.ProxyDefaults != nil {
+			limit = util.EmptyOr(limit, srv.Spec.ProxyDefaults.BandwidthLimit)
+		}
+		if limit != "" {
+			usages[svc.Namespace].BandwidthLimits[svc.Name] = limit
+		}
+	}
+	for _, usage := range usages {
+		usage.Servers = lo.Uniq(usage.Servers)
+		sort.Strings(usage.Servers)
+	}
+	return usages
+}
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;create;update;delete
+
+// UsageReporter writes the usage summary of each namespace with tunnels to the UsageConfigMapName ConfigMap of
+// the namespace, so tenants see their usage without cluster wide read rights. The reports of the namespaces
+// without tunnels are deleted.
+type UsageReporter struct {
+	// Client reads the FrpServers, Services and FrpServerClaims from the cache and writes the ConfigMaps
+	Client client.Client
+	// APIReader lists the report ConfigMaps from the API server, so the ConfigMaps of the cluster aren't cached
+	APIReader client.Reader
+	// Interval is the period the reports are written at
+	Interval time.Duration
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader writes the reports
+func (r *UsageReporter) NeedLeaderElection() bool {
+	return true
+}
+
+// Start writes the reports every Interval until ctx is done
+func (r *UsageReporter) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("usage-reporter")
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.report(ctx); err != nil {
+			logger.Error(err, "unable write usage reports")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// report writes the usage reports which changed and deletes the ones of the namespaces without tunnels
+func (r *UsageReporter) report(ctx context.Context) error {
+	snap, err := load(ctx, r.Client, "")
+	if err != nil {
+		return fmt.Errorf("unable load usage, got: %w", err)
+	}
+	usages := snap.Usages()
+	reports := &v1.ConfigMapList{}
+	if err := r.APIReader.List(ctx, reports, client.HasLabels{frplabels.UsageReport}); err != nil {
+		return fmt.Errorf("unable list usage reports, got: %w", err)
+	}
+	var errs error
+	current := make(map[string]*v1.ConfigMap, len(reports.Items))
+	for i := range reports.Items {
+		report := &reports.Items[i]
+		if report.Name != UsageConfigMapName {
+			continue
+		}
+		if _, ok := usages[report.Namespace]; !ok {
+			if err := r.Client.Delete(ctx, report); err != nil && !apierrors.IsNotFound(err) {
+				errs = errors.Join(errs, fmt.Errorf("unable delete usage report of namespace '%s', got: %w", report.Namespace, err))
+			}
+			continue
+		}
+		current[report.Namespace] = report
+	}
+	for namespace, usage := range usages {
+		data := usage.Data()
+		report, ok := current[namespace]
+		if !ok {
+			report = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: UsageConfigMapName, Labels: map[string]string{frplabels.UsageReport: "true"}},
+				Data:       data,
+			}
+			if err := r.Client.Create(ctx, report); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("unable create usage report of namespace '%s', got: %w", namespace, err))
+			}
+			continue
+		}
+		if equality.Semantic.DeepEqual(report.Data, data) {
+			continue
+		}
+		report.Data = data
+		if err := r.Client.Update(ctx, report); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("unable update usage report of namespace '%s', got: %w", namespace, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sync"
+)
+
+// ErrPoolExhausted is returned when every ingress IP of the pool is allocated
+var ErrPoolExhausted = errors.New("ingress ip pool is exhausted")
+
+// Request identifies the Service an ingress IP is allocated to
+type Request struct {
+	// Server is the name of the FrpServer the Service is scheduled on
+	Server string `json:"server"`
+	// Namespace is the namespace of the Service
+	Namespace string `json:"namespace"`
+	// Service is the name of the Service
+	Service string `json:"service"`
+	// IP is the ingress IP previously allocated to the Service, empty on the first allocation
+	IP string `json:"ip,omitempty"`
+}
+
+// Allocator allocates the ingress IPs of the Services scheduled on a FrpServer
+type Allocator interface {
+	// Allocate returns the ingress IP of the Service, the IPs in inUse are allocated to other Services.
+	// The IP previously allocated to the Service is kept while it's still part of the pool.
+	Allocate(ctx context.Context, req *Request, inUse sets.Set[string]) (string, error)
+	// Release returns the ingress IP of the Service to the pool
+	Release(ctx context.Context, req *Request) error
+}
+
+// Factory creates the Allocator of an IPAM spec, it returns an error when the spec is invalid
+type Factory func(spec *v1beta1.FrpServerIPAM) (Allocator, error)
+
+var (
+	lock      sync.RWMutex
+	factories = make(map[v1beta1.FrpServerIPAMType]Factory)
+)
+
+func init() {
+	Register(v1beta1.FrpServerIPAMTypeStatic, newStaticAllocator)
+	Register(v1beta1.FrpServerIPAMTypeCIDR, newCIDRAllocator)
+	Register(v1beta1.FrpServerIPAMTypeWebhook, newWebhookAllocator)
+}
+
+// Register makes an IPAM available by the provided type, it replaces the IPAM registered for the type
+func Register(t v1beta1.FrpServerIPAMType, f Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	factories[t] = f
+}
+
+// New creates the Allocator of the IPAM spec of a FrpServer
+func New(spec *v1beta1.FrpServerIPAM) (Allocator, error) {
+	lock.RLock()
+	f, ok := factories[spec.Type]
+	lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no ipam registered for type '%s'", spec.Type)
+	}
+	return f(spec)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"net/netip"
+)
+
+// hostRange is an inclusive range of the host addresses of a pool
+type hostRange struct {
+	first, last netip.Addr
+}
+
+func (r hostRange) contains(addr netip.Addr) bool {
+	return r.first.Compare(addr) <= 0 && addr.Compare(r.last) <= 0
+}
+
+// poolAllocator allocates the lowest free address of its ranges, the state of the pool is the
+// set of IPs in use so it's rebuilt from the Services on every allocation.
+type poolAllocator struct {
+	ranges []hostRange
+}
+
+func newStaticAllocator(spec *v1beta1.FrpServerIPAM) (Allocator, error) {
+	if len(spec.Addresses) == 0 {
+		return nil, fmt.Errorf("field addresses should not be empty for the %s ipam", spec.Type)
+	}
+	p := &poolAllocator{}
+	for _, value := range spec.Addresses {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s', got: %w", value, err)
+		}
+		p.ranges = append(p.ranges, hostRange{first: addr, last: addr})
+	}
+	return p, nil
+}
+
+func newCIDRAllocator(spec *v1beta1.FrpServerIPAM) (Allocator, error) {
+	if len(spec.CIDRs) == 0 {
+		return nil, fmt.Errorf("field cidrs should not be empty for the %s ipam", spec.Type)
+	}
+	p := &poolAllocator{}
+	for _, value := range spec.CIDRs {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr '%s', got: %w", value, err)
+		}
+		p.ranges = append(p.ranges, hosts(prefix.Masked()))
+	}
+	return p, nil
+}
+
+// hosts returns the host addresses of a prefix, the network and broadcast addresses of IPv4
+// prefixes are skipped unless the prefix is a point-to-point /31 or a single /32.
+func hosts(prefix netip.Prefix) hostRange {
+	last := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(last)*8; i++ {
+		last[i/8] |= 1 << (7 - i%8)
+	}
+	r := hostRange{first: prefix.Addr()}
+	r.last, _ = netip.AddrFromSlice(last)
+	if prefix.Addr().Is4() && prefix.Bits() < 31 {
+		r.first, r.last = r.first.Next(), r.last.Prev()
+	}
+	return r
+}
+
+// Allocate implements Allocator
+func (p *poolAllocator) Allocate(_ context.Context, req *Request, inUse sets.Set[string]) (string, error) {
+	if addr, err := netip.ParseAddr(req.IP); err == nil && !inUse.Has(addr.String()) {
+		for _, r := range p.ranges {
+			if r.contains(addr) {
+				return addr.String(), nil
+			}
+		}
+	}
+	for _, r := range p.ranges {
+		for addr := r.first; addr.IsValid() && r.contains(addr); addr = addr.Next() {
+			if !inUse.Has(addr.String()) {
+				return addr.String(), nil
+			}
+		}
+	}
+	return "", ErrPoolExhausted
+}
+
+// Release implements Allocator, the IP is free once no Service records it
+func (p *poolAllocator) Release(context.Context, *Request) error {
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"io"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultWebhookTimeout bounds a request to the external IPAM when the spec does not set a timeout
+const defaultWebhookTimeout = 10 * time.Second
+
+// webhookAllocator delegates the allocation of the ingress IPs to an external IPAM
+type webhookAllocator struct {
+	url        string
+	httpClient *http.Client
+}
+
+// webhookResponse is the response of the allocate request of the external IPAM
+type webhookResponse struct {
+	IP string `json:"ip"`
+}
+
+func newWebhookAllocator(spec *v1beta1.FrpServerIPAM) (Allocator, error) {
+	if spec.Webhook == nil || spec.Webhook.URL == "" {
+		return nil, fmt.Errorf("field webhook.url should not be empty for the %s ipam", spec.Type)
+	}
+	if _, err := url.ParseRequestURI(spec.Webhook.URL); err != nil {
+		return nil, fmt.Errorf("invalid webhook.url '%s', got: %w", spec.Webhook.URL, err)
+	}
+	timeout := defaultWebhookTimeout
+	if spec.Webhook.TimeoutSeconds > 0 {
+		timeout = time.Duration(spec.Webhook.TimeoutSeconds) * time.Second
+	}
+	return &webhookAllocator{
+		url:        strings.TrimSuffix(spec.Webhook.URL, "/"),
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Allocate implements Allocator
+func (w *webhookAllocator) Allocate(ctx context.Context, req *Request, inUse sets.Set[string]) (string, error) {
+	resp := &webhookResponse{}
+	if err := w.post(ctx, "/allocate", req, resp); err != nil {
+		return "", err
+	}
+	addr, err := netip.ParseAddr(resp.IP)
+	if err != nil {
+		return "", fmt.Errorf("external ipam returned an invalid ip '%s', got: %w", resp.IP, err)
+	}
+	if inUse.Has(addr.String()) {
+		return "", fmt.Errorf("external ipam returned ip '%s' which is allocated to another service", addr)
+	}
+	return addr.String(), nil
+}
+
+// Release implements Allocator
+func (w *webhookAllocator) Release(ctx context.Context, req *Request) error {
+	return w.post(ctx, "/release", req, nil)
+}
+
+// post sends the request to the path of the external IPAM and decodes the response into out when not nil
+func (w *webhookAllocator) post(ctx context.Context, path string, req *Request, out any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := w.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("unable request external ipam, got: %w", err)
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("unable read external ipam response, got: %w", err)
+	}
+	if httpResp.StatusCode/100 != 2 {
+		return fmt.Errorf("external ipam %s failed with status %d: %s", path, httpResp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unable decode external ipam response, got: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// dekSize is the size of the generated AES-256 data encryption keys
+const dekSize = 32
+
+// Service is a KMSv2-style plugin which wraps data encryption keys with a key encryption
+// key it never exposes. The tenant is passed through so a plugin can select a key per tenant.
+type Service interface {
+	// Encrypt wraps plaintext for the tenant and returns the id of the key used
+	Encrypt(ctx context.Context, tenant string, plaintext []byte) (*EncryptResponse, error)
+	// Decrypt unwraps ciphertext which was encrypted for the tenant with the key keyID
+	Decrypt(ctx context.Context, tenant string, req *DecryptRequest) ([]byte, error)
+	// Status returns the id of the key currently used to encrypt for the tenant
+	Status(ctx context.Context, tenant string) (*StatusResponse, error)
+}
+
+// EncryptResponse is the result of Service.Encrypt
+type EncryptResponse struct {
+	Ciphertext []byte
+	KeyID      string
+}
+
+// DecryptRequest is the input of Service.Decrypt
+type DecryptRequest struct {
+	Ciphertext []byte
+	KeyID      string
+}
+
+// StatusResponse is the result of Service.Status
+type StatusResponse struct {
+	KeyID string
+}
+
+// Envelope is a value encrypted with a data encryption key, which itself is wrapped by the KMS
+type Envelope struct {
+	Ciphertext   []byte
+	EncryptedDEK []byte
+	KeyID        string
+}
+
+// Seal encrypts plaintext with a freshly generated data encryption key and wraps the key for the tenant
+func Seal(ctx context.Context, svc Service, tenant string, plaintext []byte) (*Envelope, error) {
+	dek := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("unable generate data encryption key, got: %w", err)
+	}
+	ciphertext, err := aesGCMEncrypt(dek, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := svc.Encrypt(ctx, tenant, dek)
+	if err != nil {
+		return nil, fmt.Errorf("unable encrypt data encryption key with kms, got: %w", err)
+	}
+	return &Envelope{Ciphertext: ciphertext, EncryptedDEK: resp.Ciphertext, KeyID: resp.KeyID}, nil
+}
+
+// Open unwraps the data encryption key of the envelope and decrypts its ciphertext
+func Open(ctx context.Context, svc Service, tenant string, env *Envelope) ([]byte, error) {
+	dek, err := svc.Decrypt(ctx, tenant, &DecryptRequest{Ciphertext: env.EncryptedDEK, KeyID: env.KeyID})
+	if err != nil {
+		return nil, fmt.Errorf("unable decrypt data encryption key with kms, got: %w", err)
+	}
+	return aesGCMDecrypt(dek, env.Ciphertext)
+}
+
+// NeedsRotation reports whether the envelope was sealed with a key which is no longer current for the tenant
+func NeedsRotation(ctx context.Context, svc Service, tenant string, env *Envelope) (bool, error) {
+	status, err := svc.Status(ctx, tenant)
+	if err != nil {
+		return false, fmt.Errorf("unable get kms status, got: %w", err)
+	}
+	return status.KeyID != env.KeyID, nil
+}
+
+// aesGCMEncrypt encrypts plaintext with key, the nonce is prepended to the result
+func aesGCMEncrypt(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable generate nonce, got: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// aesGCMDecrypt decrypts ciphertext produced by aesGCMEncrypt
+func aesGCMDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, data := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, data, nil)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeKeys writes a key file holding a key per id, the first id is the current key. The modification
+// time is moved forward on every write so LocalService reloads the file.
+func writeKeys(t *testing.T, path string, ids ...string) {
+	t.Helper()
+	keys := LocalKeys{}
+	for _, id := range ids {
+		secret := bytes.Repeat([]byte(id[:1]), dekSize)
+		keys.Keys = append(keys.Keys, LocalKey{ID: id, Secret: base64.StdEncoding.EncodeToString(secret)})
+	}
+	data, err := yaml.Marshal(keys)
+	if err != nil {
+		t.Fatalf("unable marshal keys, got: %v", err)
+	}
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("unable write keys, got: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("unable touch keys, got: %v", err)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	tests := []struct {
+		name       string
+		plaintext  []byte
+		openTenant string
+		tamper     func(env *Envelope)
+		wantErr    bool
+	}{
+		{name: "round trip", plaintext: []byte("0123456789abcdef"), openTenant: "default"},
+		{name: "empty plaintext", plaintext: []byte{}, openTenant: "default"},
+		{name: "large plaintext", plaintext: bytes.Repeat([]byte("x"), 1<<16), openTenant: "default"},
+		{name: "wrong namespace", plaintext: []byte("0123456789abcdef"), openTenant: "kube-system", wantErr: true},
+		{name: "unknown key id", plaintext: []byte("secret"), openTenant: "default", wantErr: true,
+			tamper: func(env *Envelope) { env.KeyID = "missing" }},
+		{name: "tampered ciphertext", plaintext: []byte("secret"), openTenant: "default", wantErr: true,
+			tamper: func(env *Envelope) { env.Ciphertext[len(env.Ciphertext)-1] ^= 0xff }},
+		{name: "tampered data encryption key", plaintext: []byte("secret"), openTenant: "default", wantErr: true,
+			tamper: func(env *Envelope) { env.EncryptedDEK[len(env.EncryptedDEK)-1] ^= 0xff }},
+		{name: "truncated data encryption key", plaintext: []byte("secret"), openTenant: "default", wantErr: true,
+			tamper: func(env *Envelope) { env.EncryptedDEK = env.EncryptedDEK[:4] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "keys.yaml")
+			writeKeys(t, path, "a1")
+			svc, err := NewLocalService(path)
+			if err != nil {
+				t.Fatalf("unable create local service, got: %v", err)
+			}
+			env, err := Seal(ctx, svc, "default", tt.plaintext)
+			if err != nil {
+				t.Fatalf("unable seal, got: %v", err)
+			}
+			if env.KeyID != "a1" {
+				t.Fatalf("expected key id 'a1', got '%s'", env.KeyID)
+			}
+			if tt.tamper != nil {
+				tt.tamper(env)
+			}
+			got, err := Open(ctx, svc, tt.openTenant, env)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error opening the envelope")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable open, got: %v", err)
+			}
+			if !bytes.Equal(got, tt.plaintext) {
+				t.Fatalf("expected the sealed plaintext back, got %d bytes", len(got))
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		name         string
+		rotated      []string
+		wantRotation bool
+		wantOldOpen  bool
+	}{
+		{name: "current key unchanged", rotated: []string{"a1"}, wantOldOpen: true},
+		{name: "older key appended", rotated: []string{"a1", "b0"}, wantOldOpen: true},
+		{name: "new key prepended", rotated: []string{"b2", "a1"}, wantRotation: true, wantOldOpen: true},
+		{name: "old key removed", rotated: []string{"b2"}, wantRotation: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "keys.yaml")
+			writeKeys(t, path, "a1")
+			svc, err := NewLocalService(path)
+			if err != nil {
+				t.Fatalf("unable create local service, got: %v", err)
+			}
+			plaintext := []byte("0123456789abcdef")
+			env, err := Seal(ctx, svc, "default", plaintext)
+			if err != nil {
+				t.Fatalf("unable seal, got: %v", err)
+			}
+
+			writeKeys(t, path, tt.rotated...)
+			rotate, err := NeedsRotation(ctx, svc, "default", env)
+			if err != nil {
+				t.Fatalf("unable check rotation, got: %v", err)
+			}
+			if rotate != tt.wantRotation {
+				t.Fatalf("expected rotation %t, got %t", tt.wantRotation, rotate)
+			}
+			got, err := Open(ctx, svc, "default", env)
+			if tt.wantOldOpen != (err == nil) {
+				t.Fatalf("expected the old envelope to open %t, got: %v", tt.wantOldOpen, err)
+			}
+			if err != nil {
+				return
+			}
+
+			// rewrap the data like the secret key sync does, the new envelope uses the current key
+			rewrapped, err := Seal(ctx, svc, "default", got)
+			if err != nil {
+				t.Fatalf("unable rewrap, got: %v", err)
+			}
+			if rewrapped.KeyID != tt.rotated[0] {
+				t.Fatalf("expected the rewrapped key id '%s', got '%s'", tt.rotated[0], rewrapped.KeyID)
+			}
+			if rotate, err := NeedsRotation(ctx, svc, "default", rewrapped); err != nil || rotate {
+				t.Fatalf("expected the rewrapped envelope not to need rotation, got %t: %v", rotate, err)
+			}
+			if got, err := Open(ctx, svc, "default", rewrapped); err != nil || !bytes.Equal(got, plaintext) {
+				t.Fatalf("expected the rewrapped envelope to open, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewLocalService(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "no keys", content: "keys: []", wantErr: "does not contain any key"},
+		{name: "invalid base64", content: "keys: [{id: a1, secret: '!!'}]", wantErr: "unable decode kms key 'a1'"},
+		{name: "short key", content: "keys: [{id: a1, secret: " + base64.StdEncoding.EncodeToString([]byte("short")) + "}]", wantErr: "must be 32 bytes"},
+		{name: "invalid yaml", content: "keys: {", wantErr: "unable parse kms key file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("unable write keys, got: %v", err)
+			}
+			_, err := NewLocalService(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing '%s', got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
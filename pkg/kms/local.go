@@ -0,0 +1,158 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sync"
+	"time"
+)
+
+var _ Service = &LocalService{}
+
+// LocalKeys is the content of the key file used by LocalService, the first key is used
+// to encrypt while all keys can decrypt, so a key is rotated by prepending a new one.
+type LocalKeys struct {
+	Keys []LocalKey `json:"keys"`
+}
+
+// LocalKey is a base64 encoded AES-256 key encryption key
+type LocalKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// LocalService is a Service backed by key encryption keys read from a local file,
+// typically a mounted Secret. The file is reloaded when it changes so keys can be
+// rotated without restarting the manager. The tenant is bound to the wrapped key as
+// additional authenticated data, a key wrapped for one tenant can't be unwrapped for another.
+type LocalService struct {
+	path string
+
+	lock    sync.Mutex
+	modTime time.Time
+	current string
+	keys    map[string][]byte
+}
+
+// NewLocalService create a LocalService from the key file at path
+func NewLocalService(path string) (*LocalService, error) {
+	s := &LocalService{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the key file again when it has been modified
+func (s *LocalService) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("unable stat kms key file, got: %w", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.keys != nil && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("unable read kms key file, got: %w", err)
+	}
+	localKeys := LocalKeys{}
+	if err := yaml.Unmarshal(data, &localKeys); err != nil {
+		return fmt.Errorf("unable parse kms key file, got: %w", err)
+	}
+	if len(localKeys.Keys) == 0 {
+		return fmt.Errorf("kms key file does not contain any key")
+	}
+	keys := make(map[string][]byte, len(localKeys.Keys))
+	for _, key := range localKeys.Keys {
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return fmt.Errorf("unable decode kms key '%s', got: %w", key.ID, err)
+		}
+		if len(secret) != dekSize {
+			return fmt.Errorf("kms key '%s' must be %d bytes", key.ID, dekSize)
+		}
+		keys[key.ID] = secret
+	}
+	s.keys, s.current, s.modTime = keys, localKeys.Keys[0].ID, info.ModTime()
+	return nil
+}
+
+// key returns the key encryption key with the id, or the current one when id is empty
+func (s *LocalService) key(id string) (string, cipher.AEAD, error) {
+	if err := s.reload(); err != nil {
+		return "", nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if id == "" {
+		id = s.current
+	}
+	secret, ok := s.keys[id]
+	if !ok {
+		return "", nil, fmt.Errorf("kms key '%s' not found", id)
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	return id, aead, err
+}
+
+// Encrypt implements Service
+func (s *LocalService) Encrypt(_ context.Context, tenant string, plaintext []byte) (*EncryptResponse, error) {
+	id, aead, err := s.key("")
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable generate nonce, got: %w", err)
+	}
+	return &EncryptResponse{Ciphertext: aead.Seal(nonce, nonce, plaintext, []byte(tenant)), KeyID: id}, nil
+}
+
+// Decrypt implements Service
+func (s *LocalService) Decrypt(_ context.Context, tenant string, req *DecryptRequest) ([]byte, error) {
+	_, aead, err := s.key(req.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, data := req.Ciphertext[:aead.NonceSize()], req.Ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, data, []byte(tenant))
+}
+
+// Status implements Service
+func (s *LocalService) Status(_ context.Context, _ string) (*StatusResponse, error) {
+	id, _, err := s.key("")
+	if err != nil {
+		return nil, err
+	}
+	return &StatusResponse{KeyID: id}, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package leak
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// growthThreshold is the number of consecutive snapshots a goroutine group must grow in to be reported
+	growthThreshold = 3
+	// maxStackSize bounds the buffer used to dump the stacks of all goroutines
+	maxStackSize = 64 << 20
+)
+
+// argsPattern matches the arguments, offsets and creator ids of a stack, they differ between goroutines of the same group
+var argsPattern = regexp.MustCompile(`(?m)\(.*\)$| \+0x[0-9a-f]+$| in goroutine \d+$`)
+
+var (
+	enabled atomic.Bool
+	conns   sync.Map
+	nextID  atomic.Uint64
+)
+
+// trackedConn is an entry of the connection registry
+type trackedConn struct {
+	owner   string
+	created time.Time
+	stack   string
+}
+
+// Track registers conn with the owner tag when the leak sentinel is enabled, the connection is
+// removed from the registry once closed. conn is returned untouched when the sentinel is disabled.
+func Track(conn net.Conn, owner string) net.Conn {
+	if conn == nil || !enabled.Load() {
+		return conn
+	}
+	buf := make([]byte, 8<<10)
+	id := nextID.Add(1)
+	conns.Store(id, &trackedConn{owner: owner, created: time.Now(), stack: string(buf[:runtime.Stack(buf, false)])})
+	return &registeredConn{Conn: conn, id: id}
+}
+
+// registeredConn removes itself from the connection registry once closed
+type registeredConn struct {
+	net.Conn
+	id uint64
+}
+
+// Close closes the connection
+func (c *registeredConn) Close() error {
+	conns.Delete(c.id)
+	return c.Conn.Close()
+}
+
+// Sentinel periodically diffs goroutine snapshots and audits the connection registry, goroutine
+// groups which keep growing and connections open longer than ConnMaxAge are logged with stack traces.
+type Sentinel struct {
+	// Interval is the time between two snapshots
+	Interval time.Duration
+	// ConnMaxAge is the age after which an open tracked connection is reported
+	ConnMaxAge time.Duration
+
+	previous map[string]int
+	growth   map[string]int
+	reported map[uint64]bool
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica watches its own process
+func (s *Sentinel) NeedLeaderElection() bool {
+	return false
+}
+
+// Start enables the connection registry and snapshots the goroutines until ctx is done
+func (s *Sentinel) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("leak-sentinel")
+	logger.Info("Starting leak sentinel", "interval", s.Interval, "connMaxAge", s.ConnMaxAge)
+	enabled.Store(true)
+	defer enabled.Store(false)
+
+	s.previous, s.growth, s.reported = s.snapshot(), make(map[string]int), make(map[uint64]bool)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current := s.snapshot()
+		for group, count := range current {
+			if count <= s.previous[group] {
+				delete(s.growth, group)
+				continue
+			}
+			s.growth[group]++
+			if s.growth[group] == growthThreshold {
+				logger.Info("Suspected goroutine leak, goroutine group kept growing", "count", count,
+					"snapshots", growthThreshold, "stack", group)
+			}
+		}
+		s.previous = current
+
+		reported := make(map[uint64]bool, len(s.reported))
+		conns.Range(func(key, value any) bool {
+			id, tracked := key.(uint64), value.(*trackedConn)
+			if age := time.Since(tracked.created); age > s.ConnMaxAge {
+				reported[id] = true
+				if s.reported[id] {
+					return true
+				}
+				logger.Info("Suspected connection leak, connection is still open", "owner", tracked.owner,
+					"age", age.Round(time.Second), "stack", tracked.stack)
+			}
+			return true
+		})
+		s.reported = reported
+	}
+}
+
+// snapshot groups the goroutines of the process by their normalized stack
+func (s *Sentinel) snapshot() map[string]int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackSize {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	groups := make(map[string]int)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		// drop the header holding the goroutine id, state and wait time
+		_, frames, found := strings.Cut(stack, "\n")
+		if !found {
+			continue
+		}
+		groups[argsPattern.ReplaceAllString(strings.TrimSpace(frames), "")]++
+	}
+	return groups
+}
@@ -15,7 +15,9 @@ package log
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type loggerKey struct{}
@@ -64,5 +66,38 @@ func NewLogger(ctx context.Context, opt *Options) (*zap.Logger, error) {
 		ErrorOutputPaths:  opt.ErrorOutputPaths,
 		InitialFields:     opt.InitialFields,
 	}
-	return config.Build(opt.Options...)
+	if len(opt.Sinks) == 0 {
+		return config.Build(opt.Options...)
+	}
+	cores := make([]zapcore.Core, 0, len(opt.Sinks))
+	for i, sink := range opt.Sinks {
+		core, err := newSinkCore(config, sink)
+		if err != nil {
+			return nil, fmt.Errorf("unable create log.sinks[%d], got: %w", i, err)
+		}
+		cores = append(cores, core)
+	}
+	tee := zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(append([]zapcore.Core{core}, cores...)...)
+	})
+	return config.Build(append([]zap.Option{tee}, opt.Options...)...)
+}
+
+// newSinkCore creates the core writing to a sink, it's built from the config of the logger so that the sink
+// is sampled and carries the initial fields alike. The level of the logger is shared when the sink sets none.
+func newSinkCore(config zap.Config, sink Sink) (zapcore.Core, error) {
+	if sink.Level != "" {
+		level, err := zap.ParseAtomicLevel(sink.Level)
+		if err != nil {
+			return nil, err
+		}
+		config.Level = level
+	}
+	config.Encoding = sink.Encoding
+	config.OutputPaths = sink.OutputPaths
+	l, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	return l.Core(), nil
 }
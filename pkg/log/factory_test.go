@@ -15,7 +15,12 @@ package log_test
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/frp-sigs/frp-provisioner/pkg/log"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -46,3 +51,39 @@ func Test_LogContext(t *testing.T) {
 
 	log.FromContext(ctx).Sugar().Info("hello world")
 }
+
+func Test_NewLoggerSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manager.json")
+
+	opts := log.NewOptions()
+	opts.SetDefaults()
+	opts.Level.SetLevel(zap.InfoLevel)
+	opts.Sinks = []log.Sink{{Encoding: "json", Level: "debug", OutputPaths: []string{path}}}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := log.NewLogger(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hello debug")
+	l.Info("hello info")
+	_ = l.Sync()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines; got %d", len(lines))
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "hello debug" {
+		t.Fatalf("expected 'hello debug'; got %v", entry["msg"])
+	}
+}
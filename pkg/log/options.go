@@ -19,10 +19,12 @@ import (
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"strings"
 )
 
 var (
 	_ pflag.Value = (*atomicLevel)(nil)
+	_ pflag.Value = (*sinksValue)(nil)
 )
 
 type atomicLevel struct {
@@ -49,6 +51,73 @@ func (a *atomicLevel) Set(s string) error {
 	return nil
 }
 
+// Sink is an additional output of the logger with its own encoding and level, e.g. json at the debug level
+// to a file next to the console output at the info level to stderr. It shares the encoder config, the
+// sampling and the initial fields of the logger.
+type Sink struct {
+	// Level is the minimum enabled logging level of the sink, it follows the
+	// level of the logger when it's empty.
+	Level string `json:"level,omitempty" yaml:"level,omitempty"`
+
+	// Encoding sets the encoding of the sink, "json" or "console".
+	Encoding string `json:"encoding" yaml:"encoding"`
+
+	// OutputPaths is a list of URLs or file paths to write the output of the sink to.
+	OutputPaths []string `json:"outputPaths" yaml:"outputPaths"`
+}
+
+// String formats the sink as the log.sink flag, "{encoding}:{level}:{path},{path}"
+func (s Sink) String() string {
+	return s.Encoding + ":" + s.Level + ":" + strings.Join(s.OutputPaths, ",")
+}
+
+// parseSink parses a sink formatted as the log.sink flag
+func parseSink(value string) (Sink, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return Sink{}, fmt.Errorf("invalid log sink '%s', expected '{encoding}:{level}:{path},{path}'", value)
+	}
+	sink := Sink{Encoding: parts[0], Level: parts[1]}
+	if parts[2] != "" {
+		sink.OutputPaths = strings.Split(parts[2], ",")
+	}
+	return sink, nil
+}
+
+type sinksValue struct {
+	sinks   *[]Sink
+	changed bool
+}
+
+// String is the method to format the flag's value, part of the pflag.Value interface.
+func (v *sinksValue) String() string {
+	values := make([]string, 0, len(*v.sinks))
+	for _, sink := range *v.sinks {
+		values = append(values, sink.String())
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+// Type is the type of the value
+func (v *sinksValue) Type() string {
+	return "stringArray"
+}
+
+// Set is the method to set the flag value, part of the pflag.Value interface. The first value replaces the
+// sinks of the config file, the next ones are appended.
+func (v *sinksValue) Set(s string) error {
+	sink, err := parseSink(s)
+	if err != nil {
+		return err
+	}
+	if !v.changed {
+		*v.sinks = nil
+		v.changed = true
+	}
+	*v.sinks = append(*v.sinks, sink)
+	return nil
+}
+
 // Options is the log options struct for zap logger
 type Options struct {
 	// Options the zap options
@@ -98,6 +167,10 @@ type Options struct {
 
 	// InitialFields is a collection of fields to add to the root logger.
 	InitialFields map[string]interface{} `json:"initialFields" yaml:"initialFields"`
+
+	// Sinks are the outputs written to next to OutputPaths, each with its own
+	// encoding and level.
+	Sinks []Sink `json:"sinks,omitempty" yaml:"sinks,omitempty"`
 }
 
 // SetDefaults sets the default values.
@@ -167,6 +240,10 @@ func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringArrayVar(&o.OutputPaths, "log.output-paths", o.OutputPaths, "The file path to write logging output to. "+
 		"Can be specified multiple times, and can be a file path or URL. "+
 		"Standard error is used if no paths are given.")
+
+	fs.Var(&sinksValue{sinks: &o.Sinks}, "log.sink", "An additional logging output with its own encoding and "+
+		"level as '{encoding}:{level}:{path},{path}', e.g. 'json:debug:/var/log/manager.json'. The level of "+
+		"the sink follows log.level when it's empty. Can be specified multiple times.")
 }
 
 // Validate verify the configuration and return an error if correct
@@ -183,6 +260,19 @@ func (o *Options) Validate() (err error) {
 	if len(o.ErrorOutputPaths) == 0 {
 		err = errors.Join(err, fmt.Errorf("log.errorOutputPaths is required"))
 	}
+	for i, sink := range o.Sinks {
+		if sink.Encoding == "" {
+			err = errors.Join(err, fmt.Errorf("log.sinks[%d].encoding is required", i))
+		}
+		if len(sink.OutputPaths) == 0 {
+			err = errors.Join(err, fmt.Errorf("log.sinks[%d].outputPaths is required", i))
+		}
+		if sink.Level != "" {
+			if _, parseErr := zapcore.ParseLevel(sink.Level); parseErr != nil {
+				err = errors.Join(err, fmt.Errorf("invalid log.sinks[%d].level, got: %w", i, parseErr))
+			}
+		}
+	}
 	return err
 }
 
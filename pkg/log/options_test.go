@@ -62,3 +62,37 @@ func TestOptions_AddFlags(t *testing.T) {
 		t.Fatalf("expected 'json'; got %v", options.Encoding)
 	}
 }
+
+func TestOptions_AddFlagsSinks(t *testing.T) {
+	args := []string{
+		"--log.sink=json:debug:/var/log/manager.json",
+		"--log.sink=console::stdout,stderr",
+	}
+
+	options := log.NewOptions()
+	options.SetDefaults()
+	options.Sinks = []log.Sink{{Encoding: "json", OutputPaths: []string{"stdout"}}}
+
+	cleanFlags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	options.AddFlags(cleanFlags)
+
+	if err := cleanFlags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(options.Sinks) != 2 {
+		t.Fatalf("expected 2 sinks; got %v", options.Sinks)
+	}
+
+	if got := options.Sinks[0].String(); got != "json:debug:/var/log/manager.json" {
+		t.Fatalf("expected 'json:debug:/var/log/manager.json'; got %v", got)
+	}
+
+	if len(options.Sinks[1].OutputPaths) != 2 || options.Sinks[1].Level != "" {
+		t.Fatalf("expected 2 output paths and no level; got %v", options.Sinks[1])
+	}
+
+	if err := cleanFlags.Parse([]string{"--log.sink=json"}); err == nil {
+		t.Fatal("expected error; got nil")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
+)
+
+// OpenMetricsPath is the path of the metrics server serving the OpenMetrics format, the exemplars are
+// only exposed in the OpenMetrics format.
+const OpenMetricsPath = "/metrics/openmetrics"
+
+type traceIDKey struct{}
+
+// NewTraceID returns a random W3C trace id
+func NewTraceID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// ContextWithTraceID returns a copy of ctx carrying the trace id
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns the trace id carried by ctx, "" is returned when ctx is not traced
+func TraceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// ObserveWithExemplar records the observation with the trace id of ctx as its exemplar, the observation
+// is recorded without exemplar when ctx is not traced.
+func ObserveWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	traceID := TraceIDFromContext(ctx)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && traceID != "" {
+		exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{LabelTraceID: traceID})
+		return
+	}
+	observer.Observe(value)
+}
+
+// OpenMetricsHandler serves the metrics of the controller-runtime registry in the OpenMetrics format
+func OpenMetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{
+		ErrorHandling:     promhttp.HTTPErrorOnError,
+		EnableOpenMetrics: true,
+	})
+}
+
+// InstrumentReconciler records the latency of the reconciles of the controller. When tracing is enabled
+// each reconcile is assigned a trace id, which is added to the logger of the reconcile and attached as the
+// exemplar of the latency so a latency spike leads to the logs of the reconcile. A panic of the reconcile
+// is recovered and returned as an error, so the request is retried with backoff instead of crashing the
+// manager, the trace id is its correlation id.
+func InstrumentReconciler(controller string, r reconcile.Reconciler, tracing bool) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (result reconcile.Result, err error) {
+		if tracing {
+			traceID := NewTraceID()
+			ctx = ContextWithTraceID(ctx, traceID)
+			ctx = log.IntoContext(ctx, log.FromContext(ctx).WithValues("traceID", traceID))
+		}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				correlationID := TraceIDFromContext(ctx)
+				if correlationID == "" {
+					correlationID = NewTraceID()
+				}
+				result, err = reconcile.Result{}, recoverPanic(ctx, PanicKindReconciler, controller, correlationID, recovered)
+			}
+		}()
+		start := time.Now()
+		result, err = r.Reconcile(ctx, req)
+		ObserveWithExemplar(ctx, ReconcileDurationSeconds.WithLabelValues(controller), time.Since(start).Seconds())
+		return result, err
+	})
+}
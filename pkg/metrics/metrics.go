@@ -18,15 +18,263 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
+// Names and labels of the metrics exported by this build, generated dashboards and alert rules
+// reference them so monitoring stays in lockstep with the code.
+const (
+	ReconcilesTotalName               = "reconciles_total"
+	OIDCTokenAgeName                  = "oidc_token_age_seconds"
+	OIDCTokenRefreshFailuresTotalName = "oidc_token_refresh_failures_total"
+	TunnelReadyName                   = "tunnel_ready"
+	TunnelReconnectsTotalName         = "tunnel_reconnects_total"
+	TunnelMuxStreamOpenSecondsName    = "tunnel_mux_stream_open_seconds"
+	TunnelMuxStreamsName              = "tunnel_mux_streams"
+	WebhookRejectionsTotalName        = "webhook_rejections_total"
+	CanaryFailedName                  = "canary_failed"
+	ReconcileDurationSecondsName      = "reconcile_duration_seconds"
+	LoginDurationSecondsName          = "login_duration_seconds"
+	ForwardedEventsTotalName          = "forwarded_events_total"
+	ReconcilePhaseDurationSecondsName = "reconcile_phase_duration_seconds"
+	ManagedObjectsName                = "managed_objects"
+	InformerCacheBytesName            = "informer_cache_bytes"
+	CloudEventsTotalName              = "cloudevents_total"
+	CRDSchemaDriftName                = "crd_schema_drift"
+	OutageQueueDepthName              = "outage_queue_depth"
+	SuppressedEventsTotalName         = "suppressed_events_total"
+	SkippedStatusUpdatesTotalName     = "skipped_status_updates_total"
+	PanicsTotalName                   = "panics_total"
+	ConfigOptionChangedName           = "config_option_changed"
+	ClockSkewSecondsName              = "clock_skew_seconds"
+	LoginAttemptsTotalName            = "login_attempts_total"
+	LoginFailuresTotalName            = "login_failures_total"
+	ProxyCreateFailuresTotalName      = "proxy_create_failures_total"
+	ActiveTunnelsName                 = "active_tunnels"
+	StaleRoutesName                   = "stale_routes"
+	// ReconcileTimeName is the reconcile latency histogram exported by controller-runtime
+	ReconcileTimeName = "controller_runtime_reconcile_time_seconds"
+
+	LabelServer     = "server"
+	LabelNamespace  = "namespace"
+	LabelService    = "service"
+	LabelController = "controller"
+	LabelField      = "field"
+	LabelReason     = "reason"
+	LabelResult     = "result"
+	LabelPhase      = "phase"
+	LabelKind       = "kind"
+	LabelType       = "type"
+	LabelCRD        = "crd"
+	LabelHandler    = "handler"
+	LabelOption     = "option"
+	// LabelTraceID is the exemplar label linking an observation to its trace
+	LabelTraceID = "trace_id"
+)
+
 var (
 	ReconcilesTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "reconciles_total",
+			Name: ReconcilesTotalName,
 			Help: "Number of total reconciliation attempts",
 		},
 	)
+	OIDCTokenAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: OIDCTokenAgeName,
+			Help: "Age of the cached OIDC access token used to authenticate with frp server",
+		},
+		[]string{LabelServer},
+	)
+	OIDCTokenRefreshFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: OIDCTokenRefreshFailuresTotalName,
+			Help: "Number of failed OIDC access token refresh attempts",
+		},
+		[]string{LabelServer},
+	)
+	TunnelReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: TunnelReadyName,
+			Help: "Whether the frp tunnel of a LoadBalancer service is ready (1) or not (0)",
+		},
+		[]string{LabelNamespace, LabelService, LabelServer},
+	)
+	TunnelReconnectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: TunnelReconnectsTotalName,
+			Help: "Number of times the frp tunnel of a LoadBalancer service became ready again after being lost",
+		},
+		[]string{LabelNamespace, LabelService, LabelServer},
+	)
+	TunnelMuxStreamOpenSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    TunnelMuxStreamOpenSecondsName,
+			Help:    "Latency of opening a stream on the tcp multiplexing sessions of the manager with frp server, the frp client pods are not covered",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		},
+		[]string{LabelServer},
+	)
+	TunnelMuxStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: TunnelMuxStreamsName,
+			Help: "Number of concurrent streams open on the tcp multiplexing sessions of the manager with frp server, the frp client pods are not covered",
+		},
+		[]string{LabelServer},
+	)
+	WebhookRejectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: WebhookRejectionsTotalName,
+			Help: "Number of FrpServer fields rejected by the validating admission webhook",
+		},
+		[]string{LabelField, LabelReason},
+	)
+	CanaryFailed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: CanaryFailedName,
+			Help: "Whether the frp client pod template failed its canary validation (1) and the previous template is restored, or not (0)",
+		},
+	)
+	ReconcileDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    ReconcileDurationSecondsName,
+			Help:    "Latency of the reconciles of frp-provisioner controllers, with trace id exemplars when tracing is enabled",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{LabelController},
+	)
+	LoginDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    LoginDurationSecondsName,
+			Help:    "Latency of logging in to frp server, with trace id exemplars when tracing is enabled",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{LabelServer},
+	)
+	ForwardedEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: ForwardedEventsTotalName,
+			Help: "Number of Warning events forwarded to the event webhook by result, sent, failed or dropped by the rate limit",
+		},
+		[]string{LabelResult},
+	)
+	ReconcilePhaseDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    ReconcilePhaseDurationSecondsName,
+			Help:    "Latency of the phases of the reconciles of frp-provisioner controllers, e.g. listing and claiming the frp client pods",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+		},
+		[]string{LabelController, LabelPhase},
+	)
+	ManagedObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: ManagedObjectsName,
+			Help: "Number of objects in the informer cache of the manager by kind, frp_client_pods are the pods generated for the services",
+		},
+		[]string{LabelKind},
+	)
+	InformerCacheBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: InformerCacheBytesName,
+			Help: "Approximate memory of the objects in the informer cache of the manager by kind, estimated from their serialized size",
+		},
+		[]string{LabelKind},
+	)
+	CloudEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: CloudEventsTotalName,
+			Help: "Number of provisioning CloudEvents posted to the sink by type and result, sent, failed or dropped when the queue is full",
+		},
+		[]string{LabelType, LabelResult},
+	)
+	CRDSchemaDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: CRDSchemaDriftName,
+			Help: "Whether the CRD installed in the cluster is missing or older than the schema compiled into the manager, checked on startup",
+		},
+		[]string{LabelCRD},
+	)
+	OutageQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: OutageQueueDepthName,
+			Help: "Number of services whose proxy changes are queued until their Unhealthy frp server is healthy again",
+		},
+		[]string{LabelServer},
+	)
+	SuppressedEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: SuppressedEventsTotalName,
+			Help: "Number of Kubernetes events not recorded by reason, duplicate within the dedup window or rate_limited per object",
+		},
+		[]string{LabelReason},
+	)
+	SkippedStatusUpdatesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: SkippedStatusUpdatesTotalName,
+			Help: "Number of status writes skipped by controller because the status was unchanged",
+		},
+		[]string{LabelController},
+	)
+	PanicsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: PanicsTotalName,
+			Help: "Number of panics recovered by kind, reconciler or webhook, and handler",
+		},
+		[]string{LabelKind, LabelHandler},
+	)
+	ConfigOptionChanged = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: ConfigOptionChangedName,
+			Help: "Set to 1 for the options whose effective value changed since the previous run of the manager",
+		},
+		[]string{LabelOption},
+	)
+	ClockSkewSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: ClockSkewSecondsName,
+			Help: "Offset of the clock of the frp server host from the manager, positive when the host is ahead",
+		},
+		[]string{LabelServer},
+	)
+	LoginAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: LoginAttemptsTotalName,
+			Help: "Number of login attempts to frp server, one per tried transport protocol",
+		},
+		[]string{LabelServer},
+	)
+	LoginFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: LoginFailuresTotalName,
+			Help: "Number of failed login attempts to frp server, unreachable or rejected by the server",
+		},
+		[]string{LabelServer},
+	)
+	ProxyCreateFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: ProxyCreateFailuresTotalName,
+			Help: "Number of failed proxy creations by kind, the frp client pods of a Service or the proxies of a FrpProxy",
+		},
+		[]string{LabelKind, LabelServer},
+	)
+	ActiveTunnels = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: ActiveTunnelsName,
+			Help: "Number of ready frp tunnels of LoadBalancer services by frp server",
+		},
+		[]string{LabelServer},
+	)
+	StaleRoutes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: StaleRoutesName,
+			Help: "Number of online http and https routes of frp server left by deleted or moved objects which could not be closed",
+		},
+		[]string{LabelServer},
+	)
 )
 
 func init() {
-	metrics.Registry.MustRegister(ReconcilesTotal)
+	metrics.Registry.MustRegister(ReconcilesTotal, OIDCTokenAge, OIDCTokenRefreshFailuresTotal,
+		TunnelReady, TunnelReconnectsTotal, TunnelMuxStreamOpenSeconds, TunnelMuxStreams, WebhookRejectionsTotal, CanaryFailed,
+		ReconcileDurationSeconds, LoginDurationSeconds, ForwardedEventsTotal, ReconcilePhaseDurationSeconds, ManagedObjects,
+		InformerCacheBytes, CloudEventsTotal, CRDSchemaDrift, OutageQueueDepth, SuppressedEventsTotal,
+		SkippedStatusUpdatesTotal, PanicsTotal, ConfigOptionChanged, ClockSkewSeconds, LoginAttemptsTotal, LoginFailuresTotal,
+		ProxyCreateFailuresTotal, ActiveTunnels, StaleRoutes)
 }
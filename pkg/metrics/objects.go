@@ -0,0 +1,113 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// Kinds of the objects counted by ObjectCounter
+const (
+	KindServices        = "services"
+	KindFrpServers      = "frpservers"
+	KindFrpServerClaims = "frpserverclaims"
+	KindPods            = "pods"
+	// KindFrpClientPods are the pods generated for the services, a subset of KindPods
+	KindFrpClientPods = "frp_client_pods"
+)
+
+// ObjectCounter periodically exports the number of objects in the informer cache of the manager and their
+// approximate memory, so the capacity of the manager itself can be planned from data.
+type ObjectCounter struct {
+	// Reader reads the objects from the informer cache
+	Reader client.Reader
+	// Interval is the period the objects are counted at
+	Interval time.Duration
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica has its own cache
+func (c *ObjectCounter) NeedLeaderElection() bool {
+	return false
+}
+
+// Start counts the objects every Interval until ctx is done
+func (c *ObjectCounter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		c.count(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ObjectCounter) count(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("object-counter")
+	lists := map[string]client.ObjectList{
+		KindServices:        &v1.ServiceList{},
+		KindFrpServers:      &v1beta1.FrpServerList{},
+		KindFrpServerClaims: &v1beta1.FrpServerClaimList{},
+		KindPods:            &v1.PodList{},
+	}
+	for kind, list := range lists {
+		if err := c.Reader.List(ctx, list); err != nil {
+			logger.Error(err, "unable list cached objects", "kind", kind)
+			continue
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			logger.Error(err, "unable extract cached objects", "kind", kind)
+			continue
+		}
+		ManagedObjects.WithLabelValues(kind).Set(float64(len(items)))
+		InformerCacheBytes.WithLabelValues(kind).Set(float64(objectsSize(items)))
+	}
+	if pods, ok := lists[KindPods].(*v1.PodList); ok {
+		generated := 0
+		for i := range pods.Items {
+			if _, ok := pods.Items[i].Labels[frplabels.ServiceName]; ok {
+				generated++
+			}
+		}
+		ManagedObjects.WithLabelValues(KindFrpClientPods).Set(float64(generated))
+	}
+}
+
+// objectsSize estimates the memory of the objects from their serialized size, the protobuf size of the
+// built-in types and the json size of the custom resources
+func objectsSize(items []runtime.Object) int {
+	size := 0
+	for _, item := range items {
+		if sized, ok := item.(interface{ Size() int }); ok {
+			size += sized.Size()
+			continue
+		}
+		if data, err := json.Marshal(item); err == nil {
+			size += len(data)
+		}
+	}
+	return size
+}
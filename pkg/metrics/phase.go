@@ -0,0 +1,41 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// Phases of the Service reconcile timed by StartPhase
+const (
+	PhaseListPods     = "list_pods"
+	PhaseClaim        = "claim"
+	PhaseGenerate     = "generate"
+	PhaseCreate       = "create"
+	PhaseStatusUpdate = "status_update"
+)
+
+// StartPhase starts timing a phase of a reconcile of the controller, the returned func ends the phase. The
+// duration is logged at debug level and recorded by ReconcilePhaseDurationSeconds with the trace id of ctx
+// as exemplar.
+func StartPhase(ctx context.Context, controller, phase string) func() {
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		log.FromContext(ctx).V(1).Info("reconcile phase completed", "phase", phase, "duration", elapsed.String())
+		ObserveWithExemplar(ctx, ReconcilePhaseDurationSeconds.WithLabelValues(controller, phase), elapsed.Seconds())
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Kinds of the handlers whose panics are recovered
+const (
+	PanicKindReconciler = "reconciler"
+	PanicKindWebhook    = "webhook"
+)
+
+// recoverPanic counts and logs the recovered panic of a handler with its stack trace, it returns the error
+// the handler fails with. The correlation id is logged and returned with the error so the failed request
+// leads to the stack trace.
+func recoverPanic(ctx context.Context, kind, handler, correlationID string, recovered any) error {
+	PanicsTotal.WithLabelValues(kind, handler).Inc()
+	log.FromContext(ctx).Error(fmt.Errorf("%v", recovered), "recovered panic", "kind", kind, "handler", handler,
+		"correlationID", correlationID, "stacktrace", string(debug.Stack()))
+	return fmt.Errorf("%s %s panicked, correlation id %s: %v", kind, handler, correlationID, recovered)
+}
+
+// InstrumentHandler recovers the panics of the admission handler, the request is answered with an internal
+// error the API server retries according to the failure policy of the webhook instead of crashing the
+// manager. The uid of the admission request is the correlation id.
+func InstrumentHandler(webhook string, h admission.Handler) admission.Handler {
+	return admission.HandlerFunc(func(ctx context.Context, req admission.Request) (resp admission.Response) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				resp = admission.Errored(http.StatusInternalServerError, recoverPanic(ctx, PanicKindWebhook, webhook, string(req.UID), recovered))
+			}
+		}()
+		return h.Handle(ctx, req)
+	})
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observability
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+	"strconv"
+)
+
+const (
+	defaultRuleName        = "frp-provisioner-slo"
+	defaultRuleNamespace   = "frp-provisioner-system"
+	defaultTunnelObjective = 99.9
+	// tunnelErrorRatioRecord is the recording rule name of the tunnel error ratio, suffixed by the window
+	tunnelErrorRatioRecord = "frp_provisioner:tunnel_error_ratio:avg"
+)
+
+// burnRateAlert is a multi-window burn-rate alert, the alert fires when the error budget burns
+// faster than factor over both the long and the short window.
+type burnRateAlert struct {
+	long, short string
+	factor      float64
+	severity    string
+}
+
+// burnRateAlerts are the multi-window burn-rate alerts recommended by the Google SRE workbook
+var burnRateAlerts = []burnRateAlert{
+	{long: "1h", short: "5m", factor: 14.4, severity: "critical"},
+	{long: "6h", short: "30m", factor: 6, severity: "critical"},
+	{long: "1d", short: "2h", factor: 3, severity: "warning"},
+	{long: "3d", short: "6h", factor: 1, severity: "warning"},
+}
+
+// SLOOptions contains the configuration of the generated SLO alert rules
+type SLOOptions struct {
+	// Name is the name of the generated PrometheusRule
+	Name string `json:"name"`
+	// Namespace is the namespace of the generated PrometheusRule
+	Namespace string `json:"namespace"`
+	// TunnelObjective is the percentage of time tunnels should be ready, e.g. 99.9
+	TunnelObjective float64 `json:"tunnelObjective"`
+	// Job restricts the queries to the Prometheus job scraping the manager, empty matches all jobs
+	Job string `json:"job"`
+	// RunbookURL is added to the annotations of the generated alerts when not empty
+	RunbookURL string `json:"runbookURL"`
+}
+
+// SetDefaults set default values for SLO options
+func (o *SLOOptions) SetDefaults() {
+	o.Name = util.EmptyOr(o.Name, defaultRuleName)
+	o.Namespace = util.EmptyOr(o.Namespace, defaultRuleNamespace)
+	o.TunnelObjective = util.EmptyOr(o.TunnelObjective, defaultTunnelObjective)
+}
+
+// Validate validates the SLO options
+func (o *SLOOptions) Validate() (err error) {
+	if o.Name == "" {
+		err = errors.Join(err, fmt.Errorf("name is required"))
+	}
+	if o.TunnelObjective <= 0 || o.TunnelObjective >= 100 {
+		err = errors.Join(err, fmt.Errorf("tunnel objective must be in the range (0, 100), got: %v", o.TunnelObjective))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *SLOOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Name, "name", o.Name, "Is the name of the generated PrometheusRule.")
+	fs.StringVar(&o.Namespace, "namespace", o.Namespace, "Is the namespace of the generated PrometheusRule.")
+	fs.Float64Var(&o.TunnelObjective, "tunnel-objective", o.TunnelObjective, "Is the percentage of time tunnels should be ready.")
+	fs.StringVar(&o.Job, "job", o.Job, "Restricts the queries to the Prometheus job scraping the manager, empty matches all jobs.")
+	fs.StringVar(&o.RunbookURL, "runbook-url", o.RunbookURL, "Is added to the annotations of the generated alerts when not empty.")
+}
+
+// PrometheusRule is the subset of the prometheus-operator PrometheusRule used by the generator
+type PrometheusRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              PrometheusRuleSpec `json:"spec"`
+}
+
+// PrometheusRuleSpec contains the rule groups of a PrometheusRule
+type PrometheusRuleSpec struct {
+	Groups []RuleGroup `json:"groups"`
+}
+
+// RuleGroup is a group of recording and alerting rules
+type RuleGroup struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is a recording or alerting rule
+type Rule struct {
+	Record      string            `json:"record,omitempty"`
+	Alert       string            `json:"alert,omitempty"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NewSLORule generates the PrometheusRule encoding the tunnel availability SLO with
+// multi-window burn-rate alerts for the metrics exported by this build
+func NewSLORule(o *SLOOptions) *PrometheusRule {
+	sel := (&DashboardOptions{Job: o.Job}).selector()
+	errorBudget := 1 - o.TunnelObjective/100
+	objective := strconv.FormatFloat(o.TunnelObjective, 'f', -1, 64)
+
+	windows := make([]string, 0)
+	for _, alert := range burnRateAlerts {
+		for _, window := range []string{alert.short, alert.long} {
+			if !lo.Contains(windows, window) {
+				windows = append(windows, window)
+			}
+		}
+	}
+	recordGroup := RuleGroup{Name: "frp-provisioner-slo.rules"}
+	for _, window := range windows {
+		recordGroup.Rules = append(recordGroup.Rules, Rule{
+			Record: tunnelErrorRatioRecord + window,
+			Expr: fmt.Sprintf("1 - (sum(avg_over_time(%[1]s%[2]s[%[3]s])) / count(avg_over_time(%[1]s%[2]s[%[3]s])))",
+				metrics.TunnelReadyName, sel, window),
+			Labels: map[string]string{"slo": "tunnel-ready"},
+		})
+	}
+	alertGroup := RuleGroup{Name: "frp-provisioner-slo.alerts"}
+	for _, alert := range burnRateAlerts {
+		rule := Rule{
+			Alert: "FrpTunnelErrorBudgetBurn",
+			Expr: fmt.Sprintf("%[1]s%[2]s > (%[4]v * %[5]v) and %[1]s%[3]s > (%[4]v * %[5]v)",
+				tunnelErrorRatioRecord, alert.long, alert.short, alert.factor, strconv.FormatFloat(errorBudget, 'g', 6, 64)),
+			For: alert.short,
+			Labels: map[string]string{
+				"severity":    alert.severity,
+				"slo":         "tunnel-ready",
+				"long_window": alert.long,
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("frp tunnels are burning the %s%% availability error budget %vx too fast", objective, alert.factor),
+				"description": fmt.Sprintf("The ratio of frp tunnels which are not ready over the last %s and %s exceeds %v times the error budget of the %s%% tunnel-ready objective.",
+					alert.long, alert.short, alert.factor, objective),
+			},
+		}
+		if o.RunbookURL != "" {
+			rule.Annotations["runbook_url"] = o.RunbookURL
+		}
+		alertGroup.Rules = append(alertGroup.Rules, rule)
+	}
+	return &PrometheusRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: "monitoring.coreos.com/v1", Kind: "PrometheusRule"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      o.Name,
+			Namespace: o.Namespace,
+			Labels:    map[string]string{"app.kubernetes.io/name": "frp-provisioner"},
+		},
+		Spec: PrometheusRuleSpec{Groups: []RuleGroup{recordGroup, alertGroup}},
+	}
+}
+
+// MarshalSLORule generates the PrometheusRule YAML
+func MarshalSLORule(o *SLOOptions) ([]byte, error) {
+	return yaml.Marshal(NewSLORule(o))
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package observability
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/spf13/pflag"
+)
+
+const (
+	defaultDashboardTitle = "frp-provisioner"
+	defaultDashboardUID   = "frp-provisioner"
+	// datasourceVariable is the dashboard variable selecting the Prometheus datasource
+	datasourceVariable = "datasource"
+)
+
+// DashboardOptions contains the configuration of the generated Grafana dashboard
+type DashboardOptions struct {
+	// Title is the title of the dashboard
+	Title string `json:"title"`
+	// UID is the unique identifier of the dashboard
+	UID string `json:"uid"`
+	// Job restricts the queries to the Prometheus job scraping the manager, empty matches all jobs
+	Job string `json:"job"`
+}
+
+// SetDefaults set default values for dashboard options
+func (o *DashboardOptions) SetDefaults() {
+	o.Title = util.EmptyOr(o.Title, defaultDashboardTitle)
+	o.UID = util.EmptyOr(o.UID, defaultDashboardUID)
+}
+
+// AddFlags add related command line parameters
+func (o *DashboardOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Title, "title", o.Title, "Is the title of the generated dashboard.")
+	fs.StringVar(&o.UID, "uid", o.UID, "Is the unique identifier of the generated dashboard.")
+	fs.StringVar(&o.Job, "job", o.Job, "Restricts the queries to the Prometheus job scraping the manager, empty matches all jobs.")
+}
+
+// Dashboard is the subset of the Grafana dashboard JSON model used by the generator
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+// TimeRange is the default time range of the dashboard
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Templating holds the dashboard variables
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+// Variable is a dashboard variable
+type Variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+// Panel is a dashboard panel
+type Panel struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Type        string      `json:"type"`
+	Datasource  Datasource  `json:"datasource"`
+	GridPos     GridPos     `json:"gridPos"`
+	Targets     []Target    `json:"targets"`
+	FieldConfig FieldConfig `json:"fieldConfig"`
+}
+
+// Datasource references the datasource of a panel
+type Datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// GridPos is the position of a panel
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Target is a query of a panel
+type Target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	// Exemplar shows the exemplars of the query, they link to the trace of an observation
+	Exemplar bool `json:"exemplar,omitempty"`
+}
+
+// FieldConfig configures how panel values are displayed
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+// FieldDefaults is the default field configuration of a panel
+type FieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+// selector returns the label selector restricting a metric to the configured job
+func (o *DashboardOptions) selector() string {
+	if o.Job == "" {
+		return ""
+	}
+	return fmt.Sprintf(`{job=%q}`, o.Job)
+}
+
+// NewDashboard generates the Grafana dashboard for the metrics exported by this build
+func NewDashboard(o *DashboardOptions) *Dashboard {
+	sel := o.selector()
+	panels := []struct {
+		title, kind, unit string
+		targets           []Target
+	}{
+		{
+			title: "Ready tunnels", kind: "stat",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("sum(%s%s)", metrics.TunnelReadyName, sel),
+				LegendFormat: "ready",
+			}, {
+				Expr:         fmt.Sprintf("count(%s%s)", metrics.TunnelReadyName, sel),
+				LegendFormat: "total",
+			}},
+		},
+		{
+			title: "Tunnel readiness", kind: "timeseries",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("%s%s", metrics.TunnelReadyName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}/{{%s}}", metrics.LabelNamespace, metrics.LabelService),
+			}},
+		},
+		{
+			title: "Tunnel reconnects", kind: "timeseries",
+			targets: []Target{{
+				Expr: fmt.Sprintf("sum by (%s, %s) (increase(%s%s[$__rate_interval]))",
+					metrics.LabelNamespace, metrics.LabelService, metrics.TunnelReconnectsTotalName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}/{{%s}}", metrics.LabelNamespace, metrics.LabelService),
+			}},
+		},
+		{
+			title: "Manager mux stream open latency", kind: "timeseries", unit: "s",
+			targets: []Target{{
+				Expr: fmt.Sprintf("histogram_quantile(0.99, sum by (le, %s) (rate(%s_bucket%s[$__rate_interval])))",
+					metrics.LabelServer, metrics.TunnelMuxStreamOpenSecondsName, sel),
+				LegendFormat: fmt.Sprintf("p99 {{%s}}", metrics.LabelServer),
+			}},
+		},
+		{
+			title: "Manager concurrent mux streams", kind: "timeseries",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("sum by (%s) (%s%s)", metrics.LabelServer, metrics.TunnelMuxStreamsName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}", metrics.LabelServer),
+			}},
+		},
+		{
+			title: "Reconcile latency", kind: "timeseries", unit: "s",
+			targets: []Target{{
+				Expr: fmt.Sprintf("histogram_quantile(0.5, sum by (le, %s) (rate(%s_bucket%s[$__rate_interval])))",
+					metrics.LabelController, metrics.ReconcileTimeName, sel),
+				LegendFormat: fmt.Sprintf("p50 {{%s}}", metrics.LabelController),
+			}, {
+				Expr: fmt.Sprintf("histogram_quantile(0.99, sum by (le, %s) (rate(%s_bucket%s[$__rate_interval])))",
+					metrics.LabelController, metrics.ReconcileTimeName, sel),
+				LegendFormat: fmt.Sprintf("p99 {{%s}}", metrics.LabelController),
+			}},
+		},
+		{
+			title: "Login latency", kind: "timeseries", unit: "s",
+			targets: []Target{{
+				Expr: fmt.Sprintf("histogram_quantile(0.99, sum by (le, %s) (rate(%s_bucket%s[$__rate_interval])))",
+					metrics.LabelServer, metrics.LoginDurationSecondsName, sel),
+				LegendFormat: fmt.Sprintf("p99 {{%s}}", metrics.LabelServer),
+				Exemplar:     true,
+			}},
+		},
+		{
+			title: "Login failures", kind: "timeseries",
+			targets: []Target{{
+				Expr: fmt.Sprintf("sum by (%s) (increase(%s%s[$__rate_interval]))",
+					metrics.LabelServer, metrics.LoginFailuresTotalName, sel),
+				LegendFormat: fmt.Sprintf("failed {{%s}}", metrics.LabelServer),
+			}, {
+				Expr: fmt.Sprintf("sum by (%s) (increase(%s%s[$__rate_interval]))",
+					metrics.LabelServer, metrics.LoginAttemptsTotalName, sel),
+				LegendFormat: fmt.Sprintf("attempts {{%s}}", metrics.LabelServer),
+			}},
+		},
+		{
+			title: "Active tunnels", kind: "timeseries",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("sum by (%s) (%s%s)", metrics.LabelServer, metrics.ActiveTunnelsName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}", metrics.LabelServer),
+			}},
+		},
+		{
+			title: "Proxy create failures", kind: "timeseries",
+			targets: []Target{{
+				Expr: fmt.Sprintf("sum by (%s, %s) (increase(%s%s[$__rate_interval]))",
+					metrics.LabelKind, metrics.LabelServer, metrics.ProxyCreateFailuresTotalName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}} {{%s}}", metrics.LabelKind, metrics.LabelServer),
+			}},
+		},
+		{
+			title: "Reconcile phase latency", kind: "timeseries", unit: "s",
+			targets: []Target{{
+				Expr: fmt.Sprintf("histogram_quantile(0.99, sum by (le, %s, %s) (rate(%s_bucket%s[$__rate_interval])))",
+					metrics.LabelController, metrics.LabelPhase, metrics.ReconcilePhaseDurationSecondsName, sel),
+				LegendFormat: fmt.Sprintf("p99 {{%s}} {{%s}}", metrics.LabelController, metrics.LabelPhase),
+				Exemplar:     true,
+			}},
+		},
+		{
+			title: "Reconciles", kind: "timeseries", unit: "ops",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("sum(rate(%s%s[$__rate_interval]))", metrics.ReconcilesTotalName, sel),
+				LegendFormat: "reconciles",
+			}},
+		},
+		{
+			title: "OIDC token age", kind: "timeseries", unit: "s",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("%s%s", metrics.OIDCTokenAgeName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}", metrics.LabelServer),
+			}},
+		},
+		{
+			title: "OIDC token refresh failures", kind: "timeseries",
+			targets: []Target{{
+				Expr: fmt.Sprintf("sum by (%s) (increase(%s%s[$__rate_interval]))",
+					metrics.LabelServer, metrics.OIDCTokenRefreshFailuresTotalName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}", metrics.LabelServer),
+			}},
+		},
+		{
+			title: "Webhook rejections", kind: "timeseries",
+			targets: []Target{{
+				Expr: fmt.Sprintf("sum by (%s, %s) (increase(%s%s[$__rate_interval]))",
+					metrics.LabelField, metrics.LabelReason, metrics.WebhookRejectionsTotalName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}} {{%s}}", metrics.LabelField, metrics.LabelReason),
+			}},
+		},
+		{
+			title: "Managed objects", kind: "timeseries",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("max by (%s) (%s%s)", metrics.LabelKind, metrics.ManagedObjectsName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}", metrics.LabelKind),
+			}},
+		},
+		{
+			title: "Informer cache memory", kind: "timeseries", unit: "bytes",
+			targets: []Target{{
+				Expr:         fmt.Sprintf("max by (%s) (%s%s)", metrics.LabelKind, metrics.InformerCacheBytesName, sel),
+				LegendFormat: fmt.Sprintf("{{%s}}", metrics.LabelKind),
+			}},
+		},
+	}
+
+	dashboard := &Dashboard{
+		UID:           o.UID,
+		Title:         o.Title,
+		Tags:          []string{"frp", "frp-provisioner"},
+		Timezone:      "browser",
+		SchemaVersion: 38,
+		Refresh:       "30s",
+		Time:          TimeRange{From: "now-6h", To: "now"},
+		Templating: Templating{List: []Variable{{
+			Name:  datasourceVariable,
+			Label: "Datasource",
+			Type:  "datasource",
+			Query: "prometheus",
+		}}},
+	}
+	for i, p := range panels {
+		for j := range p.targets {
+			p.targets[j].RefID = string(rune('A' + j))
+		}
+		dashboard.Panels = append(dashboard.Panels, Panel{
+			ID:          i + 1,
+			Title:       p.title,
+			Type:        p.kind,
+			Datasource:  Datasource{Type: "prometheus", UID: "${" + datasourceVariable + "}"},
+			GridPos:     GridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8},
+			Targets:     p.targets,
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: p.unit}},
+		})
+	}
+	return dashboard
+}
+
+// MarshalDashboard generates the Grafana dashboard JSON
+func MarshalDashboard(o *DashboardOptions) ([]byte, error) {
+	return json.MarshalIndent(NewDashboard(o), "", "  ")
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package portforward
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/naming"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+	"time"
+)
+
+const (
+	// secretKeyDataKey is the key of the Secret holding the secret key of the tunnel
+	secretKeyDataKey = "secretKey"
+	// secretKeySize is the number of random bytes of the secret key
+	secretKeySize = 16
+	// pollInterval is the interval the phase of the FrpProxy is checked at while waiting for it
+	pollInterval = time.Second
+)
+
+// Options contains the configuration of a temporary port-forward tunnel
+type Options struct {
+	// Namespace is the namespace of the Service
+	Namespace string `json:"namespace"`
+	// Service is the name of the Service the tunnel forwards to
+	Service string `json:"service"`
+	// Port is the port of the Service the tunnel forwards to
+	Port int32 `json:"port"`
+	// Server is the name of the FrpServer the tunnel is registered on
+	Server string `json:"server"`
+	// TTL is how long the tunnel lives, the manager deletes it once it's expired
+	TTL time.Duration `json:"ttl"`
+	// BindPort is the local port of the visitor on the remote side, 0 uses the port of the Service
+	BindPort int `json:"bindPort"`
+	// WaitTimeout is how long to wait for frps to accept the proxy, 0 doesn't wait
+	WaitTimeout time.Duration `json:"waitTimeout"`
+}
+
+// SetDefaults set default values for port-forward options
+func (o *Options) SetDefaults() {
+	o.Namespace = util.EmptyOr(o.Namespace, v1.NamespaceDefault)
+	o.TTL = util.EmptyOr(o.TTL, time.Hour)
+	o.WaitTimeout = util.EmptyOr(o.WaitTimeout, time.Minute)
+}
+
+// Validate validates the port-forward options
+func (o *Options) Validate() (err error) {
+	if o.Service == "" {
+		err = errors.Join(err, fmt.Errorf("the service is required"))
+	}
+	if o.Server == "" {
+		err = errors.Join(err, fmt.Errorf("the frpserver is required"))
+	}
+	if o.Port <= 0 || o.Port > 65535 {
+		err = errors.Join(err, fmt.Errorf("invalid port %d, expected a port in 1-65535", o.Port))
+	}
+	if o.BindPort < 0 || o.BindPort > 65535 {
+		err = errors.Join(err, fmt.Errorf("invalid bind port %d, expected a port in 0-65535", o.BindPort))
+	}
+	if o.TTL <= 0 {
+		err = errors.Join(err, fmt.Errorf("invalid ttl %s, expected a positive duration", o.TTL))
+	}
+	if o.WaitTimeout < 0 {
+		err = errors.Join(err, fmt.Errorf("invalid wait timeout %s, expected a non-negative duration", o.WaitTimeout))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.Namespace, "namespace", "n", o.Namespace, "Is the namespace of the service.")
+	fs.StringVar(&o.Service, "service", o.Service, "Is the name of the service the tunnel forwards to.")
+	fs.Int32Var(&o.Port, "port", o.Port, "Is the port of the service the tunnel forwards to.")
+	fs.StringVar(&o.Server, "server", o.Server, "Is the name of the frpserver the tunnel is registered on.")
+	fs.DurationVar(&o.TTL, "ttl", o.TTL, "Is how long the tunnel lives, the manager deletes it once it's expired.")
+	fs.IntVar(&o.BindPort, "bind-port", o.BindPort, "Is the local port of the visitor on the remote side, 0 uses the port of the service.")
+	fs.DurationVar(&o.WaitTimeout, "wait-timeout", o.WaitTimeout, "Is how long to wait for frps to accept the proxy, 0 doesn't wait.")
+}
+
+// visitorConfig is the frpc config of the remote side, the common config of the FrpServer and the stcp visitor
+type visitorConfig struct {
+	configv1.ClientCommonConfig
+	Visitors []configv1.VisitorConfigurer `json:"visitors"`
+}
+
+// Run creates a stcp FrpProxy forwarding to the Service port and a Secret holding its secret key, then prints
+// the command running the visitor of the tunnel on the remote side. The FrpProxy carries the expires-at
+// annotation, the manager deletes it once the ttl elapsed and the Secret is garbage collected with it.
+func Run(ctx context.Context, cli client.Client, o *Options, w io.Writer) error {
+	server := &v1beta1.FrpServer{}
+	if err := cli.Get(ctx, client.ObjectKey{Name: o.Server}, server); err != nil {
+		return fmt.Errorf("unable get frpserver '%s', got: %w", o.Server, err)
+	}
+	svc := &v1.Service{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: o.Namespace, Name: o.Service}, svc); err != nil {
+		return fmt.Errorf("unable get service '%s/%s', got: %w", o.Namespace, o.Service, err)
+	}
+	if !lo.ContainsBy(svc.Spec.Ports, func(port v1.ServicePort) bool { return port.Port == o.Port }) {
+		return fmt.Errorf("service '%s/%s' has no port %d", o.Namespace, o.Service, o.Port)
+	}
+	secretKey := make([]byte, secretKeySize)
+	if _, err := io.ReadFull(rand.Reader, secretKey); err != nil {
+		return fmt.Errorf("unable generate secret key, got: %w", err)
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: naming.Prefix(o.Service, "port-forward"), Namespace: o.Namespace},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{secretKeyDataKey: []byte(hex.EncodeToString(secretKey))},
+	}
+	if err := cli.Create(ctx, secret); err != nil {
+		return fmt.Errorf("unable create secret key secret, got: %w", err)
+	}
+	expiresAt := time.Now().Add(o.TTL).UTC().Truncate(time.Second)
+	obj := &v1beta1.FrpProxy{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: naming.Prefix(o.Service, "port-forward"),
+			Namespace:    o.Namespace,
+			Annotations:  map[string]string{v1beta1.AnnotationExpiresAtKey: expiresAt.Format(time.RFC3339)},
+		},
+		Spec: v1beta1.FrpProxySpec{
+			ServerName: o.Server,
+			Type:       v1beta1.ProxyTypeSTCP,
+			Backend:    v1beta1.FrpProxyBackend{ServiceName: o.Service, Port: o.Port},
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: secret.Name},
+				Key:                  secretKeyDataKey,
+			},
+		},
+	}
+	if err := cli.Create(ctx, obj); err != nil {
+		return errors.Join(fmt.Errorf("unable create frpproxy, got: %w", err), client.IgnoreNotFound(cli.Delete(ctx, secret)))
+	}
+	if err := controllerutil.SetOwnerReference(obj, secret, cli.Scheme()); err != nil {
+		return fmt.Errorf("can't set Secret '%s' owner reference: %w", secret.Name, err)
+	}
+	if err := cli.Update(ctx, secret); err != nil {
+		return fmt.Errorf("unable set owner of secret '%s', got: %w", secret.Name, err)
+	}
+	fmt.Fprintf(w, "frpproxy %s/%s forwards to service %s/%s:%d until %s\n", obj.Namespace, obj.Name, o.Namespace, o.Service,
+		o.Port, expiresAt.Format(time.RFC3339))
+	if o.WaitTimeout > 0 {
+		if err := wait(ctx, cli, obj, o.WaitTimeout); err != nil {
+			return err
+		}
+	}
+	bindPort := lo.Ternary(o.BindPort != 0, o.BindPort, int(o.Port))
+	data, err := renderVisitorConfig(server, obj, string(secret.Data[secretKeyDataKey]), bindPort)
+	if err != nil {
+		return err
+	}
+	configFile := fmt.Sprintf("frpc-%s.json", obj.Name)
+	env := lo.Ternary(server.Spec.Auth.Method == v1beta1.FrpServerAuthMethodOIDC, v1beta1.OIDCClientSecretEnv, v1beta1.InlineServerTokenEnv)
+	fmt.Fprintf(w, "\nRun on the remote side with %s set to the credential of frpserver '%s':\n\n", env, server.Name)
+	fmt.Fprintf(w, "  echo '%s' > %s && frpc -c %s\n\n", strings.ReplaceAll(string(data), "'", `'\''`), configFile, configFile)
+	fmt.Fprintf(w, "then connect to 127.0.0.1:%d\n", bindPort)
+	return nil
+}
+
+// wait polls the FrpProxy until it's running, a failed proxy fails the wait
+func wait(ctx context.Context, cli client.Client, obj *v1beta1.FrpProxy, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return fmt.Errorf("unable get frpproxy '%s/%s', got: %w", obj.Namespace, obj.Name, err)
+		}
+		switch obj.Status.Phase {
+		case v1beta1.FrpProxyPhaseRunning:
+			return nil
+		case v1beta1.FrpProxyPhaseFailed:
+			return fmt.Errorf("frpproxy '%s/%s' failed: %s", obj.Namespace, obj.Name, obj.Status.Reason)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for frpproxy '%s/%s' to run: %s", timeout, obj.Namespace, obj.Name, obj.Status.Reason)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// renderVisitorConfig renders the frpc config of the visitor of the FrpProxy, the credentials of the FrpServer
+// are read from the env vars so they are never printed
+func renderVisitorConfig(server *v1beta1.FrpServer, obj *v1beta1.FrpProxy, secretKey string, bindPort int) ([]byte, error) {
+	cfg := &visitorConfig{ClientCommonConfig: frpclient.ClientCommonConfig(server, nil)}
+	cfg.Transport.Protocol = string(util.EmptyOr(server.Status.ActiveProtocol, server.Spec.Transport.Protocol))
+	if server.Spec.Auth.Method == v1beta1.FrpServerAuthMethodOIDC {
+		cfg.Auth.OIDC.ClientSecret = envTemplate(v1beta1.OIDCClientSecretEnv)
+	} else {
+		cfg.Auth.Token = envTemplate(v1beta1.InlineServerTokenEnv)
+	}
+	// The proxy of a FrpProxy is named "{namespace}.{name}" and the visitor logs in as the same user
+	cfg.Visitors = []configv1.VisitorConfigurer{&configv1.STCPVisitorConfig{VisitorBaseConfig: configv1.VisitorBaseConfig{
+		Name:       obj.Namespace + "." + obj.Name + ".visitor",
+		Type:       string(configv1.VisitorTypeSTCP),
+		SecretKey:  secretKey,
+		ServerName: obj.Namespace + "." + obj.Name,
+		BindAddr:   "127.0.0.1",
+		BindPort:   bindPort,
+	}}}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable marshal visitor config, got: %w", err)
+	}
+	return data, nil
+}
+
+// envTemplate returns the frpc config template reading the env var
+func envTemplate(name string) string {
+	return "{{ .Envs." + name + " }}"
+}
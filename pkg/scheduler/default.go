@@ -0,0 +1,54 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultSchedulerName is the name the Default scheduler is registered with
+	DefaultSchedulerName = "default"
+	// LeastLoadedSchedulerName is the name the Default scheduler is registered with too, after its strategy
+	LeastLoadedSchedulerName = "least-loaded"
+)
+
+// Default spreads the Services over the Healthy FrpServers allowing their proxy type, the FrpServer
+// serving the fewest Services wins.
+type Default struct{}
+
+var _ Scheduler = &Default{}
+
+// Filter implements Scheduler
+func (d *Default) Filter(_ context.Context, svc *v1.Service, server *v1beta1.FrpServer) error {
+	if !controllerutils.IsFrpServerActive(server) {
+		return fmt.Errorf("frp server is not healthy, phase %s", server.Status.Phase)
+	}
+	proxyType := util.EmptyOr(svc.Annotations[v1beta1.AnnotationProxyTypeKey], v1beta1.ProxyTypeTCP)
+	if len(server.Spec.AllowedProxyTypes) != 0 && !lo.Contains(server.Spec.AllowedProxyTypes, proxyType) {
+		return fmt.Errorf("proxy type '%s' is not allowed, allowed types are %v", proxyType, server.Spec.AllowedProxyTypes)
+	}
+	return nil
+}
+
+// Score implements Scheduler
+func (d *Default) Score(_ context.Context, _ *v1.Service, server *v1beta1.FrpServer) (int64, error) {
+	return -int64(len(server.Status.ServiceReferences)), nil
+}
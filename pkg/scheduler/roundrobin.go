@@ -0,0 +1,56 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"context"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"sync"
+)
+
+// RoundRobinSchedulerName is the name the RoundRobin scheduler is registered with
+const RoundRobinSchedulerName = "round-robin"
+
+// RoundRobin places the Services on the Healthy FrpServers allowing their proxy type in turn, by name
+// starting after the FrpServer it picked last. The turn is kept in memory, it restarts with the manager.
+type RoundRobin struct {
+	Default
+	lock sync.Mutex
+	// last is the name of the FrpServer picked last
+	last string
+}
+
+var (
+	_ Scheduler = &RoundRobin{}
+	_ Reserver  = &RoundRobin{}
+)
+
+// Score implements Scheduler, the FrpServers named after the last pick rank first, Schedule breaks the
+// ties by name
+func (r *RoundRobin) Score(_ context.Context, _ *v1.Service, server *v1beta1.FrpServer) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if server.Name > r.last {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Reserve implements Reserver
+func (r *RoundRobin) Reserve(_ context.Context, _ *v1.Service, server *v1beta1.FrpServer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.last = server.Name
+}
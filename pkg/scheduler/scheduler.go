@@ -0,0 +1,116 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"sync"
+)
+
+// ErrNoFrpServer is returned when no candidate FrpServer passes the filter of the Scheduler
+var ErrNoFrpServer = errors.New("no frp server is schedulable")
+
+// Scheduler places the Services without an assigned FrpServer, it's compiled into the manager and
+// selected by name with the --manager.scheduler flag, so placement logic, e.g. latency maps or cost, is
+// plugged in without patching the Service controller.
+type Scheduler interface {
+	// Filter returns why the FrpServer can't serve the Service, nil means it's a candidate
+	Filter(ctx context.Context, svc *v1.Service, server *v1beta1.FrpServer) error
+	// Score ranks a candidate FrpServer for the Service, the highest score wins
+	Score(ctx context.Context, svc *v1.Service, server *v1beta1.FrpServer) (int64, error)
+}
+
+// Reserver is implemented by the Schedulers keeping track of their placements, e.g. RoundRobin, Reserve is
+// called with the FrpServer picked for the Service
+type Reserver interface {
+	Reserve(ctx context.Context, svc *v1.Service, server *v1beta1.FrpServer)
+}
+
+var (
+	lock       sync.RWMutex
+	schedulers = make(map[string]Scheduler)
+)
+
+func init() {
+	Register(DefaultSchedulerName, &Default{})
+	Register(LeastLoadedSchedulerName, &Default{})
+	Register(RoundRobinSchedulerName, &RoundRobin{})
+	Register(LabelSelectorSchedulerName, &LabelSelector{})
+}
+
+// Register makes a Scheduler available by the provided name, it replaces the Scheduler registered for the name
+func Register(name string, s Scheduler) {
+	lock.Lock()
+	defer lock.Unlock()
+	schedulers[name] = s
+}
+
+// Get returns the Scheduler registered for the name
+func Get(name string) (Scheduler, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	s, ok := schedulers[name]
+	if !ok {
+		return nil, fmt.Errorf("no scheduler registered for name '%s'", name)
+	}
+	return s, nil
+}
+
+// Schedule returns the candidate FrpServer with the highest score for the Service, the ties are broken by
+// name so the placement is stable. The FrpServers not matching the server selector of the Service are no
+// candidates whatever the Scheduler. ErrNoFrpServer joined with the filter results is returned when no
+// FrpServer passes the filter.
+func Schedule(ctx context.Context, s Scheduler, svc *v1.Service, servers []*v1beta1.FrpServer) (*v1beta1.FrpServer, error) {
+	selector, err := ServerSelector(svc)
+	if err != nil {
+		return nil, err
+	}
+	servers = append([]*v1beta1.FrpServer(nil), servers...)
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	var (
+		best      *v1beta1.FrpServer
+		bestScore int64
+		errs      error
+	)
+	for _, server := range servers {
+		if !selector.Matches(labels.Set(server.Labels)) {
+			errs = errors.Join(errs, fmt.Errorf("frp server '%s': labels don't match the server selector '%s'", server.Name, selector))
+			continue
+		}
+		if err := s.Filter(ctx, svc, server); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("frp server '%s': %w", server.Name, err))
+			continue
+		}
+		score, err := s.Score(ctx, svc, server)
+		if err != nil {
+			return nil, fmt.Errorf("unable score frp server '%s', got: %w", server.Name, err)
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = server, score
+		}
+	}
+	if best == nil {
+		return nil, errors.Join(ErrNoFrpServer, errs)
+	}
+	if r, ok := s.(Reserver); ok {
+		r.Reserve(ctx, svc, best)
+	}
+	return best, nil
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ServerSelector returns the label selector of the FrpServers the Service may be scheduled on, every
+// FrpServer is selected when the Service has no server selector annotation.
+func ServerSelector(svc *v1.Service) (labels.Selector, error) {
+	value, ok := svc.Annotations[v1beta1.AnnotationServerSelectorKey]
+	if !ok {
+		return labels.Everything(), nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotations.%s '%s', got: %w", v1beta1.AnnotationServerSelectorKey, value, err)
+	}
+	return selector, nil
+}
+
+// LabelSelectorSchedulerName is the name the LabelSelector scheduler is registered with
+const LabelSelectorSchedulerName = "label-selector"
+
+// LabelSelector pins the Services to the first Healthy FrpServer by name matching their server selector,
+// the Services without a server selector are not scheduled.
+type LabelSelector struct {
+	Default
+}
+
+var _ Scheduler = &LabelSelector{}
+
+// Filter implements Scheduler
+func (l *LabelSelector) Filter(ctx context.Context, svc *v1.Service, server *v1beta1.FrpServer) error {
+	if _, ok := svc.Annotations[v1beta1.AnnotationServerSelectorKey]; !ok {
+		return fmt.Errorf("annotations.%s is required by the %s scheduler", v1beta1.AnnotationServerSelectorKey, LabelSelectorSchedulerName)
+	}
+	return l.Default.Filter(ctx, svc, server)
+}
+
+// Score implements Scheduler
+func (l *LabelSelector) Score(_ context.Context, _ *v1.Service, _ *v1beta1.FrpServer) (int64, error) {
+	return 0, nil
+}
@@ -2,13 +2,31 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/access"
 	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
 	"github.com/frp-sigs/frp-provisioner/pkg/config"
 	"github.com/frp-sigs/frp-provisioner/pkg/controller"
+	"github.com/frp-sigs/frp-provisioner/pkg/crds"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/frp-sigs/frp-provisioner/pkg/emulator"
+	"github.com/frp-sigs/frp-provisioner/pkg/events"
+	"github.com/frp-sigs/frp-provisioner/pkg/gc"
+	"github.com/frp-sigs/frp-provisioner/pkg/inventory"
+	"github.com/frp-sigs/frp-provisioner/pkg/kms"
+	"github.com/frp-sigs/frp-provisioner/pkg/leak"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/frp-sigs/frp-provisioner/pkg/scheduler"
+	"github.com/frp-sigs/frp-provisioner/pkg/service"
 	"github.com/frp-sigs/frp-provisioner/pkg/utils/fieldindex"
+	"github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"net"
+	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -34,8 +52,9 @@ func init() {
 
 // ManagerServer frp controller server
 type ManagerServer struct {
-	mgr ctrl.Manager
-	cfg *config.Configuration
+	mgr     ctrl.Manager
+	cfg     *config.Configuration
+	reloads *controller.ConfigReloads
 }
 
 // Start the frp-provisioner controller server
@@ -50,6 +69,29 @@ func (s *ManagerServer) Start(ctx context.Context) error {
 	return nil
 }
 
+// ConfigReloaded reconciles the services affected by the options applied from the reloaded config file
+func (s *ManagerServer) ConfigReloaded(ctx context.Context, applied []string) error {
+	return s.reloads.Reloaded(ctx, applied)
+}
+
+// AddHealthzCheck registers the named liveness check of a subsystem, it's served at /healthz/{name} and fails
+// /healthz. The checks must be registered before Start.
+func (s *ManagerServer) AddHealthzCheck(name string, check healthz.Checker) error {
+	if err := s.mgr.AddHealthzCheck(name, check); err != nil {
+		return fmt.Errorf("unable add health check '%s', got: %w", name, err)
+	}
+	return nil
+}
+
+// AddReadyzCheck registers the named readiness check of a subsystem, it's served at /readyz/{name} and fails
+// /readyz, e.g. when the state of the subsystem is unreadable. The checks must be registered before Start.
+func (s *ManagerServer) AddReadyzCheck(name string, check healthz.Checker) error {
+	if err := s.mgr.AddReadyzCheck(name, check); err != nil {
+		return fmt.Errorf("unable add ready check '%s', got: %w", name, err)
+	}
+	return nil
+}
+
 // NewManagerServer create frp-provisioner controller server
 func NewManagerServer(ctx context.Context, cfg *config.Configuration) (*ManagerServer, error) {
 	logger := log.FromContext(ctx)
@@ -66,6 +108,8 @@ func NewManagerServer(ctx context.Context, cfg *config.Configuration) (*ManagerS
 			return nil, fmt.Errorf("unable to convert port to number, got: '%w'", err)
 		}
 	}
+	// the webhook and metrics servers negotiate within the tls policy of the manager
+	tlsOpts := []func(*tls.Config){func(c *tls.Config) { cfg.Manager.TLSPolicy.Apply(c, cfg.Manager.FIPS) }}
 	webhookOpts := webhook.Options{
 		Host:         webhookHost,
 		Port:         webhookPort,
@@ -73,6 +117,7 @@ func NewManagerServer(ctx context.Context, cfg *config.Configuration) (*ManagerS
 		CertName:     cfg.Manager.WebhookCertName,
 		KeyName:      cfg.Manager.WebhookKeyName,
 		ClientCAName: cfg.Manager.WebhookClientCAName,
+		TLSOpts:      tlsOpts,
 	}
 	metricsOpts := metricsserver.Options{
 		CertDir:       cfg.Manager.MetricsCertDir,
@@ -80,6 +125,8 @@ func NewManagerServer(ctx context.Context, cfg *config.Configuration) (*ManagerS
 		KeyName:       cfg.Manager.MetricsKeyName,
 		SecureServing: cfg.Manager.MetricsSecureServing,
 		BindAddress:   cfg.Manager.MetricsBindAddress,
+		ExtraHandlers: map[string]http.Handler{metrics.OpenMetricsPath: metrics.OpenMetricsHandler()},
+		TLSOpts:       tlsOpts,
 	}
 	opts := ctrl.Options{
 		Scheme:                        scheme,
@@ -92,16 +139,39 @@ func NewManagerServer(ctx context.Context, cfg *config.Configuration) (*ManagerS
 		RenewDeadline:                 &cfg.Manager.RenewDeadline,
 		RetryPeriod:                   &cfg.Manager.RetryPeriod,
 		Metrics:                       metricsOpts,
-		WebhookServer:                 webhook.NewServer(webhookOpts),
 		HealthProbeBindAddress:        cfg.Manager.HealthProbeBindAddress,
 		PprofBindAddress:              cfg.Manager.PprofBindAddress,
 		GracefulShutdownTimeout:       &cfg.Manager.GracefulShutdownTimeout,
 	}
+	observing := cfg.Manager.Observing()
+	if cfg.Manager.ConfigSnapshotPath != "" {
+		reportConfigChanges(ctx, cfg)
+	}
+	if observing {
+		// the writes are validated by the API server without being persisted, the leases and the admission
+		// webhooks would mutate the cluster, so they're left to the manager reconciling it
+		logger.Info("manager runs in observe mode, the cluster objects and the frps state are never mutated")
+		opts.Client.DryRun = lo.ToPtr(true)
+		opts.LeaderElection = false
+	}
+	enableWebhooks := lo.FromPtr(cfg.Manager.EnableWebhooks) && !observing
+	if enableWebhooks {
+		opts.WebhookServer = webhook.NewServer(webhookOpts)
+	}
 	kubeConfig, err := ctrl.GetConfig()
 	if err != nil {
 		logger.Error(err, "unable to get kubernetes config")
 		return nil, fmt.Errorf("unable to get kubernetes config, got: '%w'", err)
 	}
+	manageCRDs := cfg.Manager.ManageCRDs && !observing
+	if err := crds.Check(ctx, kubeConfig, manageCRDs); err != nil {
+		// the drift check is advisory unless the manager owns the crds
+		if manageCRDs {
+			logger.Error(err, "unable to apply embedded crds")
+			return nil, fmt.Errorf("unable to apply embedded crds, got: %w", err)
+		}
+		logger.Error(err, "unable to check installed crds for schema drift")
+	}
 	mgr, err := ctrl.NewManager(kubeConfig, opts)
 	if err != nil {
 		logger.Error(err, "unable to start manager")
@@ -112,35 +182,262 @@ func NewManagerServer(ctx context.Context, cfg *config.Configuration) (*ManagerS
 		logger.Error(err, "unable  Register Field Indexes to cache")
 		return nil, fmt.Errorf("unable  RegisterFieldIndexes to cache got: '%w'", err)
 	}
-	if err := (&controller.ServiceReconciler{
-		Client:  mgr.GetClient(),
-		Scheme:  mgr.GetScheme(),
-		Options: cfg.Manager,
-	}).SetupWithManager(mgr); err != nil {
+	var kmsService kms.Service
+	if cfg.Manager.KMSKeyFile != "" {
+		if kmsService, err = kms.NewLocalService(cfg.Manager.KMSKeyFile); err != nil {
+			logger.Error(err, "unable to load kms key file")
+			return nil, fmt.Errorf("unable to load kms key file, got: %w", err)
+		}
+	}
+	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		logger.Error(err, "unable to create kubernetes clientset")
+		return nil, fmt.Errorf("unable to create kubernetes clientset, got: %w", err)
+	}
+	var forwarder *events.Forwarder
+	if cfg.Manager.EventWebhookURL != "" {
+		forwarder, err = events.NewForwarder(cfg.Manager.EventWebhookURL, events.Format(cfg.Manager.EventWebhookFormat),
+			cfg.Manager.EventWebhookTemplate, cfg.Manager.EventWebhookRateLimit, mgr.GetScheme())
+		if err != nil {
+			logger.Error(err, "unable to create event forwarder")
+			return nil, fmt.Errorf("unable to create event forwarder, got: %w", err)
+		}
+		if err := mgr.Add(forwarder); err != nil {
+			logger.Error(err, "unable to set up event forwarder")
+			return nil, fmt.Errorf("unable to set up event forwarder, got: %w", err)
+		}
+	}
+	var cloudEvents *events.CloudEventSink
+	if cfg.Manager.CloudEventsSink != "" {
+		if cloudEvents, err = events.NewCloudEventSink(cfg.Manager.CloudEventsSink); err != nil {
+			logger.Error(err, "unable to create cloudevents sink")
+			return nil, fmt.Errorf("unable to create cloudevents sink, got: %w", err)
+		}
+		if err := mgr.Add(cloudEvents); err != nil {
+			logger.Error(err, "unable to set up cloudevents sink")
+			return nil, fmt.Errorf("unable to set up cloudevents sink, got: %w", err)
+		}
+	}
+	deduplicator := &events.Deduplicator{
+		Window:        max(cfg.Manager.EventDedupWindow, 0),
+		PerObjectRate: max(cfg.Manager.EventRateLimitPerObject, 0),
+	}
+	// recorderFor returns the event recorder of a controller, its repeated events are deduplicated and its
+	// Warning events are forwarded to the event webhook when configured. The events are only logged in the
+	// observe mode.
+	recorderFor := func(name string) record.EventRecorder {
+		var recorder record.EventRecorder = &events.LogRecorder{Component: name}
+		if !observing {
+			recorder = mgr.GetEventRecorderFor(name)
+		}
+		if forwarder != nil {
+			recorder = forwarder.Recorder(name, recorder)
+		}
+		return deduplicator.Recorder(name, recorder)
+	}
+	outages := controller.NewOutageQueue()
+	reloads := controller.NewConfigReloads(mgr.GetClient())
+	var accessStore *access.Store
+	if cfg.Manager.AccessPluginBindAddress != "" && cfg.Manager.AccessPluginBindAddress != "0" {
+		accessStore = access.NewStore()
+	}
+	resolver := &credentials.Resolver{}
+	if cfg.Manager.VaultAddress != "" {
+		resolver.Vault = credentials.NewVaultProvider(cfg.Manager.VaultAddress, cfg.Manager.VaultKubernetesMountPath)
+	}
+	serviceReconciler := &controller.ServiceReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Options:     cfg.Manager,
+		KMS:         kmsService,
+		Access:      accessStore,
+		Credentials: resolver,
+		Recorder:    recorderFor("service-controller"),
+		Pods:        clientset.CoreV1(),
+		CloudEvents: cloudEvents,
+		Outages:     outages,
+		Reloads:     reloads,
+	}
+	if err := serviceReconciler.SetupWithManager(mgr); err != nil {
 		logger.Error(err, "unable to setup server reconciler", "controller", "ServiceReconciler")
 		return nil, fmt.Errorf("unable to setup server reconciler, got: %w", err)
 	}
+	proxySessions := service.NewSessions()
+	if err := mgr.Add(proxySessions); err != nil {
+		logger.Error(err, "unable to set up frpproxy sessions")
+		return nil, fmt.Errorf("unable to set up frpproxy sessions, got: %w", err)
+	}
+	authSetters := frpclient.NewAuthSetters()
+	if err := mgr.Add(authSetters); err != nil {
+		logger.Error(err, "unable to set up frpserver auth setters")
+		return nil, fmt.Errorf("unable to set up frpserver auth setters, got: %w", err)
+	}
 	if err := (&controller.FrpServerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Options:     cfg.Manager,
+		Recorder:    recorderFor("frpserver-controller"),
+		Outages:     outages,
+		Sessions:    proxySessions,
+		AuthSetters: authSetters,
+		Credentials: resolver,
 	}).SetupWithManager(mgr); err != nil {
 		logger.Error(err, "unable to setup frpserver reconciler", "controller", "FrpServerReconciler")
 		return nil, fmt.Errorf("unable to setup frpserver reconciler, got: %w", err)
 	}
-	if err = (&controller.FrpServerValidator{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWebhookWithManager(mgr); err != nil {
-		logger.Error(err, "unable to create webhook", "webhook", "FrpServerValidator")
-		return nil, fmt.Errorf("unable to setup FrpServerValidator webhook, got: %w", err)
+	if err := (&controller.FrpServerClaimReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: recorderFor("frpserverclaim-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		logger.Error(err, "unable to setup frpserverclaim reconciler", "controller", "FrpServerClaimReconciler")
+		return nil, fmt.Errorf("unable to setup frpserverclaim reconciler, got: %w", err)
+	}
+	if err := (&controller.FrpServerGroupReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: recorderFor("frpservergroup-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		logger.Error(err, "unable to setup frpservergroup reconciler", "controller", "FrpServerGroupReconciler")
+		return nil, fmt.Errorf("unable to setup frpservergroup reconciler, got: %w", err)
+	}
+	if err := (&controller.FrpProxyReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Options:     cfg.Manager,
+		Recorder:    recorderFor("frpproxy-controller"),
+		Sessions:    proxySessions,
+		Credentials: resolver,
+	}).SetupWithManager(mgr); err != nil {
+		logger.Error(err, "unable to setup frpproxy reconciler", "controller", "FrpProxyReconciler")
+		return nil, fmt.Errorf("unable to setup frpproxy reconciler, got: %w", err)
+	}
+	if enableWebhooks {
+		var rejections *controller.RejectionSummary
+		if cfg.Manager.WebhookRejectionSummaryInterval > 0 {
+			rejections = &controller.RejectionSummary{Interval: cfg.Manager.WebhookRejectionSummaryInterval}
+			if err := mgr.Add(rejections); err != nil {
+				logger.Error(err, "unable to set up webhook rejection summary")
+				return nil, fmt.Errorf("unable to set up webhook rejection summary, got: %w", err)
+			}
+		}
+		if err = (&controller.FrpServerValidator{
+			Client:      mgr.GetClient(),
+			Scheme:      mgr.GetScheme(),
+			Options:     cfg.Manager,
+			Rejections:  rejections,
+			Credentials: resolver,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			logger.Error(err, "unable to create webhook", "webhook", "FrpServerValidator")
+			return nil, fmt.Errorf("unable to setup FrpServerValidator webhook, got: %w", err)
+		}
+		if err = (&controller.PodReadinessGateInjector{}).SetupWebhookWithManager(mgr); err != nil {
+			logger.Error(err, "unable to create webhook", "webhook", "PodReadinessGateInjector")
+			return nil, fmt.Errorf("unable to setup PodReadinessGateInjector webhook, got: %w", err)
+		}
+		if err = (&controller.ServiceProxyTypeValidator{
+			Client:  mgr.GetClient(),
+			Options: cfg.Manager,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			logger.Error(err, "unable to create webhook", "webhook", "ServiceProxyTypeValidator")
+			return nil, fmt.Errorf("unable to setup ServiceProxyTypeValidator webhook, got: %w", err)
+		}
+	} else {
+		logger.Info("admission webhooks are disabled, FrpServer objects are validated by the reconciler")
+	}
+	if cfg.Manager.ObjectMetricsInterval > 0 {
+		counter := &metrics.ObjectCounter{Reader: mgr.GetCache(), Interval: cfg.Manager.ObjectMetricsInterval}
+		if err := mgr.Add(counter); err != nil {
+			logger.Error(err, "unable to set up object counter")
+			return nil, fmt.Errorf("unable to set up object counter, got: %w", err)
+		}
+	}
+	if cfg.Manager.UsageReportInterval > 0 {
+		reporter := &inventory.UsageReporter{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Interval:  cfg.Manager.UsageReportInterval,
+		}
+		if err := mgr.Add(reporter); err != nil {
+			logger.Error(err, "unable to set up usage reporter")
+			return nil, fmt.Errorf("unable to set up usage reporter, got: %w", err)
+		}
 	}
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+	if cfg.Manager.TempFileTTL > 0 {
+		janitor := &gc.Janitor{Options: gc.Options{TTL: cfg.Manager.TempFileTTL}}
+		janitor.SetDefaults()
+		if err := mgr.Add(janitor); err != nil {
+			logger.Error(err, "unable to set up temp file janitor")
+			return nil, fmt.Errorf("unable to set up temp file janitor, got: %w", err)
+		}
+	}
+	if cfg.Manager.LeakDetection {
+		sentinel := &leak.Sentinel{
+			Interval:   cfg.Manager.LeakDetectionInterval,
+			ConnMaxAge: cfg.Manager.LeakDetectionConnMaxAge,
+		}
+		if err := mgr.Add(sentinel); err != nil {
+			logger.Error(err, "unable to set up leak sentinel")
+			return nil, fmt.Errorf("unable to set up leak sentinel, got: %w", err)
+		}
+	}
+	if accessStore != nil {
+		if err := (&controller.FrpServerAccessReconciler{
+			Client:   mgr.GetClient(),
+			Scheme:   mgr.GetScheme(),
+			Recorder: recorderFor("frpserveraccess-controller"),
+			Store:    accessStore,
+		}).SetupWithManager(mgr); err != nil {
+			logger.Error(err, "unable to setup frpserveraccess reconciler", "controller", "FrpServerAccessReconciler")
+			return nil, fmt.Errorf("unable to setup frpserveraccess reconciler, got: %w", err)
+		}
+		accessPlugin := &access.Plugin{
+			BindAddress:         cfg.Manager.AccessPluginBindAddress,
+			Token:               cfg.Manager.AccessPluginToken,
+			Store:               accessStore,
+			Reader:              mgr.GetAPIReader(),
+			RestoreSourceRanges: serviceReconciler.RestoreSourceRanges,
+		}
+		if err := mgr.Add(accessPlugin); err != nil {
+			logger.Error(err, "unable to set up access plugin")
+			return nil, fmt.Errorf("unable to set up access plugin, got: %w", err)
+		}
+	}
+	if cfg.Manager.InventoryBindAddress != "" && cfg.Manager.InventoryBindAddress != "0" {
+		inventoryServer := &inventory.Server{
+			BindAddress: cfg.Manager.InventoryBindAddress,
+			Reader:      mgr.GetClient(),
+		}
+		if err := mgr.Add(inventoryServer); err != nil {
+			logger.Error(err, "unable to set up inventory server")
+			return nil, fmt.Errorf("unable to set up inventory server, got: %w", err)
+		}
+	}
+	if cfg.Manager.FrpsEmulator != "" && cfg.Manager.FrpsEmulator != "0" {
+		logger.Info("frps emulator is enabled, it's meant for local development only")
+		if err := mgr.Add(&emulator.Server{BindAddress: cfg.Manager.FrpsEmulator, Token: cfg.Manager.FrpsEmulatorToken}); err != nil {
+			logger.Error(err, "unable to set up frps emulator")
+			return nil, fmt.Errorf("unable to set up frps emulator, got: %w", err)
+		}
+	}
+	server := &ManagerServer{mgr: mgr, cfg: cfg, reloads: reloads}
+	if err := server.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		logger.Error(err, "unable to set up health check")
-		return nil, fmt.Errorf("unable to set up health check, got: %w", err)
+		return nil, err
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		logger.Error(err, "unable to set up ready check")
-		return nil, fmt.Errorf("unable to set up ready check, got: %w", err)
+	readyChecks := map[string]healthz.Checker{
+		"readyz":       healthz.Ping,
+		"frp-sessions": proxySessions.Check,
+		"scheduler": func(_ *http.Request) error {
+			_, err := scheduler.Get(cfg.Manager.CurrentScheduler())
+			return err
+		},
+	}
+	for name, check := range readyChecks {
+		if err := server.AddReadyzCheck(name, check); err != nil {
+			logger.Error(err, "unable to set up ready check", "check", name)
+			return nil, err
+		}
 	}
-	return &ManagerServer{mgr: mgr, cfg: cfg}, nil
+	return server, nil
 }
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"github.com/frp-sigs/frp-provisioner/pkg/config"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reportConfigChanges logs and exports the options changed since the previous run and persists the
+// effective options for the next one. The report is advisory, so its failures never stop the manager.
+// The snapshot is not persisted in the observe mode, the manager reconciling the cluster owns it.
+func reportConfigChanges(ctx context.Context, cfg *config.Configuration) {
+	logger := log.FromContext(ctx)
+	path := cfg.Manager.ConfigSnapshotPath
+	current := cfg.Snapshot()
+	previous, err := config.LoadSnapshot(path)
+	if err != nil {
+		logger.Error(err, "unable to load config snapshot of the previous run", "path", path)
+	}
+	if previous != nil {
+		changes := config.DiffSnapshots(previous, current)
+		for _, change := range changes {
+			metrics.ConfigOptionChanged.WithLabelValues(change.Option).Set(1)
+		}
+		if len(changes) != 0 {
+			logger.Info("options changed since the previous run, review the release notes for changed defaults",
+				"changes", changes)
+		}
+	}
+	if cfg.Manager.Observing() {
+		return
+	}
+	if err := config.SaveSnapshot(path, current); err != nil {
+		logger.Error(err, "unable to persist config snapshot", "path", path)
+	}
+}
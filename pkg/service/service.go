@@ -0,0 +1,384 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package service runs the in-process frp clients registering the proxies of the FrpProxy objects, there's
+// one client per FrpServer, the proxies are pushed to frps as NewProxy messages over its control connection
+// and are forwarded to their backends by the manager.
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	frpclient "github.com/fatedier/frp/client"
+	"github.com/fatedier/frp/client/proxy"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	frputil "github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"io"
+	"net"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNotRegistered is returned for the status of a proxy which is not registered
+var ErrNotRegistered = errors.New("proxy is not registered")
+
+// ErrClosed is returned when a proxy is registered after the sessions were closed by the shutdown of the manager
+var ErrClosed = errors.New("frp client sessions are closed")
+
+// Sessions holds the frp client session of each FrpServer carrying FrpProxy proxies, the sessions are
+// closed once ctx of Start is done
+type Sessions struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// sessions are keyed by the name of the FrpServer
+	sessions map[string]*session
+	// servers records the FrpServer each proxy is registered on, keyed by the "namespace/name" of the FrpProxy
+	servers map[string]string
+}
+
+// session is the frp client of a FrpServer and the proxies it registers
+type session struct {
+	// hash is the hash of the common config the client was started with
+	hash   string
+	svc    *frpclient.Service
+	cancel context.CancelFunc
+	done   chan error
+	// exited is closed once Run of the client returned
+	exited  chan struct{}
+	proxies map[string]configv1.ProxyConfigurer
+	// ranges are the source ranges of the proxies keyed like proxies
+	ranges map[string]frputil.SourceRanges
+	// sources are the source ranges keyed by the proxy name, they're read by the work connections
+	sources atomic.Pointer[map[string]frputil.SourceRanges]
+	// poolCount is the number of work connections frps requests after each login of the client
+	poolCount int
+	// warm is set once the client dialed a pooled work connection after its last login
+	warm atomic.Bool
+}
+
+// poolConnector marks its session warm once a work connection was dialed, the first connection
+// dialed after Open is the control connection of the login, the following ones are work connections.
+type poolConnector struct {
+	frpclient.Connector
+	sess   *session
+	dialed atomic.Int32
+}
+
+// Open resets the warm state of the session, the client opens a new connector on each login
+func (c *poolConnector) Open() error {
+	c.sess.warm.Store(false)
+	c.dialed.Store(0)
+	return c.Connector.Open()
+}
+
+// Connect dials a connection to frps, the work connections are filtered by the source ranges of their proxy
+func (c *poolConnector) Connect() (net.Conn, error) {
+	conn, err := c.Connector.Connect()
+	if err == nil && c.dialed.Add(1) > 1 {
+		c.sess.warm.Store(true)
+		conn = &sourceFilterConn{Conn: conn, sess: c.sess}
+	}
+	return conn, err
+}
+
+// sourceFilterConn is a work connection closed when frps starts it for a user outside the source ranges of the
+// proxy, the StartWorkConn message read first by the client carries the proxy name and the user address.
+type sourceFilterConn struct {
+	net.Conn
+	sess *session
+	// reader replays the StartWorkConn message before the rest of the connection once it was checked
+	reader io.Reader
+}
+
+// Read reads the connection, the first read checks the StartWorkConn message
+func (c *sourceFilterConn) Read(p []byte) (int, error) {
+	if c.reader == nil {
+		// ReadMsg reads exactly one message, the bytes after it are left on the connection
+		raw := &bytes.Buffer{}
+		m, err := msg.ReadMsg(io.TeeReader(c.Conn, raw))
+		if err != nil {
+			return 0, err
+		}
+		if start, ok := m.(*msg.StartWorkConn); ok {
+			if ranges := c.sess.sourceRanges(start.ProxyName); len(ranges) != 0 && !ranges.Allows(start.SrcAddr) {
+				_ = c.Conn.Close()
+				return 0, fmt.Errorf("user address '%s' of proxy '%s' is not in the source ranges", start.SrcAddr, start.ProxyName)
+			}
+		}
+		c.reader = io.MultiReader(raw, c.Conn)
+	}
+	return c.reader.Read(p)
+}
+
+// NewSessions returns the empty Sessions
+func NewSessions() *Sessions {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sessions{ctx: ctx, cancel: cancel, sessions: make(map[string]*session), servers: make(map[string]string)}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the proxies are registered by the leader only
+// so the replicas don't register the same proxy names
+func (s *Sessions) NeedLeaderElection() bool {
+	return true
+}
+
+// Start closes the sessions once ctx is done, the clients of all sessions are stopped at once and the proxies
+// registered afterwards are refused with ErrClosed
+func (s *Sessions) Start(ctx context.Context) error {
+	<-ctx.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// cancelling the parent context stops every client, close only waits for them to exit
+	s.cancel()
+	log.FromContext(ctx).Info("closing frp client sessions", "count", len(s.sessions))
+	for name, sess := range s.sessions {
+		sess.close()
+		delete(s.sessions, name)
+	}
+	s.servers = make(map[string]string)
+	return nil
+}
+
+// Apply registers or updates the proxy of the FrpProxy keyed by key on the FrpServer. The session of the
+// FrpServer is started on its first proxy and restarted with its proxies when its common config changed,
+// the proxy is moved off the FrpServer it was previously registered on. The work connections of the users
+// outside ranges are closed, all users are accepted when ranges is empty.
+func (s *Sessions) Apply(ctx context.Context, server *v1beta1.FrpServer, creds *credentials.Credentials, key string, cfg configv1.ProxyConfigurer, ranges frputil.SourceRanges) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if previous, ok := s.servers[key]; ok && previous != server.Name {
+		if err := s.remove(ctx, previous, key); err != nil {
+			return err
+		}
+	}
+	common := frputil.ClientCommonConfig(server, creds)
+	common.Transport.Protocol = string(util.EmptyOr(server.Status.ActiveProtocol, server.Spec.Transport.Protocol))
+	// the client keeps retrying the login while frps is unavailable
+	common.LoginFailExit = lo.ToPtr(false)
+	common.Complete()
+	hash, err := commonConfigHash(&common)
+	if err != nil {
+		return err
+	}
+	proxies := map[string]configv1.ProxyConfigurer{key: cfg}
+	sourceRanges := map[string]frputil.SourceRanges{key: ranges}
+	sess := s.sessions[server.Name]
+	if sess != nil {
+		for k, v := range sess.proxies {
+			proxies[k] = lo.Ternary(k == key, cfg, v)
+		}
+		for k, v := range sess.ranges {
+			sourceRanges[k] = lo.Ternary(k == key, ranges, v)
+		}
+		if sess.hash != hash {
+			log.FromContext(ctx).Info("restarting frp client of frp server with changed config", "server", server.Name)
+			sess.close()
+			delete(s.sessions, server.Name)
+			sess = nil
+		}
+	}
+	s.servers[key] = server.Name
+	if sess != nil {
+		sess.proxies, sess.ranges = proxies, sourceRanges
+		sess.publishSourceRanges()
+		return sess.svc.UpdateAllConfigurer(lo.Values(proxies), nil)
+	}
+	sess, err = s.start(server, &common, hash, proxies, sourceRanges)
+	if err != nil {
+		delete(s.servers, key)
+		return err
+	}
+	s.sessions[server.Name] = sess
+	return nil
+}
+
+// start starts the frp client of the FrpServer registering the proxies
+func (s *Sessions) start(server *v1beta1.FrpServer, common *configv1.ClientCommonConfig, hash string, proxies map[string]configv1.ProxyConfigurer,
+	ranges map[string]frputil.SourceRanges) (*session, error) {
+	obj := server.DeepCopy()
+	sess := &session{hash: hash, done: make(chan error, 1), exited: make(chan struct{}), proxies: proxies, ranges: ranges, poolCount: common.Transport.PoolCount}
+	sess.publishSourceRanges()
+	svc, err := frpclient.NewService(frpclient.ServiceOptions{
+		Common:    common,
+		ProxyCfgs: lo.Values(proxies),
+		ConnectorCreator: func(ctx context.Context, cfg *configv1.ClientCommonConfig) frpclient.Connector {
+			return &poolConnector{Connector: frputil.NewConnector(ctx, obj, cfg), sess: sess}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable create frp client of frp server '%s', got: %w", server.Name, err)
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sess.svc, sess.cancel = svc, cancel
+	go func() {
+		sess.done <- svc.Run(ctx)
+		close(sess.exited)
+	}()
+	return sess, nil
+}
+
+// Remove unregisters the proxy of the FrpProxy keyed by key, the session of its FrpServer is closed once it
+// has no proxies left
+func (s *Sessions) Remove(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	serverName, ok := s.servers[key]
+	if !ok {
+		return nil
+	}
+	return s.remove(ctx, serverName, key)
+}
+
+func (s *Sessions) remove(ctx context.Context, serverName, key string) error {
+	delete(s.servers, key)
+	sess := s.sessions[serverName]
+	if sess == nil {
+		return nil
+	}
+	delete(sess.proxies, key)
+	delete(sess.ranges, key)
+	sess.publishSourceRanges()
+	if len(sess.proxies) != 0 {
+		return sess.svc.UpdateAllConfigurer(lo.Values(sess.proxies), nil)
+	}
+	log.FromContext(ctx).Info("closing frp client of frp server without proxies", "server", serverName)
+	sess.close()
+	delete(s.sessions, serverName)
+	return nil
+}
+
+// Status returns the working status of the proxy of the FrpProxy keyed by key
+func (s *Sessions) Status(key string) (*proxy.WorkingStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[s.servers[key]]
+	if sess == nil || sess.proxies[key] == nil {
+		return nil, ErrNotRegistered
+	}
+	return sess.svc.GetProxyStatus(sess.proxies[key].GetBaseConfig().Name)
+}
+
+// Warm reports whether the session of the proxy of the FrpProxy keyed by key dialed a pooled work connection
+// since its last login, the sessions without a connection pool are always warm
+func (s *Sessions) Warm(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[s.servers[key]]
+	return sess != nil && (sess.poolCount <= 0 || sess.warm.Load())
+}
+
+// Check implements healthz.Checker, it fails once the sessions are closed or when the frp client of a session
+// exited, the proxies of the session are not registered anymore until their FrpServer config changes
+func (s *Sessions) Check(_ *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	var exited []string
+	for name, sess := range s.sessions {
+		select {
+		case <-sess.exited:
+			exited = append(exited, name)
+		default:
+		}
+	}
+	if len(exited) != 0 {
+		sort.Strings(exited)
+		return fmt.Errorf("frp client of frp servers %v exited", exited)
+	}
+	return nil
+}
+
+// ProxyStatus is the working status of a proxy registered on a FrpServer
+type ProxyStatus struct {
+	// Key is the "namespace/name" of the FrpProxy of the proxy
+	Key string
+	proxy.WorkingStatus
+}
+
+// Proxies returns the working status of the proxies registered on the FrpServer sorted by key, the proxies
+// the client didn't load yet, e.g. before its first login, are in the new phase
+func (s *Sessions) Proxies(serverName string) []ProxyStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[serverName]
+	if sess == nil {
+		return nil
+	}
+	statuses := make([]ProxyStatus, 0, len(sess.proxies))
+	for key, cfg := range sess.proxies {
+		base := cfg.GetBaseConfig()
+		status, err := sess.svc.GetProxyStatus(base.Name)
+		if err != nil {
+			status = &proxy.WorkingStatus{Name: base.Name, Type: base.Type, Phase: proxy.ProxyPhaseNew}
+		}
+		statuses = append(statuses, ProxyStatus{Key: key, WorkingStatus: *status})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
+
+// publishSourceRanges keys the source ranges of the proxies by the proxy name for the work connections
+func (s *session) publishSourceRanges() {
+	sources := make(map[string]frputil.SourceRanges, len(s.ranges))
+	for key, ranges := range s.ranges {
+		if cfg, ok := s.proxies[key]; ok && len(ranges) != 0 {
+			sources[cfg.GetBaseConfig().Name] = ranges
+		}
+	}
+	s.sources.Store(&sources)
+}
+
+// sourceRanges returns the source ranges of the proxy named name
+func (s *session) sourceRanges(name string) frputil.SourceRanges {
+	if sources := s.sources.Load(); sources != nil {
+		return (*sources)[name]
+	}
+	return nil
+}
+
+// close stops the client, cancelling the context of Run is safe before Run started unlike Service.Close
+func (s *session) close() {
+	s.cancel()
+	<-s.done
+}
+
+// commonConfigHash returns the hash of the common config, the credentials are part of it so rotated
+// credentials restart the client
+func commonConfigHash(common *configv1.ClientCommonConfig) (string, error) {
+	data, err := json.Marshal(common)
+	if err != nil {
+		return "", fmt.Errorf("unable marshal frp client config, got: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
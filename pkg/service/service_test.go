@@ -0,0 +1,295 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	frputil "github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testServer returns a FrpServer nothing listens on, the clients of its sessions keep retrying the login
+func testServer(name, user string) *v1beta1.FrpServer {
+	obj := &v1beta1.FrpServer{}
+	obj.Name = name
+	obj.Spec.ServerAddr = "127.0.0.1"
+	obj.Spec.ServerPort = 1
+	obj.Spec.User = user
+	return obj
+}
+
+func testProxy(name string) configv1.ProxyConfigurer {
+	cfg := &configv1.TCPProxyConfig{}
+	cfg.Name = name
+	cfg.Type = string(configv1.ProxyTypeTCP)
+	cfg.LocalIP = "127.0.0.1"
+	cfg.LocalPort = 80
+	cfg.Complete("")
+	return cfg
+}
+
+func testRanges(t *testing.T, cidrs string) frputil.SourceRanges {
+	ranges, err := frputil.ParseSourceRanges(map[string]string{v1beta1.AnnotationSourceRangesKey: cidrs})
+	if err != nil {
+		t.Fatalf("ParseSourceRanges() error = %v", err)
+	}
+	return ranges
+}
+
+// proxyKeys returns the sorted keys of the proxies of each session keyed by the FrpServer name
+func proxyKeys(s *Sessions) map[string][]string {
+	keys := make(map[string][]string, len(s.sessions))
+	for name, sess := range s.sessions {
+		for key := range sess.proxies {
+			keys[name] = append(keys[name], key)
+		}
+		sort.Strings(keys[name])
+	}
+	return keys
+}
+
+func TestSessionsApplyRemove(t *testing.T) {
+	s := NewSessions()
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_ = s.Start(ctx)
+	}()
+	// the steps run in order against the same sessions
+	steps := []struct {
+		name   string
+		server *v1beta1.FrpServer
+		key    string
+		ranges string
+		// remove removes key instead of applying it on server
+		remove      bool
+		wantServers map[string]string
+		wantProxies map[string][]string
+		// wantRestart are the sessions expected to be started again by the step
+		wantRestart []string
+		wantSources map[string][]string
+	}{
+		{
+			name:        "first proxy starts the session",
+			server:      testServer("a", ""),
+			key:         "default/p1",
+			ranges:      "10.0.0.0/8",
+			wantServers: map[string]string{"default/p1": "a"},
+			wantProxies: map[string][]string{"a": {"default/p1"}},
+			wantRestart: []string{"a"},
+			wantSources: map[string][]string{"a": {"default.p1"}},
+		},
+		{
+			name:        "second proxy joins the session",
+			server:      testServer("a", ""),
+			key:         "default/p2",
+			wantServers: map[string]string{"default/p1": "a", "default/p2": "a"},
+			wantProxies: map[string][]string{"a": {"default/p1", "default/p2"}},
+			wantSources: map[string][]string{"a": {"default.p1"}},
+		},
+		{
+			name:        "changed common config restarts the session with its proxies",
+			server:      testServer("a", "changed"),
+			key:         "default/p2",
+			ranges:      "192.168.0.0/16",
+			wantServers: map[string]string{"default/p1": "a", "default/p2": "a"},
+			wantProxies: map[string][]string{"a": {"default/p1", "default/p2"}},
+			wantRestart: []string{"a"},
+			wantSources: map[string][]string{"a": {"default.p1", "default.p2"}},
+		},
+		{
+			name:        "proxy moves to another server",
+			server:      testServer("b", ""),
+			key:         "default/p1",
+			wantServers: map[string]string{"default/p1": "b", "default/p2": "a"},
+			wantProxies: map[string][]string{"a": {"default/p2"}, "b": {"default/p1"}},
+			wantRestart: []string{"b"},
+			wantSources: map[string][]string{"a": {"default.p2"}, "b": nil},
+		},
+		{
+			name:        "removing the last proxy closes the session",
+			key:         "default/p2",
+			remove:      true,
+			wantServers: map[string]string{"default/p1": "b"},
+			wantProxies: map[string][]string{"b": {"default/p1"}},
+			wantSources: map[string][]string{"b": nil},
+		},
+		{
+			name:        "removing an unknown proxy",
+			key:         "default/unknown",
+			remove:      true,
+			wantServers: map[string]string{"default/p1": "b"},
+			wantProxies: map[string][]string{"b": {"default/p1"}},
+			wantSources: map[string][]string{"b": nil},
+		},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := make(map[string]*session, len(s.sessions))
+			for name, sess := range s.sessions {
+				previous[name] = sess
+			}
+			var err error
+			if tt.remove {
+				err = s.Remove(context.Background(), tt.key)
+			} else {
+				var ranges frputil.SourceRanges
+				if tt.ranges != "" {
+					ranges = testRanges(t, tt.ranges)
+				}
+				err = s.Apply(context.Background(), tt.server, nil, tt.key, testProxy(strings.Replace(tt.key, "/", ".", 1)), ranges)
+			}
+			if err != nil {
+				t.Fatalf("Apply() or Remove() error = %v", err)
+			}
+			if !reflect.DeepEqual(s.servers, tt.wantServers) {
+				t.Fatalf("servers = %v, want %v", s.servers, tt.wantServers)
+			}
+			if got := proxyKeys(s); !reflect.DeepEqual(got, tt.wantProxies) {
+				t.Fatalf("proxies = %v, want %v", got, tt.wantProxies)
+			}
+			var restarted []string
+			for name, sess := range s.sessions {
+				if previous[name] != sess {
+					restarted = append(restarted, name)
+				}
+			}
+			sort.Strings(restarted)
+			if !reflect.DeepEqual(restarted, tt.wantRestart) {
+				t.Fatalf("restarted sessions = %v, want %v", restarted, tt.wantRestart)
+			}
+			for name, want := range tt.wantSources {
+				var got []string
+				for proxyName := range *s.sessions[name].sources.Load() {
+					got = append(got, proxyName)
+				}
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("source ranges of session '%s' = %v, want %v", name, got, want)
+				}
+			}
+			for key := range tt.wantServers {
+				if _, err := s.Status(key); errors.Is(err, ErrNotRegistered) {
+					t.Fatalf("Status(%s) error = %v, want a registered proxy", key, err)
+				}
+			}
+			if _, err := s.Status(tt.key); tt.remove && !errors.Is(err, ErrNotRegistered) {
+				t.Fatalf("Status(%s) error = %v, want %v", tt.key, err, ErrNotRegistered)
+			}
+		})
+	}
+}
+
+func TestSessionsClosed(t *testing.T) {
+	s := NewSessions()
+	if err := s.Apply(context.Background(), testServer("a", ""), nil, "default/p1", testProxy("default.p1"), nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if len(s.sessions) != 0 || len(s.servers) != 0 {
+		t.Fatalf("Start() left sessions %v and servers %v", s.sessions, s.servers)
+	}
+	if err := s.Apply(context.Background(), testServer("a", ""), nil, "default/p1", testProxy("default.p1"), nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Apply() error = %v, want %v", err, ErrClosed)
+	}
+	if err := s.Check(nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Check() error = %v, want %v", err, ErrClosed)
+	}
+	if s.Warm("default/p1") {
+		t.Fatalf("Warm() = true, want false")
+	}
+}
+
+func TestSourceFilterConnRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  string
+		start   msg.Message
+		wantErr bool
+	}{
+		{
+			name:   "user inside the source ranges",
+			ranges: "10.0.0.0/8",
+			start:  &msg.StartWorkConn{ProxyName: "default.p1", SrcAddr: "10.1.2.3", SrcPort: 4000},
+		},
+		{
+			name:    "user outside the source ranges",
+			ranges:  "10.0.0.0/8",
+			start:   &msg.StartWorkConn{ProxyName: "default.p1", SrcAddr: "192.168.1.1", SrcPort: 4000},
+			wantErr: true,
+		},
+		{
+			name:   "proxy without source ranges",
+			ranges: "10.0.0.0/8",
+			start:  &msg.StartWorkConn{ProxyName: "default.p2", SrcAddr: "192.168.1.1", SrcPort: 4000},
+		},
+		{
+			name:   "message other than StartWorkConn",
+			ranges: "10.0.0.0/8",
+			start:  &msg.Ping{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := &session{
+				proxies: map[string]configv1.ProxyConfigurer{"default/p1": testProxy("default.p1"), "default/p2": testProxy("default.p2")},
+				ranges:  map[string]frputil.SourceRanges{"default/p1": testRanges(t, tt.ranges)},
+			}
+			sess.publishSourceRanges()
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				// the writes fail once the filtered connection was closed
+				if err := msg.WriteMsg(server, tt.start); err == nil {
+					_, _ = server.Write([]byte("payload"))
+				}
+				_ = server.Close()
+			}()
+			conn := &sourceFilterConn{Conn: client, sess: sess}
+			m, err := msg.ReadMsg(conn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ReadMsg() = %v, want an error", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadMsg() error = %v", err)
+			}
+			if !reflect.DeepEqual(m, tt.start) {
+				t.Fatalf("ReadMsg() = %+v, want %+v", m, tt.start)
+			}
+			rest, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(rest) != "payload" {
+				t.Fatalf("ReadAll() = %q, want %q", rest, "payload")
+			}
+		})
+	}
+}
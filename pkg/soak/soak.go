@@ -0,0 +1,219 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package soak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	frpclient "github.com/fatedier/frp/client"
+	"github.com/fatedier/frp/client/proxy"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/config/builder"
+	frputil "github.com/frp-sigs/frp-provisioner/pkg/utils/frpclient"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"io"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultServerPort    = 7000
+	defaultProxies       = 10
+	defaultCycles        = 10
+	defaultCycleDuration = 30 * time.Second
+	defaultLocalPort     = 80
+	// startTimeout bounds the time waiting for the synthetic proxies to run in a cycle
+	startTimeout = 30 * time.Second
+	// settleDuration is the time given to goroutines of a closed client to exit before sampling
+	settleDuration = 2 * time.Second
+)
+
+// Options contains the configuration of the soak test
+type Options struct {
+	// ServerAddr is the address of the test frps
+	ServerAddr string `json:"serverAddr"`
+	// ServerPort is the port of the test frps
+	ServerPort int `json:"serverPort"`
+	// Token is the auth token of the test frps
+	Token string `json:"token"`
+	// Proxies is the number of synthetic tcp proxies created in each cycle
+	Proxies int `json:"proxies"`
+	// Cycles is the number of reconnect cycles
+	Cycles int `json:"cycles"`
+	// CycleDuration is the time the client stays connected in each cycle
+	CycleDuration time.Duration `json:"cycleDuration"`
+	// LocalPort is the backend port of the synthetic proxies
+	LocalPort int `json:"localPort"`
+}
+
+// SetDefaults set default values for soak test options
+func (o *Options) SetDefaults() {
+	o.ServerPort = util.EmptyOr(o.ServerPort, defaultServerPort)
+	o.Proxies = util.EmptyOr(o.Proxies, defaultProxies)
+	o.Cycles = util.EmptyOr(o.Cycles, defaultCycles)
+	o.CycleDuration = util.EmptyOr(o.CycleDuration, defaultCycleDuration)
+	o.LocalPort = util.EmptyOr(o.LocalPort, defaultLocalPort)
+}
+
+// Validate validates the soak test options
+func (o *Options) Validate() (err error) {
+	if o.ServerAddr == "" {
+		err = errors.Join(err, fmt.Errorf("server address is required"))
+	}
+	if portErr := frputil.ValidatePort(o.ServerPort); portErr != nil {
+		err = errors.Join(err, fmt.Errorf("invalid server port, got: %w", portErr))
+	}
+	if o.Proxies <= 0 {
+		err = errors.Join(err, fmt.Errorf("proxies must be positive, got: %d", o.Proxies))
+	}
+	if o.Cycles <= 0 {
+		err = errors.Join(err, fmt.Errorf("cycles must be positive, got: %d", o.Cycles))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ServerAddr, "server-addr", o.ServerAddr, "Is the address of the test frps.")
+	fs.IntVar(&o.ServerPort, "server-port", o.ServerPort, "Is the port of the test frps.")
+	fs.StringVar(&o.Token, "token", o.Token, "Is the auth token of the test frps.")
+	fs.IntVar(&o.Proxies, "proxies", o.Proxies, "Is the number of synthetic tcp proxies created in each cycle.")
+	fs.IntVar(&o.Cycles, "cycles", o.Cycles, "Is the number of reconnect cycles.")
+	fs.DurationVar(&o.CycleDuration, "cycle-duration", o.CycleDuration, "Is the time the client stays connected in each cycle.")
+	fs.IntVar(&o.LocalPort, "local-port", o.LocalPort, "Is the backend port of the synthetic proxies.")
+}
+
+// Sample is the resource usage of the process after a reconnect cycle
+type Sample struct {
+	Cycle      int
+	Running    int
+	Goroutines int
+	HeapAlloc  uint64
+	HeapObjs   uint64
+}
+
+// Run cycles an in-process frp client with synthetic proxies against the test frps, and writes
+// the goroutine and heap usage after each cycle to out. A steady growth across cycles points
+// to a leak in the client control or proxy manager.
+func Run(ctx context.Context, o *Options, out io.Writer) ([]Sample, error) {
+	server := &v1beta1.FrpServer{}
+	server.Name = "soak"
+	server.Spec.Transport.TCPMux = lo.ToPtr(true)
+
+	samples := make([]Sample, 0, o.Cycles+1)
+	baseline := sample(0, 0)
+	samples = append(samples, baseline)
+	_, _ = fmt.Fprintf(out, "%-6s %-8s %-11s %-14s %-12s\n", "CYCLE", "RUNNING", "GOROUTINES", "HEAP_ALLOC", "HEAP_OBJECTS")
+	printSample(out, baseline)
+	for cycle := 1; cycle <= o.Cycles; cycle++ {
+		running, err := runCycle(ctx, o, server)
+		if err != nil {
+			return samples, fmt.Errorf("soak cycle %d failed, got: %w", cycle, err)
+		}
+		current := sample(cycle, running)
+		samples = append(samples, current)
+		printSample(out, current)
+	}
+	last := samples[len(samples)-1]
+	_, _ = fmt.Fprintf(out, "growth over %d cycles: goroutines %+d, heap alloc %+d bytes, heap objects %+d\n", o.Cycles,
+		last.Goroutines-baseline.Goroutines, int64(last.HeapAlloc)-int64(baseline.HeapAlloc), int64(last.HeapObjs)-int64(baseline.HeapObjs))
+	return samples, nil
+}
+
+// runCycle connects a client with the synthetic proxies, keeps it connected for the cycle
+// duration and closes it, it returns the number of proxies which were running.
+func runCycle(ctx context.Context, o *Options, server *v1beta1.FrpServer) (int, error) {
+	common := &configv1.ClientCommonConfig{
+		ServerAddr: o.ServerAddr,
+		ServerPort: o.ServerPort,
+		Auth:       configv1.AuthClientConfig{Method: configv1.AuthMethodToken, Token: o.Token},
+	}
+	common.Transport.TCPMux = server.Spec.Transport.TCPMux
+	common.LoginFailExit = lo.ToPtr(true)
+	proxyCfgs := make([]configv1.ProxyConfigurer, 0, o.Proxies)
+	for i := 0; i < o.Proxies; i++ {
+		cfg, err := builder.NewTCPProxy(fmt.Sprintf("soak-%d", i)).LocalPort(o.LocalPort).Build()
+		if err != nil {
+			return 0, err
+		}
+		proxyCfgs = append(proxyCfgs, cfg)
+	}
+	svc, err := frpclient.NewService(frpclient.ServiceOptions{
+		Common:    common,
+		ProxyCfgs: proxyCfgs,
+		ConnectorCreator: func(ctx context.Context, cfg *configv1.ClientCommonConfig) frpclient.Connector {
+			return frputil.NewConnector(ctx, server, cfg)
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	cycleCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.Run(cycleCtx)
+	}()
+
+	running := 0
+	deadline := time.Now().Add(startTimeout)
+	for running < o.Proxies && time.Now().Before(deadline) {
+		select {
+		case err := <-done:
+			return running, err
+		case <-ctx.Done():
+			return running, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		running = 0
+		for _, cfg := range proxyCfgs {
+			if status, err := svc.GetProxyStatus(cfg.GetBaseConfig().Name); err == nil && status.Phase == proxy.ProxyPhaseRunning {
+				running++
+			}
+		}
+	}
+
+	select {
+	case <-time.After(o.CycleDuration):
+	case <-ctx.Done():
+	}
+	svc.Close()
+	if err := <-done; err != nil {
+		return running, err
+	}
+	time.Sleep(settleDuration)
+	return running, ctx.Err()
+}
+
+// sample collects the resource usage of the process after a garbage collection
+func sample(cycle, running int) Sample {
+	runtime.GC()
+	stats := &runtime.MemStats{}
+	runtime.ReadMemStats(stats)
+	return Sample{
+		Cycle:      cycle,
+		Running:    running,
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  stats.HeapAlloc,
+		HeapObjs:   stats.HeapObjects,
+	}
+}
+
+func printSample(out io.Writer, s Sample) {
+	_, _ = fmt.Fprintf(out, "%-6d %-8d %-11d %-14d %-12d\n", s.Cycle, s.Running, s.Goroutines, s.HeapAlloc, s.HeapObjs)
+}
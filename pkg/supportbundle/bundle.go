@@ -0,0 +1,361 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package supportbundle gathers the state of a frp-provisioner installation into a tarball which can be
+// attached to a bug report, the secrets are redacted before anything is written.
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/version"
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNamespace    = "frp-provisioner-system"
+	defaultSelector     = "control-plane=controller-manager"
+	defaultLogTailLines = 5000
+	defaultDialTimeout  = 5 * time.Second
+	// lastAppliedAnnotation holds the whole object as applied by kubectl, secrets included
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+var (
+	// recorderComponents are the event sources of the controllers of the manager
+	recorderComponents = []string{"service-controller", "frpserver-controller", "frpserverclaim-controller", "frpserveraccess-controller"}
+	// secretEnvName matches the env vars of the frp client pods holding secrets inline
+	secretEnvName = regexp.MustCompile(`(?i)token|password|secret|key`)
+)
+
+// Options contains the configuration of a support bundle
+type Options struct {
+	// Output is the path of the tarball
+	Output string `json:"output"`
+	// Namespace is the namespace the manager runs in
+	Namespace string `json:"namespace"`
+	// Selector selects the manager pods whose logs are gathered
+	Selector string `json:"selector"`
+	// MetricsURL is the metrics endpoint of the manager, e.g. a port-forward, the metrics are not gathered when empty
+	MetricsURL string `json:"metricsURL"`
+	// LogTailLines is the number of lines gathered from the end of each manager container log
+	LogTailLines int64 `json:"logTailLines"`
+	// DialTimeout bounds the connectivity test of each frps
+	DialTimeout time.Duration `json:"dialTimeout"`
+}
+
+// SetDefaults set default values for support bundle options
+func (o *Options) SetDefaults() {
+	if o.Output == "" {
+		o.Output = fmt.Sprintf("frp-provisioner-support-bundle-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	}
+	if o.Namespace == "" {
+		o.Namespace = defaultNamespace
+	}
+	if o.Selector == "" {
+		o.Selector = defaultSelector
+	}
+	if o.LogTailLines == 0 {
+		o.LogTailLines = defaultLogTailLines
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+}
+
+// Validate validates the support bundle options
+func (o *Options) Validate() (err error) {
+	if o.Output == "" {
+		err = errors.Join(err, fmt.Errorf("output is required"))
+	}
+	if _, parseErr := labels.Parse(o.Selector); parseErr != nil {
+		err = errors.Join(err, fmt.Errorf("invalid selector '%s', got: %w", o.Selector, parseErr))
+	}
+	if o.MetricsURL != "" {
+		if _, parseErr := url.ParseRequestURI(o.MetricsURL); parseErr != nil {
+			err = errors.Join(err, fmt.Errorf("invalid metrics url '%s', got: %w", o.MetricsURL, parseErr))
+		}
+	}
+	if o.LogTailLines <= 0 {
+		err = errors.Join(err, fmt.Errorf("log tail lines must be positive"))
+	}
+	if o.DialTimeout <= 0 {
+		err = errors.Join(err, fmt.Errorf("dial timeout must be positive"))
+	}
+	return err
+}
+
+// AddFlags add related command line parameters
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&o.Output, "output", "o", o.Output, "Is the path of the support bundle tarball.")
+	fs.StringVar(&o.Namespace, "namespace", o.Namespace, "Is the namespace frp-provisioner-manager runs in.")
+	fs.StringVar(&o.Selector, "selector", o.Selector, "Is the label selector of the frp-provisioner-manager pods whose logs are gathered.")
+	fs.StringVar(&o.MetricsURL, "metrics-url", o.MetricsURL, "Is the metrics endpoint of frp-provisioner-manager,"+
+		" e.g. a port-forward, the metrics are not gathered when empty.")
+	fs.Int64Var(&o.LogTailLines, "log-tail-lines", o.LogTailLines, "Is the number of lines gathered from the end of each manager container log.")
+	fs.DurationVar(&o.DialTimeout, "dial-timeout", o.DialTimeout, "Bounds the connectivity test of each frps.")
+}
+
+// Collector gathers the support bundle, a step which fails doesn't stop the collection, its error is
+// recorded in errors.txt of the bundle instead.
+type Collector struct {
+	// Client reads the objects of the installation
+	Client client.Client
+	// Pods reads the logs of the manager pods
+	Pods corev1client.PodsGetter
+	// Options configures the collection
+	Options *Options
+
+	redactor Redactor
+	files    []file
+	errs     []string
+}
+
+// file is a file of the bundle
+type file struct {
+	name string
+	data []byte
+}
+
+// Collect gathers the version, the sanitized objects, the manager logs, the metrics and the frps
+// connectivity tests, and writes them redacted as a gzipped tarball to w
+func (c *Collector) Collect(ctx context.Context, w io.Writer) error {
+	c.add("version.json", json.Marshal, version.Get())
+	servers := c.collectObjects(ctx)
+	c.collectLogs(ctx)
+	c.collectMetrics(ctx)
+	c.collectConnectivity(ctx, servers)
+	if len(c.errs) != 0 {
+		c.files = append(c.files, file{name: "errors.txt", data: []byte(strings.Join(c.errs, "\n") + "\n")})
+	}
+	return c.write(w)
+}
+
+// add marshals v as the file, a marshal failure is recorded
+func (c *Collector) add(name string, marshal func(any) ([]byte, error), v any) {
+	data, err := marshal(v)
+	if err != nil {
+		c.fail(name, err)
+		return
+	}
+	c.files = append(c.files, file{name: name, data: data})
+}
+
+func (c *Collector) fail(step string, err error) {
+	c.errs = append(c.errs, fmt.Sprintf("%s: %v", step, err))
+}
+
+// collectObjects dumps the FrpServers, the claims, the accesses, the exposed services, the frp client pods
+// and the events of the controllers, it returns the FrpServers for the connectivity tests
+func (c *Collector) collectObjects(ctx context.Context) []v1beta1.FrpServer {
+	servers := &v1beta1.FrpServerList{}
+	if err := c.Client.List(ctx, servers); err != nil {
+		c.fail("frpservers", err)
+	}
+	for i := range servers.Items {
+		server := servers.Items[i].DeepCopy()
+		c.redactor.Add(server.Spec.Auth.Token)
+		server.Spec.Auth.Token = lo.Ternary(server.Spec.Auth.Token != "", Redacted, "")
+		if server.Spec.Auth.OIDC != nil {
+			c.redactor.Add(server.Spec.Auth.OIDC.ClientSecret)
+			server.Spec.Auth.OIDC.ClientSecret = lo.Ternary(server.Spec.Auth.OIDC.ClientSecret != "", Redacted, "")
+		}
+		sanitize(server)
+		servers.Items[i] = *server
+	}
+	c.add("objects/frpservers.yaml", yaml.Marshal, servers.Items)
+
+	claims := &v1beta1.FrpServerClaimList{}
+	if err := c.Client.List(ctx, claims); err != nil {
+		c.fail("frpserverclaims", err)
+	}
+	c.add("objects/frpserverclaims.yaml", yaml.Marshal, lo.Map(claims.Items, func(item v1beta1.FrpServerClaim, _ int) *v1beta1.FrpServerClaim {
+		return sanitize(item.DeepCopy())
+	}))
+
+	accesses := &v1beta1.FrpServerAccessList{}
+	if err := c.Client.List(ctx, accesses); err != nil {
+		c.fail("frpserveraccesses", err)
+	}
+	c.add("objects/frpserveraccesses.yaml", yaml.Marshal, lo.Map(accesses.Items, func(item v1beta1.FrpServerAccess, _ int) *v1beta1.FrpServerAccess {
+		return sanitize(item.DeepCopy())
+	}))
+
+	proxies := &v1beta1.FrpProxyList{}
+	if err := c.Client.List(ctx, proxies); err != nil {
+		c.fail("frpproxies", err)
+	}
+	c.add("objects/frpproxies.yaml", yaml.Marshal, lo.Map(proxies.Items, func(item v1beta1.FrpProxy, _ int) *v1beta1.FrpProxy {
+		return sanitize(item.DeepCopy())
+	}))
+
+	groups := &v1beta1.FrpServerGroupList{}
+	if err := c.Client.List(ctx, groups); err != nil {
+		c.fail("frpservergroups", err)
+	}
+	c.add("objects/frpservergroups.yaml", yaml.Marshal, groups.Items)
+
+	services := &v1.ServiceList{}
+	if err := c.Client.List(ctx, services); err != nil {
+		c.fail("services", err)
+	}
+	c.add("objects/services.yaml", yaml.Marshal, lo.FilterMap(services.Items, func(item v1.Service, _ int) (*v1.Service, bool) {
+		return sanitize(item.DeepCopy()), lo.Contains(item.Finalizers, frplabels.Finalizer)
+	}))
+
+	pods := &v1.PodList{}
+	if err := c.Client.List(ctx, pods, client.HasLabels{frplabels.ServiceName}); err != nil {
+		c.fail("pods", err)
+	}
+	c.add("objects/pods.yaml", yaml.Marshal, lo.Map(pods.Items, func(item v1.Pod, _ int) *v1.Pod {
+		pod := sanitize(item.DeepCopy())
+		for i := range pod.Spec.Containers {
+			for j, env := range pod.Spec.Containers[i].Env {
+				if env.Value != "" && secretEnvName.MatchString(env.Name) {
+					c.redactor.Add(env.Value)
+					pod.Spec.Containers[i].Env[j].Value = Redacted
+				}
+			}
+		}
+		return pod
+	}))
+
+	events := &v1.EventList{}
+	if err := c.Client.List(ctx, events); err != nil {
+		c.fail("events", err)
+	}
+	c.add("objects/events.yaml", yaml.Marshal, lo.Filter(events.Items, func(item v1.Event, _ int) bool {
+		return lo.Contains(recorderComponents, item.Source.Component)
+	}))
+	return servers.Items
+}
+
+// sanitize drops the noise and the kubectl copy of the object, which holds its secrets unredacted
+func sanitize[T metav1.Object](obj T) T {
+	obj.SetManagedFields(nil)
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		delete(annotations, lastAppliedAnnotation)
+	}
+	return obj
+}
+
+// collectLogs gathers the tail of the logs of the containers of the manager pods
+func (c *Collector) collectLogs(ctx context.Context) {
+	pods := &v1.PodList{}
+	selector, _ := labels.Parse(c.Options.Selector)
+	if err := c.Client.List(ctx, pods, client.InNamespace(c.Options.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		c.fail("manager pods", err)
+		return
+	}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			name := fmt.Sprintf("logs/%s_%s.log", pod.Name, container.Name)
+			data, err := c.Pods.Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+				Container: container.Name,
+				TailLines: &c.Options.LogTailLines,
+			}).DoRaw(ctx)
+			if err != nil {
+				c.fail(name, err)
+				continue
+			}
+			c.files = append(c.files, file{name: name, data: data})
+		}
+	}
+}
+
+// collectMetrics gathers a snapshot of the metrics of the manager
+func (c *Collector) collectMetrics(ctx context.Context) {
+	if c.Options.MetricsURL == "" {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Options.MetricsURL, nil)
+	if err != nil {
+		c.fail("metrics", err)
+		return
+	}
+	resp, err := (&http.Client{Timeout: c.Options.DialTimeout}).Do(req)
+	if err != nil {
+		c.fail("metrics", err)
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.fail("metrics", err)
+		return
+	}
+	c.files = append(c.files, file{name: "metrics.txt", data: data})
+}
+
+// collectConnectivity dials the frps of every FrpServer from where the bundle is collected, no credentials
+// are sent, so it only tells whether the address is reachable
+func (c *Collector) collectConnectivity(ctx context.Context, servers []v1beta1.FrpServer) {
+	var report strings.Builder
+	dialer := &net.Dialer{Timeout: c.Options.DialTimeout}
+	for _, server := range servers {
+		address := net.JoinHostPort(server.Spec.ServerAddr, strconv.Itoa(server.Spec.ServerPort))
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		result := fmt.Sprintf("reachable in %s", time.Since(start).Round(time.Millisecond))
+		if err != nil {
+			result = fmt.Sprintf("unreachable, got: %v", err)
+		} else {
+			_ = conn.Close()
+		}
+		fmt.Fprintf(&report, "%s\t%s\tphase=%s\t%s\n", server.Name, address, server.Status.Phase, result)
+	}
+	c.files = append(c.files, file{name: "connectivity.txt", data: []byte(report.String())})
+}
+
+// write redacts the files and writes them as a gzipped tarball
+func (c *Collector) write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range c.files {
+		data := c.redactor.Redact(f.data)
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o600, Size: int64(len(data)), ModTime: now}); err != nil {
+			return fmt.Errorf("unable write %s to support bundle, got: %w", f.name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("unable write %s to support bundle, got: %w", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supportbundle
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// Redacted replaces the secrets in the bundle
+const Redacted = "<redacted>"
+
+var (
+	// secretAssignment matches the values assigned to secret keys in logs and configs, e.g. token=abc or
+	// "clientSecret": "abc"
+	secretAssignment = regexp.MustCompile(`(?i)((?:token|password|passwd|secret|client_?secret|secret_?key|authorization)["']?[ \t]*[:=][ \t]*["']?)[^\s"',}]+`)
+	// bearerToken matches the bearer tokens of http authorization headers
+	bearerToken = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+)
+
+// Redactor removes the secrets from the files of the bundle, the known secret values are replaced
+// wherever they appear and the values of secret looking keys are replaced by pattern.
+type Redactor struct {
+	values [][]byte
+}
+
+// Add registers a secret value which is replaced wherever it appears
+func (r *Redactor) Add(value string) {
+	if value != "" && value != Redacted {
+		r.values = append(r.values, []byte(value))
+	}
+}
+
+// Redact returns a copy of data without the secrets
+func (r *Redactor) Redact(data []byte) []byte {
+	for _, value := range r.values {
+		data = bytes.ReplaceAll(data, value, []byte(Redacted))
+	}
+	data = bearerToken.ReplaceAll(data, []byte("${1}"+Redacted))
+	return secretAssignment.ReplaceAll(data, []byte("${1}"+Redacted))
+}
@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+
+package tlspolicy
+
+// BuildFIPS reports whether the manager is built with the FIPS 140-2 validated boringcrypto module, i.e.
+// with GOEXPERIMENT=boringcrypto
+const BuildFIPS = false
@@ -0,0 +1,12 @@
+//go:build boringcrypto
+
+package tlspolicy
+
+import (
+	// restricts every tls config of the process to the FIPS-approved settings
+	_ "crypto/tls/fipsonly"
+)
+
+// BuildFIPS reports whether the manager is built with the FIPS 140-2 validated boringcrypto module, i.e.
+// with GOEXPERIMENT=boringcrypto
+const BuildFIPS = true
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2021 Aapeli <aapeli.nian@gmail.com>.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+)
+
+// Policy restricts the TLS versions, cipher suites and curves a TLS connection negotiates, the empty
+// fields keep the defaults of crypto/tls, or the FIPS-approved sets in FIPS mode.
+type Policy struct {
+	// MinVersion is the minimum TLS version, one of VersionTLS10, VersionTLS11, VersionTLS12 or VersionTLS13
+	MinVersion string `json:"minVersion,omitempty"`
+	// CipherSuites are the IANA names of the enabled TLS 1.0-1.2 cipher suites, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. The TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+	// CurvePreferences are the elliptic curves of the ECDHE handshake in preference order, one of X25519,
+	// P256, P384 or P521
+	CurvePreferences []string `json:"curvePreferences,omitempty"`
+}
+
+var (
+	versions = map[string]uint16{
+		"VersionTLS10": tls.VersionTLS10,
+		"VersionTLS11": tls.VersionTLS11,
+		"VersionTLS12": tls.VersionTLS12,
+		"VersionTLS13": tls.VersionTLS13,
+	}
+	curves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+	// cipherSuites are the cipher suites of crypto/tls without known security issues
+	cipherSuites = lo.SliceToMap(tls.CipherSuites(), func(suite *tls.CipherSuite) (string, uint16) {
+		return suite.Name, suite.ID
+	})
+
+	// FIPSVersions, FIPSCipherSuites and FIPSCurvePreferences are the FIPS 140-2 approved settings, they're
+	// the ones crypto/tls/fipsonly restricts the boringcrypto builds to
+	FIPSVersions     = []string{"VersionTLS12", "VersionTLS13"}
+	FIPSCipherSuites = []string{
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"TLS_AES_128_GCM_SHA256",
+		"TLS_AES_256_GCM_SHA384",
+	}
+	FIPSCurvePreferences = []string{"P256", "P384"}
+)
+
+// IsZero reports whether the policy keeps every default
+func (p *Policy) IsZero() bool {
+	return p == nil || (p.MinVersion == "" && len(p.CipherSuites) == 0 && len(p.CurvePreferences) == 0)
+}
+
+// Validate checks the names of the policy are known to crypto/tls, field prefixes the fields in the errors
+func (p *Policy) Validate(field string) (errs error) {
+	if p == nil {
+		return nil
+	}
+	if _, ok := versions[p.MinVersion]; p.MinVersion != "" && !ok {
+		errs = errors.Join(errs, fmt.Errorf("invalid %s.minVersion '%s', optional values are VersionTLS10, VersionTLS11, VersionTLS12 or VersionTLS13", field, p.MinVersion))
+	}
+	for _, name := range p.CipherSuites {
+		if _, ok := cipherSuites[name]; !ok {
+			errs = errors.Join(errs, fmt.Errorf("invalid %s.cipherSuites '%s', it's not a secure cipher suite of crypto/tls", field, name))
+		}
+	}
+	for _, name := range p.CurvePreferences {
+		if _, ok := curves[name]; !ok {
+			errs = errors.Join(errs, fmt.Errorf("invalid %s.curvePreferences '%s', optional values are X25519, P256, P384 or P521", field, name))
+		}
+	}
+	return errs
+}
+
+// CheckFIPS checks the policy only selects FIPS-approved settings, field prefixes the fields in the errors
+func (p *Policy) CheckFIPS(field string) (errs error) {
+	if p == nil {
+		return nil
+	}
+	if p.MinVersion != "" && !lo.Contains(FIPSVersions, p.MinVersion) {
+		errs = errors.Join(errs, fmt.Errorf("%s.minVersion '%s' is not FIPS-approved, approved values are %v", field, p.MinVersion, FIPSVersions))
+	}
+	if unapproved := lo.Without(p.CipherSuites, FIPSCipherSuites...); len(unapproved) != 0 {
+		errs = errors.Join(errs, fmt.Errorf("%s.cipherSuites %v are not FIPS-approved, approved values are %v", field, unapproved, FIPSCipherSuites))
+	}
+	if unapproved := lo.Without(p.CurvePreferences, FIPSCurvePreferences...); len(unapproved) != 0 {
+		errs = errors.Join(errs, fmt.Errorf("%s.curvePreferences %v are not FIPS-approved, approved values are %v", field, unapproved, FIPSCurvePreferences))
+	}
+	return errs
+}
+
+// Apply restricts the tls config to the policy, the policy must be valid. In FIPS mode the empty fields
+// of the policy select the FIPS-approved settings.
+func (p *Policy) Apply(cfg *tls.Config, fips bool) {
+	policy := Policy{}
+	if p != nil {
+		policy = *p
+	}
+	if fips {
+		if policy.MinVersion == "" {
+			policy.MinVersion = FIPSVersions[0]
+		}
+		if len(policy.CipherSuites) == 0 {
+			policy.CipherSuites = FIPSCipherSuites
+		}
+		if len(policy.CurvePreferences) == 0 {
+			policy.CurvePreferences = FIPSCurvePreferences
+		}
+	}
+	if version, ok := versions[policy.MinVersion]; ok {
+		cfg.MinVersion = version
+	}
+	if len(policy.CipherSuites) != 0 {
+		cfg.CipherSuites = lo.FilterMap(policy.CipherSuites, func(name string, _ int) (uint16, bool) {
+			id, ok := cipherSuites[name]
+			return id, ok
+		})
+	}
+	if len(policy.CurvePreferences) != 0 {
+		cfg.CurvePreferences = lo.FilterMap(policy.CurvePreferences, func(name string, _ int) (tls.CurveID, bool) {
+			id, ok := curves[name]
+			return id, ok
+		})
+	}
+}
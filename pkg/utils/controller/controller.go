@@ -31,3 +31,30 @@ func IsFrpServerActive(i *v1beta1.FrpServer) bool {
 	return i.Status.Phase == v1beta1.FrpServerPhaseHealthy &&
 		i.DeletionTimestamp == nil
 }
+
+func IsPodReady(p *v1.Pod) bool {
+	if p.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, c := range p.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// IsReadinessGateRequested reports whether the pod opted in to the tunnel readiness gate
+func IsReadinessGateRequested(p *v1.Pod) bool {
+	return p.GetAnnotations()[v1beta1.AnnotationReadinessGateKey] == "true"
+}
+
+// HasTunnelReadinessGate reports whether the pod carries the tunnel readiness gate
+func HasTunnelReadinessGate(p *v1.Pod) bool {
+	for _, gate := range p.Spec.ReadinessGates {
+		if string(gate.ConditionType) == v1beta1.PodConditionTunnelReady {
+			return true
+		}
+	}
+	return false
+}
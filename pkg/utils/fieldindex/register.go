@@ -18,6 +18,7 @@ package fieldindex
 
 import (
 	"context"
+	frplabels "github.com/frp-sigs/frp-provisioner/pkg/api/labels"
 	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
 	v1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
@@ -28,6 +29,12 @@ import (
 const (
 	IndexNameForOwnerRefUID    = "ownerRefUID"
 	IndexNameForFrpServerPhase = "status.phase"
+	IndexNameForClaimServer    = "spec.serverName"
+	IndexNameForProxyServer    = "spec.serverName"
+	IndexNameForServiceServer  = "metadata.annotations.frpServerName"
+	IndexNameForServiceClaim   = "metadata.annotations.frpServerClaimName"
+	IndexNameForServiceGroup   = "metadata.annotations.frpServerGroup"
+	IndexNameForControllerUID  = "metadata.labels.controllerUID"
 )
 
 var ownerIndexFunc = func(obj client.Object) []string {
@@ -49,6 +56,44 @@ var phaseIndexFunc = func(obj client.Object) []string {
 	return []string{string(srv.Status.Phase)}
 }
 
+var claimServerIndexFunc = func(obj client.Object) []string {
+	claim, ok := obj.(*v1beta1.FrpServerClaim)
+	if !ok || claim.Spec.ServerName == "" {
+		return []string{}
+	}
+	return []string{claim.Spec.ServerName}
+}
+
+var proxyServerIndexFunc = func(obj client.Object) []string {
+	proxy, ok := obj.(*v1beta1.FrpProxy)
+	if !ok || proxy.Spec.ServerName == "" {
+		return []string{}
+	}
+	return []string{proxy.Spec.ServerName}
+}
+
+// annotationIndexFunc indexes objects by the value of the annotation key
+func annotationIndexFunc(key string) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		value := obj.GetAnnotations()[key]
+		if value == "" {
+			return []string{}
+		}
+		return []string{value}
+	}
+}
+
+// labelIndexFunc indexes objects by the value of the label key
+func labelIndexFunc(key string) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		value := obj.GetLabels()[key]
+		if value == "" {
+			return []string{}
+		}
+		return []string{value}
+	}
+}
+
 func RegisterFieldIndexes(ctx context.Context, c cache.Cache) error {
 	logger := log.FromContext(ctx)
 	// pod ownerReference
@@ -56,10 +101,39 @@ func RegisterFieldIndexes(ctx context.Context, c cache.Cache) error {
 		logger.Error(err, "unable register index filed for pod")
 		return err
 	}
+	// frp client pod controller uid, the pods run by a Deployment are not owned by their service
+	if err := c.IndexField(ctx, &v1.Pod{}, IndexNameForControllerUID, labelIndexFunc(frplabels.ControllerUID)); err != nil {
+		logger.Error(err, "unable register index filed for pod")
+		return err
+	}
 
 	if err := c.IndexField(ctx, &v1beta1.FrpServer{}, IndexNameForFrpServerPhase, phaseIndexFunc); err != nil {
 		logger.Error(err, "unable register index filed for FrpServer")
 		return err
 	}
+
+	if err := c.IndexField(ctx, &v1beta1.FrpServerClaim{}, IndexNameForClaimServer, claimServerIndexFunc); err != nil {
+		logger.Error(err, "unable register index filed for FrpServerClaim")
+		return err
+	}
+
+	if err := c.IndexField(ctx, &v1beta1.FrpProxy{}, IndexNameForProxyServer, proxyServerIndexFunc); err != nil {
+		logger.Error(err, "unable register index filed for FrpProxy")
+		return err
+	}
+
+	// service frp server and claim annotations
+	if err := c.IndexField(ctx, &v1.Service{}, IndexNameForServiceServer, annotationIndexFunc(v1beta1.AnnotationFrpServerNameKey)); err != nil {
+		logger.Error(err, "unable register index filed for service")
+		return err
+	}
+	if err := c.IndexField(ctx, &v1.Service{}, IndexNameForServiceClaim, annotationIndexFunc(v1beta1.AnnotationFrpServerClaimNameKey)); err != nil {
+		logger.Error(err, "unable register index filed for service")
+		return err
+	}
+	if err := c.IndexField(ctx, &v1.Service{}, IndexNameForServiceGroup, annotationIndexFunc(v1beta1.AnnotationServerGroupKey)); err != nil {
+		logger.Error(err, "unable register index filed for service")
+		return err
+	}
 	return nil
 }
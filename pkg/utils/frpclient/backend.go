@@ -0,0 +1,90 @@
+package frpclient
+
+import (
+	"fmt"
+	controllerutils "github.com/frp-sigs/frp-provisioner/pkg/utils/controller"
+	v1 "k8s.io/api/core/v1"
+	"sort"
+)
+
+// ProxyBackend is the local address a proxy of a Service forwards its traffic to, the backends are
+// recorded on the frp client pods with the v1beta1.AnnotationProxyBackendsKey annotation.
+type ProxyBackend struct {
+	// Proxy is the name of the proxy
+	Proxy string `json:"proxy"`
+	// LocalIP is the ip or host name the proxy connects to
+	LocalIP string `json:"localIP"`
+	// LocalPort is the port the proxy connects to
+	LocalPort int32 `json:"localPort"`
+	// Protocol is the protocol of the service port
+	Protocol v1.Protocol `json:"protocol"`
+}
+
+// IsHeadless reports whether the Service has no cluster ip, its pods are addressed directly
+func IsHeadless(svc *v1.Service) bool {
+	return svc.Spec.Type == v1.ServiceTypeClusterIP && svc.Spec.ClusterIP == v1.ClusterIPNone
+}
+
+// ProxyBackends returns the backends of the proxies of a Service, the proxies are named after the ports
+// of the Service. The proxies of an ExternalName Service forward to its external host, a headless Service
+// gets a proxy per ready pod and port named "{pod}-{port}", which is stable for the pods of a StatefulSet.
+// The proxies of the other Services forward to their cluster ip. pods are the pods selected by the Service.
+func ProxyBackends(svc *v1.Service, pods []*v1.Pod) ([]ProxyBackend, error) {
+	backends := make([]ProxyBackend, 0, len(svc.Spec.Ports))
+	switch {
+	case svc.Spec.Type == v1.ServiceTypeExternalName:
+		if svc.Spec.ExternalName == "" {
+			return nil, fmt.Errorf("spec.externalName of service '%s/%s' should not be empty", svc.Namespace, svc.Name)
+		}
+		for _, port := range svc.Spec.Ports {
+			localPort := port.Port
+			if port.TargetPort.IntVal != 0 {
+				localPort = port.TargetPort.IntVal
+			}
+			backends = append(backends, ProxyBackend{Proxy: port.Name, LocalIP: svc.Spec.ExternalName, LocalPort: localPort, Protocol: port.Protocol})
+		}
+	case IsHeadless(svc):
+		for _, pod := range pods {
+			if pod.Status.PodIP == "" || !controllerutils.IsPodReady(pod) {
+				continue
+			}
+			for _, port := range svc.Spec.Ports {
+				localPort, err := targetPort(pod, port)
+				if err != nil {
+					return nil, err
+				}
+				backends = append(backends, ProxyBackend{
+					Proxy:     fmt.Sprintf("%s-%s", pod.Name, port.Name),
+					LocalIP:   pod.Status.PodIP,
+					LocalPort: localPort,
+					Protocol:  port.Protocol,
+				})
+			}
+		}
+		sort.Slice(backends, func(i, j int) bool { return backends[i].Proxy < backends[j].Proxy })
+	default:
+		for _, port := range svc.Spec.Ports {
+			backends = append(backends, ProxyBackend{Proxy: port.Name, LocalIP: svc.Spec.ClusterIP, LocalPort: port.Port, Protocol: port.Protocol})
+		}
+	}
+	return backends, nil
+}
+
+// targetPort resolves the target port of a service port on a pod, a named target port is looked up in
+// the container ports of the pod
+func targetPort(pod *v1.Pod, port v1.ServicePort) (int32, error) {
+	if port.TargetPort.StrVal == "" {
+		if port.TargetPort.IntVal != 0 {
+			return port.TargetPort.IntVal, nil
+		}
+		return port.Port, nil
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == port.TargetPort.StrVal {
+				return containerPort.ContainerPort, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("target port '%s' of service port '%s' is not a container port of pod '%s'", port.TargetPort.StrVal, port.Name, pod.Name)
+}
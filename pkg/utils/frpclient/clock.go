@@ -0,0 +1,49 @@
+package frpclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"net/http"
+	"time"
+)
+
+const (
+	// clockSkewProbeTimeout bounds the request reading the time of the frps host
+	clockSkewProbeTimeout = 5 * time.Second
+	// defaultClockSkewThreshold is the skew above which a FrpServer is skewed when the threshold is not set
+	defaultClockSkewThreshold = 30 * time.Second
+)
+
+// ClockSkewThreshold returns the skew above which the clock of the frps host is considered skewed
+func ClockSkewThreshold(obj *v1beta1.FrpServer) time.Duration {
+	if obj.Spec.ClockSkew == nil || obj.Spec.ClockSkew.ThresholdSeconds <= 0 {
+		return defaultClockSkewThreshold
+	}
+	return time.Duration(obj.Spec.ClockSkew.ThresholdSeconds) * time.Second
+}
+
+// MeasureClockSkew measures the offset of the clock of the frps host from the local clock, it's positive when
+// the frps host is ahead. The frp login and pong messages carry no timestamp, so the time of the host is read
+// from the Date header of spec.clockSkew.referenceURL. The header has a second resolution, the offset is taken
+// against the middle of the round trip and of that second.
+func MeasureClockSkew(ctx context.Context, obj *v1beta1.FrpServer) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, clockSkewProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, obj.Spec.ClockSkew.ReferenceURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable create clock skew probe request, got: %w", err)
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("unable read time of frp server host from '%s', got: %w", obj.Spec.ClockSkew.ReferenceURL, err)
+	}
+	rtt := time.Since(start)
+	_ = resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header of '%s', got: %w", obj.Spec.ClockSkew.ReferenceURL, err)
+	}
+	return date.Add(500 * time.Millisecond).Sub(start.Add(rtt / 2)), nil
+}
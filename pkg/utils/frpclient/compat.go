@@ -0,0 +1,138 @@
+package frpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/util/version"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	"io"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// serverFeature is a feature of the frp client config which frps only supports since minVersion
+type serverFeature struct {
+	// name describes the feature in the rejection
+	name string
+	// minVersion is the first frps version supporting the feature
+	minVersion string
+}
+
+var (
+	// featureQUICTransport is the quic transport protocol
+	featureQUICTransport = serverFeature{name: "transport protocol 'quic'", minVersion: "0.46.0"}
+	// featureServerBandwidthLimit is the bandwidth limited by frps, i.e. bandwidth limit mode "server"
+	featureServerBandwidthLimit = serverFeature{name: "bandwidth limit mode 'server'", minVersion: "0.48.0"}
+	// featureRouteByHTTPUser is the routing of the http and tcpmux proxies by the http user
+	featureRouteByHTTPUser = serverFeature{name: "route by http user", minVersion: "0.49.0"}
+	// featureAllowUsers is the users allowed to visit the stcp, xtcp and sudp proxies besides the proxy's own
+	featureAllowUsers = serverFeature{name: "allow users", minVersion: "0.50.0"}
+	// featureNatHole is the nat hole punching protocol of the xtcp proxies, frps before v0.48.0 speaks the
+	// older protocol the frp client doesn't
+	featureNatHole = serverFeature{name: "proxy type 'xtcp'", minVersion: "0.48.0"}
+)
+
+// supportedBy reports whether the frps version supports the feature, an unknown version supports every feature
+func (f serverFeature) supportedBy(serverVersion string) bool {
+	if serverVersion == "" {
+		return true
+	}
+	return compareVersion(serverVersion, f.minVersion) >= 0
+}
+
+func (f serverFeature) unsupported(serverVersion string) error {
+	return fmt.Errorf("%s needs frps v%s or later, the server runs v%s", f.name, f.minVersion, serverVersion)
+}
+
+// compareVersion compares the frp versions "{proto}.{major}.{minor}", it returns -1, 0 or 1
+func compareVersion(a, b string) int {
+	for _, part := range []func(string) int64{version.Proto, version.Major, version.Minor} {
+		if x, y := part(a), part(b); x != y {
+			return lo.Ternary(x < y, -1, 1)
+		}
+	}
+	return 0
+}
+
+// CheckServerCompatibility checks the features of the FrpServer config are supported by the frps version,
+// the version is the one reported by the server on login.
+func CheckServerCompatibility(obj *v1beta1.FrpServer, serverVersion string) (errs error) {
+	if lo.Contains(TransportProtocols(obj), v1beta1.FrpServerTransportProtocolQUIC) && !featureQUICTransport.supportedBy(serverVersion) {
+		errs = errors.Join(errs, featureQUICTransport.unsupported(serverVersion))
+	}
+	cfg := &configv1.ProxyBaseConfig{}
+	if err := ApplyProxyDefaults(cfg, obj, nil); err != nil {
+		return errors.Join(errs, err)
+	}
+	return errors.Join(errs, CheckProxyCompatibility(&configv1.TCPProxyConfig{ProxyBaseConfig: *cfg}, serverVersion))
+}
+
+// CheckProxyCompatibility checks the features of the proxy config are supported by the frps version, the
+// NewProxy messages of the frp clients are written unchanged so the fields frps doesn't know are rejected here.
+func CheckProxyCompatibility(cfg configv1.ProxyConfigurer, serverVersion string) (errs error) {
+	check := func(used bool, feature serverFeature) {
+		if used && !feature.supportedBy(serverVersion) {
+			errs = errors.Join(errs, feature.unsupported(serverVersion))
+		}
+	}
+	check(cfg.GetBaseConfig().Transport.BandwidthLimitMode == v1beta1.BandwidthLimitModeServer, featureServerBandwidthLimit)
+	switch cfg := cfg.(type) {
+	case *configv1.HTTPProxyConfig:
+		check(cfg.RouteByHTTPUser != "", featureRouteByHTTPUser)
+	case *configv1.TCPMuxProxyConfig:
+		check(cfg.RouteByHTTPUser != "", featureRouteByHTTPUser)
+	case *configv1.STCPProxyConfig:
+		check(len(cfg.AllowUsers) != 0, featureAllowUsers)
+	case *configv1.XTCPProxyConfig:
+		check(true, featureNatHole)
+		check(len(cfg.AllowUsers) != 0, featureAllowUsers)
+	case *configv1.SUDPProxyConfig:
+		check(len(cfg.AllowUsers) != 0, featureAllowUsers)
+	}
+	return errs
+}
+
+// msgField is a field of a frp message which older frps versions don't know, it's stripped before the
+// message is written to such a server instead of failing with an obscure unmarshal error on its side
+type msgField struct {
+	serverFeature
+	// strip zeroes the field of the message, it reports whether the field was set
+	strip func(m msg.Message) bool
+}
+
+// msgFields are the message fields gated by the frps version, only the messages written by the validation
+// login pass through WriteMsg. The proxy features of the frp client pods and sessions are checked against the
+// frps version by CheckProxyCompatibility instead.
+var msgFields = []msgField{
+	{serverFeature{name: "Login.ClientSpec", minVersion: "0.53.0"}, func(m msg.Message) bool {
+		login, ok := m.(*msg.Login)
+		if !ok || login.ClientSpec == (msg.ClientSpec{}) {
+			return false
+		}
+		login.ClientSpec = msg.ClientSpec{}
+		return true
+	}},
+}
+
+// StripUnsupportedFields zeroes the fields of the message which the frps version doesn't know, it returns the
+// names of the stripped fields. Nothing is stripped when the version is unknown.
+func StripUnsupportedFields(m msg.Message, serverVersion string) []string {
+	var stripped []string
+	for _, field := range msgFields {
+		if !field.supportedBy(serverVersion) && field.strip(m) {
+			stripped = append(stripped, field.name)
+		}
+	}
+	return stripped
+}
+
+// WriteMsg writes the message to a frps of the version after stripping the fields it doesn't know
+func WriteMsg(ctx context.Context, w io.Writer, m msg.Message, serverVersion string) error {
+	if stripped := StripUnsupportedFields(m, serverVersion); len(stripped) != 0 {
+		log.FromContext(ctx).V(1).Info("stripped message fields unsupported by frp server", "version", serverVersion, "fields", stripped)
+	}
+	return msg.WriteMsg(w, m)
+}
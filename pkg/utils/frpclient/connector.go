@@ -0,0 +1,138 @@
+package frpclient
+
+import (
+	"context"
+	frpclient "github.com/fatedier/frp/client"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/leak"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	fmux "github.com/hashicorp/yamux"
+	"github.com/samber/lo"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewConnector creates the connection manager for v1beta1.FrpServer. When tcp multiplexing is
+// enabled the yamux session is owned by the returned connector, so the stream open latency and the
+// concurrent stream count of the session are exported to detect head-of-line blocking. It's only used
+// by the frp clients running in the manager, the FrpProxy sessions and the validation login, the frp
+// client pods of the Services dial with the stock frpc connector.
+func NewConnector(ctx context.Context, obj *v1beta1.FrpServer, cfg *configv1.ClientCommonConfig) frpclient.Connector {
+	if cfg.Transport.Protocol == string(v1beta1.FrpServerTransportProtocolQUIC) || !lo.FromPtr(cfg.Transport.TCPMux) {
+		return &trackedConnector{Connector: frpclient.NewConnector(ctx, cfg), owner: obj.Name}
+	}
+	// The frp connector dials a new real connection on each Connect when tcp multiplexing is disabled
+	dialerCfg := *cfg
+	dialerCfg.Transport.TCPMux = lo.ToPtr(false)
+	return &trackedConnector{
+		Connector: &muxConnector{
+			server:    obj.Name,
+			transport: &obj.Spec.Transport,
+			dialer:    frpclient.NewConnector(ctx, &dialerCfg),
+		},
+		owner: obj.Name,
+	}
+}
+
+// trackedConnector registers the connections it returns with the leak sentinel
+type trackedConnector struct {
+	frpclient.Connector
+	owner string
+}
+
+// Connect returns a connection to the frp server tagged with the FrpServer name
+func (c *trackedConnector) Connect() (net.Conn, error) {
+	conn, err := c.Connector.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return leak.Track(conn, "frpserver/"+c.owner), nil
+}
+
+// muxConnector is a frpclient.Connector multiplexing streams over a single yamux session
+type muxConnector struct {
+	server    string
+	transport *v1beta1.FrpServerTransport
+	dialer    frpclient.Connector
+
+	session *fmux.Session
+	// streams is the number of streams opened by the connector which are not closed yet
+	streams   atomic.Int64
+	closeOnce sync.Once
+}
+
+// Open dials the underlying connection and starts the yamux session
+func (c *muxConnector) Open() error {
+	conn, err := c.dialer.Connect()
+	if err != nil {
+		return err
+	}
+	session, err := fmux.Client(conn, muxConfig(c.transport))
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	c.session = session
+	return nil
+}
+
+// muxConfig builds the yamux config from the tcp multiplexing tunables of v1beta1.FrpServerTransport
+func muxConfig(transport *v1beta1.FrpServerTransport) *fmux.Config {
+	fmuxCfg := fmux.DefaultConfig()
+	fmuxCfg.LogOutput = io.Discard
+	fmuxCfg.KeepAliveInterval = time.Duration(util.EmptyOr(transport.TCPMuxKeepaliveInterval, v1beta1.DefaultTCPMuxKeepaliveInterval)) * time.Second
+	fmuxCfg.MaxStreamWindowSize = uint32(util.EmptyOr(transport.TCPMuxMaxStreamWindowSize, v1beta1.DefaultTCPMuxMaxStreamWindowSize))
+	fmuxCfg.AcceptBacklog = util.EmptyOr(transport.TCPMuxAcceptBacklog, v1beta1.DefaultTCPMuxAcceptBacklog)
+	fmuxCfg.ConnectionWriteTimeout = time.Duration(util.EmptyOr(transport.TCPMuxConnectionWriteTimeout,
+		v1beta1.DefaultTCPMuxConnectionWriteTimeout)) * time.Second
+	return fmuxCfg
+}
+
+// Connect opens a new stream on the yamux session and records its open latency
+func (c *muxConnector) Connect() (net.Conn, error) {
+	start := time.Now()
+	stream, err := c.session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	metrics.TunnelMuxStreamOpenSeconds.WithLabelValues(c.server).Observe(time.Since(start).Seconds())
+	c.streams.Add(1)
+	metrics.TunnelMuxStreams.WithLabelValues(c.server).Inc()
+	return &muxStream{Stream: stream, connector: c}, nil
+}
+
+// Close closes the yamux session and all of its streams
+func (c *muxConnector) Close() error {
+	c.closeOnce.Do(func() {
+		if c.session != nil {
+			_ = c.session.Close()
+		}
+		metrics.TunnelMuxStreams.WithLabelValues(c.server).Sub(float64(c.streams.Swap(0)))
+	})
+	return nil
+}
+
+// muxStream is a yamux stream which is removed from the concurrent stream count once closed
+type muxStream struct {
+	*fmux.Stream
+	connector *muxConnector
+	closeOnce sync.Once
+}
+
+// Close closes the stream
+func (s *muxStream) Close() error {
+	s.closeOnce.Do(func() {
+		// the streams are already removed from the count when the session was closed
+		if s.connector.streams.Add(-1) >= 0 {
+			metrics.TunnelMuxStreams.WithLabelValues(s.connector.server).Dec()
+		} else {
+			s.connector.streams.Add(1)
+		}
+	})
+	return s.Stream.Close()
+}
@@ -0,0 +1,127 @@
+package frpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const (
+	// dashboardRequestTimeout bounds a request to the frps dashboard
+	dashboardRequestTimeout = 10 * time.Second
+	// DashboardProxyOnline is the status of a proxy with a connected client in the frps dashboard
+	DashboardProxyOnline = "online"
+)
+
+// DashboardCredentials are the basic auth credentials of the frps dashboard
+type DashboardCredentials struct {
+	User     string
+	Password string
+}
+
+// DashboardProxy is a proxy registered on the frps as reported by the dashboard
+type DashboardProxy struct {
+	// Name is the name of the proxy prefixed with the frp user
+	Name string `json:"name"`
+	// Type is the proxy type the proxy was listed with
+	Type string `json:"-"`
+	// Status is "online" while the client of the proxy is connected
+	Status string `json:"status"`
+	// Conf is the part of the proxy config used to identify the routes
+	Conf struct {
+		Metadatas     map[string]string `json:"metadatas,omitempty"`
+		CustomDomains []string          `json:"customDomains,omitempty"`
+		SubDomain     string            `json:"subdomain,omitempty"`
+	} `json:"conf"`
+}
+
+// Routes returns the custom domains and the subdomain of the proxy
+func (p *DashboardProxy) Routes() []string {
+	routes := append([]string{}, p.Conf.CustomDomains...)
+	if p.Conf.SubDomain != "" {
+		routes = append(routes, p.Conf.SubDomain)
+	}
+	return routes
+}
+
+// GetDashboardCredentials reads the basic auth credentials of the frps dashboard from spec.routeGC.credentialsSecretRef,
+// nil is returned when the dashboard has no credentials
+func GetDashboardCredentials(ctx context.Context, cli client.Reader, obj *v1beta1.FrpServer) (*DashboardCredentials, error) {
+	ref := obj.Spec.RouteGC.CredentialsSecretRef
+	if ref == nil {
+		return nil, nil
+	}
+	secret := &v1.Secret{}
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		return nil, fmt.Errorf("unable get secret '%s/%s', got: %w", ref.Namespace, ref.Name, err)
+	}
+	return &DashboardCredentials{
+		User:     string(secret.Data[v1.BasicAuthUsernameKey]),
+		Password: string(secret.Data[v1.BasicAuthPasswordKey]),
+	}, nil
+}
+
+// ListProxies lists the proxies of every type registered on the frps through the dashboard at spec.routeGC.dashboardURL
+func ListProxies(ctx context.Context, obj *v1beta1.FrpServer, creds *DashboardCredentials) ([]DashboardProxy, error) {
+	return ListProxiesOfTypes(ctx, obj, creds, v1beta1.ProxyTypeTCP, v1beta1.ProxyTypeUDP, v1beta1.ProxyTypeTCPMux, v1beta1.ProxyTypeHTTP,
+		v1beta1.ProxyTypeHTTPS, v1beta1.ProxyTypeSTCP, v1beta1.ProxyTypeXTCP, v1beta1.ProxyTypeSUDP)
+}
+
+// ListVhostProxies lists the http and https proxies registered on the frps through the dashboard at
+// spec.routeGC.dashboardURL, the offline proxies are listed too until the frps forgets them.
+func ListVhostProxies(ctx context.Context, obj *v1beta1.FrpServer, creds *DashboardCredentials) ([]DashboardProxy, error) {
+	return ListProxiesOfTypes(ctx, obj, creds, v1beta1.ProxyTypeHTTP, v1beta1.ProxyTypeHTTPS)
+}
+
+// ListProxiesOfTypes lists the proxies of the types registered on the frps through the dashboard at
+// spec.routeGC.dashboardURL
+func ListProxiesOfTypes(ctx context.Context, obj *v1beta1.FrpServer, creds *DashboardCredentials, proxyTypes ...string) ([]DashboardProxy, error) {
+	var proxies []DashboardProxy
+	for _, proxyType := range proxyTypes {
+		list, err := listDashboardProxies(ctx, obj.Spec.RouteGC.DashboardURL, proxyType, creds)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, list...)
+	}
+	return proxies, nil
+}
+
+// listDashboardProxies lists the proxies of a type from the dashboard
+func listDashboardProxies(ctx context.Context, dashboardURL, proxyType string, creds *DashboardCredentials) ([]DashboardProxy, error) {
+	ctx, cancel := context.WithTimeout(ctx, dashboardRequestTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(dashboardURL, "/") + "/api/proxy/" + proxyType
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable create frps dashboard request, got: %w", err)
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.User, creds.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable list %s proxies from '%s', got: %w", proxyType, url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable list %s proxies from '%s', got status: %s", proxyType, url, resp.Status)
+	}
+	var body struct {
+		Proxies []DashboardProxy `json:"proxies"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid %s proxies from '%s', got: %w", proxyType, url, err)
+	}
+	for i := range body.Proxies {
+		body.Proxies[i].Type = proxyType
+	}
+	return body.Proxies, nil
+}
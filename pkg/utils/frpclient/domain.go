@@ -0,0 +1,54 @@
+package frpclient
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	"hash/fnv"
+	v1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// ServiceDomain returns the ingress domain the http proxies of the service are published on. The domain
+// is selected by the v1beta1.AnnotationDomainKey annotation, the services without it are spread across
+// spec.domains by the hash of their name, so a service keeps its domain across reconciles. A wildcard
+// domain built from spec.subDomainHost is returned when the FrpServer has no domains, nil when neither is set.
+func ServiceDomain(server *v1beta1.FrpServer, svc *v1.Service) (*v1beta1.FrpServerDomain, error) {
+	name := svc.Annotations[v1beta1.AnnotationDomainKey]
+	if len(server.Spec.Domains) == 0 {
+		if name != "" && name != server.Spec.SubDomainHost {
+			return nil, fmt.Errorf("invalid annotations.%s '%s', frp server '%s' has no spec.domains", v1beta1.AnnotationDomainKey, name, server.Name)
+		}
+		if server.Spec.SubDomainHost == "" {
+			return nil, nil
+		}
+		return &v1beta1.FrpServerDomain{Name: server.Spec.SubDomainHost, Wildcard: true}, nil
+	}
+	if name == "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(svc.Namespace + "/" + svc.Name))
+		return &server.Spec.Domains[h.Sum32()%uint32(len(server.Spec.Domains))], nil
+	}
+	domain, ok := lo.Find(server.Spec.Domains, func(domain v1beta1.FrpServerDomain) bool { return domain.Name == name })
+	if !ok {
+		names := lo.Map(server.Spec.Domains, func(domain v1beta1.FrpServerDomain, _ int) string { return domain.Name })
+		return nil, fmt.Errorf("invalid annotations.%s '%s', optional values are %v", v1beta1.AnnotationDomainKey, name, names)
+	}
+	return &domain, nil
+}
+
+// Hostname returns the hostname a proxy with the subdomain is published on, it's empty when the proxy
+// is not published on the domain, i.e. a wildcard domain without a subdomain.
+func Hostname(domain *v1beta1.FrpServerDomain, subdomain string) string {
+	if domain == nil {
+		return ""
+	}
+	name := strings.ToLower(strings.TrimPrefix(domain.Name, "."))
+	if !domain.Wildcard {
+		return name
+	}
+	if subdomain == "" {
+		return ""
+	}
+	return strings.ToLower(subdomain) + "." + name
+}
@@ -0,0 +1,25 @@
+package frpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"hash/fnv"
+)
+
+// ServerConfigHash returns the hash of the FrpServer settings the frp client pods apply, i.e. the login,
+// transport and heartbeat settings of the common config and the proxy defaults. frpc only applies them on
+// start, a change needs a restart of the frp client pods.
+func ServerConfigHash(obj *v1beta1.FrpServer) string {
+	return hashJSON(struct {
+		Common        any `json:"common"`
+		ProxyDefaults any `json:"proxyDefaults"`
+	}{ClientCommonConfig(obj, nil), obj.Spec.ProxyDefaults})
+}
+
+func hashJSON(v any) string {
+	data, _ := json.Marshal(v)
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
@@ -0,0 +1,81 @@
+package frpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultInlineServerPort is the port of an inline server when the annotation doesn't set it
+const defaultInlineServerPort = 7000
+
+// InlineServer is the minimal frps config of a one-off tunnel, it's set on a Service with the
+// v1beta1.AnnotationInlineServerKey annotation instead of creating a FrpServer.
+type InlineServer struct {
+	// ServerAddr is the address of the frps
+	ServerAddr string `json:"serverAddr"`
+	// ServerPort is the port of the frps, defaults to 7000
+	ServerPort int `json:"serverPort,omitempty"`
+	// User prefixes the proxy names on the frps
+	User string `json:"user,omitempty"`
+	// TokenSecretRef selects the auth token in a Secret of the namespace of the Service
+	TokenSecretRef *v1.SecretKeySelector `json:"tokenSecretRef,omitempty"`
+}
+
+// ParseInlineServer parses the inline server of a Service from its annotations, nil is returned when the
+// Service doesn't set one
+func ParseInlineServer(annotations map[string]string) (*InlineServer, error) {
+	value, ok := annotations[v1beta1.AnnotationInlineServerKey]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	s := &InlineServer{}
+	if err := json.Unmarshal([]byte(value), s); err != nil {
+		return nil, fmt.Errorf("invalid annotations.%s '%s', got: %w", v1beta1.AnnotationInlineServerKey, value, err)
+	}
+	if s.ServerAddr == "" {
+		return nil, fmt.Errorf("field serverAddr of annotations.%s should not be empty", v1beta1.AnnotationInlineServerKey)
+	}
+	if s.ServerPort == 0 {
+		s.ServerPort = defaultInlineServerPort
+	}
+	if s.ServerPort < 1 || s.ServerPort > 65535 {
+		return nil, fmt.Errorf("field serverPort of annotations.%s should be in the range 1..65535", v1beta1.AnnotationInlineServerKey)
+	}
+	if s.User != "" && !userPattern.MatchString(s.User) {
+		return nil, fmt.Errorf("invalid field user '%s' of annotations.%s, it should be at most 63 alphanumeric characters,"+
+			" '-' or '_', starting and ending with an alphanumeric character", s.User, v1beta1.AnnotationInlineServerKey)
+	}
+	if s.TokenSecretRef != nil && (s.TokenSecretRef.Name == "" || s.TokenSecretRef.Key == "") {
+		return nil, fmt.Errorf("fields tokenSecretRef.name and tokenSecretRef.key of annotations.%s should not be empty", v1beta1.AnnotationInlineServerKey)
+	}
+	return s, nil
+}
+
+// InlineServerName is the name of the FrpServer of the inline server of a Service in events, metrics and the
+// annotations of its frp client pods
+func InlineServerName(svc *v1.Service) string {
+	return "inline:" + svc.Namespace + "/" + svc.Name
+}
+
+// FrpServer returns the FrpServer of the inline server of the Service, it's never stored. The token is
+// resolved from TokenSecretRef by the caller, the frps address is published as the ingress point.
+func (s *InlineServer) FrpServer(svc *v1.Service, token string) *v1beta1.FrpServer {
+	return &v1beta1.FrpServer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        InlineServerName(svc),
+			Annotations: map[string]string{v1beta1.AnnotationInlineServerKey: svc.Annotations[v1beta1.AnnotationInlineServerKey]},
+		},
+		Spec: v1beta1.FrpServerSpec{
+			Auth:        v1beta1.FrpServerAuth{Method: v1beta1.FrpServerAuthMethodToken, Token: token},
+			User:        s.User,
+			ServerAddr:  s.ServerAddr,
+			ServerPort:  s.ServerPort,
+			ExternalIPs: []string{s.ServerAddr},
+			Transport:   v1beta1.FrpServerTransport{Protocol: v1beta1.FrpServerTransportProtocolTCP},
+		},
+		Status: v1beta1.FrpServerStatus{Phase: v1beta1.FrpServerPhaseHealthy},
+	}
+}
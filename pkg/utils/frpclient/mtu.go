@@ -0,0 +1,95 @@
+package frpclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// mtuProbeTimeout bounds the time spent waiting for ICMP fragmentation needed messages
+	mtuProbeTimeout = 200 * time.Millisecond
+	// defaultProbeMTU is the largest path MTU probed, the ethernet MTU
+	defaultProbeMTU = 1500
+	udpHeaderSize   = 8
+	ipv4HeaderSize  = 20
+	ipv6HeaderSize  = 40
+)
+
+// needsMTUProbe reports whether the transport protocol runs over UDP and is sensitive to fragmentation
+func needsMTUProbe(protocol string) bool {
+	return protocol == string(v1beta1.FrpServerTransportProtocolQUIC) || protocol == string(v1beta1.FrpServerTransportProtocolKCP)
+}
+
+// mtuProbeTTL is the age after which the path MTU towards a frp server is probed again
+const mtuProbeTTL = 10 * time.Minute
+
+// mtuProbe is the last path MTU probe towards an address
+type mtuProbe struct {
+	packetSize int64
+	err        error
+	probedAt   time.Time
+	running    bool
+}
+
+var (
+	mtuProbesLock sync.Mutex
+	// mtuProbes are the path MTU probes by address, they run in the background so the callers never
+	// wait for the ICMP replies
+	mtuProbes = make(map[string]*mtuProbe)
+)
+
+// CachedUDPPacketSize returns the udp packet size last detected towards the frp server, a new probe is
+// started in the background when there's none or it's older than mtuProbeTTL. ok is false until the
+// first probe of the address completes.
+func CachedUDPPacketSize(address string) (packetSize int64, ok bool, err error) {
+	mtuProbesLock.Lock()
+	defer mtuProbesLock.Unlock()
+	probe, found := mtuProbes[address]
+	if !found {
+		probe = &mtuProbe{}
+		mtuProbes[address] = probe
+	}
+	if !probe.running && time.Since(probe.probedAt) > mtuProbeTTL {
+		probe.running = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*mtuProbeTimeout)
+			defer cancel()
+			packetSize, err := DetectUDPPacketSize(ctx, address)
+			mtuProbesLock.Lock()
+			defer mtuProbesLock.Unlock()
+			probe.packetSize, probe.err, probe.probedAt, probe.running = packetSize, err, time.Now(), false
+		}()
+	}
+	if probe.probedAt.IsZero() {
+		return 0, false, nil
+	}
+	return probe.packetSize, true, probe.err
+}
+
+// DetectUDPPacketSize probes the path MTU towards the frp server and returns the largest udp payload
+// which is not fragmented on the path. It blocks for mtuProbeTimeout, CachedUDPPacketSize doesn't.
+func DetectUDPPacketSize(ctx context.Context, address string) (int64, error) {
+	dialer := &net.Dialer{Timeout: mtuProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return 0, fmt.Errorf("unable dial udp address '%s', got: %w", address, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	udpConn := conn.(*net.UDPConn)
+	remote := udpConn.RemoteAddr().(*net.UDPAddr)
+	headerSize := ipv4HeaderSize + udpHeaderSize
+	if remote.IP.To4() == nil {
+		headerSize = ipv6HeaderSize + udpHeaderSize
+	}
+	mtu, err := probePathMTU(udpConn, defaultProbeMTU-headerSize)
+	if err != nil {
+		return 0, fmt.Errorf("unable probe path mtu towards '%s', got: %w", address, err)
+	}
+	return int64(mtu - headerSize), nil
+}
@@ -0,0 +1,46 @@
+package frpclient
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"syscall"
+	"time"
+)
+
+// probePathMTU sends a probe of payloadSize bytes with the don't fragment bit set and returns
+// the path MTU the kernel learned from the route and any ICMP fragmentation needed replies.
+func probePathMTU(conn *net.UDPConn, payloadSize int) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	ipv6 := conn.RemoteAddr().(*net.UDPAddr).IP.To4() == nil
+	level, discoverOpt, mtuOpt, discoverDo := unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_MTU, unix.IP_PMTUDISC_DO
+	if ipv6 {
+		level, discoverOpt, mtuOpt, discoverDo = unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_MTU, unix.IPV6_PMTUDISC_DO
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), level, discoverOpt, discoverDo)
+	}); err != nil {
+		return 0, err
+	}
+	if sockErr != nil {
+		return 0, sockErr
+	}
+	// A probe larger than the known path MTU fails locally with EMSGSIZE, otherwise
+	// routers on the path may answer with ICMP fragmentation needed.
+	if _, err := conn.Write(make([]byte, payloadSize)); err != nil && !errors.Is(err, syscall.EMSGSIZE) {
+		return 0, err
+	}
+	time.Sleep(mtuProbeTimeout)
+
+	var mtu int
+	if err := raw.Control(func(fd uintptr) {
+		mtu, sockErr = unix.GetsockoptInt(int(fd), level, mtuOpt)
+	}); err != nil {
+		return 0, err
+	}
+	return mtu, sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package frpclient
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// probePathMTU is only supported on linux
+func probePathMTU(_ *net.UDPConn, _ int) (int, error) {
+	return 0, fmt.Errorf("path mtu detection is not supported on %s", runtime.GOOS)
+}
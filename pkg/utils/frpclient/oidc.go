@@ -0,0 +1,259 @@
+package frpclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatedier/frp/pkg/auth"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
+	"github.com/samber/lo"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcRenewFraction is the fraction of the token lifetime after which the token is renewed proactively
+	oidcRenewFraction = 0.8
+	// oidcMinRenewInterval prevents a hot loop when the token endpoint issues very short-lived tokens
+	oidcMinRenewInterval = 5 * time.Second
+)
+
+// oidcRefreshBackoff is used to retry the token endpoint on failures
+var oidcRefreshBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
+}
+
+var _ auth.Setter = &OIDCAuthSetter{}
+
+// OIDCAuthSetter is an auth.Setter which caches the OIDC access token instead of requesting a new
+// one for every message. The token is considered expired skew earlier than reported by the token
+// endpoint, and Run can be used to renew it proactively at 80% of its lifetime.
+type OIDCAuthSetter struct {
+	name             string
+	skew             time.Duration
+	additionalScopes []configv1.AuthScope
+	tokenGenerator   *clientcredentials.Config
+
+	lock     sync.Mutex
+	token    *oauth2.Token
+	issuedAt time.Time
+}
+
+// NewOIDCAuthSetter create a OIDCAuthSetter, name is used to label the token metrics
+func NewOIDCAuthSetter(name string, additionalScopes []configv1.AuthScope, cfg configv1.AuthOIDCClientConfig, skew time.Duration) *OIDCAuthSetter {
+	eps := make(map[string][]string)
+	for k, v := range cfg.AdditionalEndpointParams {
+		eps[k] = []string{v}
+	}
+	if cfg.Audience != "" {
+		eps["audience"] = []string{cfg.Audience}
+	}
+	return &OIDCAuthSetter{
+		name:             name,
+		skew:             skew,
+		additionalScopes: additionalScopes,
+		tokenGenerator: &clientcredentials.Config{
+			ClientID:       cfg.ClientID,
+			ClientSecret:   cfg.ClientSecret,
+			Scopes:         []string{cfg.Scope},
+			TokenURL:       cfg.TokenEndpointURL,
+			EndpointParams: eps,
+		},
+	}
+}
+
+// NewAuthSetter create auth.Setter from frp auth config, OIDC tokens are cached and renewed by OIDCAuthSetter
+func NewAuthSetter(name string, cfg configv1.AuthClientConfig, skew time.Duration) auth.Setter {
+	if cfg.Method == configv1.AuthMethodOIDC {
+		return NewOIDCAuthSetter(name, cfg.AdditionalScopes, cfg.OIDC, skew)
+	}
+	return auth.NewAuthSetter(cfg)
+}
+
+// Run renews the access token proactively until ctx is done
+func (s *OIDCAuthSetter) Run(ctx context.Context) {
+	logger := log.FromContext(ctx).WithValues("server", s.name)
+	for {
+		timer := time.NewTimer(s.nextRenewal())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := s.refresh(ctx); err != nil {
+			logger.Error(err, "Unable renew OIDC access token")
+		}
+	}
+}
+
+// AuthSetters caches the auth.Setter of each FrpServer, so the OIDC access token of a FrpServer is reused across
+// its logins and renewed proactively. The OIDC setters are renewed with the context passed to Start.
+type AuthSetters struct {
+	lock    sync.Mutex
+	ctx     context.Context
+	setters map[string]*authSetterEntry
+}
+
+// authSetterEntry is the cached auth.Setter of a FrpServer and the config it was created from
+type authSetterEntry struct {
+	cfg    configv1.AuthClientConfig
+	skew   time.Duration
+	setter auth.Setter
+	cancel context.CancelFunc
+}
+
+// NewAuthSetters returns an empty AuthSetters, it should be added to the manager so the tokens are renewed
+func NewAuthSetters() *AuthSetters {
+	return &AuthSetters{setters: make(map[string]*authSetterEntry)}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the setters are created by the FrpServer
+// logins which only run on the leader.
+func (a *AuthSetters) NeedLeaderElection() bool {
+	return false
+}
+
+// Start renews the tokens of the cached OIDC setters until ctx is done
+func (a *AuthSetters) Start(ctx context.Context) error {
+	a.lock.Lock()
+	a.ctx = ctx
+	for _, entry := range a.setters {
+		a.run(entry)
+	}
+	a.lock.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+// Get returns the cached auth.Setter of the FrpServer named name, it's replaced when the auth config changed.
+// A nil AuthSetters returns a new auth.Setter.
+func (a *AuthSetters) Get(name string, cfg configv1.AuthClientConfig, skew time.Duration) auth.Setter {
+	if a == nil {
+		return NewAuthSetter(name, cfg, skew)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if entry, ok := a.setters[name]; ok && entry.skew == skew && equality.Semantic.DeepEqual(entry.cfg, cfg) {
+		return entry.setter
+	}
+	a.stop(name)
+	entry := &authSetterEntry{cfg: cfg, skew: skew, setter: NewAuthSetter(name, cfg, skew)}
+	a.setters[name] = entry
+	if a.ctx != nil {
+		a.run(entry)
+	}
+	return entry.setter
+}
+
+// Forget stops renewing the token of the FrpServer named name and removes its token metrics
+func (a *AuthSetters) Forget(name string) {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.stop(name)
+	metrics.OIDCTokenAge.DeleteLabelValues(name)
+}
+
+// run renews the token of an OIDC setter until the AuthSetters stops or the setter is replaced
+func (a *AuthSetters) run(entry *authSetterEntry) {
+	setter, ok := entry.setter.(*OIDCAuthSetter)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	entry.cancel = cancel
+	go setter.Run(ctx)
+}
+
+// stop removes the setter of the FrpServer named name from the cache and stops its renewal
+func (a *AuthSetters) stop(name string) {
+	if entry, ok := a.setters[name]; ok && entry.cancel != nil {
+		entry.cancel()
+	}
+	delete(a.setters, name)
+}
+
+// nextRenewal returns the duration until the cached token should be renewed
+func (s *OIDCAuthSetter) nextRenewal() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.token == nil || s.token.Expiry.IsZero() {
+		return oidcMinRenewInterval
+	}
+	lifetime := s.token.Expiry.Sub(s.issuedAt) - s.skew
+	renewAt := s.issuedAt.Add(time.Duration(float64(lifetime) * oidcRenewFraction))
+	return lo.Max([]time.Duration{time.Until(renewAt), oidcMinRenewInterval})
+}
+
+// accessToken returns the cached access token, or requests a new one when it is about to expire
+func (s *OIDCAuthSetter) accessToken(ctx context.Context) (string, error) {
+	s.lock.Lock()
+	token, issuedAt := s.token, s.issuedAt
+	s.lock.Unlock()
+	if token != nil && (token.Expiry.IsZero() || time.Now().Add(s.skew).Before(token.Expiry)) {
+		metrics.OIDCTokenAge.WithLabelValues(s.name).Set(time.Since(issuedAt).Seconds())
+		return token.AccessToken, nil
+	}
+	return s.refresh(ctx)
+}
+
+// refresh requests a new access token from the token endpoint, retrying with backoff
+func (s *OIDCAuthSetter) refresh(ctx context.Context) (string, error) {
+	var (
+		token   *oauth2.Token
+		lastErr error
+	)
+	err := wait.ExponentialBackoffWithContext(ctx, oidcRefreshBackoff, func(ctx context.Context) (bool, error) {
+		token, lastErr = s.tokenGenerator.Token(ctx)
+		if lastErr != nil {
+			metrics.OIDCTokenRefreshFailuresTotal.WithLabelValues(s.name).Inc()
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't generate OIDC token, got: %w", lo.Ternary(lastErr != nil, lastErr, err))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+	s.issuedAt = time.Now()
+	metrics.OIDCTokenAge.WithLabelValues(s.name).Set(0)
+	return token.AccessToken, nil
+}
+
+// SetLogin implements auth.Setter
+func (s *OIDCAuthSetter) SetLogin(loginMsg *msg.Login) (err error) {
+	loginMsg.PrivilegeKey, err = s.accessToken(context.Background())
+	return err
+}
+
+// SetPing implements auth.Setter
+func (s *OIDCAuthSetter) SetPing(pingMsg *msg.Ping) (err error) {
+	if !lo.Contains(s.additionalScopes, configv1.AuthScopeHeartBeats) {
+		return nil
+	}
+	pingMsg.PrivilegeKey, err = s.accessToken(context.Background())
+	return err
+}
+
+// SetNewWorkConn implements auth.Setter
+func (s *OIDCAuthSetter) SetNewWorkConn(newWorkConnMsg *msg.NewWorkConn) (err error) {
+	if !lo.Contains(s.additionalScopes, configv1.AuthScopeNewWorkConns) {
+		return nil
+	}
+	newWorkConnMsg.PrivilegeKey, err = s.accessToken(context.Background())
+	return err
+}
@@ -0,0 +1,67 @@
+package frpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	"sort"
+)
+
+// ProxyDependencies parses the proxies each proxy of a Service depends on from its annotations
+func ProxyDependencies(annotations map[string]string) (map[string][]string, error) {
+	value, ok := annotations[v1beta1.AnnotationProxyDependsOnKey]
+	if !ok || value == "" {
+		return nil, nil
+	}
+	dependsOn := make(map[string][]string)
+	if err := json.Unmarshal([]byte(value), &dependsOn); err != nil {
+		return nil, fmt.Errorf("invalid annotations.%s '%s', got: %w", v1beta1.AnnotationProxyDependsOnKey, value, err)
+	}
+	return dependsOn, nil
+}
+
+// StartOrder groups the proxies into waves, the proxies of a wave only depend on proxies of earlier
+// waves so they can be started in parallel once the earlier waves are started. Proxies without
+// dependencies are all started in the first wave.
+func StartOrder(proxies []string, dependsOn map[string][]string) ([][]string, error) {
+	pending := make(map[string][]string, len(proxies))
+	for _, name := range proxies {
+		pending[name] = lo.Uniq(dependsOn[name])
+	}
+	for name, deps := range dependsOn {
+		if _, ok := pending[name]; !ok {
+			return nil, fmt.Errorf("proxy '%s' has dependencies but does not exist, optional values are %v", name, proxies)
+		}
+		for _, dep := range deps {
+			if _, ok := pending[dep]; !ok {
+				return nil, fmt.Errorf("proxy '%s' depends on unknown proxy '%s', optional values are %v", name, dep, proxies)
+			}
+			if dep == name {
+				return nil, fmt.Errorf("proxy '%s' depends on itself", name)
+			}
+		}
+	}
+	started := make(map[string]bool, len(proxies))
+	waves := make([][]string, 0)
+	for len(pending) != 0 {
+		wave := make([]string, 0, len(pending))
+		for name, deps := range pending {
+			if lo.EveryBy(deps, func(dep string) bool { return started[dep] }) {
+				wave = append(wave, name)
+			}
+		}
+		if len(wave) == 0 {
+			names := lo.Keys(pending)
+			sort.Strings(names)
+			return nil, fmt.Errorf("proxies %v have a dependency cycle", names)
+		}
+		sort.Strings(wave)
+		for _, name := range wave {
+			started[name] = true
+			delete(pending, name)
+		}
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
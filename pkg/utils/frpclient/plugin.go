@@ -0,0 +1,46 @@
+package frpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// pluginCertDir is the directory the certificates of the client plugins run by the manager are written to, it's
+// kept apart from the temp files pruned by the janitor since the plugins read the files for as long as they run
+var pluginCertDir = filepath.Join(os.TempDir(), TempFilePrefix+"plugin-certs")
+
+// WritePluginCert writes the certificate and key a client plugin of the proxy named name serves and returns their
+// paths. The files are named after the hash of their content, so a rotated certificate changes the plugin config
+// and restarts the proxy, the previous files of the proxy are removed.
+func WritePluginCert(name string, cert, key []byte) (string, string, error) {
+	dir := filepath.Join(pluginCertDir, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", fmt.Errorf("unable create plugin cert dir '%s', got: %w", dir, err)
+	}
+	sum := sha256.Sum256(append(append([]byte{}, cert...), key...))
+	hash := hex.EncodeToString(sum[:8])
+	certPath, keyPath := filepath.Join(dir, hash+".crt"), filepath.Join(dir, hash+".key")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("unable read plugin cert dir '%s', got: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if path := filepath.Join(dir, entry.Name()); path != certPath && path != keyPath {
+			_ = os.Remove(path)
+		}
+	}
+	for path, data := range map[string][]byte{certPath: cert, keyPath: key} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return "", "", fmt.Errorf("unable write plugin cert file '%s', got: %w", path, err)
+		}
+	}
+	return certPath, keyPath, nil
+}
+
+// RemovePluginCert removes the certificate files of the client plugin of the proxy named name
+func RemovePluginCert(name string) error {
+	return os.RemoveAll(filepath.Join(pluginCertDir, name))
+}
@@ -0,0 +1,119 @@
+package frpclient
+
+import (
+	"fmt"
+	"github.com/fatedier/frp/pkg/config/types"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	"regexp"
+	"strconv"
+)
+
+// userPattern is the charset of a frp user, frps prefixes the proxy names with "{user}." so dots
+// are not allowed to keep the proxy names of different users apart.
+var userPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?$`)
+
+// ProxyUser returns the frp user of the proxies of a Service, the annotations of the Service override
+// spec.user of the FrpServer it's scheduled on.
+func ProxyUser(obj *v1beta1.FrpServer, annotations map[string]string) (string, error) {
+	user, ok := annotations[v1beta1.AnnotationUserKey]
+	if !ok {
+		return obj.Spec.User, nil
+	}
+	if !userPattern.MatchString(user) {
+		return "", fmt.Errorf("invalid annotations.%s '%s', it should be at most 63 alphanumeric characters,"+
+			" '-' or '_', starting and ending with an alphanumeric character", v1beta1.AnnotationUserKey, user)
+	}
+	return user, nil
+}
+
+// ProxyDefaultsAnnotations are the annotations overriding spec.proxyDefaults of the FrpServer
+var ProxyDefaultsAnnotations = []string{
+	v1beta1.AnnotationUseEncryptionKey,
+	v1beta1.AnnotationUseCompressionKey,
+	v1beta1.AnnotationBandwidthLimitKey,
+	v1beta1.AnnotationBandwidthLimitModeKey,
+	v1beta1.AnnotationHealthCheckTypeKey,
+	v1beta1.AnnotationHealthCheckPathKey,
+}
+
+// ApplyProxyDefaults sets the transport and health check of a proxy from spec.proxyDefaults of the
+// FrpServer it's scheduled on, the annotations of the Service override the defaults field by field.
+func ApplyProxyDefaults(cfg *configv1.ProxyBaseConfig, obj *v1beta1.FrpServer, annotations map[string]string) error {
+	defaults := &v1beta1.FrpServerProxyDefaults{}
+	if obj.Spec.ProxyDefaults != nil {
+		defaults = obj.Spec.ProxyDefaults
+	}
+	useEncryption, err := boolAnnotation(annotations, v1beta1.AnnotationUseEncryptionKey, defaults.UseEncryption)
+	if err != nil {
+		return err
+	}
+	useCompression, err := boolAnnotation(annotations, v1beta1.AnnotationUseCompressionKey, defaults.UseCompression)
+	if err != nil {
+		return err
+	}
+	cfg.Transport.UseEncryption = useEncryption
+	cfg.Transport.UseCompression = useCompression
+
+	bandwidthLimit := defaults.BandwidthLimit
+	if value, ok := annotations[v1beta1.AnnotationBandwidthLimitKey]; ok {
+		bandwidthLimit = value
+	}
+	if cfg.Transport.BandwidthLimit, err = types.NewBandwidthQuantity(bandwidthLimit); err != nil {
+		return fmt.Errorf("invalid bandwidth limit '%s', got: %w", bandwidthLimit, err)
+	}
+	bandwidthLimitMode := util.EmptyOr(defaults.BandwidthLimitMode, v1beta1.BandwidthLimitModeClient)
+	if value, ok := annotations[v1beta1.AnnotationBandwidthLimitModeKey]; ok {
+		bandwidthLimitMode = value
+	}
+	if !lo.Contains([]string{v1beta1.BandwidthLimitModeClient, v1beta1.BandwidthLimitModeServer}, bandwidthLimitMode) {
+		return fmt.Errorf("invalid bandwidth limit mode '%s', optional values are %v", bandwidthLimitMode,
+			[]string{v1beta1.BandwidthLimitModeClient, v1beta1.BandwidthLimitModeServer})
+	}
+	cfg.Transport.BandwidthLimitMode = bandwidthLimitMode
+
+	healthCheck := configv1.HealthCheckConfig{}
+	if defaults.HealthCheck != nil {
+		healthCheck = configv1.HealthCheckConfig{
+			Type:            defaults.HealthCheck.Type,
+			TimeoutSeconds:  defaults.HealthCheck.TimeoutSeconds,
+			MaxFailed:       defaults.HealthCheck.MaxFailed,
+			IntervalSeconds: defaults.HealthCheck.IntervalSeconds,
+			Path:            defaults.HealthCheck.Path,
+		}
+	}
+	if value, ok := annotations[v1beta1.AnnotationHealthCheckTypeKey]; ok {
+		healthCheck.Type = value
+	}
+	if value, ok := annotations[v1beta1.AnnotationHealthCheckPathKey]; ok {
+		healthCheck.Path = value
+	}
+	switch healthCheck.Type {
+	case "", v1beta1.HealthCheckTypeNone:
+		healthCheck = configv1.HealthCheckConfig{}
+	case v1beta1.HealthCheckTypeTCP, v1beta1.HealthCheckTypeHTTP:
+	default:
+		return fmt.Errorf("invalid health check type '%s', optional values are %v", healthCheck.Type,
+			[]string{v1beta1.HealthCheckTypeTCP, v1beta1.HealthCheckTypeHTTP, v1beta1.HealthCheckTypeNone})
+	}
+	if healthCheck.Type == v1beta1.HealthCheckTypeHTTP && healthCheck.Path == "" {
+		return fmt.Errorf("health check path should not be empty when the health check type is http")
+	}
+	cfg.HealthCheck = healthCheck
+	return nil
+}
+
+// boolAnnotation returns the boolean annotation value of key, or the default when it's not set
+func boolAnnotation(annotations map[string]string, key string, defaultValue *bool) (bool, error) {
+	value, ok := annotations[key]
+	if !ok {
+		return defaultValue != nil && *defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid annotations.%s '%s', got: %w", key, value, err)
+	}
+	return b, nil
+}
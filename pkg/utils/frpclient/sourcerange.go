@@ -0,0 +1,45 @@
+package frpclient
+
+import (
+	"fmt"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"net"
+	"strings"
+)
+
+// SourceRanges are the CIDRs the users of a Service may connect from, they're set on a Service with the
+// v1beta1.AnnotationSourceRangesKey annotation and enforced by the frps access plugin, the FrpProxy objects
+// carrying the annotation are enforced by the frp client of the manager.
+type SourceRanges []*net.IPNet
+
+// ParseSourceRanges parses the source ranges of a Service from its annotations, nil is returned when
+// the source ranges are not set
+func ParseSourceRanges(annotations map[string]string) (SourceRanges, error) {
+	value := strings.TrimSpace(annotations[v1beta1.AnnotationSourceRangesKey])
+	if value == "" {
+		return nil, nil
+	}
+	var ranges SourceRanges
+	for _, cidr := range strings.Split(value, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotations.%s '%s', got: %w", v1beta1.AnnotationSourceRangesKey, value, err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// Allows reports whether the user address is in one of the source ranges
+func (s SourceRanges) Allows(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
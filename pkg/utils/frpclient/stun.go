@@ -0,0 +1,37 @@
+package frpclient
+
+import (
+	"github.com/fatedier/frp/pkg/nathole"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// STUNServers returns the STUN servers of v1beta1.FrpServer in order of preference, the primary
+// spec.natHoleStunServer first and the spec.natHoleStunFallbackServers after it
+func STUNServers(obj *v1beta1.FrpServer) []string {
+	servers := append([]string{obj.Spec.NatHoleSTUNServer}, obj.Spec.NatHoleSTUNFallbackServers...)
+	return lo.Uniq(lo.Compact(servers))
+}
+
+// ProbeSTUNServers sends a binding request to every STUN server of v1beta1.FrpServer and reports whether
+// it answered, each request is bounded by the response timeout of the frp nathole discovery.
+func ProbeSTUNServers(obj *v1beta1.FrpServer) []v1beta1.FrpServerSTUNServerStatus {
+	now := metav1.Now()
+	return lo.Map(STUNServers(obj), func(addr string, _ int) v1beta1.FrpServerSTUNServerStatus {
+		status := v1beta1.FrpServerSTUNServerStatus{Address: addr, Available: true, LastProbeTime: now}
+		if _, _, err := nathole.Discover([]string{addr}, ""); err != nil {
+			status.Available, status.Message = false, err.Error()
+		}
+		return status
+	})
+}
+
+// SelectSTUNServer returns the first available STUN server of the probe statuses, the primary
+// spec.natHoleStunServer is returned when none of them is available
+func SelectSTUNServer(obj *v1beta1.FrpServer, statuses []v1beta1.FrpServerSTUNServerStatus) string {
+	if status, ok := lo.Find(statuses, func(s v1beta1.FrpServerSTUNServerStatus) bool { return s.Available }); ok {
+		return status.Address
+	}
+	return obj.Spec.NatHoleSTUNServer
+}
@@ -0,0 +1,124 @@
+package frpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
+	"github.com/fatedier/frp/pkg/util/util"
+	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/tlspolicy"
+	"github.com/samber/lo"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemCABundles are the well known locations of the system trust store on the supported distributions,
+// the first one which exists is used. SSL_CERT_FILE takes precedence like it does for crypto/x509.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// ParseCABundle parses the PEM encoded CA certs of a bundle, the bundle may hold several certs. Every PEM
+// block must be a cert, so a key pasted by mistake is rejected instead of silently ignored.
+func ParseCABundle(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := bytes.TrimSpace(data); len(rest) != 0; rest = bytes.TrimSpace(rest) {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("ca bundle holds data which is not PEM encoded after %d certs", len(certs))
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("ca bundle holds a PEM block of type '%s', only certificates are allowed", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable parse cert %d of ca bundle, got: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("ca bundle holds no certificate")
+	}
+	return certs, nil
+}
+
+// systemCABundle returns the PEM encoded certs of the system trust store
+func systemCABundle() ([]byte, error) {
+	paths := systemCABundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		paths = []string{file}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unable read system ca bundle '%s', got: %w", path, err)
+		}
+	}
+	return nil, fmt.Errorf("system ca bundle not found in %v", paths)
+}
+
+// TrustedCABundle returns the PEM encoded certs frpc trusts to verify the frp server of v1beta1.FrpServer.
+// frpc trusts only the certs of its trusted ca file, so the system trust store is prepended to caData
+// when spec.transport.tls.trustedCAMode is v1beta1.FrpServerTrustedCAModeMerge.
+func TrustedCABundle(obj *v1beta1.FrpServer, caData []byte) ([]byte, error) {
+	if _, err := ParseCABundle(caData); err != nil {
+		return nil, fmt.Errorf("invalid transport tls file '%s', got: %w", v1beta1.DefaultCaFileName, err)
+	}
+	if obj.Spec.Transport.TLS.TrustedCAMode != v1beta1.FrpServerTrustedCAModeMerge {
+		return caData, nil
+	}
+	system, err := systemCABundle()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join([][]byte{bytes.TrimSpace(system), bytes.TrimSpace(caData), nil}, []byte("\n")), nil
+}
+
+// CheckTLSPolicy completes a tls handshake restricted to spec.transport.tls.policy with the server over tcp. frp
+// dials its tls connections with the defaults of crypto/tls, so the handshake shows the server negotiates within
+// the policy before the login. The certificate is verified by the login which follows.
+func CheckTLSPolicy(ctx context.Context, obj *v1beta1.FrpServer, commonConfig *configv1.ClientCommonConfig) error {
+	policy := (*tlspolicy.Policy)(obj.Spec.Transport.TLS.Policy)
+	if policy.IsZero() {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(util.EmptyOr(commonConfig.Transport.DialServerTimeout, 10)) * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(commonConfig.ServerAddr, strconv.Itoa(commonConfig.ServerPort)))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrServerUnreachable, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if !lo.FromPtr(commonConfig.Transport.TLS.DisableCustomTLSFirstByte) {
+		if _, err := conn.Write([]byte{byte(netpkg.FRPTLSHeadByte)}); err != nil {
+			return fmt.Errorf("%w: %w", ErrServerUnreachable, err)
+		}
+	}
+	tlsConfig := &tls.Config{
+		ServerName: util.EmptyOr(commonConfig.Transport.TLS.ServerName, commonConfig.ServerAddr),
+		// the login verifies the certificate, only the negotiated parameters are checked here
+		InsecureSkipVerify: true,
+	}
+	policy.Apply(tlsConfig, false)
+	if err := tls.Client(conn, tlsConfig).HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("frp server doesn't negotiate tls within spec.transport.tls.policy, got: %w", err)
+	}
+	return nil
+}
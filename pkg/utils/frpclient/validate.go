@@ -2,20 +2,24 @@ package frpclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	frpclient "github.com/fatedier/frp/client"
-	"github.com/fatedier/frp/pkg/auth"
 	configv1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/fatedier/frp/pkg/config/v1/validation"
 	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/util/util"
 	"github.com/fatedier/frp/pkg/util/version"
 	"github.com/frp-sigs/frp-provisioner/pkg/api/v1beta1"
+	"github.com/frp-sigs/frp-provisioner/pkg/credentials"
+	"github.com/frp-sigs/frp-provisioner/pkg/metrics"
 	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
+	"net"
 	"os"
 	"runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strconv"
 	"time"
 )
 
@@ -27,8 +31,80 @@ func ValidatePort(port int) error {
 	return fmt.Errorf("port number %d must be in the range 0..65535", port)
 }
 
-// ValidateFrpServerConfig validate and check config from v1beta1.FrpServer
-func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta1.FrpServer) error {
+// clockSkewTolerance returns the OIDC clock skew tolerance of v1beta1.FrpServer
+func clockSkewTolerance(obj *v1beta1.FrpServer) time.Duration {
+	if obj.Spec.Auth.OIDC == nil {
+		return 0
+	}
+	return time.Duration(obj.Spec.Auth.OIDC.ClockSkewTolerance) * time.Second
+}
+
+// TransportTLSData returns the transport tls material of v1beta1.FrpServer, the credentials
+// resolved from an external store take precedence over the referenced Secret.
+func TransportTLSData(ctx context.Context, cli client.Client, obj *v1beta1.FrpServer, creds *credentials.Credentials) (map[string][]byte, error) {
+	if creds != nil && len(creds.TLSData) > 0 {
+		return creds.TLSData, nil
+	}
+	if obj.Spec.Transport.TLS.SecretRef == nil {
+		return nil, nil
+	}
+	secretObj := &v1.Secret{}
+	secretObjKey := client.ObjectKey{
+		Name:      obj.Spec.Transport.TLS.SecretRef.Name,
+		Namespace: obj.Spec.Transport.TLS.SecretRef.Namespace,
+	}
+	if err := cli.Get(ctx, secretObjKey, secretObj); err != nil {
+		return nil, fmt.Errorf("unable get secret '%+v', got: '%w'", secretObjKey, err)
+	}
+	return secretObj.Data, nil
+}
+
+// TempFilePrefix prefixes the names of the temp files holding the transport tls material of a login, they
+// are named "{prefix}{cert,key,ca}-{random}" and are pruned by the janitor when a crash leaked them
+const TempFilePrefix = "frp-provisioner-"
+
+// writeTempFile writes data into a new temp file and returns its name
+func writeTempFile(pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp(os.TempDir(), TempFilePrefix+pattern+"-*")
+	if err != nil {
+		return "", fmt.Errorf("unable create temp file, got: '%w'", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if _, err = f.Write(data); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("unable write temp file '%s', got: '%w'", f.Name(), err)
+	}
+	return f.Name(), nil
+}
+
+// ErrServerUnreachable wraps the login failures of a frp server which couldn't be reached, as opposed to the
+// logins the server rejected
+var ErrServerUnreachable = errors.New("frp server unreachable")
+
+// IsServerUnreachable reports whether the login failed for every transport protocol because the frp server
+// couldn't be reached
+func IsServerUnreachable(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return lo.EveryBy(joined.Unwrap(), IsServerUnreachable)
+	}
+	return errors.Is(err, ErrServerUnreachable)
+}
+
+// LoginResult describes the transport which logged in to the frp server successfully
+type LoginResult struct {
+	// Protocol is the transport protocol which logged in
+	Protocol v1beta1.FrpServerTransportProtocol
+	// UDPPacketSize is the udp packet size detected by the path MTU probe, zero when not probed
+	UDPPacketSize int64
+	// ServerVersion is the frps version reported in the login response
+	ServerVersion string
+}
+
+// ClientCommonConfig builds the frp client config of v1beta1.FrpServer without the transport tls files,
+// creds may be nil when the FrpServer does not reference any external credentials.
+func ClientCommonConfig(obj *v1beta1.FrpServer, creds *credentials.Credentials) configv1.ClientCommonConfig {
 	authConfig := configv1.AuthClientConfig{
 		Token:  obj.Spec.Auth.Token,
 		Method: configv1.AuthMethod(obj.Spec.Auth.Method),
@@ -43,11 +119,15 @@ func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta
 			AdditionalEndpointParams: obj.Spec.Auth.OIDC.AdditionalEndpointParams,
 		}
 	}
+	if creds != nil {
+		authConfig.Token = util.EmptyOr(creds.Token, authConfig.Token)
+		authConfig.OIDC.ClientSecret = util.EmptyOr(creds.OIDCClientSecret, authConfig.OIDC.ClientSecret)
+	}
 	for _, scope := range obj.Spec.Auth.AdditionalScopes {
 		authConfig.AdditionalScopes = append(authConfig.AdditionalScopes, configv1.AuthScope(scope))
 	}
 	tlsOptions := configv1.TLSClientConfig{
-		Enable: lo.ToPtr(obj.Spec.Transport.TLS.SecretRef != nil),
+		Enable: lo.ToPtr(false),
 		TLSConfig: configv1.TLSConfig{
 			ServerName: obj.Spec.Transport.TLS.ServerName,
 		},
@@ -55,7 +135,6 @@ func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta
 	}
 	transportConfig := configv1.ClientTransportConfig{
 		TLS:                     tlsOptions,
-		Protocol:                string(obj.Spec.Transport.Protocol),
 		DialServerTimeout:       obj.Spec.Transport.DialServerTimeout,
 		DialServerKeepAlive:     obj.Spec.Transport.DialServerKeepAlive,
 		ConnectServerLocalIP:    obj.Spec.Transport.ConnectServerLocalIP,
@@ -79,110 +158,177 @@ func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta
 		Transport:         transportConfig,
 		ServerAddr:        obj.Spec.ServerAddr,
 		ServerPort:        obj.Spec.ServerPort,
-		NatHoleSTUNServer: obj.Spec.NatHoleSTUNServer,
+		NatHoleSTUNServer: util.EmptyOr(obj.Status.NatHoleSTUNServer, obj.Spec.NatHoleSTUNServer),
 		DNSServer:         obj.Spec.DNSServer,
 		LoginFailExit:     obj.Spec.LoginFailExit,
-		UDPPacketSize:     obj.Spec.UDPPacketSize,
+		UDPPacketSize:     udpPacketSize(obj),
 		Metadatas:         obj.Spec.Metadatas,
 	}
-	if obj.Spec.Transport.TLS.SecretRef != nil {
-		secretObj := &v1.Secret{}
-		secretObjKey := client.ObjectKey{
-			Name:      obj.Spec.Transport.TLS.SecretRef.Name,
-			Namespace: obj.Spec.Transport.TLS.SecretRef.Namespace,
-		}
-		commonConfig.Transport.TLS.Enable = lo.ToPtr(true)
+	return commonConfig
+}
 
-		if err := cli.Get(ctx, secretObjKey, secretObj); err != nil {
-			return fmt.Errorf("unable get secret '%+v', got: '%w'", secretObjKey, err)
-		}
+// udpPacketSize returns spec.udpPacketSize lowered to status.detectedUDPPacketSize when it's smaller, zero
+// keeps the frp default.
+func udpPacketSize(obj *v1beta1.FrpServer) int64 {
+	size, detected := obj.Spec.UDPPacketSize, obj.Status.DetectedUDPPacketSize
+	if detected > 0 && (size == 0 || detected < size) {
+		return detected
+	}
+	return size
+}
 
-		certFile, err := os.CreateTemp(os.TempDir(), "cert")
-		if err != nil {
-			return fmt.Errorf("unable create temp file, got: '%w'", err)
+// CheckFrpServerConfig checks the frp client config of v1beta1.FrpServer for every transport protocol
+// without any side effect, no credentials are resolved, no temp files are written and the server is
+// not contacted. It's used for dry-run requests which must not have side effects.
+func CheckFrpServerConfig(obj *v1beta1.FrpServer) (errs error) {
+	commonConfig := ClientCommonConfig(obj, nil)
+	for _, protocol := range TransportProtocols(obj) {
+		protocolConfig := commonConfig
+		protocolConfig.Transport.Protocol = string(protocol)
+		protocolConfig.Complete()
+		if _, err := validation.ValidateClientCommonConfig(&protocolConfig); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("invalid frp config for protocol '%s', got: %w", protocol, err))
 		}
-		defer func() {
-			_ = certFile.Close()
-			_ = os.Remove(certFile.Name())
-		}()
+	}
+	// The server is not contacted, the features are checked against the version of the last login
+	if err := CheckServerCompatibility(obj, obj.Status.ServerVersion); err != nil {
+		errs = errors.Join(errs, fmt.Errorf("frp config is not supported by the server, got: %w", err))
+	}
+	return errs
+}
 
-		certData, ok := secretObj.Data[v1beta1.DefaultCertFileName]
-		if !ok {
-			return fmt.Errorf("file '%s' not found on secret '%+v', got: %w", v1beta1.DefaultCertFileName, secretObjKey, err)
-		}
+// ValidateFrpServerConfig validate and check config from v1beta1.FrpServer and returns the transport
+// which logged in successfully, creds may be nil when the FrpServer does not reference any external credentials.
+// The login reuses the auth.Setter of the FrpServer cached by setters, a new one is used when setters is nil.
+func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta1.FrpServer, creds *credentials.Credentials, setters *AuthSetters) (*LoginResult, error) {
+	commonConfig := ClientCommonConfig(obj, creds)
+	tlsData, err := TransportTLSData(ctx, cli, obj, creds)
+	if err != nil {
+		return nil, err
+	}
+	if tlsData != nil {
+		commonConfig.Transport.TLS.Enable = lo.ToPtr(true)
 
-		_, err = certFile.Write(certData)
-		if err != nil {
-			return fmt.Errorf("file '%s' has incorrect content on secret '%+v', got: %w", v1beta1.DefaultCertFileName, secretObjKey, err)
+		for _, key := range []string{v1beta1.DefaultCertFileName, v1beta1.DefaultKeyFileName} {
+			if _, ok := tlsData[key]; !ok {
+				return nil, fmt.Errorf("file '%s' not found on transport tls data", key)
+			}
 		}
 
-		commonConfig.Transport.TLS.CertFile = certFile.Name()
-
-		keyFile, err := os.CreateTemp(os.TempDir(), "key")
+		certFile, err := writeTempFile("cert", tlsData[v1beta1.DefaultCertFileName])
 		if err != nil {
-			return fmt.Errorf("unable create temp file, got: '%w'", err)
+			return nil, err
 		}
 		defer func() {
-			_ = keyFile.Close()
-			_ = os.Remove(keyFile.Name())
+			_ = os.Remove(certFile)
 		}()
+		commonConfig.Transport.TLS.CertFile = certFile
 
-		keyData, ok := secretObj.Data[v1beta1.DefaultKeyFileName]
-		if !ok {
-			return fmt.Errorf("file '%s' not found on secret '%+v', got: %w", v1beta1.DefaultKeyFileName, secretObjKey, err)
-		}
-
-		_, err = keyFile.Write(keyData)
+		keyFile, err := writeTempFile("key", tlsData[v1beta1.DefaultKeyFileName])
 		if err != nil {
-			return fmt.Errorf("file '%s' has incorrect content on secret '%+v', got: %w", v1beta1.DefaultCertFileName, secretObjKey, err)
+			return nil, err
 		}
+		defer func() {
+			_ = os.Remove(keyFile)
+		}()
+		commonConfig.Transport.TLS.KeyFile = keyFile
 
-		commonConfig.Transport.TLS.KeyFile = keyFile.Name()
-
-		caData, ok := secretObj.Data[v1beta1.DefaultCaFileName]
-		if ok {
-			caFile, err := os.CreateTemp(os.TempDir(), "ca")
+		if caData, ok := tlsData[v1beta1.DefaultCaFileName]; ok {
+			caData, err := TrustedCABundle(obj, caData)
+			if err != nil {
+				return nil, err
+			}
+			caFile, err := writeTempFile("ca", caData)
 			if err != nil {
-				return fmt.Errorf("unable create temp file, got: '%w'", err)
+				return nil, err
 			}
 			defer func() {
-				_ = caFile.Close()
-				_ = os.Remove(caFile.Name())
+				_ = os.Remove(caFile)
 			}()
+			commonConfig.Transport.TLS.TrustedCaFile = caFile
+		}
+	}
 
-			_, err = caFile.Write(caData)
-			if err != nil {
-				return fmt.Errorf("file '%s' has incorrect content on secret '%+v', got: %w", v1beta1.DefaultCaFileName, secretObjKey, err)
+	// Try the transport protocols in order, the first one which logs in successfully is active
+	var errs error
+	for _, protocol := range TransportProtocols(obj) {
+		protocolConfig := commonConfig
+		protocolConfig.Transport.Protocol = string(protocol)
+		protocolConfig.Complete()
+
+		if _, err := validation.ValidateClientCommonConfig(&protocolConfig); err != nil {
+			return nil, err
+		}
+		result := &LoginResult{Protocol: v1beta1.FrpServerTransportProtocol(protocolConfig.Transport.Protocol)}
+		// Shrink the udp packet size to the path MTU to avoid fragmentation induced stalls, the probe runs in
+		// the background and the size detected before is kept until it completes
+		if needsMTUProbe(protocolConfig.Transport.Protocol) {
+			address := net.JoinHostPort(protocolConfig.ServerAddr, strconv.Itoa(protocolConfig.ServerPort))
+			packetSize, ok, err := CachedUDPPacketSize(address)
+			switch {
+			case err != nil:
+				log.FromContext(ctx).Error(err, "Unable detect udp packet size, keep the configured value")
+			case !ok:
+				result.UDPPacketSize = obj.Status.DetectedUDPPacketSize
+			default:
+				result.UDPPacketSize = packetSize
+				protocolConfig.UDPPacketSize = min(protocolConfig.UDPPacketSize, packetSize)
+			}
+		}
+		if tlsData != nil && protocolConfig.Transport.Protocol == string(v1beta1.FrpServerTransportProtocolTCP) {
+			if err := CheckTLSPolicy(ctx, obj, &protocolConfig); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("unable login frp server with protocol '%s', got: %w", protocol, err))
+				continue
 			}
-			commonConfig.Transport.TLS.TrustedCaFile = caFile.Name()
 		}
+		metrics.LoginAttemptsTotal.WithLabelValues(obj.Name).Inc()
+		serverVersion, err := login(ctx, obj, &protocolConfig, setters)
+		if err != nil {
+			metrics.LoginFailuresTotal.WithLabelValues(obj.Name).Inc()
+			errs = errors.Join(errs, fmt.Errorf("unable login frp server with protocol '%s', got: %w", protocol, err))
+			continue
+		}
+		result.ServerVersion = serverVersion
+		// The server is reachable but it may not support every feature of the config
+		if err := CheckServerCompatibility(obj, serverVersion); err != nil {
+			return result, fmt.Errorf("frp config is not supported by the server, got: %w", err)
+		}
+		return result, nil
 	}
+	return nil, errs
+}
 
-	commonConfig.Complete()
+// TransportProtocols returns the ordered transport protocols of v1beta1.FrpServer, spec.transport.protocol
+// is tried first followed by spec.transport.fallbackProtocols.
+func TransportProtocols(obj *v1beta1.FrpServer) []v1beta1.FrpServerTransportProtocol {
+	protocols := []v1beta1.FrpServerTransportProtocol{obj.Spec.Transport.Protocol}
+	protocols = append(protocols, obj.Spec.Transport.FallbackProtocols...)
+	return lo.Uniq(protocols)
+}
 
-	_, err := validation.ValidateClientCommonConfig(&commonConfig)
-	if err != nil {
-		return err
-	}
+// login logs in to the frp server and warms up a work connection with the completed client config,
+// it returns the frps version reported by the server
+func login(ctx context.Context, obj *v1beta1.FrpServer, commonConfig *configv1.ClientCommonConfig, setters *AuthSetters) (string, error) {
 	var (
 		loginRespMsg msg.LoginResp
 		logger       = log.FromContext(ctx)
-		authSetter   = auth.NewAuthSetter(commonConfig.Auth)
+		authSetter   = setters.Get(obj.Name, commonConfig.Auth, clockSkewTolerance(obj))
 	)
-	connMgr := frpclient.NewConnector(ctx, &commonConfig)
+	connMgr := NewConnector(ctx, obj, commonConfig)
 	defer func() {
 		_ = connMgr.Close()
 	}()
 
 	if err := connMgr.Open(); err != nil {
 		logger.Error(err, "Error open frp connection manager conn")
-		return err
+		return "", fmt.Errorf("%w: %w", ErrServerUnreachable, err)
 	}
 
+	start := time.Now()
 	conn, err := connMgr.Connect()
 	if err != nil {
 		logger.Error(err, "Unable create conn for connection manager")
-		return err
+		return "", fmt.Errorf("%w: %w", ErrServerUnreachable, err)
 	}
 	defer func() {
 		_ = conn.Close()
@@ -191,7 +337,7 @@ func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta
 	hostname, err := os.Hostname()
 	if err != nil {
 		logger.Error(err, "Unable get hostname")
-		return err
+		return "", err
 	}
 
 	loginMsg := &msg.Login{
@@ -206,24 +352,31 @@ func ValidateFrpServerConfig(ctx context.Context, cli client.Client, obj *v1beta
 
 	if err := authSetter.SetLogin(loginMsg); err != nil {
 		logger.Error(err, "Error set login message")
-		return err
+		return "", err
 	}
 
-	if err = msg.WriteMsg(conn, loginMsg); err != nil {
+	// the version reported on the previous login, the fields an older frps doesn't know are stripped
+	if err = WriteMsg(ctx, conn, loginMsg, obj.Status.ServerVersion); err != nil {
 		logger.Error(err, "Error write login message")
-		return err
+		return "", fmt.Errorf("%w: %w", ErrServerUnreachable, err)
 	}
 
 	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	if err = msg.ReadMsgInto(conn, &loginRespMsg); err != nil {
 		logger.Error(err, "Error to read login response")
-		return err
+		return "", fmt.Errorf("%w: %w", ErrServerUnreachable, err)
 	}
 	_ = conn.SetReadDeadline(time.Time{})
 
 	if loginRespMsg.Error != "" {
 		logger.Error(err, "Error to login frp server")
-		return fmt.Errorf(loginRespMsg.Error)
+		return "", fmt.Errorf(loginRespMsg.Error)
+	}
+	metrics.ObserveWithExemplar(ctx, metrics.LoginDurationSeconds.WithLabelValues(obj.Name), time.Since(start).Seconds())
+
+	if err := WarmUpWorkConn(ctx, conn, connMgr, authSetter, commonConfig, loginRespMsg.RunID); err != nil {
+		logger.Error(err, "Error to warm up work connection")
+		return "", fmt.Errorf("unable warm up work connection, got: %w", err)
 	}
-	return nil
+	return loginRespMsg.Version, nil
 }
@@ -0,0 +1,147 @@
+package frpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	frpclient "github.com/fatedier/frp/client"
+	"github.com/fatedier/frp/pkg/auth"
+	configv1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
+	"io"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sync"
+	"time"
+)
+
+const (
+	// workConnRequestTimeout is the maximum time to wait for frps to request the first pooled work connection
+	workConnRequestTimeout = 10 * time.Second
+	// workConnAcceptWindow is the time a freshly registered work connection must stay open to count as accepted,
+	// it also bounds the wait for the remaining pooled work connection requests after the first one
+	workConnAcceptWindow = time.Second
+)
+
+// WarmUpWorkConn waits for the ReqWorkConn messages frps sends after a successful login, one per
+// pooled connection of cfg.Transport.PoolCount, and pre-establishes the requested work connections.
+// It returns an error unless frps accepted at least one of them, so a tunnel is only reported ready
+// once a work connection has been dialed end-to-end. frps may pool fewer connections than requested,
+// the requests arriving within workConnAcceptWindow of the first one are served.
+func WarmUpWorkConn(ctx context.Context, ctlConn net.Conn, connMgr frpclient.Connector,
+	authSetter auth.Setter, cfg *configv1.ClientCommonConfig, runID string) error {
+	if cfg.Transport.PoolCount <= 0 {
+		return nil
+	}
+	ctlRW, err := netpkg.NewCryptoReadWriter(ctlConn, []byte(cfg.Auth.Token))
+	if err != nil {
+		return fmt.Errorf("unable create crypto read writer for control conn, got: %w", err)
+	}
+	defer func() {
+		_ = ctlConn.SetReadDeadline(time.Time{})
+	}()
+	requested := 0
+	_ = ctlConn.SetReadDeadline(time.Now().Add(workConnRequestTimeout))
+	for requested < cfg.Transport.PoolCount {
+		m, err := msg.ReadMsg(ctlRW)
+		var netErr net.Error
+		if requested > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable read ReqWorkConn message from frp server, got: %w", err)
+		}
+		if _, ok := m.(*msg.ReqWorkConn); ok {
+			requested++
+			_ = ctlConn.SetReadDeadline(time.Now().Add(workConnAcceptWindow))
+		}
+	}
+
+	workConns := make([]net.Conn, 0, requested)
+	defer func() {
+		for _, workConn := range workConns {
+			_ = workConn.Close()
+		}
+	}()
+	var errs error
+	for i := 0; i < requested; i++ {
+		workConn, err := dialWorkConn(ctx, connMgr, authSetter, runID)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		workConns = append(workConns, workConn)
+	}
+	// frps closes rejected work connections right away, an accepted one stays
+	// idle in the pool until a user connection arrives. They're verified concurrently
+	// so the warm-up takes a single accept window.
+	verifyErrs := make([]error, len(workConns))
+	var wg sync.WaitGroup
+	for i, workConn := range workConns {
+		wg.Add(1)
+		go func(i int, workConn net.Conn) {
+			defer wg.Done()
+			verifyErrs[i] = verifyWorkConn(workConn)
+		}(i, workConn)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, err := range verifyErrs {
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		return errs
+	}
+	if errs != nil {
+		log.FromContext(ctx).Info("Some pooled work connections failed", "accepted", accepted, "requested", requested, "reason", errs.Error())
+	}
+	return nil
+}
+
+// dialWorkConn dials a work connection and sends the NewWorkConn message of the client
+func dialWorkConn(ctx context.Context, connMgr frpclient.Connector, authSetter auth.Setter, runID string) (net.Conn, error) {
+	logger := log.FromContext(ctx)
+	workConn, err := connMgr.Connect()
+	if err != nil {
+		logger.Error(err, "Unable create work conn for connection manager")
+		return nil, err
+	}
+	newWorkConnMsg := &msg.NewWorkConn{RunID: runID}
+	if err := authSetter.SetNewWorkConn(newWorkConnMsg); err != nil {
+		logger.Error(err, "Error set new work conn message")
+		_ = workConn.Close()
+		return nil, err
+	}
+	if err := msg.WriteMsg(workConn, newWorkConnMsg); err != nil {
+		logger.Error(err, "Error write new work conn message")
+		_ = workConn.Close()
+		return nil, err
+	}
+	return workConn, nil
+}
+
+// verifyWorkConn checks frps kept the work connection open for workConnAcceptWindow
+func verifyWorkConn(workConn net.Conn) error {
+	_ = workConn.SetReadDeadline(time.Now().Add(workConnAcceptWindow))
+	var (
+		startMsg msg.StartWorkConn
+		netErr   net.Error
+	)
+	err := msg.ReadMsgInto(workConn, &startMsg)
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return nil
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("work connection was rejected by frp server")
+	case err != nil:
+		return fmt.Errorf("unable verify work connection, got: %w", err)
+	case startMsg.Error != "":
+		return fmt.Errorf("work connection was rejected by frp server: %s", startMsg.Error)
+	}
+	return nil
+}
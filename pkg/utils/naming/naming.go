@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Aapeli <aapeli.smith@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package naming
+
+import (
+	"fmt"
+	"hash/fnv"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apiserver/pkg/storage/names"
+	"strings"
+)
+
+const (
+	// MaxLength is the limit of a DNS-1123 label, the names generated by the manager never exceed it
+	MaxLength = validation.DNS1123LabelMaxLength
+	// MaxPrefixLength is the limit of a GenerateName prefix, the random suffix of the api server fills the rest
+	MaxPrefixLength = names.MaxGeneratedNameLength
+	// hashLength is the length of the hash suffix of a shortened name
+	hashLength = 8
+)
+
+// Shorten returns the name when it fits in max characters, otherwise the name is truncated and suffixed
+// with a hash of the full name, so two long names sharing a prefix stay distinct and the same name is
+// always shortened the same way. The result of a DNS-1123 label is a DNS-1123 label.
+func Shorten(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	if max <= hashLength+1 {
+		return hash[:max]
+	}
+	// the truncated name must not end with a separator, a DNS-1123 label ends with an alphanumeric character
+	prefix := strings.TrimRight(name[:max-hashLength-1], "-.")
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
+
+// Join joins the parts with "-" into a name of at most MaxLength characters
+func Join(parts ...string) string {
+	return Shorten(strings.Join(parts, "-"), MaxLength)
+}
+
+// Prefix joins the parts into a GenerateName prefix ending with "-", the generated name is at most
+// MaxLength characters
+func Prefix(parts ...string) string {
+	return Shorten(strings.Join(parts, "-"), MaxPrefixLength-1) + "-"
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Aapeli <aapeli.smith@gmail.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package naming
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apiserver/pkg/storage/names"
+	"strings"
+	"testing"
+)
+
+func TestShorten(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		max       int
+		unchanged bool
+	}{
+		{name: "short", input: "web", max: MaxLength, unchanged: true},
+		{name: "one below the limit", input: strings.Repeat("a", MaxLength-1), max: MaxLength, unchanged: true},
+		{name: "at the limit", input: strings.Repeat("a", MaxLength), max: MaxLength, unchanged: true},
+		{name: "one over the limit", input: strings.Repeat("a", MaxLength+1), max: MaxLength},
+		{name: "far over the limit", input: strings.Repeat("a", 253), max: MaxLength},
+		{name: "separator at the cut", input: strings.Repeat("a", MaxLength-hashLength-2) + "--" + strings.Repeat("b", 10), max: MaxLength},
+		{name: "separators only before the cut", input: strings.Repeat("-", MaxLength) + "a", max: MaxLength},
+		{name: "limit shorter than the hash", input: strings.Repeat("a", 20), max: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Shorten(tt.input, tt.max)
+			if tt.unchanged && got != tt.input {
+				t.Fatalf("expected '%s' unchanged, got '%s'", tt.input, got)
+			}
+			if len(got) > tt.max {
+				t.Fatalf("expected at most %d characters, got %d: '%s'", tt.max, len(got), got)
+			}
+			if Shorten(tt.input, tt.max) != got {
+				t.Fatalf("expected '%s' to be shortened the same way every time", tt.input)
+			}
+			if !strings.HasPrefix(tt.input, "-") {
+				if errs := validation.IsDNS1123Label(got); len(errs) != 0 {
+					t.Fatalf("expected a DNS-1123 label, got '%s': %v", got, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestShortenDistinct(t *testing.T) {
+	prefix := strings.Repeat("a", MaxLength)
+	first, second := Shorten(prefix+"-first", MaxLength), Shorten(prefix+"-second", MaxLength)
+	if first == second {
+		t.Fatalf("expected long names sharing a prefix to stay distinct, both got '%s'", first)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	if got := Join("web", "frpc-config"); got != "web-frpc-config" {
+		t.Fatalf("expected 'web-frpc-config', got '%s'", got)
+	}
+	got := Join(strings.Repeat("a", MaxLength), "frpc-config")
+	if len(got) != MaxLength {
+		t.Fatalf("expected %d characters, got %d: '%s'", MaxLength, len(got), got)
+	}
+	if errs := validation.IsDNS1123Label(got); len(errs) != 0 {
+		t.Fatalf("expected a DNS-1123 label, got '%s': %v", got, errs)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	if got := Prefix("web", "port-forward"); got != "web-port-forward-" {
+		t.Fatalf("expected 'web-port-forward-', got '%s'", got)
+	}
+	for _, length := range []int{MaxPrefixLength - 2, MaxPrefixLength - 1, MaxPrefixLength, MaxLength, 253} {
+		prefix := Prefix(strings.Repeat("a", length))
+		if len(prefix) > MaxPrefixLength {
+			t.Fatalf("expected a prefix of at most %d characters for a %d character name, got %d", MaxPrefixLength, length, len(prefix))
+		}
+		name := names.SimpleNameGenerator.GenerateName(prefix)
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			t.Fatalf("expected a DNS-1123 label, got '%s': %v", name, errs)
+		}
+	}
+}